@@ -0,0 +1,2 @@
+-- AlterTable
+ALTER TABLE "attune_tenant_api_token" ADD COLUMN     "last_used_at" TIMESTAMPTZ(6);
@@ -0,0 +1,31 @@
+-- CreateTable
+CREATE TABLE "debian_repository_snapshot" (
+    "id" BIGSERIAL NOT NULL,
+    "repository_id" BIGINT NOT NULL,
+    "name" TEXT NOT NULL,
+    "created_at" TIMESTAMPTZ(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "debian_repository_snapshot_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateTable
+CREATE TABLE "debian_repository_snapshot_package" (
+    "snapshot_id" BIGINT NOT NULL,
+    "package_id" BIGINT NOT NULL,
+    "distribution" TEXT NOT NULL,
+    "component" TEXT NOT NULL,
+
+    CONSTRAINT "debian_repository_snapshot_package_pkey" PRIMARY KEY ("snapshot_id","package_id","distribution","component")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "debian_repository_snapshot_repository_id_name_key" ON "debian_repository_snapshot"("repository_id", "name");
+
+-- AddForeignKey
+ALTER TABLE "debian_repository_snapshot" ADD CONSTRAINT "debian_repository_snapshot_repository_id_fkey" FOREIGN KEY ("repository_id") REFERENCES "debian_repository"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "debian_repository_snapshot_package" ADD CONSTRAINT "debian_repository_snapshot_package_snapshot_id_fkey" FOREIGN KEY ("snapshot_id") REFERENCES "debian_repository_snapshot"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "debian_repository_snapshot_package" ADD CONSTRAINT "debian_repository_snapshot_package_package_id_fkey" FOREIGN KEY ("package_id") REFERENCES "debian_repository_package"("id") ON DELETE RESTRICT ON UPDATE CASCADE;
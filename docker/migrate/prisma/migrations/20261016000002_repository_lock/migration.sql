@@ -0,0 +1,3 @@
+-- AlterTable
+ALTER TABLE "debian_repository" ADD COLUMN     "lock_reason" TEXT,
+ADD COLUMN     "locked_at" TIMESTAMPTZ(6);
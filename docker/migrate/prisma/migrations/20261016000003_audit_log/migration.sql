@@ -0,0 +1,27 @@
+-- CreateTable
+CREATE TABLE "attune_audit_event" (
+    "id" BIGSERIAL NOT NULL,
+    "tenant_id" BIGINT NOT NULL,
+    "token_id" BIGINT,
+    "actor" TEXT NOT NULL,
+    "action" TEXT NOT NULL,
+    "repository" TEXT NOT NULL,
+    "distribution" TEXT,
+    "component" TEXT,
+    "package" TEXT,
+    "version" TEXT,
+    "architecture" TEXT,
+    "sha256sum" TEXT,
+    "created_at" TIMESTAMPTZ(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "attune_audit_event_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE INDEX "attune_audit_event_tenant_id_created_at_idx" ON "attune_audit_event"("tenant_id", "created_at");
+
+-- AddForeignKey
+ALTER TABLE "attune_audit_event" ADD CONSTRAINT "attune_audit_event_tenant_id_fkey" FOREIGN KEY ("tenant_id") REFERENCES "attune_tenant"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "attune_audit_event" ADD CONSTRAINT "attune_audit_event_token_id_fkey" FOREIGN KEY ("token_id") REFERENCES "attune_tenant_api_token"("id") ON DELETE SET NULL ON UPDATE CASCADE;
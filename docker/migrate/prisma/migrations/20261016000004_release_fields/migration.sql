@@ -0,0 +1,2 @@
+-- AlterTable
+ALTER TABLE "debian_repository" ADD COLUMN     "release_fields" JSONB NOT NULL DEFAULT '{}';
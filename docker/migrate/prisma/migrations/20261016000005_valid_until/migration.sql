@@ -0,0 +1,2 @@
+-- AlterTable
+ALTER TABLE "debian_repository" ADD COLUMN     "valid_until_days" INTEGER;
@@ -0,0 +1,25 @@
+-- AlterTable
+ALTER TABLE "debian_repository" ADD COLUMN     "pdiffs" BOOLEAN NOT NULL DEFAULT false;
+
+-- CreateTable
+CREATE TABLE "debian_repository_index_packages_diff" (
+    "id" BIGSERIAL NOT NULL,
+    "index_id" BIGINT NOT NULL,
+    "name" TEXT NOT NULL,
+    "history_size" BIGINT NOT NULL,
+    "history_sha256sum" TEXT NOT NULL,
+    "patch_size" BIGINT NOT NULL,
+    "patch_sha256sum" TEXT NOT NULL,
+    "download_size" BIGINT NOT NULL,
+    "download_sha256sum" TEXT NOT NULL,
+    "contents" BYTEA NOT NULL,
+    "created_at" TIMESTAMPTZ(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "debian_repository_index_packages_diff_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "debian_repository_index_packages_diff_index_id_name_key" ON "debian_repository_index_packages_diff"("index_id", "name");
+
+-- AddForeignKey
+ALTER TABLE "debian_repository_index_packages_diff" ADD CONSTRAINT "debian_repository_index_packages_diff_index_id_fkey" FOREIGN KEY ("index_id") REFERENCES "debian_repository_index_packages"("id") ON DELETE CASCADE ON UPDATE CASCADE;
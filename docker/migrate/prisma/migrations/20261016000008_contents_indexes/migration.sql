@@ -0,0 +1,26 @@
+-- AlterTable
+ALTER TABLE "debian_repository" ADD COLUMN     "contents_indexes" BOOLEAN NOT NULL DEFAULT false;
+
+-- AlterTable
+ALTER TABLE "debian_repository_package" ADD COLUMN     "files" TEXT[] DEFAULT ARRAY[]::TEXT[];
+
+-- CreateTable
+CREATE TABLE "debian_repository_index_contents" (
+    "id" BIGSERIAL NOT NULL,
+    "component_id" BIGINT NOT NULL,
+    "architecture" "debian_repository_architecture" NOT NULL,
+    "size" BIGINT NOT NULL,
+    "contents" BYTEA NOT NULL,
+    "md5sum" TEXT NOT NULL,
+    "sha256sum" TEXT NOT NULL,
+    "created_at" TIMESTAMPTZ(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMPTZ(6) NOT NULL,
+
+    CONSTRAINT "debian_repository_index_contents_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "debian_repository_index_contents_component_id_architecture_key" ON "debian_repository_index_contents"("component_id", "architecture");
+
+-- AddForeignKey
+ALTER TABLE "debian_repository_index_contents" ADD CONSTRAINT "debian_repository_index_contents_component_id_fkey" FOREIGN KEY ("component_id") REFERENCES "debian_repository_component"("id") ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1,41 @@
+-- AlterTable
+ALTER TABLE "debian_repository" ADD COLUMN     "translations" BOOLEAN NOT NULL DEFAULT false;
+
+-- CreateTable
+CREATE TABLE "debian_repository_package_translation" (
+    "id" BIGSERIAL NOT NULL,
+    "package_id" BIGINT NOT NULL,
+    "language" TEXT NOT NULL,
+    "description" TEXT NOT NULL,
+    "created_at" TIMESTAMPTZ(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMPTZ(6) NOT NULL,
+
+    CONSTRAINT "debian_repository_package_translation_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateTable
+CREATE TABLE "debian_repository_index_translation" (
+    "id" BIGSERIAL NOT NULL,
+    "component_id" BIGINT NOT NULL,
+    "language" TEXT NOT NULL,
+    "size" BIGINT NOT NULL,
+    "contents" BYTEA NOT NULL,
+    "md5sum" TEXT NOT NULL,
+    "sha256sum" TEXT NOT NULL,
+    "created_at" TIMESTAMPTZ(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMPTZ(6) NOT NULL,
+
+    CONSTRAINT "debian_repository_index_translation_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "debian_repository_package_translation_package_id_language_key" ON "debian_repository_package_translation"("package_id", "language");
+
+-- CreateIndex
+CREATE UNIQUE INDEX "debian_repository_index_translation_component_id_language_key" ON "debian_repository_index_translation"("component_id", "language");
+
+-- AddForeignKey
+ALTER TABLE "debian_repository_package_translation" ADD CONSTRAINT "debian_repository_package_translation_package_id_fkey" FOREIGN KEY ("package_id") REFERENCES "debian_repository_package"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "debian_repository_index_translation" ADD CONSTRAINT "debian_repository_index_translation_component_id_fkey" FOREIGN KEY ("component_id") REFERENCES "debian_repository_component"("id") ON DELETE CASCADE ON UPDATE CASCADE;
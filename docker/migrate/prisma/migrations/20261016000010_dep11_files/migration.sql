@@ -0,0 +1,24 @@
+-- CreateTable
+CREATE TABLE "debian_repository_dep11_file" (
+    "id" BIGSERIAL NOT NULL,
+    "release_id" BIGINT NOT NULL,
+    "component" TEXT NOT NULL,
+    "name" TEXT NOT NULL,
+    "size" BIGINT NOT NULL,
+    "contents" BYTEA NOT NULL,
+    "md5sum" TEXT NOT NULL,
+    "sha256sum" TEXT NOT NULL,
+    "published_md5sum" TEXT,
+    "published_sha256sum" TEXT,
+    "removed" BOOLEAN NOT NULL DEFAULT false,
+    "created_at" TIMESTAMPTZ(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMPTZ(6) NOT NULL,
+
+    CONSTRAINT "debian_repository_dep11_file_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "debian_repository_dep11_file_release_id_component_name_key" ON "debian_repository_dep11_file"("release_id", "component", "name");
+
+-- AddForeignKey
+ALTER TABLE "debian_repository_dep11_file" ADD CONSTRAINT "debian_repository_dep11_file_release_id_fkey" FOREIGN KEY ("release_id") REFERENCES "debian_repository_release"("id") ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1,30 @@
+-- CreateTable
+CREATE TABLE "debian_repository_package_upload" (
+    "id" TEXT NOT NULL,
+    "tenant_id" BIGINT NOT NULL,
+    "size" BIGINT NOT NULL,
+    "sha256sum" TEXT NOT NULL,
+    "part_size" BIGINT NOT NULL,
+    "created_at" TIMESTAMPTZ(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMPTZ(6) NOT NULL,
+
+    CONSTRAINT "debian_repository_package_upload_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateTable
+CREATE TABLE "debian_repository_package_upload_part" (
+    "upload_id" TEXT NOT NULL,
+    "part_number" INTEGER NOT NULL,
+    "size" BIGINT NOT NULL,
+    "sha256sum" TEXT NOT NULL,
+    "created_at" TIMESTAMPTZ(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMPTZ(6) NOT NULL,
+
+    CONSTRAINT "debian_repository_package_upload_part_pkey" PRIMARY KEY ("upload_id","part_number")
+);
+
+-- AddForeignKey
+ALTER TABLE "debian_repository_package_upload" ADD CONSTRAINT "debian_repository_package_upload_tenant_id_fkey" FOREIGN KEY ("tenant_id") REFERENCES "attune_tenant"("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "debian_repository_package_upload_part" ADD CONSTRAINT "debian_repository_package_upload_part_upload_id_fkey" FOREIGN KEY ("upload_id") REFERENCES "debian_repository_package_upload"("id") ON DELETE CASCADE ON UPDATE CASCADE;
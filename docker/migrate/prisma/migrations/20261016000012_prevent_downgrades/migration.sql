@@ -0,0 +1,2 @@
+-- AlterTable
+ALTER TABLE "debian_repository" ADD COLUMN     "prevent_downgrades" BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+-- AlterTable
+ALTER TABLE "debian_repository_package" ADD COLUMN     "uploaded_by" TEXT;
@@ -0,0 +1,3 @@
+-- AlterTable
+ALTER TABLE "debian_repository" ADD COLUMN     "retain_days" INTEGER,
+ADD COLUMN     "retain_versions" INTEGER;
@@ -0,0 +1,2 @@
+-- CreateIndex
+CREATE INDEX "debian_repository_package_files_idx" ON "debian_repository_package" USING GIN ("files");
@@ -0,0 +1,11 @@
+-- AlterTable
+ALTER TABLE "debian_repository_package" ADD COLUMN     "udeb" BOOLEAN NOT NULL DEFAULT false;
+
+-- AlterTable
+ALTER TABLE "debian_repository_index_packages" ADD COLUMN     "debian_installer" BOOLEAN NOT NULL DEFAULT false;
+
+-- DropIndex
+DROP INDEX "debian_repository_index_packages_component_id_architecture_key";
+
+-- CreateIndex
+CREATE UNIQUE INDEX "debian_repository_index_packages_component_id_architecture__key" ON "debian_repository_index_packages"("component_id", "architecture", "debian_installer");
@@ -0,0 +1,3 @@
+-- AlterTable
+ALTER TABLE "debian_repository_package" ADD COLUMN     "upstream_key_fingerprint" TEXT,
+ADD COLUMN     "upstream_signature" TEXT;
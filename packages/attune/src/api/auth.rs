@@ -2,15 +2,27 @@
 
 use axum::{
     extract::{FromRef, FromRequestParts},
-    http::request,
+    http::{StatusCode, request},
 };
 use sha2::{Digest as _, Sha256};
 use sqlx::PgPool;
 
+use crate::api::ErrorResponse;
+
 /// An extractor for tenants authenticated via API token.
 #[derive(Debug, Clone, Copy)]
 pub struct TenantID(pub i64);
 
+/// An extractor for the API token that authenticated a request, for handlers
+/// that record who made a change.
+#[derive(Debug, Clone)]
+pub struct Actor {
+    pub tenant_id: TenantID,
+    pub token_id: i64,
+    /// The token's name at the time of the request.
+    pub token_name: String,
+}
+
 fn parse_api_token(header: &axum::http::header::HeaderMap) -> Result<&str, &'static str> {
     let header = header
         .get("Authorization")
@@ -29,35 +41,74 @@ where
     PgPool: FromRef<S>,
     S: Send + Sync,
 {
-    type Rejection = (axum::http::StatusCode, &'static str);
+    type Rejection = ErrorResponse;
 
     async fn from_request_parts(
         parts: &mut request::Parts,
         state: &S,
     ) -> Result<Self, Self::Rejection> {
-        let token = parse_api_token(&parts.headers)
-            .map_err(|msg| (axum::http::StatusCode::UNAUTHORIZED, msg))?;
+        Actor::from_request_parts(parts, state)
+            .await
+            .map(|actor| actor.tenant_id)
+    }
+}
+
+impl<S> FromRequestParts<S> for Actor
+where
+    PgPool: FromRef<S>,
+    S: Send + Sync,
+{
+    type Rejection = ErrorResponse;
+
+    async fn from_request_parts(
+        parts: &mut request::Parts,
+        state: &S,
+    ) -> Result<Self, Self::Rejection> {
+        let token = parse_api_token(&parts.headers).map_err(|msg| {
+            ErrorResponse::new(StatusCode::UNAUTHORIZED, "INVALID_API_TOKEN", msg)
+        })?;
         let db = PgPool::from_ref(state);
-        let tenant_id = sqlx::query!(
+        // Look up the token, and record that it was used. To avoid a write on
+        // every request, `last_used_at` is only updated once it's more than a
+        // minute old.
+        let token = sqlx::query!(
             r#"
-            SELECT attune_tenant.id
-            FROM attune_tenant
-                JOIN attune_tenant_api_token ON attune_tenant_api_token.tenant_id = attune_tenant.id
-            WHERE attune_tenant_api_token.token = $1;
+            WITH token AS (
+                SELECT id, tenant_id, name, last_used_at
+                FROM attune_tenant_api_token
+                WHERE token = $1
+            ), touched AS (
+                UPDATE attune_tenant_api_token
+                SET last_used_at = NOW()
+                FROM token
+                WHERE attune_tenant_api_token.id = token.id
+                    AND (token.last_used_at IS NULL OR token.last_used_at < NOW() - INTERVAL '1 minute')
+            )
+            SELECT id AS "id!", tenant_id AS "tenant_id!", name AS "name!"
+            FROM token;
             "#,
             Sha256::digest(token).as_slice().to_vec(),
         )
         .fetch_optional(&db)
         .await
         .map_err(|_err| {
-            (
-                axum::http::StatusCode::INTERNAL_SERVER_ERROR,
+            ErrorResponse::new(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "HTTP_SERVER_ERROR_GENERIC",
                 "Could not validate API token",
             )
         })?;
-        match tenant_id {
-            Some(tenant_id) => Ok(TenantID(tenant_id.id)),
-            None => Err((axum::http::StatusCode::UNAUTHORIZED, "Invalid API token\n")),
+        match token {
+            Some(token) => Ok(Actor {
+                tenant_id: TenantID(token.tenant_id),
+                token_id: token.id,
+                token_name: token.name,
+            }),
+            None => Err(ErrorResponse::new(
+                StatusCode::UNAUTHORIZED,
+                "INVALID_API_TOKEN",
+                "Invalid API token",
+            )),
         }
     }
 }
@@ -4,15 +4,17 @@ use axum::{
     response::{IntoResponse, Response},
 };
 use bon::Builder;
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 use thiserror::Error;
 use tracing::error;
 
-#[derive(Serialize, Deserialize, Builder, Debug, Error)]
+#[derive(Serialize, Deserialize, JsonSchema, Builder, Debug, Error)]
 #[error("{error} ({status}): {message}")]
 pub struct ErrorResponse {
     /// The HTTP status code.
     #[serde(with = "http_serde::status_code")]
+    #[schemars(with = "u16")]
     pub status: StatusCode,
     /// A short, unique error code.
     #[builder(into)]
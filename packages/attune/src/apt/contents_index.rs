@@ -0,0 +1,290 @@
+use std::collections::{BTreeMap, BTreeSet};
+
+use itertools::Itertools as _;
+use md5::Md5;
+use sha2::{Digest as _, Sha256};
+use sqlx::{FromRow, Postgres, Transaction};
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::{ReleaseEntry, gzip},
+};
+
+/// A package's name, section, and installed files, which is all that a
+/// Contents index lists about it.
+#[derive(Clone, Debug, FromRow)]
+pub struct ContentsPackage {
+    pub name: String,
+    pub section: Option<String>,
+    pub sha256sum: String,
+    /// The paths of the files that the package installs, relative to `/`.
+    pub files: Vec<String>,
+}
+
+impl ContentsPackage {
+    /// Load the packages published in a component for an architecture. udebs
+    /// aren't listed in Contents indexes.
+    pub async fn query_from_packages_index<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        repository: &str,
+        release: &str,
+        component: &str,
+        architecture: &str,
+    ) -> Result<Vec<Self>, ErrorResponse> {
+        sqlx::query_as!(Self, r#"
+            SELECT
+                debian_repository_package.package AS name,
+                debian_repository_package.section,
+                debian_repository_package.sha256sum,
+                debian_repository_package.files AS "files!"
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_component ON debian_repository_component.release_id = debian_repository_release.id
+                JOIN debian_repository_component_package ON debian_repository_component_package.component_id = debian_repository_component.id
+                JOIN debian_repository_package ON debian_repository_package.id = debian_repository_component_package.package_id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+                AND debian_repository_component.name = $4
+                AND debian_repository_package.architecture = $5::debian_repository_architecture
+                AND NOT debian_repository_package.udeb
+            "#,
+            tenant_id.0,
+            repository,
+            release,
+            component,
+            architecture as _,
+        )
+        .fetch_all(&mut **tx)
+        .await
+        .map_err(Into::into)
+    }
+
+    pub async fn query_from_sha256sum<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        sha256sum: &str,
+    ) -> Result<Option<Self>, ErrorResponse> {
+        sqlx::query_as!(
+            Self,
+            r#"
+                SELECT
+                    package AS name,
+                    section,
+                    sha256sum,
+                    files AS "files!"
+                FROM debian_repository_package
+                WHERE
+                    tenant_id = $1
+                    AND sha256sum = $2
+            "#,
+            tenant_id.0,
+            sha256sum,
+        )
+        .fetch_optional(&mut **tx)
+        .await
+        .map_err(Into::into)
+    }
+}
+
+#[derive(Clone, Debug, FromRow)]
+pub struct ContentsIndexMeta {
+    pub component: String,
+    pub architecture: String,
+
+    /// The size and hashes are of the gzipped index.
+    pub size: i64,
+
+    pub md5sum: String,
+    pub sha256sum: String,
+}
+
+impl ContentsIndexMeta {
+    pub async fn query_from_release<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        repository: &str,
+        release: &str,
+    ) -> Result<Vec<Self>, ErrorResponse> {
+        sqlx::query_as!(Self, r#"
+            SELECT
+                debian_repository_component.name AS component,
+                debian_repository_index_contents.architecture::TEXT AS "architecture!: String",
+                debian_repository_index_contents.size,
+                debian_repository_index_contents.md5sum,
+                debian_repository_index_contents.sha256sum
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_component ON debian_repository_component.release_id = debian_repository_release.id
+                JOIN debian_repository_index_contents ON debian_repository_index_contents.component_id = debian_repository_component.id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+            ORDER BY debian_repository_component.name, debian_repository_index_contents.architecture
+            "#,
+            tenant_id.0,
+            repository,
+            release,
+        )
+        .fetch_all(&mut **tx)
+        .await
+        .map_err(Into::into)
+    }
+
+    /// The index's path, relative to its distribution's Release file.
+    pub fn path(&self) -> String {
+        format!("{}/Contents-{}.gz", self.component, self.architecture)
+    }
+
+    /// The index's entry in its distribution's Release file.
+    pub fn release_entry(&self) -> ReleaseEntry {
+        ReleaseEntry {
+            path: self.path(),
+            size: self.size,
+            md5sum: self.md5sum.clone(),
+            sha256sum: self.sha256sum.clone(),
+        }
+    }
+}
+
+/// A gzipped `Contents-<arch>` index, which maps each file installed by the
+/// packages of a component and architecture to the packages that install it.
+///
+/// See https://wiki.debian.org/DebianRepository/Format#A.22Contents.22_indices.
+#[derive(Clone, Debug)]
+pub struct ContentsIndex {
+    pub meta: ContentsIndexMeta,
+    pub contents: Vec<u8>,
+}
+
+impl ContentsIndex {
+    pub fn from_packages(component: &str, architecture: &str, packages: &[ContentsPackage]) -> Self {
+        let contents = gzip(Self::render(packages).as_bytes());
+        Self {
+            meta: ContentsIndexMeta {
+                component: component.to_string(),
+                architecture: architecture.to_string(),
+                size: contents.len() as i64,
+                md5sum: hex::encode(Md5::digest(&contents)),
+                sha256sum: hex::encode(Sha256::digest(&contents)),
+            },
+            contents,
+        }
+    }
+
+    /// Load the published Contents indexes of a distribution, with their
+    /// contents.
+    pub async fn query_from_release<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        repository: &str,
+        release: &str,
+    ) -> Result<Vec<Self>, ErrorResponse> {
+        let rows = sqlx::query!(r#"
+            SELECT
+                debian_repository_component.name AS component,
+                debian_repository_index_contents.architecture::TEXT AS "architecture!: String",
+                debian_repository_index_contents.size,
+                debian_repository_index_contents.md5sum,
+                debian_repository_index_contents.sha256sum,
+                debian_repository_index_contents.contents
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_component ON debian_repository_component.release_id = debian_repository_release.id
+                JOIN debian_repository_index_contents ON debian_repository_index_contents.component_id = debian_repository_component.id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+            "#,
+            tenant_id.0,
+            repository,
+            release,
+        )
+        .fetch_all(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+        Ok(rows
+            .into_iter()
+            .map(|row| Self {
+                meta: ContentsIndexMeta {
+                    component: row.component,
+                    architecture: row.architecture,
+                    size: row.size,
+                    md5sum: row.md5sum,
+                    sha256sum: row.sha256sum,
+                },
+                contents: row.contents,
+            })
+            .collect())
+    }
+
+    /// Render the uncompressed index. Files are sorted by path, and each is
+    /// followed by the comma-separated `section/package` of every package that
+    /// installs it.
+    fn render(packages: &[ContentsPackage]) -> String {
+        let mut locations = BTreeMap::<&str, BTreeSet<String>>::new();
+        for package in packages {
+            let location = match &package.section {
+                Some(section) => format!("{section}/{}", package.name),
+                None => package.name.clone(),
+            };
+            for file in &package.files {
+                locations
+                    .entry(file.as_str())
+                    .or_default()
+                    .insert(location.clone());
+            }
+        }
+        locations
+            .into_iter()
+            .map(|(file, locations)| format!("{file} {}\n", locations.iter().join(",")))
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn renders_contents_index() {
+        let packages = [
+            ContentsPackage {
+                name: String::from("foo"),
+                section: Some(String::from("utils")),
+                sha256sum: String::from("foosha256sum"),
+                files: vec![
+                    String::from("usr/bin/foo"),
+                    String::from("usr/share/doc/shared/README"),
+                ],
+            },
+            ContentsPackage {
+                name: String::from("bar"),
+                section: None,
+                sha256sum: String::from("barsha256sum"),
+                files: vec![
+                    String::from("usr/share/doc/shared/README"),
+                    String::from("usr/bin/bar"),
+                ],
+            },
+        ];
+        assert_eq!(
+            ContentsIndex::render(&packages),
+            "usr/bin/bar bar\nusr/bin/foo utils/foo\nusr/share/doc/shared/README bar,utils/foo\n"
+        );
+
+        // The index is compressed deterministically, so that index generation
+        // can be replayed.
+        assert_eq!(
+            ContentsIndex::from_packages("main", "amd64", &packages).meta.sha256sum,
+            ContentsIndex::from_packages("main", "amd64", &packages).meta.sha256sum
+        );
+    }
+}
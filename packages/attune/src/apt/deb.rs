@@ -0,0 +1,188 @@
+//! Reading the `ar` archives that Debian packages are stored in.
+
+use serde::Serialize;
+
+/// The compressions of a package's control and data archives that Attune can
+/// read. These are the ones that `dpkg-deb` builds packages with, including
+/// the zstd that recent Ubuntu releases use.
+pub const SUPPORTED_COMPRESSIONS: [&str; 4] = ["none", "gzip", "xz", "zstd"];
+
+/// A member of a Debian package's `ar` archive, like `control.tar.xz`.
+#[derive(Serialize, Debug, Clone, PartialEq, Eq)]
+pub struct PackageMember {
+    pub name: String,
+    pub size: u64,
+    /// How the member is compressed, like `xz` or `none`, if it's a tar
+    /// archive.
+    pub compression: Option<String>,
+}
+
+/// Read the names and sizes of the members of a Debian package's `ar`
+/// archive, without decompressing them.
+pub fn read_package_members(content: &[u8]) -> Result<Vec<PackageMember>, String> {
+    let members = ar_members(content)?
+        .into_iter()
+        .map(|(name, data)| PackageMember {
+            compression: member_compression(&name),
+            name,
+            size: data.len() as u64,
+        })
+        .collect();
+    Ok(members)
+}
+
+/// Read a package's `debsig` origin signature, which is stored as its
+/// `_gpgorigin` member, if it has one.
+pub fn read_origin_signature(content: &[u8]) -> Result<Option<Vec<u8>>, String> {
+    let signature = ar_members(content)?
+        .into_iter()
+        .find(|(name, _)| name == "_gpgorigin")
+        .map(|(_, data)| data.to_vec());
+    Ok(signature)
+}
+
+/// The data that a package's `debsig` origin signature is made over: its
+/// `debian-binary`, control, and data members, concatenated in order.
+pub fn origin_signed_content(content: &[u8]) -> Result<Vec<u8>, String> {
+    let members = ar_members(content)?;
+    let mut signed = Vec::new();
+    for prefix in ["debian-binary", "control.tar", "data.tar"] {
+        let (_, data) = members
+            .iter()
+            .find(|(name, _)| name.starts_with(prefix))
+            .ok_or_else(|| format!("package has no {prefix} member"))?;
+        signed.extend_from_slice(data);
+    }
+    Ok(signed)
+}
+
+/// Split an `ar` archive into the names and contents of its members.
+fn ar_members(content: &[u8]) -> Result<Vec<(String, &[u8])>, String> {
+    let Some(mut rest) = content.strip_prefix(b"!<arch>\n") else {
+        return Err(String::from("not an ar archive"));
+    };
+    let mut members = Vec::new();
+    while !rest.is_empty() {
+        // Each member has a 60-byte header: a 16-byte name, then timestamps,
+        // owner, and mode, then a 10-byte decimal size at offset 48.
+        if rest.len() < 60 {
+            return Err(String::from("ar member header was cut off"));
+        }
+        let (header, data) = rest.split_at(60);
+        let name = String::from_utf8_lossy(&header[..16])
+            .trim_end()
+            .trim_end_matches('/')
+            .to_string();
+        let size = std::str::from_utf8(&header[48..58])
+            .ok()
+            .and_then(|size| size.trim().parse::<usize>().ok())
+            .ok_or_else(|| format!("invalid size for ar member {name:?}"))?;
+        if data.len() < size {
+            return Err(format!("ar member {name:?} was cut off"));
+        }
+        // Members are padded to an even number of bytes.
+        rest = &data[(size + size % 2).min(data.len())..];
+        members.push((name, &data[..size]));
+    }
+    Ok(members)
+}
+
+/// Check that Attune can decompress each of a package's tar archives, so that
+/// a package built with another compression is refused with a clear error
+/// instead of failing to be read.
+pub fn validate_member_compression(members: &[PackageMember]) -> Result<(), String> {
+    for member in members {
+        let Some(compression) = &member.compression else {
+            continue;
+        };
+        if !SUPPORTED_COMPRESSIONS.contains(&compression.as_str()) {
+            return Err(format!(
+                "{} is compressed with {compression}, which is not supported (expected one of: {})",
+                member.name,
+                SUPPORTED_COMPRESSIONS.join(", ")
+            ));
+        }
+    }
+    Ok(())
+}
+
+/// How an archive member is compressed, from its file extension.
+fn member_compression(name: &str) -> Option<String> {
+    let (_, extension) = name.split_once(".tar")?;
+    let compression = match extension.trim_start_matches('.') {
+        "" => "none",
+        "gz" => "gzip",
+        "xz" => "xz",
+        "zst" => "zstd",
+        "bz2" => "bzip2",
+        other => other,
+    };
+    Some(compression.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use crate::testing::{TEST_PACKAGE_AMD64, build_test_package, with_origin_signature};
+
+    use super::*;
+
+    #[test]
+    fn read_archive_members() {
+        let members =
+            read_package_members(TEST_PACKAGE_AMD64).expect("package should have members");
+        let names = members
+            .iter()
+            .map(|member| member.name.as_str())
+            .collect::<Vec<_>>();
+        assert_eq!(names[0], "debian-binary");
+        assert!(names[1].starts_with("control.tar"));
+        assert!(names[2].starts_with("data.tar"));
+        assert_eq!(members[0].compression, None);
+        assert!(members[2].compression.is_some());
+
+        assert_eq!(member_compression("data.tar.zst").as_deref(), Some("zstd"));
+        assert_eq!(member_compression("control.tar").as_deref(), Some("none"));
+        assert!(read_package_members(b"not a package").is_err());
+    }
+
+    #[test]
+    fn validate_compression() {
+        let members = read_package_members(&build_test_package("zst")).unwrap();
+        assert_eq!(
+            members
+                .iter()
+                .map(|member| member.compression.as_deref())
+                .collect::<Vec<_>>(),
+            [None, Some("zstd"), Some("zstd")]
+        );
+        assert_eq!(validate_member_compression(&members), Ok(()));
+        let members = read_package_members(TEST_PACKAGE_AMD64).unwrap();
+        assert_eq!(validate_member_compression(&members), Ok(()));
+
+        let members = read_package_members(&build_test_package("bz2")).unwrap();
+        let error = validate_member_compression(&members).unwrap_err();
+        assert_eq!(
+            error,
+            "control.tar.bz2 is compressed with bzip2, which is not supported (expected one of: none, gzip, xz, zstd)"
+        );
+    }
+
+    #[test]
+    fn read_debsig_origin_signature() {
+        assert_eq!(read_origin_signature(TEST_PACKAGE_AMD64), Ok(None));
+
+        let package = with_origin_signature(TEST_PACKAGE_AMD64, b"signature");
+        assert_eq!(
+            read_origin_signature(&package),
+            Ok(Some(b"signature".to_vec()))
+        );
+        // The signature itself isn't part of the signed content, so adding it
+        // doesn't change what it's made over.
+        let signed = origin_signed_content(&package).unwrap();
+        assert_eq!(signed, origin_signed_content(TEST_PACKAGE_AMD64).unwrap());
+        assert!(signed.starts_with(b"2.0\n"));
+        let members = read_package_members(TEST_PACKAGE_AMD64).unwrap();
+        let size = members.iter().map(|member| member.size).sum::<u64>();
+        assert_eq!(signed.len() as u64, size);
+    }
+}
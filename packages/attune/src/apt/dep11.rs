@@ -0,0 +1,208 @@
+use lazy_regex::lazy_regex;
+use sqlx::{FromRow, Postgres, Transaction};
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::ReleaseEntry,
+};
+
+/// An uploaded DEP-11 (AppStream) metadata file, like a component's
+/// `Components-<arch>.yml.gz` or `icons-<size>.tar.gz`.
+///
+/// Unlike other indexes, these files are generated outside of Attune (usually
+/// by `appstream-generator`) and uploaded as-is. They're published in the
+/// component's `dep11/` directory the next time the distribution's Release
+/// file is signed.
+///
+/// See https://wiki.debian.org/AppStream/Guidelines and
+/// https://wiki.debian.org/DebianRepository/Format#DEP-11_metadata.
+#[derive(Clone, Debug, FromRow)]
+pub struct Dep11FileMeta {
+    pub id: i64,
+    pub component: String,
+    pub name: String,
+
+    pub size: i64,
+
+    pub md5sum: String,
+    pub sha256sum: String,
+
+    /// The hashes of the version of the file that the published Release file
+    /// lists, if it has been published.
+    pub published_md5sum: Option<String>,
+    pub published_sha256sum: Option<String>,
+    /// Whether the file was deleted after it was published. Removed files are
+    /// kept until the next Release file no longer lists them, so that their
+    /// objects can be cleaned up.
+    pub removed: bool,
+}
+
+impl Dep11FileMeta {
+    pub async fn query_from_release<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        repository: &str,
+        release: &str,
+    ) -> Result<Vec<Self>, ErrorResponse> {
+        sqlx::query_as!(Self, r#"
+            SELECT
+                debian_repository_dep11_file.id,
+                debian_repository_dep11_file.component,
+                debian_repository_dep11_file.name,
+                debian_repository_dep11_file.size,
+                debian_repository_dep11_file.md5sum,
+                debian_repository_dep11_file.sha256sum,
+                debian_repository_dep11_file.published_md5sum,
+                debian_repository_dep11_file.published_sha256sum,
+                debian_repository_dep11_file.removed
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_dep11_file ON debian_repository_dep11_file.release_id = debian_repository_release.id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+            ORDER BY debian_repository_dep11_file.component, debian_repository_dep11_file.name
+            "#,
+            tenant_id.0,
+            repository,
+            release,
+        )
+        .fetch_all(&mut **tx)
+        .await
+        .map_err(Into::into)
+    }
+
+    /// Whether the published Release file lists the current version of the
+    /// file.
+    pub fn is_published(&self) -> bool {
+        !self.removed && self.published_sha256sum.as_ref() == Some(&self.sha256sum)
+    }
+
+    /// The file's path, relative to its distribution's Release file.
+    pub fn path(&self) -> String {
+        format!("{}/dep11/{}", self.component, self.name)
+    }
+
+    /// The file's entry in its distribution's Release file.
+    pub fn release_entry(&self) -> ReleaseEntry {
+        ReleaseEntry {
+            path: self.path(),
+            size: self.size,
+            md5sum: self.md5sum.clone(),
+            sha256sum: self.sha256sum.clone(),
+        }
+    }
+}
+
+/// An uploaded DEP-11 file, with its contents.
+#[derive(Clone, Debug)]
+pub struct Dep11File {
+    pub meta: Dep11FileMeta,
+    pub contents: Vec<u8>,
+}
+
+impl Dep11File {
+    /// Load the DEP-11 files of a distribution that haven't been removed, with
+    /// their contents. If `published` is set, only files whose current version
+    /// is published are loaded; otherwise, only files whose current version
+    /// isn't.
+    pub async fn query_from_release<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        repository: &str,
+        release: &str,
+        published: bool,
+    ) -> Result<Vec<Self>, ErrorResponse> {
+        let rows = sqlx::query!(r#"
+            SELECT
+                debian_repository_dep11_file.id,
+                debian_repository_dep11_file.component,
+                debian_repository_dep11_file.name,
+                debian_repository_dep11_file.size,
+                debian_repository_dep11_file.md5sum,
+                debian_repository_dep11_file.sha256sum,
+                debian_repository_dep11_file.published_md5sum,
+                debian_repository_dep11_file.published_sha256sum,
+                debian_repository_dep11_file.removed,
+                debian_repository_dep11_file.contents
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_dep11_file ON debian_repository_dep11_file.release_id = debian_repository_release.id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+                AND NOT debian_repository_dep11_file.removed
+                AND (debian_repository_dep11_file.published_sha256sum IS NOT DISTINCT FROM debian_repository_dep11_file.sha256sum) = $4
+            ORDER BY debian_repository_dep11_file.component, debian_repository_dep11_file.name
+            "#,
+            tenant_id.0,
+            repository,
+            release,
+            published,
+        )
+        .fetch_all(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+        Ok(rows
+            .into_iter()
+            .map(|row| Self {
+                meta: Dep11FileMeta {
+                    id: row.id,
+                    component: row.component,
+                    name: row.name,
+                    size: row.size,
+                    md5sum: row.md5sum,
+                    sha256sum: row.sha256sum,
+                    published_md5sum: row.published_md5sum,
+                    published_sha256sum: row.published_sha256sum,
+                    removed: row.removed,
+                },
+                contents: row.contents,
+            })
+            .collect())
+    }
+}
+
+/// Check that a file name is one that DEP-11 clients look for in a component's
+/// `dep11/` directory: `Components-<arch>.yml.gz`, `CID-Index-<arch>.json.gz`,
+/// or `icons-<size>.tar.gz` (optionally with a `@2` scale suffix).
+pub fn validate_dep11_file_name(name: &str) -> Result<(), String> {
+    let valid = lazy_regex!(
+        r"^(Components-[a-z0-9-]+\.yml\.gz|CID-Index-[a-z0-9-]+\.json\.gz|icons-[0-9]+x[0-9]+(@[0-9])?\.tar\.gz)$"
+    );
+    if !valid.is_match(name) {
+        return Err(format!(
+            "invalid DEP-11 file name {name:?}: expected a name like \"Components-amd64.yml.gz\" or \"icons-64x64.tar.gz\""
+        ));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn validates_dep11_file_names() {
+        for name in [
+            "Components-amd64.yml.gz",
+            "CID-Index-arm64.json.gz",
+            "icons-64x64.tar.gz",
+            "icons-64x64@2.tar.gz",
+        ] {
+            assert!(validate_dep11_file_name(name).is_ok(), "{name} should be valid");
+        }
+        for name in [
+            "Components-amd64.yml",
+            "../Release",
+            "icons-large.tar.gz",
+            "Packages",
+        ] {
+            assert!(validate_dep11_file_name(name).is_err(), "{name} should be invalid");
+        }
+    }
+}
@@ -1,7 +1,42 @@
+use std::io::Write as _;
+
+use flate2::{Compression, GzBuilder};
+
+mod contents_index;
+mod deb;
+mod dep11;
 mod package;
 mod packages_index;
+mod pdiff;
 mod release;
+mod translation_index;
+
+pub use contents_index::{ContentsIndex, ContentsIndexMeta, ContentsPackage};
+pub use deb::{
+    PackageMember, SUPPORTED_COMPRESSIONS, origin_signed_content, read_origin_signature,
+    read_package_members, validate_member_compression,
+};
+pub use dep11::{Dep11File, Dep11FileMeta, validate_dep11_file_name};
+pub use package::{
+    DEBIAN_INSTALLER, Package, PackageByMeta, PublishedPackage, PublishedPackageByMeta,
+    index_component, split_index_component,
+};
+pub use packages_index::{FlatPackagesIndex, PackagesIndex, PackagesIndexMeta};
+pub use pdiff::{PDIFF_HISTORY_LENGTH, PackagesDiff, PackagesDiffIndex};
+pub use release::{
+    RESERVED_RELEASE_FIELDS, ReleaseEntry, ReleaseFile, ReleaseMeta, ReleaseSettings,
+    parse_valid_until, validate_release_field,
+};
+pub use translation_index::{
+    TranslationIndex, TranslationIndexMeta, TranslationPackage, format_description,
+    validate_translation_language,
+};
 
-pub use package::{Package, PackageByMeta, PublishedPackage, PublishedPackageByMeta};
-pub use packages_index::{PackagesIndex, PackagesIndexMeta};
-pub use release::{ReleaseFile, ReleaseMeta};
+/// Gzip `data` without a filename or timestamp in the header, so that the same
+/// input always compresses to the same bytes and index generation can be
+/// replayed.
+pub fn gzip(data: &[u8]) -> Vec<u8> {
+    let mut encoder = GzBuilder::new().write(Vec::new(), Compression::best());
+    encoder.write_all(data).unwrap();
+    encoder.finish().unwrap()
+}
@@ -1,3 +1,4 @@
+use debian_packaging::binary_package_control::BinaryPackageControlFile;
 use derivative::Derivative;
 use sqlx::{FromRow, Postgres, Transaction, types::JsonValue};
 
@@ -17,9 +18,25 @@ pub struct Package {
     pub md5sum: String,
     pub sha1sum: String,
     pub sha256sum: String,
+
+    /// Whether the package is a udeb, which is published in its component's
+    /// `debian-installer` sub-tree.
+    pub udeb: bool,
 }
 
 impl Package {
+    /// The control fields of a package, in the form that they're stored in
+    /// `paragraph` and rendered into Packages indexes.
+    pub fn paragraph_from_control_file(control_file: &BinaryPackageControlFile<'_>) -> JsonValue {
+        JsonValue::Object(
+            control_file
+                .as_str_hash_map()
+                .into_iter()
+                .map(|(k, v)| (k.to_string(), JsonValue::String(v.to_string())))
+                .collect(),
+        )
+    }
+
     pub async fn query_from_meta<'a>(
         tx: &mut Transaction<'a, Postgres>,
         tenant_id: &TenantID,
@@ -39,7 +56,8 @@ impl Package {
                     s3_bucket,
                     md5sum,
                     sha1sum,
-                    sha256sum
+                    sha256sum,
+                    udeb
                 FROM debian_repository_package
                 WHERE
                     tenant_id = $1
@@ -74,7 +92,8 @@ impl Package {
                     s3_bucket,
                     md5sum,
                     sha1sum,
-                    sha256sum
+                    sha256sum,
+                    udeb
                 FROM debian_repository_package
                 WHERE
                     tenant_id = $1
@@ -88,6 +107,13 @@ impl Package {
         .map_err(Into::into)
     }
 
+    /// The name that the Packages index listing this package in a component
+    /// is published under: the component itself, or its `debian-installer`
+    /// sub-tree for udebs.
+    pub fn index_component(&self, component: &str) -> String {
+        index_component(component, self.udeb)
+    }
+
     pub fn pool_filename_in_component(&self, component: &str) -> String {
         // FIXME: This isn't actually correct! Some documentation online
         // indicates that the package name in the pool filename should
@@ -106,12 +132,41 @@ impl Package {
         let binary_package_name = &self.name;
         let version = &self.version;
         let architecture = &self.architecture;
+        let extension = match self.udeb {
+            true => "udeb",
+            false => "deb",
+        };
         format!(
-            "pool/{component}/{source_package_name_start}/{source_package_name}/{binary_package_name}_{version}_{architecture}.deb"
+            "pool/{component}/{source_package_name_start}/{source_package_name}/{binary_package_name}_{version}_{architecture}.{extension}"
         )
     }
 }
 
+/// The sub-tree of a component that its udebs are published in, for building
+/// Debian installer images.
+pub const DEBIAN_INSTALLER: &str = "debian-installer";
+
+/// The name that a component's Packages indexes are published under: the
+/// component itself, or `<component>/debian-installer` for the indexes of its
+/// udebs. Packages indexes are keyed by this name, so that a component's
+/// regular and udeb indexes for the same architecture are kept apart.
+pub fn index_component(component: &str, debian_installer: bool) -> String {
+    match debian_installer {
+        true => format!("{component}/{DEBIAN_INSTALLER}"),
+        false => component.to_string(),
+    }
+}
+
+/// Split the name that a Packages index is published under into its component
+/// and whether it's the component's `debian-installer` sub-tree.
+pub fn split_index_component(index_component: &str) -> (&str, bool) {
+    // Component names can't contain slashes.
+    match index_component.split_once('/') {
+        Some((component, DEBIAN_INSTALLER)) => (component, true),
+        _ => (index_component, false),
+    }
+}
+
 /// This newtype wraps Package for use cases (e.g. sets) where you want Packages
 /// to have equality by their (name, version, architecture) fields.
 #[derive(Derivative)]
@@ -166,7 +221,8 @@ impl PublishedPackage {
                 debian_repository_package.md5sum,
                 debian_repository_package.sha1sum,
                 debian_repository_package.sha256sum,
-                debian_repository_component_package.filename
+                debian_repository_component_package.filename,
+                debian_repository_package.udeb
             FROM
                 debian_repository
                 JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
@@ -206,6 +262,7 @@ impl PublishedPackage {
                         md5sum: row.md5sum,
                         sha1sum: row.sha1sum,
                         sha256sum: row.sha256sum,
+                        udeb: row.udeb,
                     },
                     filename: row.filename,
                 }
@@ -213,6 +270,9 @@ impl PublishedPackage {
         })
     }
 
+    /// Load the packages in a Packages index: a component's udebs if
+    /// `debian_installer` is set, and its other packages if it isn't.
+    #[allow(clippy::too_many_arguments)]
     pub async fn query_from_packages_index<'a>(
         tx: &mut Transaction<'a, Postgres>,
         tenant_id: &TenantID,
@@ -220,6 +280,7 @@ impl PublishedPackage {
         release: &str,
         component: &str,
         architecture: &str,
+        debian_installer: bool,
     ) -> Result<Vec<Self>, ErrorResponse> {
         // Note that we don't use `query_as!` here because the macros (which
         // have compile-time query checking) don't actually work with `FromRow`
@@ -239,7 +300,8 @@ impl PublishedPackage {
                 debian_repository_package.md5sum,
                 debian_repository_package.sha1sum,
                 debian_repository_package.sha256sum,
-                debian_repository_component_package.filename
+                debian_repository_component_package.filename,
+                debian_repository_package.udeb
             FROM
                 debian_repository
                 JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
@@ -252,7 +314,8 @@ impl PublishedPackage {
                 AND debian_repository_release.distribution = $3
                 AND debian_repository_component.name = $4
                 AND debian_repository_package.architecture = $5::debian_repository_architecture
-        "#, tenant_id.0, repository, release, component, architecture as _)
+                AND debian_repository_package.udeb = $6
+        "#, tenant_id.0, repository, release, component, architecture as _, debian_installer)
         .map(|row| {
             PublishedPackage {
                 package: Package {
@@ -265,6 +328,7 @@ impl PublishedPackage {
                     md5sum: row.md5sum,
                     sha1sum: row.sha1sum,
                     sha256sum: row.sha256sum,
+                    udeb: row.udeb,
                 },
                 filename: row.filename,
             }
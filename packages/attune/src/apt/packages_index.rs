@@ -1,3 +1,5 @@
+use std::collections::BTreeMap;
+
 use itertools::Itertools;
 use md5::Md5;
 use sha1::Sha1;
@@ -6,7 +8,7 @@ use sqlx::{FromRow, Postgres, Transaction};
 
 use crate::{
     api::{ErrorResponse, TenantID},
-    apt::{Package, PublishedPackage},
+    apt::{Package, PublishedPackage, index_component, split_index_component},
 };
 
 #[derive(Clone, Debug, FromRow)]
@@ -28,14 +30,15 @@ impl PackagesIndexMeta {
         repository: &str,
         release: &str,
     ) -> Result<Vec<Self>, ErrorResponse> {
-        sqlx::query_as!(PackagesIndexMeta, r#"
+        sqlx::query!(r#"
             SELECT
                 debian_repository_component.name AS component,
                 debian_repository_index_packages.architecture::TEXT AS "architecture!: String",
                 debian_repository_index_packages.size,
                 debian_repository_index_packages.md5sum,
                 debian_repository_index_packages.sha1sum,
-                debian_repository_index_packages.sha256sum
+                debian_repository_index_packages.sha256sum,
+                debian_repository_index_packages.debian_installer
             FROM
                 debian_repository
                 JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
@@ -50,12 +53,94 @@ impl PackagesIndexMeta {
             repository,
             release,
         )
+        .map(|row| PackagesIndexMeta {
+            component: index_component(&row.component, row.debian_installer),
+            architecture: row.architecture,
+            size: row.size,
+            md5sum: row.md5sum,
+            sha1sum: row.sha1sum,
+            sha256sum: row.sha256sum,
+        })
         .fetch_all(&mut **tx)
         .await
         .map_err(Into::into)
     }
 }
 
+/// The single Packages index of a flat repository, which combines the
+/// Packages indexes of every component and architecture.
+#[derive(Clone, Debug)]
+pub struct FlatPackagesIndex {
+    pub contents: String,
+    pub size: i64,
+    pub md5sum: String,
+    pub sha1sum: String,
+    pub sha256sum: String,
+}
+
+impl FlatPackagesIndex {
+    /// Combine Packages indexes, keyed by component and architecture. The
+    /// result is deterministic because the indexes are combined in key order.
+    pub fn from_indexes(indexes: &BTreeMap<(String, String), String>) -> Self {
+        // Each non-empty index ends with a newline, so joining them with
+        // another newline keeps a blank line between every paragraph.
+        let contents = indexes
+            .values()
+            .filter(|contents| !contents.is_empty())
+            .join("\n");
+        Self {
+            size: contents.len() as i64,
+            md5sum: hex::encode(Md5::digest(&contents)),
+            sha1sum: hex::encode(Sha1::digest(&contents)),
+            sha256sum: hex::encode(Sha256::digest(&contents)),
+            contents,
+        }
+    }
+
+    /// Load the contents of every Packages index in a distribution, keyed by
+    /// component and architecture.
+    pub async fn query_indexes_from_release<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        repository: &str,
+        release: &str,
+    ) -> Result<BTreeMap<(String, String), String>, ErrorResponse> {
+        let indexes = sqlx::query!(r#"
+            SELECT
+                debian_repository_component.name AS component,
+                debian_repository_index_packages.architecture::TEXT AS "architecture!: String",
+                debian_repository_index_packages.contents
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_component ON debian_repository_component.release_id = debian_repository_release.id
+                JOIN debian_repository_index_packages ON debian_repository_index_packages.component_id = debian_repository_component.id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+                AND debian_repository_index_packages.compression IS NULL
+                AND NOT debian_repository_index_packages.debian_installer
+            "#,
+            tenant_id.0,
+            repository,
+            release,
+        )
+        .fetch_all(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+        Ok(indexes
+            .into_iter()
+            .map(|index| {
+                (
+                    (index.component, index.architecture),
+                    String::from_utf8(index.contents).expect("Packages index is not UTF-8"),
+                )
+            })
+            .collect())
+    }
+}
+
 #[derive(Clone, Debug, FromRow)]
 pub struct PackagesIndex {
     #[sqlx(flatten)]
@@ -134,8 +219,11 @@ impl PackagesIndex {
         }) {
             return;
         }
+        // udebs are listed in the `debian-installer` sub-tree's index, but are
+        // stored in the component's pool.
+        let (component, _) = split_index_component(&self.meta.component);
         self.packages
-            .push(PublishedPackage::from_package(added, &self.meta.component));
+            .push(PublishedPackage::from_package(added, component));
         self.rerender();
     }
 
@@ -191,6 +279,7 @@ mod tests {
                         md5sum: format!("fake_md5sum_{i}"),
                         sha1sum: format!("fake_sha1sum_{i}"),
                         sha256sum: format!("fake_sha256sum_{i}"),
+                        udeb: false,
                     },
                     "fake_component",
                 )
@@ -214,6 +303,7 @@ mod tests {
             md5sum: String::from("fake_md5sum"),
             sha1sum: String::from("fake_sha1sum"),
             sha256sum: String::from("fake_sha256sum"),
+            udeb: false,
         };
         let published = PublishedPackage::from_package(package.clone(), "fake_component");
         let mut index = PackagesIndex::from_packages("main", "amd64", vec![published]);
@@ -223,6 +313,53 @@ mod tests {
         assert_eq!(before, after);
     }
 
+    /// udebs added to a component's `debian-installer` index are stored in the
+    /// component's pool.
+    #[test]
+    fn udeb_filename_in_component_pool() {
+        let package = Package {
+            name: String::from("foo-udeb"),
+            version: String::from("1.0.0"),
+            architecture: String::from("amd64"),
+            paragraph: serde_json::Value::Object(serde_json::Map::new()),
+            size: 0,
+            s3_bucket: String::from("fake_bucket"),
+            md5sum: String::from("fake_md5sum"),
+            sha1sum: String::from("fake_sha1sum"),
+            sha256sum: String::from("fake_sha256sum"),
+            udeb: true,
+        };
+        let component = package.index_component("main");
+        assert_eq!(component, "main/debian-installer");
+        let mut index = PackagesIndex::from_packages(&component, "amd64", Vec::new());
+        index.add_package(package);
+        assert!(
+            index
+                .contents
+                .contains("Filename: pool/main/f/foo-udeb/foo-udeb_1.0.0_amd64.udeb\n")
+        );
+    }
+
+    /// Flat indexes keep a blank line between the paragraphs of each
+    /// combined index, and skip empty indexes.
+    #[test]
+    fn flat_index_separates_indexes() {
+        let indexes = BTreeMap::from([
+            (
+                (String::from("main"), String::from("amd64")),
+                String::from("Package: foo\n"),
+            ),
+            ((String::from("main"), String::from("arm64")), String::new()),
+            (
+                (String::from("main"), String::from("i386")),
+                String::from("Package: bar\n"),
+            ),
+        ]);
+        let flat = FlatPackagesIndex::from_indexes(&indexes);
+        assert_eq!(flat.contents, "Package: foo\n\nPackage: bar\n");
+        assert_eq!(flat.size, flat.contents.len() as i64);
+    }
+
     // TODO: `debian_packaging::repository::ReleaseReader` provides a parser for
     // Packages indexes via `ControlParagraphReader` and
     // `BinaryPackageControlFile::from`. We can use that to create a
@@ -0,0 +1,343 @@
+use std::{collections::BTreeMap, fmt::Write as _};
+
+use md5::Md5;
+use sha2::{Digest as _, Sha256};
+use sqlx::{Postgres, Transaction};
+use time::{OffsetDateTime, macros::format_description};
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::{PackagesIndexMeta, ReleaseEntry, gzip, index_component},
+};
+
+/// How many patches are kept for each Packages index. Clients whose index is
+/// older than all of them download the full index instead.
+pub const PDIFF_HISTORY_LENGTH: usize = 32;
+
+/// A patch in a Packages index's `Packages.diff/` history, which turns one
+/// version of the index into the next.
+///
+/// See https://wiki.debian.org/DebianRepository/Format#Diffs.
+#[derive(Clone, Debug)]
+pub struct PackagesDiff {
+    /// The patch's name, which is unique within the index's history.
+    pub name: String,
+
+    /// The hash and size of the Packages index that the patch applies to.
+    pub history_sha256sum: String,
+    pub history_size: i64,
+
+    /// The hash and size of the uncompressed patch.
+    pub patch_sha256sum: String,
+    pub patch_size: i64,
+
+    /// The hash and size of the gzipped patch, which is what clients download.
+    pub download_sha256sum: String,
+    pub download_size: i64,
+
+    /// The gzipped patch.
+    pub contents: Vec<u8>,
+}
+
+impl PackagesDiff {
+    /// Generate the patch from one version of a Packages index to the next.
+    /// The name is derived from `release_ts`, so that generation can be
+    /// replayed.
+    pub fn between(old: &str, new: &str, release_ts: OffsetDateTime) -> Self {
+        let patch = ed_script(old, new);
+        let contents = gzip(patch.as_bytes());
+        Self {
+            name: release_ts
+                .format(format_description!(
+                    "[year]-[month]-[day]-[hour][minute].[second].[subsecond digits:6]"
+                ))
+                .unwrap(),
+            history_sha256sum: hex::encode(Sha256::digest(old)),
+            history_size: old.len() as i64,
+            patch_sha256sum: hex::encode(Sha256::digest(&patch)),
+            patch_size: patch.len() as i64,
+            download_sha256sum: hex::encode(Sha256::digest(&contents)),
+            download_size: contents.len() as i64,
+            contents,
+        }
+    }
+
+    /// Load the patch history of every Packages index in a distribution,
+    /// keyed by component and architecture, oldest first.
+    pub async fn query_from_release<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        repository: &str,
+        release: &str,
+    ) -> Result<BTreeMap<(String, String), Vec<Self>>, ErrorResponse> {
+        let rows = sqlx::query!(r#"
+            SELECT
+                debian_repository_component.name AS component,
+                debian_repository_index_packages.architecture::TEXT AS "architecture!: String",
+                debian_repository_index_packages_diff.name,
+                debian_repository_index_packages_diff.history_sha256sum,
+                debian_repository_index_packages_diff.history_size,
+                debian_repository_index_packages_diff.patch_sha256sum,
+                debian_repository_index_packages_diff.patch_size,
+                debian_repository_index_packages_diff.download_sha256sum,
+                debian_repository_index_packages_diff.download_size,
+                debian_repository_index_packages_diff.contents,
+                debian_repository_index_packages.debian_installer
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_component ON debian_repository_component.release_id = debian_repository_release.id
+                JOIN debian_repository_index_packages ON debian_repository_index_packages.component_id = debian_repository_component.id
+                JOIN debian_repository_index_packages_diff ON debian_repository_index_packages_diff.index_id = debian_repository_index_packages.id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+            ORDER BY debian_repository_index_packages_diff.id
+            "#,
+            tenant_id.0,
+            repository,
+            release,
+        )
+        .fetch_all(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+
+        let mut history = BTreeMap::<_, Vec<_>>::new();
+        for row in rows {
+            history
+                .entry((
+                    index_component(&row.component, row.debian_installer),
+                    row.architecture,
+                ))
+                .or_default()
+                .push(Self {
+                    name: row.name,
+                    history_sha256sum: row.history_sha256sum,
+                    history_size: row.history_size,
+                    patch_sha256sum: row.patch_sha256sum,
+                    patch_size: row.patch_size,
+                    download_sha256sum: row.download_sha256sum,
+                    download_size: row.download_size,
+                    contents: row.contents,
+                });
+        }
+        Ok(history)
+    }
+}
+
+/// A Packages index's `Packages.diff/Index`, which lists its patches.
+#[derive(Clone, Debug)]
+pub struct PackagesDiffIndex {
+    pub component: String,
+    pub architecture: String,
+
+    pub contents: String,
+    pub size: i64,
+    pub md5sum: String,
+    pub sha256sum: String,
+}
+
+impl PackagesDiffIndex {
+    /// Render the index of `history`, whose last patch produces `current`.
+    pub fn render(current: &PackagesIndexMeta, history: &[PackagesDiff]) -> Self {
+        let mut contents = String::new();
+        writeln!(
+            contents,
+            "SHA256-Current: {} {}",
+            current.sha256sum, current.size
+        )
+        .unwrap();
+        contents += "SHA256-History:\n";
+        for diff in history {
+            writeln!(
+                contents,
+                " {} {} {}",
+                diff.history_sha256sum, diff.history_size, diff.name
+            )
+            .unwrap();
+        }
+        contents += "SHA256-Patches:\n";
+        for diff in history {
+            writeln!(
+                contents,
+                " {} {} {}",
+                diff.patch_sha256sum, diff.patch_size, diff.name
+            )
+            .unwrap();
+        }
+        contents += "SHA256-Download:\n";
+        for diff in history {
+            writeln!(
+                contents,
+                " {} {} {}.gz",
+                diff.download_sha256sum, diff.download_size, diff.name
+            )
+            .unwrap();
+        }
+        Self {
+            component: current.component.clone(),
+            architecture: current.architecture.clone(),
+            size: contents.len() as i64,
+            md5sum: hex::encode(Md5::digest(&contents)),
+            sha256sum: hex::encode(Sha256::digest(&contents)),
+            contents,
+        }
+    }
+
+    /// The index's entry in its distribution's Release file.
+    pub fn release_entry(&self) -> ReleaseEntry {
+        ReleaseEntry {
+            path: format!(
+                "{}/binary-{}/Packages.diff/Index",
+                self.component, self.architecture
+            ),
+            size: self.size,
+            md5sum: self.md5sum.clone(),
+            sha256sum: self.sha256sum.clone(),
+        }
+    }
+}
+
+/// Generate an ed script that turns `old` into `new`, in the subset of ed that
+/// apt's `rred` understands.
+///
+/// Packages indexes are sorted, and each change adds or removes one package,
+/// so the lines between the common prefix and suffix are exactly the changed
+/// paragraph. This is linear in the size of the index, unlike a general diff.
+pub fn ed_script(old: &str, new: &str) -> String {
+    let old = old.lines().collect::<Vec<_>>();
+    let new = new.lines().collect::<Vec<_>>();
+    let prefix = old
+        .iter()
+        .zip(&new)
+        .take_while(|(old, new)| old == new)
+        .count();
+    let suffix = old[prefix..]
+        .iter()
+        .rev()
+        .zip(new[prefix..].iter().rev())
+        .take_while(|(old, new)| old == new)
+        .count();
+    let removed = prefix..old.len() - suffix;
+    let added = &new[prefix..new.len() - suffix];
+
+    // ed line numbers are 1-based, and `Na` appends after line N.
+    let mut script = if removed.is_empty() {
+        if added.is_empty() {
+            return String::new();
+        }
+        format!("{prefix}a\n")
+    } else {
+        let command = if added.is_empty() { "d" } else { "c" };
+        if removed.len() == 1 {
+            format!("{}{command}\n", removed.start + 1)
+        } else {
+            format!("{},{}{command}\n", removed.start + 1, removed.end)
+        }
+    };
+    if !added.is_empty() {
+        for line in added {
+            script += line;
+            script.push('\n');
+        }
+        script += ".\n";
+    }
+    script
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Apply a single-command ed script, as generated by `ed_script`.
+    fn apply(old: &str, script: &str) -> String {
+        let mut lines = old.lines().map(String::from).collect::<Vec<_>>();
+        let mut script = script.lines();
+        let Some(command) = script.next() else {
+            return old.to_string();
+        };
+        let added = script
+            .take_while(|line| *line != ".")
+            .map(String::from)
+            .collect::<Vec<_>>();
+        let (range, action) = command.split_at(command.len() - 1);
+        let (start, end) = match range.split_once(',') {
+            Some((start, end)) => (start.parse().unwrap(), end.parse().unwrap()),
+            None => {
+                let line = range.parse::<usize>().unwrap();
+                (line, line)
+            }
+        };
+        match action {
+            "a" => {
+                lines.splice(start..start, added);
+            }
+            "c" => {
+                lines.splice(start - 1..end, added);
+            }
+            "d" => {
+                lines.splice(start - 1..end, []);
+            }
+            _ => panic!("unexpected command {command:?}"),
+        }
+        lines.into_iter().map(|line| line + "\n").collect()
+    }
+
+    #[test]
+    fn ed_script_round_trips() {
+        let a = "Package: a\nVersion: 1\n\n";
+        let b = "Package: b\nVersion: 1\n\n";
+        let c = "Package: c\nVersion: 1\n";
+        let cases = [
+            (format!("{a}{c}"), format!("{a}{b}{c}")),
+            (format!("{a}{b}{c}"), format!("{a}{c}")),
+            (format!("{b}{c}"), format!("{a}{b}{c}")),
+            (format!("{a}{b}{c}"), format!("{b}{c}")),
+            (format!("{a}{b}"), format!("{a}{b}{c}")),
+            (format!("{a}{b}{c}"), format!("{a}{b}")),
+            (
+                format!("{a}Package: c\nVersion: 1\n"),
+                format!("{a}Package: c\nVersion: 2\n"),
+            ),
+            (format!("{a}{c}"), format!("{a}{c}")),
+        ];
+        for (old, new) in cases {
+            let script = ed_script(&old, &new);
+            assert_eq!(apply(&old, &script), new, "script:\n{script}");
+        }
+    }
+
+    #[test]
+    fn renders_diff_index() {
+        let old = "Package: a\n";
+        let new = "Package: a\n\nPackage: b\n";
+        let diff = PackagesDiff::between(old, new, OffsetDateTime::UNIX_EPOCH);
+        assert_eq!(diff.name, "1970-01-01-0000.00.000000");
+        let current = PackagesIndexMeta {
+            component: String::from("main"),
+            architecture: String::from("amd64"),
+            size: new.len() as i64,
+            md5sum: hex::encode(Md5::digest(new)),
+            sha1sum: String::new(),
+            sha256sum: hex::encode(Sha256::digest(new)),
+        };
+        let index = PackagesDiffIndex::render(&current, &[diff.clone()]);
+        assert!(index.contents.starts_with(&format!(
+            "SHA256-Current: {} {}\nSHA256-History:\n {} {} {}\n",
+            current.sha256sum,
+            current.size,
+            diff.history_sha256sum,
+            old.len(),
+            diff.name
+        )));
+        assert!(index.contents.ends_with(&format!(
+            " {} {} {}.gz\n",
+            diff.download_sha256sum, diff.download_size, diff.name
+        )));
+        assert_eq!(
+            index.release_entry().path,
+            "main/binary-amd64/Packages.diff/Index"
+        );
+    }
+}
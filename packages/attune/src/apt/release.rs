@@ -1,12 +1,17 @@
-use std::{collections::BTreeSet, fmt::Write as _, io::Write as _};
+use std::{
+    collections::{BTreeMap, BTreeSet},
+    fmt::Write as _,
+    io::Write as _,
+};
 
-use sqlx::{FromRow, Postgres, Transaction};
+use lazy_regex::lazy_regex;
+use sqlx::{Executor, FromRow, Postgres, Transaction, types::Json};
 use tabwriter::{Alignment, TabWriter};
-use time::{OffsetDateTime, format_description::well_known::Rfc2822};
+use time::{Duration, OffsetDateTime, format_description::well_known::Rfc2822};
 
 use crate::{
     api::{ErrorResponse, TenantID},
-    apt::PackagesIndexMeta,
+    apt::{FlatPackagesIndex, PackagesIndexMeta, split_index_component},
 };
 
 #[derive(FromRow, Debug)]
@@ -53,6 +58,123 @@ impl ReleaseMeta {
     }
 }
 
+/// Release fields that Attune generates, or that are set per distribution (with
+/// `attune apt dist edit`), and so can't be set as custom fields.
+pub const RESERVED_RELEASE_FIELDS: [&str; 13] = [
+    "Origin",
+    "Label",
+    "Version",
+    "Suite",
+    "Codename",
+    "Date",
+    "Valid-Until",
+    "Architectures",
+    "Components",
+    "Description",
+    "MD5Sum",
+    "SHA1",
+    "SHA256",
+];
+
+/// Check that a custom Release field can be written to a Release file.
+pub fn validate_release_field(key: &str, value: &str) -> Result<(), String> {
+    // Field names can't start with `#` or `-`, or contain colons or
+    // whitespace. See https://manpages.debian.org/stable/dpkg-dev/deb822.5.en.html.
+    if !lazy_regex!(r"^[A-Za-z0-9][A-Za-z0-9._-]*$").is_match(key) {
+        return Err(format!(
+            "invalid Release field name {key:?}: names must start with a letter or number, and contain only letters, numbers, periods, underscores, and hyphens"
+        ));
+    }
+    if let Some(reserved) = RESERVED_RELEASE_FIELDS
+        .iter()
+        .find(|reserved| reserved.eq_ignore_ascii_case(key))
+    {
+        return Err(format!(
+            "the {reserved} Release field is set by Attune and can't be customized"
+        ));
+    }
+    if value.trim().is_empty() || value.contains(['\n', '\r']) {
+        return Err(format!(
+            "invalid value for Release field {key:?}: values must be a single non-empty line"
+        ));
+    }
+    Ok(())
+}
+
+/// Repository settings that apply to the Release file of each of its
+/// distributions.
+#[derive(Debug)]
+pub struct ReleaseSettings {
+    /// Custom fields. These replace generated fields of the same name, and the
+    /// rest are written after the generated fields.
+    pub custom_fields: BTreeMap<String, String>,
+    /// If set, the Release file's `Valid-Until` is this many days after its
+    /// `Date`.
+    pub valid_until_days: Option<i32>,
+    /// Whether the repository is flat, with its Release file and a single
+    /// Packages index at its root instead of under `dists/`.
+    pub flat: bool,
+    /// Whether each Packages index also publishes a `Packages.diff/` history.
+    /// This is never set for flat repositories.
+    pub pdiffs: bool,
+    /// Whether each component publishes a `Contents-<arch>.gz` index for each
+    /// architecture. This is never set for flat repositories.
+    pub contents_indexes: bool,
+    /// Whether each component publishes `i18n/Translation-<lang>` indexes.
+    /// This is never set for flat repositories.
+    pub translations: bool,
+}
+
+impl ReleaseSettings {
+    pub async fn query<'c, E>(
+        executor: E,
+        tenant_id: &TenantID,
+        repository: &str,
+    ) -> Result<Self, ErrorResponse>
+    where
+        E: Executor<'c, Database = Postgres>,
+    {
+        sqlx::query!(
+            r#"
+            SELECT
+                release_fields AS "release_fields!: Json<BTreeMap<String, String>>",
+                valid_until_days,
+                flat,
+                pdiffs,
+                contents_indexes,
+                translations
+            FROM debian_repository
+            WHERE tenant_id = $1 AND name = $2
+            "#,
+            tenant_id.0,
+            repository,
+        )
+        .fetch_optional(executor)
+        .await
+        .map_err(ErrorResponse::from)?
+        .map(|row| ReleaseSettings {
+            custom_fields: row.release_fields.0,
+            valid_until_days: row.valid_until_days,
+            flat: row.flat,
+            pdiffs: row.pdiffs && !row.flat,
+            contents_indexes: row.contents_indexes && !row.flat,
+            translations: row.translations && !row.flat,
+        })
+        .ok_or(ErrorResponse::not_found("repository"))
+    }
+}
+
+/// A file listed in a Release file's checksums other than a Packages index,
+/// such as a `Packages.diff/Index`, a Contents index, or a Translation index.
+#[derive(Clone, Debug)]
+pub struct ReleaseEntry {
+    /// The path of the file, relative to the Release file.
+    pub path: String,
+    pub size: i64,
+    pub md5sum: String,
+    pub sha256sum: String,
+}
+
 #[derive(Debug)]
 pub struct ReleaseFile {
     pub meta: ReleaseMeta,
@@ -60,16 +182,29 @@ pub struct ReleaseFile {
 }
 
 impl ReleaseFile {
+    /// Generate a Release file for a distribution's Packages indexes.
+    ///
+    /// For flat repositories, `flat_index` is the single Packages index that
+    /// combines `packages_indexes`, and is the only index listed.
+    /// `additional_files` are listed after the indexes.
     pub fn from_indexes(
         release: ReleaseMeta,
+        settings: &ReleaseSettings,
         release_ts: OffsetDateTime,
         packages_indexes: &Vec<PackagesIndexMeta>,
+        flat_index: Option<&FlatPackagesIndex>,
+        additional_files: &[ReleaseEntry],
     ) -> Self {
         // Note that the date format is RFC 2822. _Technically_, the Debian spec
         // says it should be the date format of `date -R -u`, which technically
         // is RFC 5322, but these formats are compatible. 5322 is a later
         // revision of 2822 that retains backwards compatibility.
         let date = release_ts.format(&Rfc2822).unwrap();
+        let valid_until = settings.valid_until_days.map(|days| {
+            (release_ts + Duration::days(days.into()))
+                .format(&Rfc2822)
+                .unwrap()
+        });
 
         // Prepare "Architectures" and "Components" fields. We use BTreeSets
         // instead of HashSets to get deterministic iterator order, since index
@@ -78,7 +213,9 @@ impl ReleaseFile {
         let mut comp_set = BTreeSet::new();
         for p in packages_indexes {
             arch_set.insert(p.architecture.as_str());
-            comp_set.insert(p.component.as_str());
+            // Indexes in a component's `debian-installer` sub-tree are part of
+            // the component.
+            comp_set.insert(split_index_component(&p.component).0);
         }
         let archs = arch_set
             .into_iter()
@@ -90,39 +227,84 @@ impl ReleaseFile {
         let comps = comps.strip_prefix(" ").unwrap_or("");
 
         // Write release fields.
-        let mut release_file = vec![
+        let custom_fields = &settings.custom_fields;
+        let custom_field = |key: &str| {
+            custom_fields
+                .iter()
+                .find(|(custom, _)| custom.eq_ignore_ascii_case(key))
+                .map(|(_, value)| value.clone())
+        };
+        let generated = [
             ("Origin", release.origin.clone()),
             ("Label", release.label.clone()),
             ("Version", release.version.clone()),
             ("Suite", Some(release.suite.clone())),
             ("Codename", Some(release.codename.clone())),
             ("Date", Some(date)),
+            ("Valid-Until", valid_until),
             ("Architectures", Some(archs.to_string())),
-            ("Components", Some(comps.to_string())),
+            // Flat repositories have no components, and their indexes can't
+            // be fetched by hash.
+            ("Components", flat_index.is_none().then(|| comps.to_string())),
             ("Description", release.description.clone()),
-            ("Acquire-By-Hash", Some(String::from("yes"))),
-        ]
-        .into_iter()
-        .fold(String::new(), |mut acc, (k, v)| {
-            if let Some(v) = v {
-                writeln!(acc, "{k}: {v}").unwrap();
-            }
-            acc
+            (
+                "Acquire-By-Hash",
+                flat_index.is_none().then(|| String::from("yes")),
+            ),
+        ];
+        let additional = custom_fields.iter().filter(|(custom, _)| {
+            !generated
+                .iter()
+                .any(|(key, _)| key.eq_ignore_ascii_case(custom))
         });
+        let mut release_file = generated
+            .iter()
+            .map(|(k, v)| (k.to_string(), custom_field(k).or_else(|| v.clone())))
+            .chain(additional.map(|(k, v)| (k.clone(), Some(v.clone()))))
+            .fold(String::new(), |mut acc, (k, v)| {
+                if let Some(v) = v {
+                    writeln!(acc, "{k}: {v}").unwrap();
+                }
+                acc
+            });
 
         // Write index fingerprints.
+        //
+        // TODO(#94): Handle compressed indexes.
+        let mut files = match flat_index {
+            Some(index) => vec![(
+                index.md5sum.as_str(),
+                index.sha256sum.as_str(),
+                index.size,
+                String::from("Packages"),
+            )],
+            None => packages_indexes
+                .iter()
+                .map(|index| {
+                    (
+                        index.md5sum.as_str(),
+                        index.sha256sum.as_str(),
+                        index.size,
+                        format!("{}/binary-{}/Packages", index.component, index.architecture),
+                    )
+                })
+                .collect::<Vec<_>>(),
+        };
+        files.extend(additional_files.iter().map(|file| {
+            (
+                file.md5sum.as_str(),
+                file.sha256sum.as_str(),
+                file.size,
+                file.path.clone(),
+            )
+        }));
+
         release_file += "MD5Sum:\n";
         let mut md5writer = TabWriter::new(vec![])
             .alignment(Alignment::Right)
             .padding(1);
-        for index in packages_indexes {
-            // TODO(#94): Handle compressed indexes.
-            writeln!(
-                &mut md5writer,
-                " {}\t{}\t{}/binary-{}/Packages",
-                index.md5sum, index.size, index.component, index.architecture
-            )
-            .unwrap();
+        for (md5sum, _, size, path) in &files {
+            writeln!(&mut md5writer, " {md5sum}\t{size}\t{path}").unwrap();
         }
         md5writer.flush().unwrap();
         release_file = release_file + &String::from_utf8(md5writer.into_inner().unwrap()).unwrap();
@@ -131,14 +313,8 @@ impl ReleaseFile {
         let mut sha256writer = TabWriter::new(vec![])
             .alignment(Alignment::Right)
             .padding(1);
-        for index in packages_indexes {
-            // TODO(#94): Handle compressed indexes.
-            writeln!(
-                &mut sha256writer,
-                " {}\t{}\t{}/binary-{}/Packages",
-                index.sha256sum, index.size, index.component, index.architecture
-            )
-            .unwrap();
+        for (_, sha256sum, size, path) in &files {
+            writeln!(&mut sha256writer, " {sha256sum}\t{size}\t{path}").unwrap();
         }
         sha256writer.flush().unwrap();
 
@@ -150,3 +326,108 @@ impl ReleaseFile {
         }
     }
 }
+
+/// Read the `Valid-Until` field of a Release file, if it has one.
+pub fn parse_valid_until(contents: &str) -> Option<OffsetDateTime> {
+    contents
+        .lines()
+        // Stop at the first multi-line field (the checksums), since the
+        // simple fields all come before it.
+        .take_while(|line| !line.ends_with(':'))
+        .find_map(|line| line.strip_prefix("Valid-Until: "))
+        .and_then(|value| OffsetDateTime::parse(value, &Rfc2822).ok())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn writes_custom_fields() {
+        let release = ReleaseMeta {
+            description: None,
+            origin: Some(String::from("Example")),
+            label: None,
+            version: None,
+            suite: String::from("stable"),
+            codename: String::from("stable"),
+        };
+        let settings = ReleaseSettings {
+            custom_fields: BTreeMap::from([
+                (String::from("acquire-by-hash"), String::from("no")),
+                (String::from("Butautomaticupgrades"), String::from("yes")),
+            ]),
+            valid_until_days: None,
+            flat: false,
+            pdiffs: false,
+            contents_indexes: false,
+            translations: false,
+        };
+        let release_file =
+            ReleaseFile::from_indexes(
+            release,
+            &settings,
+            OffsetDateTime::UNIX_EPOCH,
+            &vec![],
+            None,
+            &[],
+        );
+        assert!(release_file.contents.contains("Acquire-By-Hash: no\n"));
+        assert!(!release_file.contents.contains("Acquire-By-Hash: yes"));
+        assert!(
+            release_file
+                .contents
+                .contains("Acquire-By-Hash: no\nButautomaticupgrades: yes\n")
+        );
+    }
+
+    #[test]
+    fn writes_valid_until() {
+        let release = ReleaseMeta {
+            description: None,
+            origin: None,
+            label: None,
+            version: None,
+            suite: String::from("stable"),
+            codename: String::from("stable"),
+        };
+        let settings = ReleaseSettings {
+            custom_fields: BTreeMap::new(),
+            valid_until_days: Some(7),
+            flat: false,
+            pdiffs: false,
+            contents_indexes: false,
+            translations: false,
+        };
+        let release_file =
+            ReleaseFile::from_indexes(
+            release,
+            &settings,
+            OffsetDateTime::UNIX_EPOCH,
+            &vec![],
+            None,
+            &[],
+        );
+        assert!(
+            release_file
+                .contents
+                .contains("Date: Thu, 01 Jan 1970 00:00:00 +0000\nValid-Until: Thu, 08 Jan 1970 00:00:00 +0000\n")
+        );
+        assert_eq!(
+            parse_valid_until(&release_file.contents),
+            Some(OffsetDateTime::UNIX_EPOCH + Duration::days(7))
+        );
+    }
+
+    #[test]
+    fn validates_custom_fields() {
+        assert!(validate_release_field("Butautomaticupgrades", "yes").is_ok());
+        assert!(validate_release_field("Acquire-By-Hash", "no").is_ok());
+        assert!(validate_release_field("suite", "unstable").is_err());
+        assert!(validate_release_field("Valid-Until", "never").is_err());
+        assert!(validate_release_field("Bad Key", "yes").is_err());
+        assert!(validate_release_field("-Key", "yes").is_err());
+        assert!(validate_release_field("Key", "").is_err());
+        assert!(validate_release_field("Key", "two\nlines").is_err());
+    }
+}
@@ -0,0 +1,379 @@
+use std::collections::{BTreeMap, BTreeSet};
+
+use itertools::Itertools as _;
+use lazy_regex::lazy_regex;
+use md5::Md5;
+use sha2::{Digest as _, Sha256};
+use sqlx::{FromRow, Postgres, Transaction, types::Json};
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::ReleaseEntry,
+};
+
+/// A package's description and its uploaded translations, which is all that
+/// Translation indexes list about it.
+#[derive(Clone, Debug, FromRow)]
+pub struct TranslationPackage {
+    pub name: String,
+    pub sha256sum: String,
+    /// The package's Description field, as it appears in Packages indexes.
+    pub description: Option<String>,
+    /// Uploaded translations of the description, keyed by language.
+    pub translations: Json<BTreeMap<String, String>>,
+}
+
+impl TranslationPackage {
+    /// Load the packages published in a component, for every architecture.
+    /// udebs aren't listed in Translation indexes.
+    pub async fn query_from_component<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        repository: &str,
+        release: &str,
+        component: &str,
+    ) -> Result<Vec<Self>, ErrorResponse> {
+        sqlx::query_as!(Self, r#"
+            SELECT
+                debian_repository_package.package AS name,
+                debian_repository_package.sha256sum,
+                debian_repository_package.paragraph->>'Description' AS description,
+                COALESCE(
+                    (
+                        SELECT jsonb_object_agg(language, description)
+                        FROM debian_repository_package_translation
+                        WHERE package_id = debian_repository_package.id
+                    ),
+                    '{}'::jsonb
+                ) AS "translations!: Json<BTreeMap<String, String>>"
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_component ON debian_repository_component.release_id = debian_repository_release.id
+                JOIN debian_repository_component_package ON debian_repository_component_package.component_id = debian_repository_component.id
+                JOIN debian_repository_package ON debian_repository_package.id = debian_repository_component_package.package_id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+                AND debian_repository_component.name = $4
+                AND NOT debian_repository_package.udeb
+            "#,
+            tenant_id.0,
+            repository,
+            release,
+            component,
+        )
+        .fetch_all(&mut **tx)
+        .await
+        .map_err(Into::into)
+    }
+
+    pub async fn query_from_sha256sum<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        sha256sum: &str,
+    ) -> Result<Option<Self>, ErrorResponse> {
+        sqlx::query_as!(
+            Self,
+            r#"
+                SELECT
+                    package AS name,
+                    sha256sum,
+                    paragraph->>'Description' AS description,
+                    COALESCE(
+                        (
+                            SELECT jsonb_object_agg(language, description)
+                            FROM debian_repository_package_translation
+                            WHERE package_id = debian_repository_package.id
+                        ),
+                        '{}'::jsonb
+                    ) AS "translations!: Json<BTreeMap<String, String>>"
+                FROM debian_repository_package
+                WHERE
+                    tenant_id = $1
+                    AND sha256sum = $2
+            "#,
+            tenant_id.0,
+            sha256sum,
+        )
+        .fetch_optional(&mut **tx)
+        .await
+        .map_err(Into::into)
+    }
+}
+
+#[derive(Clone, Debug, FromRow)]
+pub struct TranslationIndexMeta {
+    pub component: String,
+    pub language: String,
+
+    pub size: i64,
+
+    pub md5sum: String,
+    pub sha256sum: String,
+}
+
+impl TranslationIndexMeta {
+    pub async fn query_from_release<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        repository: &str,
+        release: &str,
+    ) -> Result<Vec<Self>, ErrorResponse> {
+        sqlx::query_as!(Self, r#"
+            SELECT
+                debian_repository_component.name AS component,
+                debian_repository_index_translation.language,
+                debian_repository_index_translation.size,
+                debian_repository_index_translation.md5sum,
+                debian_repository_index_translation.sha256sum
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_component ON debian_repository_component.release_id = debian_repository_release.id
+                JOIN debian_repository_index_translation ON debian_repository_index_translation.component_id = debian_repository_component.id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+            ORDER BY debian_repository_component.name, debian_repository_index_translation.language
+            "#,
+            tenant_id.0,
+            repository,
+            release,
+        )
+        .fetch_all(&mut **tx)
+        .await
+        .map_err(Into::into)
+    }
+
+    /// The index's path, relative to its distribution's Release file.
+    pub fn path(&self) -> String {
+        format!("{}/i18n/Translation-{}", self.component, self.language)
+    }
+
+    /// The index's entry in its distribution's Release file.
+    pub fn release_entry(&self) -> ReleaseEntry {
+        ReleaseEntry {
+            path: self.path(),
+            size: self.size,
+            md5sum: self.md5sum.clone(),
+            sha256sum: self.sha256sum.clone(),
+        }
+    }
+}
+
+/// A `Translation-<lang>` index, which lists the descriptions of a component's
+/// packages in one language. apt matches each description to its package by
+/// the MD5 sum of the package's English description.
+///
+/// See https://wiki.debian.org/DebianRepository/Format#A.22Translation.22_indices.
+#[derive(Clone, Debug)]
+pub struct TranslationIndex {
+    pub meta: TranslationIndexMeta,
+    pub contents: String,
+}
+
+impl TranslationIndex {
+    /// Generate the Translation indexes of a component: one for English, and
+    /// one for each language that any of its packages has been translated to.
+    pub fn from_packages(component: &str, packages: &[TranslationPackage]) -> Vec<Self> {
+        let languages = packages
+            .iter()
+            .flat_map(|package| package.translations.keys().map(String::as_str))
+            .chain(["en"])
+            .collect::<BTreeSet<_>>();
+        languages
+            .into_iter()
+            .map(|language| (language, Self::render(language, packages)))
+            .filter(|(_, contents)| !contents.is_empty())
+            .map(|(language, contents)| Self {
+                meta: TranslationIndexMeta {
+                    component: component.to_string(),
+                    language: language.to_string(),
+                    size: contents.len() as i64,
+                    md5sum: hex::encode(Md5::digest(&contents)),
+                    sha256sum: hex::encode(Sha256::digest(&contents)),
+                },
+                contents,
+            })
+            .collect()
+    }
+
+    /// Load the published Translation indexes of a distribution, with their
+    /// contents.
+    pub async fn query_from_release<'a>(
+        tx: &mut Transaction<'a, Postgres>,
+        tenant_id: &TenantID,
+        repository: &str,
+        release: &str,
+    ) -> Result<Vec<Self>, ErrorResponse> {
+        let rows = sqlx::query!(r#"
+            SELECT
+                debian_repository_component.name AS component,
+                debian_repository_index_translation.language,
+                debian_repository_index_translation.size,
+                debian_repository_index_translation.md5sum,
+                debian_repository_index_translation.sha256sum,
+                debian_repository_index_translation.contents
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_component ON debian_repository_component.release_id = debian_repository_release.id
+                JOIN debian_repository_index_translation ON debian_repository_index_translation.component_id = debian_repository_component.id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+            "#,
+            tenant_id.0,
+            repository,
+            release,
+        )
+        .fetch_all(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+        Ok(rows
+            .into_iter()
+            .map(|row| Self {
+                meta: TranslationIndexMeta {
+                    component: row.component,
+                    language: row.language,
+                    size: row.size,
+                    md5sum: row.md5sum,
+                    sha256sum: row.sha256sum,
+                },
+                contents: String::from_utf8(row.contents).expect("Translation index is not UTF-8"),
+            })
+            .collect())
+    }
+
+    /// Render the index for one language. Packages without a description in
+    /// that language are left out, except that every package has an English
+    /// description. Packages that share a name and description are listed
+    /// once.
+    fn render(language: &str, packages: &[TranslationPackage]) -> String {
+        let mut paragraphs = BTreeMap::new();
+        for package in packages {
+            let Some(english) = &package.description else {
+                continue;
+            };
+            let description = match package.translations.get(language) {
+                Some(translated) => translated,
+                None if language == "en" => english,
+                None => continue,
+            };
+            // apt computes this from the Description field of the package's
+            // Packages index paragraph, with a trailing newline.
+            let md5sum = hex::encode(Md5::digest(format!("{english}\n")));
+            paragraphs
+                .entry((package.name.as_str(), md5sum))
+                .or_insert(description);
+        }
+        paragraphs
+            .into_iter()
+            .map(|((name, md5sum), description)| {
+                format!(
+                    "Package: {name}\nDescription-md5: {md5sum}\nDescription-{language}: {description}\n"
+                )
+            })
+            .join("\n")
+    }
+}
+
+/// Check that a language code can name a Translation index, like `de` or
+/// `pt_BR`.
+pub fn validate_translation_language(language: &str) -> Result<(), String> {
+    if !lazy_regex!(r"^[a-z]{2,3}(_[A-Z]{2})?$").is_match(language) {
+        return Err(format!(
+            "invalid language {language:?}: expected a language code like \"de\" or \"pt_BR\""
+        ));
+    }
+    Ok(())
+}
+
+/// Format a plain-text description as the value of a control file's
+/// Description field. The first line is the synopsis, and the rest is the long
+/// description, whose blank lines become ` .`.
+pub fn format_description(text: &str) -> Result<String, String> {
+    let mut lines = text.trim_end().lines();
+    let synopsis = lines.next().unwrap_or_default().trim();
+    if synopsis.is_empty() {
+        return Err(String::from(
+            "invalid description: the first line must be a non-empty synopsis",
+        ));
+    }
+    let mut description = synopsis.to_string();
+    for line in lines {
+        let line = line.trim_end();
+        if line.is_empty() {
+            description += "\n .";
+        } else {
+            description += "\n ";
+            description += line;
+        }
+    }
+    Ok(description)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn package(
+        name: &str,
+        description: &str,
+        translations: &[(&str, &str)],
+    ) -> TranslationPackage {
+        TranslationPackage {
+            name: name.to_string(),
+            sha256sum: format!("{name}sha256sum"),
+            description: Some(description.to_string()),
+            translations: Json(
+                translations
+                    .iter()
+                    .map(|(language, text)| (language.to_string(), text.to_string()))
+                    .collect(),
+            ),
+        }
+    }
+
+    #[test]
+    fn renders_translation_indexes() {
+        let packages = [
+            package("foo", "Foo tool\n Does foo.", &[("de", "Foo-Werkzeug\n Macht foo.")]),
+            package("bar", "Bar tool", &[]),
+        ];
+        let indexes = TranslationIndex::from_packages("main", &packages);
+        assert_eq!(
+            indexes.iter().map(|index| index.meta.path()).collect::<Vec<_>>(),
+            ["main/i18n/Translation-de", "main/i18n/Translation-en"]
+        );
+        let foo_md5 = hex::encode(Md5::digest("Foo tool\n Does foo.\n"));
+        let bar_md5 = hex::encode(Md5::digest("Bar tool\n"));
+        assert_eq!(
+            indexes[0].contents,
+            format!(
+                "Package: foo\nDescription-md5: {foo_md5}\nDescription-de: Foo-Werkzeug\n Macht foo.\n"
+            )
+        );
+        assert_eq!(
+            indexes[1].contents,
+            format!(
+                "Package: bar\nDescription-md5: {bar_md5}\nDescription-en: Bar tool\n\nPackage: foo\nDescription-md5: {foo_md5}\nDescription-en: Foo tool\n Does foo.\n"
+            )
+        );
+    }
+
+    #[test]
+    fn formats_descriptions() {
+        assert_eq!(
+            format_description("Synopsis\nFirst paragraph.\n\nSecond paragraph.\n\n"),
+            Ok(String::from("Synopsis\n First paragraph.\n .\n Second paragraph."))
+        );
+        assert!(format_description("\nNo synopsis").is_err());
+        assert!(validate_translation_language("pt_BR").is_ok());
+        assert!(validate_translation_language("../en").is_err());
+    }
+}
@@ -33,6 +33,26 @@ struct Args {
     /// the default user will not have an API token configured.
     #[arg(long, env = "ATTUNE_API_TOKEN")]
     default_api_token: Option<String>,
+    /// Allow packages to be fetched by URL from private network addresses.
+    ///
+    /// By default, packages added with `--from-url` can only be downloaded
+    /// from public addresses, so that tenants can't make the server send
+    /// requests to internal services. Only enable this if every tenant is
+    /// trusted.
+    #[arg(long, env = "ATTUNE_FETCH_ALLOW_PRIVATE_NETWORKS")]
+    fetch_allow_private_networks: bool,
+    /// S3 buckets that packages can be added from with `--from-uri`.
+    ///
+    /// Objects are read with the server's own credentials, so packages can
+    /// only be read from the buckets listed here. Buckets are separated by
+    /// commas.
+    #[arg(
+        long = "fetch-allowed-bucket",
+        env = "ATTUNE_FETCH_ALLOWED_BUCKETS",
+        value_name = "BUCKET",
+        value_delimiter = ','
+    )]
+    fetch_allowed_buckets: Vec<String>,
 }
 
 #[tokio::main]
@@ -77,6 +97,10 @@ async fn main() {
             db,
             s3,
             s3_bucket_name,
+            fetch: attune::server::pkg::fetch::FetchConfig {
+                allow_private_networks: args.fetch_allow_private_networks,
+                allowed_buckets: args.fetch_allowed_buckets,
+            },
         },
         args.default_api_token,
     )
@@ -0,0 +1,233 @@
+use std::{collections::BTreeSet, fmt, process::ExitCode};
+
+use axum::http::StatusCode;
+use clap::{Args, Subcommand};
+use colored::Colorize as _;
+use serde::Serialize;
+use serde_json::Value;
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::OutputFormat,
+};
+use attune::{
+    api::ErrorResponse,
+    server::schema::{SCHEMA_VERSION, openapi},
+};
+
+#[derive(Args, Debug)]
+pub struct ApiCommand {
+    #[command(subcommand)]
+    subcommand: ApiSubCommand,
+}
+
+#[derive(Subcommand, Debug)]
+pub enum ApiSubCommand {
+    /// Check for drift between the CLI's types and the API server's schemas
+    ///
+    /// The CLI and the API server share their request and response types, and
+    /// the OpenAPI document that the server publishes is generated from them.
+    /// This compares the CLI's copy of that document with the server's, and
+    /// exits with a non-zero status if any response schema that the CLI knows
+    /// about differs from the one advertised by the server.
+    Check,
+}
+
+pub async fn handle_api(ctx: Config, command: ApiCommand) -> ExitCode {
+    match command.subcommand {
+        ApiSubCommand::Check => check(ctx).await,
+    }
+}
+
+async fn check(ctx: Config) -> ExitCode {
+    let res = match ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/schema").unwrap())
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    let remote = match res.status() {
+        StatusCode::OK => res.json::<Value>().await.expect("Could not parse response"),
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            return ctx.api_error("fetching API schema", error);
+        }
+    };
+    let Some(report) = compare(&openapi(), &remote) else {
+        return ctx.error(
+            Failure::General,
+            "server schema document has no component schemas",
+        );
+    };
+
+    if let Some(output) = ctx.output.render(&report) {
+        println!("{output}");
+    } else if ctx.output == OutputFormat::Text {
+        println!("CLI schema version:    {}", report.cli_version);
+        println!("Server schema version: {}", report.server_version);
+        for schema in &report.schemas {
+            let name = &schema.name;
+            match schema.status {
+                SchemaStatus::Ok => println!("{} {name}", "ok     ".green()),
+                SchemaStatus::Changed => println!("{} {name}", "changed".red()),
+                SchemaStatus::Missing => {
+                    println!("{} {name} (not served by this server)", "missing".red());
+                }
+                SchemaStatus::New => {
+                    println!("{} {name} (unknown to this CLI)", "new    ".yellow());
+                }
+            }
+        }
+    } else {
+        let mut rows = vec![vec![String::from("Schema"), String::from("Status")]];
+        for schema in &report.schemas {
+            rows.push(vec![schema.name.clone(), schema.status.to_string()]);
+        }
+        println!("{}", ctx.output.table(rows));
+    }
+
+    if report.drifted() {
+        return ctx.error(
+            Failure::Validation,
+            "CLI types have drifted from the API server; upgrade the CLI or the server so that their versions match",
+        );
+    }
+    ExitCode::SUCCESS
+}
+
+/// How a response schema that the CLI or the server knows about compares
+/// between the two.
+#[derive(Serialize, Debug, Clone, Copy, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+enum SchemaStatus {
+    /// The CLI and the server have the same schema.
+    Ok,
+    /// The CLI and the server have different schemas.
+    Changed,
+    /// The CLI has a schema that the server doesn't serve.
+    Missing,
+    /// The server serves a schema that the CLI doesn't know about. This isn't
+    /// drift, since it can't break the CLI.
+    New,
+}
+
+impl fmt::Display for SchemaStatus {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            SchemaStatus::Ok => write!(f, "ok"),
+            SchemaStatus::Changed => write!(f, "changed"),
+            SchemaStatus::Missing => write!(f, "missing"),
+            SchemaStatus::New => write!(f, "new"),
+        }
+    }
+}
+
+#[derive(Serialize, Debug)]
+struct SchemaCheck {
+    name: String,
+    status: SchemaStatus,
+}
+
+#[derive(Serialize, Debug)]
+struct ApiCheckReport {
+    cli_version: String,
+    server_version: String,
+    schemas: Vec<SchemaCheck>,
+}
+
+impl ApiCheckReport {
+    fn drifted(&self) -> bool {
+        self.schemas
+            .iter()
+            .any(|schema| matches!(schema.status, SchemaStatus::Changed | SchemaStatus::Missing))
+    }
+}
+
+/// Compare the CLI's OpenAPI document with the server's, or return `None` if
+/// either has no component schemas.
+fn compare(local: &Value, remote: &Value) -> Option<ApiCheckReport> {
+    let local_schemas = local["components"]["schemas"].as_object()?;
+    let remote_schemas = remote["components"]["schemas"].as_object()?;
+    let names = local_schemas
+        .keys()
+        .chain(remote_schemas.keys())
+        .collect::<BTreeSet<_>>();
+    let schemas = names
+        .into_iter()
+        .map(|name| {
+            let status = match (local_schemas.get(name), remote_schemas.get(name)) {
+                (Some(local), Some(remote)) if local == remote => SchemaStatus::Ok,
+                (Some(_), Some(_)) => SchemaStatus::Changed,
+                (Some(_), None) => SchemaStatus::Missing,
+                (None, Some(_)) => SchemaStatus::New,
+                (None, None) => unreachable!("name came from one of the two maps"),
+            };
+            SchemaCheck {
+                name: name.clone(),
+                status,
+            }
+        })
+        .collect();
+    Some(ApiCheckReport {
+        cli_version: SCHEMA_VERSION.to_string(),
+        server_version: remote["info"]["version"]
+            .as_str()
+            .unwrap_or("unknown")
+            .to_string(),
+        schemas,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use serde_json::json;
+
+    use super::*;
+
+    #[test]
+    fn compare_schemas() {
+        let local = json!({
+            "components": { "schemas": {
+                "a": { "type": "object" },
+                "b": { "type": "object" },
+                "c": { "type": "string" },
+            } },
+        });
+        let remote = json!({
+            "info": { "version": "9.9.9" },
+            "components": { "schemas": {
+                "a": { "type": "object" },
+                "c": { "type": "integer" },
+                "d": { "type": "object" },
+            } },
+        });
+        let report = compare(&local, &remote).expect("both documents have schemas");
+        assert_eq!(report.server_version, "9.9.9");
+        assert_eq!(
+            report
+                .schemas
+                .iter()
+                .map(|schema| (schema.name.as_str(), schema.status))
+                .collect::<Vec<_>>(),
+            [
+                ("a", SchemaStatus::Ok),
+                ("b", SchemaStatus::Missing),
+                ("c", SchemaStatus::Changed),
+                ("d", SchemaStatus::New),
+            ]
+        );
+        assert!(report.drifted());
+
+        let report = compare(&local, &local).unwrap();
+        assert!(!report.drifted());
+        assert_eq!(report.server_version, "unknown");
+        assert!(compare(&local, &json!({})).is_none());
+    }
+}
@@ -0,0 +1,509 @@
+use std::{
+    collections::HashSet,
+    path::{Path, PathBuf},
+    process::ExitCode,
+};
+
+use clap::Args;
+use color_eyre::eyre::{Context as _, Result, bail};
+use colored::Colorize as _;
+use http::StatusCode;
+use inquire::Confirm;
+use percent_encoding::percent_encode;
+use serde::{Deserialize, Serialize, de::DeserializeOwned};
+use tracing::debug;
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+};
+use attune::{
+    api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    server::repo::{
+        create::{CreateRepositoryRequest, CreateRepositoryResponse},
+        dist::{
+            create::{CreateDistributionRequest, CreateDistributionResponse},
+            edit::{EditDistributionRequest, EditDistributionResponse},
+            list::ListDistributionsResponse,
+        },
+        list::{ListRepositoryRequest, ListRepositoryResponse},
+    },
+};
+
+#[derive(Args, Debug)]
+pub struct ApplyCommand {
+    /// Path to the YAML file describing the desired repositories
+    #[arg(long, short)]
+    file: PathBuf,
+
+    /// Print the plan without applying it
+    #[arg(long, conflicts_with = "yes")]
+    dry_run: bool,
+
+    /// Skip confirmation prompt and apply the plan
+    #[arg(short, long)]
+    yes: bool,
+}
+
+/// The declarative description of a tenant's repositories.
+///
+/// Only what's listed is managed: repositories and distributions that aren't
+/// listed, and distribution fields that are omitted, are left unchanged.
+/// Components aren't listed, since they're created when packages are
+/// published into them.
+#[derive(Serialize, Deserialize, Debug, Default, Clone, PartialEq, Eq)]
+#[serde(deny_unknown_fields)]
+pub struct Manifest {
+    #[serde(default)]
+    pub repositories: Vec<RepositoryManifest>,
+}
+
+#[derive(Serialize, Deserialize, Debug, Clone, PartialEq, Eq)]
+#[serde(deny_unknown_fields)]
+pub struct RepositoryManifest {
+    pub name: String,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub distributions: Vec<DistributionManifest>,
+}
+
+/// A distribution and its metadata.
+///
+/// When a distribution is created, an omitted suite or codename defaults to
+/// the distribution's name, as with `attune apt dist create`.
+#[derive(Serialize, Deserialize, Debug, Clone, PartialEq, Eq)]
+#[serde(deny_unknown_fields)]
+pub struct DistributionManifest {
+    pub name: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub suite: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub codename: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub origin: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub label: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub version: Option<String>,
+}
+
+/// A change needed to make the live state match the manifest.
+#[derive(Serialize, Debug, PartialEq, Eq)]
+#[serde(tag = "action", rename_all = "snake_case")]
+enum Change {
+    CreateRepository {
+        repository: String,
+    },
+    CreateDistribution {
+        repository: String,
+        distribution: DistributionManifest,
+    },
+    EditDistribution {
+        repository: String,
+        distribution: String,
+        /// Each changed field, with its current and desired values.
+        fields: Vec<FieldChange>,
+    },
+}
+
+#[derive(Serialize, Debug, PartialEq, Eq)]
+struct FieldChange {
+    field: &'static str,
+    from: Option<String>,
+    to: String,
+}
+
+pub async fn run(ctx: Config, command: ApplyCommand) -> ExitCode {
+    let desired = match read_manifest(&command.file) {
+        Ok(desired) => desired,
+        Err(error) => return ctx.error(Failure::Usage, format!("{error:#}")),
+    };
+    let live = match fetch_live(&ctx).await {
+        Ok(live) => live,
+        Err(error) => return ctx.report_error("fetching current state", error),
+    };
+    let plan = plan(&live, &desired);
+
+    let rendered = ctx.output.render(&plan);
+    match &rendered {
+        Some(rendered) if command.dry_run => {
+            println!("{rendered}");
+            return ExitCode::SUCCESS;
+        }
+        Some(_) => {}
+        None => print_plan(&plan),
+    }
+    if plan.is_empty() || command.dry_run {
+        return ExitCode::SUCCESS;
+    }
+    if !command.yes {
+        let confirm = Confirm::new(&format!("Apply {} changes?", plan.len()))
+            .with_default(false)
+            .prompt();
+        match confirm {
+            Ok(true) => {}
+            Ok(false) => return ExitCode::SUCCESS,
+            Err(e) => {
+                eprintln!("Aborting: {e}");
+                return ExitCode::FAILURE;
+            }
+        }
+    }
+
+    for change in &plan {
+        if let Err(error) = apply(&ctx, change).await {
+            return ctx.report_error("applying plan", error);
+        }
+    }
+    match rendered {
+        Some(rendered) => println!("{rendered}"),
+        None => ctx.status(format!("{} {} changes", "Applied".green(), plan.len())),
+    }
+    ExitCode::SUCCESS
+}
+
+fn read_manifest(path: &Path) -> Result<Manifest> {
+    let contents = std::fs::read_to_string(path).with_context(|| format!("read {path:?}"))?;
+    let manifest =
+        serde_yaml::from_str::<Manifest>(&contents).with_context(|| format!("parse {path:?}"))?;
+
+    let mut repositories = HashSet::new();
+    for repo in &manifest.repositories {
+        if !repositories.insert(&repo.name) {
+            bail!("repository {:?} is listed more than once", repo.name);
+        }
+        let mut distributions = HashSet::new();
+        for dist in &repo.distributions {
+            if !distributions.insert(&dist.name) {
+                bail!(
+                    "distribution {:?} is listed more than once in repository {:?}",
+                    dist.name,
+                    repo.name
+                );
+            }
+        }
+    }
+    Ok(manifest)
+}
+
+/// Fetch every repository and distribution, with all of their metadata.
+pub async fn fetch_live(ctx: &Config) -> Result<Manifest> {
+    let res = ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/repositories").unwrap())
+        .json(&ListRepositoryRequest { name: None })
+        .send_retrying(ctx)
+        .await
+        .context("send api request")?;
+    let mut repositories = Vec::new();
+    for repo in parse_response::<ListRepositoryResponse>(res)
+        .await?
+        .repositories
+    {
+        let res = ctx
+            .client
+            .get(ctx.endpoint.join(&dists_path(&repo.name, None)).unwrap())
+            .send_retrying(ctx)
+            .await
+            .context("send api request")?;
+        let mut distributions = parse_response::<ListDistributionsResponse>(res)
+            .await?
+            .distributions
+            .into_iter()
+            .map(|dist| DistributionManifest {
+                name: dist.distribution,
+                suite: Some(dist.suite),
+                codename: Some(dist.codename),
+                description: dist.description,
+                origin: dist.origin,
+                label: dist.label,
+                version: dist.version,
+            })
+            .collect::<Vec<_>>();
+        distributions.sort_by(|a, b| a.name.cmp(&b.name));
+        repositories.push(RepositoryManifest {
+            name: repo.name,
+            distributions,
+        });
+    }
+    repositories.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(Manifest { repositories })
+}
+
+/// Work out the changes that make `live` match `desired`, in the order they
+/// must be applied.
+fn plan(live: &Manifest, desired: &Manifest) -> Vec<Change> {
+    let mut changes = Vec::new();
+    for repo in &desired.repositories {
+        let live_repo = live.repositories.iter().find(|live| live.name == repo.name);
+        if live_repo.is_none() {
+            changes.push(Change::CreateRepository {
+                repository: repo.name.clone(),
+            });
+        }
+        for dist in &repo.distributions {
+            let live_dist =
+                live_repo.and_then(|live| live.distributions.iter().find(|d| d.name == dist.name));
+            let Some(live_dist) = live_dist else {
+                changes.push(Change::CreateDistribution {
+                    repository: repo.name.clone(),
+                    distribution: dist.clone(),
+                });
+                continue;
+            };
+            let fields = [
+                ("suite", &live_dist.suite, &dist.suite),
+                ("codename", &live_dist.codename, &dist.codename),
+                ("description", &live_dist.description, &dist.description),
+                ("origin", &live_dist.origin, &dist.origin),
+                ("label", &live_dist.label, &dist.label),
+                ("version", &live_dist.version, &dist.version),
+            ]
+            .into_iter()
+            .filter_map(|(field, from, to)| match to {
+                Some(to) if from.as_ref() != Some(to) => Some(FieldChange {
+                    field,
+                    from: from.clone(),
+                    to: to.clone(),
+                }),
+                _ => None,
+            })
+            .collect::<Vec<_>>();
+            if !fields.is_empty() {
+                changes.push(Change::EditDistribution {
+                    repository: repo.name.clone(),
+                    distribution: dist.name.clone(),
+                    fields,
+                });
+            }
+        }
+    }
+    changes
+}
+
+fn print_plan(plan: &[Change]) {
+    if plan.is_empty() {
+        println!("No changes; the live state matches the file");
+        return;
+    }
+    for change in plan {
+        match change {
+            Change::CreateRepository { repository } => {
+                println!("{} repository {repository}", "+".green());
+            }
+            Change::CreateDistribution {
+                repository,
+                distribution,
+            } => {
+                println!(
+                    "{} distribution {repository}/{}",
+                    "+".green(),
+                    distribution.name
+                );
+            }
+            Change::EditDistribution {
+                repository,
+                distribution,
+                fields,
+            } => {
+                println!("{} distribution {repository}/{distribution}", "~".yellow());
+                for field in fields {
+                    println!(
+                        "    {}: {:?} -> {:?}",
+                        field.field,
+                        field.from.as_deref().unwrap_or(""),
+                        field.to
+                    );
+                }
+            }
+        }
+    }
+}
+
+async fn apply(ctx: &Config, change: &Change) -> Result<()> {
+    match change {
+        Change::CreateRepository { repository } => {
+            let res = ctx
+                .client
+                .post(ctx.endpoint.join("/api/v0/repositories").unwrap())
+                .json(&CreateRepositoryRequest {
+                    name: repository.clone(),
+                    flat: false,
+                })
+                .send_retrying(ctx)
+                .await
+                .context("send api request")?;
+            parse_response::<CreateRepositoryResponse>(res).await?;
+            ctx.status(format!("{} repository {repository}", "Created".green()));
+        }
+        Change::CreateDistribution {
+            repository,
+            distribution,
+        } => {
+            let request = CreateDistributionRequest::builder()
+                .name(&distribution.name)
+                .suite(distribution.suite.as_ref().unwrap_or(&distribution.name))
+                .codename(distribution.codename.as_ref().unwrap_or(&distribution.name))
+                .maybe_description(distribution.description.clone())
+                .maybe_origin(distribution.origin.clone())
+                .maybe_label(distribution.label.clone())
+                .maybe_version(distribution.version.clone())
+                .build();
+            let res = ctx
+                .client
+                .post(ctx.endpoint.join(&dists_path(repository, None)).unwrap())
+                .json(&request)
+                .send_retrying(ctx)
+                .await
+                .context("send api request")?;
+            parse_response::<CreateDistributionResponse>(res).await?;
+            ctx.status(format!(
+                "{} distribution {repository}/{}",
+                "Created".green(),
+                distribution.name
+            ));
+        }
+        Change::EditDistribution {
+            repository,
+            distribution,
+            fields,
+        } => {
+            let value = |name: &str| {
+                fields
+                    .iter()
+                    .find(|field| field.field == name)
+                    .map(|field| field.to.clone())
+            };
+            let request = EditDistributionRequest::builder()
+                .maybe_suite(value("suite"))
+                .maybe_codename(value("codename"))
+                .maybe_description(value("description"))
+                .maybe_origin(value("origin"))
+                .maybe_label(value("label"))
+                .maybe_version(value("version"))
+                .build();
+            let res = ctx
+                .client
+                .put(
+                    ctx.endpoint
+                        .join(&dists_path(repository, Some(distribution)))
+                        .unwrap(),
+                )
+                .json(&request)
+                .send_retrying(ctx)
+                .await
+                .context("send api request")?;
+            parse_response::<EditDistributionResponse>(res).await?;
+            ctx.status(format!(
+                "{} distribution {repository}/{distribution}",
+                "Updated".yellow()
+            ));
+        }
+    }
+    Ok(())
+}
+
+fn dists_path(repository: &str, distribution: Option<&str>) -> String {
+    let path = format!(
+        "/api/v0/repositories/{}/distributions",
+        percent_encode(repository.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+    );
+    match distribution {
+        Some(dist) => format!(
+            "{path}/{}",
+            percent_encode(dist.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+        ),
+        None => path,
+    }
+}
+
+/// Parse a successful response, or turn an error response into an error.
+async fn parse_response<T: DeserializeOwned>(res: reqwest::Response) -> Result<T> {
+    match res.status() {
+        StatusCode::OK => res.json::<T>().await.context("parse response"),
+        status => {
+            let body = res.text().await.context("read response")?;
+            debug!(?body, ?status, "error response");
+            let error =
+                serde_json::from_str::<ErrorResponse>(&body).context("parse error response")?;
+            bail!(error);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn dist(name: &str) -> DistributionManifest {
+        DistributionManifest {
+            name: name.to_string(),
+            suite: None,
+            codename: None,
+            description: None,
+            origin: None,
+            label: None,
+            version: None,
+        }
+    }
+
+    #[test]
+    fn plans_only_listed_changes() {
+        let live = Manifest {
+            repositories: vec![RepositoryManifest {
+                name: String::from("existing"),
+                distributions: vec![DistributionManifest {
+                    suite: Some(String::from("stable")),
+                    codename: Some(String::from("stable")),
+                    origin: Some(String::from("Example")),
+                    ..dist("stable")
+                }],
+            }],
+        };
+        let desired = serde_yaml::from_str::<Manifest>(
+            r#"
+repositories:
+  - name: existing
+    distributions:
+      - name: stable
+        suite: stable
+        label: Example
+      - name: testing
+  - name: new
+"#,
+        )
+        .unwrap();
+
+        assert_eq!(
+            plan(&live, &desired),
+            vec![
+                Change::EditDistribution {
+                    repository: String::from("existing"),
+                    distribution: String::from("stable"),
+                    fields: vec![FieldChange {
+                        field: "label",
+                        from: None,
+                        to: String::from("Example"),
+                    }],
+                },
+                Change::CreateDistribution {
+                    repository: String::from("existing"),
+                    distribution: dist("testing"),
+                },
+                Change::CreateRepository {
+                    repository: String::from("new"),
+                },
+            ]
+        );
+        assert_eq!(plan(&live, &live), vec![]);
+    }
+
+    #[test]
+    fn rejects_unknown_fields() {
+        let error =
+            serde_yaml::from_str::<Manifest>("repositories:\n  - name: repo\n    retention: 30d\n")
+                .unwrap_err();
+        assert!(error.to_string().contains("unknown field"), "{error}");
+    }
+}
@@ -2,15 +2,18 @@ use clap::Args;
 
 use crate::{
     cmd::apt::dist::{build_distribution_url, handle_api_response},
-    config::Config,
+    config::{Config, SendRetrying as _},
+    exit::{CommandError, Failure},
 };
 use attune::server::repo::dist::create::{CreateDistributionRequest, CreateDistributionResponse};
 
 #[derive(Args, Debug)]
 pub struct CreateArgs {
     /// The repository in which to create the distribution.
-    #[arg(long)]
-    repo: String,
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, env = "ATTUNE_REPO")]
+    repo: Option<String>,
 
     /// The name of the distribution.
     ///
@@ -56,7 +59,8 @@ pub struct DistMetadata {
     version: Option<String>,
 }
 
-pub async fn run(ctx: Config, args: CreateArgs) -> Result<String, String> {
+pub async fn run(ctx: Config, args: CreateArgs) -> Result<String, CommandError> {
+    let repo = ctx.repo(args.repo)?;
     let request = CreateDistributionRequest::builder()
         .suite(args.suite.unwrap_or_else(|| args.name.clone()))
         .codename(args.codename.unwrap_or_else(|| args.name.clone()))
@@ -67,16 +71,22 @@ pub async fn run(ctx: Config, args: CreateArgs) -> Result<String, String> {
         .maybe_version(args.metadata.version)
         .build();
 
-    let url = build_distribution_url(&ctx, &args.repo, None);
-    ctx.client
+    let url = build_distribution_url(&ctx, &repo, None);
+    let response = ctx
+        .client
         .post(url)
         .json(&request)
-        .send()
+        .send_retrying(&ctx)
         .await
         .map(handle_api_response::<CreateDistributionResponse>)
-        .map_err(|err| format!("Failed to send request: {err}"))?
-        .await
-        .map(|CreateDistributionResponse { distribution, .. }| {
-            format!("Distribution {distribution:?} created successfully")
-        })
+        .map_err(|err| {
+            CommandError::new(Failure::Network, format!("Failed to send request: {err}"))
+        })?
+        .await?;
+    Ok(ctx.output.render(&response).unwrap_or_else(|| {
+        format!(
+            "Distribution {:?} created successfully",
+            response.distribution
+        )
+    }))
 }
@@ -4,42 +4,79 @@ use inquire::Confirm;
 
 use crate::{
     cmd::apt::dist::{build_distribution_url, handle_api_response},
-    config::Config,
+    config::{Config, SendRetrying as _},
+    exit::{CommandError, Failure},
 };
-use attune::server::repo::dist::delete::DeleteDistributionResponse;
+use attune::server::repo::dist::delete::{DeleteDistributionParams, DeleteDistributionResponse};
 
 #[derive(Args, Debug)]
 pub struct DeleteArgs {
     /// The repository containing the distribution.
-    #[arg(long)]
-    repo: String,
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, env = "ATTUNE_REPO")]
+    repo: Option<String>,
     /// The name of the distribution to delete.
     #[arg(long)]
     name: String,
+
+    /// Delete the distribution even if packages are still published in it.
+    ///
+    /// Packages that aren't published in any other distribution are deleted
+    /// too.
+    #[arg(long)]
+    force: bool,
+
+    /// Skip confirmation prompt and proceed with deletion
+    #[arg(short, long)]
+    yes: bool,
 }
 
-pub async fn run(ctx: Config, args: DeleteArgs) -> Result<String, String> {
-    println!("{}", format!(
+pub async fn run(ctx: Config, args: DeleteArgs) -> Result<String, CommandError> {
+    let repo = ctx.repo(args.repo)?;
+    eprintln!("{}", format!(
         "Warning: This will irreversibly delete distribution {:?} from repository {:?} and all its components, package indexes, and package associations.",
         args.name,
-        args.repo
+        repo
     ).red());
 
-    let confirmed = Confirm::new("Are you sure you want to proceed?")
-        .with_default(false)
-        .prompt()
-        .map_err(|e| format!("Confirmation failed: {e}"))?;
-    if !confirmed {
-        return Ok(String::from("Operation cancelled"));
+    if !args.yes {
+        let confirmed = Confirm::new("Are you sure you want to proceed?")
+            .with_default(false)
+            .prompt()
+            .map_err(|e| format!("Confirmation failed: {e}"))?;
+        if !confirmed {
+            return Ok(String::from("Operation cancelled"));
+        }
     }
 
-    let url = build_distribution_url(&ctx, &args.repo, Some(&args.name));
-    ctx.client
+    let url = build_distribution_url(&ctx, &repo, Some(&args.name));
+    let response = ctx
+        .client
         .delete(url)
-        .send()
+        .query(&DeleteDistributionParams { force: args.force })
+        .send_retrying(&ctx)
         .await
         .map(handle_api_response::<DeleteDistributionResponse>)
-        .map_err(|err| format!("Failed to send request: {err}"))?
+        .map_err(|err| {
+            CommandError::new(Failure::Network, format!("Failed to send request: {err}"))
+        })?
         .await
-        .map(|_| format!("Distribution {:?} deleted successfully", args.name))
+        .map_err(|mut err| {
+            if err
+                .response
+                .as_ref()
+                .is_some_and(|res| res.error == "DISTRIBUTION_NOT_EMPTY")
+            {
+                err.message = format!(
+                    "{}\nRemove its packages with `attune apt pkg remove`, or pass --force to delete them along with the distribution.",
+                    err.message
+                );
+            }
+            err
+        })?;
+    Ok(ctx
+        .output
+        .render(&response)
+        .unwrap_or_else(|| format!("Distribution {:?} deleted successfully", args.name)))
 }
@@ -0,0 +1,257 @@
+use std::path::PathBuf;
+
+use clap::{Args, Subcommand};
+
+use crate::{
+    cmd::apt::dist::{build_distribution_url, handle_api_response},
+    config::{Config, SendRetrying as _},
+    exit::{CommandError, Failure},
+    output::{ColumnArgs, OutputFormat},
+};
+use attune::{
+    apt::gzip,
+    server::repo::dist::dep11::{
+        Dep11FileInfo, Dep11FileResponse, Dep11FileStatus, ListDep11FilesResponse,
+    },
+};
+
+#[derive(Args, Debug)]
+pub struct Dep11Command {
+    #[command(subcommand)]
+    subcommand: Dep11SubCommand,
+}
+
+#[derive(Subcommand, Debug)]
+pub enum Dep11SubCommand {
+    /// Upload DEP-11 files to a component
+    ///
+    /// Files are named like the files that clients fetch from the component's
+    /// `dep11/` directory, such as `Components-amd64.yml.gz` or
+    /// `icons-64x64.tar.gz`. Uncompressed `.yml` and `.json` files are gzipped
+    /// before they're uploaded.
+    Upload(UploadArgs),
+
+    /// Show a distribution's DEP-11 files
+    #[command(visible_alias = "ls")]
+    List(ListArgs),
+
+    /// Delete DEP-11 files from a component
+    #[command(visible_alias = "rm")]
+    Remove(RemoveArgs),
+}
+
+#[derive(Args, Debug)]
+pub struct UploadArgs {
+    /// The repository containing the distribution.
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, env = "ATTUNE_REPO")]
+    repo: Option<String>,
+    /// The name of the distribution.
+    #[arg(long)]
+    name: String,
+    /// The component to publish the files in.
+    #[arg(long, short, default_value = "main")]
+    component: String,
+
+    /// The files to upload, usually generated by `appstream-generator`.
+    #[arg(required = true)]
+    files: Vec<PathBuf>,
+}
+
+#[derive(Args, Debug)]
+pub struct ListArgs {
+    /// The repository containing the distribution.
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, env = "ATTUNE_REPO")]
+    repo: Option<String>,
+    /// The name of the distribution.
+    #[arg(long)]
+    name: String,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+#[derive(Args, Debug)]
+pub struct RemoveArgs {
+    /// The repository containing the distribution.
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, env = "ATTUNE_REPO")]
+    repo: Option<String>,
+    /// The name of the distribution.
+    #[arg(long)]
+    name: String,
+    /// The component to delete the files from.
+    #[arg(long, short, default_value = "main")]
+    component: String,
+
+    /// The names of the files to delete, like `Components-amd64.yml.gz`.
+    #[arg(required = true)]
+    files: Vec<String>,
+}
+
+pub async fn run(ctx: Config, command: Dep11Command) -> Result<String, CommandError> {
+    match command.subcommand {
+        Dep11SubCommand::Upload(args) => upload(ctx, args).await,
+        Dep11SubCommand::List(args) => list(ctx, args).await,
+        Dep11SubCommand::Remove(args) => remove(ctx, args).await,
+    }
+}
+
+/// Build the URL of a DEP-11 file, or of a distribution's DEP-11 files if
+/// `file` is not set.
+fn build_dep11_url(
+    ctx: &Config,
+    repository: &str,
+    distribution: &str,
+    file: Option<(&str, &str)>,
+) -> reqwest::Url {
+    let mut url = build_distribution_url(ctx, repository, Some(distribution));
+    {
+        let mut segments = url.path_segments_mut().expect("Invalid URL construction");
+        segments.push("dep11");
+        if let Some((component, name)) = file {
+            segments.push(component).push(name);
+        }
+    }
+    url
+}
+
+async fn upload(ctx: Config, args: UploadArgs) -> Result<String, CommandError> {
+    let repo = ctx.repo(args.repo)?;
+    let mut files = Vec::new();
+    for path in args.files {
+        let Some(name) = path.file_name().and_then(|name| name.to_str()) else {
+            return Err(CommandError::new(Failure::Usage, format!("invalid file name: {path:?}")));
+        };
+        let contents = std::fs::read(&path).map_err(|error| {
+            CommandError::new(Failure::Usage, format!("could not read {path:?}: {error}"))
+        })?;
+        let (name, contents) = if name.ends_with(".yml") || name.ends_with(".json") {
+            (format!("{name}.gz"), gzip(&contents))
+        } else {
+            (name.to_string(), contents)
+        };
+
+        let url = build_dep11_url(
+            &ctx,
+            &repo,
+            &args.name,
+            Some((args.component.as_str(), name.as_str())),
+        );
+        let response = ctx
+            .client
+            .put(url)
+            .body(contents)
+            .send_retrying(&ctx)
+            .await
+            .map(handle_api_response::<Dep11FileResponse>)
+            .map_err(|err| {
+                CommandError::new(Failure::Network, format!("Failed to send request: {err}"))
+            })?
+            .await?;
+        files.push(response.result);
+    }
+    Ok(render_changed(&ctx, files))
+}
+
+async fn list(ctx: Config, args: ListArgs) -> Result<String, CommandError> {
+    let repo = ctx.repo(args.repo)?;
+    let url = build_dep11_url(&ctx, &repo, &args.name, None);
+    let response = ctx
+        .client
+        .get(url)
+        .send_retrying(&ctx)
+        .await
+        .map(handle_api_response::<ListDep11FilesResponse>)
+        .map_err(|err| {
+            CommandError::new(Failure::Network, format!("Failed to send request: {err}"))
+        })?
+        .await?;
+    if let Some(output) = ctx.output.render(&response) {
+        return Ok(output);
+    }
+
+    if response.files.is_empty() && ctx.output == OutputFormat::Text {
+        return Ok(format!("No DEP-11 files found in distribution {:?}", args.name));
+    }
+
+    let mut rows = vec![
+        ["Component", "Name", "Size", "Status", "SHA256"]
+            .map(String::from)
+            .to_vec(),
+    ];
+    for file in response.files {
+        rows.push(vec![
+            file.component,
+            file.name,
+            file.size.to_string(),
+            status_label(file.status).to_string(),
+            file.sha256sum,
+        ]);
+    }
+    let rows = args
+        .columns
+        .select(rows, &[])
+        .map_err(|error| CommandError::new(Failure::Usage, error))?;
+    Ok(ctx.output.table(rows))
+}
+
+async fn remove(ctx: Config, args: RemoveArgs) -> Result<String, CommandError> {
+    let repo = ctx.repo(args.repo)?;
+    let mut files = Vec::new();
+    for name in &args.files {
+        let url = build_dep11_url(
+            &ctx,
+            &repo,
+            &args.name,
+            Some((args.component.as_str(), name.as_str())),
+        );
+        let response = ctx
+            .client
+            .delete(url)
+            .send_retrying(&ctx)
+            .await
+            .map(handle_api_response::<Dep11FileResponse>)
+            .map_err(|err| {
+                CommandError::new(Failure::Network, format!("Failed to send request: {err}"))
+            })?
+            .await?;
+        files.push(response.result);
+    }
+    Ok(render_changed(&ctx, files))
+}
+
+/// Render the files that were uploaded or deleted.
+fn render_changed(ctx: &Config, files: Vec<Dep11FileInfo>) -> String {
+    let response = ListDep11FilesResponse { files };
+    if let Some(output) = ctx.output.render(&response) {
+        return output;
+    }
+    let mut lines = response
+        .files
+        .iter()
+        .map(|file| {
+            let action = match file.status {
+                Dep11FileStatus::Removed => "Deleted",
+                Dep11FileStatus::Published | Dep11FileStatus::Pending => "Uploaded",
+            };
+            format!("{action} {}/dep11/{}", file.component, file.name)
+        })
+        .collect::<Vec<_>>();
+    lines.push(String::from(
+        "Note: DEP-11 files are published the next time a package is added to or removed from the distribution.",
+    ));
+    lines.join("\n")
+}
+
+fn status_label(status: Dep11FileStatus) -> &'static str {
+    match status {
+        Dep11FileStatus::Published => "published",
+        Dep11FileStatus::Pending => "pending",
+        Dep11FileStatus::Removed => "removed",
+    }
+}
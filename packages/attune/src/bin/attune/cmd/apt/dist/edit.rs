@@ -2,15 +2,18 @@ use clap::Args;
 
 use crate::{
     cmd::apt::dist::{build_distribution_url, handle_api_response},
-    config::Config,
+    config::{Config, SendRetrying as _},
+    exit::{CommandError, Failure},
 };
 use attune::server::repo::dist::edit::{EditDistributionRequest, EditDistributionResponse};
 
 #[derive(Args, Debug)]
 pub struct EditArgs {
     /// The repository containing the distribution.
-    #[arg(long)]
-    repo: String,
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, env = "ATTUNE_REPO")]
+    repo: Option<String>,
     /// The name of the distribution to edit.
     #[arg(long)]
     name: String,
@@ -41,7 +44,8 @@ pub struct EditMetadata {
     codename: Option<String>,
 }
 
-pub async fn run(ctx: Config, args: EditArgs) -> Result<String, String> {
+pub async fn run(ctx: Config, args: EditArgs) -> Result<String, CommandError> {
+    let repo = ctx.repo(args.repo)?;
     let request = EditDistributionRequest::builder()
         .maybe_description(args.metadata.description)
         .maybe_origin(args.metadata.origin)
@@ -52,27 +56,31 @@ pub async fn run(ctx: Config, args: EditArgs) -> Result<String, String> {
         .build();
 
     if !request.any_some() {
-        return Err(String::from(
+        return Err(CommandError::new(
+            Failure::Usage,
             "No fields to update provided. Use --help to see available options.",
         ));
     }
 
-    let url = build_distribution_url(&ctx, &args.repo, Some(&args.name));
-    ctx.client
+    let url = build_distribution_url(&ctx, &repo, Some(&args.name));
+    let response = ctx
+        .client
         .put(url)
         .json(&request)
-        .send()
+        .send_retrying(&ctx)
         .await
         .map(handle_api_response::<EditDistributionResponse>)
-        .map_err(|err| format!("Failed to send request: {err}"))?
-        .await
-        .map(|EditDistributionResponse { distribution, .. }| {
-            format!(
-                concat!(
-                    "Distribution {:?} updated successfully\n",
-                    "Note: Changes will be reflected in repository indexes after the next sync."
-                ),
-                distribution
-            )
-        })
+        .map_err(|err| {
+            CommandError::new(Failure::Network, format!("Failed to send request: {err}"))
+        })?
+        .await?;
+    Ok(ctx.output.render(&response).unwrap_or_else(|| {
+        format!(
+            concat!(
+                "Distribution {:?} updated successfully\n",
+                "Note: Changes will be reflected in repository indexes after the next sync."
+            ),
+            response.distribution
+        )
+    }))
 }
@@ -1,49 +1,61 @@
 use clap::Args;
-use tabled::settings::Style;
 
 use crate::{
     cmd::apt::dist::{build_distribution_url, handle_api_response},
-    config::Config,
+    config::{Config, SendRetrying as _},
+    exit::{CommandError, Failure},
+    output::{ColumnArgs, OutputFormat},
 };
 use attune::server::repo::dist::list::ListDistributionsResponse;
 
 #[derive(Args, Debug)]
 pub struct ListArgs {
     /// The name of the repository.
-    #[arg(long)]
-    repo: String,
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, env = "ATTUNE_REPO")]
+    repo: Option<String>,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
 }
 
-pub async fn run(ctx: Config, args: ListArgs) -> Result<String, String> {
-    let url = build_distribution_url(&ctx, &args.repo, None);
+pub async fn run(ctx: Config, args: ListArgs) -> Result<String, CommandError> {
+    let repo = ctx.repo(args.repo)?;
+    let url = build_distribution_url(&ctx, &repo, None);
     let response = ctx
         .client
         .get(url)
-        .send()
+        .send_retrying(&ctx)
         .await
         .map(handle_api_response::<ListDistributionsResponse>)
-        .map_err(|err| format!("Failed to send request: {err}"))?
+        .map_err(|err| {
+            CommandError::new(Failure::Network, format!("Failed to send request: {err}"))
+        })?
         .await?;
+    if let Some(output) = ctx.output.render(&response) {
+        return Ok(output);
+    }
 
-    if response.distributions.is_empty() {
-        return Ok(format!(
-            "No distributions found in repository {:?}",
-            args.repo
-        ));
+    if response.distributions.is_empty() && ctx.output == OutputFormat::Text {
+        return Ok(format!("No distributions found in repository {:?}", repo));
     }
 
-    let mut builder = tabled::builder::Builder::new();
-    builder.push_record([
-        "Name",
-        "Suite",
-        "Codename",
-        "Description",
-        "Origin",
-        "Label",
-        "Version",
-    ]);
+    let mut rows = vec![
+        [
+            "Name",
+            "Suite",
+            "Codename",
+            "Description",
+            "Origin",
+            "Label",
+            "Version",
+        ]
+        .map(String::from)
+        .to_vec(),
+    ];
     for dist in response.distributions {
-        builder.push_record([
+        rows.push(vec![
             dist.distribution,
             dist.suite,
             dist.codename,
@@ -53,8 +65,9 @@ pub async fn run(ctx: Config, args: ListArgs) -> Result<String, String> {
             dist.version.unwrap_or(String::from("(unset)")),
         ]);
     }
-
-    let mut table = builder.build();
-    table.with(Style::modern());
-    Ok(table.to_string())
+    let rows = args
+        .columns
+        .select(rows, &[])
+        .map_err(|error| CommandError::new(Failure::Usage, error))?;
+    Ok(ctx.output.table(rows))
 }
@@ -2,11 +2,12 @@ use axum::http::StatusCode;
 use clap::{Args, Subcommand};
 use percent_encoding::percent_encode;
 
-use crate::config::Config;
+use crate::{config::Config, exit::CommandError};
 use attune::api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET};
 
 mod create;
 mod delete;
+mod dep11;
 mod edit;
 mod list;
 mod resync;
@@ -51,15 +52,24 @@ pub enum DistSubCommand {
     /// This is only useful for self-hosted instances. This is primarily for
     /// restoring repository state after very rare race conditions or crashes.
     Resync(resync::DistResyncCommand),
+
+    /// Manage DEP-11 (AppStream) metadata
+    ///
+    /// Software centers like GNOME Software and KDE Discover show rich entries
+    /// for packages from the AppStream metadata in each component's `dep11/`
+    /// directory. Generate the metadata with a tool like
+    /// `appstream-generator`, and upload it here.
+    Dep11(dep11::Dep11Command),
 }
 
-pub async fn handle_dist(ctx: Config, command: DistCommand) -> Result<String, String> {
+pub async fn handle_dist(ctx: Config, command: DistCommand) -> Result<String, CommandError> {
     match command.subcommand {
         DistSubCommand::Create(args) => create::run(ctx, args).await,
         DistSubCommand::List(args) => list::run(ctx, args).await,
         DistSubCommand::Edit(args) => edit::run(ctx, args).await,
         DistSubCommand::Delete(args) => delete::run(ctx, args).await,
         DistSubCommand::Resync(args) => resync::run(ctx, args).await,
+        DistSubCommand::Dep11(args) => dep11::run(ctx, args).await,
     }
 }
 
@@ -87,20 +97,21 @@ fn build_distribution_url(
 }
 
 /// Handle API response, accounting for the structured error type.
-async fn handle_api_response<T>(response: reqwest::Response) -> Result<T, String>
+async fn handle_api_response<T>(response: reqwest::Response) -> Result<T, CommandError>
 where
     T: for<'de> serde::Deserialize<'de>,
 {
     if response.status() == StatusCode::OK {
-        response
+        Ok(response
             .json::<T>()
             .await
-            .map_err(|e| format!("Failed to parse API response: {e}"))
+            .map_err(|e| format!("Failed to parse API response: {e}"))?)
     } else {
-        response
+        let err = response
             .json::<ErrorResponse>()
             .await
-            .map(|err| Err(format!("API error: {}", err.message)))
-            .map_err(|err| format!("Failed to parse error response: {err}"))?
+            .map_err(|err| format!("Failed to parse error response: {err}"))?;
+        let message = format!("API error: {}", err.message);
+        Err(CommandError::from_response(err, message))
     }
 }
@@ -2,7 +2,10 @@ use axum::http::StatusCode;
 use clap::Args;
 use percent_encoding::percent_encode;
 
-use crate::config::Config;
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::{CommandError, Failure},
+};
 use attune::{
     api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
     server::repo::sync::resync::ResyncRepositoryResponse,
@@ -11,8 +14,10 @@ use attune::{
 #[derive(Args, Debug)]
 pub struct DistResyncCommand {
     /// The repository containing the distribution.
-    #[arg(long)]
-    repo: String,
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, env = "ATTUNE_REPO")]
+    repo: Option<String>,
     /// The name of the distribution to resync.
     #[arg(long)]
     name: String,
@@ -21,36 +26,43 @@ pub struct DistResyncCommand {
 // TODO: We should move this command behind an EE or self-hosted build of the
 // CLI, because it doesn't make sense for cloud-hosted users to see this
 // command.
-pub async fn run(ctx: Config, cmd: DistResyncCommand) -> Result<String, String> {
+pub async fn run(ctx: Config, cmd: DistResyncCommand) -> Result<String, CommandError> {
+    let repo = ctx.repo(cmd.repo)?;
     let res = ctx
         .client
         .get(
             ctx.endpoint
                 .join(&format!(
                     "/api/v0/repositories/{}/distributions/{}/sync",
-                    percent_encode(cmd.repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET),
+                    percent_encode(repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET),
                     percent_encode(cmd.name.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
                 ))
                 .unwrap(),
         )
-        .send()
+        .send_retrying(&ctx)
         .await
-        .expect("Could not send API request");
+        .map_err(|err| {
+            CommandError::new(Failure::Network, format!("Failed to send request: {err}"))
+        })?;
     match res.status() {
         StatusCode::OK => {
-            let _repo = res
+            let res = res
                 .json::<ResyncRepositoryResponse>()
                 .await
                 .expect("Could not parse response");
             // TODO: Print something informative about what was resynchronized.
-            Ok(format!("Distribution {:?} resynced!", cmd.name))
+            Ok(ctx
+                .output
+                .render(&res)
+                .unwrap_or_else(|| format!("Distribution {:?} resynced!", cmd.name)))
         }
         _ => {
             let error = res
                 .json::<ErrorResponse>()
                 .await
                 .expect("Could not parse error response");
-            Err(format!("error resyncing distribution: {}", error.message))
+            let message = format!("error resyncing distribution: {}", error.message);
+            Err(CommandError::from_response(error, message))
         }
     }
 }
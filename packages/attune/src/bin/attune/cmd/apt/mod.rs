@@ -5,8 +5,9 @@ use clap::{Args, Subcommand};
 use crate::config::Config;
 
 mod dist;
-mod pkg;
+pub mod pkg;
 mod repo;
+pub mod targets;
 
 #[derive(Args, Debug)]
 pub struct AptCommand {
@@ -31,6 +32,17 @@ pub enum AptSubcommand {
     Package(pkg::PkgCommand),
 }
 
+impl AptCommand {
+    /// Whether the command works without the API server, so it doesn't need an
+    /// API token.
+    pub fn is_offline(&self) -> bool {
+        match &self.subcommand {
+            AptSubcommand::Package(pkg) => pkg.is_offline(),
+            AptSubcommand::Repository(_) | AptSubcommand::Distribution(_) => false,
+        }
+    }
+}
+
 pub async fn handle_apt(ctx: Config, command: AptCommand) -> ExitCode {
     match command.subcommand {
         AptSubcommand::Repository(repo) => repo::handle_repo(ctx, repo).await,
@@ -39,15 +51,12 @@ pub async fn handle_apt(ctx: Config, command: AptCommand) -> ExitCode {
         // if we want to later we can do the same for other subcommands.
         //
         // Also, if we really want to make this nice, we can convert to `color-eyre`.
-        AptSubcommand::Distribution(dist) => match dist::handle_dist(ctx, dist).await {
+        AptSubcommand::Distribution(dist) => match dist::handle_dist(ctx.clone(), dist).await {
             Ok(output) => {
                 println!("{output}");
                 ExitCode::SUCCESS
             }
-            Err(err) => {
-                eprintln!("Error: {err}");
-                ExitCode::FAILURE
-            }
+            Err(err) => ctx.fail(err),
         },
     }
 }
@@ -1,20 +1,58 @@
-use std::process::ExitCode;
+use std::{
+    collections::HashSet,
+    io::{IsTerminal as _, Read as _},
+    path::{Path, PathBuf},
+    process::ExitCode,
+};
 
-use crate::{config::Config, gpg_sign, retry_delay_default, retry_infinite};
+use crate::{
+    cmd::apt::{
+        pkg::{
+            deb,
+            lint::{self, LintMode, Severity},
+            manifest,
+            ratelimit::{self, RateLimiter},
+            remove::{PkgRemoveCommand, remove_package_retrying},
+        },
+        targets::{self, RepoTargets},
+    },
+    config::{Config, SendRetrying as _},
+    exit::{CommandError, Failure, SigningFailed},
+    gpg_sign, retry_delay_default, retry_infinite,
+};
 
+use base64::Engine as _;
 use bon::Builder;
+use bytes::Bytes;
 use clap::Args;
-use color_eyre::eyre::{Context as _, Result, bail};
+use color_eyre::eyre::{Context as _, OptionExt as _, Result, bail, eyre};
+use colored::Colorize as _;
+use futures_util::{StreamExt as _, TryStreamExt as _, stream};
 use http::StatusCode;
 use percent_encoding::percent_encode;
 use reqwest::multipart::{self, Part};
-use sha2::{Digest as _, Sha256};
+use serde::{Serialize, de::DeserializeOwned};
+use sha2::{Digest as _, Sha256, Sha512};
 use tracing::{debug, instrument};
 
 use attune::{
     api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    apt::read_origin_signature,
     server::{
-        pkg::{info::PackageInfoResponse, upload::PackageUploadResponse},
+        pkg::{
+            fetch::PackageFetchRequest,
+            info::PackageInfoResponse,
+            list::{PackageListParams, PackageListResponse},
+            resumable::{
+                ResumableUploadResponse, create::CreateResumableUploadRequest,
+                part::UploadPartResponse,
+            },
+            upload::{PackageUploadParams, PackageUploadResponse},
+            upstream::{
+                self, UpstreamSignature, VerifyUpstreamSignatureRequest,
+                VerifyUpstreamSignatureResponse,
+            },
+        },
         repo::{
             index::{
                 PackageChange, PackageChangeAction,
@@ -26,12 +64,28 @@ use attune::{
     },
 };
 
+/// Packages larger than this are uploaded in parts, so that an interrupted
+/// upload can be resumed.
+const RESUMABLE_UPLOAD_THRESHOLD: usize = 64 * 1024 * 1024;
+
+/// How many packages are uploaded at once by default when adding several.
+const DEFAULT_CONCURRENCY: u16 = 4;
+
+/// How many parts of a large package are uploaded at once by default.
+const DEFAULT_UPLOAD_CONCURRENCY: u16 = 4;
+
 #[derive(Args, Debug, Builder, Clone)]
 pub struct PkgAddCommand {
-    /// Name of the repository to add the package to
-    #[arg(long, short)]
+    /// Repositories to add the package to
+    #[command(flatten)]
+    #[builder(default)]
+    pub targets: RepoTargets,
+    /// Name of the repository to add the package to.
+    ///
+    /// [`run`] sets this for each of the `targets` in turn.
+    #[arg(skip)]
     #[builder(into)]
-    pub repo: String,
+    pub repo: Option<String>,
     /// Distribution to add the package to
     #[arg(long, short, default_value = "stable")]
     #[builder(into)]
@@ -56,31 +110,806 @@ pub struct PkgAddCommand {
     #[builder(into)]
     pub gpg_home_dir: Option<String>,
 
-    /// Path to the package to add
+    /// Require a valid upstream (vendor) signature on the package
+    ///
+    /// The package is verified against `--upstream-key` before it is uploaded,
+    /// and the upload is aborted if verification fails. Use this when
+    /// re-publishing third-party packages. Packages without a detached
+    /// signature are checked for an embedded `debsig` signature. The API server
+    /// verifies the signature again before recording it as the package's
+    /// provenance.
+    #[arg(long, requires = "upstream_key", conflicts_with = "from_url")]
+    #[builder(default)]
+    pub require_upstream_sig: bool,
+    /// Path to the vendor's armored OpenPGP public key.
+    #[arg(long)]
+    #[builder(into)]
+    pub upstream_key: Option<String>,
+    /// Path to the vendor's detached signature over the package file.
+    ///
+    /// Both armored and binary signatures are accepted. If not set, defaults to
+    /// the package path with `.asc` or `.sig` appended, or to the package's
+    /// embedded `debsig` signature if neither exists.
+    #[arg(long, conflicts_with = "manifest")]
+    #[builder(into)]
+    pub upstream_sig: Option<String>,
+
+    /// Keep adding the remaining packages when one fails
+    ///
+    /// By default, adding several packages stops at the first one that fails.
+    /// Either way, the command exits with an error if any package failed.
+    #[arg(long)]
+    #[builder(default)]
+    pub continue_on_error: bool,
+    /// Replace a package with the same name, version, and architecture but
+    /// different contents
+    ///
+    /// By default, adding a package that was already uploaded with different
+    /// contents fails. With `--force`, the old package is removed from the
+    /// components it's being added to, and replaced. It can't be replaced
+    /// while any other component or snapshot has it.
+    #[arg(long, conflicts_with = "from_url")]
+    #[builder(default)]
+    pub force: bool,
+    /// Add the package even if its version is lower than the latest version of
+    /// it in the repository
+    ///
+    /// This only matters for repositories that prevent downgrades (see
+    /// `attune apt repo edit --prevent-downgrades`).
+    #[arg(long)]
+    #[builder(default)]
+    pub allow_downgrade: bool,
+    /// Lint the package before uploading it, and refuse to upload it if there
+    /// are errors
+    ///
+    /// Packages are checked with lintian if it's installed, or otherwise with
+    /// a built-in subset of its checks. With `--lint=warn`, errors are
+    /// reported but the package is uploaded anyway.
+    #[arg(
+        long,
+        value_enum,
+        value_name = "MODE",
+        num_args = 0..=1,
+        require_equals = true,
+        default_missing_value = "error",
+        conflicts_with = "from_url"
+    )]
+    pub lint: Option<LintMode>,
+    /// Number of packages to upload at once when adding several packages
+    ///
+    /// Packages are still added to indexes one at a time, in the order they
+    /// were given. Each package being uploaded is held in memory.
+    #[arg(
+        long,
+        value_name = "PACKAGES",
+        default_value_t = DEFAULT_CONCURRENCY,
+        value_parser = clap::value_parser!(u16).range(1..)
+    )]
+    #[builder(default = DEFAULT_CONCURRENCY)]
+    pub concurrency: u16,
+
+    /// Path to a YAML manifest listing the packages to add
+    ///
+    /// Each package can set its own distribution, component, and upstream
+    /// signature, overriding the manifest's and the command's settings.
+    #[arg(long = "file", short = 'f', value_name = "MANIFEST")]
     #[builder(into)]
+    pub manifest: Option<PathBuf>,
+    /// Paths to the packages to add, or `-` to read a package from standard
+    /// input
+    ///
+    /// Wildcards in file names, like `dist/*.deb`, are expanded even if the
+    /// shell didn't expand them.
+    #[arg(
+        value_name = "PACKAGE_FILE",
+        required_unless_present_any = ["manifest", "from_url"],
+        conflicts_with_all = ["manifest", "from_url"]
+    )]
+    #[builder(default)]
+    pub package_files: Vec<String>,
+    /// URL that the API server downloads the package from, instead of
+    /// uploading a local file
+    ///
+    /// This is faster for packages that are already hosted elsewhere, like a
+    /// CI system's build artifacts. Besides HTTP and HTTPS URLs, `s3://` URIs
+    /// are read by the API server using its own credentials, from the buckets
+    /// that it allows. The package must match `--sha256`.
+    #[arg(
+        long,
+        visible_alias = "from-uri",
+        value_name = "URL",
+        requires = "sha256",
+        conflicts_with = "manifest"
+    )]
+    #[builder(into)]
+    pub from_url: Option<String>,
+    /// Expected SHA256 sum of the package at `--from-url`
+    #[arg(long, value_name = "SHA256", requires = "from_url")]
+    #[builder(into)]
+    pub sha256: Option<String>,
+    /// Resume an interrupted upload of a large package
+    ///
+    /// Large packages are uploaded in parts. If the upload is interrupted, the
+    /// command prints the upload's ID; pass it here to send only the parts that
+    /// the API server hasn't received yet.
+    #[arg(long, value_name = "UPLOAD_ID", conflicts_with_all = ["manifest", "from_url"])]
+    #[builder(into)]
+    pub resume: Option<String>,
+    /// Number of parts of a large package to upload at once
+    #[arg(
+        long,
+        value_name = "PARTS",
+        default_value_t = DEFAULT_UPLOAD_CONCURRENCY,
+        value_parser = clap::value_parser!(u16).range(1..)
+    )]
+    #[builder(default = DEFAULT_UPLOAD_CONCURRENCY)]
+    pub upload_concurrency: u16,
+    /// Limit upload throughput, in bytes per second
+    ///
+    /// Suffix the rate with `K`, `M`, or `G` for KiB, MiB, or GiB per second,
+    /// like `10M`. The limit applies to all of the command's uploads combined.
+    #[arg(long, value_name = "RATE", value_parser = ratelimit::parse_rate)]
+    pub limit_rate: Option<RateLimiter>,
+    /// Path to the package to add.
+    ///
+    /// [`run`] sets this for each of the `package_files` in turn.
+    #[arg(skip)]
+    #[builder(default, into)]
     pub package_file: String,
+
+    /// Name to show for a package read from standard input
+    ///
+    /// This is also used to find the package's upstream signature if
+    /// `--upstream-sig` isn't set.
+    #[arg(long, value_name = "NAME", conflicts_with_all = ["manifest", "from_url"])]
+    #[builder(into)]
+    pub filename: Option<String>,
+    /// Size in bytes of a package read from standard input
+    ///
+    /// If set, the command fails unless exactly this many bytes are read, which
+    /// catches packages that were cut off on their way in.
+    #[arg(long, conflicts_with_all = ["manifest", "from_url"])]
+    pub size: Option<usize>,
+    /// Contents of the package, if it was read from standard input.
+    ///
+    /// [`run`] reads standard input once, since it can't be read again when an
+    /// upload is retried.
+    #[arg(skip)]
+    #[builder(skip)]
+    pub package_content: Option<Bytes>,
+}
+
+impl PkgAddCommand {
+    /// The name of the repository, which [`run`] resolves before using.
+    pub fn repo(&self) -> &str {
+        self.repo.as_deref().expect("repository is resolved")
+    }
+
+    /// Whether the package is a udeb, going by its file name.
+    pub fn udeb(&self) -> bool {
+        self.package_file.ends_with(".udeb")
+    }
 }
 
 #[instrument]
 pub async fn run(ctx: Config, command: PkgAddCommand) -> ExitCode {
-    match validate_repository_exists(&ctx, &command).await {
-        Ok(true) => {}
-        Ok(false) => {
-            eprintln!("Error: repository {:?} does not exist", command.repo);
-            return ExitCode::FAILURE;
+    let repos = match command.targets.clone().resolve(&ctx).await {
+        Ok(repos) => repos,
+        Err(error) => return ctx.fail(error),
+    };
+    let packages = match (&command.manifest, &command.from_url) {
+        (Some(path), _) => match manifest::read(path, &command) {
+            Ok(packages) => packages,
+            Err(error) => return ctx.error(Failure::Usage, format!("{error:#}")),
+        },
+        (None, Some(url)) => vec![PkgAddCommand {
+            package_file: url.clone(),
+            ..command.clone()
+        }],
+        (None, None) => {
+            let package_files = match expand_package_files(&command.package_files) {
+                Ok(package_files) => package_files,
+                Err(error) => return ctx.fail(error),
+            };
+            if command.upstream_sig.is_some() && package_files.len() > 1 {
+                return ctx.error(
+                    Failure::Usage,
+                    "--upstream-sig can only be used when adding a single package",
+                );
+            }
+            if command.resume.is_some() && package_files.len() > 1 {
+                return ctx.error(
+                    Failure::Usage,
+                    "--resume can only be used when adding a single package",
+                );
+            }
+            let package_content = match package_files.as_slice() {
+                [package_file] if package_file == "-" => match read_stdin(command.size) {
+                    Ok(content) => Some(content),
+                    Err(error) => return ctx.fail(error),
+                },
+                package_files if package_files.iter().any(|file| file == "-") => {
+                    return ctx.error(
+                        Failure::Usage,
+                        "standard input (`-`) can only be used when adding a single package",
+                    );
+                }
+                _ if command.filename.is_some() || command.size.is_some() => {
+                    return ctx.error(
+                        Failure::Usage,
+                        "--filename and --size can only be used when reading the package from standard input (`-`)",
+                    );
+                }
+                _ => None,
+            };
+            package_files
+                .into_iter()
+                .map(|package_file| PkgAddCommand {
+                    package_file: match package_content {
+                        Some(_) => command.filename.clone().unwrap_or(package_file),
+                        None => package_file,
+                    },
+                    package_content: package_content.clone(),
+                    ..command.clone()
+                })
+                .collect::<Vec<_>>()
+        }
+    };
+    let mut repo_infos = Vec::with_capacity(repos.len());
+    for repo in &repos {
+        let command = PkgAddCommand {
+            repo: Some(repo.to_string()),
+            ..command.clone()
+        };
+        match fetch_repository(&ctx, &command).await {
+            Ok(Some(info)) => repo_infos.push(info),
+            Ok(None) => {
+                return ctx.error(
+                    Failure::NotFound,
+                    format!("repository {repo:?} does not exist"),
+                );
+            }
+            Err(error) => return ctx.report_error("validating repository", error),
+        }
+    }
+    // Check every package before uploading any of them, so that a malformed
+    // package is refused before the others are added.
+    for package in &packages {
+        if let Err(error) = preflight(&ctx, package, &repo_infos) {
+            return ctx.fail(error);
+        }
+    }
+
+    match <[_; 1]>::try_from(packages) {
+        Ok([package]) => add_single(&ctx, package, repos).await,
+        Err(packages) => add_batch(&ctx, &repos, packages, &command).await,
+    }
+}
+
+/// Add one package to each of the repositories.
+async fn add_single(ctx: &Config, command: PkgAddCommand, repos: Vec<String>) -> ExitCode {
+    let for_repo = |repo: &str| PkgAddCommand {
+        repo: Some(repo.to_string()),
+        ..command.clone()
+    };
+
+    let upstream = match verify_upstream(ctx, &command) {
+        Ok(upstream) => upstream,
+        Err(error) => return ctx.fail(error),
+    };
+
+    let sha256sum = match upload_replacing(ctx, &command, &repos).await {
+        Ok(sha256sum) => sha256sum,
+        Err(error) => return ctx.report_error("uploading file content", error),
+    };
+    if let Some(upstream) = &upstream {
+        let recorded = record_upstream_signature(ctx, upstream, &sha256sum).await;
+        if let Err(error) = recorded {
+            return ctx.report_error("recording upstream signature", error);
+        }
+    }
+
+    if let [repo] = repos.as_slice() {
+        let command = for_repo(repo);
+        return match add_package_retrying(ctx, &command, &sha256sum).await {
+            Ok(changed) => {
+                tracing::info!(?sha256sum, changed, "package added to index");
+                match ctx.output.render(&package_change(&command, &sha256sum)) {
+                    Some(output) => println!("{output}"),
+                    None => added(ctx, &command, changed),
+                }
+                ExitCode::SUCCESS
+            }
+            Err(error) => match error.downcast::<ErrorResponse>() {
+                Ok(res) if res.error == "INVALID_COMPONENT_NAME" => {
+                    let message = format!(
+                        "Invalid component name {:?}: {}\nComponent names must contain only letters, numbers, underscores, and hyphens.",
+                        command.component, res.message
+                    );
+                    ctx.fail(CommandError::from_response(res, message))
+                }
+                Ok(res) => ctx.api_error("adding package to index", res),
+                Err(other) => ctx.report_error("adding package to index", other),
+            },
+        };
+    }
+
+    // Keep going when adding to one repository fails, so that the report shows
+    // every repository that still needs the package.
+    let mut outcomes = Vec::with_capacity(repos.len());
+    for repo in repos {
+        let command = for_repo(&repo);
+        let result = add_package_retrying(ctx, &command, &sha256sum)
+            .await
+            .map(|changed| {
+                tracing::info!(?sha256sum, %repo, changed, "package added to index");
+                if !ctx.output.is_structured() {
+                    added(ctx, &command, changed);
+                }
+            })
+            .map_err(CommandError::from_report);
+        outcomes.push((repo, result));
+    }
+    let (report, error) = targets::report(ctx, "adding package", outcomes);
+    println!("{report}");
+    match error {
+        Some(error) => ctx.fail(error),
+        None => ExitCode::SUCCESS,
+    }
+}
+
+/// The outcome of adding one of several packages.
+#[derive(Serialize, Debug)]
+struct BatchOutcome {
+    package_file: String,
+    distribution: String,
+    component: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    sha256sum: Option<String>,
+    /// Why the package couldn't be added, if it couldn't.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error: Option<String>,
+    /// Whether the package was skipped, because another package failed.
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    skipped: bool,
+}
+
+/// Add several packages, each to every repository, and print a summary of the
+/// outcome for each package.
+async fn add_batch(
+    ctx: &Config,
+    repos: &[String],
+    packages: Vec<PkgAddCommand>,
+    command: &PkgAddCommand,
+) -> ExitCode {
+    let total = packages.len();
+    let (outcomes, failure) = add_each(ctx, repos, &packages, command).await;
+    let failed = outcomes
+        .iter()
+        .filter(|outcome| outcome.error.is_some())
+        .count();
+
+    let report = ctx.output.render(&outcomes).unwrap_or_else(|| {
+        let mut rows = vec![
+            ["Package", "Distribution", "Component", "SHA256", "Result"]
+                .map(String::from)
+                .to_vec(),
+        ];
+        for outcome in outcomes {
+            rows.push(vec![
+                outcome.package_file,
+                outcome.distribution,
+                outcome.component,
+                outcome.sha256sum.unwrap_or_default(),
+                match (outcome.error, outcome.skipped) {
+                    (Some(error), _) => format!("failed: {error}"),
+                    (None, true) => String::from("skipped"),
+                    (None, false) => String::from("ok"),
+                },
+            ]);
+        }
+        ctx.output.table(rows)
+    });
+    println!("{report}");
+    match failure {
+        Some(failure) => ctx.error(
+            failure,
+            format!("adding packages failed for {failed} of {total} packages"),
+        ),
+        None => ExitCode::SUCCESS,
+    }
+}
+
+/// Add several packages, each to every repository, returning the outcome for
+/// each package and the first failure, if any package failed.
+///
+/// Packages are uploaded in parallel, but added to indexes one at a time in
+/// the order they were given, since concurrent changes to an index conflict.
+async fn add_each(
+    ctx: &Config,
+    repos: &[String],
+    packages: &[PkgAddCommand],
+    command: &PkgAddCommand,
+) -> (Vec<BatchOutcome>, Option<Failure>) {
+    let total = packages.len();
+
+    // Only the packages being uploaded are read into memory. After a failure,
+    // uploads that haven't finished are cancelled unless the command should
+    // continue.
+    let mut uploaded = std::iter::repeat_with(|| None).take(total).collect::<Vec<_>>();
+    let mut done = 0;
+    let mut uploads = stream::iter(packages.iter().enumerate())
+        .map(|(index, package)| async move { (index, upload_file(ctx, package, repos).await) })
+        .buffer_unordered(command.concurrency.into());
+    while let Some((index, result)) = uploads.next().await {
+        done += 1;
+        let failed = result.is_err();
+        if !failed {
+            ctx.status(format!(
+                "[{done}/{total}] Uploaded {:?}",
+                packages[index].package_file
+            ));
+        }
+        uploaded[index] = Some(result);
+        if failed && !command.continue_on_error {
+            break;
+        }
+    }
+    drop(uploads);
+
+    let mut failure = None;
+    let mut stopped = false;
+    let mut outcomes = Vec::with_capacity(total);
+    for (package, uploaded) in packages.iter().zip(uploaded) {
+        let mut outcome = BatchOutcome {
+            package_file: package.package_file.clone(),
+            distribution: package.distribution.clone(),
+            component: package.component.clone(),
+            sha256sum: None,
+            error: None,
+            skipped: false,
+        };
+        // A failed upload is reported even if an earlier package was skipped,
+        // since that package's upload was cancelled because of it.
+        let result = match uploaded {
+            Some(Err(error)) => Err((None, error)),
+            Some(Ok(sha256sum)) if !stopped => index_file(ctx, package, repos, sha256sum).await,
+            _ => {
+                stopped = true;
+                outcome.skipped = true;
+                outcomes.push(outcome);
+                continue;
+            }
+        };
+
+        match result {
+            Ok(sha256sum) => outcome.sha256sum = Some(sha256sum),
+            Err((sha256sum, error)) => {
+                ctx.status(format!(
+                    "{} to add {:?}: {}",
+                    "Failed".red(),
+                    package.package_file,
+                    error.message
+                ));
+                failure.get_or_insert(error.failure);
+                stopped = !command.continue_on_error;
+                outcome.sha256sum = sha256sum;
+                outcome.error = Some(error.message);
+            }
+        }
+        outcomes.push(outcome);
+    }
+    (outcomes, failure)
+}
+
+/// Verify one of several packages' upstream signature if needed, and upload
+/// it, returning its SHA256 sum. A verified signature is then sent to the API
+/// server to be recorded.
+async fn upload_file(
+    ctx: &Config,
+    command: &PkgAddCommand,
+    repos: &[String],
+) -> Result<String, CommandError> {
+    let upstream = verify_upstream(ctx, command)?;
+    let sha256sum = upload_replacing(ctx, command, repos)
+        .await
+        .map_err(CommandError::from_report)?;
+    if let Some(upstream) = &upstream {
+        record_upstream_signature(ctx, upstream, &sha256sum)
+            .await
+            .map_err(CommandError::from_report)?;
+    }
+    Ok(sha256sum)
+}
+
+/// Verify the package's upstream signature if the command requires one.
+fn verify_upstream(
+    ctx: &Config,
+    command: &PkgAddCommand,
+) -> Result<Option<VerifiedUpstreamSignature>, CommandError> {
+    if !command.require_upstream_sig {
+        return Ok(None);
+    }
+    let verified = verify_upstream_signature(command).map_err(|error| {
+        let message = format!("upstream signature verification failed: {error:#}");
+        CommandError::new(Failure::Signing, message)
+    })?;
+    ctx.status(format!(
+        "Verified {} upstream signature {:?} by {} for {:?}",
+        verified.signature.kind,
+        verified.path,
+        verified.signature.key_fingerprint,
+        command.package_file
+    ));
+    Ok(Some(verified))
+}
+
+/// Add one of several uploaded packages to every repository.
+async fn index_file(
+    ctx: &Config,
+    command: &PkgAddCommand,
+    repos: &[String],
+    sha256sum: String,
+) -> Result<String, (Option<String>, CommandError)> {
+    // Keep going when adding to one repository fails, like when adding a
+    // single package.
+    let mut failure = None;
+    for repo in repos {
+        let command = PkgAddCommand {
+            repo: Some(repo.to_string()),
+            ..command.clone()
+        };
+        match add_package_retrying(ctx, &command, &sha256sum).await {
+            Ok(changed) => {
+                tracing::info!(?sha256sum, %repo, changed, "package added to index");
+                if !ctx.output.is_structured() {
+                    added(ctx, &command, changed);
+                }
+            }
+            Err(error) => {
+                let mut error = CommandError::from_report(error);
+                error.message = format!("{repo}: {}", error.message);
+                failure.get_or_insert(error);
+            }
+        }
+    }
+    match failure {
+        Some(error) => Err((Some(sha256sum), error)),
+        None => Ok(sha256sum),
+    }
+}
+
+/// Expand wildcards in package file arguments, for when the shell didn't (for
+/// example, because the pattern was quoted). Arguments without wildcards are
+/// kept as-is, so that missing files are reported when they're read.
+pub fn expand_package_files(patterns: &[String]) -> Result<Vec<String>, CommandError> {
+    let mut package_files = Vec::new();
+    for pattern in patterns {
+        if !pattern.contains(['*', '?', '[']) || Path::new(pattern).exists() {
+            package_files.push(pattern.clone());
+            continue;
+        }
+        let paths = glob::glob(pattern).map_err(|error| {
+            CommandError::new(
+                Failure::Usage,
+                format!("invalid pattern {pattern:?}: {error}"),
+            )
+        })?;
+        let matched = paths
+            .filter_map(Result::ok)
+            .filter(|path| path.is_file())
+            .map(|path| path.to_string_lossy().to_string())
+            .collect::<Vec<_>>();
+        if matched.is_empty() {
+            return Err(CommandError::new(
+                Failure::Usage,
+                format!("no package files match {pattern:?}"),
+            ));
+        }
+        package_files.extend(matched);
+    }
+    let mut seen = HashSet::new();
+    package_files.retain(|package_file| seen.insert(package_file.clone()));
+    Ok(package_files)
+}
+
+/// Read a package from standard input, checking its size if it's known.
+pub fn read_stdin(size: Option<usize>) -> Result<Bytes, CommandError> {
+    let mut stdin = std::io::stdin().lock();
+    if stdin.is_terminal() {
+        return Err(CommandError::new(
+            Failure::Usage,
+            "standard input is a terminal; pipe the package into the command instead",
+        ));
+    }
+    let mut content = Vec::with_capacity(size.unwrap_or_default());
+    stdin.read_to_end(&mut content).map_err(|error| {
+        CommandError::new(
+            Failure::General,
+            format!("could not read package from standard input: {error}"),
+        )
+    })?;
+    match size {
+        Some(size) if content.len() != size => Err(CommandError::new(
+            Failure::Validation,
+            format!(
+                "read {} bytes from standard input, but the package should be {size} bytes",
+                content.len()
+            ),
+        )),
+        _ => Ok(Bytes::from(content)),
+    }
+}
+
+/// Read the package's contents, unless [`run`] already read them from
+/// standard input.
+fn read_package_file(cmd: &PkgAddCommand) -> Result<Vec<u8>> {
+    match &cmd.package_content {
+        Some(content) => Ok(content.to_vec()),
+        None => std::fs::read(&cmd.package_file).context("read package file"),
+    }
+}
+
+/// Check a local package before it's uploaded, so that a malformed package is
+/// refused without waiting for the upload, and likely mistakes in where it's
+/// being added are pointed out.
+fn preflight(
+    ctx: &Config,
+    command: &PkgAddCommand,
+    repos: &[RepositoryInfoResponse],
+) -> Result<(), CommandError> {
+    // Packages at a URL are checked by the API server when it fetches them.
+    if command.from_url.is_some() {
+        return Ok(());
+    }
+    let content = read_package_file(command).map_err(|error| {
+        CommandError::new(
+            Failure::Usage,
+            format!("{:?}: {error:#}", command.package_file),
+        )
+    })?;
+    let (name, version, architecture) = deb::check_package(&content).map_err(|error| {
+        CommandError::new(
+            Failure::Validation,
+            format!(
+                "{:?} is not a valid Debian package: {error:#}",
+                command.package_file
+            ),
+        )
+    })?;
+    ctx.status(format!(
+        "Checked {:?}: Package {name}, Version {version}, Architecture {architecture}",
+        command.package_file
+    ));
+    for warning in preflight_warnings(command, &architecture, repos) {
+        eprintln!("{} {warning}", "Warning:".yellow());
+    }
+    if let Some(mode) = command.lint {
+        lint_package(ctx, command, &content, mode)?;
+    }
+    Ok(())
+}
+
+/// Lint a local package, failing if there are errors unless `mode` is
+/// [`LintMode::Warn`].
+fn lint_package(
+    ctx: &Config,
+    command: &PkgAddCommand,
+    content: &[u8],
+    mode: LintMode,
+) -> Result<(), CommandError> {
+    let path = match command.package_content {
+        Some(_) => None,
+        None => Some(command.package_file.as_str()),
+    };
+    let (linter, findings) = lint::lint(path, content).map_err(|error| {
+        CommandError::new(
+            Failure::Validation,
+            format!("could not lint {:?}: {error:#}", command.package_file),
+        )
+    })?;
+    for finding in &findings {
+        eprintln!("  {finding}");
+    }
+    let errors = findings
+        .iter()
+        .filter(|finding| finding.severity == Severity::Error)
+        .count();
+    let warnings = findings.len() - errors;
+    ctx.status(format!(
+        "Linted {:?} with {linter}: {errors} errors, {warnings} warnings",
+        command.package_file
+    ));
+    if errors > 0 && mode == LintMode::Error {
+        return Err(CommandError::new(
+            Failure::Validation,
+            format!(
+                "linting {:?} found {errors} errors; pass --lint=warn to upload it anyway",
+                command.package_file
+            ),
+        ));
+    }
+    Ok(())
+}
+
+/// Warnings about adding a package of this architecture to the command's
+/// distribution and component in each of the repositories. These are often
+/// typos, since adding a package creates whatever doesn't exist yet.
+fn preflight_warnings(
+    command: &PkgAddCommand,
+    architecture: &str,
+    repos: &[RepositoryInfoResponse],
+) -> Vec<String> {
+    let mut warnings = Vec::new();
+    for repo in repos {
+        let dist = repo
+            .distributions
+            .iter()
+            .find(|dist| dist.distribution == command.distribution);
+        let Some(dist) = dist else {
+            warnings.push(format!(
+                "distribution {:?} doesn't exist in {}; adding the package will create it",
+                command.distribution, repo.name
+            ));
+            continue;
+        };
+        if !dist.components.contains(&command.component) {
+            warnings.push(format!(
+                "component {:?} doesn't exist in {}/{}; adding the package will create it",
+                command.component, repo.name, dist.distribution
+            ));
         }
-        Err(error) => {
-            eprintln!("Unable to validate repository: {error:#?}");
-            return ExitCode::FAILURE;
+        // Packages for all architectures are listed in every architecture's
+        // index.
+        let known = dist.architectures.is_empty()
+            || dist.architectures.iter().any(|known| known == architecture);
+        if architecture != "all" && !known {
+            warnings.push(format!(
+                "{}/{} has no {architecture} packages yet (only {})",
+                repo.name,
+                dist.distribution,
+                dist.architectures.join(", ")
+            ));
         }
     }
+    warnings
+}
+
+/// Report that a package was added, or that it already had been.
+fn added(ctx: &Config, command: &PkgAddCommand, changed: bool) {
+    let action = match changed {
+        true => "Added".green(),
+        false => "Already added".yellow(),
+    };
+    ctx.status(format!(
+        "{action} {:?} to {}/{}/{}",
+        command.package_file,
+        command.repo(),
+        command.distribution,
+        command.component
+    ));
+}
+
+/// Add an uploaded package to the index, retrying if another change to the
+/// index raced with this one. Returns whether the index was changed.
+pub async fn add_package_retrying(
+    ctx: &Config,
+    command: &PkgAddCommand,
+    sha256sum: &str,
+) -> Result<bool> {
+    // Adding a package that's already in the component wouldn't change the
+    // index, so there's nothing to sign.
+    if package_in_component(ctx, command, sha256sum).await? {
+        debug!(?sha256sum, "package already in component");
+        return Ok(false);
+    }
 
-    let sha256sum = match retry_infinite(
-        || upload_file_content(&ctx, &command),
+    retry_infinite(
+        || add_package(ctx, command, sha256sum),
         |error| match error.downcast_ref::<ErrorResponse>() {
-            Some(res) => match res.status {
-                StatusCode::CONFLICT => {
-                    tracing::warn!(error = ?res, "retrying upload");
+            Some(res) => match res.error.as_str() {
+                "CONCURRENT_INDEX_CHANGE" | "DETACHED_SIGNATURE_VERIFICATION_FAILED" => {
+                    tracing::warn!(error = ?res, "retrying signature: concurrent index change");
                     true
                 }
                 _ => false,
@@ -90,25 +919,182 @@ pub async fn run(ctx: Config, command: PkgAddCommand) -> ExitCode {
         retry_delay_default,
     )
     .await
-    {
-        Ok(sha256sum) => sha256sum,
-        Err(error) => {
-            eprintln!("Unable to upload file content: {error:#?}");
-            return ExitCode::FAILURE;
+    .map(|()| true)
+    .map_err(|mut error| {
+        if let Some(res) = error
+            .downcast_mut::<ErrorResponse>()
+            .filter(|res| res.error == "VERSION_DOWNGRADE")
+        {
+            res.message.push_str("; pass --allow-downgrade to add it anyway");
         }
+        error
+    })
+}
+
+/// Whether the uploaded package is already in the command's component.
+async fn package_in_component(
+    ctx: &Config,
+    command: &PkgAddCommand,
+    sha256sum: &str,
+) -> Result<bool> {
+    let res = ctx
+        .client
+        .get(
+            ctx.endpoint
+                .join(&format!("/api/v0/packages/{sha256sum}"))
+                .unwrap(),
+        )
+        .send_retrying(ctx)
+        .await
+        .context("send api request")?;
+    let package = parse_response::<PackageInfoResponse>(res).await?;
+
+    let res = ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/packages").unwrap())
+        .query(&PackageListParams {
+            repository: Some(command.repo().to_string()),
+            distribution: Some(command.distribution.clone()),
+            component: Some(command.component.clone()),
+            name: Some(package.package),
+            version: Some(package.version),
+            architecture: Some(package.architecture),
+            limit: None,
+            cursor: None,
+            sort: None,
+        })
+        .send_retrying(ctx)
+        .await
+        .context("send api request")?;
+    let listed = parse_response::<PackageListResponse>(res).await?;
+    Ok(listed
+        .packages
+        .iter()
+        .any(|package| package.sha256sum == sha256sum))
+}
+
+/// The index change that adds the uploaded package.
+pub fn package_change(command: &PkgAddCommand, sha256sum: &str) -> PackageChange {
+    PackageChange {
+        repository: command.repo().to_string(),
+        distribution: command.distribution.clone(),
+        component: command.component.clone(),
+        action: PackageChangeAction::Add {
+            package_sha256sum: sha256sum.to_string(),
+            allow_downgrade: command.allow_downgrade,
+        },
+    }
+}
+
+/// Upload the package file. With `--force`, this replaces a different package
+/// with the same name, version, and architecture.
+async fn upload_replacing(
+    ctx: &Config,
+    command: &PkgAddCommand,
+    repos: &[String],
+) -> Result<String> {
+    let error = match upload_file_content_retrying(ctx, command).await {
+        Ok(sha256sum) => return Ok(sha256sum),
+        Err(error) => error,
     };
+    match error.downcast::<ErrorResponse>() {
+        // The API server only replaces packages that aren't in any component,
+        // so the replaced package has to be removed from ours first.
+        Ok(res) if res.error == "PACKAGE_PUBLISHED" && command.force => {
+            unpublish_replaced(ctx, command, repos).await?;
+            upload_file_content_retrying(ctx, command).await
+        }
+        Ok(mut res) if res.error == "PACKAGE_ALREADY_EXISTS" => {
+            res.message = format!("{}; pass --force to replace it", res.message);
+            Err(res.into())
+        }
+        Ok(res) => Err(res.into()),
+        Err(error) => Err(error),
+    }
+}
+
+/// Remove the package that the command's package replaces from the components
+/// that the command adds to.
+///
+/// Nothing is removed if any other component has the replaced package, since
+/// replacing it would change that component too.
+async fn unpublish_replaced(ctx: &Config, command: &PkgAddCommand, repos: &[String]) -> Result<()> {
+    let content = read_package_file(command)?;
+    let control_file = deb::read_control_file(&content)
+        .with_context(|| format!("read {:?}", command.package_file))?;
+    let (name, version, architecture) = deb::package_key(&control_file)?;
+    let described = format!("{name} {version} ({architecture})");
+
+    let res = ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/packages").unwrap())
+        .query(&PackageListParams {
+            repository: None,
+            distribution: None,
+            component: None,
+            name: Some(name.clone()),
+            version: Some(version.clone()),
+            architecture: Some(architecture.clone()),
+            limit: None,
+            cursor: None,
+            sort: None,
+        })
+        .send_retrying(ctx)
+        .await
+        .context("send api request")?;
+    let (replaced, elsewhere) = parse_response::<PackageListResponse>(res)
+        .await?
+        .packages
+        .into_iter()
+        .partition::<Vec<_>, _>(|package| {
+            repos.contains(&package.repository)
+                && package.distribution == command.distribution
+                && package.component == command.component
+        });
+    if let Some(other) = elsewhere.first() {
+        bail!(
+            "{described} is also in {}/{}/{}; remove it there before replacing it",
+            other.repository,
+            other.distribution,
+            other.component
+        );
+    }
 
-    // TODO: Check whether the package needs to be added to the index. If the
-    // package already exists in the (release, distribution, component), we can
-    // skip re-signing.
+    for package in replaced {
+        let target = format!(
+            "{}/{}/{}",
+            package.repository, package.distribution, package.component
+        );
+        let remove = PkgRemoveCommand::builder()
+            .repo(package.repository)
+            .distribution(package.distribution)
+            .component(package.component)
+            .maybe_key_id(command.key_id.clone())
+            .maybe_gpg_home_dir(command.gpg_home_dir.clone())
+            .package(&name)
+            .version(&version)
+            .architecture(&architecture)
+            .build();
+        remove_package_retrying(ctx, &remove)
+            .await
+            .with_context(|| format!("remove {described} from {target}"))?;
+        ctx.status(format!(
+            "{} {described} from {target} to replace it",
+            "Removed".yellow()
+        ));
+    }
+    Ok(())
+}
 
-    // Add the package to the index, retrying if needed.
-    let res = retry_infinite(
-        || add_package(&ctx, &command, &sha256sum),
+/// Upload the package file, retrying if a concurrent upload of the same
+/// package conflicted with this one.
+async fn upload_file_content_retrying(ctx: &Config, command: &PkgAddCommand) -> Result<String> {
+    retry_infinite(
+        || upload_file_content(ctx, command),
         |error| match error.downcast_ref::<ErrorResponse>() {
-            Some(res) => match res.error.as_str() {
-                "CONCURRENT_INDEX_CHANGE" | "DETACHED_SIGNATURE_VERIFICATION_FAILED" => {
-                    tracing::warn!(error = ?res, "retrying signature: concurrent index change");
+            Some(res) => match res.status {
+                StatusCode::CONFLICT => {
+                    tracing::warn!(error = ?res, "retrying upload");
                     true
                 }
                 _ => false,
@@ -117,37 +1103,15 @@ pub async fn run(ctx: Config, command: PkgAddCommand) -> ExitCode {
         },
         retry_delay_default,
     )
-    .await;
-    match res {
-        Ok(_) => {
-            tracing::info!(?sha256sum, "package added to index");
-            ExitCode::SUCCESS
-        }
-        Err(error) => match error.downcast::<ErrorResponse>() {
-            Ok(res) => match res.error.as_str() {
-                "INVALID_COMPONENT_NAME" => {
-                    eprintln!(
-                        "Error: Invalid component name {:?}: {}\nComponent names must contain only letters, numbers, underscores, and hyphens.",
-                        command.component, res.message
-                    );
-                    ExitCode::FAILURE
-                }
-                _ => {
-                    eprintln!("Unable to add package to index: {}", res.message);
-                    ExitCode::FAILURE
-                }
-            },
-            Err(other) => {
-                eprintln!("Unable to add package to index: {other:#?}");
-                ExitCode::FAILURE
-            }
-        },
-    }
+    .await
 }
 
-/// Ensure that the specified repository exists.
+/// Fetch the specified repository, or `None` if it doesn't exist.
 #[instrument(skip(ctx, cmd))]
-pub async fn validate_repository_exists(ctx: &Config, cmd: &PkgAddCommand) -> Result<bool> {
+pub async fn fetch_repository(
+    ctx: &Config,
+    cmd: &PkgAddCommand,
+) -> Result<Option<RepositoryInfoResponse>> {
     debug!("checking whether repository exists");
     let res = ctx
         .client
@@ -156,13 +1120,13 @@ pub async fn validate_repository_exists(ctx: &Config, cmd: &PkgAddCommand) -> Re
                 .join(
                     format!(
                         "/api/v0/repositories/{}",
-                        percent_encode(cmd.repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                        percent_encode(cmd.repo().as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
                     )
                     .as_str(),
                 )
                 .unwrap(),
         )
-        .send()
+        .send_retrying(&ctx)
         .await
         .context("send api request")?;
     match res.status() {
@@ -172,11 +1136,11 @@ pub async fn validate_repository_exists(ctx: &Config, cmd: &PkgAddCommand) -> Re
                 .await
                 .context("parse response")?;
             debug!(?repo, "repository exists");
-            Ok(true)
+            Ok(Some(repo))
         }
         StatusCode::NOT_FOUND => {
             debug!("repository does not exist");
-            Ok(false)
+            Ok(None)
         }
         status => {
             let body = res.text().await.context("read response")?;
@@ -188,52 +1152,194 @@ pub async fn validate_repository_exists(ctx: &Config, cmd: &PkgAddCommand) -> Re
     }
 }
 
+/// An upstream signature that was verified before uploading a package. The
+/// API server verifies it again before recording it as the package's
+/// provenance.
+#[derive(Debug, Clone)]
+pub struct VerifiedUpstreamSignature {
+    /// The detached signature file, or the package file for a `debsig`
+    /// signature.
+    pub path: PathBuf,
+    /// The vendor's armored public key.
+    pub key: String,
+    /// The detached signature, or `None` for a `debsig` signature.
+    pub detached: Option<Vec<u8>>,
+    pub signature: UpstreamSignature,
+}
+
+/// Verify a vendor-provided signature over the package.
+///
+/// A detached signature from `--upstream-sig`, or from a `.asc` or `.sig` file
+/// next to the package, is verified over the package file. Otherwise, a
+/// `debsig` signature embedded in the package (its `_gpgorigin` member) is
+/// verified over the package's archive members.
+///
+/// The signature may be made by the vendor key's primary key or any of its
+/// subkeys.
+#[instrument(skip(cmd))]
+pub fn verify_upstream_signature(cmd: &PkgAddCommand) -> Result<VerifiedUpstreamSignature> {
+    let key_path = cmd
+        .upstream_key
+        .as_deref()
+        .ok_or_eyre("no upstream key provided")?;
+    let key = std::fs::read_to_string(key_path)
+        .with_context(|| format!("read upstream key: {key_path:?}"))?;
+
+    let detached = match &cmd.upstream_sig {
+        Some(path) => Some(PathBuf::from(path)),
+        None => ["asc", "sig"]
+            .into_iter()
+            .map(|ext| PathBuf::from(format!("{}.{ext}", cmd.package_file)))
+            .find(|path| path.exists()),
+    };
+    let content = read_package_file(cmd)?;
+    let (path, detached) = match detached {
+        Some(sig_path) => {
+            let sig = std::fs::read(&sig_path)
+                .with_context(|| format!("read upstream signature: {sig_path:?}"))?;
+            (sig_path, Some(sig))
+        }
+        None => {
+            let origin = read_origin_signature(&content)
+                .map_err(|error| eyre!("read {:?}: {error}", cmd.package_file))?;
+            if origin.is_none() {
+                bail!(
+                    "no upstream signature found for {:?} (expected a .asc or .sig file next to it, or a debsig signature in it)",
+                    cmd.package_file
+                );
+            }
+            (PathBuf::from(&cmd.package_file), None)
+        }
+    };
+
+    let signature = upstream::verify_upstream_signature(&key, &content, detached.as_deref())
+        .map_err(|error| eyre!("verify {path:?} with {key_path:?}: {error}"))?;
+    let verified = VerifiedUpstreamSignature {
+        path,
+        key,
+        detached,
+        signature,
+    };
+    debug!(path = ?verified.path, signature = ?verified.signature, "verified upstream signature");
+    Ok(verified)
+}
+
+/// Have the API server verify the package's upstream signature, and record it
+/// as the package's provenance.
+#[instrument(skip(ctx, upstream))]
+async fn record_upstream_signature(
+    ctx: &Config,
+    upstream: &VerifiedUpstreamSignature,
+    sha256sum: &str,
+) -> Result<()> {
+    let res = ctx
+        .client
+        .post(
+            ctx.endpoint
+                .join(&format!("/api/v0/packages/{sha256sum}/upstream-signature"))
+                .unwrap(),
+        )
+        .json(&VerifyUpstreamSignatureRequest {
+            key: upstream.key.clone(),
+            signature: upstream
+                .detached
+                .as_ref()
+                .map(|sig| base64::engine::general_purpose::STANDARD.encode(sig)),
+        })
+        .send_retrying(ctx)
+        .await
+        .context("send api request")?;
+    let recorded = parse_response::<VerifyUpstreamSignatureResponse>(res).await?;
+    debug!(?recorded, "upstream signature recorded");
+    Ok(())
+}
+
 /// Checksum the package file, and upload if needed.
 //
 // TODO: We might want to make this streaming for sufficiently large package
 // files (ones that don't fit in memory). For small ones, I think keeping
 // the file in memory might be faster.
-//
-// TODO(#48): Add an `--overwrite` flag to allow the user to deliberately upload
-// a package with a different SHA256sum.
 #[instrument(skip(ctx, cmd))]
 pub async fn upload_file_content(ctx: &Config, cmd: &PkgAddCommand) -> Result<String> {
     debug!("uploading file content");
 
-    debug!("calculating SHA256 sum");
-    let content = std::fs::read(&cmd.package_file).context("read package file")?;
-    let sha256sum = hex::encode(Sha256::digest(&content).as_slice());
-    debug!(?sha256sum, "calculated SHA256 sum");
+    // Packages at a URL are downloaded by the API server, which checks them
+    // against the expected SHA256 sum.
+    let (content, sha256sum) = match (&cmd.from_url, &cmd.sha256) {
+        (Some(_), Some(sha256sum)) => (None, sha256sum.to_ascii_lowercase()),
+        (Some(_), None) => bail!("--sha256 is required with --from-url"),
+        (None, _) => {
+            debug!("calculating SHA256 sum");
+            let content = read_package_file(cmd)?;
+            let sha256sum = hex::encode(Sha256::digest(&content).as_slice());
+            debug!(?sha256sum, "calculated SHA256 sum");
+            (Some(content), sha256sum)
+        }
+    };
 
+    // Packages are stored by their content, so a package that was already
+    // uploaded, for example to publish it to another repository, doesn't need
+    // to be sent again.
     let res = ctx
         .client
-        .get(
-            ctx.endpoint
-                .join(format!("/api/v0/packages/{sha256sum}").as_str())
-                .unwrap(),
-        )
-        .send()
+        .head(ctx.endpoint.join("/api/v0/packages").unwrap())
+        .query(&[("sha256", &sha256sum)])
+        .send_retrying(&ctx)
         .await
         .context("send api request")?;
 
     match res.status() {
         StatusCode::OK => {
-            let pkg = res
-                .json::<PackageInfoResponse>()
-                .await
-                .context("parse response")?;
-            debug!(?sha256sum, ?pkg, "package already exists, skipping upload");
+            debug!(?sha256sum, "package already exists, skipping upload");
+            ctx.status(format!(
+                "Skipping upload of {:?}, which was already uploaded",
+                cmd.package_file
+            ));
             Ok(sha256sum)
         }
         StatusCode::NOT_FOUND => {
             debug!(?sha256sum, "package does not exist, uploading");
-            let multipart = multipart::Form::new().part("file", Part::bytes(content));
+            let sent = PackageUploadResponse {
+                sha256sum: sha256sum.clone(),
+                sha512sum: content
+                    .as_ref()
+                    .map(|content| hex::encode(Sha512::digest(content))),
+                size: content.as_ref().map(|content| content.len() as i64),
+            };
+            let req = match (&cmd.from_url, content) {
+                (Some(url), _) => ctx
+                    .client
+                    .post(ctx.endpoint.join("/api/v0/packages/fetch").unwrap())
+                    .json(&PackageFetchRequest {
+                        url: url.clone(),
+                        sha256sum: sha256sum.clone(),
+                    }),
+                (None, Some(content))
+                    if content.len() > RESUMABLE_UPLOAD_THRESHOLD || cmd.resume.is_some() =>
+                {
+                    let uploaded = upload_resumable(ctx, cmd, content.into(), &sha256sum).await?;
+                    verify_upload(&sent, &uploaded)?;
+                    return Ok(sha256sum);
+                }
+                (None, Some(content)) => {
+                    let length = content.len() as u64;
+                    let part = Part::stream_with_length(upload_body(cmd, content.into()), length);
+                    let multipart = multipart::Form::new().part("file", part);
+                    ctx
+                        .client
+                        .post(ctx.endpoint.join("/api/v0/packages").unwrap())
+                        .query(&PackageUploadParams {
+                            replace: cmd.force,
+                            udeb: cmd.udeb(),
+                        })
+                        .multipart(multipart)
+                }
+                (None, None) => unreachable!("local packages are read before uploading"),
+            };
 
-            let res = ctx
-                .client
-                .post(ctx.endpoint.join("/api/v0/packages").unwrap())
-                .multipart(multipart)
-                .send()
+            let res = req
+                .timeout(ctx.upload_timeout)
+                .send_retrying(&ctx)
                 .await
                 .context("send api request")?;
             match res.status() {
@@ -243,6 +1349,7 @@ pub async fn upload_file_content(ctx: &Config, cmd: &PkgAddCommand) -> Result<St
                         .await
                         .context("parse response")?;
                     debug!(?sha256sum, ?uploaded, "package uploaded");
+                    verify_upload(&sent, &uploaded)?;
                     Ok(sha256sum)
                 }
                 _ => {
@@ -254,11 +1361,169 @@ pub async fn upload_file_content(ctx: &Config, cmd: &PkgAddCommand) -> Result<St
                 }
             }
         }
-        _ => {
-            let error = res
-                .json::<ErrorResponse>()
+        // Responses to HEAD requests have no body to explain the error.
+        status => bail!(ErrorResponse::new(
+            status,
+            "PACKAGE_CHECK_FAILED",
+            format!("could not check whether the package was already uploaded: {status}"),
+        )),
+    }
+}
+
+/// Upload a package in parts, so that an interrupted upload can be resumed
+/// with `--resume` instead of starting over. Up to `--upload-concurrency`
+/// parts are sent at once.
+#[instrument(skip(ctx, content))]
+async fn upload_resumable(
+    ctx: &Config,
+    cmd: &PkgAddCommand,
+    content: Bytes,
+    sha256sum: &str,
+) -> Result<PackageUploadResponse> {
+    let uploads_url = ctx.endpoint.join("/api/v0/packages/uploads/").unwrap();
+    let upload_url = |upload_id: &str, path: &str| {
+        let upload_id = percent_encode(upload_id.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET);
+        uploads_url.join(&format!("{upload_id}{path}")).unwrap()
+    };
+
+    let upload = match &cmd.resume {
+        Some(upload_id) => {
+            let res = ctx
+                .client
+                .get(upload_url(upload_id, ""))
+                .send_retrying(ctx)
+                .await
+                .context("send api request")?;
+            let upload = parse_response::<ResumableUploadResponse>(res).await?.upload;
+            if upload.sha256sum != sha256sum || upload.size != content.len() as i64 {
+                bail!(
+                    "upload {upload_id:?} is for a different package (SHA256 sum {})",
+                    upload.sha256sum
+                );
+            }
+            upload
+        }
+        None => {
+            let res = ctx
+                .client
+                .post(ctx.endpoint.join("/api/v0/packages/uploads").unwrap())
+                .json(&CreateResumableUploadRequest {
+                    size: content.len() as i64,
+                    sha256sum: sha256sum.to_string(),
+                    part_size: None,
+                })
+                .send_retrying(ctx)
                 .await
-                .context("parse error response")?;
+                .context("send api request")?;
+            parse_response::<ResumableUploadResponse>(res).await?.upload
+        }
+    };
+
+    let missing = upload.missing_parts();
+    ctx.status(format!(
+        "Uploading {:?} in {} parts ({} remaining) as upload {}",
+        cmd.package_file,
+        upload.part_count(),
+        missing.len(),
+        upload.id
+    ));
+    let (upload_id, part_count) = (&upload.id, upload.part_count());
+    stream::iter(missing)
+        .map(|part_number| {
+            let (offset, size) = upload
+                .part_range(part_number)
+                .expect("missing parts are in range");
+            let part = content.slice(offset as usize..(offset + size) as usize);
+            let url = upload_url(upload_id, &format!("/parts/{part_number}"));
+            async move {
+                upload_part(ctx, url, upload_body(cmd, part)).await.with_context(|| {
+                    format!(
+                        "upload part {part_number} of {part_count}; \
+                         resume the upload with `--resume {upload_id}`"
+                    )
+                })?;
+                debug!(part_number, "uploaded part");
+                Ok::<_, color_eyre::Report>(())
+            }
+        })
+        .buffer_unordered(cmd.upload_concurrency.into())
+        .try_collect::<()>()
+        .await?;
+
+    let res = ctx
+        .client
+        .post(upload_url(&upload.id, "/complete"))
+        .query(&PackageUploadParams {
+            replace: cmd.force,
+            udeb: cmd.udeb(),
+        })
+        .timeout(ctx.upload_timeout)
+        .send_retrying(ctx)
+        .await
+        .context("send api request")?;
+    let uploaded = parse_response::<PackageUploadResponse>(res).await?;
+    debug!(?uploaded, "package uploaded");
+    Ok(uploaded)
+}
+
+#[instrument(skip(ctx, part))]
+async fn upload_part(ctx: &Config, url: reqwest::Url, part: reqwest::Body) -> Result<()> {
+    let res = ctx
+        .client
+        .put(url)
+        .body(part)
+        .timeout(ctx.upload_timeout)
+        .send_retrying(ctx)
+        .await
+        .context("send api request")?;
+    parse_response::<UploadPartResponse>(res).await.map(drop)
+}
+
+/// Check the checksums that the API server computed for an uploaded package
+/// against the ones computed before sending it, so that a package that was
+/// corrupted on its way to the server is never added to an index.
+///
+/// Checksums that only one side computed, like those of a package at a URL,
+/// aren't compared.
+fn verify_upload(sent: &PackageUploadResponse, received: &PackageUploadResponse) -> Result<()> {
+    let checksums = [
+        ("SHA256 sum", Some(&sent.sha256sum), Some(&received.sha256sum)),
+        ("SHA512 sum", sent.sha512sum.as_ref(), received.sha512sum.as_ref()),
+    ];
+    for (name, sent, received) in checksums {
+        match (sent, received) {
+            (Some(sent), Some(received)) if !sent.eq_ignore_ascii_case(received) => bail!(
+                "package was corrupted while uploading: the API server received a package with \
+                 {name} {received}, but {sent} was sent"
+            ),
+            _ => {}
+        }
+    }
+    match (sent.size, received.size) {
+        (Some(sent), Some(received)) if sent != received => bail!(
+            "package was corrupted while uploading: the API server received {received} bytes, \
+             but {sent} were sent"
+        ),
+        _ => Ok(()),
+    }
+}
+
+/// The body to upload package content in, throttled to `--limit-rate`.
+fn upload_body(cmd: &PkgAddCommand, content: Bytes) -> reqwest::Body {
+    match &cmd.limit_rate {
+        Some(limiter) => limiter.body(content),
+        None => content.into(),
+    }
+}
+
+async fn parse_response<T: DeserializeOwned>(res: reqwest::Response) -> Result<T> {
+    match res.status() {
+        StatusCode::OK => res.json::<T>().await.context("parse response"),
+        status => {
+            let body = res.text().await.context("read response")?;
+            debug!(?body, ?status, "error response");
+            let error =
+                serde_json::from_str::<ErrorResponse>(&body).context("parse error response")?;
             bail!(error);
         }
     }
@@ -267,16 +1532,10 @@ pub async fn upload_file_content(ctx: &Config, cmd: &PkgAddCommand) -> Result<St
 /// Generate an index for the package, and sign it.
 #[instrument]
 pub async fn add_package(ctx: &Config, command: &PkgAddCommand, sha256sum: &str) -> Result<()> {
-    debug!(?sha256sum, repo = ?command.repo, distribution = ?command.distribution, component = ?command.component, "adding package to index");
+    debug!(?sha256sum, repo = command.repo(), distribution = ?command.distribution, component = ?command.component, "adding package to index");
     let generate_index_request = GenerateIndexRequest {
-        change: PackageChange {
-            repository: command.repo.clone(),
-            distribution: command.distribution.clone(),
-            component: command.component.clone(),
-            action: PackageChangeAction::Add {
-                package_sha256sum: sha256sum.to_string(),
-            },
-        },
+        change: package_change(command, sha256sum),
+        then: Vec::new(),
     };
     let res = ctx
         .client
@@ -285,14 +1544,14 @@ pub async fn add_package(ctx: &Config, command: &PkgAddCommand, sha256sum: &str)
                 .join(
                     format!(
                         "/api/v0/repositories/{}/index",
-                        percent_encode(command.repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                        percent_encode(command.repo().as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
                     )
                     .as_str(),
                 )
                 .unwrap(),
         )
         .json(&generate_index_request)
-        .send()
+        .send_retrying(&ctx)
         .await
         .context("send api request")?;
     let (index, release_ts) = match res.status() {
@@ -320,7 +1579,7 @@ pub async fn add_package(ctx: &Config, command: &PkgAddCommand, sha256sum: &str)
         index,
     )
     .await
-    .context("sign index")?;
+    .context(SigningFailed)?;
 
     // Submit signatures.
     debug!("submitting signatures");
@@ -331,7 +1590,7 @@ pub async fn add_package(ctx: &Config, command: &PkgAddCommand, sha256sum: &str)
                 .join(
                     format!(
                         "/api/v0/repositories/{}/index",
-                        percent_encode(command.repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                        percent_encode(command.repo().as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
                     )
                     .as_str(),
                 )
@@ -339,12 +1598,13 @@ pub async fn add_package(ctx: &Config, command: &PkgAddCommand, sha256sum: &str)
         )
         .json(&SignIndexRequest {
             change: generate_index_request.change,
+            then: Vec::new(),
             release_ts,
             clearsigned: sig.clearsigned,
             detachsigned: sig.detachsigned,
             public_key_cert: sig.public_key_cert,
         })
-        .send()
+        .send_retrying(&ctx)
         .await
         .context("send api request")?;
     match res.status() {
@@ -370,11 +1630,241 @@ pub async fn add_package(ctx: &Config, command: &PkgAddCommand, sha256sum: &str)
 mod tests {
     use std::fs::read_dir;
 
-    use attune::testing::{AttuneTestServer, AttuneTestServerConfig, MIGRATOR, gpg_key_id};
+    use attune::{
+        apt::origin_signed_content,
+        testing::{
+            AttuneTestServer, AttuneTestServerConfig, MIGRATOR, TEST_PACKAGE_AMD64,
+            TEST_PACKAGE_ARM64, gpg_key_id, with_origin_signature,
+        },
+    };
     use workspace_root::get_workspace_root;
 
     use super::*;
 
+    #[test_log::test(tokio::test)]
+    async fn verify_upstream_detached_signature() {
+        let (key_id, _gpg, gpg_home_dir) = gpg_key_id().await.expect("failed to create GPG key");
+        let dir = gpg_home_dir.dir_path();
+
+        let sig = gpg_sign(
+            Some(dir.to_string_lossy()),
+            Some(&key_id),
+            TEST_PACKAGE_AMD64,
+        )
+        .await
+        .expect("failed to sign package");
+        let package_file = dir.join("package.deb");
+        let key_file = dir.join("vendor.asc");
+        std::fs::write(&package_file, TEST_PACKAGE_AMD64).unwrap();
+        std::fs::write(&key_file, &sig.public_key_cert).unwrap();
+        std::fs::write(dir.join("package.deb.asc"), &sig.detachsigned).unwrap();
+
+        let command = PkgAddCommand::builder()
+            .repo("unused")
+            .distribution("stable")
+            .component("main")
+            .require_upstream_sig(true)
+            .upstream_key(key_file.to_string_lossy())
+            .package_file(package_file.to_string_lossy())
+            .build();
+        let verified = verify_upstream_signature(&command).expect("signature should verify");
+        assert_eq!(verified.signature.kind, "detached");
+        assert_eq!(verified.path, dir.join("package.deb.asc"));
+        assert_eq!(verified.signature.key_fingerprint, key_id.to_ascii_uppercase());
+
+        // The same signature must not verify different package contents.
+        std::fs::write(&package_file, TEST_PACKAGE_ARM64).unwrap();
+        assert!(verify_upstream_signature(&command).is_err());
+    }
+
+    #[test_log::test(tokio::test)]
+    async fn verify_upstream_debsig_signature() {
+        let (key_id, _gpg, gpg_home_dir) = gpg_key_id().await.expect("failed to create GPG key");
+        let dir = gpg_home_dir.dir_path();
+
+        let signed = origin_signed_content(TEST_PACKAGE_AMD64).unwrap();
+        let sig = gpg_sign(Some(dir.to_string_lossy()), Some(&key_id), signed)
+            .await
+            .expect("failed to sign package");
+        let package_file = dir.join("package.deb");
+        let key_file = dir.join("vendor.asc");
+        std::fs::write(&key_file, &sig.public_key_cert).unwrap();
+
+        let command = PkgAddCommand::builder()
+            .repo("unused")
+            .distribution("stable")
+            .component("main")
+            .require_upstream_sig(true)
+            .upstream_key(key_file.to_string_lossy())
+            .package_file(package_file.to_string_lossy())
+            .build();
+
+        // Without a detached signature next to it, a package must have an
+        // embedded one.
+        std::fs::write(&package_file, TEST_PACKAGE_AMD64).unwrap();
+        let error = verify_upstream_signature(&command).unwrap_err();
+        assert!(
+            format!("{error:#}").contains("no upstream signature found"),
+            "unexpected error: {error:#}"
+        );
+
+        let package = with_origin_signature(TEST_PACKAGE_AMD64, sig.detachsigned.as_bytes());
+        std::fs::write(&package_file, package).unwrap();
+        let verified = verify_upstream_signature(&command).expect("signature should verify");
+        assert_eq!(verified.signature.kind, "debsig");
+        assert_eq!(verified.path, package_file);
+
+        // The signature must not verify another package's members.
+        let package = with_origin_signature(TEST_PACKAGE_ARM64, sig.detachsigned.as_bytes());
+        std::fs::write(&package_file, package).unwrap();
+        assert!(verify_upstream_signature(&command).is_err());
+    }
+
+    #[test_log::test(tokio::test)]
+    async fn expand_package_file_wildcards() {
+        let dir = async_tempfile::TempDir::new_in(Path::new("/tmp")).await.unwrap();
+        let dir = dir.dir_path();
+        for name in ["a.deb", "b.deb", "notes.txt"] {
+            std::fs::write(dir.join(name), TEST_PACKAGE_AMD64).unwrap();
+        }
+        let path = |name: &str| dir.join(name).to_string_lossy().to_string();
+
+        let patterns = [path("*.deb"), path("a.deb"), path("missing.deb")];
+        let expanded = expand_package_files(&patterns).expect("patterns should expand");
+        assert_eq!(expanded, vec![path("a.deb"), path("b.deb"), path("missing.deb")]);
+
+        let error = expand_package_files(&[path("*.udeb")]).unwrap_err();
+        assert_eq!(error.failure, Failure::Usage);
+    }
+
+    #[test]
+    fn detect_corrupted_upload() {
+        let checksums = |package: &[u8]| PackageUploadResponse {
+            sha256sum: hex::encode(Sha256::digest(package)),
+            sha512sum: Some(hex::encode(Sha512::digest(package))),
+            size: Some(package.len() as i64),
+        };
+        let sent = checksums(TEST_PACKAGE_AMD64);
+        verify_upload(&sent, &checksums(TEST_PACKAGE_AMD64)).expect("upload should verify");
+
+        let mut corrupted = TEST_PACKAGE_AMD64.to_vec();
+        corrupted[100] ^= 1;
+        assert!(verify_upload(&sent, &checksums(&corrupted)).is_err());
+        corrupted.truncate(TEST_PACKAGE_AMD64.len() - 1);
+        assert!(verify_upload(&sent, &checksums(&corrupted)).is_err());
+
+        // Servers that don't return a SHA512 sum are still checked against
+        // the SHA256 sum.
+        let received = PackageUploadResponse {
+            sha512sum: None,
+            size: None,
+            ..checksums(TEST_PACKAGE_AMD64)
+        };
+        verify_upload(&sent, &received).expect("upload should verify");
+    }
+
+    #[test]
+    fn warn_about_likely_typos() {
+        let repo = serde_json::from_value::<RepositoryInfoResponse>(serde_json::json!({
+            "id": 1,
+            "name": "test",
+            "uri": null,
+            "s3_bucket": "attune-test",
+            "s3_prefix": "test",
+            "flat": false,
+            "created_at": "2026-01-01T00:00:00Z",
+            "lock": null,
+            "release_fields": {},
+            "valid_until_days": null,
+            "pdiffs": false,
+            "contents_indexes": false,
+            "translations": false,
+            "prevent_downgrades": false,
+            "retain_versions": null,
+            "retain_days": null,
+            "distributions": [{
+                "distribution": "stable",
+                "suite": "stable",
+                "codename": "stable",
+                "components": ["main"],
+                "architectures": ["amd64"],
+                "package_count": 1,
+                "updated_at": "2026-01-01T00:00:00Z",
+                "signing_key_fingerprint": null,
+                "valid_until": null,
+            }],
+        }))
+        .unwrap();
+        let warnings = |distribution: &str, component: &str, architecture: &str| {
+            let command = PkgAddCommand::builder()
+                .repo("test")
+                .distribution(distribution)
+                .component(component)
+                .build();
+            preflight_warnings(&command, architecture, std::slice::from_ref(&repo))
+        };
+
+        assert!(warnings("stable", "main", "amd64").is_empty());
+        assert!(warnings("stable", "main", "all").is_empty());
+        assert_eq!(warnings("stable", "mian", "amd64").len(), 1);
+        assert_eq!(warnings("stable", "main", "arm64").len(), 1);
+        assert_eq!(warnings("stabel", "main", "arm64").len(), 1);
+    }
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn resume_interrupted_upload(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_tenant_id, api_token) = server.create_test_tenant("resume_interrupted_upload").await;
+        let ctx = Config::new(api_token, server.base_url);
+
+        // Start an upload that's interrupted before any parts are sent.
+        let sha256sum = hex::encode(Sha256::digest(TEST_PACKAGE_AMD64));
+        let res = ctx
+            .client
+            .post(ctx.endpoint.join("/api/v0/packages/uploads").unwrap())
+            .json(&CreateResumableUploadRequest {
+                size: TEST_PACKAGE_AMD64.len() as i64,
+                sha256sum: sha256sum.clone(),
+                part_size: Some(TEST_PACKAGE_AMD64.len() as i64 / 3 + 1),
+            })
+            .send()
+            .await
+            .unwrap();
+        let upload = parse_response::<ResumableUploadResponse>(res)
+            .await
+            .unwrap()
+            .upload;
+
+        let dir = async_tempfile::TempDir::new_in(Path::new("/tmp")).await.unwrap();
+        let package_file = dir.dir_path().join("package.deb");
+        std::fs::write(&package_file, TEST_PACKAGE_AMD64).unwrap();
+        let command = PkgAddCommand::builder()
+            .distribution("stable")
+            .component("main")
+            .package_file(package_file.to_string_lossy())
+            .resume(&upload.id)
+            .upload_concurrency(2)
+            .build();
+        let uploaded = upload_file_content(&ctx, &command)
+            .await
+            .expect("upload should resume");
+        assert_eq!(uploaded, sha256sum);
+
+        // The upload is gone once it's completed.
+        let res = ctx
+            .client
+            .get(ctx.endpoint.join(&format!("/api/v0/packages/uploads/{}", upload.id)).unwrap())
+            .send()
+            .await
+            .unwrap();
+        assert_eq!(res.status(), StatusCode::NOT_FOUND);
+    }
+
     #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
     async fn abort_on_concurrent_index_change(pool: sqlx::PgPool) {
         let (key_id, _gpg, gpg_home_dir) = gpg_key_id().await.expect("failed to create GPG key");
@@ -456,4 +1946,61 @@ mod tests {
             "at least one concurrent index change or detached signature verification error expected",
         );
     }
+
+    /// A package whose upload fails while an earlier package is still being
+    /// uploaded is reported as failed, not skipped, so that the batch fails.
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn report_failed_upload_in_batch(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        const REPO_NAME: &str = "report_failed_upload_in_batch";
+        let (tenant_id, api_token) = server.create_test_tenant(REPO_NAME).await;
+        server.create_repository(tenant_id, REPO_NAME).await;
+        let ctx = Config::new(api_token, server.base_url);
+
+        let dir = async_tempfile::TempDir::new_in(Path::new("/tmp")).await.unwrap();
+        let amd64 = dir.dir_path().join("amd64.deb");
+        let arm64 = dir.dir_path().join("arm64.deb");
+        std::fs::write(&amd64, TEST_PACKAGE_AMD64).unwrap();
+        std::fs::write(&arm64, TEST_PACKAGE_ARM64).unwrap();
+        let command = PkgAddCommand::builder()
+            .repo(REPO_NAME)
+            .distribution("stable")
+            .component("main")
+            .concurrency(2)
+            .build();
+        // The first package's upload waits on the API server, while the second
+        // fails before sending anything because its upstream key is missing.
+        let packages = [
+            PkgAddCommand {
+                package_file: amd64.to_string_lossy().to_string(),
+                ..command.clone()
+            },
+            PkgAddCommand {
+                package_file: arm64.to_string_lossy().to_string(),
+                require_upstream_sig: true,
+                upstream_key: Some(dir.dir_path().join("missing.asc").to_string_lossy().into()),
+                ..command.clone()
+            },
+        ];
+
+        let repos = [REPO_NAME.to_string()];
+        let (outcomes, failure) = add_each(&ctx, &repos, &packages, &command).await;
+        assert_eq!(failure, Some(Failure::Signing));
+        assert!(outcomes[0].skipped, "unexpected outcome: {:?}", outcomes[0]);
+        assert!(outcomes[0].error.is_none());
+        assert!(!outcomes[1].skipped);
+        assert!(
+            outcomes[1]
+                .error
+                .as_deref()
+                .is_some_and(|error| error.contains("upstream signature verification failed")),
+            "unexpected outcome: {:?}",
+            outcomes[1]
+        );
+    }
 }
@@ -0,0 +1,123 @@
+use std::process::ExitCode;
+
+use clap::Args;
+use colored::Colorize as _;
+
+use crate::{
+    cmd::apt::pkg::{
+        add::{PkgAddCommand, add_package_retrying, package_change},
+        list::resolve_package,
+    },
+    config::Config,
+};
+use attune::server::pkg::list::PackageListParams;
+
+#[derive(Args, Debug)]
+pub struct PkgCopyCommand {
+    /// The package to copy: either its SHA256 sum (see `attune apt pkg
+    /// list`), or `NAME=VERSION` to find a published package.
+    #[arg(value_name = "PACKAGE")]
+    package: String,
+
+    /// When finding a package by name, only consider packages published in
+    /// this repository.
+    #[arg(long)]
+    from_repo: Option<String>,
+    /// When finding a package by name, only consider packages published in
+    /// this distribution.
+    #[arg(long)]
+    from_distribution: Option<String>,
+    /// When finding a package by name, only consider packages published in
+    /// this component.
+    #[arg(long)]
+    from_component: Option<String>,
+    /// When finding a package by name, only consider packages with this
+    /// architecture.
+    #[arg(short, long, visible_alias = "arch")]
+    architecture: Option<String>,
+
+    /// Repository to copy the package to.
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, env = "ATTUNE_REPO", value_name = "REPO")]
+    to_repo: Option<String>,
+    /// Distribution to copy the package to
+    #[arg(long, default_value = "stable")]
+    to_distribution: String,
+    /// Component to copy the package to
+    #[arg(long, default_value = "main")]
+    to_component: String,
+
+    /// GPG key ID to sign the index with (see `gpg --list-secret-keys`).
+    ///
+    /// If not set and there is only one signing key available, that key will be
+    /// used. Otherwise, the command will fail.
+    #[arg(long, short)]
+    key_id: Option<String>,
+    /// GPG home directory to use for signing.
+    ///
+    /// If not set, defaults to the standard GPG home directory
+    /// for the platform.
+    #[arg(long, short)]
+    gpg_home_dir: Option<String>,
+
+    /// Copy the package even if its version is lower than the latest version
+    /// of it in the destination
+    ///
+    /// This only matters for repositories that prevent downgrades (see
+    /// `attune apt repo edit --prevent-downgrades`).
+    #[arg(long)]
+    allow_downgrade: bool,
+}
+
+/// Publish an uploaded package in another component, without uploading it
+/// again. This promotes packages between repositories, distributions, and
+/// components, for example from `unstable` to `stable`.
+pub async fn run(ctx: Config, command: PkgCopyCommand) -> ExitCode {
+    let repo = match ctx.repo(command.to_repo.clone()) {
+        Ok(repo) => repo,
+        Err(error) => return ctx.fail(error),
+    };
+    let filter = PackageListParams {
+        repository: command.from_repo.clone(),
+        distribution: command.from_distribution.clone(),
+        component: command.from_component.clone(),
+        architecture: command.architecture.clone(),
+        ..Default::default()
+    };
+    let sha256sum = match resolve_package(&ctx, &command.package, filter).await {
+        Ok(sha256sum) => sha256sum,
+        Err(error) => return ctx.fail(error),
+    };
+
+    let add = PkgAddCommand::builder()
+        .repo(repo)
+        .distribution(command.to_distribution)
+        .component(command.to_component)
+        .maybe_key_id(command.key_id)
+        .maybe_gpg_home_dir(command.gpg_home_dir)
+        .allow_downgrade(command.allow_downgrade)
+        .build();
+    match add_package_retrying(&ctx, &add, &sha256sum).await {
+        Ok(changed) => {
+            tracing::info!(?sha256sum, changed, "package copied");
+            match ctx.output.render(&package_change(&add, &sha256sum)) {
+                Some(output) => println!("{output}"),
+                None => {
+                    let action = match changed {
+                        true => "Copied".green(),
+                        false => "Already published".yellow(),
+                    };
+                    ctx.status(format!(
+                        "{action} {sha256sum} to {}/{}/{}",
+                        add.repo(),
+                        add.distribution,
+                        add.component
+                    ));
+                }
+            }
+            ExitCode::SUCCESS
+        }
+        Err(error) => ctx.report_error("copying package", error),
+    }
+}
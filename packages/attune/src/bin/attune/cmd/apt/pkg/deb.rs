@@ -0,0 +1,154 @@
+//! Reading package files locally, before they're uploaded.
+
+use color_eyre::eyre::{Context as _, Result, bail, eyre};
+use debian_packaging::{
+    binary_package_control::BinaryPackageControlFile,
+    deb::reader::{BinaryPackageEntry, BinaryPackageReader, ControlTarFile},
+    package_version::PackageVersion,
+};
+
+use attune::apt::{read_package_members, validate_member_compression};
+
+/// Read the control file of a Debian package.
+pub fn read_control_file(content: &[u8]) -> Result<BinaryPackageControlFile<'static>> {
+    let mut reader = BinaryPackageReader::new(content).context("read package archive")?;
+    let Some(BinaryPackageEntry::DebianBinary(_)) =
+        reader.next_entry().transpose().context("read package archive")?
+    else {
+        bail!("not a Debian package: expected a debian-binary member first");
+    };
+    let Some(BinaryPackageEntry::Control(mut control_reader)) =
+        reader.next_entry().transpose().context("read package archive")?
+    else {
+        bail!("not a Debian package: expected a control archive after debian-binary");
+    };
+    for entry in control_reader.entries().context("read control archive")? {
+        let (_, file) = entry
+            .context("read control archive")?
+            .to_control_file()
+            .context("read control archive")?;
+        if let ControlTarFile::Control(control_file) = file {
+            return Ok(control_file);
+        }
+    }
+    bail!("not a Debian package: control archive has no control file");
+}
+
+/// The name, version, and architecture of a Debian package, which identify it
+/// within a tenant.
+pub fn package_key(
+    control_file: &BinaryPackageControlFile<'_>,
+) -> Result<(String, String, String)> {
+    let name = control_file.package().context("read Package field")?;
+    let version = control_file.version().context("read Version field")?;
+    let architecture = control_file
+        .architecture()
+        .context("read Architecture field")?;
+    Ok((name.to_string(), version.to_string(), architecture.to_string()))
+}
+
+/// Check that a package is well-formed enough for the API server to accept,
+/// and return its name, version, and architecture.
+///
+/// The API server reads the same fields and archive members when the package
+/// is uploaded, so this catches a malformed package before a slow upload
+/// instead of after it. This includes archive members compressed in a way that
+/// the API server can't read.
+pub fn check_package(content: &[u8]) -> Result<(String, String, String)> {
+    let members =
+        read_package_members(content).map_err(|error| eyre!("not a Debian package: {error}"))?;
+    validate_member_compression(&members).map_err(|error| eyre!(error))?;
+    let control_file = read_control_file(content)?;
+    let (name, version, architecture) = package_key(&control_file)?;
+    PackageVersion::parse(&version)
+        .map_err(|error| eyre!("invalid Version field {version:?}: {error}"))?;
+    control_file
+        .maintainer()
+        .context("read Maintainer field")?;
+    control_file
+        .description()
+        .context("read Description field")?;
+    read_files(content)?;
+    Ok((name, version, architecture))
+}
+
+/// Read the paths of the files (but not directories) that a Debian package
+/// installs, relative to `/`, in the same form that the API server records
+/// them for Contents indexes.
+pub fn read_files(content: &[u8]) -> Result<Vec<String>> {
+    // The data archive follows the debian-binary member and the control
+    // archive.
+    let mut reader = BinaryPackageReader::new(content).context("read package archive")?;
+    for _ in 0..2 {
+        reader.next_entry().transpose().context("read package archive")?;
+    }
+    let Some(BinaryPackageEntry::Data(mut data_reader)) =
+        reader.next_entry().transpose().context("read package archive")?
+    else {
+        bail!("not a Debian package: expected a data archive after the control archive");
+    };
+    let mut files = Vec::new();
+    for entry in data_reader.entries().context("read data archive")? {
+        let entry = entry.context("read data archive")?;
+        if entry.header().entry_type().is_dir() {
+            continue;
+        }
+        let path = entry.path().context("read data archive")?;
+        let path = path.to_string_lossy();
+        let path = path.trim_start_matches("./").trim_start_matches('/');
+        if !path.is_empty() {
+            files.push(path.to_string());
+        }
+    }
+    Ok(files)
+}
+
+#[cfg(test)]
+mod tests {
+    use attune::testing::{TEST_PACKAGE_AMD64, build_test_package};
+
+    use super::*;
+
+    #[test]
+    fn read_package_key() {
+        let control_file = read_control_file(TEST_PACKAGE_AMD64).expect("package should parse");
+        let (_name, _version, architecture) =
+            package_key(&control_file).expect("package should have a key");
+        assert_eq!(architecture, "amd64");
+
+        assert!(read_control_file(b"not a package").is_err());
+    }
+
+    #[test]
+    fn check_well_formed_package() {
+        let (_name, _version, architecture) =
+            check_package(TEST_PACKAGE_AMD64).expect("package should be well-formed");
+        assert_eq!(architecture, "amd64");
+
+        // A package that was cut off is refused.
+        let truncated = &TEST_PACKAGE_AMD64[..TEST_PACKAGE_AMD64.len() / 2];
+        assert!(check_package(truncated).is_err());
+    }
+
+    #[test]
+    fn check_package_compression() {
+        for extension in ["gz", "zst"] {
+            let package = build_test_package(extension);
+            let (name, version, _architecture) = check_package(&package)
+                .unwrap_or_else(|error| panic!("{extension} package should be read: {error:#}"));
+            assert_eq!((name.as_str(), version.as_str()), ("attune-test-compression", "1.0.0"));
+            assert_eq!(
+                read_files(&package).unwrap(),
+                ["usr/share/doc/attune-test-compression/copyright"]
+            );
+        }
+
+        let error = check_package(&build_test_package("bz2")).unwrap_err();
+        assert!(
+            error
+                .to_string()
+                .starts_with("control.tar.bz2 is compressed with bzip2, which is not supported"),
+            "unexpected error: {error:#}"
+        );
+    }
+}
@@ -0,0 +1,188 @@
+use std::{io::Write as _, path::PathBuf, process::ExitCode};
+
+use axum::http::StatusCode;
+use clap::Args;
+use color_eyre::eyre::{Context as _, Result, bail};
+use percent_encoding::percent_encode;
+use serde::Serialize;
+use sha2::{Digest as _, Sha256};
+
+use crate::{
+    cmd::apt::pkg::list::resolve_package,
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+};
+use attune::{
+    api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    server::pkg::{info::PackageInfoResponse, list::PackageListParams},
+};
+
+#[derive(Args, Debug)]
+pub struct PkgDownloadCommand {
+    /// The package to download: either its SHA256 sum (see `attune apt pkg
+    /// list`), or `NAME=VERSION` to find a published package.
+    package: String,
+
+    /// When finding a package by name, only consider packages with this
+    /// architecture.
+    #[arg(short, long, visible_alias = "arch")]
+    architecture: Option<String>,
+    /// When finding a package by name, only consider packages published in
+    /// this repository.
+    #[arg(short, long)]
+    repository: Option<String>,
+
+    /// Write the package to this file. Defaults to
+    /// `<package>_<version>_<arch>.deb` in the current directory.
+    #[arg(long, short)]
+    file: Option<PathBuf>,
+    /// Overwrite the file if it already exists.
+    #[arg(long)]
+    force: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct DownloadOutput {
+    path: PathBuf,
+    package: String,
+    version: String,
+    architecture: String,
+    size: i64,
+    sha256sum: String,
+}
+
+pub async fn run(ctx: Config, command: PkgDownloadCommand) -> ExitCode {
+    let filter = PackageListParams {
+        repository: command.repository.clone(),
+        architecture: command.architecture.clone(),
+        ..Default::default()
+    };
+    let sha256sum = match resolve_package(&ctx, &command.package, filter).await {
+        Ok(sha256sum) => sha256sum,
+        Err(error) => return ctx.fail(error),
+    };
+
+    let info = match fetch_info(&ctx, &sha256sum).await {
+        Ok(info) => info,
+        Err(error) => return ctx.report_error("showing package", error),
+    };
+    let content = match fetch_content(&ctx, &sha256sum).await {
+        Ok(content) => content,
+        Err(error) => return ctx.report_error("downloading package", error),
+    };
+
+    // The package passes through object storage and any proxies in between, so
+    // check it against the checksum recorded when it was uploaded.
+    let downloaded = hex::encode(Sha256::digest(&content));
+    if !downloaded.eq_ignore_ascii_case(&info.sha256sum) || content.len() as i64 != info.size {
+        return ctx.error(
+            Failure::Validation,
+            format!(
+                "downloaded package has SHA256 sum {downloaded} and size {}, but {} and {} \
+                 were expected",
+                content.len(),
+                info.sha256sum,
+                info.size
+            ),
+        );
+    }
+
+    let path = command.file.clone().unwrap_or_else(|| {
+        PathBuf::from(format!(
+            "{}_{}_{}.deb",
+            info.package, info.version, info.architecture
+        ))
+    });
+    let written = std::fs::OpenOptions::new()
+        .write(true)
+        .truncate(true)
+        .create(command.force)
+        .create_new(!command.force)
+        .open(&path)
+        .and_then(|mut file| file.write_all(&content));
+    if let Err(error) = written {
+        return match error.kind() {
+            std::io::ErrorKind::AlreadyExists => ctx.error(
+                Failure::Conflict,
+                format!("{path:?} already exists; pass --force to overwrite it"),
+            ),
+            _ => ctx.error(
+                Failure::General,
+                format!("could not write {path:?}: {error}"),
+            ),
+        };
+    }
+
+    let output = DownloadOutput {
+        path,
+        package: info.package,
+        version: info.version,
+        architecture: info.architecture,
+        size: info.size,
+        sha256sum: info.sha256sum,
+    };
+    match ctx.output.render(&output) {
+        Some(rendered) => println!("{rendered}"),
+        None => ctx.status(format!(
+            "Downloaded {} {} ({}) to {:?}; SHA256 sum verified",
+            output.package, output.version, output.architecture, output.path
+        )),
+    }
+    ExitCode::SUCCESS
+}
+
+/// Fetch a package's metadata, including where it's published.
+pub async fn fetch_info(ctx: &Config, sha256sum: &str) -> Result<PackageInfoResponse> {
+    let res = ctx
+        .client
+        .get(
+            ctx.endpoint
+                .join(&format!(
+                    "/api/v0/packages/{}",
+                    percent_encode(sha256sum.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                ))
+                .unwrap(),
+        )
+        .send_retrying(ctx)
+        .await
+        .context("send API request")?;
+    match res.status() {
+        StatusCode::OK => res
+            .json::<PackageInfoResponse>()
+            .await
+            .context("parse response"),
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .context("parse error response")?;
+            bail!(error);
+        }
+    }
+}
+
+async fn fetch_content(ctx: &Config, sha256sum: &str) -> Result<Vec<u8>> {
+    let res = ctx
+        .client
+        .get(
+            ctx.endpoint
+                .join(&format!(
+                    "/api/v0/packages/{}/download",
+                    percent_encode(sha256sum.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                ))
+                .unwrap(),
+        )
+        .send_retrying(ctx)
+        .await
+        .context("send API request")?;
+    match res.status() {
+        StatusCode::OK => Ok(res.bytes().await.context("read package")?.to_vec()),
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .context("parse error response")?;
+            bail!(error);
+        }
+    }
+}
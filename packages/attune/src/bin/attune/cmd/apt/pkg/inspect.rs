@@ -0,0 +1,199 @@
+use std::{collections::BTreeMap, process::ExitCode};
+
+use clap::Args;
+use color_eyre::eyre::{Context as _, Result, eyre};
+use md5::Md5;
+use serde::Serialize;
+use sha1::Sha1;
+use sha2::{Digest as _, Sha256};
+
+use crate::{
+    cmd::apt::pkg::{add::read_stdin, deb},
+    config::Config,
+    exit::Failure,
+    output::OutputFormat,
+};
+use attune::apt::{
+    Package, PackageMember, PackagesIndex, PublishedPackage, index_component, read_package_members,
+};
+
+#[derive(Args, Debug)]
+pub struct PkgInspectCommand {
+    /// Path to the package file, or `-` to read it from standard input.
+    package_file: String,
+    /// Component to show the package's Packages index entry for, which
+    /// determines its `Filename`.
+    #[arg(long, short, default_value = "main")]
+    component: String,
+}
+
+#[derive(Serialize, Debug)]
+struct PackageInspection {
+    package: String,
+    version: String,
+    architecture: String,
+    size: i64,
+    md5sum: String,
+    sha1sum: String,
+    sha256sum: String,
+    /// The members of the package's `ar` archive.
+    members: Vec<PackageMember>,
+    fields: BTreeMap<String, String>,
+    /// The files that the package installs, relative to `/`.
+    files: Vec<String>,
+    /// The package's entry in the Packages index of `component`, as the API
+    /// server would render it.
+    index_entry: String,
+}
+
+/// Show what's in a local package file without uploading it: its control
+/// fields, checksums, archive members, installed files, and Packages index
+/// entry. This doesn't need the API server.
+pub async fn run(ctx: Config, command: PkgInspectCommand) -> ExitCode {
+    let content = match command.package_file.as_str() {
+        "-" => match read_stdin(None) {
+            Ok(content) => content.to_vec(),
+            Err(error) => return ctx.fail(error),
+        },
+        path => match std::fs::read(path) {
+            Ok(content) => content,
+            Err(error) => {
+                return ctx.error(Failure::Usage, format!("could not read {path:?}: {error}"));
+            }
+        },
+    };
+    let udeb = command.package_file.ends_with(".udeb");
+    let inspection = match inspect(&content, &command.component, udeb) {
+        Ok(inspection) => inspection,
+        Err(error) => {
+            return ctx.error(
+                Failure::Validation,
+                format!(
+                    "{:?} is not a valid Debian package: {error:#}",
+                    command.package_file
+                ),
+            );
+        }
+    };
+    if let Some(output) = ctx.output.render(&inspection) {
+        println!("{output}");
+        return ExitCode::SUCCESS;
+    }
+
+    // Delimited output is only the table of files, so that it can be parsed.
+    if ctx.output == OutputFormat::Text {
+        println!("Package:      {}", inspection.package);
+        println!("Version:      {}", inspection.version);
+        println!("Architecture: {}", inspection.architecture);
+        println!("Size:         {} bytes", inspection.size);
+        println!("MD5sum:       {}", inspection.md5sum);
+        println!("SHA1:         {}", inspection.sha1sum);
+        println!("SHA256:       {}", inspection.sha256sum);
+        println!("Archive members:");
+        for member in &inspection.members {
+            match &member.compression {
+                Some(compression) => println!(
+                    "  {} ({} bytes, compression: {compression})",
+                    member.name, member.size
+                ),
+                None => println!("  {} ({} bytes)", member.name, member.size),
+            }
+        }
+        println!("Control fields:");
+        for (key, value) in &inspection.fields {
+            // Continuation lines of multi-line fields, like `Description`,
+            // stay indented under their field.
+            println!("  {key}: {}", value.replace('\n', "\n  "));
+        }
+        println!(
+            "Packages index entry in {}:",
+            index_component(&command.component, udeb)
+        );
+        for line in inspection.index_entry.lines() {
+            println!("  {line}");
+        }
+        if inspection.files.is_empty() {
+            println!("\nInstalls no files");
+            return ExitCode::SUCCESS;
+        }
+        println!();
+    }
+
+    let mut rows = vec![vec![String::from("File")]];
+    for file in inspection.files {
+        rows.push(vec![format!("/{file}")]);
+    }
+    println!("{}", ctx.output.table(rows));
+    ExitCode::SUCCESS
+}
+
+/// Read everything that `inspect` shows about a package.
+fn inspect(content: &[u8], component: &str, udeb: bool) -> Result<PackageInspection> {
+    let members = read_package_members(content).map_err(|error| eyre!(error))?;
+    let (name, version, architecture) = deb::check_package(content)?;
+    let control_file = deb::read_control_file(content)?;
+    let files = deb::read_files(content)?;
+    let fields = control_file
+        .as_str_hash_map()
+        .into_iter()
+        .map(|(key, value)| (key.to_string(), value.to_string()))
+        .collect();
+
+    let package = Package {
+        name: name.clone(),
+        version: version.clone(),
+        architecture: architecture.clone(),
+        paragraph: Package::paragraph_from_control_file(&control_file),
+        size: i64::try_from(content.len()).context("package is too large")?,
+        s3_bucket: String::new(),
+        md5sum: hex::encode(Md5::digest(content)),
+        sha1sum: hex::encode(Sha1::digest(content)),
+        sha256sum: hex::encode(Sha256::digest(content)),
+        udeb,
+    };
+    let index = PackagesIndex::from_packages(
+        &package.index_component(component),
+        &architecture,
+        vec![PublishedPackage::from_package(package.clone(), component)],
+    );
+
+    Ok(PackageInspection {
+        package: name,
+        version,
+        architecture,
+        size: package.size,
+        md5sum: package.md5sum,
+        sha1sum: package.sha1sum,
+        sha256sum: package.sha256sum,
+        members,
+        fields,
+        files,
+        index_entry: index.contents,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use attune::testing::TEST_PACKAGE_AMD64;
+
+    use super::*;
+
+    #[test]
+    fn inspect_test_package() {
+        let inspection =
+            inspect(TEST_PACKAGE_AMD64, "main", false).expect("package should be inspected");
+        assert_eq!(inspection.architecture, "amd64");
+        assert_eq!(inspection.size, TEST_PACKAGE_AMD64.len() as i64);
+        assert_eq!(inspection.members.len(), 3);
+        assert_eq!(inspection.fields.get("Package"), Some(&inspection.package));
+        assert!(inspection.index_entry.contains(&format!(
+            "Filename: pool/main/{}",
+            &inspection.package[..1]
+        )));
+        assert!(
+            inspection
+                .index_entry
+                .contains(&format!("SHA256: {}", inspection.sha256sum))
+        );
+    }
+}
@@ -0,0 +1,312 @@
+//! Checking packages for common mistakes before they're uploaded.
+
+use std::{fmt, io::ErrorKind, process::Command};
+
+use clap::ValueEnum;
+use color_eyre::eyre::{Context as _, Result, bail};
+use debian_packaging::binary_package_control::BinaryPackageControlFile;
+use lazy_regex::lazy_regex;
+use tracing::{debug, instrument};
+
+use crate::cmd::apt::pkg::deb;
+
+/// What to do when linting a package finds errors.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum LintMode {
+    /// Refuse to upload the package.
+    Error,
+    /// Upload the package anyway.
+    Warn,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub enum Severity {
+    Error,
+    Warning,
+}
+
+/// A problem found in a package, named after the lintian tag that it
+/// corresponds to.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Finding {
+    pub severity: Severity,
+    pub tag: String,
+    pub detail: String,
+}
+
+impl Finding {
+    fn new(severity: Severity, tag: &str, detail: impl Into<String>) -> Self {
+        Self {
+            severity,
+            tag: tag.to_string(),
+            detail: detail.into(),
+        }
+    }
+}
+
+impl fmt::Display for Finding {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let severity = match self.severity {
+            Severity::Error => "E",
+            Severity::Warning => "W",
+        };
+        write!(f, "{severity}: {}", self.tag)?;
+        if !self.detail.is_empty() {
+            write!(f, " {}", self.detail)?;
+        }
+        Ok(())
+    }
+}
+
+/// Which linter checked a package.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Linter {
+    Lintian,
+    BuiltIn,
+}
+
+impl fmt::Display for Linter {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            Linter::Lintian => write!(f, "lintian"),
+            Linter::BuiltIn => write!(f, "built-in checks"),
+        }
+    }
+}
+
+/// Lint a package with lintian, or with a built-in subset of its checks if
+/// lintian isn't installed.
+///
+/// `path` is the package file, if it has one; packages read from standard
+/// input only get the built-in checks.
+#[instrument(skip(content))]
+pub fn lint(path: Option<&str>, content: &[u8]) -> Result<(Linter, Vec<Finding>)> {
+    if let Some(path) = path {
+        match run_lintian(path)? {
+            Some(findings) => return Ok((Linter::Lintian, findings)),
+            None => debug!("lintian is not installed, using built-in checks"),
+        }
+    }
+    Ok((Linter::BuiltIn, check(content)?))
+}
+
+/// Run lintian on a package file, or return `None` if it isn't installed.
+fn run_lintian(path: &str) -> Result<Option<Vec<Finding>>> {
+    let output = match Command::new("lintian").arg(path).output() {
+        Ok(output) => output,
+        Err(error) if error.kind() == ErrorKind::NotFound => return Ok(None),
+        Err(error) => return Err(error).context("run lintian"),
+    };
+    // lintian exits with 1 when it finds errors, and with other codes when it
+    // couldn't check the package.
+    if !matches!(output.status.code(), Some(0 | 1)) {
+        bail!(
+            "lintian failed ({}): {}",
+            output.status,
+            String::from_utf8_lossy(&output.stderr).trim()
+        );
+    }
+    Ok(Some(parse_lintian(&String::from_utf8_lossy(&output.stdout))))
+}
+
+/// Parse lintian's errors and warnings, like `E: hello: some-tag detail`.
+/// Informational tags and other output are skipped.
+fn parse_lintian(output: &str) -> Vec<Finding> {
+    output
+        .lines()
+        .filter_map(|line| {
+            let (severity, rest) = line.split_once(": ")?;
+            let severity = match severity {
+                "E" => Severity::Error,
+                "W" => Severity::Warning,
+                _ => return None,
+            };
+            // Skip the package name (and type, like `hello binary`).
+            let (_package, rest) = rest.split_once(": ")?;
+            let (tag, detail) = rest.split_once(' ').unwrap_or((rest, ""));
+            Some(Finding::new(severity, tag, detail))
+        })
+        .collect()
+}
+
+/// Check a package for a subset of the problems that lintian reports.
+fn check(content: &[u8]) -> Result<Vec<Finding>> {
+    let control_file = deb::read_control_file(content)?;
+    let files = deb::read_files(content)?;
+    let mut findings = check_control_file(&control_file)?;
+    findings.extend(check_files(&control_file, &files)?);
+    findings.sort_by_key(|finding| finding.severity);
+    Ok(findings)
+}
+
+fn check_control_file(control_file: &BinaryPackageControlFile<'_>) -> Result<Vec<Finding>> {
+    let mut findings = Vec::new();
+    let name = control_file.package().context("read Package field")?;
+    if !lazy_regex!(r"^[a-z0-9][a-z0-9+.\-]+$").is_match(name) {
+        findings.push(Finding::new(Severity::Error, "bad-package-name", name));
+    }
+    let maintainer = control_file
+        .maintainer()
+        .context("read Maintainer field")?;
+    if !lazy_regex!(r"^[^<>]+ <[^<>@\s]+@[^<>@\s]+>$").is_match(maintainer) {
+        findings.push(Finding::new(
+            Severity::Error,
+            "malformed-contact",
+            format!("Maintainer {maintainer}"),
+        ));
+    }
+    if control_file.section().is_none() {
+        findings.push(Finding::new(Severity::Warning, "no-section-field", ""));
+    }
+    if control_file.priority().is_none() {
+        findings.push(Finding::new(Severity::Warning, "no-priority-field", ""));
+    }
+
+    let description = control_file
+        .description()
+        .context("read Description field")?;
+    let mut lines = description.lines();
+    let synopsis = lines.next().unwrap_or_default().trim();
+    if synopsis.is_empty() {
+        findings.push(Finding::new(Severity::Error, "description-synopsis-is-empty", ""));
+    } else if synopsis.chars().count() > 80 {
+        findings.push(Finding::new(Severity::Warning, "synopsis-too-long", synopsis));
+    }
+    if lines.all(|line| matches!(line.trim(), "" | ".")) {
+        findings.push(Finding::new(Severity::Warning, "extended-description-is-empty", ""));
+    }
+    Ok(findings)
+}
+
+fn check_files(
+    control_file: &BinaryPackageControlFile<'_>,
+    files: &[String],
+) -> Result<Vec<Finding>> {
+    let mut findings = Vec::new();
+    if files.is_empty() {
+        findings.push(Finding::new(Severity::Warning, "empty-binary-package", ""));
+        return Ok(findings);
+    }
+    for file in files {
+        let tag = if file.starts_with("usr/local/") {
+            "dir-or-file-in-usr-local"
+        } else if file.starts_with("home/") {
+            "dir-or-file-in-home"
+        } else if file.starts_with("tmp/") {
+            "dir-or-file-in-tmp"
+        } else {
+            continue;
+        };
+        findings.push(Finding::new(Severity::Error, tag, format!("[{file}]")));
+    }
+    let name = control_file.package().context("read Package field")?;
+    let copyright = format!("usr/share/doc/{name}/copyright");
+    if !files.contains(&copyright) {
+        findings.push(Finding::new(Severity::Warning, "no-copyright-file", ""));
+    }
+    Ok(findings)
+}
+
+#[cfg(test)]
+mod tests {
+    use attune::testing::TEST_PACKAGE_AMD64;
+    use debian_packaging::{
+        control::ControlParagraph, debian_source_control::DebianSourceControlFile,
+    };
+    use indoc::indoc;
+
+    use super::*;
+
+    fn control_file(contents: &str) -> BinaryPackageControlFile<'static> {
+        let dsc = DebianSourceControlFile::from_reader(contents.as_bytes()).unwrap();
+        BinaryPackageControlFile::from(ControlParagraph::from(dsc))
+    }
+
+    fn tags(findings: Vec<Finding>) -> Vec<String> {
+        findings.into_iter().map(|finding| finding.tag).collect()
+    }
+
+    #[test]
+    fn parse_lintian_output() {
+        let output = indoc! {"
+            E: hello: malformed-contact Maintainer nobody
+            W: hello: no-manual-page [usr/bin/hello]
+            I: hello: hardening-no-fortify-functions [usr/bin/hello]
+            N: 1 hint overridden
+        "};
+        assert_eq!(
+            parse_lintian(output),
+            [
+                Finding::new(Severity::Error, "malformed-contact", "Maintainer nobody"),
+                Finding::new(Severity::Warning, "no-manual-page", "[usr/bin/hello]"),
+            ]
+        );
+    }
+
+    #[test]
+    fn check_control_fields() {
+        let good = control_file(indoc! {"
+            Package: hello
+            Version: 1.0.0
+            Architecture: amd64
+            Maintainer: Attune <attune@example.com>
+            Section: utils
+            Priority: optional
+            Description: Say hello
+             Prints a friendly greeting.
+        "});
+        assert_eq!(check_control_file(&good).unwrap(), []);
+
+        let bad = control_file(indoc! {"
+            Package: Hello_World
+            Version: 1.0.0
+            Architecture: amd64
+            Maintainer: attune@example.com
+            Description: Say hello
+        "});
+        assert_eq!(
+            tags(check_control_file(&bad).unwrap()),
+            [
+                "bad-package-name",
+                "malformed-contact",
+                "no-section-field",
+                "no-priority-field",
+                "extended-description-is-empty",
+            ]
+        );
+    }
+
+    #[test]
+    fn check_installed_files() {
+        let control_file = control_file(indoc! {"
+            Package: hello
+            Version: 1.0.0
+            Architecture: amd64
+            Maintainer: Attune <attune@example.com>
+            Description: Say hello
+        "});
+        let files = [
+            "usr/bin/hello",
+            "usr/local/bin/hello",
+            "tmp/hello.log",
+            "usr/share/doc/hello/copyright",
+        ]
+        .map(String::from);
+        assert_eq!(
+            tags(check_files(&control_file, &files).unwrap()),
+            ["dir-or-file-in-usr-local", "dir-or-file-in-tmp"]
+        );
+        assert_eq!(
+            tags(check_files(&control_file, &files[..1]).unwrap()),
+            ["no-copyright-file"]
+        );
+    }
+
+    #[test]
+    fn check_test_package() {
+        // Whatever the package's findings are, it can be checked without
+        // lintian.
+        check(TEST_PACKAGE_AMD64).expect("package should be checked");
+        assert!(check(b"not a package").is_err());
+    }
+}
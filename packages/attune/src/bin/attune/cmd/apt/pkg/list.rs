@@ -1,12 +1,19 @@
-use std::process::ExitCode;
+use std::{collections::BTreeSet, process::ExitCode};
 
 use axum::http::StatusCode;
-use clap::Args;
+use clap::{Args, ValueEnum};
+use color_eyre::eyre::{Context as _, Result, bail};
 
-use crate::config::Config;
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::{CommandError, Failure},
+    output::ColumnArgs,
+};
 use attune::{
     api::ErrorResponse,
-    server::pkg::list::{PackageListParams, PackageListResponse},
+    server::pkg::list::{
+        MAX_PAGE_SIZE, Package, PackageListParams, PackageListResponse, PackageSort,
+    },
 };
 
 #[derive(Args, Debug)]
@@ -23,59 +30,223 @@ pub struct PkgListCommand {
     version: Option<String>,
     #[arg(short, long)]
     architecture: Option<String>,
+
+    /// Sort packages by this field.
+    #[arg(long, value_enum)]
+    sort: Option<SortField>,
+
+    /// Maximum number of packages to list.
+    ///
+    /// If there are more packages, the cursor to continue from is printed.
+    #[arg(long, conflicts_with = "all", value_parser = clap::value_parser!(u64).range(1..))]
+    limit: Option<u64>,
+    /// Continue listing after the cursor printed by a previous `--limit`ed
+    /// listing.
+    #[arg(long)]
+    cursor: Option<String>,
+    /// List every package, fetching as many pages as needed. This is the
+    /// default unless `--limit` is set.
+    #[arg(long)]
+    all: bool,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+enum SortField {
+    Name,
+    Version,
+    Architecture,
+    Repository,
+    Distribution,
+    Component,
+}
+
+impl From<SortField> for PackageSort {
+    fn from(field: SortField) -> Self {
+        match field {
+            SortField::Name => PackageSort::Name,
+            SortField::Version => PackageSort::Version,
+            SortField::Architecture => PackageSort::Architecture,
+            SortField::Repository => PackageSort::Repository,
+            SortField::Distribution => PackageSort::Distribution,
+            SortField::Component => PackageSort::Component,
+        }
+    }
 }
 
 pub async fn run(ctx: Config, command: PkgListCommand) -> ExitCode {
-    let res = ctx
-        .client
-        .get(ctx.endpoint.join("/api/v0/packages").unwrap())
-        .query(&PackageListParams {
-            repository: command.repository,
-            distribution: command.distribution,
-            component: command.component,
-            name: command.name,
-            version: command.version,
-            architecture: command.architecture,
-        })
-        .send()
-        .await
-        .expect("Could not send API request");
-    match res.status() {
-        StatusCode::OK => {
-            let packages = res
+    let packages = match list_packages(&ctx, &command).await {
+        Ok(packages) => packages,
+        Err(error) => return ctx.report_error("listing packages", error),
+    };
+    if let Some(cursor) = &packages.next_cursor {
+        ctx.status(format!(
+            "More packages are available; continue with --cursor {cursor}"
+        ));
+    }
+    if let Some(output) = ctx.output.render(&packages) {
+        println!("{output}");
+        return ExitCode::SUCCESS;
+    }
+    let mut rows = vec![
+        [
+            "Package",
+            "Version",
+            "Architecture",
+            "Repository",
+            "Distribution",
+            "Component",
+            "SHA256",
+        ]
+        .map(String::from)
+        .to_vec(),
+    ];
+    for package in packages.packages {
+        rows.push(vec![
+            package.name,
+            package.version,
+            package.architecture,
+            package.repository,
+            package.distribution,
+            package.component,
+            package.sha256sum,
+        ]);
+    }
+    let rows = match command.columns.select(rows, &["sha256"]) {
+        Ok(rows) => rows,
+        Err(error) => return ctx.error(Failure::Usage, error),
+    };
+    println!("{}", ctx.output.table(rows));
+    ExitCode::SUCCESS
+}
+
+/// List packages, following cursors until `--limit` packages have been
+/// fetched, or until there are no more packages.
+async fn list_packages(ctx: &Config, command: &PkgListCommand) -> Result<PackageListResponse> {
+    // `--all` and `--limit` conflict, so this only spells out the default.
+    let limit = match command.all {
+        true => None,
+        false => command.limit,
+    };
+    let mut packages = Vec::new();
+    let mut cursor = command.cursor.clone();
+    loop {
+        let remaining = limit
+            .map(|limit| limit.saturating_sub(packages.len() as u64))
+            .unwrap_or(u64::MAX);
+        let res = ctx
+            .client
+            .get(ctx.endpoint.join("/api/v0/packages").unwrap())
+            .query(&PackageListParams {
+                repository: command.repository.clone(),
+                distribution: command.distribution.clone(),
+                component: command.component.clone(),
+                name: command.name.clone(),
+                version: command.version.clone(),
+                architecture: command.architecture.clone(),
+                limit: Some(remaining.min(MAX_PAGE_SIZE as u64) as i64),
+                cursor,
+                sort: command.sort.map(PackageSort::from),
+            })
+            .send_retrying(ctx)
+            .await
+            .context("send API request")?;
+        let page = match res.status() {
+            StatusCode::OK => res
                 .json::<PackageListResponse>()
                 .await
-                .expect("Could not parse response");
-            let mut builder = tabled::builder::Builder::new();
-            builder.push_record([
-                "Package",
-                "Version",
-                "Architecture",
-                "Repository",
-                "Distribution",
-                "Component",
-            ]);
-            for package in packages.packages {
-                builder.push_record([
-                    package.name,
-                    package.version,
-                    package.architecture,
-                    package.repository,
-                    package.distribution,
-                    package.component,
-                ]);
+                .context("parse response")?,
+            _ => {
+                let error = res
+                    .json::<ErrorResponse>()
+                    .await
+                    .context("parse error response")?;
+                bail!(error);
             }
-            let table = builder.build();
-            println!("{table}");
-            ExitCode::SUCCESS
+        };
+        packages.extend(page.packages);
+        cursor = page.next_cursor;
+        if cursor.is_none() || limit.is_some_and(|limit| packages.len() as u64 >= limit) {
+            return Ok(PackageListResponse {
+                packages,
+                next_cursor: cursor,
+            });
         }
-        _ => {
-            let error = res
-                .json::<ErrorResponse>()
+    }
+}
+
+/// Find the SHA256 sum of a package, given either as its SHA256 sum or as
+/// `NAME=VERSION`. Packages given by name must be published somewhere that
+/// matches `filter`, and only one package may match.
+pub async fn resolve_package(
+    ctx: &Config,
+    package: &str,
+    filter: PackageListParams,
+) -> Result<String, CommandError> {
+    let Some((name, version)) = package.split_once('=') else {
+        return Ok(package.to_string());
+    };
+    let filter = PackageListParams {
+        name: Some(name.to_string()),
+        version: Some(version.to_string()),
+        ..filter
+    };
+    // The same package is listed once for each component that publishes it.
+    let sha256sums = list_published(ctx, filter)
+        .await
+        .map_err(CommandError::from_report)?
+        .into_iter()
+        .map(|pkg| pkg.sha256sum)
+        .collect::<BTreeSet<_>>();
+    match sha256sums.len() {
+        0 => Err(CommandError::new(
+            Failure::NotFound,
+            format!("no published package {name} at version {version}"),
+        )),
+        1 => Ok(sha256sums.into_iter().next().unwrap()),
+        n => Err(CommandError::new(
+            Failure::Usage,
+            format!("{n} different packages match {name}={version}; pass its SHA256 sum instead"),
+        )),
+    }
+}
+
+/// List every published package that matches `filter`, fetching as many pages
+/// as needed.
+pub async fn list_published(ctx: &Config, filter: PackageListParams) -> Result<Vec<Package>> {
+    let mut packages = Vec::new();
+    let mut cursor = None;
+    loop {
+        let res = ctx
+            .client
+            .get(ctx.endpoint.join("/api/v0/packages").unwrap())
+            .query(&PackageListParams {
+                limit: Some(MAX_PAGE_SIZE),
+                cursor,
+                ..filter.clone()
+            })
+            .send_retrying(ctx)
+            .await
+            .context("send API request")?;
+        let page = match res.status() {
+            StatusCode::OK => res
+                .json::<PackageListResponse>()
                 .await
-                .expect("Could not parse error response");
-            eprintln!("Error listing packages: {}", error.message);
-            ExitCode::FAILURE
+                .context("parse response")?,
+            _ => {
+                let error = res
+                    .json::<ErrorResponse>()
+                    .await
+                    .context("parse error response")?;
+                bail!(error);
+            }
+        };
+        packages.extend(page.packages);
+        cursor = page.next_cursor;
+        if cursor.is_none() {
+            return Ok(packages);
         }
     }
 }
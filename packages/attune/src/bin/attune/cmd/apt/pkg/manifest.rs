@@ -0,0 +1,162 @@
+//! Manifests that describe a set of packages to add at once, so that a whole
+//! release can be reviewed as one file and published with
+//! `attune apt pkg add --file`.
+
+use std::path::Path;
+
+use color_eyre::eyre::{Context as _, Result, bail, eyre};
+use serde::Deserialize;
+
+use crate::cmd::apt::pkg::add::{PkgAddCommand, expand_package_files};
+
+/// The packages to add, and where to add them.
+///
+/// Settings on a package override the manifest's, which override the
+/// command's flags. Paths are relative to the manifest's directory.
+#[derive(Deserialize, Debug, PartialEq, Eq)]
+#[serde(deny_unknown_fields)]
+pub struct PackageManifest {
+    pub distribution: Option<String>,
+    pub component: Option<String>,
+    /// Path to the vendor's armored OpenPGP public key. When set, every
+    /// package must have a valid upstream signature.
+    pub upstream_key: Option<String>,
+    pub packages: Vec<PackageEntry>,
+}
+
+#[derive(Deserialize, Debug, PartialEq, Eq)]
+#[serde(deny_unknown_fields)]
+pub struct PackageEntry {
+    /// Path to the package file, which may contain wildcards.
+    pub file: String,
+    pub distribution: Option<String>,
+    pub component: Option<String>,
+    pub upstream_key: Option<String>,
+    /// Path to the vendor's detached signature over the package file, if it
+    /// isn't next to the package file. Only allowed if `file` names a single
+    /// package.
+    pub upstream_sig: Option<String>,
+}
+
+/// Read a manifest, and build the command to add each of its packages in the
+/// order they're listed.
+pub fn read(path: &Path, command: &PkgAddCommand) -> Result<Vec<PkgAddCommand>> {
+    let contents = std::fs::read_to_string(path).with_context(|| format!("read {path:?}"))?;
+    let manifest = serde_yaml::from_str::<PackageManifest>(&contents)
+        .with_context(|| format!("parse {path:?}"))?;
+    let base = path.parent().unwrap_or(Path::new(""));
+    commands(manifest, base, command).with_context(|| format!("in {path:?}"))
+}
+
+fn commands(
+    manifest: PackageManifest,
+    base: &Path,
+    command: &PkgAddCommand,
+) -> Result<Vec<PkgAddCommand>> {
+    if manifest.packages.is_empty() {
+        bail!("no packages are listed");
+    }
+    let resolve = |path: String| base.join(path).to_string_lossy().to_string();
+
+    let mut commands = Vec::new();
+    for entry in manifest.packages {
+        let package_files = expand_package_files(&[resolve(entry.file.clone())])
+            .map_err(|error| eyre!(error.message))?;
+        if entry.upstream_sig.is_some() && package_files.len() > 1 {
+            bail!(
+                "upstream_sig is set for {:?}, which matches {} packages",
+                entry.file,
+                package_files.len()
+            );
+        }
+        let upstream_key = entry
+            .upstream_key
+            .or_else(|| manifest.upstream_key.clone())
+            .map(resolve);
+        let entry_command = PkgAddCommand {
+            distribution: entry
+                .distribution
+                .or_else(|| manifest.distribution.clone())
+                .unwrap_or_else(|| command.distribution.clone()),
+            component: entry
+                .component
+                .or_else(|| manifest.component.clone())
+                .unwrap_or_else(|| command.component.clone()),
+            require_upstream_sig: command.require_upstream_sig || upstream_key.is_some(),
+            upstream_key: upstream_key.or_else(|| command.upstream_key.clone()),
+            upstream_sig: entry.upstream_sig.map(resolve),
+            ..command.clone()
+        };
+        commands.extend(package_files.into_iter().map(|package_file| PkgAddCommand {
+            package_file,
+            ..entry_command.clone()
+        }));
+    }
+    Ok(commands)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn package_settings_override_manifest_and_flags() {
+        let manifest = serde_yaml::from_str::<PackageManifest>(
+            r#"
+            component: main
+            upstream_key: vendor.asc
+            packages:
+              - file: hello_1.0_amd64.deb
+              - file: hello-dbgsym_1.0_amd64.deb
+                component: debug
+              - file: hello_1.0_arm64.deb
+                distribution: bookworm
+                upstream_sig: sigs/hello_1.0_arm64.deb.asc
+            "#,
+        )
+        .expect("manifest should parse");
+        let command = PkgAddCommand::builder()
+            .distribution("stable")
+            .component("contrib")
+            .build();
+
+        let commands = commands(manifest, Path::new("release"), &command)
+            .expect("manifest should build commands");
+        let targets = commands
+            .iter()
+            .map(|command| {
+                (
+                    command.package_file.as_str(),
+                    command.distribution.as_str(),
+                    command.component.as_str(),
+                    command.upstream_sig.as_deref(),
+                )
+            })
+            .collect::<Vec<_>>();
+        assert_eq!(
+            targets,
+            vec![
+                ("release/hello_1.0_amd64.deb", "stable", "main", None),
+                ("release/hello-dbgsym_1.0_amd64.deb", "stable", "debug", None),
+                (
+                    "release/hello_1.0_arm64.deb",
+                    "bookworm",
+                    "main",
+                    Some("release/sigs/hello_1.0_arm64.deb.asc")
+                ),
+            ]
+        );
+        for command in &commands {
+            assert!(command.require_upstream_sig);
+            assert_eq!(command.upstream_key.as_deref(), Some("release/vendor.asc"));
+        }
+    }
+
+    #[test]
+    fn rejects_unknown_fields() {
+        let error = serde_yaml::from_str::<PackageManifest>(
+            "packages:\n  - file: hello.deb\n    section: utils\n",
+        );
+        assert!(error.is_err());
+    }
+}
@@ -4,9 +4,22 @@ use clap::{Args, Subcommand};
 
 use crate::config::Config;
 
-mod add;
-mod list;
-mod remove;
+pub mod add;
+mod copy;
+mod deb;
+mod download;
+mod inspect;
+mod lint;
+pub mod list;
+mod manifest;
+mod r#move;
+mod owns;
+mod ratelimit;
+pub mod remove;
+mod search;
+mod show;
+mod translate;
+mod versions;
 
 #[derive(Args, Debug)]
 pub struct PkgCommand {
@@ -19,18 +32,56 @@ pub enum PkgSubCommand {
     /// Upload a new package
     #[command(visible_aliases = ["new", "upload"])]
     Add(add::PkgAddCommand),
+    /// Publish an uploaded package in another component
+    #[command(visible_alias = "cp")]
+    Copy(copy::PkgCopyCommand),
+    /// Download a package and verify its checksum
+    Download(download::PkgDownloadCommand),
+    /// Show what's in a local package file, without uploading it
+    Inspect(inspect::PkgInspectCommand),
     /// Show information about packages
     #[command(visible_alias = "ls")]
     List(list::PkgListCommand),
+    /// Move a package to another component of its distribution
+    #[command(visible_alias = "mv")]
+    Move(r#move::PkgMoveCommand),
+    /// Find the published packages that install a file
+    Owns(owns::PkgOwnsCommand),
     /// Remove a package
     #[command(visible_aliases = ["rm", "delete"])]
     Remove(remove::PkgRemoveCommand),
+    /// Search package names, descriptions, and maintainers
+    #[command(visible_alias = "find")]
+    Search(search::PkgSearchCommand),
+    /// Show a package's control fields, checksums, and where it's published
+    Show(show::PkgShowCommand),
+    /// Set or delete a translation of a package's description
+    Translate(translate::PkgTranslateCommand),
+    /// List every stored version of a package and where each is published
+    Versions(versions::PkgVersionsCommand),
+}
+
+impl PkgCommand {
+    /// Whether the command works without the API server, so it doesn't need an
+    /// API token.
+    pub fn is_offline(&self) -> bool {
+        matches!(self.subcommand, PkgSubCommand::Inspect(_))
+    }
 }
 
 pub async fn handle_pkg(ctx: Config, command: PkgCommand) -> ExitCode {
     match command.subcommand {
         PkgSubCommand::Add(add) => add::run(ctx, add).await,
+        PkgSubCommand::Copy(copy) => copy::run(ctx, copy).await,
+        PkgSubCommand::Download(download) => download::run(ctx, download).await,
+        PkgSubCommand::Inspect(inspect) => inspect::run(ctx, inspect).await,
         PkgSubCommand::List(list) => list::run(ctx, list).await,
+        PkgSubCommand::Move(command) => r#move::run(ctx, command).await,
+        PkgSubCommand::Owns(owns) => owns::run(ctx, owns).await,
         PkgSubCommand::Remove(remove) => remove::run(ctx, remove).await,
+        PkgSubCommand::Search(search) => search::run(ctx, search).await,
+        PkgSubCommand::Show(show) => show::run(ctx, show).await,
+        PkgSubCommand::Translate(translate) => translate::run(ctx, translate).await,
+        PkgSubCommand::Versions(versions) => versions::run(ctx, versions).await,
     }
 }
@@ -0,0 +1,344 @@
+use std::process::ExitCode;
+
+use clap::Args;
+use color_eyre::eyre::{Context as _, Result, bail};
+use colored::Colorize as _;
+use http::StatusCode;
+use percent_encoding::percent_encode;
+use tracing::{debug, instrument};
+
+use crate::{
+    cmd::apt::pkg::{
+        add::{self, PkgAddCommand},
+        download::fetch_info,
+        list::resolve_package,
+        remove::{self, PkgRemoveCommand, remove_package_retrying},
+    },
+    config::{Config, SendRetrying as _},
+    exit::{Failure, SigningFailed},
+    gpg_sign, retry_delay_default, retry_infinite,
+};
+use attune::{
+    api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    server::{
+        pkg::list::PackageListParams,
+        repo::index::{
+            generate::{GenerateIndexRequest, GenerateIndexResponse},
+            sign::{SignIndexRequest, SignIndexResponse},
+        },
+    },
+};
+
+#[derive(Args, Debug)]
+pub struct PkgMoveCommand {
+    /// The package to move: either its SHA256 sum (see `attune apt pkg list`),
+    /// or `NAME=VERSION`.
+    #[arg(value_name = "PACKAGE")]
+    package: String,
+
+    /// Name of the repository to move the package within.
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, short, env = "ATTUNE_REPO")]
+    repo: Option<String>,
+    /// Distribution to move the package within
+    #[arg(long, short, default_value = "stable")]
+    distribution: String,
+    /// Component to move the package from.
+    ///
+    /// If not set, the package must be published in exactly one other
+    /// component of the distribution.
+    #[arg(long)]
+    from_component: Option<String>,
+    /// Component to move the package to
+    #[arg(long)]
+    to_component: String,
+    /// When finding a package by name, only consider packages with this
+    /// architecture.
+    #[arg(short, long, visible_alias = "arch")]
+    architecture: Option<String>,
+
+    /// GPG key ID to sign the index with (see `gpg --list-secret-keys`).
+    ///
+    /// If not set and there is only one signing key available, that key will be
+    /// used. Otherwise, the command will fail.
+    #[arg(long, short)]
+    key_id: Option<String>,
+    /// GPG home directory to use for signing.
+    ///
+    /// If not set, defaults to the standard GPG home directory
+    /// for the platform.
+    #[arg(long, short)]
+    gpg_home_dir: Option<String>,
+
+    /// Move the package even if its version is lower than the latest version
+    /// of it in the new component
+    ///
+    /// This only matters for repositories that prevent downgrades (see
+    /// `attune apt repo edit --prevent-downgrades`).
+    #[arg(long)]
+    allow_downgrade: bool,
+}
+
+/// Move a package from one component of a distribution to another, without
+/// uploading it again.
+///
+/// The package is removed from its old component and added to its new one in
+/// a single index change, so a single Release file publishes the move and the
+/// package is never missing from the distribution. If the package is already
+/// in its new component, it's only removed from its old one.
+pub async fn run(ctx: Config, command: PkgMoveCommand) -> ExitCode {
+    let repo = match ctx.repo(command.repo.clone()) {
+        Ok(repo) => repo,
+        Err(error) => return ctx.fail(error),
+    };
+    if command.from_component.as_ref() == Some(&command.to_component) {
+        return ctx.error(
+            Failure::Usage,
+            "the package is already in the component it would be moved to",
+        );
+    }
+    let filter = PackageListParams {
+        repository: Some(repo.clone()),
+        distribution: Some(command.distribution.clone()),
+        component: command.from_component.clone(),
+        architecture: command.architecture.clone(),
+        ..Default::default()
+    };
+    let sha256sum = match resolve_package(&ctx, &command.package, filter).await {
+        Ok(sha256sum) => sha256sum,
+        Err(error) => return ctx.fail(error),
+    };
+    let info = match fetch_info(&ctx, &sha256sum).await {
+        Ok(info) => info,
+        Err(error) => return ctx.report_error("showing package", error),
+    };
+
+    // Find the component to move the package from. The package may already be
+    // in the new component, if it was added there separately.
+    let mut components = info
+        .publications
+        .iter()
+        .filter(|publication| {
+            publication.repository == repo
+                && publication.distribution == command.distribution
+                && publication.component != command.to_component
+        })
+        .map(|publication| publication.component.clone())
+        .collect::<Vec<_>>();
+    if let Some(from_component) = &command.from_component {
+        components.retain(|component| component == from_component);
+    }
+    let from_component = match components.as_slice() {
+        [component] => component.clone(),
+        [] => {
+            return ctx.error(
+                Failure::NotFound,
+                format!(
+                    "{} {} ({}) is not published in {repo}/{}{}",
+                    info.package,
+                    info.version,
+                    info.architecture,
+                    command.distribution,
+                    match &command.from_component {
+                        Some(component) => format!("/{component}"),
+                        None => String::new(),
+                    }
+                ),
+            );
+        }
+        _ => {
+            return ctx.error(
+                Failure::Usage,
+                format!(
+                    "{} {} ({}) is published in components {}; pass --from-component",
+                    info.package,
+                    info.version,
+                    info.architecture,
+                    components.join(", ")
+                ),
+            );
+        }
+    };
+
+    let add_command = PkgAddCommand::builder()
+        .repo(repo.clone())
+        .distribution(command.distribution.clone())
+        .component(command.to_component.clone())
+        .maybe_key_id(command.key_id.clone())
+        .maybe_gpg_home_dir(command.gpg_home_dir.clone())
+        .allow_downgrade(command.allow_downgrade)
+        .build();
+    let remove_command = PkgRemoveCommand::builder()
+        .repo(repo.clone())
+        .distribution(command.distribution.clone())
+        .component(&from_component)
+        .maybe_key_id(command.key_id)
+        .maybe_gpg_home_dir(command.gpg_home_dir)
+        .package(info.package)
+        .version(info.version)
+        .architecture(info.architecture)
+        .build();
+    let in_new_component = info.publications.iter().any(|publication| {
+        publication.repository == repo
+            && publication.distribution == command.distribution
+            && publication.component == command.to_component
+    });
+    let changes = if in_new_component {
+        if let Err(error) = remove_package_retrying(&ctx, &remove_command).await {
+            return ctx.report_error("removing package from old component", error);
+        }
+        vec![remove::package_change(&remove_command)]
+    } else {
+        if let Err(error) =
+            move_package_retrying(&ctx, &add_command, &remove_command, &sha256sum).await
+        {
+            return ctx.report_error("moving package", error);
+        }
+        vec![
+            remove::package_change(&remove_command),
+            add::package_change(&add_command, &sha256sum),
+        ]
+    };
+    tracing::info!(?sha256sum, "package moved");
+
+    match ctx.output.render(&changes) {
+        Some(output) => println!("{output}"),
+        None => ctx.status(format!(
+            "{} {sha256sum} from {}/{}/{} to {}",
+            "Moved".green(),
+            add_command.repo(),
+            add_command.distribution,
+            from_component,
+            add_command.component
+        )),
+    }
+    ExitCode::SUCCESS
+}
+
+/// Move a package in a single index change, retrying if another change to the
+/// index raced with this one.
+async fn move_package_retrying(
+    ctx: &Config,
+    add_command: &PkgAddCommand,
+    remove_command: &PkgRemoveCommand,
+    sha256sum: &str,
+) -> Result<()> {
+    retry_infinite(
+        || move_package(ctx, add_command, remove_command, sha256sum),
+        |error| match error.downcast_ref::<ErrorResponse>() {
+            Some(res) => match res.error.as_str() {
+                "CONCURRENT_INDEX_CHANGE" | "DETACHED_SIGNATURE_VERIFICATION_FAILED" => {
+                    tracing::warn!(error = ?res, "retrying: concurrent index change");
+                    true
+                }
+                _ => false,
+            },
+            None => false,
+        },
+        retry_delay_default,
+    )
+    .await
+    .map_err(|mut error| {
+        if let Some(res) = error
+            .downcast_mut::<ErrorResponse>()
+            .filter(|res| res.error == "VERSION_DOWNGRADE")
+        {
+            res.message.push_str("; pass --allow-downgrade to move it anyway");
+        }
+        error
+    })
+}
+
+/// Generate an index that removes the package from its old component and adds
+/// it to its new one, and sign it.
+#[instrument]
+async fn move_package(
+    ctx: &Config,
+    add_command: &PkgAddCommand,
+    remove_command: &PkgRemoveCommand,
+    sha256sum: &str,
+) -> Result<()> {
+    debug!("moving package in index");
+    let generate_index_request = GenerateIndexRequest {
+        change: remove::package_change(remove_command),
+        then: vec![add::package_change(add_command, sha256sum)],
+    };
+    let endpoint = ctx
+        .endpoint
+        .join(
+            format!(
+                "/api/v0/repositories/{}/index",
+                percent_encode(add_command.repo().as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+            )
+            .as_str(),
+        )
+        .context("join endpoint")?;
+    let res = ctx
+        .client
+        .get(endpoint.clone())
+        .json(&generate_index_request)
+        .send_retrying(ctx)
+        .await
+        .context("send API request")?;
+    let (index, release_ts) = match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<GenerateIndexResponse>()
+                .await
+                .context("parse response")?;
+            debug!(index = ?res.release, "generated index to sign");
+            (res.release, res.release_ts)
+        }
+        status => {
+            let body = res.text().await.context("read response")?;
+            debug!(?body, ?status, "error response");
+            let error =
+                serde_json::from_str::<ErrorResponse>(&body).context("parse error response")?;
+            bail!(error);
+        }
+    };
+
+    // Sign index locally.
+    let sig = gpg_sign(
+        add_command.gpg_home_dir.as_deref(),
+        add_command.key_id.as_deref(),
+        index,
+    )
+    .await
+    .context(SigningFailed)?;
+
+    // Submit signatures.
+    debug!("submitting signatures");
+    let res = ctx
+        .client
+        .post(endpoint)
+        .json(&SignIndexRequest {
+            change: generate_index_request.change,
+            then: generate_index_request.then,
+            release_ts,
+            clearsigned: sig.clearsigned,
+            detachsigned: sig.detachsigned,
+            public_key_cert: sig.public_key_cert,
+        })
+        .send_retrying(ctx)
+        .await
+        .context("send API request")?;
+    match res.status() {
+        StatusCode::OK => {
+            let _ = res
+                .json::<SignIndexResponse>()
+                .await
+                .context("parse response")?;
+            debug!("signed index");
+            Ok(())
+        }
+        status => {
+            let body = res.text().await.context("read response")?;
+            debug!(?body, ?status, "error response");
+            let error =
+                serde_json::from_str::<ErrorResponse>(&body).context("parse error response")?;
+            bail!(error);
+        }
+    }
+}
@@ -0,0 +1,107 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::ColumnArgs,
+};
+use attune::{
+    api::ErrorResponse,
+    server::pkg::owns::{PackageOwnersParams, PackageOwnersResponse},
+};
+
+#[derive(Args, Debug)]
+pub struct PkgOwnsCommand {
+    /// The absolute path of an installed file, like `/usr/bin/hello`.
+    path: String,
+
+    #[arg(short, long)]
+    repository: Option<String>,
+    #[arg(short, long)]
+    distribution: Option<String>,
+    #[arg(short, long)]
+    component: Option<String>,
+    #[arg(short, long, visible_alias = "arch")]
+    architecture: Option<String>,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+/// Find the published packages that install a file, using the same file lists
+/// as Contents indexes.
+pub async fn run(ctx: Config, command: PkgOwnsCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/packages/owns").unwrap())
+        .query(&PackageOwnersParams {
+            path: command.path.clone(),
+            repository: command.repository.clone(),
+            distribution: command.distribution.clone(),
+            component: command.component.clone(),
+            architecture: command.architecture.clone(),
+        })
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    let owners = match res.status() {
+        StatusCode::OK => res
+            .json::<PackageOwnersResponse>()
+            .await
+            .expect("Could not parse response"),
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            return ctx.api_error("finding packages", error);
+        }
+    };
+    if let Some(output) = ctx.output.render(&owners) {
+        println!("{output}");
+        return ExitCode::SUCCESS;
+    }
+    if owners.packages.is_empty() {
+        return ctx.error(
+            Failure::NotFound,
+            format!("no published package installs /{}", owners.path),
+        );
+    }
+
+    let mut rows = vec![
+        [
+            "Package",
+            "Version",
+            "Architecture",
+            "Repository",
+            "Distribution",
+            "Component",
+            "SHA256",
+        ]
+        .map(String::from)
+        .to_vec(),
+    ];
+    for package in owners.packages {
+        rows.push(vec![
+            package.name,
+            package.version,
+            package.architecture,
+            package.repository,
+            package.distribution,
+            package.component,
+            package.sha256sum,
+        ]);
+    }
+    let rows = match command.columns.select(rows, &["sha256"]) {
+        Ok(rows) => rows,
+        Err(error) => return ctx.error(Failure::Usage, error),
+    };
+    println!("{}", ctx.output.table(rows));
+    ExitCode::SUCCESS
+}
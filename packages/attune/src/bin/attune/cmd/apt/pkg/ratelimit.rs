@@ -0,0 +1,113 @@
+//! Throttling for package uploads, so that publishing from a slow connection
+//! doesn't saturate its uplink.
+
+use std::{
+    sync::{Arc, Mutex},
+    time::Duration,
+};
+
+use bytes::Bytes;
+use futures_util::{StreamExt as _, stream};
+use tokio::time::Instant;
+
+/// Uploads are throttled this many bytes at a time.
+const CHUNK_SIZE: usize = 16 * 1024;
+
+/// Limits the combined throughput of every upload that shares it, including
+/// parts that are uploaded in parallel.
+#[derive(Debug, Clone)]
+pub struct RateLimiter {
+    bytes_per_second: u64,
+    /// When the next chunk may be sent.
+    next: Arc<Mutex<Instant>>,
+}
+
+impl RateLimiter {
+    pub fn new(bytes_per_second: u64) -> Self {
+        Self {
+            bytes_per_second,
+            next: Arc::new(Mutex::new(Instant::now())),
+        }
+    }
+
+    /// Wait until `bytes` more bytes can be sent without exceeding the limit.
+    pub async fn acquire(&self, bytes: usize) {
+        let delay = Duration::from_secs_f64(bytes as f64 / self.bytes_per_second as f64);
+        let start = {
+            let mut next = self.next.lock().unwrap();
+            let start = (*next).max(Instant::now());
+            *next = start + delay;
+            start
+        };
+        tokio::time::sleep_until(start).await;
+    }
+
+    /// Build a request body that sends `content` no faster than the limit.
+    ///
+    /// Unlike buffered bodies, streamed bodies can't be cloned, so requests
+    /// with them aren't retried.
+    pub fn body(&self, content: Bytes) -> reqwest::Body {
+        let limiter = self.clone();
+        let chunks = (0..content.len())
+            .step_by(CHUNK_SIZE)
+            .map(move |offset| content.slice(offset..(offset + CHUNK_SIZE).min(content.len())));
+        reqwest::Body::wrap_stream(stream::iter(chunks).then(move |chunk| {
+            let limiter = limiter.clone();
+            async move {
+                limiter.acquire(chunk.len()).await;
+                Ok::<_, std::io::Error>(chunk)
+            }
+        }))
+    }
+}
+
+/// Parse a rate like curl's `--limit-rate`: a number of bytes per second,
+/// optionally suffixed with `K`, `M`, or `G` for KiB, MiB, or GiB.
+pub fn parse_rate(rate: &str) -> Result<RateLimiter, String> {
+    let invalid = || format!("{rate:?} is not a rate (like `500K` or `10M`)");
+    let (amount, multiplier) = match rate.char_indices().last().ok_or_else(invalid)? {
+        (split, 'k' | 'K') => (&rate[..split], 1024),
+        (split, 'm' | 'M') => (&rate[..split], 1024 * 1024),
+        (split, 'g' | 'G') => (&rate[..split], 1024 * 1024 * 1024),
+        _ => (rate, 1),
+    };
+    let amount = amount.parse::<u64>().map_err(|_| invalid())?;
+    match amount.checked_mul(multiplier) {
+        Some(0) => Err(String::from("the rate must be greater than zero")),
+        Some(bytes_per_second) => Ok(RateLimiter::new(bytes_per_second)),
+        None => Err(invalid()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parses_rate() {
+        for (rate, bytes_per_second) in [
+            ("2048", 2048),
+            ("500K", 500 * 1024),
+            ("10M", 10 * 1024 * 1024),
+            ("1g", 1024 * 1024 * 1024),
+        ] {
+            assert_eq!(parse_rate(rate).unwrap().bytes_per_second, bytes_per_second);
+        }
+        for rate in ["", "M", "0", "0K", "1.5M", "10MB", "-1"] {
+            assert!(parse_rate(rate).is_err(), "{rate:?} should be rejected");
+        }
+    }
+
+    #[tokio::test]
+    async fn throttles_shared_uploads() {
+        let limiter = parse_rate("1M").unwrap();
+        let started = Instant::now();
+
+        // The first chunk is sent right away, and the second has to wait for
+        // the first to have been sent at the limited rate.
+        let other = limiter.clone();
+        tokio::join!(limiter.acquire(256 * 1024), other.acquire(256 * 1024));
+        limiter.acquire(1).await;
+        assert!(started.elapsed() >= Duration::from_millis(500));
+    }
+}
@@ -3,27 +3,51 @@ use std::process::ExitCode;
 use bon::Builder;
 use clap::Args;
 use color_eyre::eyre::{Context as _, Result, bail};
+use colored::Colorize as _;
 use http::StatusCode;
+use inquire::Confirm;
+use lazy_regex::Regex;
 use percent_encoding::percent_encode;
+use time::{OffsetDateTime, format_description::well_known::Rfc3339};
 use tracing::{debug, info, instrument};
 
 use attune::{
     api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
-    server::repo::index::{
-        PackageChange, PackageChangeAction,
-        generate::{GenerateIndexRequest, GenerateIndexResponse},
-        sign::{SignIndexRequest, SignIndexResponse},
+    server::{
+        pkg::list::{Package, PackageListParams},
+        repo::index::{
+            PackageChange, PackageChangeAction,
+            generate::{GenerateIndexRequest, GenerateIndexResponse},
+            sign::{SignIndexRequest, SignIndexResponse},
+        },
     },
 };
 
-use crate::{config::Config, gpg_sign, retry_delay_default, retry_infinite};
+use crate::{
+    cmd::{
+        apt::{
+            pkg::list::list_published,
+            targets::{self, RepoTargets},
+        },
+        audit::list::parse_since,
+    },
+    config::{Config, SendRetrying as _},
+    exit::{CommandError, Failure, SigningFailed},
+    gpg_sign, retry_delay_default, retry_infinite,
+};
 
-#[derive(Args, Debug, Builder)]
+#[derive(Args, Debug, Builder, Clone)]
 pub struct PkgRemoveCommand {
-    /// Name of the repository to remove the package from
-    #[arg(long, short)]
+    /// Repositories to remove the package from
+    #[command(flatten)]
+    #[builder(default)]
+    targets: RepoTargets,
+    /// Name of the repository to remove the package from.
+    ///
+    /// [`run`] sets this for each of the `targets` in turn.
+    #[arg(skip)]
     #[builder(into)]
-    repo: String,
+    repo: Option<String>,
     /// Distribution to remove the package from
     #[arg(long, short)]
     #[builder(into)]
@@ -49,22 +73,303 @@ pub struct PkgRemoveCommand {
     gpg_home_dir: Option<String>,
 
     /// Name of the package to remove
-    #[arg(long, short)]
+    #[arg(long, short, required_unless_present = "pattern")]
     #[builder(into)]
-    package: String,
+    package: Option<String>,
     /// Version of the package to remove
-    #[arg(long, short)]
+    #[arg(long, short, required_unless_present = "pattern")]
     #[builder(into)]
-    version: String,
+    version: Option<String>,
     /// Architecture of the package to remove
-    #[arg(long, short)]
+    ///
+    /// With `--match`, only packages with this architecture are removed.
+    #[arg(long, short, required_unless_present = "pattern")]
     #[builder(into)]
-    architecture: String,
+    architecture: Option<String>,
+
+    /// Remove every package in the component whose name matches this glob
+    /// (like `foo-*`), instead of a single package
+    ///
+    /// The packages are listed before you're asked to confirm.
+    #[arg(
+        long = "match",
+        value_name = "PATTERN",
+        conflicts_with_all = ["package", "version"]
+    )]
+    #[builder(into)]
+    pattern: Option<String>,
+    /// Treat `--match` as a regular expression instead of a glob
+    ///
+    /// Regular expressions match anywhere in the name unless anchored with `^`
+    /// and `$`.
+    #[arg(long, requires = "pattern")]
+    #[builder(default)]
+    regex: bool,
+    /// With `--match`, only remove packages that were added to the component
+    /// this long ago (e.g. `90d`, `2w`), or before an RFC 3339 timestamp
+    #[arg(long, value_name = "AGE", requires = "pattern", value_parser = parse_since)]
+    older_than: Option<OffsetDateTime>,
+    /// List the packages that `--match` would remove, without removing them
+    #[arg(long, requires = "pattern")]
+    #[builder(default)]
+    dry_run: bool,
+
+    /// Skip confirmation prompt and proceed with removal
+    #[arg(short, long)]
+    #[builder(default)]
+    yes: bool,
+}
+
+impl PkgRemoveCommand {
+    /// The name of the repository, which [`run`] resolves before using.
+    fn repo(&self) -> &str {
+        self.repo.as_deref().expect("repository is resolved")
+    }
+
+    /// The name of the package, which is set unless removing by `--match`.
+    fn package(&self) -> &str {
+        self.package.as_deref().expect("package is set")
+    }
+
+    /// The version of the package, which is set unless removing by `--match`.
+    fn version(&self) -> &str {
+        self.version.as_deref().expect("version is set")
+    }
+
+    /// The architecture of the package, which is set unless removing by
+    /// `--match`.
+    fn architecture(&self) -> &str {
+        self.architecture.as_deref().expect("architecture is set")
+    }
 }
 
 pub async fn run(ctx: Config, command: PkgRemoveCommand) -> ExitCode {
-    let res = retry_infinite(
-        || remove_package(&ctx, &command),
+    let repos = match command.targets.clone().resolve(&ctx).await {
+        Ok(repos) => repos,
+        Err(error) => return ctx.fail(error),
+    };
+    if command.pattern.is_some() {
+        return remove_matching(&ctx, &command, &repos).await;
+    }
+    let for_repo = |repo: &str| PkgRemoveCommand {
+        repo: Some(repo.to_string()),
+        ..command.clone()
+    };
+    if !command.yes {
+        let locations = repos
+            .iter()
+            .map(|repo| format!("{repo}/{}/{}", command.distribution, command.component))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let confirm = Confirm::new(&format!(
+            "Remove {} {} {} from {locations}?",
+            command.package(),
+            command.version(),
+            command.architecture(),
+        ))
+        .with_default(false)
+        .prompt();
+        match confirm {
+            Ok(true) => {}
+            Ok(false) => return ExitCode::SUCCESS,
+            Err(e) => {
+                eprintln!("Aborting: {e}");
+                return ExitCode::FAILURE;
+            }
+        }
+    }
+
+    if let [repo] = repos.as_slice() {
+        let command = for_repo(repo);
+        return match remove_package_retrying(&ctx, &command).await {
+            Ok(_) => {
+                info!(package = command.package(), "package removed from index");
+                match ctx.output.render(&package_change(&command)) {
+                    Some(output) => println!("{output}"),
+                    None => removed(&ctx, &command),
+                }
+                ExitCode::SUCCESS
+            }
+            Err(error) => ctx.report_error("removing package from index", error),
+        };
+    }
+
+    // Keep going when removing from one repository fails, so that the report
+    // shows every repository that still has the package.
+    let mut outcomes = Vec::with_capacity(repos.len());
+    for repo in repos {
+        let command = for_repo(&repo);
+        let result = remove_package_retrying(&ctx, &command)
+            .await
+            .map(|()| {
+                info!(package = command.package(), %repo, "package removed from index");
+                if !ctx.output.is_structured() {
+                    removed(&ctx, &command);
+                }
+            })
+            .map_err(CommandError::from_report);
+        outcomes.push((repo, result));
+    }
+    let (report, error) = targets::report(&ctx, "removing package", outcomes);
+    println!("{report}");
+    match error {
+        Some(error) => ctx.fail(error),
+        None => ExitCode::SUCCESS,
+    }
+}
+
+/// Remove every package matching `--match` (and `--older-than`) from the
+/// command's component in each repository, after listing them and asking for
+/// confirmation.
+async fn remove_matching(ctx: &Config, command: &PkgRemoveCommand, repos: &[String]) -> ExitCode {
+    let pattern = command.pattern.as_deref().expect("pattern is set");
+    let matcher = match NameMatcher::new(pattern, command.regex) {
+        Ok(matcher) => matcher,
+        Err(error) => return ctx.error(Failure::Usage, error),
+    };
+    let mut matched = Vec::new();
+    for repo in repos {
+        let filter = PackageListParams {
+            repository: Some(repo.clone()),
+            distribution: Some(command.distribution.clone()),
+            component: Some(command.component.clone()),
+            architecture: command.architecture.clone(),
+            ..Default::default()
+        };
+        let packages = match list_published(ctx, filter).await {
+            Ok(packages) => packages,
+            Err(error) => return ctx.report_error("listing packages", error),
+        };
+        matched.extend(packages.into_iter().filter(|pkg| {
+            matcher.is_match(&pkg.name)
+                && command
+                    .older_than
+                    .is_none_or(|older_than| pkg.added_at < older_than)
+        }));
+    }
+
+    if command.dry_run {
+        match ctx.output.render(&matched) {
+            Some(output) => println!("{output}"),
+            None => println!("{}", matched_table(ctx, &matched)),
+        }
+        ctx.status(format!("Would remove {} packages", matched.len()));
+        return ExitCode::SUCCESS;
+    }
+    if matched.is_empty() {
+        ctx.status(format!("No packages match {pattern:?}"));
+        return ExitCode::SUCCESS;
+    }
+    if !command.yes {
+        eprintln!("{}", matched_table(ctx, &matched));
+        let confirm = Confirm::new(&format!("Remove these {} packages?", matched.len()))
+            .with_default(false)
+            .prompt();
+        match confirm {
+            Ok(true) => {}
+            Ok(false) => return ExitCode::SUCCESS,
+            Err(e) => {
+                eprintln!("Aborting: {e}");
+                return ExitCode::FAILURE;
+            }
+        }
+    }
+
+    // Each removal is signed and published on its own, so a failed bulk
+    // removal can be finished by running it again.
+    let mut changes = Vec::with_capacity(matched.len());
+    for (removed_count, pkg) in matched.iter().enumerate() {
+        let command = PkgRemoveCommand {
+            repo: Some(pkg.repository.clone()),
+            package: Some(pkg.name.clone()),
+            version: Some(pkg.version.clone()),
+            architecture: Some(pkg.architecture.clone()),
+            ..command.clone()
+        };
+        if let Err(error) = remove_package_retrying(ctx, &command).await {
+            let doing = format!(
+                "removing package ({removed_count} of {} removed)",
+                matched.len()
+            );
+            return ctx.report_error(&doing, error);
+        }
+        info!(package = command.package(), repo = command.repo(), "package removed from index");
+        if !ctx.output.is_structured() {
+            removed(ctx, &command);
+        }
+        changes.push(package_change(&command));
+    }
+    if let Some(output) = ctx.output.render(&changes) {
+        println!("{output}");
+    }
+    ExitCode::SUCCESS
+}
+
+/// The packages that `--match` selected, as a table.
+fn matched_table(ctx: &Config, matched: &[Package]) -> String {
+    let mut rows = vec![
+        ["Package", "Version", "Architecture", "Repository", "Added"]
+            .map(String::from)
+            .to_vec(),
+    ];
+    for pkg in matched {
+        rows.push(vec![
+            pkg.name.clone(),
+            pkg.version.clone(),
+            pkg.architecture.clone(),
+            pkg.repository.clone(),
+            pkg.added_at.format(&Rfc3339).unwrap(),
+        ]);
+    }
+    ctx.output.table(rows)
+}
+
+/// Matches package names against `--match`.
+#[derive(Debug)]
+enum NameMatcher {
+    Glob(glob::Pattern),
+    Regex(Regex),
+}
+
+impl NameMatcher {
+    fn new(pattern: &str, regex: bool) -> Result<Self, String> {
+        match regex {
+            true => Regex::new(pattern)
+                .map(NameMatcher::Regex)
+                .map_err(|error| format!("invalid regular expression {pattern:?}: {error}")),
+            false => glob::Pattern::new(pattern)
+                .map(NameMatcher::Glob)
+                .map_err(|error| format!("invalid pattern {pattern:?}: {error}")),
+        }
+    }
+
+    fn is_match(&self, name: &str) -> bool {
+        match self {
+            NameMatcher::Glob(pattern) => pattern.matches(name),
+            NameMatcher::Regex(regex) => regex.is_match(name),
+        }
+    }
+}
+
+/// Print that the package was removed from the command's repository.
+fn removed(ctx: &Config, command: &PkgRemoveCommand) {
+    ctx.status(format!(
+        "{} {} {} ({}) from {}/{}/{}",
+        "Removed".red(),
+        command.package(),
+        command.version(),
+        command.architecture(),
+        command.repo(),
+        command.distribution,
+        command.component
+    ));
+}
+
+/// Remove a package from the index, retrying if another change to the index
+/// raced with this one.
+pub async fn remove_package_retrying(ctx: &Config, command: &PkgRemoveCommand) -> Result<()> {
+    retry_infinite(
+        || remove_package(ctx, command),
         |error| match error.downcast_ref::<ErrorResponse>() {
             Some(res) => match res.error.as_str() {
                 "CONCURRENT_INDEX_CHANGE" | "DETACHED_SIGNATURE_VERIFICATION_FAILED" => {
@@ -77,17 +382,20 @@ pub async fn run(ctx: Config, command: PkgRemoveCommand) -> ExitCode {
         },
         retry_delay_default,
     )
-    .await;
+    .await
+}
 
-    match res {
-        Ok(_) => {
-            info!(?command.package, "package removed from index");
-            ExitCode::SUCCESS
-        }
-        Err(error) => {
-            eprintln!("Error removing package from index: {error:#?}");
-            ExitCode::FAILURE
-        }
+/// The index change that removes the package.
+pub fn package_change(command: &PkgRemoveCommand) -> PackageChange {
+    PackageChange {
+        repository: command.repo().to_string(),
+        distribution: command.distribution.clone(),
+        component: command.component.clone(),
+        action: PackageChangeAction::Remove {
+            name: command.package().to_string(),
+            version: command.version().to_string(),
+            architecture: command.architecture().to_string(),
+        },
     }
 }
 
@@ -95,16 +403,8 @@ pub async fn run(ctx: Config, command: PkgRemoveCommand) -> ExitCode {
 pub async fn remove_package(ctx: &Config, command: &PkgRemoveCommand) -> Result<()> {
     debug!("removing package from index");
     let generate_index_request = GenerateIndexRequest {
-        change: PackageChange {
-            repository: command.repo.clone(),
-            distribution: command.distribution.clone(),
-            component: command.component.clone(),
-            action: PackageChangeAction::Remove {
-                name: command.package.clone(),
-                version: command.version.clone(),
-                architecture: command.architecture.clone(),
-            },
-        },
+        change: package_change(command),
+        then: Vec::new(),
     };
     let res = ctx
         .client
@@ -113,14 +413,14 @@ pub async fn remove_package(ctx: &Config, command: &PkgRemoveCommand) -> Result<
                 .join(
                     format!(
                         "/api/v0/repositories/{}/index",
-                        percent_encode(command.repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                        percent_encode(command.repo().as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
                     )
                     .as_str(),
                 )
                 .context("join endpoint")?,
         )
         .json(&generate_index_request)
-        .send()
+        .send_retrying(&ctx)
         .await
         .context("send API request")?;
     let (index, release_ts) = match res.status() {
@@ -148,7 +448,7 @@ pub async fn remove_package(ctx: &Config, command: &PkgRemoveCommand) -> Result<
         index,
     )
     .await
-    .context("sign index")?;
+    .context(SigningFailed)?;
 
     // Submit signatures.
     debug!("submitting signatures");
@@ -159,7 +459,7 @@ pub async fn remove_package(ctx: &Config, command: &PkgRemoveCommand) -> Result<
                 .join(
                     format!(
                         "/api/v0/repositories/{}/index",
-                        percent_encode(command.repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                        percent_encode(command.repo().as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
                     )
                     .as_str(),
                 )
@@ -167,12 +467,13 @@ pub async fn remove_package(ctx: &Config, command: &PkgRemoveCommand) -> Result<
         )
         .json(&SignIndexRequest {
             change: generate_index_request.change,
+            then: Vec::new(),
             release_ts,
             clearsigned: sig.clearsigned,
             detachsigned: sig.detachsigned,
             public_key_cert: sig.public_key_cert,
         })
-        .send()
+        .send_retrying(&ctx)
         .await
         .context("send API request")?;
     match res.status() {
@@ -203,7 +504,22 @@ mod tests {
 
     use super::*;
     use crate::cmd::apt::pkg::add::{PkgAddCommand, add_package, upload_file_content};
-    use attune::server::pkg::list::{PackageListParams, PackageListResponse};
+    use attune::server::pkg::list::PackageListResponse;
+
+    #[test]
+    fn matches_names() {
+        let glob = NameMatcher::new("libfoo-*", false).unwrap();
+        assert!(glob.is_match("libfoo-dev"));
+        assert!(!glob.is_match("libfoo"));
+        assert!(!glob.is_match("xlibfoo-dev"));
+
+        let regex = NameMatcher::new("^libfoo[0-9]+$", true).unwrap();
+        assert!(regex.is_match("libfoo2"));
+        assert!(!regex.is_match("libfoo-dev"));
+
+        assert!(NameMatcher::new("[", false).is_err());
+        assert!(NameMatcher::new("(", true).is_err());
+    }
 
     #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
     async fn abort_on_concurrent_index_change(pool: sqlx::PgPool) {
@@ -266,8 +582,11 @@ mod tests {
                 name: None,
                 version: None,
                 architecture: None,
+                limit: None,
+                cursor: None,
+                sort: None,
             })
-            .send()
+            .send_retrying(&ctx)
             .await
             .expect("failed to list packages");
 
@@ -0,0 +1,139 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::{Args, ValueEnum};
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::ColumnArgs,
+};
+use attune::{
+    api::ErrorResponse,
+    server::pkg::{
+        list::MAX_PAGE_SIZE,
+        search::{PackageSearchField, PackageSearchParams, PackageSearchResponse},
+    },
+};
+
+#[derive(Args, Debug)]
+pub struct PkgSearchCommand {
+    /// Text to search for in package names, descriptions, and maintainers,
+    /// ignoring case.
+    query: String,
+
+    /// Only search this field.
+    #[arg(long, value_enum)]
+    field: Option<SearchField>,
+
+    #[arg(short, long)]
+    repository: Option<String>,
+    #[arg(short, long)]
+    distribution: Option<String>,
+    #[arg(short, long)]
+    component: Option<String>,
+    #[arg(short, long, visible_alias = "arch")]
+    architecture: Option<String>,
+
+    /// Maximum number of packages to show.
+    #[arg(long, value_parser = clap::value_parser!(i64).range(1..=MAX_PAGE_SIZE))]
+    limit: Option<i64>,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+enum SearchField {
+    Name,
+    Description,
+    Maintainer,
+}
+
+impl From<SearchField> for PackageSearchField {
+    fn from(field: SearchField) -> Self {
+        match field {
+            SearchField::Name => PackageSearchField::Name,
+            SearchField::Description => PackageSearchField::Description,
+            SearchField::Maintainer => PackageSearchField::Maintainer,
+        }
+    }
+}
+
+pub async fn run(ctx: Config, command: PkgSearchCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/packages/search").unwrap())
+        .query(&PackageSearchParams {
+            query: command.query.clone(),
+            field: command.field.map(PackageSearchField::from),
+            repository: command.repository.clone(),
+            distribution: command.distribution.clone(),
+            component: command.component.clone(),
+            architecture: command.architecture.clone(),
+            limit: command.limit,
+        })
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    let results = match res.status() {
+        StatusCode::OK => res
+            .json::<PackageSearchResponse>()
+            .await
+            .expect("Could not parse response"),
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            return ctx.api_error("searching packages", error);
+        }
+    };
+    if results.truncated {
+        ctx.status(format!(
+            "Showing the first {} matching packages; narrow the search or raise --limit",
+            results.packages.len()
+        ));
+    }
+    if let Some(output) = ctx.output.render(&results) {
+        println!("{output}");
+        return ExitCode::SUCCESS;
+    }
+    let mut rows = vec![
+        [
+            "Package",
+            "Version",
+            "Architecture",
+            "Repository",
+            "Distribution",
+            "Component",
+            "Description",
+            "Maintainer",
+            "SHA256",
+        ]
+        .map(String::from)
+        .to_vec(),
+    ];
+    for package in results.packages {
+        rows.push(vec![
+            package.name,
+            package.version,
+            package.architecture,
+            package.repository,
+            package.distribution,
+            package.component,
+            package.synopsis,
+            package.maintainer,
+            package.sha256sum,
+        ]);
+    }
+    let rows = match command.columns.select(rows, &["maintainer", "sha256"]) {
+        Ok(rows) => rows,
+        Err(error) => return ctx.error(Failure::Usage, error),
+    };
+    println!("{}", ctx.output.table(rows));
+    ExitCode::SUCCESS
+}
@@ -0,0 +1,119 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+use percent_encoding::percent_encode;
+use time::format_description::well_known::Rfc3339;
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::{ColumnArgs, OutputFormat},
+};
+use attune::{
+    api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    server::pkg::info::PackageInfoResponse,
+};
+
+#[derive(Args, Debug)]
+pub struct PkgShowCommand {
+    /// The SHA256 sum of the package (see `attune apt pkg list`).
+    sha256sum: String,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+pub async fn run(ctx: Config, command: PkgShowCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .get(
+            ctx.endpoint
+                .join(&format!(
+                    "/api/v0/packages/{}",
+                    percent_encode(command.sha256sum.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                ))
+                .unwrap(),
+        )
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let pkg = res
+                .json::<PackageInfoResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&pkg) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+
+            // Delimited output is only the table of publications, so that it
+            // can be parsed.
+            if ctx.output == OutputFormat::Text {
+                println!("Package:      {}", pkg.package);
+                println!("Version:      {}", pkg.version);
+                println!("Architecture: {}", pkg.architecture);
+                println!("Size:         {} bytes", pkg.size);
+                println!("MD5sum:       {}", pkg.md5sum);
+                println!("SHA1:         {}", pkg.sha1sum);
+                println!("SHA256:       {}", pkg.sha256sum);
+                println!("Uploaded:     {}", pkg.uploaded_at.format(&Rfc3339).unwrap());
+                println!(
+                    "Uploaded by:  {}",
+                    pkg.uploaded_by.as_deref().unwrap_or("(not recorded)")
+                );
+                match &pkg.upstream_signature {
+                    Some(signature) => println!(
+                        "Upstream sig: {} signature by {}",
+                        signature.kind, signature.key_fingerprint
+                    ),
+                    None => println!("Upstream sig: (not verified)"),
+                }
+                println!("Control fields:");
+                for (key, value) in &pkg.fields {
+                    // Continuation lines of multi-line fields, like
+                    // `Description`, stay indented under their field.
+                    println!("  {key}: {}", value.replace('\n', "\n  "));
+                }
+                if pkg.publications.is_empty() {
+                    println!("\nNot published in any repository");
+                    return ExitCode::SUCCESS;
+                }
+                println!();
+            }
+
+            let mut rows = vec![vec![
+                String::from("Repository"),
+                String::from("Distribution"),
+                String::from("Component"),
+                String::from("Filename"),
+            ]];
+            for publication in pkg.publications {
+                rows.push(vec![
+                    publication.repository,
+                    publication.distribution,
+                    publication.component,
+                    publication.filename,
+                ]);
+            }
+            let rows = match command.columns.select(rows, &[]) {
+                Ok(rows) => rows,
+                Err(error) => return ctx.error(Failure::Usage, error),
+            };
+            println!("{}", ctx.output.table(rows));
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("showing package", error)
+        }
+    }
+}
@@ -0,0 +1,97 @@
+use std::{path::PathBuf, process::ExitCode};
+
+use axum::http::StatusCode;
+use clap::Args;
+use percent_encoding::percent_encode;
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+};
+use attune::{
+    api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    server::pkg::translation::{PackageTranslationResponse, set::SetPackageTranslationRequest},
+};
+
+#[derive(Args, Debug)]
+pub struct PkgTranslateCommand {
+    /// The SHA256 sum of the package (see `attune apt pkg list`).
+    sha256sum: String,
+
+    /// The translation's language code, like `de` or `pt_BR`.
+    #[arg(long, short)]
+    language: String,
+
+    /// A plain-text file with the translated description. The first line is
+    /// the synopsis, and the rest is the long description.
+    #[arg(long, short, required_unless_present = "delete")]
+    file: Option<PathBuf>,
+
+    /// Delete the translation instead of setting it.
+    #[arg(long, conflicts_with = "file")]
+    delete: bool,
+}
+
+pub async fn run(ctx: Config, command: PkgTranslateCommand) -> ExitCode {
+    let url = ctx
+        .endpoint
+        .join(&format!(
+            "/api/v0/packages/{}/translations/{}",
+            percent_encode(command.sha256sum.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET),
+            percent_encode(command.language.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET),
+        ))
+        .unwrap();
+    let req = match &command.file {
+        Some(path) => {
+            let description = match std::fs::read_to_string(path) {
+                Ok(description) => description,
+                Err(error) => {
+                    return ctx.error(Failure::Usage, format!("could not read {path:?}: {error}"));
+                }
+            };
+            ctx.client
+                .put(url)
+                .json(&SetPackageTranslationRequest { description })
+        }
+        None => ctx.client.delete(url),
+    };
+    let res = match req.send_retrying(&ctx).await {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<PackageTranslationResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            let translation = res.result;
+            let action = match translation.description {
+                Some(_) => "Set",
+                None => "Deleted",
+            };
+            println!(
+                "{action} {:?} translation of {} {} ({})",
+                translation.language,
+                translation.package,
+                translation.version,
+                translation.architecture
+            );
+            println!(
+                "Note: Translation indexes are regenerated the next time a package is added to or removed from each component."
+            );
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("translating package", error)
+        }
+    }
+}
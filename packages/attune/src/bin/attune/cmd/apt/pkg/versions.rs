@@ -0,0 +1,120 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+use time::format_description::well_known::Rfc3339;
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::ColumnArgs,
+};
+use attune::{
+    api::ErrorResponse,
+    server::pkg::versions::{PackageVersionsParams, PackageVersionsResponse},
+};
+
+#[derive(Args, Debug)]
+pub struct PkgVersionsCommand {
+    /// The name of the package.
+    name: String,
+
+    #[arg(short, long, visible_alias = "arch")]
+    architecture: Option<String>,
+    /// Only show where versions are published in this repository. Versions
+    /// that aren't published in it are shown as staged.
+    #[arg(short, long)]
+    repository: Option<String>,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+/// List every stored version of a package, newest first for each
+/// architecture, and where each one is published. Versions that were uploaded
+/// but aren't published anywhere are staged.
+pub async fn run(ctx: Config, command: PkgVersionsCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/packages/versions").unwrap())
+        .query(&PackageVersionsParams {
+            name: command.name.clone(),
+            architecture: command.architecture.clone(),
+            repository: command.repository.clone(),
+        })
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    let versions = match res.status() {
+        StatusCode::OK => res
+            .json::<PackageVersionsResponse>()
+            .await
+            .expect("Could not parse response"),
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            return ctx.api_error("listing package versions", error);
+        }
+    };
+    if let Some(output) = ctx.output.render(&versions) {
+        println!("{output}");
+        return ExitCode::SUCCESS;
+    }
+    if versions.versions.is_empty() {
+        return ctx.error(
+            Failure::NotFound,
+            format!("no stored versions of {}", command.name),
+        );
+    }
+
+    let mut rows = vec![
+        [
+            "Version",
+            "Architecture",
+            "State",
+            "Published in",
+            "Uploaded",
+            "Uploaded by",
+            "SHA256",
+        ]
+        .map(String::from)
+        .to_vec(),
+    ];
+    for version in versions.versions {
+        let state = match version.publications.is_empty() {
+            true => "staged",
+            false => "published",
+        };
+        let published_in = version
+            .publications
+            .iter()
+            .map(|publication| {
+                format!(
+                    "{}/{}/{}",
+                    publication.repository, publication.distribution, publication.component
+                )
+            })
+            .collect::<Vec<_>>()
+            .join(", ");
+        rows.push(vec![
+            version.version,
+            version.architecture,
+            state.to_string(),
+            published_in,
+            version.uploaded_at.format(&Rfc3339).unwrap(),
+            version.uploaded_by.unwrap_or_default(),
+            version.sha256sum,
+        ]);
+    }
+    let rows = match command.columns.select(rows, &["uploaded_by", "sha256"]) {
+        Ok(rows) => rows,
+        Err(error) => return ctx.error(Failure::Usage, error),
+    };
+    println!("{}", ctx.output.table(rows));
+    ExitCode::SUCCESS
+}
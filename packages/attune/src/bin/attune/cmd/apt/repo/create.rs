@@ -3,7 +3,10 @@ use std::process::ExitCode;
 use axum::http::StatusCode;
 use clap::Args;
 
-use crate::config::Config;
+use crate::{
+    cmd::apt::repo::snapshot::{fetch_snapshot, publish_packages},
+    config::{Config, SendRetrying as _},
+};
 use attune::{
     api::ErrorResponse,
     server::repo::create::{CreateRepositoryRequest, CreateRepositoryResponse},
@@ -14,34 +17,101 @@ pub struct RepoCreateCommand {
     /// A name that uniquely identifies this repository.
     name: String,
 
-    /// Output in JSON format.
+    /// Publish a flat repository, with its Release file and Packages index at
+    /// its root instead of under `dists/`.
+    ///
+    /// Flat repositories are for clients that can't use suites, and are
+    /// configured with `deb <url> ./`. They have only one distribution, and
+    /// all of its components and architectures share one Packages index.
     #[arg(long)]
-    json: bool,
+    flat: bool,
+
+    /// Populate the new repository with the packages in this snapshot of
+    /// `--from-repo`.
+    ///
+    /// Each package is published into the same distribution and component as
+    /// in the snapshot, and the indexes are signed locally as when publishing.
+    /// Distribution metadata is not copied.
+    #[arg(long, requires = "from_repo")]
+    from_snapshot: Option<String>,
+    /// The repository that `--from-snapshot` was taken of.
+    #[arg(long, requires = "from_snapshot")]
+    from_repo: Option<String>,
+
+    /// GPG key ID to sign the indexes with when using `--from-snapshot` (see
+    /// `gpg --list-secret-keys`)
+    ///
+    /// If not set and there is only one signing key available, that key will be
+    /// used. Otherwise, the command will fail.
+    #[arg(long, short)]
+    key_id: Option<String>,
+    /// GPG home directory to use for signing.
+    ///
+    /// If not set, defaults to the standard GPG home directory
+    /// for the platform.
+    #[arg(long, short)]
+    gpg_home_dir: Option<String>,
 }
 
 pub async fn run(ctx: Config, command: RepoCreateCommand) -> ExitCode {
-    let res = ctx
+    // Fetch the snapshot first, so that a missing snapshot doesn't leave an
+    // empty repository behind.
+    let snapshot = match (&command.from_repo, &command.from_snapshot) {
+        (Some(repo), Some(snapshot)) => match fetch_snapshot(&ctx, repo, snapshot).await {
+            Ok(snapshot) => Some(snapshot),
+            Err(error) => return ctx.report_error("fetching snapshot", error),
+        },
+        _ => None,
+    };
+
+    let res = match ctx
         .client
         .post(ctx.endpoint.join("/api/v0/repositories").unwrap())
-        .json(&CreateRepositoryRequest { name: command.name })
-        .send()
+        .json(&CreateRepositoryRequest {
+            name: command.name.clone(),
+            flat: command.flat,
+        })
+        .send_retrying(&ctx)
         .await
-        .expect("Could not send API request");
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
     match res.status() {
         StatusCode::OK => {
             let res = res
                 .json::<CreateRepositoryResponse>()
                 .await
                 .expect("Could not parse response");
+            if let Some(snapshot) = snapshot {
+                if let Err(error) = publish_packages(
+                    &ctx,
+                    &command.name,
+                    &snapshot.packages,
+                    command.key_id.as_deref(),
+                    command.gpg_home_dir.as_deref(),
+                )
+                .await
+                {
+                    return ctx.report_error("publishing snapshot", error);
+                }
+            }
             // TODO: In the managed cloud version of this CLI, we should hide
             // the S3 bucket and prefix fields because they're irrelevant.
-            if command.json {
-                println!("{}", serde_json::to_string_pretty(&res).unwrap());
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
                 return ExitCode::SUCCESS;
             }
             println!(
-                "Repository {:?} created in bucket {:?} at prefix {:?}",
-                res.name, res.s3_bucket, res.s3_prefix
+                "{} {:?} created in bucket {:?} at prefix {:?}",
+                if res.flat {
+                    "Flat repository"
+                } else {
+                    "Repository"
+                },
+                res.name,
+                res.s3_bucket,
+                res.s3_prefix
             );
             ExitCode::SUCCESS
         }
@@ -50,8 +120,7 @@ pub async fn run(ctx: Config, command: RepoCreateCommand) -> ExitCode {
                 .json::<ErrorResponse>()
                 .await
                 .expect("Could not parse error response");
-            eprintln!("Error creating repository: {}", error.message);
-            ExitCode::FAILURE
+            ctx.api_error("creating repository", error)
         }
     }
 }
@@ -6,7 +6,7 @@ use colored::Colorize as _;
 use inquire::Confirm;
 use percent_encoding::percent_encode;
 
-use crate::config::Config;
+use crate::config::{Config, SendRetrying as _};
 use attune::{
     api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
     server::repo::delete::{DeleteRepositoryRequest, DeleteRepositoryResponse},
@@ -23,7 +23,7 @@ pub struct RepoDeleteCommand {
 }
 
 pub async fn run(ctx: Config, command: RepoDeleteCommand) -> ExitCode {
-    println!(
+    eprintln!(
         "{}",
         format!(
             "Warning: this will irreversibly delete repository {:?}",
@@ -46,7 +46,7 @@ pub async fn run(ctx: Config, command: RepoDeleteCommand) -> ExitCode {
         }
     }
 
-    let res = ctx
+    let res = match ctx
         .client
         .delete(
             ctx.endpoint
@@ -60,14 +60,22 @@ pub async fn run(ctx: Config, command: RepoDeleteCommand) -> ExitCode {
                 .unwrap(),
         )
         .json(&DeleteRepositoryRequest {})
-        .send()
+        .send_retrying(&ctx)
         .await
-        .expect("Could not send API request");
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
     match res.status() {
         StatusCode::OK => {
-            res.json::<DeleteRepositoryResponse>()
+            let res = res
+                .json::<DeleteRepositoryResponse>()
                 .await
                 .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
             println!("Repository deleted");
             ExitCode::SUCCESS
         }
@@ -76,8 +84,7 @@ pub async fn run(ctx: Config, command: RepoDeleteCommand) -> ExitCode {
                 .json::<ErrorResponse>()
                 .await
                 .expect("Could not parse error response");
-            eprintln!("Error deleting repository: {}", error.message);
-            ExitCode::FAILURE
+            ctx.api_error("deleting repository", error)
         }
     }
 }
@@ -4,7 +4,7 @@ use axum::http::StatusCode;
 use clap::Args;
 use percent_encoding::percent_encode;
 
-use crate::config::Config;
+use crate::config::{Config, SendRetrying as _};
 use attune::{
     api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
     server::repo::edit::{EditRepositoryRequest, EditRepositoryResponse},
@@ -19,10 +19,78 @@ pub struct RepoEditCommand {
     /// The new name for the repository.
     #[arg(long)]
     new_name: Option<String>,
+
+    /// Set a custom field in the Release file of every distribution, as
+    /// `Key=Value`. Can be repeated.
+    ///
+    /// Fields that Attune sets itself (like `Suite` or `Date`) can't be
+    /// customized, except for `Acquire-By-Hash`.
+    #[arg(long = "release-field", value_name = "KEY=VALUE", value_parser = parse_release_field)]
+    release_fields: Vec<(String, String)>,
+
+    /// Remove a custom Release field. Can be repeated.
+    #[arg(long = "unset-release-field", value_name = "KEY")]
+    unset_release_fields: Vec<String>,
+
+    /// Add a `Valid-Until` field to Release files, this many days after they
+    /// are generated. Set to 0 to stop Release files from expiring.
+    ///
+    /// apt refuses to use an expired Release file, so a distribution must be
+    /// republished (by adding or removing a package) before then.
+    #[arg(long, value_name = "DAYS")]
+    valid_until_days: Option<i32>,
+
+    /// Publish a `Packages.diff/` history of patches next to each Packages
+    /// index, so that apt clients can update their copy of a large index
+    /// without downloading all of it.
+    ///
+    /// Turning this off discards the existing history. Flat repositories
+    /// don't support this.
+    #[arg(long, value_name = "BOOL")]
+    pdiffs: Option<bool>,
+
+    /// Publish a `Contents-<arch>.gz` index in each component, which lists the
+    /// files installed by each package, so that `apt-file` can search them.
+    ///
+    /// Flat repositories don't support this.
+    #[arg(long, value_name = "BOOL")]
+    contents_indexes: Option<bool>,
+
+    /// Publish `i18n/Translation-<lang>` indexes in each component, which list
+    /// package descriptions in English and in any language that a package has
+    /// a translation for (see `attune apt pkg translate`).
+    ///
+    /// Flat repositories don't support this.
+    #[arg(long, value_name = "BOOL")]
+    translations: Option<bool>,
+
+    /// Refuse to add a package whose version is lower than the latest version
+    /// of the same package in its Packages index, so that stale build
+    /// artifacts can't roll a package back by accident.
+    ///
+    /// Pass `--allow-downgrade` to `attune apt pkg add` to add a lower version
+    /// anyway.
+    #[arg(long, value_name = "BOOL")]
+    prevent_downgrades: Option<bool>,
+
+    /// When pruning (see `attune apt repo prune`), keep this many of the
+    /// newest versions of each package per architecture. Set to 0 to keep
+    /// every version.
+    #[arg(long, value_name = "COUNT")]
+    retain_versions: Option<i32>,
+
+    /// When pruning (see `attune apt repo prune`), keep older versions of
+    /// packages for this many days after they're added. Set to 0 to prune
+    /// them regardless of age.
+    ///
+    /// If both this and `--retain-versions` are set, a version is only pruned
+    /// if both rules allow it.
+    #[arg(long, value_name = "DAYS")]
+    retain_days: Option<i32>,
 }
 
 pub async fn run(ctx: Config, command: RepoEditCommand) -> ExitCode {
-    let res = ctx
+    let res = match ctx
         .client
         .put(
             ctx.endpoint
@@ -36,21 +104,105 @@ pub async fn run(ctx: Config, command: RepoEditCommand) -> ExitCode {
                 .unwrap(),
         )
         .json(&EditRepositoryRequest {
-            new_name: command.new_name,
+            new_name: command.new_name.clone(),
+            set_release_fields: command.release_fields.iter().cloned().collect(),
+            unset_release_fields: command.unset_release_fields.clone(),
+            valid_until_days: command.valid_until_days,
+            pdiffs: command.pdiffs,
+            contents_indexes: command.contents_indexes,
+            translations: command.translations,
+            prevent_downgrades: command.prevent_downgrades,
+            retain_versions: command.retain_versions,
+            retain_days: command.retain_days,
         })
-        .send()
+        .send_retrying(&ctx)
         .await
-        .expect("Could not send API request");
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
     match res.status() {
         StatusCode::OK => {
             let repo = res
                 .json::<EditRepositoryResponse>()
                 .await
                 .expect("Could not parse response");
-            println!(
-                "Repository name changed from {:?} to {:?}",
-                command.name, repo.result.name
-            );
+            if let Some(output) = ctx.output.render(&repo) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            if command.new_name.is_some() {
+                println!(
+                    "Repository name changed from {:?} to {:?}",
+                    command.name, repo.result.name
+                );
+            }
+            if command.valid_until_days.is_some() {
+                match repo.result.valid_until_days {
+                    Some(days) => println!(
+                        "Release files for {:?} are valid for {days} days after publishing",
+                        repo.result.name
+                    ),
+                    None => println!("Release files for {:?} don't expire", repo.result.name),
+                }
+            }
+            if command.pdiffs.is_some() {
+                if repo.result.pdiffs {
+                    println!("Packages.diff histories enabled for {:?}", repo.result.name);
+                } else {
+                    println!("Packages.diff histories disabled for {:?}", repo.result.name);
+                }
+            }
+            if command.contents_indexes.is_some() {
+                if repo.result.contents_indexes {
+                    println!("Contents indexes enabled for {:?}", repo.result.name);
+                } else {
+                    println!("Contents indexes disabled for {:?}", repo.result.name);
+                }
+            }
+            if command.translations.is_some() {
+                if repo.result.translations {
+                    println!("Translation indexes enabled for {:?}", repo.result.name);
+                } else {
+                    println!("Translation indexes disabled for {:?}", repo.result.name);
+                }
+            }
+            if command.prevent_downgrades.is_some() {
+                if repo.result.prevent_downgrades {
+                    println!("Downgrades prevented for {:?}", repo.result.name);
+                } else {
+                    println!("Downgrades allowed for {:?}", repo.result.name);
+                }
+            }
+            if command.retain_versions.is_some() || command.retain_days.is_some() {
+                println!(
+                    "Retention policy for {:?}: {}",
+                    repo.result.name,
+                    describe_retention(repo.result.retain_versions, repo.result.retain_days)
+                );
+            }
+            let fields_changed =
+                !command.release_fields.is_empty() || !command.unset_release_fields.is_empty();
+            if fields_changed {
+                if repo.result.release_fields.is_empty() {
+                    println!("Repository {:?} has no custom Release fields", repo.result.name);
+                } else {
+                    println!("Custom Release fields for {:?}:", repo.result.name);
+                    for (key, value) in &repo.result.release_fields {
+                        println!("  {key}: {value}");
+                    }
+                }
+            }
+            if fields_changed
+                || command.valid_until_days.is_some()
+                || command.pdiffs.is_some()
+                || command.contents_indexes.is_some()
+                || command.translations.is_some()
+            {
+                println!(
+                    "Note: Release files are regenerated the next time a package is added to or removed from each distribution."
+                );
+            }
             ExitCode::SUCCESS
         }
         _ => {
@@ -58,8 +210,45 @@ pub async fn run(ctx: Config, command: RepoEditCommand) -> ExitCode {
                 .json::<ErrorResponse>()
                 .await
                 .expect("Could not parse error response");
-            eprintln!("Error editing repository: {}", error.message);
-            ExitCode::FAILURE
+            ctx.api_error("editing repository", error)
         }
     }
 }
+
+/// Describe a repository's retention policy for `attune apt repo prune`.
+pub fn describe_retention(versions: Option<i32>, days: Option<i32>) -> String {
+    match (versions, days) {
+        (None, None) => String::from("keep everything"),
+        (Some(versions), None) => format!("keep the newest {versions} versions"),
+        (None, Some(days)) => format!("keep versions added in the last {days} days"),
+        (Some(versions), Some(days)) => format!(
+            "keep the newest {versions} versions, and versions added in the last {days} days"
+        ),
+    }
+}
+
+/// Parse a `Key=Value` Release field.
+fn parse_release_field(field: &str) -> Result<(String, String), String> {
+    let (key, value) = field
+        .split_once('=')
+        .ok_or_else(|| format!("invalid Release field {field:?}: expected KEY=VALUE"))?;
+    Ok((key.trim().to_string(), value.trim().to_string()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parses_release_fields() {
+        assert_eq!(
+            parse_release_field("Butautomaticupgrades=yes"),
+            Ok((String::from("Butautomaticupgrades"), String::from("yes")))
+        );
+        assert_eq!(
+            parse_release_field("X-Vendor=a=b"),
+            Ok((String::from("X-Vendor"), String::from("a=b")))
+        );
+        assert!(parse_release_field("Acquire-By-Hash").is_err());
+    }
+}
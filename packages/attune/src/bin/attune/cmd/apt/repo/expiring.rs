@@ -0,0 +1,138 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+use time::{Duration, OffsetDateTime, format_description::well_known::Rfc3339};
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::{ColumnArgs, OutputFormat},
+};
+use attune::{
+    api::ErrorResponse,
+    server::repo::expiring::{ExpiringReleasesParams, ExpiringReleasesResponse},
+};
+
+/// Commands that show Release files warn when they expire within this many
+/// days.
+pub const WARNING_DAYS: i64 = 7;
+
+#[derive(Args, Debug)]
+pub struct RepoExpiringCommand {
+    /// Show Release files that expire within this many days
+    ///
+    /// Release files that have already expired are always shown.
+    #[arg(long, default_value_t = WARNING_DAYS)]
+    within_days: i64,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+pub async fn run(ctx: Config, command: RepoExpiringCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/releases/expiring").unwrap())
+        .query(&ExpiringReleasesParams {
+            within_days: command.within_days,
+        })
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<ExpiringReleasesResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            if res.releases.is_empty() && ctx.output == OutputFormat::Text {
+                println!(
+                    "No Release files expire within {} days",
+                    command.within_days
+                );
+                return ExitCode::SUCCESS;
+            }
+
+            let now = OffsetDateTime::now_utc();
+            let mut rows = vec![vec![
+                String::from("Repository"),
+                String::from("Distribution"),
+                String::from("Valid until"),
+                String::from("Status"),
+            ]];
+            for release in res.releases {
+                rows.push(vec![
+                    release.repository,
+                    release.distribution,
+                    release.valid_until.format(&Rfc3339).unwrap(),
+                    String::from(if release.valid_until <= now {
+                        "expired"
+                    } else {
+                        "expiring"
+                    }),
+                ]);
+            }
+            let rows = match command.columns.select(rows, &[]) {
+                Ok(rows) => rows,
+                Err(error) => return ctx.error(Failure::Usage, error),
+            };
+            println!("{}", ctx.output.table(rows));
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("listing expiring Release files", error)
+        }
+    }
+}
+
+/// A warning for a distribution whose Release file has expired or expires
+/// within [`WARNING_DAYS`], or `None` if it doesn't expire soon.
+pub fn expiry_warning(
+    distribution: &str,
+    valid_until: OffsetDateTime,
+    now: OffsetDateTime,
+) -> Option<String> {
+    let when = valid_until.format(&Rfc3339).unwrap();
+    if valid_until <= now {
+        Some(format!(
+            "the Release file for distribution {distribution:?} expired at {when}, so apt will refuse to use it; publish or remove a package in the distribution to regenerate it"
+        ))
+    } else if valid_until - now <= Duration::days(WARNING_DAYS) {
+        Some(format!(
+            "the Release file for distribution {distribution:?} expires at {when}; publish or remove a package in the distribution to regenerate it"
+        ))
+    } else {
+        None
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn warns_about_expiring_releases() {
+        let now = OffsetDateTime::UNIX_EPOCH;
+        assert!(expiry_warning("stable", now + Duration::days(30), now).is_none());
+        assert!(
+            expiry_warning("stable", now + Duration::days(2), now)
+                .is_some_and(|warning| warning.contains("expires at"))
+        );
+        assert!(
+            expiry_warning("stable", now - Duration::hours(1), now)
+                .is_some_and(|warning| warning.contains("expired at"))
+        );
+    }
+}
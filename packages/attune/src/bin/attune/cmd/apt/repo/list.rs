@@ -2,9 +2,12 @@ use std::process::ExitCode;
 
 use axum::http::StatusCode;
 use clap::Args;
-use tabled::settings::Style;
 
-use crate::config::Config;
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::ColumnArgs,
+};
 use attune::{
     api::ErrorResponse,
     server::repo::list::{ListRepositoryRequest, ListRepositoryResponse},
@@ -12,23 +15,25 @@ use attune::{
 
 #[derive(Args, Debug)]
 pub struct RepoListCommand {
-    /// Output in JSON format.
-    #[arg(long)]
-    json: bool,
-
     /// Filter repositories by name (substring match).
     #[arg(long)]
     name: Option<String>,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
 }
 
 pub async fn run(ctx: Config, cmd: RepoListCommand) -> ExitCode {
-    let res = ctx
+    let res = match ctx
         .client
         .get(ctx.endpoint.join("/api/v0/repositories").unwrap())
         .json(&ListRepositoryRequest { name: cmd.name })
-        .send()
+        .send_retrying(&ctx)
         .await
-        .expect("Could not send API request");
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
     match res.status() {
         StatusCode::OK => {
             let res = res
@@ -37,22 +42,23 @@ pub async fn run(ctx: Config, cmd: RepoListCommand) -> ExitCode {
                 .expect("Could not parse response");
             // TODO: In the managed cloud version of this CLI, we should hide
             // the S3 bucket and prefix fields because they're irrelevant.
-            if cmd.json {
-                println!("{}", serde_json::to_string_pretty(&res).unwrap());
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
                 return ExitCode::SUCCESS;
             }
-            let mut builder = tabled::builder::Builder::new();
-            builder.push_record([
+            let mut rows = vec![vec![
                 String::from("Name"),
                 String::from("S3 bucket"),
                 String::from("S3 prefix"),
-            ]);
+            ]];
             for repo in res.repositories {
-                builder.push_record([&repo.name, &repo.s3_bucket, &repo.s3_prefix]);
+                rows.push(vec![repo.name, repo.s3_bucket, repo.s3_prefix]);
             }
-            let mut table = builder.build();
-            table.with(Style::modern());
-            println!("{table}");
+            let rows = match cmd.columns.select(rows, &[]) {
+                Ok(rows) => rows,
+                Err(error) => return ctx.error(Failure::Usage, error),
+            };
+            println!("{}", ctx.output.table(rows));
             ExitCode::SUCCESS
         }
         _ => {
@@ -60,8 +66,7 @@ pub async fn run(ctx: Config, cmd: RepoListCommand) -> ExitCode {
                 .json::<ErrorResponse>()
                 .await
                 .expect("Could not parse error response");
-            eprintln!("Error listing repositories: {}", error.message);
-            ExitCode::FAILURE
+            ctx.api_error("listing repositories", error)
         }
     }
 }
@@ -0,0 +1,115 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+use colored::Colorize as _;
+use percent_encoding::percent_encode;
+
+use crate::config::{Config, SendRetrying as _};
+use attune::{
+    api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    server::repo::lock::{
+        create::{LockRepositoryRequest, LockRepositoryResponse},
+        delete::UnlockRepositoryResponse,
+    },
+};
+
+#[derive(Args, Debug)]
+pub struct RepoLockCommand {
+    /// The name of the repository to lock.
+    name: String,
+
+    /// Why the repository is locked, which is shown to anyone whose changes
+    /// are rejected.
+    #[arg(long, short)]
+    reason: Option<String>,
+}
+
+#[derive(Args, Debug)]
+pub struct RepoUnlockCommand {
+    /// The name of the repository to unlock.
+    name: String,
+}
+
+fn lock_path(repo: &str) -> String {
+    format!(
+        "/api/v0/repositories/{}/lock",
+        percent_encode(repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+    )
+}
+
+pub async fn lock(ctx: Config, command: RepoLockCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .put(ctx.endpoint.join(&lock_path(&command.name)).unwrap())
+        .json(&LockRepositoryRequest {
+            reason: command.reason,
+        })
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<LockRepositoryResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            println!(
+                "{} repository {:?}; packages can't be added or removed until it is unlocked",
+                "Locked".yellow(),
+                command.name
+            );
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("locking repository", error)
+        }
+    }
+}
+
+pub async fn unlock(ctx: Config, command: RepoUnlockCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .delete(ctx.endpoint.join(&lock_path(&command.name)).unwrap())
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<UnlockRepositoryResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            match res.was_locked {
+                true => println!("{} repository {:?}", "Unlocked".green(), command.name),
+                false => println!("Repository {:?} was not locked", command.name),
+            }
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("unlocking repository", error)
+        }
+    }
+}
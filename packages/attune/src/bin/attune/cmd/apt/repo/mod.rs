@@ -7,7 +7,14 @@ use crate::config::Config;
 mod create;
 mod delete;
 mod edit;
+mod expiring;
 mod list;
+mod lock;
+mod prune;
+mod restore;
+mod show;
+mod snapshot;
+mod stats;
 
 #[derive(Args, Debug)]
 pub struct RepoCommand {
@@ -23,19 +30,44 @@ pub enum RepoSubCommand {
     /// Show information about repositories
     #[command(visible_alias = "ls")]
     List(list::RepoListCommand),
+    /// Show a repository's details and distributions
+    #[command(visible_alias = "info")]
+    Show(show::RepoShowCommand),
+    /// Show package counts and storage used by a repository
+    Stats(stats::RepoStatsCommand),
+    /// List distributions whose Release files expire soon
+    Expiring(expiring::RepoExpiringCommand),
     /// Edit repository metadata
     #[command(visible_alias = "set")]
     Edit(edit::RepoEditCommand),
     /// Delete a repository
     #[command(visible_alias = "rm")]
     Delete(delete::RepoDeleteCommand),
+    /// Freeze a repository's packages, e.g. during an incident
+    Lock(lock::RepoLockCommand),
+    /// Unfreeze a locked repository
+    Unlock(lock::RepoUnlockCommand),
+    /// Remove packages that the repository's retention policy no longer keeps
+    Prune(prune::RepoPruneCommand),
+    /// Reset a repository's packages to those in a snapshot
+    Restore(restore::RepoRestoreCommand),
+    /// Capture and list point-in-time snapshots of a repository
+    Snapshot(snapshot::SnapshotCommand),
 }
 
 pub async fn handle_repo(ctx: Config, command: RepoCommand) -> ExitCode {
     match command.subcommand {
         RepoSubCommand::Create(create) => create::run(ctx, create).await,
         RepoSubCommand::List(list) => list::run(ctx, list).await,
+        RepoSubCommand::Show(show) => show::run(ctx, show).await,
+        RepoSubCommand::Stats(stats) => stats::run(ctx, stats).await,
+        RepoSubCommand::Expiring(expiring) => expiring::run(ctx, expiring).await,
         RepoSubCommand::Edit(edit) => edit::run(ctx, edit).await,
         RepoSubCommand::Delete(delete) => delete::run(ctx, delete).await,
+        RepoSubCommand::Lock(command) => lock::lock(ctx, command).await,
+        RepoSubCommand::Unlock(command) => lock::unlock(ctx, command).await,
+        RepoSubCommand::Prune(prune) => prune::run(ctx, prune).await,
+        RepoSubCommand::Restore(restore) => restore::run(ctx, restore).await,
+        RepoSubCommand::Snapshot(snapshot) => snapshot::handle_snapshot(ctx, snapshot).await,
     }
 }
@@ -0,0 +1,319 @@
+use std::{collections::BTreeMap, process::ExitCode};
+
+use axum::http::StatusCode;
+use clap::Args;
+use color_eyre::eyre::{Context as _, Result, bail};
+use colored::Colorize as _;
+use debian_packaging::package_version::PackageVersion;
+use inquire::Confirm;
+use percent_encoding::percent_encode;
+use time::{Duration, OffsetDateTime, format_description::well_known::Rfc3339};
+
+use crate::{
+    cmd::apt::{
+        pkg::{
+            list::list_published,
+            remove::{PkgRemoveCommand, package_change, remove_package_retrying},
+        },
+        repo::edit::describe_retention,
+    },
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+};
+use attune::{
+    api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    server::{
+        pkg::list::{Package, PackageListParams},
+        repo::info::RepositoryInfoResponse,
+    },
+};
+
+#[derive(Args, Debug)]
+pub struct RepoPruneCommand {
+    /// Name of the repository to prune
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, short, env = "ATTUNE_REPO")]
+    repo: Option<String>,
+    /// Only prune this distribution
+    #[arg(long, short)]
+    distribution: Option<String>,
+
+    /// GPG key ID to sign the indexes with (see `gpg --list-secret-keys`)
+    ///
+    /// If not set and there is only one signing key available, that key will be
+    /// used. Otherwise, the command will fail.
+    #[arg(long, short)]
+    key_id: Option<String>,
+    /// GPG home directory to use for signing.
+    ///
+    /// If not set, defaults to the standard GPG home directory
+    /// for the platform.
+    #[arg(long, short)]
+    gpg_home_dir: Option<String>,
+
+    /// Show the packages that would be removed, without removing them
+    #[arg(long)]
+    dry_run: bool,
+    /// Skip confirmation prompt and proceed with the removal
+    #[arg(short, long)]
+    yes: bool,
+}
+
+/// Remove the packages that a repository's retention policy no longer keeps
+/// (see `attune apt repo edit --retain-versions` and `--retain-days`).
+///
+/// Each removal is signed and published as it is made, so an interrupted prune
+/// can be finished by running it again.
+pub async fn run(ctx: Config, command: RepoPruneCommand) -> ExitCode {
+    let repo = match ctx.repo(command.repo.clone()) {
+        Ok(repo) => repo,
+        Err(error) => return ctx.fail(error),
+    };
+    let info = match fetch_repo(&ctx, &repo).await {
+        Ok(info) => info,
+        Err(error) => return ctx.report_error("showing repository", error),
+    };
+    if info.retain_versions.is_none() && info.retain_days.is_none() {
+        return ctx.error(
+            Failure::Usage,
+            format!(
+                "repository {repo:?} has no retention policy; set one with `attune apt repo edit \
+                 --retain-versions` or `--retain-days`"
+            ),
+        );
+    }
+    let filter = PackageListParams {
+        repository: Some(repo.clone()),
+        distribution: command.distribution.clone(),
+        ..Default::default()
+    };
+    let packages = match list_published(&ctx, filter).await {
+        Ok(packages) => packages,
+        Err(error) => return ctx.report_error("listing packages", error),
+    };
+    let pruned = prunable(
+        packages,
+        info.retain_versions,
+        info.retain_days,
+        OffsetDateTime::now_utc(),
+    );
+    let policy = describe_retention(info.retain_versions, info.retain_days);
+
+    if command.dry_run {
+        match ctx.output.render(&pruned) {
+            Some(output) => println!("{output}"),
+            None => println!("{}", pruned_table(&ctx, &pruned)),
+        }
+        ctx.status(format!("Would remove {} packages ({policy})", pruned.len()));
+        return ExitCode::SUCCESS;
+    }
+    if pruned.is_empty() {
+        ctx.status(format!("Nothing to prune in {repo:?} ({policy})"));
+        return ExitCode::SUCCESS;
+    }
+    if !command.yes {
+        eprintln!("{}", pruned_table(&ctx, &pruned));
+        let confirm = Confirm::new(&format!(
+            "Remove these {} packages from {repo} ({policy})?",
+            pruned.len()
+        ))
+        .with_default(false)
+        .prompt();
+        match confirm {
+            Ok(true) => {}
+            Ok(false) => return ExitCode::SUCCESS,
+            Err(e) => {
+                eprintln!("Aborting: {e}");
+                return ExitCode::FAILURE;
+            }
+        }
+    }
+
+    let mut changes = Vec::with_capacity(pruned.len());
+    for (removed_count, package) in pruned.iter().enumerate() {
+        let remove = PkgRemoveCommand::builder()
+            .repo(repo.as_str())
+            .distribution(&package.distribution)
+            .component(&package.component)
+            .maybe_key_id(command.key_id.as_deref())
+            .maybe_gpg_home_dir(command.gpg_home_dir.as_deref())
+            .package(&package.name)
+            .version(&package.version)
+            .architecture(&package.architecture)
+            .yes(true)
+            .build();
+        if let Err(error) = remove_package_retrying(&ctx, &remove).await {
+            let doing = format!(
+                "removing package ({removed_count} of {} removed)",
+                pruned.len()
+            );
+            return ctx.report_error(&doing, error);
+        }
+        if !ctx.output.is_structured() {
+            ctx.status(format!(
+                "{} {} {} ({}) from {repo}/{}/{}",
+                "Removed".red(),
+                package.name,
+                package.version,
+                package.architecture,
+                package.distribution,
+                package.component
+            ));
+        }
+        changes.push(package_change(&remove));
+    }
+    if let Some(output) = ctx.output.render(&changes) {
+        println!("{output}");
+    }
+    ExitCode::SUCCESS
+}
+
+/// Select the published packages that a retention policy doesn't keep.
+///
+/// Versions are compared within each package, architecture, distribution, and
+/// component. A version is pruned only if every rule in the policy allows it,
+/// and the newest version is always kept.
+fn prunable(
+    packages: Vec<Package>,
+    retain_versions: Option<i32>,
+    retain_days: Option<i32>,
+    now: OffsetDateTime,
+) -> Vec<Package> {
+    let mut groups = BTreeMap::<_, Vec<Package>>::new();
+    for package in packages {
+        let key = (
+            package.distribution.clone(),
+            package.component.clone(),
+            package.name.clone(),
+            package.architecture.clone(),
+        );
+        groups.entry(key).or_default().push(package);
+    }
+
+    let keep_versions = retain_versions.map_or(1, |versions| versions.max(1) as usize);
+    let cutoff = retain_days.map(|days| now - Duration::days(days.into()));
+    let mut pruned = Vec::new();
+    for mut versions in groups.into_values() {
+        // Sort newest first. Versions that can't be parsed sort as oldest, by
+        // when they were added.
+        versions.sort_by(|a, b| {
+            let parsed = |package: &Package| PackageVersion::parse(&package.version).ok();
+            parsed(b)
+                .cmp(&parsed(a))
+                .then_with(|| b.added_at.cmp(&a.added_at))
+        });
+        pruned.extend(
+            versions
+                .into_iter()
+                .skip(keep_versions)
+                .filter(|package| cutoff.is_none_or(|cutoff| package.added_at < cutoff)),
+        );
+    }
+    pruned
+}
+
+/// The packages that would be pruned, as a table.
+fn pruned_table(ctx: &Config, pruned: &[Package]) -> String {
+    let mut rows = vec![
+        [
+            "Package",
+            "Version",
+            "Architecture",
+            "Distribution",
+            "Component",
+            "Added",
+        ]
+        .map(String::from)
+        .to_vec(),
+    ];
+    for package in pruned {
+        rows.push(vec![
+            package.name.clone(),
+            package.version.clone(),
+            package.architecture.clone(),
+            package.distribution.clone(),
+            package.component.clone(),
+            package.added_at.format(&Rfc3339).unwrap(),
+        ]);
+    }
+    ctx.output.table(rows)
+}
+
+/// Fetch a repository's details, including its retention policy.
+async fn fetch_repo(ctx: &Config, repo: &str) -> Result<RepositoryInfoResponse> {
+    let res = ctx
+        .client
+        .get(
+            ctx.endpoint
+                .join(&format!(
+                    "/api/v0/repositories/{}",
+                    percent_encode(repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                ))
+                .unwrap(),
+        )
+        .send_retrying(ctx)
+        .await
+        .context("send API request")?;
+    match res.status() {
+        StatusCode::OK => res
+            .json::<RepositoryInfoResponse>()
+            .await
+            .context("parse response"),
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .context("parse error response")?;
+            bail!(error);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn package(name: &str, version: &str, added_days_ago: i64, now: OffsetDateTime) -> Package {
+        Package {
+            repository: String::from("test"),
+            distribution: String::from("stable"),
+            component: String::from("main"),
+            name: String::from(name),
+            version: String::from(version),
+            architecture: String::from("amd64"),
+            sha256sum: format!("{name}-{version}"),
+            added_at: now - Duration::days(added_days_ago),
+        }
+    }
+
+    fn versions(pruned: &[Package]) -> Vec<&str> {
+        pruned.iter().map(|package| package.version.as_str()).collect()
+    }
+
+    #[test]
+    fn prunes_by_version_count_and_age() {
+        let now = OffsetDateTime::now_utc();
+        let packages = || {
+            vec![
+                package("foo", "1.10", 1, now),
+                package("foo", "1.9", 40, now),
+                package("foo", "1.2", 50, now),
+                package("foo", "1.1", 5, now),
+                package("bar", "2.0", 100, now),
+            ]
+        };
+
+        let pruned = prunable(packages(), Some(2), None, now);
+        assert_eq!(versions(&pruned), ["1.2", "1.1"]);
+
+        let pruned = prunable(packages(), None, Some(30), now);
+        assert_eq!(versions(&pruned), ["1.9", "1.2"]);
+
+        let pruned = prunable(packages(), Some(2), Some(30), now);
+        assert_eq!(versions(&pruned), ["1.2"]);
+
+        let pruned = prunable(packages(), Some(10), None, now);
+        assert!(pruned.is_empty());
+    }
+}
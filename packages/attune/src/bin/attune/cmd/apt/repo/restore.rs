@@ -0,0 +1,216 @@
+use std::{collections::BTreeSet, process::ExitCode};
+
+use clap::Args;
+use color_eyre::eyre::{Context as _, Result, bail};
+use colored::Colorize as _;
+use http::StatusCode;
+use inquire::Confirm;
+use serde::Serialize;
+
+use crate::{
+    cmd::apt::{
+        pkg::remove::{PkgRemoveCommand, remove_package_retrying},
+        repo::snapshot::{fetch_snapshot, publish_packages},
+    },
+    config::{Config, SendRetrying as _},
+};
+use attune::{
+    api::ErrorResponse,
+    server::{
+        pkg::list::{MAX_PAGE_SIZE, PackageListParams, PackageListResponse},
+        repo::snapshot::info::SnapshotPackage,
+    },
+};
+
+#[derive(Args, Debug)]
+pub struct RepoRestoreCommand {
+    /// Name of the repository to restore
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, short, env = "ATTUNE_REPO")]
+    repo: Option<String>,
+    /// Name of the snapshot to restore the repository to (see `attune apt repo
+    /// snapshot list`)
+    #[arg(long, short)]
+    snapshot: String,
+
+    /// GPG key ID to sign the indexes with (see `gpg --list-secret-keys`)
+    ///
+    /// If not set and there is only one signing key available, that key will be
+    /// used. Otherwise, the command will fail.
+    #[arg(long, short)]
+    key_id: Option<String>,
+    /// GPG home directory to use for signing.
+    ///
+    /// If not set, defaults to the standard GPG home directory
+    /// for the platform.
+    #[arg(long, short)]
+    gpg_home_dir: Option<String>,
+
+    /// Skip confirmation prompt and proceed with the restore
+    #[arg(short, long)]
+    yes: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct RestoreOutput {
+    repository: String,
+    snapshot: String,
+    /// Packages that were published because they were in the snapshot.
+    added: Vec<SnapshotPackage>,
+    /// Packages that were removed because they weren't in the snapshot.
+    removed: Vec<SnapshotPackage>,
+}
+
+/// Reset a repository to a snapshot by publishing and removing packages until
+/// the repository's packages match the snapshot's.
+///
+/// Each change is signed and published as it is made, so an interrupted
+/// restore can be finished by running it again.
+pub async fn run(ctx: Config, command: RepoRestoreCommand) -> ExitCode {
+    let repo = match ctx.repo(command.repo) {
+        Ok(repo) => repo,
+        Err(error) => return ctx.fail(error),
+    };
+    let snapshot = match fetch_snapshot(&ctx, &repo, &command.snapshot).await {
+        Ok(snapshot) => snapshot,
+        Err(error) => return ctx.report_error("fetching snapshot", error),
+    };
+    let current = match current_packages(&ctx, &repo).await {
+        Ok(current) => current,
+        Err(error) => return ctx.report_error("listing packages", error),
+    };
+    let wanted = snapshot.packages.into_iter().collect::<BTreeSet<_>>();
+    let added = wanted.difference(&current).cloned().collect::<Vec<_>>();
+    let removed = current.difference(&wanted).cloned().collect::<Vec<_>>();
+
+    if added.is_empty() && removed.is_empty() {
+        ctx.status(format!(
+            "Repository {repo:?} already matches snapshot {:?}",
+            command.snapshot
+        ));
+    } else if !command.yes {
+        let confirm = Confirm::new(&format!(
+            "Restore {repo} to snapshot {}? This will publish {} and remove {} packages.",
+            command.snapshot,
+            added.len(),
+            removed.len()
+        ))
+        .with_default(false)
+        .prompt();
+        match confirm {
+            Ok(true) => {}
+            Ok(false) => return ExitCode::SUCCESS,
+            Err(e) => {
+                eprintln!("Aborting: {e}");
+                return ExitCode::FAILURE;
+            }
+        }
+    }
+
+    // Remove packages first, so that a package that was replaced by one with
+    // the same name, version, and architecture is replaced back.
+    for package in &removed {
+        let remove = PkgRemoveCommand::builder()
+            .repo(repo.as_str())
+            .distribution(&package.distribution)
+            .component(&package.component)
+            .maybe_key_id(command.key_id.as_deref())
+            .maybe_gpg_home_dir(command.gpg_home_dir.as_deref())
+            .package(&package.name)
+            .version(&package.version)
+            .architecture(&package.architecture)
+            .yes(true)
+            .build();
+        if let Err(error) = remove_package_retrying(&ctx, &remove).await {
+            return ctx.report_error("removing package from index", error);
+        }
+        ctx.status(format!(
+            "{} {} {} ({}) from {repo}/{}/{}",
+            "Removed".red(),
+            package.name,
+            package.version,
+            package.architecture,
+            package.distribution,
+            package.component
+        ));
+    }
+    if let Err(error) = publish_packages(
+        &ctx,
+        &repo,
+        &added,
+        command.key_id.as_deref(),
+        command.gpg_home_dir.as_deref(),
+    )
+    .await
+    {
+        return ctx.report_error("adding package to index", error);
+    }
+
+    let output = RestoreOutput {
+        repository: repo,
+        snapshot: command.snapshot,
+        added,
+        removed,
+    };
+    match ctx.output.render(&output) {
+        Some(rendered) => println!("{rendered}"),
+        None => ctx.status(format!(
+            "{} repository {:?} to snapshot {:?}",
+            "Restored".green(),
+            output.repository,
+            output.snapshot
+        )),
+    }
+    ExitCode::SUCCESS
+}
+
+/// List every package currently published in the repository.
+async fn current_packages(ctx: &Config, repo: &str) -> Result<BTreeSet<SnapshotPackage>> {
+    let mut packages = BTreeSet::new();
+    let mut cursor = None;
+    loop {
+        let res = ctx
+            .client
+            .get(ctx.endpoint.join("/api/v0/packages").unwrap())
+            .query(&PackageListParams {
+                repository: Some(repo.to_string()),
+                distribution: None,
+                component: None,
+                name: None,
+                version: None,
+                architecture: None,
+                limit: Some(MAX_PAGE_SIZE),
+                cursor,
+                sort: None,
+            })
+            .send_retrying(ctx)
+            .await
+            .context("send API request")?;
+        let page = match res.status() {
+            StatusCode::OK => res
+                .json::<PackageListResponse>()
+                .await
+                .context("parse response")?,
+            _ => {
+                let error = res
+                    .json::<ErrorResponse>()
+                    .await
+                    .context("parse error response")?;
+                bail!(error);
+            }
+        };
+        packages.extend(page.packages.into_iter().map(|pkg| SnapshotPackage {
+            distribution: pkg.distribution,
+            component: pkg.component,
+            name: pkg.name,
+            version: pkg.version,
+            architecture: pkg.architecture,
+            sha256sum: pkg.sha256sum,
+        }));
+        cursor = page.next_cursor;
+        if cursor.is_none() {
+            return Ok(packages);
+        }
+    }
+}
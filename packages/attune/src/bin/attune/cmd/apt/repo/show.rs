@@ -0,0 +1,170 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+use colored::Colorize as _;
+use percent_encoding::percent_encode;
+use time::{OffsetDateTime, format_description::well_known::Rfc3339};
+
+use crate::{
+    cmd::apt::repo::{edit::describe_retention, expiring::expiry_warning},
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::{ColumnArgs, OutputFormat},
+};
+use attune::{
+    api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    server::repo::info::RepositoryInfoResponse,
+};
+
+#[derive(Args, Debug)]
+pub struct RepoShowCommand {
+    /// The name of the repository to show.
+    name: String,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+pub async fn run(ctx: Config, command: RepoShowCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .get(
+            ctx.endpoint
+                .join(
+                    format!(
+                        "/api/v0/repositories/{}",
+                        percent_encode(command.name.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                    )
+                    .as_str(),
+                )
+                .unwrap(),
+        )
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let repo = res
+                .json::<RepositoryInfoResponse>()
+                .await
+                .expect("Could not parse response");
+            let now = OffsetDateTime::now_utc();
+            for dist in &repo.distributions {
+                if let Some(warning) = dist
+                    .valid_until
+                    .and_then(|valid_until| expiry_warning(&dist.distribution, valid_until, now))
+                {
+                    eprintln!("{} {warning}", "Warning:".yellow());
+                }
+            }
+            if let Some(output) = ctx.output.render(&repo) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            let format = |ts: OffsetDateTime| ts.format(&Rfc3339).unwrap();
+
+            // Delimited output is only the table of distributions, so that it
+            // can be parsed.
+            if ctx.output == OutputFormat::Text {
+                println!("Name:       {}", repo.name);
+                println!(
+                    "Public URL: {}",
+                    repo.uri.as_deref().unwrap_or("(not configured)")
+                );
+                println!("S3 bucket:  {}", repo.s3_bucket);
+                println!("S3 prefix:  {}", repo.s3_prefix);
+                if repo.flat {
+                    println!("Layout:     flat");
+                }
+                println!("Created:    {}", format(repo.created_at));
+                if let Some(lock) = &repo.lock {
+                    println!(
+                        "Locked:     since {}{}",
+                        format(lock.locked_at),
+                        lock.reason
+                            .as_ref()
+                            .map(|reason| format!(" ({reason})"))
+                            .unwrap_or_default()
+                    );
+                }
+                if let Some(days) = repo.valid_until_days {
+                    println!("Valid for:  {days} days after publishing");
+                }
+                if repo.pdiffs {
+                    println!("Pdiffs:     enabled");
+                }
+                if repo.contents_indexes {
+                    println!("Contents:   enabled");
+                }
+                if repo.translations {
+                    println!("i18n:       enabled");
+                }
+                if repo.prevent_downgrades {
+                    println!("Downgrades: prevented");
+                }
+                if repo.retain_versions.is_some() || repo.retain_days.is_some() {
+                    println!(
+                        "Retention:  {}",
+                        describe_retention(repo.retain_versions, repo.retain_days)
+                    );
+                }
+                if !repo.release_fields.is_empty() {
+                    println!("Release fields:");
+                    for (key, value) in &repo.release_fields {
+                        println!("  {key}: {value}");
+                    }
+                }
+                if repo.distributions.is_empty() {
+                    println!("\nNo distributions");
+                    return ExitCode::SUCCESS;
+                }
+                println!();
+            }
+
+            let mut rows = vec![vec![
+                String::from("Distribution"),
+                String::from("Suite"),
+                String::from("Codename"),
+                String::from("Components"),
+                String::from("Architectures"),
+                String::from("Packages"),
+                String::from("Updated"),
+                String::from("Signing key"),
+                String::from("Valid until"),
+            ]];
+            for dist in repo.distributions {
+                rows.push(vec![
+                    dist.distribution,
+                    dist.suite,
+                    dist.codename,
+                    dist.components.join(", "),
+                    dist.architectures.join(", "),
+                    dist.package_count.to_string(),
+                    format(dist.updated_at),
+                    dist.signing_key_fingerprint
+                        .unwrap_or_else(|| String::from("none")),
+                    dist.valid_until
+                        .map(format)
+                        .unwrap_or_else(|| String::from("never")),
+                ]);
+            }
+            let rows = match command.columns.select(rows, &[]) {
+                Ok(rows) => rows,
+                Err(error) => return ctx.error(Failure::Usage, error),
+            };
+            println!("{}", ctx.output.table(rows));
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("showing repository", error)
+        }
+    }
+}
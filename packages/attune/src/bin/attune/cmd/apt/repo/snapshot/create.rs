@@ -0,0 +1,70 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+use colored::Colorize as _;
+
+use crate::{
+    cmd::apt::repo::snapshot::snapshots_path,
+    config::{Config, SendRetrying as _},
+};
+use attune::{
+    api::ErrorResponse,
+    server::repo::snapshot::create::{CreateSnapshotRequest, CreateSnapshotResponse},
+};
+
+#[derive(Args, Debug)]
+pub struct SnapshotCreateCommand {
+    /// Name of the repository to snapshot
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, short, env = "ATTUNE_REPO")]
+    repo: Option<String>,
+    /// A name that uniquely identifies the snapshot within the repository,
+    /// such as a date (e.g. `2024-06-01`).
+    #[arg(long, short)]
+    name: String,
+}
+
+pub async fn run(ctx: Config, command: SnapshotCreateCommand) -> ExitCode {
+    let repo = match ctx.repo(command.repo) {
+        Ok(repo) => repo,
+        Err(error) => return ctx.fail(error),
+    };
+    let res = match ctx
+        .client
+        .post(ctx.endpoint.join(&snapshots_path(&repo)).unwrap())
+        .json(&CreateSnapshotRequest { name: command.name })
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<CreateSnapshotResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            println!(
+                "{} snapshot {:?} of repository {repo:?} with {} packages",
+                "Created".green(),
+                res.snapshot.name,
+                res.snapshot.package_count
+            );
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("creating snapshot", error)
+        }
+    }
+}
@@ -0,0 +1,79 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+use time::{OffsetDateTime, format_description::well_known::Rfc3339};
+
+use crate::{
+    cmd::apt::repo::snapshot::snapshots_path,
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::ColumnArgs,
+};
+use attune::{api::ErrorResponse, server::repo::snapshot::list::ListSnapshotsResponse};
+
+#[derive(Args, Debug)]
+pub struct SnapshotListCommand {
+    /// Name of the repository whose snapshots to list
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, short, env = "ATTUNE_REPO")]
+    repo: Option<String>,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+pub async fn run(ctx: Config, command: SnapshotListCommand) -> ExitCode {
+    let repo = match ctx.repo(command.repo) {
+        Ok(repo) => repo,
+        Err(error) => return ctx.fail(error),
+    };
+    let res = match ctx
+        .client
+        .get(ctx.endpoint.join(&snapshots_path(&repo)).unwrap())
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<ListSnapshotsResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            let format = |ts: OffsetDateTime| ts.format(&Rfc3339).unwrap();
+            let mut rows = vec![vec![
+                String::from("Name"),
+                String::from("Created"),
+                String::from("Packages"),
+            ]];
+            for snapshot in res.snapshots {
+                rows.push(vec![
+                    snapshot.name,
+                    format(snapshot.created_at),
+                    snapshot.package_count.to_string(),
+                ]);
+            }
+            let rows = match command.columns.select(rows, &[]) {
+                Ok(rows) => rows,
+                Err(error) => return ctx.error(Failure::Usage, error),
+            };
+            println!("{}", ctx.output.table(rows));
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("listing snapshots", error)
+        }
+    }
+}
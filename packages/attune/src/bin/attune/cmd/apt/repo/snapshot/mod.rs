@@ -0,0 +1,129 @@
+use std::process::ExitCode;
+
+use clap::{Args, Subcommand};
+use color_eyre::eyre::{Context as _, Result, bail};
+use colored::Colorize as _;
+use http::StatusCode;
+use percent_encoding::percent_encode;
+use tracing::{debug, instrument};
+
+use crate::{
+    cmd::apt::pkg::add::{PkgAddCommand, add_package_retrying},
+    config::{Config, SendRetrying as _},
+};
+use attune::{
+    api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    server::repo::snapshot::info::{SnapshotInfoResponse, SnapshotPackage},
+};
+
+mod create;
+mod list;
+
+#[derive(Args, Debug)]
+pub struct SnapshotCommand {
+    #[command(subcommand)]
+    subcommand: SnapshotSubCommand,
+}
+
+#[derive(Subcommand, Debug)]
+pub enum SnapshotSubCommand {
+    /// Capture the packages currently published in a repository
+    #[command(visible_aliases = ["new", "add"])]
+    Create(create::SnapshotCreateCommand),
+    /// Show a repository's snapshots
+    #[command(visible_alias = "ls")]
+    List(list::SnapshotListCommand),
+}
+
+pub async fn handle_snapshot(ctx: Config, command: SnapshotCommand) -> ExitCode {
+    match command.subcommand {
+        SnapshotSubCommand::Create(create) => create::run(ctx, create).await,
+        SnapshotSubCommand::List(list) => list::run(ctx, list).await,
+    }
+}
+
+fn snapshots_path(repo: &str) -> String {
+    format!(
+        "/api/v0/repositories/{}/snapshots",
+        percent_encode(repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+    )
+}
+
+/// Fetch a snapshot and the packages it contains.
+#[instrument(skip(ctx))]
+pub async fn fetch_snapshot(ctx: &Config, repo: &str, name: &str) -> Result<SnapshotInfoResponse> {
+    let res = ctx
+        .client
+        .get(
+            ctx.endpoint
+                .join(
+                    format!(
+                        "{}/{}",
+                        snapshots_path(repo),
+                        percent_encode(name.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                    )
+                    .as_str(),
+                )
+                .unwrap(),
+        )
+        .send_retrying(&ctx)
+        .await
+        .context("send api request")?;
+    match res.status() {
+        StatusCode::OK => res
+            .json::<SnapshotInfoResponse>()
+            .await
+            .context("parse response"),
+        status => {
+            let body = res.text().await.context("read response")?;
+            debug!(?body, ?status, "error response");
+            let error =
+                serde_json::from_str::<ErrorResponse>(&body).context("parse error response")?;
+            bail!(error);
+        }
+    }
+}
+
+/// Publish snapshotted packages into a repository, signing each change to the
+/// index.
+///
+/// The packages are already stored by the API server, so nothing is uploaded.
+pub async fn publish_packages(
+    ctx: &Config,
+    repo: &str,
+    packages: &[SnapshotPackage],
+    key_id: Option<&str>,
+    gpg_home_dir: Option<&str>,
+) -> Result<()> {
+    for package in packages {
+        let command = PkgAddCommand::builder()
+            .repo(repo)
+            .distribution(&package.distribution)
+            .component(&package.component)
+            .maybe_key_id(key_id)
+            .maybe_gpg_home_dir(gpg_home_dir)
+            .package_file(describe(package))
+            // Publishing a snapshot deliberately restores the versions it has.
+            .allow_downgrade(true)
+            .build();
+        add_package_retrying(ctx, &command, &package.sha256sum)
+            .await
+            .with_context(|| format!("add {}", describe(package)))?;
+        ctx.status(format!(
+            "{} {} to {repo}/{}/{}",
+            "Added".green(),
+            describe(package),
+            package.distribution,
+            package.component
+        ));
+    }
+    Ok(())
+}
+
+/// A package's name as it appears in the pool, for messages.
+fn describe(package: &SnapshotPackage) -> String {
+    format!(
+        "{}_{}_{}.deb",
+        package.name, package.version, package.architecture
+    )
+}
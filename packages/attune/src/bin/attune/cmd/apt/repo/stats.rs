@@ -0,0 +1,142 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+use percent_encoding::percent_encode;
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::{ColumnArgs, OutputFormat},
+};
+use attune::{
+    api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    server::repo::stats::RepositoryStatsResponse,
+};
+
+#[derive(Args, Debug)]
+pub struct RepoStatsCommand {
+    /// Name of the repository to show statistics for
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long, short, env = "ATTUNE_REPO")]
+    repo: Option<String>,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+pub async fn run(ctx: Config, command: RepoStatsCommand) -> ExitCode {
+    let repo = match ctx.repo(command.repo) {
+        Ok(repo) => repo,
+        Err(error) => return ctx.fail(error),
+    };
+    let res = match ctx
+        .client
+        .get(
+            ctx.endpoint
+                .join(
+                    format!(
+                        "/api/v0/repositories/{}/stats",
+                        percent_encode(repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+                    )
+                    .as_str(),
+                )
+                .unwrap(),
+        )
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let stats = res
+                .json::<RepositoryStatsResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&stats) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+
+            // Delimited output is only the table of components, so that it can
+            // be parsed.
+            if ctx.output == OutputFormat::Text {
+                println!("Packages:  {}", stats.package_count);
+                println!("Pool size: {}", human_size(stats.pool_size));
+                println!(
+                    "Indexes:   {} ({})",
+                    stats.index_count,
+                    human_size(stats.index_size)
+                );
+                if stats.components.is_empty() {
+                    return ExitCode::SUCCESS;
+                }
+                println!();
+            }
+
+            let mut rows = vec![vec![
+                String::from("Distribution"),
+                String::from("Component"),
+                String::from("Architecture"),
+                String::from("Packages"),
+                String::from("Size"),
+            ]];
+            for component in stats.components {
+                rows.push(vec![
+                    component.distribution,
+                    component.component,
+                    component.architecture,
+                    component.package_count.to_string(),
+                    match ctx.output {
+                        OutputFormat::Text => human_size(component.size),
+                        _ => component.size.to_string(),
+                    },
+                ]);
+            }
+            let rows = match command.columns.select(rows, &[]) {
+                Ok(rows) => rows,
+                Err(error) => return ctx.error(Failure::Usage, error),
+            };
+            println!("{}", ctx.output.table(rows));
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("showing repository statistics", error)
+        }
+    }
+}
+
+/// Format a size in bytes using binary units, e.g. `1.5 MiB`.
+fn human_size(bytes: i64) -> String {
+    const UNITS: [&str; 5] = ["KiB", "MiB", "GiB", "TiB", "PiB"];
+    if bytes < 1024 {
+        return format!("{bytes} B");
+    }
+    let mut size = bytes as f64 / 1024.0;
+    let mut unit = 0;
+    while size >= 1024.0 && unit < UNITS.len() - 1 {
+        size /= 1024.0;
+        unit += 1;
+    }
+    format!("{size:.1} {}", UNITS[unit])
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn formats_sizes() {
+        assert_eq!(human_size(0), "0 B");
+        assert_eq!(human_size(1023), "1023 B");
+        assert_eq!(human_size(1536), "1.5 KiB");
+        assert_eq!(human_size(5 * 1024 * 1024 * 1024), "5.0 GiB");
+    }
+}
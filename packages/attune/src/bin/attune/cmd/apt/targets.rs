@@ -0,0 +1,131 @@
+//! Commands that can act on several repositories at once, such as publishing
+//! the same hotfix to a repository for each supported release.
+
+use std::collections::HashSet;
+
+use clap::Args;
+use serde::Serialize;
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::{CommandError, Failure},
+};
+use attune::{
+    api::ErrorResponse,
+    server::repo::list::{ListRepositoryRequest, ListRepositoryResponse},
+};
+
+#[derive(Args, Debug, Clone, Default)]
+pub struct RepoTargets {
+    /// Name of the repository; repeat to act on several repositories
+    ///
+    /// If not set, the `repo` from the configuration file is used.
+    #[arg(long = "repo", short = 'r', env = "ATTUNE_REPO", value_name = "REPO")]
+    repos: Vec<String>,
+    /// Act on every repository, instead of the ones set by `--repo`
+    #[arg(long)]
+    all_repos: bool,
+}
+
+impl RepoTargets {
+    /// The names of the repositories to act on, in the order given.
+    pub async fn resolve(self, ctx: &Config) -> Result<Vec<String>, CommandError> {
+        if self.all_repos {
+            let res = ctx
+                .client
+                .get(ctx.endpoint.join("/api/v0/repositories").unwrap())
+                .json(&ListRepositoryRequest { name: None })
+                .send_retrying(ctx)
+                .await
+                .map_err(|error| {
+                    CommandError::new(
+                        Failure::Network,
+                        format!("could not reach API server: {error}"),
+                    )
+                })?;
+            if !res.status().is_success() {
+                let error = res
+                    .json::<ErrorResponse>()
+                    .await
+                    .map_err(|err| format!("Failed to parse error response: {err}"))?;
+                let message = format!("error listing repositories: {}", error.message);
+                return Err(CommandError::from_response(error, message));
+            }
+            let repos = res
+                .json::<ListRepositoryResponse>()
+                .await
+                .map_err(|err| format!("Failed to parse API response: {err}"))?
+                .repositories
+                .into_iter()
+                .map(|repo| repo.name)
+                .collect::<Vec<_>>();
+            if repos.is_empty() {
+                return Err(CommandError::new(Failure::NotFound, "there are no repositories"));
+            }
+            return Ok(repos);
+        }
+
+        match self.repos.is_empty() {
+            true => Ok(vec![ctx.repo(None)?]),
+            false => {
+                let mut repos = self.repos;
+                let mut seen = HashSet::new();
+                repos.retain(|repo| seen.insert(repo.clone()));
+                Ok(repos)
+            }
+        }
+    }
+}
+
+/// The outcome of a command for one repository.
+#[derive(Serialize, Debug)]
+struct Outcome {
+    repository: String,
+    /// Why the command failed for this repository, if it did.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error: Option<String>,
+}
+
+/// Render a report of the outcome for each repository, along with an error
+/// to exit with if the command failed for any of them.
+pub fn report(
+    ctx: &Config,
+    doing: &str,
+    outcomes: Vec<(String, Result<(), CommandError>)>,
+) -> (String, Option<CommandError>) {
+    let total = outcomes.len();
+    let mut failure = None;
+    let mut failed = 0;
+    let outcomes = outcomes
+        .into_iter()
+        .map(|(repository, result)| Outcome {
+            repository,
+            error: result.err().map(|error| {
+                failed += 1;
+                failure.get_or_insert(error.failure);
+                error.message
+            }),
+        })
+        .collect::<Vec<_>>();
+
+    let rendered = ctx.output.render(&outcomes).unwrap_or_else(|| {
+        let mut rows = vec![vec![String::from("Repository"), String::from("Result")]];
+        for outcome in outcomes {
+            rows.push(vec![
+                outcome.repository,
+                match outcome.error {
+                    Some(error) => format!("failed: {error}"),
+                    None => String::from("ok"),
+                },
+            ]);
+        }
+        ctx.output.table(rows)
+    });
+    let error = failure.map(|failure| {
+        CommandError::new(
+            failure,
+            format!("{doing} failed for {failed} of {total} repositories"),
+        )
+    });
+    (rendered, error)
+}
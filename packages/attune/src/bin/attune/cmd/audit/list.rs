@@ -0,0 +1,138 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+use time::{Duration, OffsetDateTime, format_description::well_known::Rfc3339};
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::ColumnArgs,
+};
+use attune::{
+    api::ErrorResponse,
+    server::audit::list::{AuditListParams, AuditListResponse},
+};
+
+#[derive(Args, Debug)]
+pub struct AuditListCommand {
+    /// Only show events for this repository
+    #[arg(long, short)]
+    repo: Option<String>,
+    /// Only show events since this long ago (e.g. `90m`, `12h`, `7d`, `2w`),
+    /// or since an RFC 3339 timestamp
+    #[arg(long, value_parser = parse_since)]
+    since: Option<OffsetDateTime>,
+
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+pub async fn run(ctx: Config, command: AuditListCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/audit").unwrap())
+        .query(&AuditListParams {
+            repository: command.repo,
+            since: command.since.map(|since| since.format(&Rfc3339).unwrap()),
+        })
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<AuditListResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            let mut rows = vec![vec![
+                String::from("Time"),
+                String::from("Actor"),
+                String::from("Action"),
+                String::from("Repository"),
+                String::from("Distribution"),
+                String::from("Component"),
+                String::from("Package"),
+            ]];
+            for event in res.events {
+                let package = match (event.package, event.version, event.architecture) {
+                    (Some(package), Some(version), Some(architecture)) => {
+                        format!("{package} {version} ({architecture})")
+                    }
+                    _ => String::new(),
+                };
+                rows.push(vec![
+                    event.created_at.format(&Rfc3339).unwrap(),
+                    event.actor,
+                    event.action,
+                    event.repository,
+                    event.distribution.unwrap_or_default(),
+                    event.component.unwrap_or_default(),
+                    package,
+                ]);
+            }
+            let rows = match command.columns.select(rows, &[]) {
+                Ok(rows) => rows,
+                Err(error) => return ctx.error(Failure::Usage, error),
+            };
+            println!("{}", ctx.output.table(rows));
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("listing audit events", error)
+        }
+    }
+}
+
+/// Parse `--since` as either a duration before now or an RFC 3339 timestamp.
+///
+/// Other commands that take a point in time, like `pkg remove --older-than`,
+/// parse it the same way.
+pub fn parse_since(since: &str) -> Result<OffsetDateTime, String> {
+    if let Ok(timestamp) = OffsetDateTime::parse(since, &Rfc3339) {
+        return Ok(timestamp);
+    }
+    let invalid = || format!("{since:?} is not a duration (like `7d`) or an RFC 3339 timestamp");
+    let (split, _) = since.char_indices().last().ok_or_else(invalid)?;
+    let (amount, unit) = since.split_at(split);
+    let amount = amount.parse::<i64>().map_err(|_| invalid())?;
+    let ago = match unit {
+        "s" => Duration::seconds(amount),
+        "m" => Duration::minutes(amount),
+        "h" => Duration::hours(amount),
+        "d" => Duration::days(amount),
+        "w" => Duration::weeks(amount),
+        _ => return Err(invalid()),
+    };
+    Ok(OffsetDateTime::now_utc() - ago)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parses_since() {
+        let week = OffsetDateTime::now_utc() - parse_since("7d").unwrap();
+        assert!((week - Duration::days(7)).abs() < Duration::minutes(1));
+
+        let timestamp = parse_since("2024-06-01T00:00:00Z").unwrap();
+        assert_eq!(timestamp.unix_timestamp(), 1717200000);
+
+        assert!(parse_since("").is_err());
+        assert!(parse_since("7").is_err());
+        assert!(parse_since("7y").is_err());
+        assert!(parse_since("d").is_err());
+    }
+}
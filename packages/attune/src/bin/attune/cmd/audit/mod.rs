@@ -0,0 +1,26 @@
+use std::process::ExitCode;
+
+use clap::{Args, Subcommand};
+
+use crate::config::Config;
+
+pub mod list;
+
+#[derive(Args, Debug)]
+pub struct AuditCommand {
+    #[command(subcommand)]
+    subcommand: AuditSubCommand,
+}
+
+#[derive(Subcommand, Debug)]
+pub enum AuditSubCommand {
+    /// Show who published, removed, and resynced what, and when
+    #[command(visible_alias = "ls")]
+    List(list::AuditListCommand),
+}
+
+pub async fn handle_audit(ctx: Config, command: AuditCommand) -> ExitCode {
+    match command.subcommand {
+        AuditSubCommand::List(list) => list::run(ctx, list).await,
+    }
+}
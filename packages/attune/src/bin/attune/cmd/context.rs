@@ -0,0 +1,129 @@
+use std::{path::PathBuf, process::ExitCode};
+
+use clap::{Args, Subcommand};
+use colored::Colorize as _;
+
+use crate::config::{ConfigFile, DEFAULT_API_ENDPOINT};
+
+#[derive(Args, Debug)]
+pub struct ContextCommand {
+    #[command(subcommand)]
+    subcommand: ContextSubCommand,
+}
+
+#[derive(Subcommand, Debug)]
+pub enum ContextSubCommand {
+    /// List the contexts defined in the config file
+    #[command(visible_alias = "ls")]
+    List,
+    /// Set the context used by default
+    #[command(visible_alias = "switch")]
+    Use {
+        /// Name of the context to use.
+        name: String,
+    },
+    /// Show the settings of a context
+    ///
+    /// Shows the current context if no name is given. Tokens are redacted.
+    Show {
+        /// Name of the context to show.
+        name: Option<String>,
+    },
+}
+
+/// Run a context subcommand.
+///
+/// `path` is the config file's location, and `selected` is the context
+/// selected with `--context`, if any.
+pub fn run(
+    command: ContextCommand,
+    path: Option<PathBuf>,
+    mut config_file: ConfigFile,
+    selected: Option<String>,
+) -> ExitCode {
+    match command.subcommand {
+        ContextSubCommand::List => {
+            if config_file.contexts.is_empty() {
+                eprintln!("No contexts defined in the config file");
+                return ExitCode::SUCCESS;
+            }
+            let current = selected.or(config_file.current_context);
+            for (name, context) in config_file.contexts {
+                let marker = if current.as_deref() == Some(name.as_str()) {
+                    "*".green()
+                } else {
+                    " ".normal()
+                };
+                let endpoint = context
+                    .endpoint
+                    .or_else(|| config_file.endpoint.clone())
+                    .unwrap_or_else(|| String::from(DEFAULT_API_ENDPOINT));
+                println!("{marker} {name}\t{endpoint}");
+            }
+            ExitCode::SUCCESS
+        }
+        ContextSubCommand::Use { name } => {
+            let Some(path) = path else {
+                eprintln!(
+                    "{} could not determine config file location (set --config)",
+                    "Error:".red().bold()
+                );
+                return ExitCode::FAILURE;
+            };
+            if !config_file.contexts.contains_key(&name) {
+                eprintln!(
+                    "{} context {name:?} is not defined in {path:?} (see `attune context list`)",
+                    "Error:".red().bold()
+                );
+                return ExitCode::FAILURE;
+            }
+            config_file.current_context = Some(name.clone());
+            match config_file.save(&path) {
+                Ok(()) => {
+                    println!("Switched to context {name:?}");
+                    ExitCode::SUCCESS
+                }
+                Err(error) => {
+                    eprintln!("{} {error:#}", "Error:".red().bold());
+                    ExitCode::FAILURE
+                }
+            }
+        }
+        ContextSubCommand::Show { name } => {
+            let (name, context) = match config_file.resolve(name.or(selected).as_deref()) {
+                Ok(resolved) => resolved,
+                Err(error) => {
+                    eprintln!("{} {error:#}", "Error:".red().bold());
+                    return ExitCode::FAILURE;
+                }
+            };
+            let unset = || String::from("(not set)");
+            println!(
+                "Context:  {}",
+                name.unwrap_or_else(|| String::from("(none)"))
+            );
+            println!(
+                "Endpoint: {}",
+                context
+                    .endpoint
+                    .unwrap_or_else(|| String::from(DEFAULT_API_ENDPOINT))
+            );
+            println!(
+                "Token:    {}",
+                context.token.as_deref().map(redact).unwrap_or_else(unset)
+            );
+            println!("Repo:     {}", context.repo.unwrap_or_else(unset));
+            ExitCode::SUCCESS
+        }
+    }
+}
+
+/// Redact a token, keeping only its last few characters so that users can
+/// tell tokens apart.
+fn redact(token: &str) -> String {
+    let visible = token.len().saturating_sub(4);
+    match token.get(visible..) {
+        Some(suffix) if visible > 0 => format!("****{suffix}"),
+        _ => String::from("****"),
+    }
+}
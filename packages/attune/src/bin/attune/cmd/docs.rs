@@ -0,0 +1,227 @@
+use std::{fmt::Write as _, fs, path::PathBuf, process::ExitCode};
+
+use clap::{Arg, Args, Command, ValueEnum};
+use colored::Colorize as _;
+
+#[derive(Args, Debug)]
+pub struct DocsCommand {
+    /// Format of the reference documentation.
+    #[arg(long, value_enum, default_value_t = DocsFormat::Markdown)]
+    format: DocsFormat,
+
+    /// Directory to write the documentation into.
+    ///
+    /// Man pages are written one file per command (e.g.
+    /// `attune-apt-package-add.1`). Markdown is written as a single
+    /// `attune.md`, or printed to stdout if this is not set.
+    #[arg(long)]
+    out_dir: Option<PathBuf>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+enum DocsFormat {
+    /// roff man pages, for section 1.
+    Man,
+    /// A single Markdown document.
+    Markdown,
+}
+
+pub fn run(command: DocsCommand, cli: Command) -> ExitCode {
+    let result = match (command.format, command.out_dir) {
+        (DocsFormat::Man, Some(out_dir)) => clap_mangen::generate_to(cli, &out_dir)
+            .map_err(|error| format!("could not write man pages to {out_dir:?}: {error}")),
+        (DocsFormat::Man, None) => Err(String::from("man pages require --out-dir")),
+        (DocsFormat::Markdown, Some(out_dir)) => {
+            let path = out_dir.join("attune.md");
+            fs::write(&path, markdown(cli))
+                .map_err(|error| format!("could not write {path:?}: {error}"))
+        }
+        (DocsFormat::Markdown, None) => {
+            print!("{}", markdown(cli));
+            Ok(())
+        }
+    };
+    match result {
+        Ok(()) => ExitCode::SUCCESS,
+        Err(error) => {
+            eprintln!("{} {error}", "Error:".red().bold());
+            ExitCode::FAILURE
+        }
+    }
+}
+
+/// Render a reference for every visible command and flag as Markdown.
+fn markdown(mut cli: Command) -> String {
+    // Building the command fills in usage strings, binary names of
+    // subcommands, and the generated `--help` and `--version` flags.
+    cli.build();
+    let mut out = format!("# Command-line reference for `{}`\n", cli.get_name());
+    markdown_command(&mut out, &cli, true);
+    out
+}
+
+fn markdown_command(out: &mut String, command: &Command, root: bool) {
+    let name = command.get_bin_name().unwrap_or(command.get_name());
+    writeln!(out, "\n## `{name}`\n").unwrap();
+    if let Some(about) = command.get_long_about().or(command.get_about()) {
+        writeln!(out, "{about}\n").unwrap();
+    }
+    let usage = command.clone().render_usage().to_string();
+    let usage = usage.strip_prefix("Usage: ").unwrap_or(&usage);
+    writeln!(out, "**Usage:** `{usage}`").unwrap();
+
+    let subcommands = visible_subcommands(command).collect::<Vec<_>>();
+    if !subcommands.is_empty() {
+        writeln!(out, "\n**Commands:**\n").unwrap();
+        for subcommand in &subcommands {
+            match subcommand.get_about() {
+                Some(about) => writeln!(out, "* `{}` — {about}", subcommand.get_name()),
+                None => writeln!(out, "* `{}`", subcommand.get_name()),
+            }
+            .unwrap();
+        }
+    }
+
+    // Global flags are propagated to every subcommand, but are only
+    // documented once, on the root command.
+    let args = command
+        .get_arguments()
+        .filter(|arg| !arg.is_hide_set() && (root || !arg.is_global_set()))
+        .collect::<Vec<_>>();
+    for (heading, positional) in [("Arguments", true), ("Options", false)] {
+        let args = args
+            .iter()
+            .filter(|arg| arg.is_positional() == positional)
+            .collect::<Vec<_>>();
+        if args.is_empty() {
+            continue;
+        }
+        writeln!(out, "\n**{heading}:**\n").unwrap();
+        for arg in args {
+            markdown_arg(out, arg);
+        }
+    }
+
+    for subcommand in subcommands {
+        markdown_command(out, subcommand, false);
+    }
+}
+
+fn markdown_arg(out: &mut String, arg: &Arg) {
+    write!(out, "* `{}`", arg_synopsis(arg)).unwrap();
+    match arg.get_long_help().or(arg.get_help()) {
+        // Continuation paragraphs are indented to stay in the list item.
+        Some(help) => writeln!(out, " — {}", help.to_string().replace("\n\n", "\n\n  ")),
+        None => writeln!(out),
+    }
+    .unwrap();
+
+    let mut details = Vec::new();
+    if let Some(env) = arg.get_env() {
+        details.push(format!("Environment variable: `{}`", env.to_string_lossy()));
+    }
+    let defaults = arg
+        .get_default_values()
+        .iter()
+        .map(|value| format!("`{}`", value.to_string_lossy()))
+        .collect::<Vec<_>>();
+    if !defaults.is_empty() && arg.get_action().takes_values() {
+        details.push(format!("Default value: {}", defaults.join(", ")));
+    }
+    let values = arg
+        .get_possible_values()
+        .into_iter()
+        .filter(|value| !value.is_hide_set())
+        .map(|value| match value.get_help() {
+            Some(help) => format!("`{}`: {help}", value.get_name()),
+            None => format!("`{}`", value.get_name()),
+        })
+        .collect::<Vec<_>>();
+    if !values.is_empty() && arg.get_action().takes_values() {
+        details.push(format!("Possible values: {}", values.join("; ")));
+    }
+    for detail in details {
+        writeln!(out, "\n  {detail}").unwrap();
+    }
+}
+
+/// How an argument is written on the command line, e.g. `-o, --output
+/// <OUTPUT>`.
+fn arg_synopsis(arg: &Arg) -> String {
+    let flag = match (arg.get_short(), arg.get_long()) {
+        (Some(short), Some(long)) => format!("-{short}, --{long}"),
+        (Some(short), None) => format!("-{short}"),
+        (None, Some(long)) => format!("--{long}"),
+        (None, None) => String::new(),
+    };
+    if !arg.get_action().takes_values() {
+        return flag;
+    }
+    let value = match arg.get_value_names() {
+        Some(names) => names
+            .iter()
+            .map(|name| format!("<{name}>"))
+            .collect::<Vec<_>>()
+            .join(" "),
+        None => format!("<{}>", arg.get_id()),
+    };
+    match flag.is_empty() {
+        true => value,
+        false => format!("{flag} {value}"),
+    }
+}
+
+fn visible_subcommands(command: &Command) -> impl Iterator<Item = &Command> {
+    command
+        .get_subcommands()
+        .filter(|subcommand| !subcommand.is_hide_set() && subcommand.get_name() != "help")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn cli() -> Command {
+        Command::new("attune")
+            .about("Attune CLI")
+            .arg(
+                Arg::new("output")
+                    .long("output")
+                    .short('o')
+                    .global(true)
+                    .help("Format of command output")
+                    .value_parser(["text", "json"])
+                    .default_value("text"),
+            )
+            .subcommand(
+                Command::new("repo")
+                    .about("Manage repositories")
+                    .subcommand(
+                        Command::new("delete")
+                            .about("Delete a repository")
+                            .arg(Arg::new("name").required(true).help("Repository name")),
+                    ),
+            )
+            .subcommand(Command::new("secret").hide(true))
+    }
+
+    #[test]
+    fn documents_every_visible_command() {
+        let docs = markdown(cli());
+        assert!(docs.contains("\n## `attune`\n"));
+        assert!(docs.contains("\n## `attune repo`\n"));
+        assert!(docs.contains("\n## `attune repo delete`\n"));
+        assert!(docs.contains("**Usage:** `attune repo delete "));
+        assert!(docs.contains("* `<name>` — Repository name"));
+        assert!(!docs.contains("secret"));
+        assert!(!docs.contains("## `attune help`"));
+    }
+
+    #[test]
+    fn documents_global_options_once() {
+        let docs = markdown(cli());
+        assert_eq!(docs.matches("* `-o, --output <output>`").count(), 1);
+        assert!(docs.contains("Default value: `text`"));
+        assert!(docs.contains("Possible values: `text`; `json`"));
+    }
+}
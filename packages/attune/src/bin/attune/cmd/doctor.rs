@@ -0,0 +1,354 @@
+use std::{process::ExitCode, time::Duration};
+
+use chrono::{DateTime, Utc};
+use clap::Args;
+use colored::Colorize as _;
+use gpgme::{Context, Protocol};
+use http::{StatusCode, header::DATE};
+use serde::Serialize;
+
+use crate::config::{Config, SendRetrying as _};
+use attune::{api::ErrorResponse, server::meta::MetaResponse};
+
+/// How long to wait for each request to the API server, so that diagnosing a
+/// host without network access doesn't hang.
+const REQUEST_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// How far the local clock may drift from the API server's before signatures
+/// made on this host are likely to be rejected.
+const MAX_CLOCK_SKEW: Duration = Duration::from_secs(60);
+
+#[derive(Args, Debug)]
+pub struct DoctorCommand {
+    /// GPG key ID to sign with (see `gpg --list-secret-keys`)
+    ///
+    /// If not set and there is only one signing key available, that key will be
+    /// used, the same as when publishing.
+    #[arg(long, short)]
+    key_id: Option<String>,
+    /// GPG home directory to use for signing.
+    ///
+    /// If not set, defaults to the standard GPG home directory
+    /// for the platform.
+    #[arg(long, short)]
+    gpg_home_dir: Option<String>,
+}
+
+#[derive(Serialize, Debug, Clone, Copy, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+enum Status {
+    Ok,
+    Warn,
+    Fail,
+    /// The check couldn't run because an earlier check failed.
+    Skipped,
+}
+
+/// The outcome of a single diagnostic check.
+#[derive(Serialize, Debug)]
+struct Check {
+    name: &'static str,
+    status: Status,
+    message: String,
+    /// What to do about a failed check.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    remediation: Option<String>,
+}
+
+impl Check {
+    fn ok(name: &'static str, message: impl Into<String>) -> Self {
+        Self {
+            name,
+            status: Status::Ok,
+            message: message.into(),
+            remediation: None,
+        }
+    }
+
+    fn warn(
+        name: &'static str,
+        message: impl Into<String>,
+        remediation: impl Into<String>,
+    ) -> Self {
+        Self {
+            name,
+            status: Status::Warn,
+            message: message.into(),
+            remediation: Some(remediation.into()),
+        }
+    }
+
+    fn fail(
+        name: &'static str,
+        message: impl Into<String>,
+        remediation: impl Into<String>,
+    ) -> Self {
+        Self {
+            name,
+            status: Status::Fail,
+            message: message.into(),
+            remediation: Some(remediation.into()),
+        }
+    }
+
+    fn skipped(name: &'static str, reason: impl Into<String>) -> Self {
+        Self {
+            name,
+            status: Status::Skipped,
+            message: reason.into(),
+            remediation: None,
+        }
+    }
+}
+
+/// Diagnose common problems with the local environment.
+///
+/// Unlike other commands, this runs without an API token so that a missing
+/// token is reported as a failed check rather than an error.
+pub async fn run(ctx: Config, has_token: bool, command: DoctorCommand) -> ExitCode {
+    let (connectivity, server_time) = check_connectivity(&ctx).await;
+    let reachable = connectivity.status == Status::Ok;
+    let token = match (reachable, has_token) {
+        (_, false) => Check::fail(
+            "token",
+            "no API token provided",
+            "set --api-token, $ATTUNE_API_TOKEN, or `token` in the config file",
+        ),
+        (false, true) => Check::skipped("token", "API server is unreachable"),
+        (true, true) => check_token(&ctx).await,
+    };
+    let clock = match server_time {
+        Some(server_time) => check_clock(server_time, Utc::now()),
+        None => Check::skipped("clock", "API server did not report its time"),
+    };
+    let gpg = check_gpg();
+    let signing = match gpg.status {
+        Status::Ok => check_signing(command.gpg_home_dir, command.key_id).await,
+        _ => Check::skipped("signing", "GPG is unavailable"),
+    };
+    let checks = vec![connectivity, token, clock, gpg, signing];
+
+    let failed = checks.iter().any(|check| check.status == Status::Fail);
+    if let Some(rendered) = ctx.output.render(&checks) {
+        println!("{rendered}");
+    } else {
+        for check in &checks {
+            print_check(check);
+        }
+    }
+    match failed {
+        true => ExitCode::FAILURE,
+        false => ExitCode::SUCCESS,
+    }
+}
+
+fn print_check(check: &Check) {
+    let label = match check.status {
+        Status::Ok => "ok  ".green(),
+        Status::Warn => "warn".yellow(),
+        Status::Fail => "fail".red(),
+        Status::Skipped => "skip".dimmed(),
+    };
+    println!("{label} {}: {}", check.name, check.message);
+    if let Some(remediation) = &check.remediation {
+        println!("     {} {remediation}", "fix:".bold());
+    }
+}
+
+/// Check that the API server is reachable, returning the server's clock
+/// reading from the response's `Date` header if it has one.
+async fn check_connectivity(ctx: &Config) -> (Check, Option<DateTime<Utc>>) {
+    const NAME: &str = "connectivity";
+    let res = ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/meta").unwrap())
+        .timeout(REQUEST_TIMEOUT)
+        .send_retrying(&ctx.clone().with_max_retries(0))
+        .await;
+    let res = match res {
+        Ok(res) => res,
+        Err(error) => {
+            return (
+                Check::fail(
+                    NAME,
+                    format!("could not reach {}: {error}", ctx.endpoint),
+                    "check your network connection, and that --endpoint (or $ATTUNE_API_ENDPOINT) is correct",
+                ),
+                None,
+            );
+        }
+    };
+    let server_time = res
+        .headers()
+        .get(DATE)
+        .and_then(|date| date.to_str().ok())
+        .and_then(|date| DateTime::parse_from_rfc2822(date).ok())
+        .map(|date| date.with_timezone(&Utc));
+    let check = match res.status() {
+        StatusCode::OK => match res.json::<MetaResponse>().await {
+            Ok(meta) => Check::ok(
+                NAME,
+                format!(
+                    "reached {} (server version {})",
+                    ctx.endpoint, meta.server_version
+                ),
+            ),
+            Err(error) => Check::fail(
+                NAME,
+                format!(
+                    "{} did not respond like an Attune API server: {error}",
+                    ctx.endpoint
+                ),
+                "check that --endpoint (or $ATTUNE_API_ENDPOINT) points at the Attune API server, not a proxy or the repository's public URL",
+            ),
+        },
+        // Servers that predate the metadata endpoint are still usable.
+        StatusCode::NOT_FOUND => Check::ok(NAME, format!("reached {}", ctx.endpoint)),
+        status => Check::fail(
+            NAME,
+            format!("{} responded with {status}", ctx.endpoint),
+            "check that --endpoint (or $ATTUNE_API_ENDPOINT) is correct, and that the API server is healthy",
+        ),
+    };
+    (check, server_time)
+}
+
+/// Check that the API server accepts the API token.
+async fn check_token(ctx: &Config) -> Check {
+    const NAME: &str = "token";
+    let res = ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/tokens").unwrap())
+        .timeout(REQUEST_TIMEOUT)
+        .send_retrying(&ctx.clone().with_max_retries(0))
+        .await;
+    let res = match res {
+        Ok(res) => res,
+        Err(error) => {
+            return Check::fail(
+                NAME,
+                format!("could not reach {}: {error}", ctx.endpoint),
+                "check your network connection and try again",
+            );
+        }
+    };
+    match res.status() {
+        StatusCode::OK => Check::ok(NAME, "API token is valid"),
+        StatusCode::UNAUTHORIZED | StatusCode::FORBIDDEN => {
+            let message = match res.json::<ErrorResponse>().await {
+                Ok(error) => error.message,
+                Err(_) => String::from("API token was rejected"),
+            };
+            Check::fail(
+                NAME,
+                message,
+                "check that the token is current (it may have been revoked or rotated), and that it belongs to this endpoint's tenant",
+            )
+        }
+        status => Check::warn(
+            NAME,
+            format!("could not verify API token: API server responded with {status}"),
+            "try again later; if the problem persists, contact your Attune administrator",
+        ),
+    }
+}
+
+/// Check that the local clock agrees with the API server's.
+///
+/// Signatures are timestamped with the local clock, and APT rejects release
+/// files that appear to be from the future.
+fn check_clock(server_time: DateTime<Utc>, local_time: DateTime<Utc>) -> Check {
+    const NAME: &str = "clock";
+    let skew = (local_time - server_time).abs();
+    // The `Date` header only has whole-second precision.
+    let seconds = skew.num_seconds();
+    match skew.to_std() {
+        Ok(skew) if skew <= MAX_CLOCK_SKEW => Check::ok(
+            NAME,
+            format!("local clock is within {seconds}s of the API server's"),
+        ),
+        _ => Check::fail(
+            NAME,
+            format!(
+                "local clock is {seconds}s {} the API server's",
+                match local_time > server_time {
+                    true => "ahead of",
+                    false => "behind",
+                }
+            ),
+            "synchronize the system clock (e.g. enable NTP with `timedatectl set-ntp true`)",
+        ),
+    }
+}
+
+/// Check that GPGME can find a GnuPG installation.
+fn check_gpg() -> Check {
+    const NAME: &str = "gpg";
+    match Context::from_protocol(Protocol::OpenPgp) {
+        Ok(gpg) => {
+            let engine = gpg.engine_info();
+            Check::ok(
+                NAME,
+                format!(
+                    "found GnuPG {} at {}",
+                    engine.version().unwrap_or("(unknown version)"),
+                    engine.path().unwrap_or("(unknown path)")
+                ),
+            )
+        }
+        Err(error) => Check::fail(
+            NAME,
+            format!("could not use GnuPG: {error}"),
+            "install GnuPG (e.g. `apt install gnupg`)",
+        ),
+    }
+}
+
+/// Check that the signing key can produce a signature, the same way that
+/// publishing does.
+async fn check_signing(gpg_home_dir: Option<String>, key_id: Option<String>) -> Check {
+    const NAME: &str = "signing";
+    let has_key_id = key_id.is_some();
+    match crate::gpg_sign(gpg_home_dir, key_id, "attune doctor test signature\n").await {
+        Ok(_) => Check::ok(NAME, "made a test signature"),
+        Err(error) => Check::fail(
+            NAME,
+            format!("could not make a test signature: {error:#}"),
+            match has_key_id {
+                true => {
+                    "check that the key is listed by `gpg --list-secret-keys`, and that gpg-agent can unlock it"
+                }
+                false => {
+                    "pass --key-id to choose a key (see `gpg --list-secret-keys`), or import a signing key with `gpg --import`"
+                }
+            },
+        ),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn clock_skew() {
+        let server = DateTime::parse_from_rfc2822("Tue, 21 Oct 2025 07:28:00 GMT")
+            .unwrap()
+            .with_timezone(&Utc);
+        assert_eq!(
+            check_clock(server, server + chrono::Duration::seconds(30)).status,
+            Status::Ok
+        );
+
+        let check = check_clock(server, server + chrono::Duration::minutes(5));
+        assert_eq!(check.status, Status::Fail);
+        assert_eq!(
+            check.message,
+            "local clock is 300s ahead of the API server's"
+        );
+
+        let check = check_clock(server, server - chrono::Duration::minutes(5));
+        assert_eq!(check.status, Status::Fail);
+        assert_eq!(check.message, "local clock is 300s behind the API server's");
+    }
+}
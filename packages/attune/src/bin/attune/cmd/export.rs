@@ -0,0 +1,44 @@
+use std::{path::PathBuf, process::ExitCode};
+
+use clap::Args;
+
+use crate::{cmd::apply::fetch_live, config::Config, exit::Failure};
+
+#[derive(Args, Debug)]
+pub struct ExportCommand {
+    /// Write the YAML to this file instead of stdout
+    #[arg(long, short)]
+    file: Option<PathBuf>,
+}
+
+/// Print every repository and distribution in the format that `attune apply`
+/// reads, so that existing state can be put under version control.
+///
+/// Packages aren't exported.
+pub async fn run(ctx: Config, command: ExportCommand) -> ExitCode {
+    let manifest = match fetch_live(&ctx).await {
+        Ok(manifest) => manifest,
+        Err(error) => return ctx.report_error("fetching current state", error),
+    };
+    let yaml = serde_yaml::to_string(&manifest).expect("Could not serialize manifest");
+    match command.file {
+        Some(path) => {
+            if let Err(error) = std::fs::write(&path, yaml) {
+                return ctx.error(
+                    Failure::General,
+                    format!("could not write {path:?}: {error}"),
+                );
+            }
+            ctx.status(format!(
+                "Exported {} repositories to {path:?}",
+                manifest.repositories.len()
+            ));
+        }
+        // JSON is also YAML, so `attune apply` reads either.
+        None => match ctx.output.render(&manifest) {
+            Some(rendered) => println!("{rendered}"),
+            None => print!("{yaml}"),
+        },
+    }
+    ExitCode::SUCCESS
+}
@@ -1 +1,14 @@
+pub mod api;
+pub mod apply;
 pub mod apt;
+pub mod audit;
+pub mod context;
+pub mod docs;
+pub mod doctor;
+pub mod export;
+pub mod ping;
+pub mod plugin;
+pub mod schema;
+pub mod selftest;
+pub mod token;
+pub mod version;
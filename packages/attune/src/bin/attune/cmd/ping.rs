@@ -0,0 +1,105 @@
+use std::{process::ExitCode, time::Instant};
+
+use axum::http::StatusCode;
+use clap::Args;
+use serde::Serialize;
+
+use crate::config::{Config, SendRetrying as _};
+use attune::{
+    api::ErrorResponse,
+    server::{health::HealthCheckResponse, meta::MetaResponse},
+};
+
+#[derive(Args, Debug)]
+pub struct PingCommand {}
+
+#[derive(Serialize, Debug)]
+struct PingOutput {
+    endpoint: String,
+    /// Round-trip time of the health check, in milliseconds.
+    latency_ms: u128,
+    /// The API server's metadata, unless the server predates it.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    server: Option<MetaResponse>,
+}
+
+/// Check that the API server is up and can reach its database.
+///
+/// Like `version`, this doesn't need an API token, and doesn't retry, so that
+/// it fails fast when the endpoint is misconfigured.
+pub async fn run(ctx: Config, _: PingCommand) -> ExitCode {
+    let ctx = ctx.with_max_retries(0);
+
+    let start = Instant::now();
+    let res = match ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/health").unwrap())
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    let latency = start.elapsed();
+    match res.status() {
+        StatusCode::OK => {
+            res.json::<HealthCheckResponse>()
+                .await
+                .expect("Could not parse response");
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            return ctx.api_error("checking API server health", error);
+        }
+    }
+
+    let res = match ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/meta").unwrap())
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    let server = match res.status() {
+        StatusCode::OK => Some(
+            res.json::<MetaResponse>()
+                .await
+                .expect("Could not parse response"),
+        ),
+        StatusCode::NOT_FOUND => None,
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            return ctx.api_error("fetching API server metadata", error);
+        }
+    };
+
+    let output = PingOutput {
+        endpoint: ctx.endpoint.to_string(),
+        latency_ms: latency.as_millis(),
+        server,
+    };
+    if let Some(rendered) = ctx.output.render(&output) {
+        println!("{rendered}");
+        return ExitCode::SUCCESS;
+    }
+    match &output.server {
+        Some(meta) => println!(
+            "{} is healthy ({}ms, server version {}, API versions {} to {})",
+            output.endpoint,
+            output.latency_ms,
+            meta.server_version,
+            meta.minimum_api_version,
+            meta.latest_api_version
+        ),
+        None => println!("{} is healthy ({}ms)", output.endpoint, output.latency_ms),
+    }
+    ExitCode::SUCCESS
+}
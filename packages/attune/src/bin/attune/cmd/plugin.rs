@@ -0,0 +1,55 @@
+use std::{ffi::OsString, io::ErrorKind, process::ExitCode};
+
+use colored::Colorize as _;
+use tracing::{debug, instrument};
+
+/// Prefix of executables on `PATH` that are run as plugins.
+///
+/// Invoking `attune foo` runs `attune-foo`, in the same way that `git foo`
+/// runs `git-foo`.
+const PLUGIN_PREFIX: &str = "attune-";
+
+/// Run an external plugin for an unrecognized subcommand.
+///
+/// `args` is the subcommand name followed by its arguments. The plugin
+/// receives the remaining arguments as-is, and the API endpoint and token
+/// through the same environment variables that the CLI reads them from, so
+/// that plugins can use the CLI's configuration without re-implementing it.
+#[instrument(skip(api_token))]
+pub fn run(args: Vec<OsString>, api_endpoint: String, api_token: Option<String>) -> ExitCode {
+    let Some((name, args)) = args.split_first() else {
+        eprintln!("{} no subcommand provided", "Error:".red().bold());
+        return ExitCode::FAILURE;
+    };
+    let mut program = OsString::from(PLUGIN_PREFIX);
+    program.push(name);
+
+    let mut command = std::process::Command::new(&program);
+    command.args(args).env("ATTUNE_API_ENDPOINT", api_endpoint);
+    if let Some(api_token) = api_token {
+        command.env("ATTUNE_API_TOKEN", api_token);
+    }
+
+    debug!(?program, ?args, "running plugin");
+    match command.status() {
+        // Plugins that were killed by a signal have no exit code.
+        Ok(status) => status
+            .code()
+            .map(|code| ExitCode::from(u8::try_from(code).unwrap_or(1)))
+            .unwrap_or(ExitCode::FAILURE),
+        Err(error) if error.kind() == ErrorKind::NotFound => {
+            eprintln!(
+                "{} unrecognized subcommand {name:?} (no {program:?} plugin found on PATH)\n\nFor more information, try '--help'.",
+                "Error:".red().bold()
+            );
+            ExitCode::FAILURE
+        }
+        Err(error) => {
+            eprintln!(
+                "{} could not run plugin {program:?}: {error}",
+                "Error:".red().bold()
+            );
+            ExitCode::FAILURE
+        }
+    }
+}
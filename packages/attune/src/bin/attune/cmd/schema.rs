@@ -0,0 +1,69 @@
+use std::process::ExitCode;
+
+use clap::Args;
+use colored::Colorize as _;
+use serde_json::{Map, Value, json};
+
+use attune::server::schema::{SCHEMA_VERSION, schemas};
+
+#[derive(Args, Debug)]
+pub struct SchemaCommand {
+    /// Name of a single schema to print (e.g. "repo.list").
+    ///
+    /// If not set, all schemas are printed as a single document keyed by
+    /// name.
+    name: Option<String>,
+
+    /// List the names of available schemas instead of printing them.
+    #[arg(long, conflicts_with = "name")]
+    list: bool,
+
+    /// Print the schemas as an OpenAPI document.
+    #[arg(long, conflicts_with_all = ["name", "list"])]
+    openapi: bool,
+}
+
+pub fn run(command: SchemaCommand) -> ExitCode {
+    if command.openapi {
+        let document = attune::server::schema::openapi();
+        println!("{}", serde_json::to_string_pretty(&document).unwrap());
+        return ExitCode::SUCCESS;
+    }
+
+    let schemas = schemas();
+    if command.list {
+        for named in schemas {
+            println!("{}", named.name);
+        }
+        return ExitCode::SUCCESS;
+    }
+
+    let document = match command.name {
+        Some(name) => match schemas.into_iter().find(|named| named.name == name) {
+            Some(named) => {
+                json!({ "version": SCHEMA_VERSION, "name": name, "schema": named.schema })
+            }
+            None => {
+                eprintln!(
+                    "{} unknown schema {name:?} (see `attune schema --list`)",
+                    "Error:".red().bold()
+                );
+                return ExitCode::FAILURE;
+            }
+        },
+        None => {
+            let schemas = schemas
+                .into_iter()
+                .map(|named| {
+                    (
+                        named.name.to_string(),
+                        serde_json::to_value(named.schema).unwrap(),
+                    )
+                })
+                .collect::<Map<String, Value>>();
+            json!({ "version": SCHEMA_VERSION, "schemas": schemas })
+        }
+    };
+    println!("{}", serde_json::to_string_pretty(&document).unwrap());
+    ExitCode::SUCCESS
+}
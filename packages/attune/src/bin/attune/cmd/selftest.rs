@@ -0,0 +1,282 @@
+use std::process::ExitCode;
+
+use clap::Args;
+use color_eyre::eyre::{Context as _, Result, bail};
+use colored::Colorize as _;
+use http::StatusCode;
+use percent_encoding::percent_encode;
+use serde::de::DeserializeOwned;
+use tracing::{debug, instrument};
+use uuid::Uuid;
+
+use crate::{
+    cmd::apt::pkg::add::{PkgAddCommand, add_package, upload_file_content},
+    config::{Config, SendRetrying as _},
+};
+use attune::{
+    api::{ErrorResponse, PATH_SEGMENT_PERCENT_ENCODE_SET},
+    server::{
+        pkg::list::{PackageListParams, PackageListResponse},
+        repo::{
+            create::{CreateRepositoryRequest, CreateRepositoryResponse},
+            delete::{DeleteRepositoryRequest, DeleteRepositoryResponse},
+            dist::delete::{DeleteDistributionParams, DeleteDistributionResponse},
+            sync::check::CheckConsistencyResponse,
+        },
+    },
+    testing::{TEST_PACKAGE_AMD64, gpg_key_id},
+};
+
+const DISTRIBUTION: &str = "selftest";
+const COMPONENT: &str = "main";
+
+#[derive(Args, Debug)]
+pub struct SelftestCommand {
+    /// Keep the throwaway repository instead of deleting it afterwards.
+    ///
+    /// Useful for inspecting the published output when a check fails.
+    #[arg(long)]
+    keep: bool,
+}
+
+/// Exercise the full publishing flow against the configured API server.
+///
+/// This creates a throwaway repository, publishes a package into it signed
+/// with a throwaway key, checks that the published objects match the
+/// server's view of the repository, and then deletes everything it created.
+pub async fn run(ctx: Config, command: SelftestCommand) -> ExitCode {
+    let repo = format!("attune-selftest-{}", Uuid::new_v4());
+    println!(
+        "Running self-test against {} in repository {repo:?}",
+        ctx.endpoint
+    );
+
+    let created = step("create repository", create_repository(&ctx, &repo)).await;
+    let tested = match created {
+        true => publish_and_verify(&ctx, &repo).await,
+        false => false,
+    };
+
+    let cleaned = match (created, command.keep) {
+        (false, _) => true,
+        (true, true) => {
+            println!("Keeping repository {repo:?}");
+            true
+        }
+        (true, false) => {
+            step("delete distribution", delete_distribution(&ctx, &repo)).await
+                && step("delete repository", delete_repository(&ctx, &repo)).await
+        }
+    };
+
+    if tested && cleaned {
+        println!("\n{}", "Self-test passed".green());
+        ExitCode::SUCCESS
+    } else {
+        eprintln!("\n{}", "Self-test failed".red());
+        ExitCode::FAILURE
+    }
+}
+
+/// Run the steps that need a repository to exist, stopping at the first
+/// failure.
+async fn publish_and_verify(ctx: &Config, repo: &str) -> bool {
+    // The key's home directory must outlive every step that signs with it.
+    let (key_id, _gpg, gpg_home_dir) =
+        match step_value("generate signing key", async { gpg_key_id().await }).await {
+            Some(key) => key,
+            None => return false,
+        };
+
+    let package_file = gpg_home_dir.dir_path().join("selftest.deb");
+    let command = PkgAddCommand::builder()
+        .repo(repo)
+        .distribution(DISTRIBUTION)
+        .component(COMPONENT)
+        .key_id(key_id)
+        .gpg_home_dir(gpg_home_dir.dir_path().to_string_lossy())
+        .package_file(package_file.to_string_lossy())
+        .build();
+
+    let Some(sha256sum) = step_value("upload package", async {
+        std::fs::write(&package_file, TEST_PACKAGE_AMD64).context("write package file")?;
+        upload_file_content(ctx, &command).await
+    })
+    .await
+    else {
+        return false;
+    };
+
+    step(
+        "publish signed index",
+        add_package(ctx, &command, &sha256sum),
+    )
+    .await
+        && step(
+            "verify package is listed",
+            verify_listed(ctx, repo, &sha256sum),
+        )
+        .await
+        && step("verify published objects", verify_consistent(ctx, repo)).await
+}
+
+/// Run a step, printing its outcome.
+async fn step(name: &str, fut: impl Future<Output = Result<()>>) -> bool {
+    step_value(name, fut).await.is_some()
+}
+
+/// Run a step that produces a value, printing its outcome.
+async fn step_value<T>(name: &str, fut: impl Future<Output = Result<T>>) -> Option<T> {
+    match fut.await {
+        Ok(value) => {
+            println!("{} {name}", "ok  ".green());
+            Some(value)
+        }
+        Err(error) => {
+            println!("{} {name}", "fail".red());
+            eprintln!("     {error:#}");
+            None
+        }
+    }
+}
+
+fn repo_path(repo: &str) -> String {
+    format!(
+        "/api/v0/repositories/{}",
+        percent_encode(repo.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+    )
+}
+
+fn dist_path(repo: &str) -> String {
+    format!(
+        "{}/distributions/{}",
+        repo_path(repo),
+        percent_encode(DISTRIBUTION.as_bytes(), PATH_SEGMENT_PERCENT_ENCODE_SET)
+    )
+}
+
+/// Parse a successful response, or turn an error response into an error.
+async fn parse_response<T: DeserializeOwned>(res: reqwest::Response) -> Result<T> {
+    match res.status() {
+        StatusCode::OK => res.json::<T>().await.context("parse response"),
+        status => {
+            let body = res.text().await.context("read response")?;
+            debug!(?body, ?status, "error response");
+            let error =
+                serde_json::from_str::<ErrorResponse>(&body).context("parse error response")?;
+            bail!(error);
+        }
+    }
+}
+
+#[instrument(skip(ctx))]
+async fn create_repository(ctx: &Config, repo: &str) -> Result<()> {
+    let res = ctx
+        .client
+        .post(ctx.endpoint.join("/api/v0/repositories").unwrap())
+        .json(&CreateRepositoryRequest {
+            name: repo.to_string(),
+            flat: false,
+        })
+        .send_retrying(&ctx)
+        .await
+        .context("send api request")?;
+    let created = parse_response::<CreateRepositoryResponse>(res).await?;
+    debug!(?created, "created repository");
+    Ok(())
+}
+
+#[instrument(skip(ctx))]
+async fn verify_listed(ctx: &Config, repo: &str, sha256sum: &str) -> Result<()> {
+    let res = ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/packages").unwrap())
+        .query(&PackageListParams {
+            repository: Some(repo.to_string()),
+            distribution: Some(DISTRIBUTION.to_string()),
+            component: Some(COMPONENT.to_string()),
+            name: None,
+            version: None,
+            architecture: None,
+            limit: None,
+            cursor: None,
+            sort: None,
+        })
+        .send_retrying(&ctx)
+        .await
+        .context("send api request")?;
+    let listed = parse_response::<PackageListResponse>(res).await?;
+    if !listed.packages.iter().any(|pkg| pkg.sha256sum == sha256sum) {
+        bail!("package {sha256sum} is not listed in the repository");
+    }
+    Ok(())
+}
+
+#[instrument(skip(ctx))]
+async fn verify_consistent(ctx: &Config, repo: &str) -> Result<()> {
+    let res = ctx
+        .client
+        .get(
+            ctx.endpoint
+                .join(format!("{}/sync", dist_path(repo)).as_str())
+                .unwrap(),
+        )
+        .send_retrying(&ctx)
+        .await
+        .context("send api request")?;
+    let status = parse_response::<CheckConsistencyResponse>(res)
+        .await?
+        .status;
+    let inconsistent = [
+        ("Release", status.release),
+        ("InRelease", status.release_clearsigned),
+        ("Release.gpg", status.release_detachsigned),
+    ]
+    .into_iter()
+    .filter_map(|(name, inconsistent)| inconsistent.then(|| name.to_string()))
+    .chain(status.packages_indexes)
+    .chain(status.packages)
+    .collect::<Vec<_>>();
+    if !inconsistent.is_empty() {
+        bail!(
+            "published objects do not match the repository: {}",
+            inconsistent.join(", ")
+        );
+    }
+    Ok(())
+}
+
+/// Delete the distribution, which also removes its published objects and any
+/// packages that are no longer referenced.
+#[instrument(skip(ctx))]
+async fn delete_distribution(ctx: &Config, repo: &str) -> Result<()> {
+    let res = ctx
+        .client
+        .delete(ctx.endpoint.join(&dist_path(repo)).unwrap())
+        .query(&DeleteDistributionParams { force: true })
+        .send_retrying(&ctx)
+        .await
+        .context("send api request")?;
+    match res.status() {
+        // The distribution is only created once the package is published, so
+        // it won't exist if an earlier step failed.
+        StatusCode::NOT_FOUND => Ok(()),
+        _ => parse_response::<DeleteDistributionResponse>(res)
+            .await
+            .map(|_| ()),
+    }
+}
+
+#[instrument(skip(ctx))]
+async fn delete_repository(ctx: &Config, repo: &str) -> Result<()> {
+    let res = ctx
+        .client
+        .delete(ctx.endpoint.join(&repo_path(repo)).unwrap())
+        .json(&DeleteRepositoryRequest {})
+        .send_retrying(&ctx)
+        .await
+        .context("send api request")?;
+    parse_response::<DeleteRepositoryResponse>(res)
+        .await
+        .map(|_| ())
+}
@@ -0,0 +1,56 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+
+use crate::config::{Config, SendRetrying as _};
+use attune::{
+    api::ErrorResponse,
+    server::token::create::{CreateTokenRequest, CreateTokenResponse},
+};
+
+#[derive(Args, Debug)]
+pub struct TokenCreateCommand {
+    /// A human-readable name for the token (e.g. "github-actions").
+    name: String,
+}
+
+pub async fn run(ctx: Config, command: TokenCreateCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .post(ctx.endpoint.join("/api/v0/tokens").unwrap())
+        .json(&CreateTokenRequest { name: command.name })
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<CreateTokenResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            // Only the token goes to stdout, so that it can be captured by
+            // scripts.
+            ctx.status(format!(
+                "Created token {:?} with ID {}. Store it now; it can't be shown again.",
+                res.name, res.id
+            ));
+            println!("{}", res.token);
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("creating token", error)
+        }
+    }
+}
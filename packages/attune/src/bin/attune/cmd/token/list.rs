@@ -0,0 +1,73 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+use time::{OffsetDateTime, format_description::well_known::Rfc3339};
+
+use crate::{
+    config::{Config, SendRetrying as _},
+    exit::Failure,
+    output::ColumnArgs,
+};
+use attune::{api::ErrorResponse, server::token::list::ListTokensResponse};
+
+#[derive(Args, Debug)]
+pub struct TokenListCommand {
+    #[command(flatten)]
+    columns: ColumnArgs,
+}
+
+pub async fn run(ctx: Config, command: TokenListCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/tokens").unwrap())
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<ListTokensResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            let format = |ts: OffsetDateTime| ts.format(&Rfc3339).unwrap();
+            let mut rows = vec![vec![
+                String::from("ID"),
+                String::from("Name"),
+                String::from("Created"),
+                String::from("Last used"),
+            ]];
+            for token in res.tokens {
+                rows.push(vec![
+                    token.id.to_string(),
+                    token.name,
+                    format(token.created_at),
+                    token
+                        .last_used_at
+                        .map(format)
+                        .unwrap_or_else(|| String::from("never")),
+                ]);
+            }
+            let rows = match command.columns.select(rows, &[]) {
+                Ok(rows) => rows,
+                Err(error) => return ctx.error(Failure::Usage, error),
+            };
+            println!("{}", ctx.output.table(rows));
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("listing tokens", error)
+        }
+    }
+}
@@ -0,0 +1,40 @@
+use std::process::ExitCode;
+
+use clap::{Args, Subcommand};
+
+use crate::config::Config;
+
+mod create;
+mod list;
+mod revoke;
+mod rotate;
+
+#[derive(Args, Debug)]
+pub struct TokenCommand {
+    #[command(subcommand)]
+    subcommand: TokenSubCommand,
+}
+
+#[derive(Subcommand, Debug)]
+pub enum TokenSubCommand {
+    /// Create a new API token
+    #[command(visible_aliases = ["new", "add"])]
+    Create(create::TokenCreateCommand),
+    /// Show API tokens and when they were last used
+    #[command(visible_alias = "ls")]
+    List(list::TokenListCommand),
+    /// Revoke an API token
+    #[command(visible_aliases = ["rm", "delete"])]
+    Revoke(revoke::TokenRevokeCommand),
+    /// Replace an API token's secret, invalidating the old one
+    Rotate(rotate::TokenRotateCommand),
+}
+
+pub async fn handle_token(ctx: Config, command: TokenCommand) -> ExitCode {
+    match command.subcommand {
+        TokenSubCommand::Create(create) => create::run(ctx, create).await,
+        TokenSubCommand::List(list) => list::run(ctx, list).await,
+        TokenSubCommand::Revoke(revoke) => revoke::run(ctx, revoke).await,
+        TokenSubCommand::Rotate(rotate) => rotate::run(ctx, rotate).await,
+    }
+}
@@ -0,0 +1,79 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+use colored::Colorize as _;
+use inquire::Confirm;
+
+use crate::config::{Config, SendRetrying as _};
+use attune::{api::ErrorResponse, server::token::revoke::RevokeTokenResponse};
+
+#[derive(Args, Debug)]
+pub struct TokenRevokeCommand {
+    /// ID of the token to revoke (see `attune token list`).
+    id: i64,
+
+    /// Skip confirmation prompt and proceed with revocation
+    #[arg(short, long)]
+    yes: bool,
+}
+
+pub async fn run(ctx: Config, command: TokenRevokeCommand) -> ExitCode {
+    eprintln!(
+        "{}",
+        format!(
+            "Warning: this will immediately revoke token {}; anything using it will stop working",
+            command.id
+        )
+        .on_red()
+    );
+
+    if !command.yes {
+        let confirm = Confirm::new("Are you sure you want to proceed?")
+            .with_default(false)
+            .prompt();
+        match confirm {
+            Ok(true) => {}
+            Ok(false) => return ExitCode::SUCCESS,
+            Err(e) => {
+                eprintln!("Aborting: {e}");
+                return ExitCode::FAILURE;
+            }
+        }
+    }
+
+    let res = match ctx
+        .client
+        .delete(
+            ctx.endpoint
+                .join(format!("/api/v0/tokens/{}", command.id).as_str())
+                .unwrap(),
+        )
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<RevokeTokenResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            println!("Token {} revoked", command.id);
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("revoking token", error)
+        }
+    }
+}
@@ -0,0 +1,54 @@
+use std::process::ExitCode;
+
+use axum::http::StatusCode;
+use clap::Args;
+
+use crate::config::{Config, SendRetrying as _};
+use attune::{api::ErrorResponse, server::token::rotate::RotateTokenResponse};
+
+#[derive(Args, Debug)]
+pub struct TokenRotateCommand {
+    /// ID of the token to rotate (see `attune token list`).
+    id: i64,
+}
+
+pub async fn run(ctx: Config, command: TokenRotateCommand) -> ExitCode {
+    let res = match ctx
+        .client
+        .post(
+            ctx.endpoint
+                .join(format!("/api/v0/tokens/{}/rotate", command.id).as_str())
+                .unwrap(),
+        )
+        .send_retrying(&ctx)
+        .await
+    {
+        Ok(res) => res,
+        Err(error) => return ctx.network_error(error),
+    };
+    match res.status() {
+        StatusCode::OK => {
+            let res = res
+                .json::<RotateTokenResponse>()
+                .await
+                .expect("Could not parse response");
+            if let Some(output) = ctx.output.render(&res) {
+                println!("{output}");
+                return ExitCode::SUCCESS;
+            }
+            ctx.status(format!(
+                "Rotated token {:?} with ID {}. The old token no longer works. Store the new one now; it can't be shown again.",
+                res.name, res.id
+            ));
+            println!("{}", res.token);
+            ExitCode::SUCCESS
+        }
+        _ => {
+            let error = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            ctx.api_error("rotating token", error)
+        }
+    }
+}
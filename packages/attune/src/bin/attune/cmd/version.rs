@@ -0,0 +1,107 @@
+use std::{process::ExitCode, time::Duration};
+
+use axum::http::StatusCode;
+use clap::Args;
+use colored::Colorize as _;
+use serde::Serialize;
+
+use crate::{
+    VERSION,
+    config::{Config, SendRetrying as _},
+};
+use attune::server::{compatibility::API_VERSION_HEADER_V0_2_0, meta::MetaResponse};
+
+/// How long to wait for the API server when checking for updates, so that the
+/// check doesn't hang on hosts without network access.
+const CHECK_TIMEOUT: Duration = Duration::from_secs(5);
+
+#[derive(Args, Debug)]
+pub struct VersionCommand {
+    /// Also ask the API server whether a newer CLI is available.
+    ///
+    /// Doesn't require an API token. If the API server can't be reached
+    /// within a few seconds, a warning is printed instead.
+    #[arg(long)]
+    check: bool,
+}
+
+#[derive(Serialize, Debug)]
+struct VersionOutput {
+    cli_version: &'static str,
+    api_version: &'static str,
+    /// The API server's metadata, if it was checked.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    server: Option<MetaResponse>,
+    /// Whether the API server supports a newer API version than this CLI, if
+    /// it was checked.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    update_available: Option<bool>,
+}
+
+pub async fn run(ctx: Config, command: VersionCommand) -> ExitCode {
+    let server = match command.check {
+        true => fetch_meta(&ctx).await,
+        false => None,
+    };
+    // API versions are dates, and so compare correctly as strings.
+    let update_available = server
+        .as_ref()
+        .map(|meta| API_VERSION_HEADER_V0_2_0 < meta.latest_api_version.as_str());
+    let output = VersionOutput {
+        cli_version: VERSION,
+        api_version: API_VERSION_HEADER_V0_2_0,
+        server,
+        update_available,
+    };
+    if let Some(rendered) = ctx.output.render(&output) {
+        println!("{rendered}");
+        return ExitCode::SUCCESS;
+    }
+
+    println!("attune CLI {}", output.cli_version);
+    println!("API version {}", output.api_version);
+    if let Some(meta) = &output.server {
+        println!(
+            "API server {} (version {}, API versions {} to {})",
+            ctx.endpoint, meta.server_version, meta.minimum_api_version, meta.latest_api_version
+        );
+        if API_VERSION_HEADER_V0_2_0 < meta.minimum_api_version.as_str() {
+            println!(
+                "{}",
+                "This CLI is too old for the API server; please upgrade the attune CLI".red()
+            );
+        } else if update_available == Some(true) {
+            println!("{}", "New version of attune available".blue());
+        } else {
+            println!("attune is up to date");
+        }
+    }
+    ExitCode::SUCCESS
+}
+
+/// Fetch the API server's metadata, warning (rather than failing) if it can't
+/// be fetched.
+async fn fetch_meta(ctx: &Config) -> Option<MetaResponse> {
+    let res = ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/meta").unwrap())
+        .timeout(CHECK_TIMEOUT)
+        .send_retrying(&ctx.clone().with_max_retries(0))
+        .await;
+    let warning = match res {
+        Ok(res) if res.status() == StatusCode::OK => match res.json::<MetaResponse>().await {
+            Ok(meta) => return Some(meta),
+            Err(error) => format!("could not parse API server metadata: {error}"),
+        },
+        Ok(res) if res.status() == StatusCode::NOT_FOUND => {
+            String::from("API server is too old to report its version")
+        }
+        Ok(res) => format!("API server responded with {}", res.status()),
+        Err(error) => format!("could not reach API server: {error}"),
+    };
+    eprintln!(
+        "{} could not check for updates: {warning}",
+        "Warning:".yellow()
+    );
+    None
+}
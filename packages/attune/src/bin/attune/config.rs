@@ -1,11 +1,77 @@
-use attune::server::compatibility::{API_VERSION_HEADER, API_VERSION_HEADER_V0_2_0};
-use reqwest::{Client, Url};
+use std::{
+    collections::BTreeMap,
+    io::Write as _,
+    os::unix::fs::OpenOptionsExt as _,
+    path::{Path, PathBuf},
+    process::ExitCode,
+    sync::atomic::{AtomicBool, Ordering},
+    time::{Duration, Instant},
+};
+
+use attune::{
+    api::ErrorResponse,
+    server::{
+        compatibility::{API_VERSION_HEADER, API_VERSION_HEADER_V0_2_0},
+        meta::{DEPRECATION_HEADER, SUNSET_HEADER},
+    },
+};
+use color_eyre::eyre::{Context as _, Result, eyre};
+use colored::Colorize as _;
+use reqwest::{
+    Client, Method, Request, RequestBuilder, Response, StatusCode, Url, header::RETRY_AFTER,
+};
+use serde::{Deserialize, Serialize};
+use tracing::warn;
 use uuid::Uuid;
 
+use crate::{
+    exit::{CommandError, Failure},
+    output::OutputFormat,
+    trace::{self, TraceHttp},
+};
+
+/// The API endpoint used when none is configured.
+pub const DEFAULT_API_ENDPOINT: &str = "https://api.attunehq.com";
+
+/// Identifies the CLI and its version to the API server.
+const USER_AGENT: &str = concat!("attune-cli/", env!("CARGO_PKG_VERSION"));
+
+/// The number of times a failed idempotent request is retried by default.
+pub const DEFAULT_MAX_RETRIES: u32 = 3;
+
+/// How long to wait to establish a connection (including the TLS handshake).
+const CONNECT_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// The default deadline, in seconds, for an API request to complete.
+pub const DEFAULT_TIMEOUT_SECS: u64 = 60;
+
+/// The default deadline, in seconds, for a package upload to complete. This is
+/// much longer than [`DEFAULT_TIMEOUT_SECS`] because package files can be
+/// large.
+pub const DEFAULT_UPLOAD_TIMEOUT_SECS: u64 = 30 * 60;
+
 #[derive(Debug, Clone)]
 pub struct Config {
     pub client: Client,
     pub endpoint: Url,
+    /// How many times [`SendRetrying::send_retrying`] retries a request after
+    /// a transient failure.
+    pub max_retries: u32,
+    /// Deadline for each API request attempt, unless the request sets its own.
+    pub timeout: Duration,
+    /// Deadline for package uploads.
+    pub upload_timeout: Duration,
+    /// Whether (and how much) to dump HTTP exchanges to stderr.
+    pub trace_http: Option<TraceHttp>,
+    /// W3C Trace Context trace ID shared by every request in this invocation.
+    pub trace_id: String,
+    /// How to print command results.
+    pub output: OutputFormat,
+    /// Whether to suppress status messages.
+    pub quiet: bool,
+    /// Repository for commands that operate on one, when `--repo` (or
+    /// `$ATTUNE_REPO`) isn't set.
+    pub default_repo: Option<String>,
 }
 
 impl Config {
@@ -37,8 +103,480 @@ impl Config {
             format!("Bearer {api_token}").parse().unwrap(),
         );
 
-        // Build default client.
-        let client = Client::builder().default_headers(headers).build().unwrap();
-        Self { client, endpoint }
+        // Build default client. Every request in an invocation shares this
+        // client so that connections are pooled. HTTPS endpoints negotiate
+        // HTTP/2 via ALPN when the server supports it.
+        let client = Client::builder()
+            .default_headers(headers)
+            .user_agent(USER_AGENT)
+            .connect_timeout(CONNECT_TIMEOUT)
+            .build()
+            .unwrap();
+        Self {
+            client,
+            endpoint,
+            max_retries: DEFAULT_MAX_RETRIES,
+            timeout: Duration::from_secs(DEFAULT_TIMEOUT_SECS),
+            upload_timeout: Duration::from_secs(DEFAULT_UPLOAD_TIMEOUT_SECS),
+            trace_http: None,
+            trace_id: hex::encode(rand::random::<[u8; 16]>()),
+            output: OutputFormat::default(),
+            quiet: false,
+            default_repo: None,
+        }
+    }
+
+    pub fn with_max_retries(self, max_retries: u32) -> Self {
+        Self {
+            max_retries,
+            ..self
+        }
+    }
+
+    pub fn with_trace_http(self, trace_http: Option<TraceHttp>) -> Self {
+        Self { trace_http, ..self }
+    }
+
+    pub fn with_output(self, output: OutputFormat) -> Self {
+        Self { output, ..self }
+    }
+
+    pub fn with_quiet(self, quiet: bool) -> Self {
+        Self { quiet, ..self }
+    }
+
+    pub fn with_default_repo(self, default_repo: Option<String>) -> Self {
+        Self {
+            default_repo,
+            ..self
+        }
+    }
+
+    pub fn with_timeouts(self, timeout: Duration, upload_timeout: Duration) -> Self {
+        Self {
+            timeout,
+            upload_timeout,
+            ..self
+        }
+    }
+}
+
+impl Config {
+    /// The repository to operate on: `repo` if it was set (by `--repo` or
+    /// `$ATTUNE_REPO`), otherwise the configuration file's default.
+    pub fn repo(&self, repo: Option<String>) -> Result<String, CommandError> {
+        repo.or_else(|| self.default_repo.clone()).ok_or_else(|| {
+            CommandError::new(
+                Failure::Usage,
+                "no repository provided (set --repo, $ATTUNE_REPO, or `repo` in the config file)",
+            )
+        })
+    }
+
+    /// Print a status message to stderr, unless in quiet mode.
+    ///
+    /// Status messages narrate what a command did. They are distinct from the
+    /// command's result (which goes to stdout), warnings, and errors, none of
+    /// which are suppressed.
+    pub fn status(&self, message: impl std::fmt::Display) {
+        if !self.quiet {
+            eprintln!("{message}");
+        }
+    }
+
+    /// Print a command's error to stderr, returning the code to exit with.
+    ///
+    /// In structured output modes, the error is printed as a single line of
+    /// JSON (which is also valid YAML), so that scripts can parse it.
+    pub fn fail(&self, error: CommandError) -> ExitCode {
+        match self.output.is_structured() {
+            true => eprintln!(
+                "{}",
+                serde_json::to_string(&error.output(&self.trace_id)).unwrap()
+            ),
+            false => eprintln!("{} {}", "Error:".red().bold(), error.message),
+        }
+        error.failure.into()
+    }
+
+    /// Print an error that isn't from the API server.
+    pub fn error(&self, failure: Failure, message: impl std::fmt::Display) -> ExitCode {
+        self.fail(CommandError::new(failure, message.to_string()))
+    }
+
+    /// Print an error returned by the API server while `doing` something.
+    pub fn api_error(&self, doing: &str, error: ErrorResponse) -> ExitCode {
+        let message = error.message.clone();
+        self.fail_while(doing, CommandError::from_response(error, message))
+    }
+
+    /// Print an error for a request that couldn't be sent.
+    pub fn network_error(&self, error: reqwest::Error) -> ExitCode {
+        self.error(
+            Failure::Network,
+            format!("could not reach API server: {error}"),
+        )
+    }
+
+    /// Print an error from a multi-step command while `doing` something. The
+    /// error may have come from the API server.
+    pub fn report_error(&self, doing: &str, report: color_eyre::Report) -> ExitCode {
+        let report = match report.downcast::<ErrorResponse>() {
+            Ok(error) => return self.api_error(doing, error),
+            Err(report) => report,
+        };
+        // The debug format includes the report's sections, such as span
+        // traces, which only make sense to humans.
+        let message = match self.output.is_structured() {
+            true => format!("{report:#}"),
+            false => format!("{report:?}"),
+        };
+        self.fail_while(
+            doing,
+            CommandError::new(Failure::from_report(&report), message),
+        )
+    }
+
+    fn fail_while(&self, doing: &str, error: CommandError) -> ExitCode {
+        if self.output.is_structured() {
+            return self.fail(error);
+        }
+        eprintln!("{} {doing}: {}", "Error".red().bold(), error.message);
+        error.failure.into()
+    }
+}
+
+/// Sends requests using the retry policy configured in [`Config`].
+pub trait SendRetrying {
+    /// Send a request, retrying transient failures.
+    ///
+    /// Only idempotent requests are retried, since otherwise we can't know
+    /// whether a failed request was partially applied. Requests are retried on
+    /// connection errors, timeouts, and 429, 502, and 503 responses, with
+    /// jittered exponential backoff. A `Retry-After` header on the response
+    /// overrides the backoff delay.
+    ///
+    /// Requests with streaming bodies (e.g. multipart uploads) can't be cloned,
+    /// and so are never retried.
+    ///
+    /// Each attempt is subject to [`Config::timeout`], unless the request sets
+    /// its own timeout.
+    fn send_retrying(self, ctx: &Config) -> impl Future<Output = reqwest::Result<Response>> + Send;
+}
+
+impl SendRetrying for RequestBuilder {
+    async fn send_retrying(self, ctx: &Config) -> reqwest::Result<Response> {
+        let (client, request) = self.build_split();
+        let mut request = request?;
+        request.timeout_mut().get_or_insert(ctx.timeout);
+        let idempotent = [
+            Method::GET,
+            Method::HEAD,
+            Method::PUT,
+            Method::DELETE,
+            Method::OPTIONS,
+        ]
+        .contains(request.method());
+
+        let mut attempt = 0;
+        loop {
+            let retry = if idempotent && attempt < ctx.max_retries {
+                request.try_clone()
+            } else {
+                None
+            };
+            let Some(retry) = retry else {
+                return execute(ctx, &client, request).await;
+            };
+
+            let delay = match execute(ctx, &client, retry).await {
+                Ok(res) if RETRY_STATUSES.contains(&res.status()) => {
+                    retry_after(&res).unwrap_or_else(|| backoff(attempt))
+                }
+                Err(error) if error.is_connect() || error.is_timeout() => backoff(attempt),
+                res => return res,
+            };
+            attempt += 1;
+            warn!(
+                method = %request.method(),
+                url = %request.url(),
+                ?delay,
+                attempt,
+                "retrying request after transient failure"
+            );
+            tokio::time::sleep(delay).await;
+        }
+    }
+}
+
+/// Execute a single request attempt, tracing it if enabled.
+///
+/// Each attempt is sent with a `traceparent` header, so that the server's logs
+/// for every request in this invocation share a trace ID.
+async fn execute(ctx: &Config, client: &Client, mut request: Request) -> reqwest::Result<Response> {
+    let span_id = hex::encode(rand::random::<[u8; 8]>());
+    request.headers_mut().insert(
+        "traceparent",
+        format!("00-{}-{span_id}-01", ctx.trace_id).parse().unwrap(),
+    );
+
+    let endpoint = format!("{} {}", request.method(), request.url().path());
+    let res = match ctx.trace_http {
+        None => client.execute(request).await?,
+        Some(mode) => {
+            trace::request(&request, mode);
+            let start = Instant::now();
+            match client.execute(request).await {
+                Ok(res) => trace::response(res, mode, start.elapsed()).await?,
+                Err(error) => {
+                    eprintln!("< error: {error}");
+                    return Err(error);
+                }
+            }
+        }
+    };
+    warn_deprecated(&endpoint, &res);
+    Ok(res)
+}
+
+/// Whether a deprecation warning has been shown in this invocation.
+static DEPRECATION_WARNED: AtomicBool = AtomicBool::new(false);
+
+/// Warn (once per invocation) when the server marks an endpoint as deprecated
+/// with `Deprecation` or `Sunset` headers, since that usually means that this
+/// CLI is out of date.
+fn warn_deprecated(endpoint: &str, res: &Response) {
+    let headers = res.headers();
+    let sunset = headers.get(SUNSET_HEADER).and_then(|v| v.to_str().ok());
+    if !headers.contains_key(DEPRECATION_HEADER) && sunset.is_none() {
+        return;
+    }
+    if DEPRECATION_WARNED.swap(true, Ordering::Relaxed) {
+        return;
+    }
+    let removal = match sunset {
+        Some(sunset) => format!(" and will stop working after {sunset}"),
+        None => String::new(),
+    };
+    eprintln!(
+        "{} the API server has deprecated {endpoint}{removal}. Please upgrade the attune CLI.\n",
+        "Warning:".yellow()
+    );
+}
+
+/// Response statuses that indicate a transient failure.
+const RETRY_STATUSES: [StatusCode; 3] = [
+    StatusCode::TOO_MANY_REQUESTS,
+    StatusCode::BAD_GATEWAY,
+    StatusCode::SERVICE_UNAVAILABLE,
+];
+
+/// Exponential backoff with full jitter, starting at up to 500ms and capped at
+/// 30s.
+fn backoff(attempt: u32) -> Duration {
+    const BASE_DELAY_MS: u64 = 500;
+    const MAX_DELAY_MS: u64 = 30_000;
+    let delay = BASE_DELAY_MS
+        .saturating_mul(2u64.saturating_pow(attempt))
+        .min(MAX_DELAY_MS);
+    Duration::from_millis(rand::random_range(0..=delay))
+}
+
+/// Parse a `Retry-After` header given in seconds.
+///
+/// HTTP dates are also valid here, but our API server never sends them, so we
+/// fall back to the default backoff for those.
+fn retry_after(res: &Response) -> Option<Duration> {
+    res.headers()
+        .get(RETRY_AFTER)?
+        .to_str()
+        .ok()?
+        .trim()
+        .parse()
+        .ok()
+        .map(Duration::from_secs)
+}
+
+/// Settings read from the CLI's configuration file.
+///
+/// Every setting is optional. Settings passed as flags or environment
+/// variables take precedence over the ones in this file.
+///
+/// The file may also define named contexts, each of which overrides the
+/// top-level settings when selected (with `--context`, or `attune context
+/// use`):
+///
+/// ```toml
+/// current_context = "staging"
+///
+/// [contexts.staging]
+/// endpoint = "https://attune.staging.example.com"
+/// token = "..."
+/// repo = "packages"
+/// ```
+#[derive(Debug, Default, Serialize, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct ConfigFile {
+    /// Attune API endpoint.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub endpoint: Option<String>,
+    /// Attune API token.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub token: Option<String>,
+    /// Default repository for commands that operate on one.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub repo: Option<String>,
+
+    /// Name of the context to use when none is selected explicitly.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub current_context: Option<String>,
+    /// Named sets of settings, keyed by context name.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub contexts: BTreeMap<String, ConfigContext>,
+}
+
+/// A named set of settings in the configuration file.
+#[derive(Debug, Default, Clone, Serialize, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct ConfigContext {
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub endpoint: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub token: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub repo: Option<String>,
+}
+
+impl ConfigFile {
+    /// The default location of the configuration file:
+    /// `$XDG_CONFIG_HOME/attune/config.toml`, falling back to
+    /// `~/.config/attune/config.toml`.
+    pub fn default_path() -> Option<PathBuf> {
+        std::env::var_os("XDG_CONFIG_HOME")
+            .filter(|dir| !dir.is_empty())
+            .map(PathBuf::from)
+            .or_else(|| std::env::var_os("HOME").map(|home| PathBuf::from(home).join(".config")))
+            .map(|dir| dir.join("attune").join("config.toml"))
+    }
+
+    /// Load the configuration file at `path`. A missing file is treated as an
+    /// empty configuration.
+    pub fn load(path: &Path) -> Result<Self> {
+        match std::fs::read_to_string(path) {
+            Ok(content) => {
+                toml::from_str(&content).with_context(|| format!("parse config file {path:?}"))
+            }
+            Err(error) if error.kind() == std::io::ErrorKind::NotFound => Ok(Self::default()),
+            Err(error) => Err(error).with_context(|| format!("read config file {path:?}")),
+        }
+    }
+
+    /// Write the configuration file to `path`.
+    ///
+    /// The file contains API tokens, so it is only made readable by the
+    /// current user. Comments in an existing file are not preserved.
+    pub fn save(&self, path: &Path) -> Result<()> {
+        let content = toml::to_string_pretty(self).context("serialize config file")?;
+        if let Some(dir) = path.parent() {
+            std::fs::create_dir_all(dir)
+                .with_context(|| format!("create config directory {dir:?}"))?;
+        }
+
+        // Write to a new file that only the current user can read and move it
+        // into place, so that the tokens are never readable by anyone else,
+        // even when replacing a file with looser permissions.
+        let mut temp = path.as_os_str().to_owned();
+        temp.push(".tmp");
+        let temp = PathBuf::from(temp);
+        match std::fs::remove_file(&temp) {
+            Err(error) if error.kind() != std::io::ErrorKind::NotFound => {
+                return Err(error).with_context(|| format!("remove stale file {temp:?}"));
+            }
+            _ => {}
+        }
+        let mut file = std::fs::OpenOptions::new()
+            .write(true)
+            .create_new(true)
+            .mode(0o600)
+            .open(&temp)
+            .with_context(|| format!("create config file {temp:?}"))?;
+        file.write_all(content.as_bytes())
+            .and_then(|()| file.sync_all())
+            .with_context(|| format!("write config file {temp:?}"))?;
+        std::fs::rename(&temp, path).with_context(|| format!("write config file {path:?}"))
+    }
+
+    /// Resolve the settings for a context.
+    ///
+    /// `name` selects a context explicitly; otherwise `current_context` is
+    /// used, if set. Settings that the context doesn't set fall back to the
+    /// top-level settings. Returns the name of the selected context (if any)
+    /// along with its settings.
+    pub fn resolve(&self, name: Option<&str>) -> Result<(Option<String>, ConfigContext)> {
+        let top = ConfigContext {
+            endpoint: self.endpoint.clone(),
+            token: self.token.clone(),
+            repo: self.repo.clone(),
+        };
+        let Some(name) = name.or(self.current_context.as_deref()) else {
+            return Ok((None, top));
+        };
+        let context = self.contexts.get(name).ok_or_else(|| {
+            eyre!("context {name:?} is not defined in the config file (see `attune context list`)")
+        })?;
+        Ok((
+            Some(name.to_string()),
+            ConfigContext {
+                endpoint: context.endpoint.clone().or(top.endpoint),
+                token: context.token.clone().or(top.token),
+                repo: context.repo.clone().or(top.repo),
+            },
+        ))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn backoff_is_capped() {
+        for attempt in [0, 1, 5, 10, 64, u32::MAX] {
+            assert!(backoff(attempt) <= Duration::from_secs(30));
+        }
+    }
+
+    #[test]
+    fn repo_falls_back_to_default() {
+        let ctx = Config::new("token", "http://localhost:3000");
+        assert_eq!(ctx.repo(Some(String::from("flag"))).unwrap(), "flag");
+        assert_eq!(ctx.repo(None).unwrap_err().failure, Failure::Usage);
+
+        let ctx = ctx.with_default_repo(Some(String::from("default")));
+        assert_eq!(ctx.repo(Some(String::from("flag"))).unwrap(), "flag");
+        assert_eq!(ctx.repo(None).unwrap(), "default");
+    }
+
+    #[test_log::test(tokio::test)]
+    async fn save_is_private() {
+        use std::os::unix::fs::PermissionsExt as _;
+
+        let dir = async_tempfile::TempDir::new_in(Path::new("/tmp")).await.unwrap();
+        let path = dir.dir_path().join("attune").join("config.toml");
+        let config = ConfigFile {
+            token: Some(String::from("secret")),
+            ..Default::default()
+        };
+        config.save(&path).unwrap();
+        let mode = std::fs::metadata(&path).unwrap().permissions().mode();
+        assert_eq!(mode & 0o777, 0o600);
+
+        // Replacing a file that others can read makes it private too.
+        std::fs::set_permissions(&path, std::fs::Permissions::from_mode(0o644)).unwrap();
+        config.save(&path).unwrap();
+        let mode = std::fs::metadata(&path).unwrap().permissions().mode();
+        assert_eq!(mode & 0o777, 0o600);
+        let saved = ConfigFile::load(&path).unwrap();
+        assert_eq!(saved.token.as_deref(), Some("secret"));
     }
 }
@@ -0,0 +1,272 @@
+//! Exit codes.
+//!
+//! Each class of failure exits with its own code, so that scripts can branch
+//! on why a command failed:
+//!
+//! | Code | Meaning                                                    |
+//! | ---- | ---------------------------------------------------------- |
+//! | 0    | Success                                                    |
+//! | 1    | Any failure not listed below                               |
+//! | 2    | Invalid command line usage                                 |
+//! | 3    | Authentication or authorization failed                     |
+//! | 4    | A repository, distribution, package, or token wasn't found |
+//! | 5    | The API server rejected a request as invalid               |
+//! | 6    | A conflicting resource exists, or a concurrent change won  |
+//! | 7    | The API server couldn't be reached                         |
+//! | 8    | Signing or signature verification failed                   |
+
+use std::{fmt, process::ExitCode};
+
+use axum::http::StatusCode;
+use serde::Serialize;
+
+use attune::api::ErrorResponse;
+
+/// Why a command failed.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Failure {
+    General = 1,
+    /// Clap also exits with this code when parsing arguments fails.
+    Usage = 2,
+    Auth = 3,
+    NotFound = 4,
+    Validation = 5,
+    Conflict = 6,
+    Network = 7,
+    Signing = 8,
+}
+
+impl Failure {
+    /// Classify an error returned by the API server.
+    pub fn from_error(error: &ErrorResponse) -> Self {
+        match error.error.as_str() {
+            "PUBLIC_KEY_VERIFICATION_FAILED"
+            | "CLEARSIGN_VERIFICATION_FAILED"
+            | "DETACHED_SIGNATURE_VERIFICATION_FAILED" => return Failure::Signing,
+            _ => {}
+        }
+        match error.status {
+            StatusCode::UNAUTHORIZED | StatusCode::FORBIDDEN => Failure::Auth,
+            StatusCode::NOT_FOUND => Failure::NotFound,
+            StatusCode::BAD_REQUEST | StatusCode::UNPROCESSABLE_ENTITY => Failure::Validation,
+            StatusCode::CONFLICT | StatusCode::LOCKED => Failure::Conflict,
+            // These come from proxies and load balancers in front of the API
+            // server rather than from the server itself.
+            StatusCode::BAD_GATEWAY
+            | StatusCode::SERVICE_UNAVAILABLE
+            | StatusCode::GATEWAY_TIMEOUT => Failure::Network,
+            _ => Failure::General,
+        }
+    }
+
+    /// Classify an error from a multi-step command, such as publishing a
+    /// package.
+    pub fn from_report(report: &color_eyre::Report) -> Self {
+        if report.downcast_ref::<SigningFailed>().is_some() {
+            Failure::Signing
+        } else if let Some(error) = report.downcast_ref::<ErrorResponse>() {
+            Failure::from_error(error)
+        } else if report.downcast_ref::<reqwest::Error>().is_some() {
+            Failure::Network
+        } else {
+            Failure::General
+        }
+    }
+
+    /// The error code printed for failures that didn't come from the API
+    /// server, which has its own error codes.
+    pub fn code(self) -> &'static str {
+        match self {
+            Failure::General => "CLI_ERROR",
+            Failure::Usage => "CLI_USAGE",
+            Failure::Auth => "CLI_AUTH",
+            Failure::NotFound => "CLI_NOT_FOUND",
+            Failure::Validation => "CLI_VALIDATION",
+            Failure::Conflict => "CLI_CONFLICT",
+            Failure::Network => "CLI_NETWORK",
+            Failure::Signing => "CLI_SIGNING",
+        }
+    }
+}
+
+impl From<Failure> for ExitCode {
+    fn from(failure: Failure) -> Self {
+        ExitCode::from(failure as u8)
+    }
+}
+
+/// Error context for failures to sign an index locally, which marks them as
+/// [`Failure::Signing`].
+#[derive(Debug)]
+pub struct SigningFailed;
+
+impl fmt::Display for SigningFailed {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str("sign index")
+    }
+}
+
+/// A failed command's error message, along with why it failed.
+#[derive(Debug)]
+pub struct CommandError {
+    pub failure: Failure,
+    pub message: String,
+    /// The API server's error, if the command failed because of one.
+    pub response: Option<ErrorResponse>,
+}
+
+impl CommandError {
+    pub fn new(failure: Failure, message: impl Into<String>) -> Self {
+        Self {
+            failure,
+            message: message.into(),
+            response: None,
+        }
+    }
+
+    /// An error returned by the API server.
+    pub fn from_response(response: ErrorResponse, message: impl Into<String>) -> Self {
+        Self {
+            failure: Failure::from_error(&response),
+            message: message.into(),
+            response: Some(response),
+        }
+    }
+
+    /// An error from a multi-step command. The error may have come from the
+    /// API server.
+    pub fn from_report(report: color_eyre::Report) -> Self {
+        match report.downcast::<ErrorResponse>() {
+            Ok(response) => {
+                let message = response.message.clone();
+                Self::from_response(response, message)
+            }
+            Err(report) => Self::new(Failure::from_report(&report), format!("{report:#}")),
+        }
+    }
+
+    /// The error as printed in structured output modes.
+    pub fn output(&self, request_id: &str) -> ErrorOutput {
+        ErrorOutput {
+            code: match &self.response {
+                Some(response) => response.error.clone(),
+                None => self.failure.code().to_string(),
+            },
+            message: match &self.response {
+                Some(response) => response.message.clone(),
+                None => self.message.clone(),
+            },
+            request_id: request_id.to_string(),
+            details: ErrorDetails {
+                status: self
+                    .response
+                    .as_ref()
+                    .map(|response| response.status.as_u16()),
+                exit_code: self.failure as u8,
+            },
+        }
+    }
+}
+
+impl From<String> for CommandError {
+    fn from(message: String) -> Self {
+        Self::new(Failure::General, message)
+    }
+}
+
+/// An error, as printed to stderr in structured output modes.
+#[derive(Serialize, Debug)]
+pub struct ErrorOutput {
+    /// The API server's error code, or a `CLI_` code for errors that didn't
+    /// come from the API server.
+    pub code: String,
+    pub message: String,
+    /// The trace ID of this invocation, which the API server logs with each
+    /// request.
+    pub request_id: String,
+    pub details: ErrorDetails,
+}
+
+#[derive(Serialize, Debug)]
+pub struct ErrorDetails {
+    /// The HTTP status of the API server's response.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub status: Option<u16>,
+    /// The code that the CLI exits with.
+    pub exit_code: u8,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn classifies_api_errors() {
+        let error = |status, code: &str| ErrorResponse::new(status, code, "");
+        assert_eq!(
+            Failure::from_error(&error(StatusCode::UNAUTHORIZED, "INVALID_API_TOKEN")),
+            Failure::Auth
+        );
+        assert_eq!(
+            Failure::from_error(&error(StatusCode::NOT_FOUND, "REPOSITORY_NOT_FOUND")),
+            Failure::NotFound
+        );
+        assert_eq!(
+            Failure::from_error(&error(StatusCode::CONFLICT, "CONCURRENT_INDEX_CHANGE")),
+            Failure::Conflict
+        );
+        assert_eq!(
+            Failure::from_error(&error(StatusCode::LOCKED, "REPOSITORY_LOCKED")),
+            Failure::Conflict
+        );
+        assert_eq!(
+            Failure::from_error(&error(
+                StatusCode::BAD_REQUEST,
+                "DETACHED_SIGNATURE_VERIFICATION_FAILED"
+            )),
+            Failure::Signing
+        );
+        assert_eq!(
+            Failure::from_error(&error(StatusCode::INTERNAL_SERVER_ERROR, "INTERNAL")),
+            Failure::General
+        );
+    }
+
+    #[test]
+    fn structured_output() {
+        let error = CommandError::from_response(
+            ErrorResponse::new(
+                StatusCode::NOT_FOUND,
+                "REPO_NOT_FOUND",
+                "repository not found",
+            ),
+            "API error: repository not found",
+        );
+        assert_eq!(
+            serde_json::to_value(error.output("abc123")).unwrap(),
+            serde_json::json!({
+                "code": "REPO_NOT_FOUND",
+                "message": "repository not found",
+                "request_id": "abc123",
+                "details": { "status": 404, "exit_code": 4 },
+            })
+        );
+
+        let error = CommandError::new(Failure::Usage, "unknown column \"foo\"");
+        assert_eq!(
+            serde_json::to_value(error.output("abc123")).unwrap(),
+            serde_json::json!({
+                "code": "CLI_USAGE",
+                "message": "unknown column \"foo\"",
+                "request_id": "abc123",
+                "details": { "exit_code": 2 },
+            })
+        );
+    }
+
+    #[test]
+    fn classifies_signing_reports() {
+        let report = color_eyre::eyre::eyre!("no GPG key found").wrap_err(SigningFailed);
+        assert_eq!(Failure::from_report(&report), Failure::Signing);
+    }
+}
@@ -1,8 +1,14 @@
-use std::{iter::once, process::ExitCode, time::Duration};
+use std::{ffi::OsString, iter::once, path::PathBuf, process::ExitCode, time::Duration};
 
-use attune::{api::ErrorResponse, server::compatibility::CompatibilityResponse};
+use attune::{
+    api::ErrorResponse,
+    server::{
+        compatibility::{API_VERSION_HEADER_V0_2_0, CompatibilityResponse},
+        meta::MetaResponse,
+    },
+};
 use axum::http::StatusCode;
-use clap::{Parser, Subcommand};
+use clap::{CommandFactory as _, Parser, Subcommand};
 use color_eyre::{
     Result,
     eyre::{Context as _, OptionExt, bail},
@@ -17,6 +23,17 @@ use tracing_subscriber::{
 
 mod cmd;
 mod config;
+mod exit;
+mod output;
+mod trace;
+
+use config::SendRetrying as _;
+
+/// The version of this CLI.
+const VERSION: &str = git_version!(
+    args = ["--tags", "--always", "--dirty=-modified"],
+    fallback = "unknown"
+);
 
 /// Attune CLI
 ///
@@ -24,21 +41,104 @@ mod config;
 #[derive(Parser, Debug)]
 #[command(
     name = "attune",
-    version = git_version!(args = ["--tags", "--always", "--dirty=-modified"], fallback = "unknown"),
+    version = VERSION,
     max_term_width = 80
 )]
 struct Args {
     /// Attune API token.
-    #[arg(long, env = "ATTUNE_API_TOKEN")]
-    api_token: String,
+    ///
+    /// If not set, the `token` from the configuration file is used.
+    #[arg(long, visible_alias = "token", env = "ATTUNE_API_TOKEN")]
+    api_token: Option<String>,
 
     /// Attune API endpoint.
+    ///
+    /// If not set, the `endpoint` from the configuration file is used, or
+    /// https://api.attunehq.com if that isn't set either.
+    #[arg(long, visible_alias = "endpoint", env = "ATTUNE_API_ENDPOINT")]
+    api_endpoint: Option<String>,
+
+    /// Path to the configuration file.
+    ///
+    /// Defaults to `$XDG_CONFIG_HOME/attune/config.toml` (or
+    /// `~/.config/attune/config.toml`). A missing file is ignored.
+    #[arg(long, env = "ATTUNE_CONFIG")]
+    config: Option<PathBuf>,
+
+    /// Maximum number of times to retry an API request after a transient
+    /// failure.
+    ///
+    /// Only idempotent requests are retried.
+    #[arg(long, env = "ATTUNE_MAX_RETRIES", default_value_t = config::DEFAULT_MAX_RETRIES)]
+    max_retries: u32,
+
+    /// Seconds to wait for an API request to complete.
+    ///
+    /// Each retry gets the full timeout. Package uploads use `--upload-timeout`
+    /// instead.
+    #[arg(long, env = "ATTUNE_TIMEOUT", default_value_t = config::DEFAULT_TIMEOUT_SECS)]
+    timeout: u64,
+
+    /// Seconds to wait for a package upload to complete.
+    #[arg(
+        long,
+        env = "ATTUNE_UPLOAD_TIMEOUT",
+        default_value_t = config::DEFAULT_UPLOAD_TIMEOUT_SECS
+    )]
+    upload_timeout: u64,
+
+    /// Dump HTTP requests and responses to stderr.
+    ///
+    /// Credentials are redacted, so the output is safe to attach to bug
+    /// reports.
+    #[arg(
+        long,
+        value_enum,
+        num_args = 0..=1,
+        require_equals = true,
+        default_missing_value = "headers"
+    )]
+    trace_http: Option<trace::TraceHttp>,
+
+    /// Format of command output.
+    ///
+    /// Structured formats print the API's response types, whose schemas are
+    /// printed by `attune schema`.
+    #[arg(
+        short,
+        long,
+        global = true,
+        value_enum,
+        env = "ATTUNE_OUTPUT",
+        default_value_t = output::OutputFormat::Text
+    )]
+    output: output::OutputFormat,
+
+    /// Shorthand for `--output json`.
+    #[arg(long, global = true, conflicts_with = "output")]
+    json: bool,
+
+    /// Suppress status messages, printing only results, warnings, and errors.
+    ///
+    /// Useful in CI, where status messages clutter logs.
+    #[arg(short, long, global = true, env = "ATTUNE_QUIET")]
+    quiet: bool,
+
+    /// When to use colors in output.
     #[arg(
         long,
-        env = "ATTUNE_API_ENDPOINT",
-        default_value = "https://api.attunehq.com"
+        global = true,
+        value_enum,
+        env = "ATTUNE_COLOR",
+        default_value_t = output::ColorChoice::Auto
     )]
-    api_endpoint: String,
+    color: output::ColorChoice,
+
+    /// Context from the configuration file to use.
+    ///
+    /// Defaults to the context chosen with `attune context use`, if any.
+    #[arg(long, env = "ATTUNE_CONTEXT")]
+    context: Option<String>,
 
     /// Tool to run.
     #[command(subcommand)]
@@ -49,14 +149,53 @@ struct Args {
 enum ToolCommand {
     /// Manage APT repositories
     Apt(cmd::apt::AptCommand),
+    /// Inspect the API server
+    Api(cmd::api::ApiCommand),
+    /// Create and update repositories and distributions to match a YAML file
+    ///
+    /// Prints the changes it will make, and asks for confirmation before making
+    /// them.
+    Apply(cmd::apply::ApplyCommand),
+    /// Print repositories and distributions as YAML for `attune apply`
+    Export(cmd::export::ExportCommand),
+    /// Manage API tokens
+    Token(cmd::token::TokenCommand),
+    /// Inspect the log of changes made to repositories
+    Audit(cmd::audit::AuditCommand),
+    /// Print JSON Schemas for structured output
+    Schema(cmd::schema::SchemaCommand),
+    /// Generate man pages or a Markdown reference for every command
+    #[command(hide = true)]
+    Docs(cmd::docs::DocsCommand),
+    /// Switch between saved endpoints, tokens, and default repositories
+    Context(cmd::context::ContextCommand),
+    /// Print the CLI version, and optionally check for a newer release
+    Version(cmd::version::VersionCommand),
+    /// Check that the API server is up, and how long it takes to respond
+    Ping(cmd::ping::PingCommand),
+    /// Diagnose problems with connectivity, the API token, the clock, and GPG
+    Doctor(cmd::doctor::DoctorCommand),
+    /// Run an end-to-end self-test against the API server
+    ///
+    /// Creates a throwaway repository, publishes a package to it signed with a
+    /// throwaway key, verifies the published output, and then cleans up.
+    Selftest(cmd::selftest::SelftestCommand),
+    /// Run `attune-<name>` from PATH for unrecognized subcommands
+    #[command(external_subcommand)]
+    Plugin(Vec<OsString>),
 }
 
 #[tokio::main]
 async fn main() -> ExitCode {
+    let args = Args::parse();
+    let color = args.color.enabled();
+    colored::control::set_override(color);
+
     // Set up logging.
     tracing_subscriber::registry()
         .with(
             tracing_subscriber::fmt::layer()
+                .with_ansi(color)
                 .with_span_events(FmtSpan::NEW | FmtSpan::CLOSE)
                 .with_file(true)
                 .with_line_number(true)
@@ -68,19 +207,184 @@ async fn main() -> ExitCode {
         )
         .with(tracing_subscriber::EnvFilter::from_default_env())
         .init();
-
-    let args = Args::parse();
     debug!(?args, "parsed arguments");
 
-    let ctx = config::Config::new(args.api_token, args.api_endpoint);
+    // Subcommands that don't talk to the API server are handled before we
+    // require credentials or check API compatibility.
+    let tool = match args.tool {
+        ToolCommand::Schema(command) => return cmd::schema::run(command),
+        ToolCommand::Docs(command) => return cmd::docs::run(command, Args::command()),
+        tool => tool,
+    };
+
+    // Resolve settings. Flags take precedence over environment variables
+    // (which clap handles for us), which take precedence over the
+    // configuration file.
+    let config_path = args.config.or_else(config::ConfigFile::default_path);
+    let config_file = match config_path.as_deref().map(config::ConfigFile::load) {
+        Some(Ok(config_file)) => config_file,
+        Some(Err(error)) => {
+            eprintln!("{} {error:#}", "Error:".red().bold());
+            return ExitCode::FAILURE;
+        }
+        None => config::ConfigFile::default(),
+    };
+
+    // Managing contexts must work even if the selected context is broken.
+    let tool = match tool {
+        ToolCommand::Context(command) => {
+            return cmd::context::run(command, config_path, config_file, args.context);
+        }
+        tool => tool,
+    };
+
+    let settings = match config_file.resolve(args.context.as_deref()) {
+        Ok((_, settings)) => settings,
+        Err(error) => {
+            eprintln!("{} {error:#}", "Error:".red().bold());
+            return ExitCode::FAILURE;
+        }
+    };
+    let api_endpoint = args
+        .api_endpoint
+        .or(settings.endpoint)
+        .unwrap_or_else(|| String::from(config::DEFAULT_API_ENDPOINT));
+    let api_token = args.api_token.or(settings.token);
+
+    let tool = match tool {
+        ToolCommand::Plugin(plugin) => return cmd::plugin::run(plugin, api_endpoint, api_token),
+        tool => tool,
+    };
+
+    let ctx = config::Config::new(api_token.clone().unwrap_or_default(), api_endpoint)
+        .with_max_retries(args.max_retries)
+        .with_trace_http(args.trace_http)
+        .with_quiet(args.quiet)
+        .with_default_repo(settings.repo)
+        .with_output(match args.json {
+            true => output::OutputFormat::Json,
+            false => args.output,
+        })
+        .with_timeouts(
+            Duration::from_secs(args.timeout),
+            Duration::from_secs(args.upload_timeout),
+        );
+
+    // These don't need an API token.
+    let tool = match tool {
+        ToolCommand::Version(command) => return cmd::version::run(ctx, command).await,
+        ToolCommand::Ping(command) => return cmd::ping::run(ctx, command).await,
+        ToolCommand::Doctor(command) => {
+            return cmd::doctor::run(ctx, api_token.is_some(), command).await;
+        }
+        ToolCommand::Apt(command) if command.is_offline() => {
+            return cmd::apt::handle_apt(ctx, command).await;
+        }
+        tool => tool,
+    };
+
+    if api_token.is_none() {
+        eprintln!(
+            "{} no API token provided (set --api-token, $ATTUNE_API_TOKEN, or `token` in the config file)",
+            "Error:".red().bold()
+        );
+        return exit::Failure::Auth.into();
+    }
+    debug!(trace_id = %ctx.trace_id, "starting invocation");
 
     // Do a check for API version compatibility.
+    if let Err(code) = check_api_version(&ctx).await {
+        return code;
+    }
+
+    // Execute subcommand.
+    //
+    // TODO: We should update all the subcommands to return `Result<String,
+    // ErrorResponse>`       so that we can centralize retries, pretty printing,
+    // etc.
+    match tool {
+        ToolCommand::Apt(command) => cmd::apt::handle_apt(ctx, command).await,
+        ToolCommand::Api(command) => cmd::api::handle_api(ctx, command).await,
+        ToolCommand::Apply(command) => cmd::apply::run(ctx, command).await,
+        ToolCommand::Export(command) => cmd::export::run(ctx, command).await,
+        ToolCommand::Token(command) => cmd::token::handle_token(ctx, command).await,
+        ToolCommand::Audit(command) => cmd::audit::handle_audit(ctx, command).await,
+        ToolCommand::Selftest(command) => cmd::selftest::run(ctx, command).await,
+        ToolCommand::Schema(_)
+        | ToolCommand::Docs(_)
+        | ToolCommand::Context(_)
+        | ToolCommand::Version(_)
+        | ToolCommand::Doctor(_)
+        | ToolCommand::Ping(_)
+        | ToolCommand::Plugin(_) => {
+            unreachable!("handled before API setup")
+        }
+    }
+}
+
+/// Check that the API server serves the API version that this CLI speaks,
+/// returning the code to exit with if the CLI can't proceed.
+///
+/// Servers that predate `/api/v0/meta` are checked using the older
+/// compatibility endpoint instead.
+async fn check_api_version(ctx: &config::Config) -> Result<(), ExitCode> {
+    let res = ctx
+        .client
+        .get(ctx.endpoint.join("/api/v0/meta").unwrap())
+        .send_retrying(ctx)
+        .await
+        .map_err(|error| ctx.network_error(error))?;
+    let meta = match res.status() {
+        StatusCode::OK => res
+            .json::<MetaResponse>()
+            .await
+            .expect("Could not parse meta response"),
+        StatusCode::NOT_FOUND => return check_compatibility(ctx).await,
+        _ => {
+            let err = res
+                .json::<ErrorResponse>()
+                .await
+                .expect("Could not parse error response");
+            return Err(ctx.api_error("checking API version", err));
+        }
+    };
+    debug!(?meta, "API server metadata");
+
+    // API versions are dates, and so compare correctly as strings.
+    let cli_version = API_VERSION_HEADER_V0_2_0;
+    if cli_version < meta.minimum_api_version.as_str() {
+        return Err(ctx.error(
+            exit::Failure::General,
+            format!(
+                "CLI version is incompatible with API server, which requires API version {:?} or newer (this CLI uses {cli_version:?}). Please upgrade the attune CLI.",
+                meta.minimum_api_version
+            ),
+        ));
+    }
+    if cli_version > meta.latest_api_version.as_str() {
+        eprintln!(
+            "{} API server only supports API versions up to {:?} (this CLI uses {cli_version:?}), so some commands may fail. Please upgrade the API server, or use an older attune CLI.\n",
+            "Warning:".yellow(),
+            meta.latest_api_version
+        );
+    } else if cli_version < meta.latest_api_version.as_str() {
+        ctx.status(format!(
+            "{} (API server supports API version {:?})\n",
+            "New version of attune available".blue(),
+            meta.latest_api_version
+        ));
+    }
+    Ok(())
+}
+
+/// Check compatibility using the deprecated compatibility endpoint.
+async fn check_compatibility(ctx: &config::Config) -> Result<(), ExitCode> {
     let res = ctx
         .client
         .get(ctx.endpoint.join("/api/v0/compatibility").unwrap())
-        .send()
+        .send_retrying(ctx)
         .await
-        .expect("Could not reach API server");
+        .map_err(|error| ctx.network_error(error))?;
     match res.status() {
         StatusCode::OK => {
             let compatibility = res
@@ -90,13 +394,17 @@ async fn main() -> ExitCode {
             match compatibility {
                 CompatibilityResponse::Ok => {}
                 CompatibilityResponse::WarnUpgrade { latest } => {
-                    eprintln!("{} {}\n", "New version of attune available".blue(), latest);
+                    ctx.status(format!(
+                        "{} {}\n",
+                        "New version of attune available".blue(),
+                        latest
+                    ));
                 }
                 CompatibilityResponse::Incompatible { minimum } => {
-                    eprintln!(
-                        "Error: CLI version is incompatible with API server. Please upgrade to version {minimum:?} or newer."
-                    );
-                    return ExitCode::FAILURE;
+                    return Err(ctx.error(
+                        exit::Failure::General,
+                        format!("CLI version is incompatible with API server. Please upgrade to version {minimum:?} or newer."),
+                    ));
                 }
             }
         }
@@ -105,22 +413,10 @@ async fn main() -> ExitCode {
                 .json::<ErrorResponse>()
                 .await
                 .expect("Could not parse error response");
-            eprintln!(
-                "Error: could not check CLI version compatibility: {}",
-                err.message
-            );
-            return ExitCode::FAILURE;
+            return Err(ctx.api_error("checking CLI version compatibility", err));
         }
     }
-
-    // Execute subcommand.
-    //
-    // TODO: We should update all the subcommands to return `Result<String,
-    // ErrorResponse>`       so that we can centralize retries, pretty printing,
-    // etc.
-    match args.tool {
-        ToolCommand::Apt(command) => cmd::apt::handle_apt(ctx, command).await,
-    }
+    Ok(())
 }
 
 /// Infinitely retry an asynchronous function call.
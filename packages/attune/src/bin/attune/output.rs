@@ -0,0 +1,251 @@
+//! Output formats for command results (`--output`).
+//!
+//! Structured formats print the API's response types as-is, so their shape is
+//! stable within an API version and described by `attune schema`. Delimited
+//! formats print the rows of list commands' tables.
+
+use std::io::IsTerminal as _;
+
+use clap::{Args, ValueEnum};
+use serde::Serialize;
+use tabled::{builder::Builder, settings::Style};
+
+/// How to print command results.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, ValueEnum)]
+pub enum OutputFormat {
+    /// Human-readable messages and tables.
+    #[default]
+    Text,
+    /// Pretty-printed JSON.
+    Json,
+    /// YAML, with the same structure as the JSON output.
+    Yaml,
+    /// Comma-separated values, for list commands.
+    Csv,
+    /// Tab-separated values, for list commands.
+    Tsv,
+}
+
+impl OutputFormat {
+    /// Render a result in this format, or return `None` for text output, which
+    /// each command formats itself.
+    ///
+    /// Delimited formats only apply to tables, so other results are printed as
+    /// text.
+    pub fn render<T: Serialize>(self, value: &T) -> Option<String> {
+        match self {
+            OutputFormat::Text | OutputFormat::Csv | OutputFormat::Tsv => None,
+            OutputFormat::Json => Some(serde_json::to_string_pretty(value).unwrap()),
+            // Serialized YAML always ends with a newline, but results are
+            // printed with `println!`.
+            OutputFormat::Yaml => Some(
+                serde_yaml::to_string(value)
+                    .unwrap()
+                    .trim_end_matches('\n')
+                    .to_string(),
+            ),
+        }
+    }
+
+    /// Whether results are printed as structured data (which `render`
+    /// returns), rather than as text or tables.
+    pub fn is_structured(self) -> bool {
+        matches!(self, OutputFormat::Json | OutputFormat::Yaml)
+    }
+
+    /// Render a table, whose first row is the header.
+    pub fn table(self, rows: Vec<Vec<String>>) -> String {
+        match self {
+            OutputFormat::Csv => delimited(rows, ',', csv_field),
+            OutputFormat::Tsv => delimited(rows, '\t', tsv_field),
+            OutputFormat::Text | OutputFormat::Json | OutputFormat::Yaml => {
+                let mut table = Builder::from(rows).build();
+                table.with(Style::modern());
+                table.to_string()
+            }
+        }
+    }
+}
+
+/// When to use colors in output.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, ValueEnum)]
+pub enum ColorChoice {
+    /// Use colors when writing to a terminal, unless `NO_COLOR` is set.
+    #[default]
+    Auto,
+    Always,
+    Never,
+}
+
+impl ColorChoice {
+    /// Whether output should be colored.
+    ///
+    /// In `auto` mode, both stdout and stderr must be terminals, since colors
+    /// are a global setting and both streams carry colored messages.
+    ///
+    /// See: https://no-color.org
+    pub fn enabled(self) -> bool {
+        match self {
+            ColorChoice::Always => true,
+            ColorChoice::Never => false,
+            ColorChoice::Auto => {
+                std::env::var_os("NO_COLOR").is_none_or(|value| value.is_empty())
+                    && std::io::stdout().is_terminal()
+                    && std::io::stderr().is_terminal()
+            }
+        }
+    }
+}
+
+/// Options for choosing the columns of a list command's table.
+#[derive(Args, Debug)]
+pub struct ColumnArgs {
+    /// Columns to show, in order (e.g. `--columns package,version`).
+    ///
+    /// Column names are the table's headers, case-insensitively, with spaces
+    /// written as underscores. Also applies to CSV and TSV output.
+    #[arg(long, value_delimiter = ',')]
+    columns: Vec<String>,
+}
+
+impl ColumnArgs {
+    /// Select the chosen columns from a table whose first row is the header.
+    ///
+    /// If no columns were chosen, every column is shown except for `hidden`
+    /// ones, which list commands use for fields that are too wide to show by
+    /// default.
+    pub fn select(
+        &self,
+        rows: Vec<Vec<String>>,
+        hidden: &[&str],
+    ) -> Result<Vec<Vec<String>>, String> {
+        let header = rows
+            .first()
+            .map(|header| header.iter().map(|name| column_name(name)).collect())
+            .unwrap_or_else(Vec::new);
+        let indices = if self.columns.is_empty() {
+            (0..header.len())
+                .filter(|&i| !hidden.contains(&header[i].as_str()))
+                .collect::<Vec<_>>()
+        } else {
+            self.columns
+                .iter()
+                .map(|name| {
+                    let name = column_name(name);
+                    header
+                        .iter()
+                        .position(|column| *column == name)
+                        .ok_or_else(|| {
+                            format!(
+                                "unknown column {name:?} (available columns: {})",
+                                header.join(", ")
+                            )
+                        })
+                })
+                .collect::<Result<Vec<_>, _>>()?
+        };
+        Ok(rows
+            .into_iter()
+            .map(|row| indices.iter().map(|&i| row[i].clone()).collect())
+            .collect())
+    }
+}
+
+/// Normalize a column header (e.g. "Last used") to the name used to select it
+/// (e.g. "last_used").
+fn column_name(header: &str) -> String {
+    header.trim().to_lowercase().replace([' ', '-'], "_")
+}
+
+fn delimited(rows: Vec<Vec<String>>, separator: char, field: fn(String) -> String) -> String {
+    rows.into_iter()
+        .map(|row| {
+            row.into_iter()
+                .map(field)
+                .collect::<Vec<_>>()
+                .join(&separator.to_string())
+        })
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+/// Quote a CSV field if needed, as described in RFC 4180.
+fn csv_field(field: String) -> String {
+    if field.contains([',', '"', '\n', '\r']) {
+        format!("\"{}\"", field.replace('"', "\"\""))
+    } else {
+        field
+    }
+}
+
+/// TSV has no quoting, so characters that would break the row structure are
+/// replaced with spaces.
+fn tsv_field(field: String) -> String {
+    field.replace(['\t', '\n', '\r'], " ")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn rows() -> Vec<Vec<String>> {
+        vec![
+            vec![String::from("Name"), String::from("Description")],
+            vec![
+                String::from("stable"),
+                String::from("Say \"hi\",\tthen\nbye"),
+            ],
+        ]
+    }
+
+    #[test]
+    fn selects_columns() {
+        let rows = vec![
+            vec![
+                String::from("Name"),
+                String::from("Last used"),
+                String::from("SHA256"),
+            ],
+            vec![
+                String::from("ci"),
+                String::from("never"),
+                String::from("abc"),
+            ],
+        ];
+
+        let default = ColumnArgs { columns: vec![] };
+        assert_eq!(
+            default.select(rows.clone(), &["sha256"]).unwrap(),
+            vec![vec!["Name", "Last used"], vec!["ci", "never"]]
+        );
+
+        let chosen = ColumnArgs {
+            columns: vec![String::from("sha256"), String::from("LAST-USED")],
+        };
+        assert_eq!(
+            chosen.select(rows.clone(), &["sha256"]).unwrap(),
+            vec![vec!["SHA256", "Last used"], vec!["abc", "never"]]
+        );
+
+        let unknown = ColumnArgs {
+            columns: vec![String::from("version")],
+        };
+        assert!(unknown.select(rows, &[]).is_err());
+    }
+
+    #[test]
+    fn csv_quotes_fields() {
+        assert_eq!(
+            OutputFormat::Csv.table(rows()),
+            "Name,Description\nstable,\"Say \"\"hi\"\",\tthen\nbye\""
+        );
+    }
+
+    #[test]
+    fn tsv_replaces_separators() {
+        assert_eq!(
+            OutputFormat::Tsv.table(rows()),
+            "Name\tDescription\nstable\tSay \"hi\", then bye"
+        );
+    }
+}
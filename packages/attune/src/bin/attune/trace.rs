@@ -0,0 +1,150 @@
+//! Dumping of HTTP requests and responses for debugging (`--trace-http`).
+//!
+//! Traces are written to stderr in a format similar to `curl -v`, so that
+//! users can attach them to bug reports. Credentials are always redacted.
+
+use std::time::Duration;
+
+use clap::ValueEnum;
+use reqwest::{Request, Response, header::HeaderMap};
+use serde_json::Value;
+
+/// How much of each HTTP exchange to trace.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum TraceHttp {
+    /// Trace method, URL, status, and headers.
+    Headers,
+    /// Also trace request and response bodies.
+    Bodies,
+}
+
+const REDACTED: &str = "<redacted>";
+
+/// Headers whose values are never printed.
+const SECRET_HEADERS: [&str; 3] = ["authorization", "cookie", "set-cookie"];
+
+/// Print a request that is about to be sent.
+pub fn request(request: &Request, mode: TraceHttp) {
+    eprintln!(
+        "> {} {} {:?}",
+        request.method(),
+        request.url(),
+        request.version()
+    );
+    headers('>', request.headers());
+    eprintln!(">");
+    if mode == TraceHttp::Bodies {
+        match request.body().map(|body| body.as_bytes()) {
+            Some(Some(bytes)) => body('>', bytes),
+            Some(None) => eprintln!("> <streaming body not shown>"),
+            None => {}
+        }
+    }
+}
+
+/// Print a response, returning it so that it can still be consumed.
+///
+/// When tracing bodies, the body has to be read to be printed, and so the
+/// returned response is rebuilt from the buffered body.
+pub async fn response(
+    res: Response,
+    mode: TraceHttp,
+    elapsed: Duration,
+) -> reqwest::Result<Response> {
+    eprintln!("< {:?} {} ({elapsed:.0?})", res.version(), res.status());
+    headers('<', res.headers());
+    eprintln!("<");
+    if mode == TraceHttp::Headers {
+        return Ok(res);
+    }
+
+    let mut rebuilt = http::Response::builder()
+        .status(res.status())
+        .version(res.version());
+    if let Some(headers) = rebuilt.headers_mut() {
+        *headers = res.headers().clone();
+    }
+    let bytes = res.bytes().await?;
+    body('<', &bytes);
+    Ok(Response::from(rebuilt.body(bytes).unwrap()))
+}
+
+fn headers(prefix: char, headers: &HeaderMap) {
+    for (name, value) in headers {
+        let value = if SECRET_HEADERS.contains(&name.as_str()) {
+            REDACTED
+        } else {
+            value.to_str().unwrap_or("<non-UTF-8 value>")
+        };
+        eprintln!("{prefix} {name}: {value}");
+    }
+}
+
+fn body(prefix: char, bytes: &[u8]) {
+    if bytes.is_empty() {
+        return;
+    }
+    let text = match serde_json::from_slice::<Value>(bytes) {
+        Ok(mut json) => {
+            redact(&mut json);
+            serde_json::to_string_pretty(&json).unwrap()
+        }
+        Err(_) => match std::str::from_utf8(bytes) {
+            Ok(text) => text.to_string(),
+            Err(_) => format!("<{} bytes of binary data>", bytes.len()),
+        },
+    };
+    for line in text.lines() {
+        eprintln!("{prefix} {line}");
+    }
+}
+
+/// Redact JSON fields that look like they contain credentials, such as the
+/// plaintext tokens returned by `attune token create`.
+fn redact(json: &mut Value) {
+    match json {
+        Value::Object(fields) => {
+            for (key, value) in fields {
+                let key = key.to_ascii_lowercase();
+                if key == "token"
+                    || key.ends_with("_token")
+                    || key.contains("secret")
+                    || key.contains("password")
+                {
+                    *value = Value::String(String::from(REDACTED));
+                } else {
+                    redact(value);
+                }
+            }
+        }
+        Value::Array(values) => values.iter_mut().for_each(redact),
+        _ => {}
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use serde_json::json;
+
+    use super::*;
+
+    #[test]
+    fn redacts_nested_credentials() {
+        let mut json = json!({
+            "id": 1,
+            "token": "attune_secret",
+            "tokens": [{ "name": "ci", "api_token": "attune_secret" }],
+            "nested": { "client_secret": "hunter2", "name": "kept" },
+        });
+        redact(&mut json);
+        assert_eq!(
+            json,
+            json!({
+                "id": 1,
+                "token": REDACTED,
+                "tokens": [{ "name": "ci", "api_token": REDACTED }],
+                "nested": { "client_secret": REDACTED, "name": "kept" },
+            })
+        );
+    }
+}
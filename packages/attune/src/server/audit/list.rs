@@ -0,0 +1,84 @@
+use axum::{
+    Json,
+    extract::{Query, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use time::{OffsetDateTime, format_description::well_known::Rfc3339};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{ServerState, audit::AuditEvent},
+};
+
+#[derive(Serialize, Deserialize, Debug, Default)]
+pub struct AuditListParams {
+    /// Only list events for this repository.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub repository: Option<String>,
+    /// Only list events at or after this RFC 3339 timestamp.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub since: Option<String>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct AuditListResponse {
+    /// Matching events, oldest first.
+    pub events: Vec<AuditEvent>,
+}
+
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Query(params): Query<AuditListParams>,
+) -> Result<Json<AuditListResponse>, ErrorResponse> {
+    let since = params
+        .since
+        .as_deref()
+        .map(|since| OffsetDateTime::parse(since, &Rfc3339))
+        .transpose()
+        .map_err(|err| {
+            ErrorResponse::new(
+                StatusCode::BAD_REQUEST,
+                "INVALID_SINCE",
+                format!("`since` must be an RFC 3339 timestamp: {err}"),
+            )
+        })?;
+
+    let events = sqlx::query_as!(
+        AuditEvent,
+        r#"
+        SELECT
+            id,
+            created_at,
+            actor,
+            token_id,
+            action,
+            repository,
+            distribution,
+            component,
+            package,
+            version,
+            architecture,
+            sha256sum
+        FROM attune_audit_event
+        WHERE
+            tenant_id = $1
+            AND ($2::TEXT IS NULL OR repository = $2)
+            AND ($3::TIMESTAMPTZ IS NULL OR created_at >= $3)
+        ORDER BY created_at, id
+        "#,
+        tenant_id.0,
+        params.repository,
+        since,
+    )
+    .fetch_all(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    Ok(Json(AuditListResponse { events }))
+}
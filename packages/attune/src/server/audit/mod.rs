@@ -0,0 +1,118 @@
+//! The audit log records who changed what in each repository, and when.
+//!
+//! Events are recorded in the same transaction as the change that they
+//! describe, so that the log has an event for exactly the changes that were
+//! committed.
+
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use sqlx::{Executor, Postgres};
+use time::OffsetDateTime;
+use tracing::instrument;
+
+use crate::{
+    api::{Actor, ErrorResponse},
+    apt::Package,
+};
+
+pub mod list;
+
+/// A kind of change recorded in the audit log.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum AuditAction {
+    /// A package was published to a component.
+    PackageAdd,
+    /// A package was removed from a component.
+    PackageRemove,
+    /// A distribution's published files were resynchronized from the
+    /// database.
+    DistributionResync,
+}
+
+impl AuditAction {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            AuditAction::PackageAdd => "package.add",
+            AuditAction::PackageRemove => "package.remove",
+            AuditAction::DistributionResync => "distribution.resync",
+        }
+    }
+}
+
+/// A change to record in the audit log.
+#[derive(Debug)]
+pub struct AuditRecord<'a> {
+    pub action: AuditAction,
+    pub repository: &'a str,
+    pub distribution: &'a str,
+    pub component: Option<&'a str>,
+    pub package: Option<&'a Package>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct AuditEvent {
+    pub id: i64,
+    #[serde(with = "time::serde::rfc3339")]
+    #[schemars(with = "String")]
+    pub created_at: OffsetDateTime,
+    /// The name of the API token that made the change.
+    pub actor: String,
+    /// The ID of the API token that made the change, or `None` if the token
+    /// has since been revoked.
+    pub token_id: Option<i64>,
+    /// What happened, e.g. `package.add`.
+    pub action: String,
+    pub repository: String,
+    pub distribution: Option<String>,
+    pub component: Option<String>,
+    /// The affected package, for package changes.
+    pub package: Option<String>,
+    pub version: Option<String>,
+    pub architecture: Option<String>,
+    pub sha256sum: Option<String>,
+}
+
+/// Record a change made by `actor` in the audit log.
+#[instrument(skip(executor))]
+pub async fn record<'c, E>(
+    executor: E,
+    actor: &Actor,
+    event: &AuditRecord<'_>,
+) -> Result<(), ErrorResponse>
+where
+    E: Executor<'c, Database = Postgres>,
+{
+    sqlx::query!(
+        r#"
+        INSERT INTO attune_audit_event (
+            tenant_id,
+            token_id,
+            actor,
+            action,
+            repository,
+            distribution,
+            component,
+            package,
+            version,
+            architecture,
+            sha256sum
+        )
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+        "#,
+        actor.tenant_id.0,
+        actor.token_id,
+        actor.token_name,
+        event.action.as_str(),
+        event.repository,
+        event.distribution,
+        event.component,
+        event.package.map(|package| package.name.as_str()),
+        event.package.map(|package| package.version.as_str()),
+        event.package.map(|package| package.architecture.as_str()),
+        event.package.map(|package| package.sha256sum.as_str()),
+    )
+    .execute(executor)
+    .await
+    .map_err(ErrorResponse::from)?;
+    Ok(())
+}
@@ -1,11 +1,12 @@
 use axum::{
     Json,
     http::{HeaderMap, StatusCode},
+    response::{IntoResponse, Response},
 };
 use chrono::NaiveDate;
 use serde::{Deserialize, Serialize};
 
-use crate::api::ErrorResponse;
+use crate::{api::ErrorResponse, server::meta::deprecation_headers};
 
 #[derive(Serialize, Deserialize, Debug)]
 #[serde(tag = "status", rename_all = "snake_case")]
@@ -19,6 +20,9 @@ pub const API_VERSION_HEADER: &str = "X-API-Version";
 
 pub const API_VERSION_HEADER_V0_2_0: &str = "2025-07-24";
 
+/// When this endpoint was superseded by `/api/v0/meta` (2026-10-16T00:00:00Z).
+const DEPRECATED_AT: i64 = 1_792_108_800;
+
 // TODO: Should this be a layer instead? If we make it into a layer, we could
 // return an `X-Upgrade-To` header on "warning" and return a 500 on
 // "incompatible".
@@ -26,8 +30,20 @@ pub const API_VERSION_HEADER_V0_2_0: &str = "2025-07-24";
 // How would we handle the warning case on the client side? Is there a way to
 // add "default layers" to reqwest's response handling for a specific client? Or
 // should we just write our own client?
+//
+// Deprecated: clients should check `/api/v0/meta` instead, which doesn't need
+// the server to know about client versions.
 #[axum::debug_handler]
-pub async fn handler(headers: HeaderMap) -> Result<Json<CompatibilityResponse>, ErrorResponse> {
+pub async fn handler(headers: HeaderMap) -> Result<Response, ErrorResponse> {
+    let compatibility = check(&headers)?;
+    Ok((
+        deprecation_headers(DEPRECATED_AT, "/api/v0/meta"),
+        Json(compatibility),
+    )
+        .into_response())
+}
+
+fn check(headers: &HeaderMap) -> Result<CompatibilityResponse, ErrorResponse> {
     let version = match headers.get(API_VERSION_HEADER) {
         Some(version) => match version.to_str() {
             Ok(version) => version,
@@ -59,9 +75,9 @@ pub async fn handler(headers: HeaderMap) -> Result<Json<CompatibilityResponse>,
     };
 
     if version_date < NaiveDate::parse_from_str(API_VERSION_HEADER_V0_2_0, "%Y-%m-%d").unwrap() {
-        return Ok(Json(CompatibilityResponse::Incompatible {
+        return Ok(CompatibilityResponse::Incompatible {
             minimum: API_VERSION_HEADER_V0_2_0.to_string(),
-        }));
+        });
     }
-    Ok(Json(CompatibilityResponse::Ok))
+    Ok(CompatibilityResponse::Ok)
 }
@@ -5,7 +5,7 @@ use crate::{api::ErrorResponse, server::ServerState};
 
 #[derive(Serialize, Deserialize, Debug)]
 pub struct HealthCheckResponse {
-    ready: bool,
+    pub ready: bool,
 }
 
 #[axum::debug_handler]
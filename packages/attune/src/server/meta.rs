@@ -0,0 +1,104 @@
+use axum::{
+    Json,
+    http::{HeaderName, HeaderValue},
+};
+use serde::{Deserialize, Serialize};
+
+use crate::{api::ErrorResponse, server::compatibility::API_VERSION_HEADER_V0_2_0};
+
+/// The oldest API version that this server still serves.
+pub const MINIMUM_API_VERSION: &str = API_VERSION_HEADER_V0_2_0;
+
+/// The newest API version that this server serves.
+pub const LATEST_API_VERSION: &str = API_VERSION_HEADER_V0_2_0;
+
+/// Response header marking an endpoint as deprecated.
+///
+/// See: https://www.rfc-editor.org/rfc/rfc9745
+pub const DEPRECATION_HEADER: &str = "Deprecation";
+
+/// Response header giving the date after which a deprecated endpoint may stop
+/// responding.
+///
+/// See: https://www.rfc-editor.org/rfc/rfc8594
+pub const SUNSET_HEADER: &str = "Sunset";
+
+/// Information about the API server, used by clients to negotiate an API
+/// version.
+///
+/// API versions are dates in `YYYY-MM-DD` format, and so compare correctly as
+/// strings.
+#[derive(Serialize, Deserialize, Debug)]
+pub struct MetaResponse {
+    pub server_version: String,
+    pub minimum_api_version: String,
+    pub latest_api_version: String,
+}
+
+#[axum::debug_handler]
+pub async fn handler() -> Result<Json<MetaResponse>, ErrorResponse> {
+    Ok(Json(MetaResponse {
+        server_version: env!("CARGO_PKG_VERSION").to_string(),
+        minimum_api_version: MINIMUM_API_VERSION.to_string(),
+        latest_api_version: LATEST_API_VERSION.to_string(),
+    }))
+}
+
+/// Headers marking a deprecated endpoint, pointing clients at its successor.
+///
+/// `deprecated_at` is a Unix timestamp.
+pub fn deprecation_headers(deprecated_at: i64, successor: &str) -> [(HeaderName, HeaderValue); 2] {
+    [
+        (
+            HeaderName::from_static("deprecation"),
+            HeaderValue::from_str(&format!("@{deprecated_at}")).unwrap(),
+        ),
+        (
+            http::header::LINK,
+            HeaderValue::from_str(&format!("<{successor}>; rel=\"successor-version\"")).unwrap(),
+        ),
+    ]
+}
+
+#[cfg(test)]
+mod tests {
+    use crate::{
+        server::{
+            compatibility::{API_VERSION_HEADER, API_VERSION_HEADER_V0_2_0},
+            meta::{LATEST_API_VERSION, MINIMUM_API_VERSION, MetaResponse},
+        },
+        testing::{AttuneTestServer, AttuneTestServerConfig, MIGRATOR},
+    };
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn meta_reports_api_versions(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+
+        // The meta endpoint is unauthenticated, so that clients can check
+        // compatibility before they have credentials.
+        let res = server.http.get("/api/v0/meta").await;
+        res.assert_status_ok();
+        let meta = res.json::<MetaResponse>();
+        assert_eq!(meta.minimum_api_version, MINIMUM_API_VERSION);
+        assert_eq!(meta.latest_api_version, LATEST_API_VERSION);
+        assert!(meta.minimum_api_version <= meta.latest_api_version);
+
+        // The old compatibility endpoint points clients at its successor.
+        let res = server
+            .http
+            .get("/api/v0/compatibility")
+            .add_header(API_VERSION_HEADER, API_VERSION_HEADER_V0_2_0)
+            .await;
+        res.assert_status_ok();
+        assert!(res.headers().contains_key("deprecation"));
+        assert_eq!(
+            res.headers()["link"],
+            "</api/v0/meta>; rel=\"successor-version\""
+        );
+    }
+}
@@ -1,7 +1,11 @@
+pub mod audit;
 pub mod compatibility;
 pub mod health;
+pub mod meta;
 pub mod pkg;
 pub mod repo;
+pub mod schema;
+pub mod token;
 
 use std::{any::Any, time::Duration};
 
@@ -13,7 +17,7 @@ use axum::{
     handler::Handler,
     middleware::Next,
     response::{IntoResponse, Response},
-    routing::{get, put},
+    routing::{delete, get, post, put},
 };
 use http::StatusCode;
 use sha2::{Digest as _, Sha256};
@@ -23,7 +27,10 @@ use tower_http::{catch_panic::CatchPanicLayer, trace::TraceLayer};
 use tracing::warn;
 use uuid::{ContextV7, Timestamp, Uuid};
 
-use crate::{api::ErrorResponse, server::compatibility::API_VERSION_HEADER};
+use crate::{
+    api::ErrorResponse,
+    server::{compatibility::API_VERSION_HEADER, pkg::fetch::FetchConfig},
+};
 
 #[derive(Clone, Debug, FromRef)]
 pub struct ServerState {
@@ -31,6 +38,8 @@ pub struct ServerState {
     pub s3: aws_sdk_s3::Client,
 
     pub s3_bucket_name: String,
+
+    pub fetch: FetchConfig,
 }
 
 pub async fn new(state: ServerState, default_api_token: Option<String>) -> Router {
@@ -55,7 +64,11 @@ pub async fn new(state: ServerState, default_api_token: Option<String>) -> Route
                 .begin()
                 .await
                 .expect("could not start default user initialization");
-            sqlx::query!("DELETE FROM attune_tenant_api_token WHERE tenant_id = 1;")
+            // Only the environment-provided token is replaced, so that tokens
+            // created through the API survive restarts.
+            sqlx::query!(
+                "DELETE FROM attune_tenant_api_token WHERE tenant_id = 1 AND name = 'LOCAL_TENANT_API_TOKEN';"
+            )
                 .execute(&mut *tx)
                 .await
                 .expect("could not remove existing single-tenant API token");
@@ -94,8 +107,12 @@ pub async fn new(state: ServerState, default_api_token: Option<String>) -> Route
 
     // Configure routes.
     let api = Router::new()
+        .route("/audit", get(audit::list::handler))
         .route("/compatibility", get(compatibility::handler))
         .route("/health", get(health::handler))
+        .route("/meta", get(meta::handler))
+        .route("/releases/expiring", get(repo::expiring::handler))
+        .route("/schema", get(schema::handler))
         .route(
             "/repositories",
             get(repo::list::handler).post(repo::create::handler),
@@ -106,6 +123,14 @@ pub async fn new(state: ServerState, default_api_token: Option<String>) -> Route
                 .put(repo::edit::handler)
                 .delete(repo::delete::handler),
         )
+        .route(
+            "/repositories/{repository_name}/lock",
+            put(repo::lock::create::handler).delete(repo::lock::delete::handler),
+        )
+        .route(
+            "/repositories/{repository_name}/stats",
+            get(repo::stats::handler),
+        )
         .route(
             "/repositories/{repository_name}/index",
             get(repo::index::generate::handler).post(repo::index::sign::handler),
@@ -122,11 +147,65 @@ pub async fn new(state: ServerState, default_api_token: Option<String>) -> Route
             "/repositories/{repository_name}/distributions/{distribution_name}/sync",
             get(repo::sync::check::handler).post(repo::sync::resync::handler),
         )
+        .route(
+            "/repositories/{repository_name}/distributions/{distribution_name}/dep11",
+            get(repo::dist::dep11::list::handler),
+        )
+        .route(
+            "/repositories/{repository_name}/distributions/{distribution_name}/dep11/{component}/{file_name}",
+            put(repo::dist::dep11::upload::handler.layer(DefaultBodyLimit::disable()))
+                .delete(repo::dist::dep11::delete::handler),
+        )
+        .route(
+            "/repositories/{repository_name}/snapshots",
+            get(repo::snapshot::list::handler).post(repo::snapshot::create::handler),
+        )
+        .route(
+            "/repositories/{repository_name}/snapshots/{snapshot_name}",
+            get(repo::snapshot::info::handler),
+        )
         .route(
             "/packages",
-            get(pkg::list::handler).post(pkg::upload::handler.layer(DefaultBodyLimit::disable())),
+            get(pkg::list::handler)
+                .head(pkg::exists::handler)
+                .post(pkg::upload::handler.layer(DefaultBodyLimit::disable())),
+        )
+        .route("/packages/fetch", post(pkg::fetch::handler))
+        .route("/packages/owns", get(pkg::owns::handler))
+        .route("/packages/search", get(pkg::search::handler))
+        .route("/packages/uploads", post(pkg::resumable::create::handler))
+        .route("/packages/versions", get(pkg::versions::handler))
+        .route(
+            "/packages/uploads/{upload_id}",
+            get(pkg::resumable::info::handler),
+        )
+        .route(
+            "/packages/uploads/{upload_id}/parts/{part_number}",
+            put(pkg::resumable::part::handler.layer(DefaultBodyLimit::disable())),
         )
-        .route("/packages/{package_sha256sum}", get(pkg::info::handler));
+        .route(
+            "/packages/uploads/{upload_id}/complete",
+            post(pkg::resumable::complete::handler),
+        )
+        .route("/packages/{package_sha256sum}", get(pkg::info::handler))
+        .route(
+            "/packages/{package_sha256sum}/download",
+            get(pkg::download::handler),
+        )
+        .route(
+            "/packages/{package_sha256sum}/upstream-signature",
+            post(pkg::upstream::handler),
+        )
+        .route(
+            "/packages/{package_sha256sum}/translations/{language}",
+            put(pkg::translation::set::handler).delete(pkg::translation::delete::handler),
+        )
+        .route(
+            "/tokens",
+            get(token::list::handler).post(token::create::handler),
+        )
+        .route("/tokens/{token_id}", delete(token::revoke::handler))
+        .route("/tokens/{token_id}/rotate", post(token::rotate::handler));
 
     // The intention of error handling middleware here is that:
     // - `handle_non_success` handles responses from handlers and axum itself,
@@ -143,7 +222,7 @@ pub async fn new(state: ServerState, default_api_token: Option<String>) -> Route
                     TraceLayer::new_for_http().make_span_with(|req: &http::Request<Body>| {
                         let request_id = Uuid::new_v7(Timestamp::now(ContextV7::new()));
                         let headers = req.headers();
-                        match headers.get("X-Invocation-ID") {
+                        let span = match headers.get("X-Invocation-ID") {
                             Some(invocation_id) => {
                                 let api_version = headers.get(API_VERSION_HEADER).unwrap();
                                 tracing::span!(
@@ -154,6 +233,7 @@ pub async fn new(state: ServerState, default_api_token: Option<String>) -> Route
                                     invocation_id = %invocation_id.to_str().unwrap(),
                                     request_id = %request_id,
                                     api_version = %api_version.to_str().unwrap(),
+                                    trace_id = tracing::field::Empty,
                                 )
                             }
                             None => {
@@ -163,9 +243,14 @@ pub async fn new(state: ServerState, default_api_token: Option<String>) -> Route
                                     method = %req.method(),
                                     uri = %req.uri(),
                                     request_id = %request_id,
+                                    trace_id = tracing::field::Empty,
                                 )
                             }
+                        };
+                        if let Some(trace_id) = traceparent_trace_id(headers) {
+                            span.record("trace_id", trace_id);
                         }
+                        span
                     }),
                 )
                 .layer(CatchPanicLayer::custom(handle_panic))
@@ -175,6 +260,27 @@ pub async fn new(state: ServerState, default_api_token: Option<String>) -> Route
         .with_state(state)
 }
 
+/// Extract the trace ID from a W3C Trace Context `traceparent` header, so that
+/// server logs can be correlated with the client operation that caused them.
+///
+/// See: https://www.w3.org/TR/trace-context/#traceparent-header
+fn traceparent_trace_id(headers: &http::HeaderMap) -> Option<&str> {
+    let traceparent = headers.get("traceparent")?.to_str().ok()?;
+    let mut fields = traceparent.split('-');
+    let (_version, trace_id, parent_id, _flags) = (
+        fields.next()?,
+        fields.next()?,
+        fields.next()?,
+        fields.next()?,
+    );
+    let valid = |id: &str, len: usize| {
+        id.len() == len
+            && id.bytes().all(|b| b.is_ascii_hexdigit())
+            && id.bytes().any(|b| b != b'0')
+    };
+    (valid(trace_id, 32) && valid(parent_id, 16)).then_some(trace_id)
+}
+
 async fn handle_non_success(request: Request, next: Next) -> Response {
     let uri = request.uri().to_string();
     let response = next.run(request).await;
@@ -258,3 +364,32 @@ async fn handle_middleware_error(err: BoxError) -> ErrorResponse {
         format!("internal server error: {err}"),
     )
 }
+
+#[cfg(test)]
+mod tests {
+    use http::{HeaderMap, HeaderValue};
+
+    use super::*;
+
+    #[test]
+    fn parses_traceparent() {
+        let mut headers = HeaderMap::new();
+        assert_eq!(traceparent_trace_id(&headers), None);
+
+        headers.insert(
+            "traceparent",
+            HeaderValue::from_static("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+        );
+        assert_eq!(
+            traceparent_trace_id(&headers),
+            Some("4bf92f3577b34da6a3ce929d0e0e4736")
+        );
+
+        // All-zero IDs are invalid.
+        headers.insert(
+            "traceparent",
+            HeaderValue::from_static("00-00000000000000000000000000000000-00f067aa0ba902b7-01"),
+        );
+        assert_eq!(traceparent_trace_id(&headers), None);
+    }
+}
@@ -0,0 +1,151 @@
+use aws_sdk_s3::error::DisplayErrorContext;
+use axum::{
+    extract::{Path, State},
+    http::{HeaderValue, StatusCode, header},
+    response::{IntoResponse, Response},
+};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::ServerState,
+};
+
+/// The media type of Debian binary packages.
+pub const DEB_CONTENT_TYPE: &str = "application/vnd.debian.binary-package";
+
+/// Download the contents of a stored package.
+///
+/// Packages can be downloaded whether or not they're published. The response
+/// body is the package file itself, named `<package>_<version>_<arch>.deb` in
+/// its `Content-Disposition` header.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path(sha256sum): Path<String>,
+) -> Result<Response, ErrorResponse> {
+    let pkg = sqlx::query!(
+        r#"
+        SELECT
+            package,
+            version,
+            architecture::TEXT AS "architecture!: String",
+            s3_bucket
+        FROM debian_repository_package
+        WHERE tenant_id = $1 AND sha256sum = $2
+        LIMIT 1
+        "#,
+        tenant_id.0,
+        sha256sum,
+    )
+    .fetch_optional(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+    let Some(pkg) = pkg else {
+        return Err(ErrorResponse::new(
+            StatusCode::NOT_FOUND,
+            "PACKAGE_NOT_FOUND".to_string(),
+            "package not found".to_string(),
+        ));
+    };
+
+    let object = state
+        .s3
+        .get_object()
+        .bucket(&pkg.s3_bucket)
+        .key(format!("packages/{sha256sum}"))
+        .send()
+        .await
+        .map_err(|error| {
+            ErrorResponse::new(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "PACKAGE_READ_FAILED".to_string(),
+                format!("could not read package: {}", DisplayErrorContext(&error)),
+            )
+        })?;
+    let body = object.body.collect().await.map_err(|error| {
+        ErrorResponse::new(
+            StatusCode::INTERNAL_SERVER_ERROR,
+            "PACKAGE_READ_FAILED".to_string(),
+            format!("could not read package: {error}"),
+        )
+    })?;
+
+    // Package names, versions, and architectures can't contain quotes, so the
+    // filename doesn't need escaping.
+    let filename = format!("{}_{}_{}.deb", pkg.package, pkg.version, pkg.architecture);
+    let disposition = HeaderValue::from_str(&format!("attachment; filename=\"{filename}\""))
+        .map_err(|error| {
+            ErrorResponse::new(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "PACKAGE_READ_FAILED".to_string(),
+                format!("invalid package filename {filename:?}: {error}"),
+            )
+        })?;
+    Ok((
+        [
+            (header::CONTENT_TYPE, HeaderValue::from_static(DEB_CONTENT_TYPE)),
+            (header::CONTENT_DISPOSITION, disposition),
+        ],
+        body.into_bytes(),
+    )
+        .into_response())
+}
+
+#[cfg(test)]
+mod tests {
+    use axum_test::multipart::{MultipartForm, Part};
+
+    use crate::{
+        server::pkg::upload::PackageUploadResponse,
+        testing::{AttuneTestServer, AttuneTestServerConfig, MIGRATOR, fixtures},
+    };
+
+    use super::*;
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn download_uploaded_package(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_tenant_id, api_token) = server.create_test_tenant("download_uploaded_package").await;
+
+        let res = server
+            .http
+            .get(&format!("/api/v0/packages/{}/download", "0".repeat(64)))
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .expect_failure()
+            .await;
+        res.assert_status(StatusCode::NOT_FOUND);
+
+        let upload = MultipartForm::new()
+            .add_part("file", Part::bytes(fixtures::TEST_PACKAGE_AMD64.to_vec()));
+        let uploaded = server
+            .http
+            .post("/api/v0/packages")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .multipart(upload)
+            .await
+            .json::<PackageUploadResponse>();
+
+        let res = server
+            .http
+            .get(&format!("/api/v0/packages/{}/download", uploaded.sha256sum))
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .await;
+        res.assert_status_ok();
+        assert_eq!(res.header(header::CONTENT_TYPE), DEB_CONTENT_TYPE);
+        assert!(
+            res.header(header::CONTENT_DISPOSITION)
+                .to_str()
+                .unwrap()
+                .ends_with("_amd64.deb\"")
+        );
+        assert_eq!(res.as_bytes().as_ref(), fixtures::TEST_PACKAGE_AMD64);
+    }
+}
@@ -0,0 +1,117 @@
+use axum::{
+    extract::{Query, State},
+    http::StatusCode,
+};
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::ServerState,
+};
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct PackageExistsParams {
+    /// The SHA256 sum of the package file.
+    pub sha256: String,
+}
+
+/// Check whether a package has already been uploaded, without transferring
+/// anything but the status code.
+///
+/// Clients use this to skip uploading packages that the tenant has already
+/// uploaded, for example when publishing the same package to several
+/// repositories.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Query(params): Query<PackageExistsParams>,
+) -> Result<StatusCode, ErrorResponse> {
+    let exists = sqlx::query_scalar!(
+        r#"
+        SELECT EXISTS (
+            SELECT 1
+            FROM debian_repository_package
+            WHERE tenant_id = $1 AND sha256sum = $2
+        ) AS "exists!"
+        "#,
+        tenant_id.0,
+        params.sha256.to_ascii_lowercase(),
+    )
+    .fetch_one(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+    if exists {
+        Ok(StatusCode::OK)
+    } else {
+        Err(ErrorResponse::new(
+            StatusCode::NOT_FOUND,
+            "PACKAGE_NOT_FOUND".to_string(),
+            "package not found".to_string(),
+        ))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use axum_test::multipart::{MultipartForm, Part};
+    use digest::Digest as _;
+    use sha2::Sha256;
+
+    use crate::testing::{AttuneTestServer, AttuneTestServerConfig, MIGRATOR, fixtures};
+
+    use super::*;
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn exists_after_upload(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_tenant_id, api_token) = server.create_test_tenant("exists_after_upload").await;
+        let (_other_tenant_id, other_api_token) =
+            server.create_test_tenant("exists_after_upload_other").await;
+        let sha256sum = hex::encode(Sha256::digest(fixtures::TEST_PACKAGE_AMD64));
+
+        server
+            .http
+            .method(http::Method::HEAD, "/api/v0/packages")
+            .add_query_param("sha256", &sha256sum)
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .expect_failure()
+            .await
+            .assert_status(StatusCode::NOT_FOUND);
+
+        let upload = MultipartForm::new()
+            .add_part("file", Part::bytes(fixtures::TEST_PACKAGE_AMD64.to_vec()));
+        server
+            .http
+            .post("/api/v0/packages")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .multipart(upload)
+            .await
+            .assert_status_ok();
+        let res = server
+            .http
+            .method(http::Method::HEAD, "/api/v0/packages")
+            .add_query_param("sha256", sha256sum.to_ascii_uppercase())
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .await;
+        res.assert_status_ok();
+        assert!(res.as_bytes().is_empty());
+
+        // Other tenants can't tell which packages have been uploaded.
+        server
+            .http
+            .method(http::Method::HEAD, "/api/v0/packages")
+            .add_query_param("sha256", &sha256sum)
+            .add_header("authorization", format!("Bearer {other_api_token}"))
+            .expect_failure()
+            .await
+            .assert_status(StatusCode::NOT_FOUND);
+    }
+}
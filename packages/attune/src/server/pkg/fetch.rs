@@ -0,0 +1,569 @@
+use std::{
+    net::{IpAddr, SocketAddr},
+    sync::Arc,
+    time::Duration,
+};
+
+use aws_sdk_s3::error::DisplayErrorContext;
+use axum::{
+    BoxError, Json,
+    extract::{Query, State},
+    http::StatusCode,
+};
+use bytes::{Bytes, BytesMut};
+use digest::Digest as _;
+use percent_encoding::percent_decode_str;
+use reqwest::{
+    dns::{Addrs, Name, Resolve, Resolving},
+    redirect::Policy,
+};
+use serde::{Deserialize, Serialize};
+use sha2::Sha256;
+use tracing::{instrument, warn};
+
+use crate::{
+    api::{Actor, ErrorResponse},
+    server::{
+        ServerState,
+        pkg::upload::{
+            MAX_PACKAGE_SIZE, PackageUploadParams, PackageUploadResponse, store_package,
+        },
+    },
+};
+
+/// How long to wait for a package to be downloaded.
+const FETCH_TIMEOUT: Duration = Duration::from_secs(10 * 60);
+
+/// How many redirects to follow when downloading a package.
+const MAX_REDIRECTS: usize = 10;
+
+/// Where the server may fetch packages from.
+#[derive(Clone, Debug, Default)]
+pub struct FetchConfig {
+    /// Whether packages can be downloaded from loopback, private, and other
+    /// non-public addresses. This lets any tenant make the server send
+    /// requests to internal services, so it's off by default.
+    pub allow_private_networks: bool,
+    /// The S3 buckets that packages can be read from. Packages are read with
+    /// the server's own credentials, so any other bucket is refused, even if
+    /// the server can read it.
+    pub allowed_buckets: Vec<String>,
+}
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct PackageFetchRequest {
+    /// The URL to download the package from. Besides HTTP and HTTPS URLs,
+    /// `s3://<bucket>/<key>` URIs are read from object storage using the
+    /// server's own credentials.
+    pub url: String,
+    /// The expected SHA256 sum of the package. The package is only stored if
+    /// the download matches it.
+    pub sha256sum: String,
+}
+
+/// Download a package from a URL and store it, as if it had been uploaded.
+///
+/// This saves a round trip through the client for packages that are already
+/// hosted elsewhere, like a CI system's build artifacts.
+///
+/// Packages can only be downloaded from public addresses, unless the server
+/// allows private networks.
+///
+/// Object storage URIs are read through the server's S3 client, and only from
+/// the buckets that the server allows. Objects in the server's own bucket
+/// can't be read this way, since it holds every tenant's packages.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    actor: Actor,
+    Query(params): Query<PackageUploadParams>,
+    Json(req): Json<PackageFetchRequest>,
+) -> Result<Json<PackageUploadResponse>, ErrorResponse> {
+    let invalid = |reason: &str| {
+        ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_PACKAGE_URL",
+            format!("invalid package URL {:?}: {reason}", req.url),
+        )
+    };
+    let url = reqwest::Url::parse(&req.url).map_err(|error| invalid(&error.to_string()))?;
+    let value = match url.scheme() {
+        "http" | "https" => {
+            if !state.fetch.allow_private_networks && is_private_host(&url) {
+                return Err(invalid("packages can't be downloaded from private addresses"));
+            }
+            fetch(&state.fetch, &url).await?
+        }
+        "s3" => {
+            let bucket = url
+                .host_str()
+                .filter(|bucket| !bucket.is_empty())
+                .ok_or_else(|| invalid("expected a bucket name"))?;
+            if bucket == state.s3_bucket_name {
+                return Err(invalid("packages can't be read from Attune's own bucket"));
+            }
+            if !state.fetch.allowed_buckets.iter().any(|allowed| allowed == bucket) {
+                return Err(invalid("the server doesn't allow reading from this bucket"));
+            }
+            let key = percent_decode_str(url.path().trim_start_matches('/'))
+                .decode_utf8()
+                .map_err(|error| invalid(&error.to_string()))?;
+            if key.is_empty() {
+                return Err(invalid("expected an object key"));
+            }
+            fetch_object(&state.s3, bucket, &key).await?
+        }
+        // The server's object storage client only speaks S3, so a `gs://` URI
+        // would be read from the S3 bucket with the same name.
+        "gs" => return Err(invalid("GCS URIs aren't supported")),
+        _ => return Err(invalid("expected an HTTP, HTTPS, or S3 URL")),
+    };
+    let sha256sum = hex::encode(Sha256::digest(&value));
+    if !sha256sum.eq_ignore_ascii_case(&req.sha256sum) {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "PACKAGE_CHECKSUM_MISMATCH",
+            format!(
+                "downloaded package has SHA256 sum {sha256sum}, but {} was expected",
+                req.sha256sum
+            ),
+        ));
+    }
+
+    // Packages at URLs ending in `.udeb` are stored as udebs.
+    let params = PackageUploadParams {
+        udeb: params.udeb || url.path().ends_with(".udeb"),
+        ..params
+    };
+    store_package(&state, &actor, value, &params)
+        .await
+        .map(Json)
+}
+
+/// Download a package over HTTP or HTTPS.
+///
+/// Unless private networks are allowed, the package's host and the host of
+/// every redirect must only have public addresses. Host names are checked as
+/// they're resolved for each connection, so they can't be rebound to a private
+/// address after they're checked. Errors don't say why the download failed,
+/// so that they can't be used to probe which hosts and ports are reachable.
+#[instrument(skip(config))]
+async fn fetch(config: &FetchConfig, url: &reqwest::Url) -> Result<Bytes, ErrorResponse> {
+    let failed = |error: reqwest::Error| {
+        warn!(%error, "could not download package");
+        ErrorResponse::new(
+            StatusCode::BAD_GATEWAY,
+            "PACKAGE_FETCH_FAILED",
+            format!("could not download package from {url}"),
+        )
+    };
+    let too_large = || {
+        ErrorResponse::new(
+            StatusCode::PAYLOAD_TOO_LARGE,
+            "PACKAGE_TOO_LARGE",
+            format!("package at {url} is larger than the maximum of {MAX_PACKAGE_SIZE} bytes"),
+        )
+    };
+
+    let allow_private_networks = config.allow_private_networks;
+    let redirects = Policy::custom(move |attempt| {
+        if attempt.previous().len() >= MAX_REDIRECTS {
+            attempt.error("too many redirects")
+        } else if !allow_private_networks && is_private_host(attempt.url()) {
+            attempt.error("redirected to a private address")
+        } else {
+            attempt.follow()
+        }
+    });
+    let mut client = reqwest::Client::builder()
+        .timeout(FETCH_TIMEOUT)
+        .redirect(redirects)
+        .no_proxy();
+    if !allow_private_networks {
+        client = client.dns_resolver(Arc::new(PublicResolver));
+    }
+    let client = client.build().expect("could not build HTTP client");
+
+    let mut res = client.get(url.clone()).send().await.map_err(failed)?;
+    let status = res.status();
+    if !status.is_success() {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_GATEWAY,
+            "PACKAGE_FETCH_FAILED",
+            format!("could not download package from {url}: server responded with {status}"),
+        ));
+    }
+    if res
+        .content_length()
+        .is_some_and(|size| size > MAX_PACKAGE_SIZE as u64)
+    {
+        return Err(too_large());
+    }
+    let mut body = BytesMut::new();
+    while let Some(chunk) = res.chunk().await.map_err(failed)? {
+        if (body.len() + chunk.len()) as u64 > MAX_PACKAGE_SIZE as u64 {
+            return Err(too_large());
+        }
+        body.extend_from_slice(&chunk);
+    }
+    Ok(body.freeze())
+}
+
+/// Whether a URL's host is an IP address that isn't public. Host names are
+/// checked by [`PublicResolver`] instead.
+fn is_private_host(url: &reqwest::Url) -> bool {
+    let host = url.host_str().unwrap_or_default();
+    host.trim_start_matches('[')
+        .trim_end_matches(']')
+        .parse::<IpAddr>()
+        .is_ok_and(|ip| !is_public(ip))
+}
+
+/// Whether an address is publicly routable. Loopback, private, link-local
+/// (which includes cloud metadata services like 169.254.169.254), shared,
+/// and reserved addresses aren't.
+fn is_public(ip: IpAddr) -> bool {
+    match ip {
+        IpAddr::V4(ip) => {
+            let [a, b, c, _] = ip.octets();
+            !(ip.is_unspecified()
+                || ip.is_loopback()
+                || ip.is_private()
+                || ip.is_link_local()
+                || ip.is_broadcast()
+                || ip.is_documentation()
+                || ip.is_multicast()
+                // "This network", shared address space (RFC 6598), IETF
+                // protocol assignments, benchmarking, and reserved ranges.
+                || a == 0
+                || (a == 100 && (64..128).contains(&b))
+                || (a == 192 && b == 0 && c == 0)
+                || (a == 198 && (b == 18 || b == 19))
+                || a >= 240)
+        }
+        IpAddr::V6(ip) => {
+            if let Some(ip) = ip.to_ipv4_mapped() {
+                return is_public(ip.into());
+            }
+            let segments = ip.segments();
+            !(ip.is_multicast()
+                // Unspecified, loopback, and IPv4-compatible addresses.
+                || segments[..6] == [0; 6]
+                // Unique local and link-local addresses.
+                || (segments[0] & 0xfe00) == 0xfc00
+                || (segments[0] & 0xffc0) == 0xfe80
+                // Documentation addresses, and NAT64 and 6to4 addresses,
+                // which can embed private IPv4 addresses.
+                || (segments[0] == 0x2001 && segments[1] == 0x0db8)
+                || (segments[0] == 0x0064 && segments[1] == 0xff9b)
+                || segments[0] == 0x2002)
+        }
+    }
+}
+
+/// Resolves host names to their public addresses only, so that packages can't
+/// be downloaded from hosts on private networks.
+struct PublicResolver;
+
+impl Resolve for PublicResolver {
+    fn resolve(&self, name: Name) -> Resolving {
+        Box::pin(resolve_public(name))
+    }
+}
+
+async fn resolve_public(name: Name) -> Result<Addrs, BoxError> {
+    let addrs = tokio::net::lookup_host((name.as_str(), 0))
+        .await?
+        .filter(|addr| is_public(addr.ip()))
+        .collect::<Vec<SocketAddr>>();
+    if addrs.is_empty() {
+        return Err(format!("{} has no public addresses", name.as_str()).into());
+    }
+    Ok(Box::new(addrs.into_iter()))
+}
+
+#[instrument(skip(s3))]
+async fn fetch_object(
+    s3: &aws_sdk_s3::Client,
+    bucket: &str,
+    key: &str,
+) -> Result<Bytes, ErrorResponse> {
+    let failed = |error: String| {
+        ErrorResponse::new(
+            StatusCode::BAD_GATEWAY,
+            "PACKAGE_FETCH_FAILED",
+            format!("could not read package from bucket {bucket:?} at {key:?}: {error}"),
+        )
+    };
+    let object = s3
+        .get_object()
+        .bucket(bucket)
+        .key(key)
+        .send()
+        .await
+        .map_err(|error| failed(DisplayErrorContext(&error).to_string()))?;
+    if object
+        .content_length()
+        .is_some_and(|size| size > MAX_PACKAGE_SIZE)
+    {
+        return Err(ErrorResponse::new(
+            StatusCode::PAYLOAD_TOO_LARGE,
+            "PACKAGE_TOO_LARGE",
+            format!("package is larger than the maximum of {MAX_PACKAGE_SIZE} bytes"),
+        ));
+    }
+    let body = object
+        .body
+        .collect()
+        .await
+        .map_err(|error| failed(error.to_string()))?;
+    Ok(body.into_bytes())
+}
+
+#[cfg(test)]
+mod tests {
+    use axum::{Router, routing::get};
+
+    use crate::{
+        server::pkg::info::PackageInfoResponse,
+        testing::{AttuneTestServer, AttuneTestServerConfig, MIGRATOR, fixtures},
+    };
+
+    use super::*;
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn fetch_checks_sha256sum(pool: sqlx::PgPool) {
+        // The package is served locally, which is a private address.
+        let server = AttuneTestServer::with_fetch_config(
+            AttuneTestServerConfig {
+                db: pool,
+                s3_bucket_name: None,
+                http_api_token: None,
+            },
+            FetchConfig {
+                allow_private_networks: true,
+            },
+        )
+        .await;
+        let (_tenant_id, api_token) = server.create_test_tenant("fetch_checks_sha256sum").await;
+
+        // Serve the package from somewhere else.
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let url = format!("http://{}/hello.deb", listener.local_addr().unwrap());
+        let artifacts = Router::new().route(
+            "/hello.deb",
+            get(|| async { Bytes::from_static(fixtures::TEST_PACKAGE_AMD64) }),
+        );
+        tokio::spawn(async move { axum::serve(listener, artifacts).await });
+        let sha256sum = hex::encode(Sha256::digest(fixtures::TEST_PACKAGE_AMD64));
+
+        // A download that doesn't match the expected SHA256 sum isn't stored.
+        let res = server
+            .http
+            .post("/api/v0/packages/fetch")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .json(&PackageFetchRequest {
+                url: url.clone(),
+                sha256sum: hex::encode(Sha256::digest(fixtures::TEST_PACKAGE_ARM64)),
+            })
+            .expect_failure()
+            .await;
+        res.assert_status(StatusCode::BAD_REQUEST);
+        assert_eq!(res.json::<ErrorResponse>().error, "PACKAGE_CHECKSUM_MISMATCH");
+        server
+            .http
+            .get(&format!("/api/v0/packages/{sha256sum}"))
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .expect_failure()
+            .await
+            .assert_status(StatusCode::NOT_FOUND);
+
+        let fetched = server
+            .http
+            .post("/api/v0/packages/fetch")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .json(&PackageFetchRequest {
+                url,
+                sha256sum: sha256sum.clone(),
+            })
+            .await
+            .json::<PackageUploadResponse>();
+        assert_eq!(fetched.sha256sum, sha256sum);
+        server
+            .http
+            .get(&format!("/api/v0/packages/{sha256sum}"))
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .await
+            .json::<PackageInfoResponse>();
+
+        // Only HTTP and HTTPS URLs can be fetched.
+        let res = server
+            .http
+            .post("/api/v0/packages/fetch")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .json(&PackageFetchRequest {
+                url: String::from("file:///etc/passwd"),
+                sha256sum,
+            })
+            .expect_failure()
+            .await;
+        assert_eq!(res.json::<ErrorResponse>().error, "INVALID_PACKAGE_URL");
+    }
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn fetch_refuses_private_addresses(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_tenant_id, api_token) =
+            server.create_test_tenant("fetch_refuses_private_addresses").await;
+
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let port = listener.local_addr().unwrap().port();
+        let artifacts = Router::new().route(
+            "/hello.deb",
+            get(|| async { Bytes::from_static(fixtures::TEST_PACKAGE_AMD64) }),
+        );
+        tokio::spawn(async move { axum::serve(listener, artifacts).await });
+        let sha256sum = hex::encode(Sha256::digest(fixtures::TEST_PACKAGE_AMD64));
+
+        // Private addresses are refused before anything is sent.
+        for url in [
+            format!("http://127.0.0.1:{port}/hello.deb"),
+            String::from("http://169.254.169.254/latest/meta-data/"),
+            String::from("http://[::1]/hello.deb"),
+        ] {
+            let res = server
+                .http
+                .post("/api/v0/packages/fetch")
+                .add_header("authorization", format!("Bearer {api_token}"))
+                .json(&PackageFetchRequest {
+                    url,
+                    sha256sum: sha256sum.clone(),
+                })
+                .expect_failure()
+                .await;
+            assert_eq!(res.json::<ErrorResponse>().error, "INVALID_PACKAGE_URL");
+        }
+
+        // Host names that resolve to private addresses fail like any other
+        // download, without saying why.
+        let url = format!("http://localhost:{port}/hello.deb");
+        let res = server
+            .http
+            .post("/api/v0/packages/fetch")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .json(&PackageFetchRequest {
+                url: url.clone(),
+                sha256sum,
+            })
+            .expect_failure()
+            .await;
+        res.assert_status(StatusCode::BAD_GATEWAY);
+        let error = res.json::<ErrorResponse>();
+        assert_eq!(error.error, "PACKAGE_FETCH_FAILED");
+        assert_eq!(error.message, format!("could not download package from {url}"));
+    }
+
+    #[test]
+    fn public_addresses() {
+        for ip in ["1.1.1.1", "93.184.215.14", "2606:4700:4700::1111"] {
+            assert!(is_public(ip.parse().unwrap()), "{ip} should be public");
+        }
+        for ip in [
+            "0.0.0.0",
+            "127.0.0.1",
+            "10.0.0.1",
+            "172.16.0.1",
+            "192.168.1.1",
+            "169.254.169.254",
+            "100.64.0.1",
+            "255.255.255.255",
+            "::",
+            "::1",
+            "::ffff:127.0.0.1",
+            "::ffff:169.254.169.254",
+            "fd00::1",
+            "fe80::1",
+            "64:ff9b::a00:1",
+        ] {
+            assert!(!is_public(ip.parse().unwrap()), "{ip} should not be public");
+        }
+    }
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn fetch_from_bucket(pool: sqlx::PgPool) {
+        const BUCKET: &str = "attune-dev-0";
+        const ARTIFACTS_BUCKET: &str = "attune-dev-0-artifacts";
+        let server = AttuneTestServer::with_fetch_config(
+            AttuneTestServerConfig {
+                db: pool,
+                s3_bucket_name: Some(String::from(BUCKET)),
+                http_api_token: None,
+            },
+            FetchConfig {
+                allowed_buckets: vec![String::from(ARTIFACTS_BUCKET), String::from(BUCKET)],
+                ..FetchConfig::default()
+            },
+        )
+        .await;
+        let (_tenant_id, api_token) = server.create_test_tenant("fetch_from_bucket").await;
+        let sha256sum = hex::encode(Sha256::digest(fixtures::TEST_PACKAGE_AMD64));
+
+        // Stage the package in a build artifact bucket, which may be left
+        // over from an earlier run.
+        server
+            .s3
+            .create_bucket()
+            .bucket(ARTIFACTS_BUCKET)
+            .send()
+            .await
+            .ok();
+        server
+            .s3
+            .put_object()
+            .bucket(ARTIFACTS_BUCKET)
+            .key("builds/42/hello 1.0.deb")
+            .body(fixtures::TEST_PACKAGE_AMD64.to_vec().into())
+            .send()
+            .await
+            .unwrap();
+
+        let fetched = server
+            .http
+            .post("/api/v0/packages/fetch")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .json(&PackageFetchRequest {
+                url: format!("s3://{ARTIFACTS_BUCKET}/builds/42/hello%201.0.deb"),
+                sha256sum: sha256sum.clone(),
+            })
+            .await
+            .json::<PackageUploadResponse>();
+        assert_eq!(fetched.sha256sum, sha256sum);
+
+        // Attune's own bucket holds every tenant's packages, so it can't be
+        // read from even if it's allowed. Buckets that aren't allowed can't be
+        // read from either, and GCS URIs aren't supported.
+        for url in [
+            format!("s3://{BUCKET}/packages/{sha256sum}"),
+            format!("s3://{BUCKET}-backups/builds/42/hello%201.0.deb"),
+            format!("gs://{ARTIFACTS_BUCKET}/builds/42/hello%201.0.deb"),
+        ] {
+            let res = server
+                .http
+                .post("/api/v0/packages/fetch")
+                .add_header("authorization", format!("Bearer {api_token}"))
+                .json(&PackageFetchRequest {
+                    url,
+                    sha256sum: sha256sum.clone(),
+                })
+                .expect_failure()
+                .await;
+            assert_eq!(res.json::<ErrorResponse>().error, "INVALID_PACKAGE_URL");
+        }
+    }
+}
@@ -1,21 +1,56 @@
+use std::collections::BTreeMap;
+
 use axum::{
     Json,
     extract::{Path, State},
     http::StatusCode,
 };
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
+use sqlx::types::Json as SqlJson;
+use time::OffsetDateTime;
 use tracing::instrument;
 
 use crate::{
     api::{ErrorResponse, TenantID},
-    server::ServerState,
+    server::{ServerState, pkg::upstream::UpstreamSignature},
 };
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub struct PackageInfoResponse {
     pub package: String,
     pub version: String,
     pub architecture: String,
+    /// Every field of the package's control file, like `Depends`,
+    /// `Maintainer`, `Section`, and `Description`.
+    pub fields: BTreeMap<String, String>,
+    /// The size of the package file, in bytes.
+    pub size: i64,
+    pub md5sum: String,
+    pub sha1sum: String,
+    pub sha256sum: String,
+    #[serde(with = "time::serde::rfc3339")]
+    #[schemars(with = "String")]
+    pub uploaded_at: OffsetDateTime,
+    /// The name of the API token that uploaded the package, if it was
+    /// recorded.
+    pub uploaded_by: Option<String>,
+    /// The upstream (vendor) signature that the API server verified for the
+    /// package, if any.
+    #[serde(default)]
+    pub upstream_signature: Option<UpstreamSignature>,
+    /// The components that publish the package, sorted by repository,
+    /// distribution, and component.
+    pub publications: Vec<PackagePublication>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct PackagePublication {
+    pub repository: String,
+    pub distribution: String,
+    pub component: String,
+    /// The package's path in the repository's pool.
+    pub filename: String,
 }
 
 #[axum::debug_handler]
@@ -28,9 +63,19 @@ pub async fn handler(
     let pkg = sqlx::query!(
         r#"
         SELECT
+            id,
             package,
             version,
-            architecture::TEXT AS "architecture!: String"
+            architecture::TEXT AS "architecture!: String",
+            paragraph AS "paragraph!: SqlJson<BTreeMap<String, String>>",
+            size,
+            md5sum,
+            sha1sum,
+            sha256sum,
+            created_at,
+            uploaded_by,
+            upstream_signature,
+            upstream_key_fingerprint
         FROM debian_repository_package
         WHERE tenant_id = $1 AND sha256sum = $2
         LIMIT 1
@@ -41,16 +86,106 @@ pub async fn handler(
     .fetch_optional(&state.db)
     .await
     .map_err(ErrorResponse::from)?;
-    match pkg {
-        Some(pkg) => Ok(Json(PackageInfoResponse {
-            package: pkg.package,
-            version: pkg.version,
-            architecture: pkg.architecture,
-        })),
-        None => Err(ErrorResponse::new(
+    let Some(pkg) = pkg else {
+        return Err(ErrorResponse::new(
             StatusCode::NOT_FOUND,
             "PACKAGE_NOT_FOUND".to_string(),
             "package not found".to_string(),
-        )),
+        ));
+    };
+
+    let publications = sqlx::query_as!(
+        PackagePublication,
+        r#"
+        SELECT
+            debian_repository.name AS repository,
+            debian_repository_release.distribution,
+            debian_repository_component.name AS component,
+            debian_repository_component_package.filename
+        FROM
+            debian_repository_component_package
+            JOIN debian_repository_component ON debian_repository_component_package.component_id = debian_repository_component.id
+            JOIN debian_repository_release ON debian_repository_component.release_id = debian_repository_release.id
+            JOIN debian_repository ON debian_repository_release.repository_id = debian_repository.id
+        WHERE debian_repository_component_package.package_id = $1
+        ORDER BY
+            debian_repository.name,
+            debian_repository_release.distribution,
+            debian_repository_component.name
+        "#,
+        pkg.id,
+    )
+    .fetch_all(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    Ok(Json(PackageInfoResponse {
+        package: pkg.package,
+        version: pkg.version,
+        architecture: pkg.architecture,
+        fields: pkg.paragraph.0,
+        size: pkg.size,
+        md5sum: pkg.md5sum,
+        sha1sum: pkg.sha1sum,
+        sha256sum: pkg.sha256sum,
+        uploaded_at: pkg.created_at,
+        uploaded_by: pkg.uploaded_by,
+        upstream_signature: pkg
+            .upstream_signature
+            .zip(pkg.upstream_key_fingerprint)
+            .map(|(kind, key_fingerprint)| UpstreamSignature {
+                kind,
+                key_fingerprint,
+            }),
+        publications,
+    }))
+}
+
+#[cfg(test)]
+mod tests {
+    use axum_test::multipart::{MultipartForm, Part};
+
+    use crate::{
+        server::pkg::upload::PackageUploadResponse,
+        testing::{AttuneTestServer, AttuneTestServerConfig, MIGRATOR, fixtures},
+    };
+
+    use super::*;
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn show_uploaded_package(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_tenant_id, api_token) = server.create_test_tenant("show_uploaded_package").await;
+
+        let upload = MultipartForm::new()
+            .add_part("file", Part::bytes(fixtures::TEST_PACKAGE_AMD64.to_vec()));
+        let uploaded = server
+            .http
+            .post("/api/v0/packages")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .multipart(upload)
+            .await
+            .json::<PackageUploadResponse>();
+
+        let res = server
+            .http
+            .get(&format!("/api/v0/packages/{}", uploaded.sha256sum))
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .await;
+        res.assert_status_ok();
+        let info = res.json::<PackageInfoResponse>();
+        assert_eq!(info.sha256sum, uploaded.sha256sum);
+        assert_eq!(info.architecture, "amd64");
+        assert_eq!(info.fields.get("Package"), Some(&info.package));
+        assert!(info.fields.contains_key("Maintainer"));
+        assert_eq!(info.size, fixtures::TEST_PACKAGE_AMD64.len() as i64);
+        assert_eq!(info.uploaded_by.as_deref(), Some("TEST_TENANT_API_TOKEN"));
+        assert_eq!(info.upstream_signature, None);
+        assert!(info.publications.is_empty());
     }
 }
@@ -1,8 +1,11 @@
 use axum::{
     Json,
     extract::{Query, State},
+    http::StatusCode,
 };
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
+use time::OffsetDateTime;
 use tracing::instrument;
 
 use crate::{
@@ -10,7 +13,7 @@ use crate::{
     server::ServerState,
 };
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, Debug, Clone, Default)]
 pub struct PackageListParams {
     pub repository: Option<String>,
     pub distribution: Option<String>,
@@ -19,9 +22,51 @@ pub struct PackageListParams {
     pub name: Option<String>,
     pub version: Option<String>,
     pub architecture: Option<String>,
+
+    /// Maximum number of packages to return. If not set, all matching
+    /// packages are returned.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub limit: Option<i64>,
+    /// Return packages after this cursor, which is the `next_cursor` of a
+    /// previous page.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub cursor: Option<String>,
+    /// Sort packages by this field. If not set, packages are listed in the
+    /// order they were added to their components.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub sort: Option<PackageSort>,
+}
+
+/// A field that packages can be sorted by. Ties are broken by the order in
+/// which packages were added to their components.
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum PackageSort {
+    Name,
+    Version,
+    Architecture,
+    Repository,
+    Distribution,
+    Component,
 }
 
-#[derive(Serialize, Deserialize, Debug)]
+impl PackageSort {
+    fn as_str(&self) -> &'static str {
+        match self {
+            PackageSort::Name => "name",
+            PackageSort::Version => "version",
+            PackageSort::Architecture => "architecture",
+            PackageSort::Repository => "repository",
+            PackageSort::Distribution => "distribution",
+            PackageSort::Component => "component",
+        }
+    }
+}
+
+/// The largest page that a client may request.
+pub const MAX_PAGE_SIZE: i64 = 1000;
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub struct Package {
     pub repository: String,
     pub distribution: String,
@@ -32,11 +77,32 @@ pub struct Package {
     pub architecture: String,
 
     pub sha256sum: String,
+    /// When the package was added to the component.
+    #[serde(with = "time::serde::rfc3339")]
+    #[schemars(with = "String")]
+    pub added_at: OffsetDateTime,
 }
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub struct PackageListResponse {
     pub packages: Vec<Package>,
+    /// Cursor for the next page, if there are more packages than were
+    /// returned.
+    #[serde(default)]
+    pub next_cursor: Option<String>,
+}
+
+/// Packages are listed in order of their component and package IDs (i.e. the
+/// primary key of their component membership), which is stable as packages
+/// are added. Cursors encode the position in that order, but clients should
+/// treat them as opaque.
+fn encode_cursor(component_id: i64, package_id: i64) -> String {
+    format!("{component_id}.{package_id}")
+}
+
+fn decode_cursor(cursor: &str) -> Option<(i64, i64)> {
+    let (component_id, package_id) = cursor.split_once('.')?;
+    Some((component_id.parse().ok()?, package_id.parse().ok()?))
 }
 
 #[axum::debug_handler]
@@ -46,32 +112,96 @@ pub async fn handler(
     tenant_id: TenantID,
     params: Query<PackageListParams>,
 ) -> Result<Json<PackageListResponse>, ErrorResponse> {
-    let packages = sqlx::query!(
+    let limit = match params.limit {
+        Some(limit) if !(1..=MAX_PAGE_SIZE).contains(&limit) => {
+            return Err(ErrorResponse::new(
+                StatusCode::BAD_REQUEST,
+                "INVALID_LIMIT".to_string(),
+                format!("limit must be between 1 and {MAX_PAGE_SIZE}"),
+            ));
+        }
+        limit => limit,
+    };
+    let after = match params.cursor.as_deref().map(decode_cursor) {
+        Some(None) => {
+            return Err(ErrorResponse::new(
+                StatusCode::BAD_REQUEST,
+                "INVALID_CURSOR".to_string(),
+                "invalid pagination cursor".to_string(),
+            ));
+        }
+        Some(Some(after)) => Some(after),
+        None => None,
+    };
+
+    // Fetch one extra row to find out whether there is another page.
+    //
+    // When sorting, the cursor still identifies the last row of the previous
+    // page; its sort key is looked up so that the cursor stays the same shape
+    // regardless of the sort order.
+    let mut rows = sqlx::query!(
         r#"
+        WITH packages AS (
+            SELECT
+                debian_repository.name AS repository,
+                debian_repository_release.distribution AS distribution,
+                debian_repository_component.name AS component,
+
+                debian_repository_package.package AS name,
+                debian_repository_package.version,
+                debian_repository_package.architecture::TEXT AS architecture,
+
+                debian_repository_package.sha256sum,
+
+                debian_repository_component_package.component_id,
+                debian_repository_component_package.package_id,
+                debian_repository_component_package.created_at AS added_at,
+
+                CASE $11::TEXT
+                    WHEN 'name' THEN debian_repository_package.package
+                    WHEN 'version' THEN debian_repository_package.version
+                    WHEN 'architecture' THEN debian_repository_package.architecture::TEXT
+                    WHEN 'repository' THEN debian_repository.name
+                    WHEN 'distribution' THEN debian_repository_release.distribution
+                    WHEN 'component' THEN debian_repository_component.name
+                    ELSE ''
+                END AS sort_key
+            FROM
+                debian_repository_package
+                JOIN debian_repository_component_package ON debian_repository_package.id = debian_repository_component_package.package_id
+                JOIN debian_repository_component ON debian_repository_component_package.component_id = debian_repository_component.id
+                JOIN debian_repository_release ON debian_repository_component.release_id = debian_repository_release.id
+                JOIN debian_repository ON debian_repository_release.repository_id = debian_repository.id
+            WHERE
+                debian_repository_package.tenant_id = $1
+                AND (debian_repository.name = $2 OR $2 IS NULL)
+                AND (debian_repository_release.distribution = $3 OR $3 IS NULL)
+                AND (debian_repository_component.name = $4 OR $4 IS NULL)
+                AND (debian_repository_package.package = $5 OR $5 IS NULL)
+                AND (debian_repository_package.version = $6 OR $6 IS NULL)
+                AND (debian_repository_package.architecture = $7::debian_repository_architecture OR $7 IS NULL)
+        )
         SELECT
-            debian_repository.name AS repository,
-            debian_repository_release.distribution AS distribution,
-            debian_repository_component.name AS component,
-
-            debian_repository_package.package AS name,
-            debian_repository_package.version,
-            debian_repository_package.architecture::TEXT AS "architecture!: String",
-
-            debian_repository_package.sha256sum
-        FROM
-            debian_repository_package
-            JOIN debian_repository_component_package ON debian_repository_package.id = debian_repository_component_package.package_id
-            JOIN debian_repository_component ON debian_repository_component_package.component_id = debian_repository_component.id
-            JOIN debian_repository_release ON debian_repository_component.release_id = debian_repository_release.id
-            JOIN debian_repository ON debian_repository_release.repository_id = debian_repository.id
+            repository AS "repository!",
+            distribution AS "distribution!",
+            component AS "component!",
+            name AS "name!",
+            version AS "version!",
+            architecture AS "architecture!: String",
+            sha256sum AS "sha256sum!",
+            component_id AS "component_id!",
+            package_id AS "package_id!",
+            added_at AS "added_at!"
+        FROM packages
         WHERE
-            debian_repository_package.tenant_id = $1
-            AND (debian_repository.name = $2 OR $2 IS NULL)
-            AND (debian_repository_release.distribution = $3 OR $3 IS NULL)
-            AND (debian_repository_component.name = $4 OR $4 IS NULL)
-            AND (debian_repository_package.package = $5 OR $5 IS NULL)
-            AND (debian_repository_package.version = $6 OR $6 IS NULL)
-            AND (debian_repository_package.architecture = $7::debian_repository_architecture OR $7 IS NULL)
+            $8::BIGINT IS NULL
+            OR (sort_key, component_id, package_id) > (
+                (SELECT sort_key FROM packages WHERE component_id = $8 AND package_id = $9::BIGINT),
+                $8,
+                $9::BIGINT
+            )
+        ORDER BY sort_key, component_id, package_id
+        LIMIT $10
         "#,
         tenant_id.0,
         // These explicit typecasts are necessary because otherwise Postgres
@@ -83,21 +213,68 @@ pub async fn handler(
         &params.name as &Option<String>,
         &params.version as &Option<String>,
         &params.architecture as &Option<String>,
+        after.map(|(component_id, _)| component_id),
+        after.map(|(_, package_id)| package_id),
+        limit.map(|limit| limit + 1),
+        params.sort.map(|sort| sort.as_str()),
     )
     .fetch_all(&state.db)
     .await
-    .map_err(ErrorResponse::from)?
-    .into_iter()
-    .map(|pkg| Package {
-        repository: pkg.repository,
-        distribution: pkg.distribution,
-        component: pkg.component,
-        name: pkg.name,
-        version: pkg.version,
-        architecture: pkg.architecture,
-        sha256sum: pkg.sha256sum,
-    })
-    .collect::<Vec<_>>();
-
-    Ok(Json(PackageListResponse { packages }))
+    .map_err(ErrorResponse::from)?;
+
+    let next_cursor = match limit {
+        Some(limit) if rows.len() as i64 > limit => {
+            rows.truncate(limit as usize);
+            rows.last()
+                .map(|last| encode_cursor(last.component_id, last.package_id))
+        }
+        _ => None,
+    };
+    let packages = rows
+        .into_iter()
+        .map(|pkg| Package {
+            repository: pkg.repository,
+            distribution: pkg.distribution,
+            component: pkg.component,
+            name: pkg.name,
+            version: pkg.version,
+            architecture: pkg.architecture,
+            sha256sum: pkg.sha256sum,
+            added_at: pkg.added_at,
+        })
+        .collect::<Vec<_>>();
+
+    Ok(Json(PackageListResponse {
+        packages,
+        next_cursor,
+    }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn cursor_roundtrip() {
+        assert_eq!(decode_cursor(&encode_cursor(12, 345)), Some((12, 345)));
+        assert_eq!(decode_cursor("12"), None);
+        assert_eq!(decode_cursor("a.b"), None);
+    }
+
+    #[test]
+    fn sort_names_match_query() {
+        for sort in [
+            PackageSort::Name,
+            PackageSort::Version,
+            PackageSort::Architecture,
+            PackageSort::Repository,
+            PackageSort::Distribution,
+            PackageSort::Component,
+        ] {
+            assert_eq!(
+                serde_json::to_value(sort).unwrap(),
+                serde_json::Value::from(sort.as_str())
+            );
+        }
+    }
 }
@@ -1,3 +1,12 @@
+pub mod download;
+pub mod exists;
+pub mod fetch;
 pub mod info;
 pub mod list;
+pub mod owns;
+pub mod resumable;
+pub mod search;
+pub mod translation;
 pub mod upload;
+pub mod upstream;
+pub mod versions;
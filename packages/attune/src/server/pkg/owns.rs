@@ -0,0 +1,225 @@
+use axum::{
+    Json,
+    extract::{Query, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::ServerState,
+};
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct PackageOwnersParams {
+    /// The absolute path of an installed file, like `/usr/bin/hello`.
+    pub path: String,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub repository: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub distribution: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub component: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub architecture: Option<String>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct PackageOwner {
+    pub repository: String,
+    pub distribution: String,
+    pub component: String,
+
+    pub name: String,
+    pub version: String,
+    pub architecture: String,
+
+    pub sha256sum: String,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct PackageOwnersResponse {
+    /// The path that was searched for, relative to `/`, as it's listed in
+    /// Contents indexes.
+    pub path: String,
+    /// The published packages that install the file, sorted by name, version,
+    /// architecture, repository, distribution, and component.
+    pub packages: Vec<PackageOwner>,
+}
+
+/// Normalize an installed file's path to the form that package files are
+/// recorded in: relative to `/`, without a leading `./`.
+///
+/// Returns `None` for paths of directories, which aren't recorded.
+fn normalize_path(path: &str) -> Option<String> {
+    let path = path.trim().trim_start_matches("./").trim_start_matches('/');
+    if path.is_empty() || path.ends_with('/') {
+        return None;
+    }
+    Some(path.to_string())
+}
+
+/// Find the published packages that install a file.
+///
+/// Files are read from each package's data archive when it's uploaded, like
+/// for Contents indexes, so packages uploaded before Attune recorded installed
+/// files aren't found until they're uploaded again.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    params: Query<PackageOwnersParams>,
+) -> Result<Json<PackageOwnersResponse>, ErrorResponse> {
+    let Some(path) = normalize_path(&params.path) else {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_PATH".to_string(),
+            format!("invalid file path {:?}: must name a file", params.path),
+        ));
+    };
+
+    let packages = sqlx::query_as!(
+        PackageOwner,
+        r#"
+        SELECT
+            debian_repository.name AS repository,
+            debian_repository_release.distribution,
+            debian_repository_component.name AS component,
+
+            debian_repository_package.package AS name,
+            debian_repository_package.version,
+            debian_repository_package.architecture::TEXT AS "architecture!: String",
+
+            debian_repository_package.sha256sum
+        FROM
+            debian_repository_package
+            JOIN debian_repository_component_package ON debian_repository_package.id = debian_repository_component_package.package_id
+            JOIN debian_repository_component ON debian_repository_component_package.component_id = debian_repository_component.id
+            JOIN debian_repository_release ON debian_repository_component.release_id = debian_repository_release.id
+            JOIN debian_repository ON debian_repository_release.repository_id = debian_repository.id
+        WHERE
+            debian_repository_package.tenant_id = $1
+            AND debian_repository_package.files @> ARRAY[$2::TEXT]
+            AND (debian_repository.name = $3 OR $3 IS NULL)
+            AND (debian_repository_release.distribution = $4 OR $4 IS NULL)
+            AND (debian_repository_component.name = $5 OR $5 IS NULL)
+            AND (debian_repository_package.architecture = $6::debian_repository_architecture OR $6 IS NULL)
+        ORDER BY
+            debian_repository_package.package,
+            debian_repository_package.version,
+            debian_repository_package.architecture,
+            debian_repository.name,
+            debian_repository_release.distribution,
+            debian_repository_component.name
+        "#,
+        tenant_id.0,
+        path,
+        // These explicit typecasts are necessary because otherwise Postgres
+        // infers these argument types using the first callsite and assumes
+        // these parameters are &str's.
+        &params.repository as &Option<String>,
+        &params.distribution as &Option<String>,
+        &params.component as &Option<String>,
+        &params.architecture as &Option<String>,
+    )
+    .fetch_all(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    Ok(Json(PackageOwnersResponse { path, packages }))
+}
+
+#[cfg(test)]
+mod tests {
+    use sha2::{Digest as _, Sha256};
+
+    use crate::testing::{AttuneTestServer, AttuneTestServerConfig, MIGRATOR};
+
+    use super::*;
+
+    #[test]
+    fn normalizes_paths() {
+        assert_eq!(
+            normalize_path("/usr/bin/hello"),
+            Some(String::from("usr/bin/hello"))
+        );
+        assert_eq!(
+            normalize_path("./usr/bin/hello"),
+            Some(String::from("usr/bin/hello"))
+        );
+        assert_eq!(
+            normalize_path("usr/bin/hello"),
+            Some(String::from("usr/bin/hello"))
+        );
+        assert_eq!(normalize_path("/usr/bin/"), None);
+        assert_eq!(normalize_path("/"), None);
+    }
+
+    #[test_log::test(sqlx::test(
+        migrator = "MIGRATOR",
+        fixtures(path = "../repo/index/fixtures", scripts("setup_multi_arch"))
+    ))]
+    async fn find_package_owners(pool: sqlx::PgPool) {
+        let api_token = "test-api-token-find_package_owners";
+        sqlx::query(
+            r#"
+            INSERT INTO attune_tenant_api_token (tenant_id, name, token, created_at, updated_at)
+            VALUES (1, 'find_package_owners', $1, NOW(), NOW())
+            "#,
+        )
+        .bind(Sha256::digest(api_token).as_slice().to_vec())
+        .execute(&pool)
+        .await
+        .unwrap();
+        sqlx::query(
+            "UPDATE debian_repository_package SET files = ARRAY['usr/bin/test-package', 'usr/share/doc/test-package/copyright']",
+        )
+        .execute(&pool)
+        .await
+        .unwrap();
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let owns = |params: &[(&str, &str)]| {
+            let mut req = server
+                .http
+                .get("/api/v0/packages/owns")
+                .add_header("authorization", format!("Bearer {api_token}"));
+            for (key, value) in params {
+                req = req.add_query_param(key, value);
+            }
+            req
+        };
+
+        let res = owns(&[("path", "/usr/bin/test-package")])
+            .await
+            .json::<PackageOwnersResponse>();
+        assert_eq!(res.path, "usr/bin/test-package");
+        assert_eq!(res.packages.len(), 2);
+        assert!(res.packages.iter().all(|pkg| pkg.name == "test-package"));
+
+        let res = owns(&[("path", "/usr/bin/test-package"), ("architecture", "arm64")])
+            .await
+            .json::<PackageOwnersResponse>();
+        assert_eq!(res.packages.len(), 1);
+        assert_eq!(res.packages[0].architecture, "arm64");
+
+        // Paths must match exactly.
+        let res = owns(&[("path", "/usr/bin/test")])
+            .await
+            .json::<PackageOwnersResponse>();
+        assert!(res.packages.is_empty());
+
+        owns(&[("path", "/usr/bin/")])
+            .expect_failure()
+            .await
+            .assert_status(StatusCode::BAD_REQUEST);
+    }
+}
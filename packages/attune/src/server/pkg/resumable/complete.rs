@@ -0,0 +1,113 @@
+use aws_sdk_s3::error::DisplayErrorContext;
+use axum::{
+    Json,
+    extract::{Path, Query, State},
+    http::StatusCode,
+};
+use bytes::BytesMut;
+use digest::Digest as _;
+use itertools::Itertools as _;
+use sha2::Sha256;
+use tracing::instrument;
+
+use crate::{
+    api::{Actor, ErrorResponse},
+    server::{
+        ServerState,
+        pkg::{
+            resumable::{delete_upload, part_key, query_upload},
+            upload::{MAX_PACKAGE_SIZE, PackageUploadParams, PackageUploadResponse, store_package},
+        },
+    },
+};
+
+/// Combine the parts of a resumable upload into a package, and store it as if
+/// it had been uploaded in one request. The upload is deleted afterwards, as it
+/// is if the combined parts don't match the package's SHA256 sum.
+///
+/// Like a package uploaded in one request, the package is held in memory to
+/// store it, so uploads are limited to `MAX_PACKAGE_SIZE`. Parts are hashed as
+/// they're read.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    actor: Actor,
+    Path(upload_id): Path<String>,
+    Query(params): Query<PackageUploadParams>,
+) -> Result<Json<PackageUploadResponse>, ErrorResponse> {
+    let upload = query_upload(&state.db, &actor.tenant_id, &upload_id).await?;
+    let missing = upload.missing_parts();
+    if !missing.is_empty() {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "UPLOAD_INCOMPLETE",
+            format!("upload is missing parts {}", missing.iter().join(", ")),
+        ));
+    }
+
+    // Uploads created before their size was limited may be larger.
+    if upload.size > MAX_PACKAGE_SIZE {
+        delete_upload(&state, &upload).await?;
+        return Err(ErrorResponse::new(
+            StatusCode::PAYLOAD_TOO_LARGE,
+            "PACKAGE_TOO_LARGE",
+            format!("package is larger than the maximum of {MAX_PACKAGE_SIZE} bytes"),
+        ));
+    }
+
+    let mut value = BytesMut::with_capacity(upload.size as usize);
+    let mut hasher = Sha256::new();
+    for part_number in 1..=upload.part_count() {
+        let read_failed = |error: String| {
+            ErrorResponse::new(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "UPLOAD_READ_FAILED",
+                format!("could not read part {part_number} of upload: {error}"),
+            )
+        };
+        let part = state
+            .s3
+            .get_object()
+            .bucket(&state.s3_bucket_name)
+            .key(part_key(&upload.id, part_number))
+            .send()
+            .await
+            .map_err(|error| read_failed(DisplayErrorContext(&error).to_string()))?;
+        let mut body = part.body;
+        while let Some(chunk) = body
+            .try_next()
+            .await
+            .map_err(|error| read_failed(error.to_string()))?
+        {
+            // Parts are checked against their size when they're received, so
+            // this only happens if a staged part was changed.
+            if (value.len() + chunk.len()) as i64 > upload.size {
+                return Err(read_failed(format!(
+                    "parts are larger than the package's {} bytes",
+                    upload.size
+                )));
+            }
+            hasher.update(&chunk);
+            value.extend_from_slice(&chunk);
+        }
+    }
+    let value = value.freeze();
+
+    let sha256sum = hex::encode(hasher.finalize());
+    if sha256sum != upload.sha256sum {
+        delete_upload(&state, &upload).await?;
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "PACKAGE_CHECKSUM_MISMATCH",
+            format!(
+                "uploaded package has SHA256 sum {sha256sum}, but {} was expected; start a new upload",
+                upload.sha256sum
+            ),
+        ));
+    }
+
+    let uploaded = store_package(&state, &actor, value, &params).await?;
+    delete_upload(&state, &upload).await?;
+    Ok(Json(uploaded))
+}
@@ -0,0 +1,107 @@
+use axum::{Json, extract::State, http::StatusCode};
+use serde::{Deserialize, Serialize};
+use tracing::{instrument, warn};
+use uuid::Uuid;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{
+        ServerState,
+        pkg::{
+            resumable::{
+                DEFAULT_PART_SIZE, MAX_PART_COUNT, MAX_PART_SIZE, ResumableUpload,
+                ResumableUploadResponse, delete_expired_uploads,
+            },
+            upload::MAX_PACKAGE_SIZE,
+        },
+    },
+};
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct CreateResumableUploadRequest {
+    /// The size of the whole package.
+    pub size: i64,
+    /// The SHA256 sum of the whole package, which is checked when the upload
+    /// is completed.
+    pub sha256sum: String,
+    /// The size of each part. If not set, a default is used.
+    pub part_size: Option<i64>,
+}
+
+/// Start a resumable upload of a package.
+///
+/// Expired uploads are deleted first.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Json(req): Json<CreateResumableUploadRequest>,
+) -> Result<Json<ResumableUploadResponse>, ErrorResponse> {
+    let invalid = |message: String| {
+        ErrorResponse::new(StatusCode::BAD_REQUEST, "INVALID_UPLOAD", message)
+    };
+    if req.size < 1 {
+        return Err(invalid(format!("invalid package size {}", req.size)));
+    }
+    if req.size > MAX_PACKAGE_SIZE {
+        return Err(ErrorResponse::new(
+            StatusCode::PAYLOAD_TOO_LARGE,
+            "PACKAGE_TOO_LARGE",
+            format!("package is larger than the maximum of {MAX_PACKAGE_SIZE} bytes"),
+        ));
+    }
+    let part_size = req.part_size.unwrap_or(DEFAULT_PART_SIZE);
+    if !(1..=MAX_PART_SIZE).contains(&part_size) {
+        return Err(invalid(format!(
+            "invalid part size {part_size}: parts can be at most {MAX_PART_SIZE} bytes"
+        )));
+    }
+    if req.sha256sum.len() != 64 || !req.sha256sum.chars().all(|c| c.is_ascii_hexdigit()) {
+        return Err(invalid(format!("invalid SHA256 sum {:?}", req.sha256sum)));
+    }
+
+    let upload = ResumableUpload {
+        id: Uuid::new_v4().to_string(),
+        size: req.size,
+        sha256sum: req.sha256sum.to_ascii_lowercase(),
+        part_size,
+        received_parts: Vec::new(),
+    };
+    if !(1..=MAX_PART_COUNT).contains(&upload.part_count()) {
+        return Err(invalid(format!(
+            "package of {} bytes has too many parts of {part_size} bytes: uploads can have at most {MAX_PART_COUNT} parts",
+            req.size
+        )));
+    }
+
+    // Failing to clean up other uploads doesn't stop this one.
+    if let Err(error) = delete_expired_uploads(&state).await {
+        warn!(?error, "could not delete expired uploads");
+    }
+
+    sqlx::query!(
+        r#"
+        INSERT INTO debian_repository_package_upload (
+            id,
+            tenant_id,
+            size,
+            sha256sum,
+            part_size,
+            created_at,
+            updated_at
+        )
+        VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+        "#,
+        &upload.id,
+        tenant_id.0,
+        upload.size,
+        &upload.sha256sum,
+        upload.part_size,
+    )
+    .execute(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    Ok(Json(ResumableUploadResponse { upload }))
+}
@@ -0,0 +1,25 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{
+        ServerState,
+        pkg::resumable::{ResumableUploadResponse, query_upload},
+    },
+};
+
+/// Show a resumable upload, including which parts have been received.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path(upload_id): Path<String>,
+) -> Result<Json<ResumableUploadResponse>, ErrorResponse> {
+    let upload = query_upload(&state.db, &tenant_id, &upload_id).await?;
+    Ok(Json(ResumableUploadResponse { upload }))
+}
@@ -0,0 +1,421 @@
+//! Uploads of packages in parts, so that an interrupted upload of a large
+//! package can be resumed instead of started over.
+//!
+//! A client creates an upload with the package's size and SHA256 sum, sends
+//! each part (in any order, and again if sending it failed), and then completes
+//! the upload, which stores the package as if it had been uploaded in one
+//! request. Received parts are staged in S3 until the upload is completed.
+//!
+//! Uploads that aren't completed within a week of being created expire, and
+//! are deleted along with their staged parts when another upload is created.
+
+use aws_sdk_s3::error::DisplayErrorContext;
+use axum::http::StatusCode;
+use serde::{Deserialize, Serialize};
+use sqlx::PgPool;
+use tracing::{debug, instrument};
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::ServerState,
+};
+
+pub mod complete;
+pub mod create;
+pub mod info;
+pub mod part;
+
+/// The part size used when the client doesn't choose one.
+pub const DEFAULT_PART_SIZE: i64 = 8 * 1024 * 1024;
+
+/// The largest part size that a client can choose.
+pub const MAX_PART_SIZE: i64 = 256 * 1024 * 1024;
+
+/// The most parts that a package can be split into.
+pub const MAX_PART_COUNT: i32 = 10_000;
+
+/// The most expired uploads that are deleted each time an upload is created.
+const EXPIRED_UPLOAD_BATCH_SIZE: i64 = 100;
+
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct ResumableUpload {
+    /// The upload's ID, which is used to resume it.
+    pub id: String,
+    /// The size of the whole package.
+    pub size: i64,
+    pub sha256sum: String,
+    /// The size of every part except the last, which holds the remainder.
+    pub part_size: i64,
+    /// The numbers of the parts that have been received, in order. Part
+    /// numbers start at 1.
+    pub received_parts: Vec<i32>,
+}
+
+impl ResumableUpload {
+    /// The number of parts that the package is split into.
+    pub fn part_count(&self) -> i32 {
+        (self.size + self.part_size - 1)
+            .checked_div(self.part_size)
+            .and_then(|count| i32::try_from(count).ok())
+            .unwrap_or_default()
+    }
+
+    /// The offset and size of a part in the package, or `None` if there is no
+    /// such part.
+    pub fn part_range(&self, part_number: i32) -> Option<(i64, i64)> {
+        if part_number < 1 || part_number > self.part_count() {
+            return None;
+        }
+        let offset = i64::from(part_number - 1) * self.part_size;
+        Some((offset, self.part_size.min(self.size - offset)))
+    }
+
+    /// The numbers of the parts that haven't been received yet.
+    pub fn missing_parts(&self) -> Vec<i32> {
+        (1..=self.part_count())
+            .filter(|part_number| self.received_parts.binary_search(part_number).is_err())
+            .collect()
+    }
+}
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct ResumableUploadResponse {
+    pub upload: ResumableUpload,
+}
+
+/// The S3 key at which a received part is staged.
+fn part_key(upload_id: &str, part_number: i32) -> String {
+    format!("uploads/{upload_id}/{part_number}")
+}
+
+/// Load an upload and its received parts, or return an error if it doesn't
+/// exist.
+#[instrument(skip(db))]
+async fn query_upload(
+    db: &PgPool,
+    tenant_id: &TenantID,
+    upload_id: &str,
+) -> Result<ResumableUpload, ErrorResponse> {
+    let upload = sqlx::query!(
+        r#"
+        SELECT id, size, sha256sum, part_size
+        FROM debian_repository_package_upload
+        WHERE tenant_id = $1 AND id = $2
+        "#,
+        tenant_id.0,
+        upload_id,
+    )
+    .fetch_optional(db)
+    .await
+    .map_err(ErrorResponse::from)?
+    .ok_or_else(|| {
+        ErrorResponse::new(
+            StatusCode::NOT_FOUND,
+            "UPLOAD_NOT_FOUND",
+            format!("upload {upload_id:?} not found"),
+        )
+    })?;
+    let received_parts = sqlx::query_scalar!(
+        r#"
+        SELECT part_number
+        FROM debian_repository_package_upload_part
+        WHERE upload_id = $1
+        ORDER BY part_number
+        "#,
+        upload_id,
+    )
+    .fetch_all(db)
+    .await
+    .map_err(ErrorResponse::from)?;
+    Ok(ResumableUpload {
+        id: upload.id,
+        size: upload.size,
+        sha256sum: upload.sha256sum,
+        part_size: upload.part_size,
+        received_parts,
+    })
+}
+
+/// Delete an upload and its staged parts.
+#[instrument(skip(state))]
+async fn delete_upload(state: &ServerState, upload: &ResumableUpload) -> Result<(), ErrorResponse> {
+    // Parts are deleted first, so that they're still recorded if deleting
+    // one fails.
+    for &part_number in &upload.received_parts {
+        state
+            .s3
+            .delete_object()
+            .bucket(&state.s3_bucket_name)
+            .key(part_key(&upload.id, part_number))
+            .send()
+            .await
+            .map_err(|error| {
+                ErrorResponse::new(
+                    StatusCode::INTERNAL_SERVER_ERROR,
+                    "UPLOAD_DELETE_FAILED",
+                    format!(
+                        "could not delete part {part_number} of upload {:?}: {}",
+                        upload.id,
+                        DisplayErrorContext(&error)
+                    ),
+                )
+            })?;
+    }
+    sqlx::query!(
+        r#"
+        DELETE FROM debian_repository_package_upload
+        WHERE id = $1
+        "#,
+        &upload.id,
+    )
+    .execute(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+    Ok(())
+}
+
+/// Delete uploads that weren't completed within a week of being created, along
+/// with their staged parts. At most `EXPIRED_UPLOAD_BATCH_SIZE` uploads are
+/// deleted at once, oldest first.
+#[instrument(skip(state))]
+async fn delete_expired_uploads(state: &ServerState) -> Result<(), ErrorResponse> {
+    let expired = sqlx::query!(
+        r#"
+        SELECT id, tenant_id
+        FROM debian_repository_package_upload
+        WHERE created_at < NOW() - INTERVAL '7 days'
+        ORDER BY created_at
+        LIMIT $1
+        "#,
+        EXPIRED_UPLOAD_BATCH_SIZE,
+    )
+    .fetch_all(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+    for expired in expired {
+        let upload = query_upload(&state.db, &TenantID(expired.tenant_id), &expired.id).await?;
+        delete_upload(state, &upload).await?;
+        debug!(upload_id = ?upload.id, "deleted expired upload");
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use digest::Digest as _;
+    use sha2::Sha256;
+
+    use crate::{
+        server::pkg::{
+            info::PackageInfoResponse,
+            resumable::{create::CreateResumableUploadRequest, part::UploadPartResponse},
+            upload::{MAX_PACKAGE_SIZE, PackageUploadResponse},
+        },
+        testing::{AttuneTestServer, AttuneTestServerConfig, MIGRATOR, fixtures},
+    };
+
+    use super::*;
+
+    #[test]
+    fn splits_package_into_parts() {
+        let upload = ResumableUpload {
+            id: String::from("upload"),
+            size: 25,
+            sha256sum: String::new(),
+            part_size: 10,
+            received_parts: vec![2],
+        };
+        assert_eq!(upload.part_count(), 3);
+        assert_eq!(upload.part_range(1), Some((0, 10)));
+        assert_eq!(upload.part_range(3), Some((20, 5)));
+        assert_eq!(upload.part_range(0), None);
+        assert_eq!(upload.part_range(4), None);
+        assert_eq!(upload.missing_parts(), vec![1, 3]);
+    }
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn resume_upload(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_tenant_id, api_token) = server.create_test_tenant("resume_upload").await;
+        let auth = format!("Bearer {api_token}");
+
+        let package = fixtures::TEST_PACKAGE_AMD64;
+        let part_size = package.len() as i64 / 2 + 1;
+        let sha256sum = hex::encode(Sha256::digest(package));
+        let upload = server
+            .http
+            .post("/api/v0/packages/uploads")
+            .add_header("authorization", &auth)
+            .json(&CreateResumableUploadRequest {
+                size: package.len() as i64,
+                sha256sum: sha256sum.clone(),
+                part_size: Some(part_size),
+            })
+            .await
+            .json::<ResumableUploadResponse>()
+            .upload;
+        assert_eq!(upload.part_count(), 2);
+
+        // Send the second part, as if the upload was interrupted before the
+        // first part was received.
+        let send_part = |part_number: i32| {
+            let (offset, size) = upload.part_range(part_number).unwrap();
+            let part = package[offset as usize..(offset + size) as usize].to_vec();
+            server
+                .http
+                .put(&format!(
+                    "/api/v0/packages/uploads/{}/parts/{part_number}",
+                    upload.id
+                ))
+                .add_header("authorization", &auth)
+                .bytes(part.into())
+        };
+        let part = send_part(2).await.json::<UploadPartResponse>();
+        assert_eq!(part.part_number, 2);
+        let resumed = server
+            .http
+            .get(&format!("/api/v0/packages/uploads/{}", upload.id))
+            .add_header("authorization", &auth)
+            .await
+            .json::<ResumableUploadResponse>()
+            .upload;
+        assert_eq!(resumed.received_parts, vec![2]);
+        assert_eq!(resumed.missing_parts(), vec![1]);
+
+        // The upload can't be completed until every part is received.
+        let complete = || {
+            server
+                .http
+                .post(&format!("/api/v0/packages/uploads/{}/complete", upload.id))
+                .add_header("authorization", &auth)
+        };
+        let res = complete().expect_failure().await;
+        assert_eq!(res.json::<ErrorResponse>().error, "UPLOAD_INCOMPLETE");
+
+        send_part(1).await.assert_status_ok();
+        let uploaded = complete().await.json::<PackageUploadResponse>();
+        assert_eq!(uploaded.sha256sum, sha256sum);
+        server
+            .http
+            .get(&format!("/api/v0/packages/{sha256sum}"))
+            .add_header("authorization", &auth)
+            .await
+            .json::<PackageInfoResponse>();
+
+        // Completed uploads are deleted.
+        server
+            .http
+            .get(&format!("/api/v0/packages/uploads/{}", upload.id))
+            .add_header("authorization", &auth)
+            .expect_failure()
+            .await
+            .assert_status(StatusCode::NOT_FOUND);
+    }
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn limit_upload_size(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_tenant_id, api_token) = server.create_test_tenant("limit_upload_size").await;
+
+        let create = |size: i64, part_size: i64| {
+            server
+                .http
+                .post("/api/v0/packages/uploads")
+                .add_header("authorization", format!("Bearer {api_token}"))
+                .json(&CreateResumableUploadRequest {
+                    size,
+                    sha256sum: "0".repeat(64),
+                    part_size: Some(part_size),
+                })
+                .expect_failure()
+        };
+        let res = create(MAX_PACKAGE_SIZE + 1, MAX_PART_SIZE).await;
+        res.assert_status(StatusCode::PAYLOAD_TOO_LARGE);
+        assert_eq!(res.json::<ErrorResponse>().error, "PACKAGE_TOO_LARGE");
+
+        // Small parts would make too many of them to track.
+        let res = create(MAX_PACKAGE_SIZE, 1).await;
+        res.assert_status(StatusCode::BAD_REQUEST);
+        assert_eq!(res.json::<ErrorResponse>().error, "INVALID_UPLOAD");
+        let res = create(i64::from(MAX_PART_COUNT) + 1, 1).await;
+        res.assert_status(StatusCode::BAD_REQUEST);
+        assert_eq!(res.json::<ErrorResponse>().error, "INVALID_UPLOAD");
+    }
+
+    /// Uploads that aren't completed expire, and are deleted along with their
+    /// staged parts when another upload is created.
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn delete_expired_upload(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_tenant_id, api_token) = server.create_test_tenant("delete_expired_upload").await;
+        let auth = format!("Bearer {api_token}");
+
+        let package = fixtures::TEST_PACKAGE_AMD64;
+        let create = || {
+            server
+                .http
+                .post("/api/v0/packages/uploads")
+                .add_header("authorization", &auth)
+                .json(&CreateResumableUploadRequest {
+                    size: package.len() as i64,
+                    sha256sum: hex::encode(Sha256::digest(package)),
+                    part_size: None,
+                })
+        };
+        let expired = create().await.json::<ResumableUploadResponse>().upload;
+        server
+            .http
+            .put(&format!("/api/v0/packages/uploads/{}/parts/1", expired.id))
+            .add_header("authorization", &auth)
+            .bytes(package.to_vec().into())
+            .await
+            .assert_status_ok();
+        sqlx::query(
+            r#"
+            UPDATE debian_repository_package_upload
+            SET created_at = NOW() - INTERVAL '8 days'
+            WHERE id = $1
+            "#,
+        )
+        .bind(&expired.id)
+        .execute(&server.db)
+        .await
+        .unwrap();
+
+        let upload = create().await.json::<ResumableUploadResponse>().upload;
+        let info = |upload_id: &str| {
+            server
+                .http
+                .get(&format!("/api/v0/packages/uploads/{upload_id}"))
+                .add_header("authorization", &auth)
+        };
+        info(&expired.id)
+            .expect_failure()
+            .await
+            .assert_status(StatusCode::NOT_FOUND);
+        info(&upload.id).await.assert_status_ok();
+        let staged = server
+            .s3
+            .head_object()
+            .bucket(&server.s3_bucket_name)
+            .key(part_key(&expired.id, 1))
+            .send()
+            .await;
+        assert!(staged.is_err(), "expired upload's part was not deleted");
+    }
+}
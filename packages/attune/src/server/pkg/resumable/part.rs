@@ -0,0 +1,107 @@
+use aws_sdk_s3::types::ChecksumAlgorithm;
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use base64::Engine;
+use bytes::Bytes;
+use digest::Digest as _;
+use md5::Md5;
+use serde::{Deserialize, Serialize};
+use sha2::Sha256;
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{
+        ServerState,
+        pkg::resumable::{part_key, query_upload},
+    },
+};
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct UploadPartResponse {
+    pub part_number: i32,
+    pub size: i64,
+    pub sha256sum: String,
+}
+
+/// Receive a part of a resumable upload. Sending a part again replaces it, so
+/// that a part whose response was lost can be retried.
+#[axum::debug_handler]
+#[instrument(skip(state, body))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path((upload_id, part_number)): Path<(String, i32)>,
+    body: Bytes,
+) -> Result<Json<UploadPartResponse>, ErrorResponse> {
+    let upload = query_upload(&state.db, &tenant_id, &upload_id).await?;
+    let Some((_, size)) = upload.part_range(part_number) else {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_PART_NUMBER",
+            format!(
+                "invalid part number {part_number}: upload has parts 1 to {}",
+                upload.part_count()
+            ),
+        ));
+    };
+    if body.len() as i64 != size {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_PART_SIZE",
+            format!(
+                "part {part_number} is {} bytes, but should be {size} bytes",
+                body.len()
+            ),
+        ));
+    }
+
+    let sha256sum = Sha256::digest(&body);
+    state
+        .s3
+        .put_object()
+        .bucket(&state.s3_bucket_name)
+        .key(part_key(&upload.id, part_number))
+        .content_md5(base64::engine::general_purpose::STANDARD.encode(Md5::digest(&body)))
+        .checksum_algorithm(ChecksumAlgorithm::Sha256)
+        .checksum_sha256(base64::engine::general_purpose::STANDARD.encode(sha256sum))
+        .body(body.into())
+        .send()
+        .await
+        .unwrap();
+
+    let sha256sum = hex::encode(sha256sum);
+    sqlx::query!(
+        r#"
+        INSERT INTO debian_repository_package_upload_part (
+            upload_id,
+            part_number,
+            size,
+            sha256sum,
+            created_at,
+            updated_at
+        )
+        VALUES ($1, $2, $3, $4, NOW(), NOW())
+        ON CONFLICT (upload_id, part_number) DO UPDATE SET
+            size = EXCLUDED.size,
+            sha256sum = EXCLUDED.sha256sum,
+            updated_at = NOW()
+        "#,
+        &upload.id,
+        part_number,
+        size,
+        &sha256sum,
+    )
+    .execute(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    Ok(Json(UploadPartResponse {
+        part_number,
+        size,
+        sha256sum,
+    }))
+}
@@ -0,0 +1,300 @@
+use axum::{
+    Json,
+    extract::{Query, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{ServerState, pkg::list::MAX_PAGE_SIZE},
+};
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct PackageSearchParams {
+    /// Text to search for, ignoring case.
+    pub query: String,
+    /// Only search this field. If not set, package names, descriptions, and
+    /// maintainers are all searched.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub field: Option<PackageSearchField>,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub repository: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub distribution: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub component: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub architecture: Option<String>,
+
+    /// Maximum number of packages to return. Defaults to
+    /// [`DEFAULT_SEARCH_LIMIT`].
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub limit: Option<i64>,
+}
+
+/// A field of a package that can be searched.
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum PackageSearchField {
+    Name,
+    Description,
+    Maintainer,
+}
+
+impl PackageSearchField {
+    fn as_str(&self) -> &'static str {
+        match self {
+            PackageSearchField::Name => "name",
+            PackageSearchField::Description => "description",
+            PackageSearchField::Maintainer => "maintainer",
+        }
+    }
+}
+
+/// How many packages a search returns, unless it sets a limit.
+pub const DEFAULT_SEARCH_LIMIT: i64 = 100;
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct PackageSearchResult {
+    pub repository: String,
+    pub distribution: String,
+    pub component: String,
+
+    pub name: String,
+    pub version: String,
+    pub architecture: String,
+
+    pub maintainer: String,
+    /// The first line of the package's description.
+    pub synopsis: String,
+
+    pub sha256sum: String,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct PackageSearchResponse {
+    /// Matching packages. Packages whose names match come first, and are
+    /// otherwise sorted by name.
+    pub packages: Vec<PackageSearchResult>,
+    /// Whether more packages matched than were returned.
+    pub truncated: bool,
+}
+
+/// Escape the wildcards of a `LIKE` pattern, so that they match themselves.
+fn escape_like(query: &str) -> String {
+    query
+        .replace('\\', "\\\\")
+        .replace('%', "\\%")
+        .replace('_', "\\_")
+}
+
+/// Search the published packages of a tenant's repositories.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    params: Query<PackageSearchParams>,
+) -> Result<Json<PackageSearchResponse>, ErrorResponse> {
+    if params.query.trim().is_empty() {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_QUERY".to_string(),
+            "search query must not be empty".to_string(),
+        ));
+    }
+    let limit = match params.limit {
+        Some(limit) if !(1..=MAX_PAGE_SIZE).contains(&limit) => {
+            return Err(ErrorResponse::new(
+                StatusCode::BAD_REQUEST,
+                "INVALID_LIMIT".to_string(),
+                format!("limit must be between 1 and {MAX_PAGE_SIZE}"),
+            ));
+        }
+        limit => limit.unwrap_or(DEFAULT_SEARCH_LIMIT),
+    };
+    let pattern = format!("%{}%", escape_like(params.query.trim()));
+
+    // Fetch one extra row to find out whether the results were truncated.
+    let mut rows = sqlx::query!(
+        r#"
+        SELECT
+            debian_repository.name AS repository,
+            debian_repository_release.distribution,
+            debian_repository_component.name AS component,
+
+            debian_repository_package.package AS name,
+            debian_repository_package.version,
+            debian_repository_package.architecture::TEXT AS "architecture!: String",
+
+            debian_repository_package.maintainer,
+            debian_repository_package.description,
+
+            debian_repository_package.sha256sum
+        FROM
+            debian_repository_package
+            JOIN debian_repository_component_package ON debian_repository_package.id = debian_repository_component_package.package_id
+            JOIN debian_repository_component ON debian_repository_component_package.component_id = debian_repository_component.id
+            JOIN debian_repository_release ON debian_repository_component.release_id = debian_repository_release.id
+            JOIN debian_repository ON debian_repository_release.repository_id = debian_repository.id
+        WHERE
+            debian_repository_package.tenant_id = $1
+            AND (
+                (($3::TEXT IS NULL OR $3 = 'name') AND debian_repository_package.package ILIKE $2)
+                OR (($3 IS NULL OR $3 = 'description') AND debian_repository_package.description ILIKE $2)
+                OR (($3 IS NULL OR $3 = 'maintainer') AND debian_repository_package.maintainer ILIKE $2)
+            )
+            AND (debian_repository.name = $4 OR $4 IS NULL)
+            AND (debian_repository_release.distribution = $5 OR $5 IS NULL)
+            AND (debian_repository_component.name = $6 OR $6 IS NULL)
+            AND (debian_repository_package.architecture = $7::debian_repository_architecture OR $7 IS NULL)
+        ORDER BY
+            debian_repository_package.package ILIKE $2 DESC,
+            debian_repository_package.package,
+            debian_repository_package.version,
+            debian_repository_package.architecture,
+            debian_repository.name,
+            debian_repository_release.distribution,
+            debian_repository_component.name
+        LIMIT $8
+        "#,
+        tenant_id.0,
+        pattern,
+        params.field.map(|field| field.as_str()),
+        // These explicit typecasts are necessary because otherwise Postgres
+        // infers these argument types using the first callsite and assumes
+        // these parameters are &str's.
+        &params.repository as &Option<String>,
+        &params.distribution as &Option<String>,
+        &params.component as &Option<String>,
+        &params.architecture as &Option<String>,
+        limit + 1,
+    )
+    .fetch_all(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    let truncated = rows.len() as i64 > limit;
+    rows.truncate(limit as usize);
+    let packages = rows
+        .into_iter()
+        .map(|pkg| PackageSearchResult {
+            repository: pkg.repository,
+            distribution: pkg.distribution,
+            component: pkg.component,
+            name: pkg.name,
+            version: pkg.version,
+            architecture: pkg.architecture,
+            maintainer: pkg.maintainer,
+            synopsis: pkg.description.lines().next().unwrap_or_default().to_string(),
+            sha256sum: pkg.sha256sum,
+        })
+        .collect();
+
+    Ok(Json(PackageSearchResponse {
+        packages,
+        truncated,
+    }))
+}
+
+#[cfg(test)]
+mod tests {
+    use sha2::{Digest as _, Sha256};
+
+    use crate::testing::{AttuneTestServer, AttuneTestServerConfig, MIGRATOR};
+
+    use super::*;
+
+    #[test]
+    fn escape_like_wildcards() {
+        assert_eq!(escape_like("lib%_foo\\"), "lib\\%\\_foo\\\\");
+        assert_eq!(escape_like("hello"), "hello");
+    }
+
+    #[test]
+    fn field_names_match_query() {
+        for field in [
+            PackageSearchField::Name,
+            PackageSearchField::Description,
+            PackageSearchField::Maintainer,
+        ] {
+            assert_eq!(
+                serde_json::to_value(field).unwrap(),
+                serde_json::Value::from(field.as_str())
+            );
+        }
+    }
+
+    #[test_log::test(sqlx::test(
+        migrator = "MIGRATOR",
+        fixtures(path = "../repo/index/fixtures", scripts("setup_multi_arch"))
+    ))]
+    async fn search_published_packages(pool: sqlx::PgPool) {
+        let api_token = "test-api-token-search_published_packages";
+        sqlx::query(
+            r#"
+            INSERT INTO attune_tenant_api_token (tenant_id, name, token, created_at, updated_at)
+            VALUES (1, 'search_published_packages', $1, NOW(), NOW())
+            "#,
+        )
+        .bind(Sha256::digest(api_token).as_slice().to_vec())
+        .execute(&pool)
+        .await
+        .unwrap();
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let search = |params: &[(&str, &str)]| {
+            let mut req = server
+                .http
+                .get("/api/v0/packages/search")
+                .add_header("authorization", format!("Bearer {api_token}"));
+            for (key, value) in params {
+                req = req.add_query_param(key, value);
+            }
+            req
+        };
+
+        // Names, descriptions, and maintainers all match, ignoring case.
+        let res = search(&[("query", "TEST-PACKAGE")])
+            .await
+            .json::<PackageSearchResponse>();
+        assert_eq!(res.packages.len(), 2);
+        assert!(!res.truncated);
+        let res = search(&[("query", "for arm64")])
+            .await
+            .json::<PackageSearchResponse>();
+        assert_eq!(res.packages.len(), 1);
+        assert_eq!(res.packages[0].architecture, "arm64");
+        assert_eq!(res.packages[0].synopsis, "Test package for arm64");
+
+        // Searches can be narrowed to one field, and filtered.
+        let res = search(&[("query", "example.com"), ("field", "name")])
+            .await
+            .json::<PackageSearchResponse>();
+        assert!(res.packages.is_empty());
+        let res = search(&[("query", "example.com"), ("architecture", "amd64")])
+            .await
+            .json::<PackageSearchResponse>();
+        assert_eq!(res.packages.len(), 1);
+        assert_eq!(res.packages[0].architecture, "amd64");
+        let res = search(&[("query", "test"), ("limit", "1")])
+            .await
+            .json::<PackageSearchResponse>();
+        assert_eq!(res.packages.len(), 1);
+        assert!(res.truncated);
+
+        // Wildcards only match themselves.
+        let res = search(&[("query", "test%package")])
+            .await
+            .json::<PackageSearchResponse>();
+        assert!(res.packages.is_empty());
+    }
+}
@@ -0,0 +1,53 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{
+        ServerState,
+        pkg::translation::{PackageTranslation, PackageTranslationResponse, query_package},
+    },
+};
+
+/// Delete a translation of a package's description.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path((sha256sum, language)): Path<(String, String)>,
+) -> Result<Json<PackageTranslationResponse>, ErrorResponse> {
+    let package = query_package(&state.db, &tenant_id, &sha256sum).await?;
+    let deleted = sqlx::query!(
+        r#"
+        DELETE FROM debian_repository_package_translation
+        WHERE package_id = $1 AND language = $2
+        "#,
+        package.id,
+        &language,
+    )
+    .execute(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+    if deleted.rows_affected() == 0 {
+        return Err(ErrorResponse::new(
+            StatusCode::NOT_FOUND,
+            "TRANSLATION_NOT_FOUND".to_string(),
+            format!("package has no {language:?} translation"),
+        ));
+    }
+
+    Ok(Json(PackageTranslationResponse {
+        result: PackageTranslation {
+            package: package.package,
+            version: package.version,
+            architecture: package.architecture,
+            language,
+            description: None,
+        },
+    }))
+}
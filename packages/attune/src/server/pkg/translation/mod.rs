@@ -0,0 +1,80 @@
+//! Translations of package descriptions, which are published in Translation
+//! indexes for repositories that have them turned on.
+//!
+//! Translations belong to a package rather than a repository, so they're
+//! published in every repository that the package is in. Indexes are only
+//! regenerated when a distribution changes, so a new translation is published
+//! the next time a package is added to or removed from its component.
+
+use axum::http::StatusCode;
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use sqlx::{Executor, Postgres};
+use tracing::instrument;
+
+use crate::api::{ErrorResponse, TenantID};
+
+pub mod delete;
+pub mod set;
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct PackageTranslation {
+    pub package: String,
+    pub version: String,
+    pub architecture: String,
+    /// The translation's language code, like `de` or `pt_BR`.
+    pub language: String,
+    /// The translated description, formatted like a control file's Description
+    /// field, or `None` if the translation was deleted.
+    pub description: Option<String>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct PackageTranslationResponse {
+    pub result: PackageTranslation,
+}
+
+struct TranslatedPackage {
+    id: i64,
+    package: String,
+    version: String,
+    architecture: String,
+}
+
+/// Load the package that a translation belongs to, or return an error if it
+/// doesn't exist.
+#[instrument(skip(executor))]
+async fn query_package<'c, E>(
+    executor: E,
+    tenant_id: &TenantID,
+    sha256sum: &str,
+) -> Result<TranslatedPackage, ErrorResponse>
+where
+    E: Executor<'c, Database = Postgres>,
+{
+    sqlx::query_as!(
+        TranslatedPackage,
+        r#"
+        SELECT
+            id,
+            package,
+            version,
+            architecture::TEXT AS "architecture!: String"
+        FROM debian_repository_package
+        WHERE tenant_id = $1 AND sha256sum = $2
+        LIMIT 1
+        "#,
+        tenant_id.0,
+        sha256sum,
+    )
+    .fetch_optional(executor)
+    .await
+    .map_err(ErrorResponse::from)?
+    .ok_or_else(|| {
+        ErrorResponse::new(
+            StatusCode::NOT_FOUND,
+            "PACKAGE_NOT_FOUND".to_string(),
+            "package not found".to_string(),
+        )
+    })
+}
@@ -0,0 +1,81 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::{format_description, validate_translation_language},
+    server::{
+        ServerState,
+        pkg::translation::{PackageTranslation, PackageTranslationResponse, query_package},
+    },
+};
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct SetPackageTranslationRequest {
+    /// The translated description as plain text. The first line is the
+    /// synopsis, and the rest is the long description.
+    pub description: String,
+}
+
+/// Add or replace a translation of a package's description.
+#[axum::debug_handler]
+#[instrument(skip(state, req))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path((sha256sum, language)): Path<(String, String)>,
+    Json(req): Json<SetPackageTranslationRequest>,
+) -> Result<Json<PackageTranslationResponse>, ErrorResponse> {
+    validate_translation_language(&language).map_err(|message| {
+        ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_LANGUAGE".to_string(),
+            message,
+        )
+    })?;
+    let description = format_description(&req.description).map_err(|message| {
+        ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_DESCRIPTION".to_string(),
+            message,
+        )
+    })?;
+
+    let package = query_package(&state.db, &tenant_id, &sha256sum).await?;
+    sqlx::query!(
+        r#"
+        INSERT INTO debian_repository_package_translation (
+            package_id,
+            language,
+            description,
+            created_at,
+            updated_at
+        )
+        VALUES ($1, $2, $3, NOW(), NOW())
+        ON CONFLICT (package_id, language) DO UPDATE SET
+            description = EXCLUDED.description,
+            updated_at = NOW()
+        "#,
+        package.id,
+        &language,
+        &description,
+    )
+    .execute(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    Ok(Json(PackageTranslationResponse {
+        result: PackageTranslation {
+            package: package.package,
+            version: package.version,
+            architecture: package.architecture,
+            language,
+            description: Some(description),
+        },
+    }))
+}
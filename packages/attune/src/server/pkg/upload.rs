@@ -1,7 +1,9 @@
+use std::fmt::Display;
+
 use aws_sdk_s3::types::ChecksumAlgorithm;
 use axum::{
     Json,
-    extract::{Multipart, State},
+    extract::{Multipart, Query, State},
     http::StatusCode,
 };
 use base64::Engine;
@@ -14,25 +16,53 @@ use digest::Digest as _;
 use md5::Md5;
 use serde::{Deserialize, Serialize};
 use sha1::Sha1;
-use sha2::Sha256;
-use sqlx::{Executor, Postgres, types::JsonValue};
+use sha2::{Sha256, Sha512};
+use sqlx::{Executor, Postgres};
 use tracing::instrument;
 
 use crate::{
-    api::{ErrorResponse, TenantID},
+    api::{Actor, ErrorResponse, TenantID},
+    apt::{Package, read_package_members, validate_member_compression},
     server::ServerState,
 };
 
+/// The largest package that the server will read into memory to store, in
+/// bytes.
+pub const MAX_PACKAGE_SIZE: i64 = 2 * 1024 * 1024 * 1024;
+
 #[derive(Serialize, Deserialize, Debug)]
 pub struct PackageUploadResponse {
     pub sha256sum: String,
+    /// The SHA512 sum of the package as the server received it, so that
+    /// clients can check that it wasn't corrupted on the way. Servers before
+    /// this was added don't return it.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub sha512sum: Option<String>,
+    /// The size of the package as the server received it, in bytes.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub size: Option<i64>,
+}
+
+#[derive(Serialize, Deserialize, Debug, Default)]
+pub struct PackageUploadParams {
+    /// Replace a package with the same name, version, and architecture but
+    /// different contents, instead of failing. The package being replaced
+    /// must not be in any component or snapshot.
+    #[serde(default)]
+    pub replace: bool,
+    /// Store the package as a udeb, which is published in its component's
+    /// `debian-installer` sub-tree. Packages whose control file has
+    /// `Package-Type: udeb` are stored as udebs even if this isn't set.
+    #[serde(default)]
+    pub udeb: bool,
 }
 
 #[axum::debug_handler]
 #[instrument(skip(state, multipart))]
 pub async fn handler(
     State(state): State<ServerState>,
-    tenant_id: TenantID,
+    actor: Actor,
+    Query(params): Query<PackageUploadParams>,
     mut multipart: Multipart,
 ) -> Result<Json<PackageUploadResponse>, ErrorResponse> {
     // TODO: We currently hold the entire package in memory. This works for now,
@@ -55,12 +85,7 @@ pub async fn handler(
         ));
     }
 
-    // Parse Debian package for control fields.
     let value = field.bytes().await.unwrap();
-    let control_file = parse_debian_package(&value).await;
-    let hashes = Hashes::from_bytes(&value);
-    let hex_hashes = hashes.hex();
-    let size = value.len() as i64;
 
     // Check that there are no more fields.
     let None = multipart.next_field().await.unwrap() else {
@@ -71,6 +96,39 @@ pub async fn handler(
         ));
     };
 
+    store_package(&state, &actor, value, &params)
+        .await
+        .map(Json)
+}
+
+/// Record a package and upload it to S3, unless it has already been stored.
+///
+/// If `replace` is set, a package with the same name, version, and
+/// architecture but different contents is deleted first, as long as no
+/// component or snapshot has it.
+///
+/// If `udeb` is set, the package is stored as a udeb. A package that was
+/// already stored keeps its type.
+#[instrument(skip(state, value))]
+pub async fn store_package(
+    state: &ServerState,
+    actor: &Actor,
+    value: Bytes,
+    params: &PackageUploadParams,
+) -> Result<PackageUploadResponse, ErrorResponse> {
+    let tenant_id = actor.tenant_id;
+
+    // Parse Debian package for control fields and installed files.
+    let (control_file, files) = parse_debian_package(&value).await?;
+    let hashes = Hashes::from_bytes(&value);
+    let hex_hashes = hashes.hex();
+    let size = value.len() as i64;
+    let uploaded = PackageUploadResponse {
+        sha256sum: hex_hashes.sha256sum.clone(),
+        sha512sum: Some(hex::encode(Sha512::digest(&value))),
+        size: Some(size),
+    };
+
     // Begin database transaction.
     let mut tx = state.db.begin().await.unwrap();
     sqlx::query!("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
@@ -83,11 +141,40 @@ pub async fn handler(
     //
     // If such a package exists AND the sha256sum is the same, we can skip the
     // rest of the handler. If such a package exists AND the sha256sum is NOT
-    // the same, then an error has occurred.
-    if let Some(shortcircuit) =
-        check_package_exists(&mut *tx, tenant_id, &control_file, &hex_hashes).await?
-    {
-        return Ok(shortcircuit);
+    // the same, then an error has occurred, unless the package is being
+    // replaced.
+    let mut replaced = None;
+    let existing = check_package_exists(
+        &mut *tx,
+        tenant_id,
+        &control_file,
+        &hex_hashes,
+        params.replace,
+    )
+    .await?;
+    match existing {
+        Some(existing) if existing.sha256sum == hex_hashes.sha256sum => {
+            // Packages uploaded before Attune recorded installed files get them
+            // when they're uploaded again, so they can be listed in Contents
+            // indexes.
+            record_package_files(&mut *tx, tenant_id, &hex_hashes.sha256sum, &files)
+                .await
+                .map_err(ErrorResponse::from)?;
+            tx.commit().await.map_err(ErrorResponse::from)?;
+            return Ok(uploaded);
+        }
+        Some(existing) => {
+            delete_replaced_package(&mut *tx, &control_file, &existing).await?;
+            // Package files are shared by every package with the same contents,
+            // in any tenant, so the replaced package's file is only deleted if
+            // no other package has it.
+            let in_use =
+                package_file_in_use(&mut *tx, &existing.s3_bucket, &existing.sha256sum).await?;
+            if !in_use {
+                replaced = Some(existing);
+            }
+        }
+        None => {}
     }
 
     // Insert the package row into the database. At this point, integrity checks
@@ -99,9 +186,14 @@ pub async fn handler(
         control_file,
         &hex_hashes,
         size,
+        params.udeb,
+        &actor.token_name,
     )
     .await
     .map_err(ErrorResponse::from)?;
+    record_package_files(&mut *tx, tenant_id, &hex_hashes.sha256sum, &files)
+        .await
+        .map_err(ErrorResponse::from)?;
 
     // Upload the package to S3.
     state
@@ -129,40 +221,112 @@ pub async fn handler(
     // the checksum header.
     tx.commit().await.map_err(ErrorResponse::from)?;
 
-    Ok(Json(PackageUploadResponse {
-        sha256sum: hex_hashes.sha256sum,
-    }))
+    // The replaced package's file was checked to be unused in the transaction.
+    if let Some(replaced) = replaced {
+        let deleted = state
+            .s3
+            .delete_object()
+            .bucket(&replaced.s3_bucket)
+            .key(format!("packages/{}", replaced.sha256sum))
+            .send()
+            .await;
+        if let Err(err) = deleted {
+            tracing::error!("Failed to delete replaced package: {err:?}");
+        }
+    }
+
+    Ok(uploaded)
 }
 
 #[instrument(skip(value))]
-async fn parse_debian_package(value: &Bytes) -> BinaryPackageControlFile<'static> {
-    let mut reader = BinaryPackageReader::new(value.as_ref()).unwrap();
-    let header_entry = reader.next_entry().unwrap().unwrap();
-    let BinaryPackageEntry::DebianBinary(_) = header_entry else {
-        panic!("expected a Debian binary package")
+async fn parse_debian_package(
+    value: &Bytes,
+) -> Result<(BinaryPackageControlFile<'static>, Vec<String>), ErrorResponse> {
+    // Check the archive members before reading them, so that a package whose
+    // archives can't be decompressed is refused with a useful error.
+    let members = read_package_members(value).map_err(|error| {
+        ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_PACKAGE",
+            format!("not a Debian package: {error}"),
+        )
+    })?;
+    validate_member_compression(&members).map_err(|error| {
+        ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "UNSUPPORTED_PACKAGE_COMPRESSION",
+            error,
+        )
+    })?;
+
+    let mut reader = BinaryPackageReader::new(value.as_ref())
+        .map_err(unreadable_archive("package"))?;
+    let header_entry = reader
+        .next_entry()
+        .transpose()
+        .map_err(unreadable_archive("package"))?;
+    let Some(BinaryPackageEntry::DebianBinary(_)) = header_entry else {
+        return Err(invalid_package("expected a debian-binary member"));
     };
-    let control_entry = reader.next_entry().unwrap().unwrap();
-    let BinaryPackageEntry::Control(mut control_reader) = control_entry else {
-        panic!("expected a control file")
+    let control_entry = reader
+        .next_entry()
+        .transpose()
+        .map_err(unreadable_archive("package"))?;
+    let Some(BinaryPackageEntry::Control(mut control_reader)) = control_entry else {
+        return Err(invalid_package("expected a control archive"));
     };
-    let mut control_entries = control_reader.entries().unwrap();
+    let mut control_entries = control_reader
+        .entries()
+        .map_err(unreadable_archive("control archive"))?;
     let control_file = loop {
-        let (_, control_tar_file) = control_entries
-            .next()
-            .unwrap()
-            .unwrap()
+        let Some(entry) = control_entries.next() else {
+            return Err(invalid_package("control archive has no control file"));
+        };
+        let (_, control_tar_file) = entry
+            .map_err(unreadable_archive("control archive"))?
             .to_control_file()
-            .unwrap();
+            .map_err(unreadable_archive("control archive"))?;
         if let ControlTarFile::Control(control_file) = control_tar_file {
             break control_file;
         }
     };
-    // TODO(#95): Parse file paths for building Contents index.
-    let data_entry = reader.next_entry().unwrap().unwrap();
-    let BinaryPackageEntry::Data(_) = data_entry else {
-        panic!("expected a data file")
+    let data_entry = reader
+        .next_entry()
+        .transpose()
+        .map_err(unreadable_archive("package"))?;
+    let Some(BinaryPackageEntry::Data(mut data_reader)) = data_entry else {
+        return Err(invalid_package("expected a data archive"));
     };
-    control_file
+    // Record the paths of installed files (but not directories) for building
+    // Contents indexes. These are listed relative to `/`, without the leading
+    // `./` of the data archive.
+    let mut files = Vec::new();
+    let entries = data_reader
+        .entries()
+        .map_err(unreadable_archive("data archive"))?;
+    for entry in entries {
+        let entry = entry.map_err(unreadable_archive("data archive"))?;
+        if entry.header().entry_type().is_dir() {
+            continue;
+        }
+        let path = entry.path().map_err(unreadable_archive("data archive"))?;
+        let path = path.to_string_lossy();
+        let path = path.trim_start_matches("./").trim_start_matches('/');
+        if !path.is_empty() {
+            files.push(path.to_string());
+        }
+    }
+    Ok((control_file, files))
+}
+
+/// The error for a package that isn't a valid Debian package.
+fn invalid_package(message: impl Into<String>) -> ErrorResponse {
+    ErrorResponse::new(StatusCode::BAD_REQUEST, "INVALID_PACKAGE", message)
+}
+
+/// The error for a package whose `archive` can't be read, for `map_err`.
+fn unreadable_archive<E: Display>(archive: &'static str) -> impl Fn(E) -> ErrorResponse {
+    move |error| invalid_package(format!("could not read {archive}: {error}"))
 }
 
 #[derive(Debug)]
@@ -201,19 +365,33 @@ struct HashesHex {
     md5sum: String,
 }
 
+/// A package with the same name, version, and architecture as one being
+/// uploaded.
+#[derive(Debug)]
+struct ExistingPackage {
+    id: i64,
+    s3_bucket: String,
+    sha256sum: String,
+}
+
+/// Find a package with the same name, version, and architecture as one being
+/// uploaded. A package with different contents is only returned if it's being
+/// replaced; otherwise, it's an error.
 #[instrument(skip(executor, control_file))]
 async fn check_package_exists<'c, E>(
     executor: E,
     tenant_id: TenantID,
     control_file: &BinaryPackageControlFile<'static>,
     hashes: &HashesHex,
-) -> Result<Option<Json<PackageUploadResponse>>, ErrorResponse>
+    replace: bool,
+) -> Result<Option<ExistingPackage>, ErrorResponse>
 where
     E: Executor<'c, Database = Postgres>,
 {
-    let existing = sqlx::query!(
+    let existing = sqlx::query_as!(
+        ExistingPackage,
         r#"
-        SELECT id, sha256sum
+        SELECT id, s3_bucket, sha256sum
         FROM debian_repository_package
         WHERE
             tenant_id = $1
@@ -230,20 +408,127 @@ where
     .fetch_optional(executor)
     .await
     .map_err(ErrorResponse::from)?;
-    if let Some(existing) = existing {
-        if existing.sha256sum == hashes.sha256sum {
-            return Ok(Some(Json(PackageUploadResponse {
-                sha256sum: existing.sha256sum,
-            })));
-        } else {
-            return Err(ErrorResponse::new(
+    match existing {
+        Some(existing) if existing.sha256sum != hashes.sha256sum && !replace => {
+            Err(ErrorResponse::new(
                 StatusCode::BAD_REQUEST,
                 "PACKAGE_ALREADY_EXISTS",
-                "package already exists",
-            ));
+                format!(
+                    "package already exists: {} was already uploaded with SHA256 sum {}",
+                    describe_package(control_file),
+                    existing.sha256sum
+                ),
+            ))
         }
+        existing => Ok(existing),
     }
-    Ok(None)
+}
+
+/// Delete a package that's being replaced, unless a component or snapshot
+/// still has it.
+#[instrument(skip(executor, control_file))]
+async fn delete_replaced_package<'c, E>(
+    executor: E,
+    control_file: &BinaryPackageControlFile<'static>,
+    existing: &ExistingPackage,
+) -> Result<(), ErrorResponse>
+where
+    E: Executor<'c, Database = Postgres>,
+{
+    let deleted = sqlx::query!(
+        r#"
+        DELETE FROM debian_repository_package p
+        WHERE p.id = $1
+        AND NOT EXISTS (
+            SELECT 1 FROM debian_repository_component_package cp
+            WHERE cp.package_id = p.id
+        )
+        AND NOT EXISTS (
+            SELECT 1 FROM debian_repository_snapshot_package sp
+            WHERE sp.package_id = p.id
+        )
+        "#,
+        existing.id,
+    )
+    .execute(executor)
+    .await
+    .map_err(ErrorResponse::from)?;
+    if deleted.rows_affected() == 0 {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "PACKAGE_PUBLISHED",
+            format!(
+                "{} can't be replaced while it's in a component or snapshot; remove it first",
+                describe_package(control_file)
+            ),
+        ));
+    }
+    Ok(())
+}
+
+/// Whether any package, in any tenant, is stored in the file at `sha256sum` in
+/// `s3_bucket`.
+#[instrument(skip(executor))]
+async fn package_file_in_use<'c, E>(
+    executor: E,
+    s3_bucket: &str,
+    sha256sum: &str,
+) -> Result<bool, ErrorResponse>
+where
+    E: Executor<'c, Database = Postgres>,
+{
+    sqlx::query_scalar!(
+        r#"
+        SELECT EXISTS (
+            SELECT 1 FROM debian_repository_package
+            WHERE s3_bucket = $1 AND sha256sum = $2
+        ) AS "in_use!"
+        "#,
+        s3_bucket,
+        sha256sum,
+    )
+    .fetch_one(executor)
+    .await
+    .map_err(ErrorResponse::from)
+}
+
+/// Describe a package by its name, version, and architecture for messages.
+fn describe_package(control_file: &BinaryPackageControlFile<'static>) -> String {
+    format!(
+        "{} {} ({})",
+        control_file.package().unwrap(),
+        control_file.version().unwrap(),
+        control_file.architecture().unwrap()
+    )
+}
+
+/// Record the files that a package installs, unless they're already recorded.
+#[instrument(skip(executor, files))]
+async fn record_package_files<'c, E>(
+    executor: E,
+    tenant_id: TenantID,
+    sha256sum: &str,
+    files: &[String],
+) -> Result<(), sqlx::Error>
+where
+    E: Executor<'c, Database = Postgres>,
+{
+    sqlx::query!(
+        r#"
+        UPDATE debian_repository_package
+        SET files = $3, updated_at = NOW()
+        WHERE
+            tenant_id = $1
+            AND sha256sum = $2
+            AND cardinality(files) = 0
+        "#,
+        tenant_id.0,
+        sha256sum,
+        files,
+    )
+    .execute(executor)
+    .await?;
+    Ok(())
 }
 
 #[instrument(skip(executor, control_file))]
@@ -254,6 +539,8 @@ async fn insert_package<'c, E>(
     control_file: BinaryPackageControlFile<'static>,
     hashes: &HashesHex,
     size: i64,
+    udeb: bool,
+    uploaded_by: &str,
 ) -> Result<i64, sqlx::Error>
 where
     E: Executor<'c, Database = Postgres>,
@@ -265,13 +552,8 @@ where
     let md5sum = &hashes.md5sum;
     let sha1sum = &hashes.sha1sum;
     let sha256sum = &hashes.sha256sum;
-    let paragraph = JsonValue::Object(
-        control_file
-            .as_str_hash_map()
-            .into_iter()
-            .map(|(k, v)| (k.to_string(), JsonValue::String(v.to_string())))
-            .collect(),
-    );
+    let paragraph = Package::paragraph_from_control_file(&control_file);
+    let udeb = udeb || control_file.field_str("Package-Type") == Some("udeb");
 
     // Run insertion.
     let inserted = sqlx::query!(
@@ -304,6 +586,9 @@ where
             sha1sum,
             sha256sum,
 
+            uploaded_by,
+            udeb,
+
             created_at,
             updated_at
         )
@@ -335,6 +620,9 @@ where
             $20,
             $21,
 
+            $22,
+            $23,
+
             NOW(),
             NOW()
         )
@@ -367,6 +655,8 @@ where
         md5sum,
         sha1sum,
         sha256sum,
+        uploaded_by,
+        udeb,
     )
     .fetch_one(executor)
     .await?;
@@ -425,7 +715,7 @@ mod tests {
             .execute(&mut *tx)
             .await
             .unwrap();
-        let existing = check_package_exists(&mut *tx, tenant_id, &control_file, &hashes_a)
+        let existing = check_package_exists(&mut *tx, tenant_id, &control_file, &hashes_a, false)
             .await
             .unwrap();
         assert!(existing.is_none());
@@ -436,6 +726,9 @@ mod tests {
             control_file.clone(),
             &hashes_a,
             42,
+            false,
+            None,
+            "test",
         )
         .await
         .unwrap();
@@ -453,10 +746,18 @@ mod tests {
             .execute(&mut *tx)
             .await
             .unwrap();
-        let existing = check_package_exists(&mut *tx, tenant_id, &control_file, &hashes_b).await;
+        let existing =
+            check_package_exists(&mut *tx, tenant_id, &control_file, &hashes_b, false).await;
         debug!(?existing, "check existing");
         let err_status = existing.err().unwrap().status;
         assert!(err_status != StatusCode::CONFLICT && err_status != StatusCode::OK);
+
+        // Unless the package is being replaced.
+        let existing = check_package_exists(&mut *tx, tenant_id, &control_file, &hashes_b, true)
+            .await
+            .unwrap()
+            .expect("package should exist");
+        assert_eq!(existing.sha256sum, hashes_a.sha256sum);
     }
 
     #[sqlx::test(migrator = "crate::testing::MIGRATOR")]
@@ -502,6 +803,190 @@ mod tests {
         );
     }
 
+    /// Packages with zstd-compressed archives, like recent Ubuntu packages, are
+    /// read like any other, and packages with unsupported compressions are
+    /// refused with an error that says why.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR")]
+    #[test_log::test]
+    async fn upload_compressed_members(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        const TEST_NAME: &str = "upload_compressed_members";
+        let (tenant_id, api_token) = server.create_test_tenant(TEST_NAME).await;
+
+        let package_file = fixtures::build_test_package("zst");
+        let upload = MultipartForm::new().add_part("file", Part::bytes(package_file));
+        let res = server
+            .http
+            .post("/api/v0/packages")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .multipart(upload)
+            .await;
+        assert!(
+            res.status_code().is_success(),
+            "Package upload failed with status: {}",
+            res.status_code()
+        );
+        let files = sqlx::query_scalar!(
+            r#"
+            SELECT files AS "files!"
+            FROM debian_repository_package
+            WHERE tenant_id = $1 AND package = 'attune-test-compression'
+            "#,
+            tenant_id.0,
+        )
+        .fetch_one(&server.db)
+        .await
+        .unwrap();
+        assert_eq!(files, ["usr/share/doc/attune-test-compression/copyright"]);
+
+        let package_file = fixtures::build_test_package("bz2");
+        let upload = MultipartForm::new().add_part("file", Part::bytes(package_file));
+        let res = server
+            .http
+            .post("/api/v0/packages")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .multipart(upload)
+            .await;
+        assert_eq!(res.status_code(), StatusCode::BAD_REQUEST);
+        let error = res.json::<ErrorResponse>();
+        assert_eq!(error.error, "UNSUPPORTED_PACKAGE_COMPRESSION");
+        assert!(
+            error.message.contains("bzip2, which is not supported"),
+            "unexpected error: {}",
+            error.message
+        );
+    }
+
+    /// Package files are shared by every package with the same contents, so a
+    /// replaced package's file is kept while another tenant's package has it.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR")]
+    #[test_log::test]
+    async fn replace_keeps_shared_package_file(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_, first_token) = server
+            .create_test_tenant("replace_keeps_shared_package_file/first")
+            .await;
+        let (_, second_token) = server
+            .create_test_tenant("replace_keeps_shared_package_file/second")
+            .await;
+
+        // These have the same name, version, and architecture, but different
+        // contents.
+        let original = fixtures::build_test_package("gz");
+        let replacement = fixtures::build_test_package("");
+        let key = format!("packages/{}", hex::encode(Sha256::digest(&original)));
+        let upload = async |api_token: &str, package: &[u8], path: &str| {
+            let upload = MultipartForm::new().add_part("file", Part::bytes(package.to_vec()));
+            let res = server
+                .http
+                .post(path)
+                .add_header("authorization", format!("Bearer {api_token}"))
+                .multipart(upload)
+                .await;
+            res.assert_status_ok();
+        };
+        let original_exists = async || {
+            server
+                .s3
+                .head_object()
+                .bucket(&server.s3_bucket_name)
+                .key(&key)
+                .send()
+                .await
+                .is_ok()
+        };
+
+        upload(&first_token, &original, "/api/v0/packages").await;
+        upload(&second_token, &original, "/api/v0/packages").await;
+        upload(&first_token, &replacement, "/api/v0/packages?replace=true").await;
+        assert!(original_exists().await, "shared package file was deleted");
+
+        // Once no package has the file, it's deleted.
+        upload(&second_token, &replacement, "/api/v0/packages?replace=true").await;
+        assert!(!original_exists().await, "unused package file was kept");
+    }
+
+    /// Packages whose data archive can't be read are refused as invalid,
+    /// instead of failing the upload with a server error.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR")]
+    #[test_log::test]
+    async fn upload_corrupt_data_archive(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        const TEST_NAME: &str = "upload_corrupt_data_archive";
+        let (_tenant_id, api_token) = server.create_test_tenant(TEST_NAME).await;
+
+        // The uncompressed data archive is the package's last member, so its
+        // first header's checksum can be corrupted in place.
+        let mut package_file = fixtures::build_test_package("");
+        let members = read_package_members(&package_file).unwrap();
+        let data_start = package_file.len() - members[2].size as usize;
+        package_file[data_start + 148] = b'7';
+
+        let upload = MultipartForm::new().add_part("file", Part::bytes(package_file));
+        let res = server
+            .http
+            .post("/api/v0/packages")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .multipart(upload)
+            .await;
+        assert_eq!(res.status_code(), StatusCode::BAD_REQUEST);
+        let error = res.json::<ErrorResponse>();
+        assert_eq!(error.error, "INVALID_PACKAGE");
+        assert!(
+            error.message.contains("could not read data archive"),
+            "unexpected error: {}",
+            error.message
+        );
+    }
+
+    /// Packages that are missing an archive are refused as invalid, instead of
+    /// failing the upload with a server error.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR")]
+    #[test_log::test]
+    async fn upload_package_without_data_archive(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        const TEST_NAME: &str = "upload_package_without_data_archive";
+        let (_tenant_id, api_token) = server.create_test_tenant(TEST_NAME).await;
+
+        // Drop the data archive, which is the package's last member, along
+        // with its 60-byte header.
+        let mut package_file = fixtures::build_test_package("");
+        let members = read_package_members(&package_file).unwrap();
+        package_file.truncate(package_file.len() - members[2].size as usize - 60);
+
+        let upload = MultipartForm::new().add_part("file", Part::bytes(package_file));
+        let res = server
+            .http
+            .post("/api/v0/packages")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .multipart(upload)
+            .await;
+        assert_eq!(res.status_code(), StatusCode::BAD_REQUEST);
+        let error = res.json::<ErrorResponse>();
+        assert_eq!(error.error, "INVALID_PACKAGE");
+        assert_eq!(error.message, "expected a data archive");
+    }
+
     /// If a duplicate package (i.e. one with the same headers and same content)
     /// is uploaded concurrently, the API should either not fail or fail with a
     /// 409 Conflict status code so that the CLI properly handles the error.
@@ -549,11 +1034,11 @@ mod tests {
             .unwrap();
 
         // Do concurrent SELECT queries.
-        let existing_a = check_package_exists(&mut *tx_a, tenant_id, &control_file, &hashes)
+        let existing_a = check_package_exists(&mut *tx_a, tenant_id, &control_file, &hashes, false)
             .await
             .unwrap();
         assert!(existing_a.is_none());
-        let existing_b = check_package_exists(&mut *tx_b, tenant_id, &control_file, &hashes)
+        let existing_b = check_package_exists(&mut *tx_b, tenant_id, &control_file, &hashes, false)
             .await
             .unwrap();
         assert!(existing_b.is_none());
@@ -566,6 +1051,9 @@ mod tests {
             control_file.clone(),
             &hashes,
             42,
+            false,
+            None,
+            "test",
         )
         .await
         .map_err(ErrorResponse::from);
@@ -585,6 +1073,9 @@ mod tests {
             control_file,
             &hashes,
             42,
+            false,
+            None,
+            "test",
         )
         .await
         .map_err(ErrorResponse::from);
@@ -0,0 +1,398 @@
+//! Upstream (vendor) signatures, which record where a re-published package
+//! came from.
+//!
+//! The API server verifies a package's upstream signature against the
+//! vendor's key itself before recording it as the package's provenance, so a
+//! recorded signature doesn't depend on the client having checked it.
+
+use std::borrow::Cow;
+
+use aws_sdk_s3::error::DisplayErrorContext;
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use base64::Engine as _;
+use pgp::{
+    composed::{Deserializable as _, SignedPublicKey, StandaloneSignature},
+    types::KeyDetails as _,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::{debug, instrument};
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::{origin_signed_content, read_origin_signature},
+    server::ServerState,
+};
+
+/// How a package's upstream (vendor) signature was made.
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum UpstreamSignatureKind {
+    /// A detached signature over the package file.
+    Detached,
+    /// A `debsig` signature embedded in the package as its `_gpgorigin`
+    /// member.
+    Debsig,
+}
+
+impl UpstreamSignatureKind {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            UpstreamSignatureKind::Detached => "detached",
+            UpstreamSignatureKind::Debsig => "debsig",
+        }
+    }
+}
+
+/// A package's upstream signature, as verified by the API server.
+#[derive(Serialize, Deserialize, JsonSchema, Debug, Clone, PartialEq, Eq)]
+pub struct UpstreamSignature {
+    /// How the signature was made: `detached` or `debsig`.
+    pub kind: String,
+    /// The fingerprint of the vendor key that made the signature, as
+    /// uppercase hex.
+    pub key_fingerprint: String,
+}
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct VerifyUpstreamSignatureRequest {
+    /// The vendor's armored OpenPGP public key.
+    pub key: String,
+    /// The vendor's detached signature over the package file, armored or
+    /// binary, encoded as base64. If not set, the package's embedded `debsig`
+    /// signature is verified instead.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub signature: Option<String>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct VerifyUpstreamSignatureResponse {
+    pub upstream_signature: UpstreamSignature,
+}
+
+/// Verify a stored package's upstream signature, and record it as the
+/// package's provenance.
+///
+/// A package's recorded signature is replaced each time another one is
+/// verified.
+#[axum::debug_handler]
+#[instrument(skip(state, req))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path(sha256sum): Path<String>,
+    Json(req): Json<VerifyUpstreamSignatureRequest>,
+) -> Result<Json<VerifyUpstreamSignatureResponse>, ErrorResponse> {
+    let detached = req
+        .signature
+        .map(|signature| base64::engine::general_purpose::STANDARD.decode(signature))
+        .transpose()
+        .map_err(|error| {
+            ErrorResponse::new(
+                StatusCode::BAD_REQUEST,
+                "INVALID_UPSTREAM_SIGNATURE".to_string(),
+                format!("signature is not valid base64: {error}"),
+            )
+        })?;
+
+    let pkg = sqlx::query!(
+        r#"
+        SELECT s3_bucket
+        FROM debian_repository_package
+        WHERE tenant_id = $1 AND sha256sum = $2
+        LIMIT 1
+        "#,
+        tenant_id.0,
+        sha256sum,
+    )
+    .fetch_optional(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+    let Some(pkg) = pkg else {
+        return Err(ErrorResponse::new(
+            StatusCode::NOT_FOUND,
+            "PACKAGE_NOT_FOUND".to_string(),
+            "package not found".to_string(),
+        ));
+    };
+
+    let object = state
+        .s3
+        .get_object()
+        .bucket(&pkg.s3_bucket)
+        .key(format!("packages/{sha256sum}"))
+        .send()
+        .await
+        .map_err(|error| {
+            ErrorResponse::new(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "PACKAGE_READ_FAILED".to_string(),
+                format!("could not read package: {}", DisplayErrorContext(&error)),
+            )
+        })?;
+    let content = object.body.collect().await.map_err(|error| {
+        ErrorResponse::new(
+            StatusCode::INTERNAL_SERVER_ERROR,
+            "PACKAGE_READ_FAILED".to_string(),
+            format!("could not read package: {error}"),
+        )
+    })?;
+
+    let upstream_signature =
+        verify_upstream_signature(&req.key, &content.into_bytes(), detached.as_deref()).map_err(
+            |message| {
+                ErrorResponse::new(
+                    StatusCode::BAD_REQUEST,
+                    "UPSTREAM_SIGNATURE_VERIFICATION_FAILED".to_string(),
+                    message,
+                )
+            },
+        )?;
+    debug!(?upstream_signature, "verified upstream signature");
+
+    sqlx::query!(
+        r#"
+        UPDATE debian_repository_package
+        SET
+            upstream_signature = $3,
+            upstream_key_fingerprint = $4,
+            updated_at = NOW()
+        WHERE tenant_id = $1 AND sha256sum = $2
+        "#,
+        tenant_id.0,
+        sha256sum,
+        upstream_signature.kind,
+        upstream_signature.key_fingerprint,
+    )
+    .execute(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    Ok(Json(VerifyUpstreamSignatureResponse { upstream_signature }))
+}
+
+/// Verify a vendor's signature over a package against the vendor's armored
+/// public key.
+///
+/// A detached signature is verified over the package file. Otherwise, the
+/// package's embedded `debsig` signature (its `_gpgorigin` member) is verified
+/// over the package's archive members. The signature may be made by the
+/// vendor key's primary key or any of its subkeys.
+pub fn verify_upstream_signature(
+    key: &str,
+    package: &[u8],
+    detached: Option<&[u8]>,
+) -> Result<UpstreamSignature, String> {
+    let (key, _headers) = SignedPublicKey::from_string(key)
+        .map_err(|error| format!("could not parse upstream key: {error}"))?;
+    key.verify()
+        .map_err(|error| format!("invalid upstream key self-signatures: {error}"))?;
+
+    let (kind, signature, signed) = match detached {
+        Some(signature) => (
+            UpstreamSignatureKind::Detached,
+            Cow::Borrowed(signature),
+            Cow::Borrowed(package),
+        ),
+        None => {
+            let signature = read_origin_signature(package)?
+                .ok_or_else(|| String::from("package has no debsig signature"))?;
+            let signed = origin_signed_content(package)?;
+            (
+                UpstreamSignatureKind::Debsig,
+                Cow::Owned(signature),
+                Cow::Owned(signed),
+            )
+        }
+    };
+    let parsed = if signature.starts_with(b"-----BEGIN") {
+        let armored = std::str::from_utf8(&signature)
+            .map_err(|_| String::from("armored signature contained invalid characters"))?;
+        StandaloneSignature::from_string(armored).map(|(signature, _headers)| signature)
+    } else {
+        StandaloneSignature::from_bytes(&*signature)
+    };
+    let signature = parsed
+        .map_err(|error| format!("could not parse {} signature: {error}", kind.as_str()))?;
+
+    let made_by_key = signature.verify(&key, &signed).is_ok()
+        || key
+            .public_subkeys
+            .iter()
+            .any(|subkey| signature.verify(&subkey.key, &signed).is_ok());
+    if !made_by_key {
+        return Err(format!(
+            "{} signature does not match package content or was not made by the upstream key",
+            kind.as_str()
+        ));
+    }
+    Ok(UpstreamSignature {
+        kind: kind.as_str().to_string(),
+        key_fingerprint: hex::encode_upper(key.fingerprint().as_bytes()),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use std::iter::once;
+
+    use axum_test::multipart::{MultipartForm, Part};
+    use gpgme::ExportMode;
+
+    use crate::{
+        server::pkg::{info::PackageInfoResponse, upload::PackageUploadResponse},
+        testing::{
+            AttuneTestServer, AttuneTestServerConfig, MIGRATOR, fixtures, gpg_key_id,
+            with_origin_signature,
+        },
+    };
+
+    use super::*;
+
+    /// Sign content with a new key, returning the key's fingerprint, the
+    /// armored detached signature, and the armored public key.
+    async fn sign(content: &[u8]) -> (String, String, String) {
+        let (key_id, mut gpg, _dir) = gpg_key_id().await.expect("failed to create GPG key");
+        let key = gpg
+            .find_secret_keys(vec![key_id.clone()])
+            .unwrap()
+            .next()
+            .unwrap()
+            .unwrap();
+        gpg.add_signer(&key).unwrap();
+
+        let mut signature = Vec::new();
+        gpg.sign_detached(content, &mut signature)
+            .expect("could not detach sign content");
+        let mut public_key = Vec::new();
+        gpg.export_keys(once(&key), ExportMode::empty(), &mut public_key)
+            .expect("could not export key");
+        (
+            key_id,
+            String::from_utf8(signature).unwrap(),
+            String::from_utf8(public_key).unwrap(),
+        )
+    }
+
+    #[test_log::test(tokio::test)]
+    async fn verify_detached_signature() {
+        let package = fixtures::TEST_PACKAGE_AMD64;
+        let (key_id, signature, key) = sign(package).await;
+
+        let verified = verify_upstream_signature(&key, package, Some(signature.as_bytes()))
+            .expect("signature should verify");
+        assert_eq!(
+            verified,
+            UpstreamSignature {
+                kind: String::from("detached"),
+                key_fingerprint: key_id.to_ascii_uppercase(),
+            }
+        );
+
+        // The same signature must not verify different package contents, or
+        // verify against another key.
+        let other = fixtures::TEST_PACKAGE_ARM64;
+        assert!(verify_upstream_signature(&key, other, Some(signature.as_bytes())).is_err());
+        let (_, _, other_key) = sign(package).await;
+        assert!(
+            verify_upstream_signature(&other_key, package, Some(signature.as_bytes())).is_err()
+        );
+    }
+
+    #[test_log::test(tokio::test)]
+    async fn verify_debsig_signature() {
+        let signed = origin_signed_content(fixtures::TEST_PACKAGE_AMD64).unwrap();
+        let (key_id, signature, key) = sign(&signed).await;
+
+        let error =
+            verify_upstream_signature(&key, fixtures::TEST_PACKAGE_AMD64, None).unwrap_err();
+        assert_eq!(error, "package has no debsig signature");
+
+        let package = with_origin_signature(fixtures::TEST_PACKAGE_AMD64, signature.as_bytes());
+        let verified =
+            verify_upstream_signature(&key, &package, None).expect("signature should verify");
+        assert_eq!(
+            verified,
+            UpstreamSignature {
+                kind: String::from("debsig"),
+                key_fingerprint: key_id.to_ascii_uppercase(),
+            }
+        );
+
+        // The signature must not verify another package's members.
+        let package = with_origin_signature(fixtures::TEST_PACKAGE_ARM64, signature.as_bytes());
+        assert!(verify_upstream_signature(&key, &package, None).is_err());
+    }
+
+    /// Only a signature that the server verified is recorded as the package's
+    /// provenance.
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn record_verified_upstream_signature(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_tenant_id, api_token) = server
+            .create_test_tenant("record_verified_upstream_signature")
+            .await;
+
+        let upload = MultipartForm::new()
+            .add_part("file", Part::bytes(fixtures::TEST_PACKAGE_AMD64.to_vec()));
+        let uploaded = server
+            .http
+            .post("/api/v0/packages")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .multipart(upload)
+            .await
+            .json::<PackageUploadResponse>();
+        let verify = |sha256sum: &str, key: &str, signature: &str| {
+            server
+                .http
+                .post(&format!("/api/v0/packages/{sha256sum}/upstream-signature"))
+                .add_header("authorization", format!("Bearer {api_token}"))
+                .json(&VerifyUpstreamSignatureRequest {
+                    key: key.to_string(),
+                    signature: Some(base64::engine::general_purpose::STANDARD.encode(signature)),
+                })
+        };
+        let show = || {
+            server
+                .http
+                .get(&format!("/api/v0/packages/{}", uploaded.sha256sum))
+                .add_header("authorization", format!("Bearer {api_token}"))
+        };
+
+        // A signature over other content is refused, and nothing is recorded.
+        let (_, wrong_signature, key) = sign(fixtures::TEST_PACKAGE_ARM64).await;
+        let res = verify(&uploaded.sha256sum, &key, &wrong_signature).await;
+        res.assert_status(StatusCode::BAD_REQUEST);
+        assert_eq!(
+            res.json::<ErrorResponse>().error,
+            "UPSTREAM_SIGNATURE_VERIFICATION_FAILED"
+        );
+        let info = show().await.json::<PackageInfoResponse>();
+        assert_eq!(info.upstream_signature, None);
+
+        let (key_id, signature, key) = sign(fixtures::TEST_PACKAGE_AMD64).await;
+        let res = verify(&uploaded.sha256sum, &key, &signature).await;
+        res.assert_status_ok();
+        let expected = UpstreamSignature {
+            kind: String::from("detached"),
+            key_fingerprint: key_id.to_ascii_uppercase(),
+        };
+        let verified = res.json::<VerifyUpstreamSignatureResponse>();
+        assert_eq!(verified.upstream_signature, expected);
+        let info = show().await.json::<PackageInfoResponse>();
+        assert_eq!(info.upstream_signature, Some(expected));
+
+        let res = verify(&"0".repeat(64), &key, &signature).await;
+        res.assert_status(StatusCode::NOT_FOUND);
+        assert_eq!(res.json::<ErrorResponse>().error, "PACKAGE_NOT_FOUND");
+    }
+}
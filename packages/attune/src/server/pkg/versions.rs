@@ -0,0 +1,256 @@
+use std::cmp::Reverse;
+
+use axum::{
+    Json,
+    extract::{Query, State},
+};
+use debian_packaging::package_version::PackageVersion;
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use time::OffsetDateTime;
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{ServerState, pkg::info::PackagePublication},
+};
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct PackageVersionsParams {
+    /// The name of the package.
+    pub name: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub architecture: Option<String>,
+    /// Only list publications in this repository. Versions that aren't
+    /// published in it are still listed, as staged.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub repository: Option<String>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct StoredPackageVersion {
+    pub version: String,
+    pub architecture: String,
+    pub sha256sum: String,
+    #[serde(with = "time::serde::rfc3339")]
+    #[schemars(with = "String")]
+    pub uploaded_at: OffsetDateTime,
+    /// The name of the API token that uploaded the package, if it was
+    /// recorded.
+    pub uploaded_by: Option<String>,
+    /// The components that publish this version, sorted by repository,
+    /// distribution, and component. A version that was uploaded but isn't
+    /// published anywhere is staged, and can be published with `attune apt
+    /// pkg copy`.
+    pub publications: Vec<PackagePublication>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct PackageVersionsResponse {
+    pub name: String,
+    /// Every stored version of the package, sorted by architecture and then
+    /// from newest to oldest version.
+    pub versions: Vec<StoredPackageVersion>,
+}
+
+/// List every stored version of a package, whether or not it's published.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Query(params): Query<PackageVersionsParams>,
+) -> Result<Json<PackageVersionsResponse>, ErrorResponse> {
+    let rows = sqlx::query!(
+        r#"
+        SELECT
+            debian_repository_package.id,
+            debian_repository_package.version,
+            debian_repository_package.architecture::TEXT AS "architecture!: String",
+            debian_repository_package.sha256sum,
+            debian_repository_package.created_at,
+            debian_repository_package.uploaded_by,
+            debian_repository.name AS "repository?",
+            debian_repository_release.distribution AS "distribution?",
+            debian_repository_component.name AS "component?",
+            debian_repository_component_package.filename AS "filename?"
+        FROM
+            debian_repository_package
+            LEFT JOIN debian_repository_component_package ON debian_repository_component_package.package_id = debian_repository_package.id
+            LEFT JOIN debian_repository_component ON debian_repository_component_package.component_id = debian_repository_component.id
+            LEFT JOIN debian_repository_release ON debian_repository_component.release_id = debian_repository_release.id
+            LEFT JOIN debian_repository ON debian_repository_release.repository_id = debian_repository.id
+        WHERE
+            debian_repository_package.tenant_id = $1
+            AND debian_repository_package.package = $2
+            AND (debian_repository_package.architecture = $3::debian_repository_architecture OR $3 IS NULL)
+        ORDER BY
+            debian_repository_package.id,
+            debian_repository.name,
+            debian_repository_release.distribution,
+            debian_repository_component.name
+        "#,
+        tenant_id.0,
+        params.name,
+        &params.architecture as &Option<String>,
+    )
+    .fetch_all(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    // Rows are ordered by package, so each package's publications are
+    // adjacent.
+    let mut versions = Vec::<(i64, StoredPackageVersion)>::new();
+    for row in rows {
+        if versions.last().is_none_or(|(id, _)| *id != row.id) {
+            versions.push((
+                row.id,
+                StoredPackageVersion {
+                    version: row.version,
+                    architecture: row.architecture,
+                    sha256sum: row.sha256sum,
+                    uploaded_at: row.created_at,
+                    uploaded_by: row.uploaded_by,
+                    publications: Vec::new(),
+                },
+            ));
+        }
+        let publication = match (row.repository, row.distribution, row.component, row.filename) {
+            (Some(repository), Some(distribution), Some(component), Some(filename)) => {
+                PackagePublication {
+                    repository,
+                    distribution,
+                    component,
+                    filename,
+                }
+            }
+            _ => continue,
+        };
+        if params
+            .repository
+            .as_ref()
+            .is_some_and(|repository| *repository != publication.repository)
+        {
+            continue;
+        }
+        let (_, version) = versions.last_mut().expect("version was just pushed");
+        version.publications.push(publication);
+    }
+
+    let mut versions = versions
+        .into_iter()
+        .map(|(_, version)| version)
+        .collect::<Vec<_>>();
+    sort_versions(&mut versions);
+    Ok(Json(PackageVersionsResponse {
+        name: params.name,
+        versions,
+    }))
+}
+
+/// Sort versions by architecture, and then from newest to oldest version.
+/// Versions that can't be parsed sort last, by when they were uploaded.
+fn sort_versions(versions: &mut [StoredPackageVersion]) {
+    versions.sort_by_cached_key(|version| {
+        (
+            version.architecture.clone(),
+            Reverse(PackageVersion::parse(&version.version).ok()),
+            Reverse(version.uploaded_at),
+        )
+    });
+}
+
+#[cfg(test)]
+mod tests {
+    use axum::http::StatusCode;
+    use axum_test::multipart::{MultipartForm, Part};
+
+    use crate::{
+        server::pkg::{info::PackageInfoResponse, upload::PackageUploadResponse},
+        testing::{AttuneTestServer, AttuneTestServerConfig, MIGRATOR, fixtures},
+    };
+
+    use super::*;
+
+    #[test]
+    fn sorts_newest_version_first() {
+        let version = |architecture: &str, version: &str| StoredPackageVersion {
+            version: String::from(version),
+            architecture: String::from(architecture),
+            sha256sum: String::new(),
+            uploaded_at: OffsetDateTime::UNIX_EPOCH,
+            uploaded_by: None,
+            publications: Vec::new(),
+        };
+        let mut versions = vec![
+            version("arm64", "1.0"),
+            version("amd64", "1.9"),
+            version("amd64", "1:0.1"),
+            version("amd64", "1.10"),
+        ];
+        sort_versions(&mut versions);
+        let sorted = versions
+            .iter()
+            .map(|version| (version.architecture.as_str(), version.version.as_str()))
+            .collect::<Vec<_>>();
+        assert_eq!(
+            sorted,
+            [
+                ("amd64", "1:0.1"),
+                ("amd64", "1.10"),
+                ("amd64", "1.9"),
+                ("arm64", "1.0"),
+            ]
+        );
+    }
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn list_staged_version(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_tenant_id, api_token) = server.create_test_tenant("list_staged_version").await;
+
+        let upload = MultipartForm::new()
+            .add_part("file", Part::bytes(fixtures::TEST_PACKAGE_AMD64.to_vec()));
+        let uploaded = server
+            .http
+            .post("/api/v0/packages")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .multipart(upload)
+            .await
+            .json::<PackageUploadResponse>();
+        let info = server
+            .http
+            .get(&format!("/api/v0/packages/{}", uploaded.sha256sum))
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .await
+            .json::<PackageInfoResponse>();
+
+        let res = server
+            .http
+            .get("/api/v0/packages/versions")
+            .add_query_param("name", &info.package)
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .await;
+        res.assert_status(StatusCode::OK);
+        let versions = res.json::<PackageVersionsResponse>();
+        assert_eq!(versions.name, info.package);
+        assert_eq!(versions.versions.len(), 1);
+        assert_eq!(versions.versions[0].sha256sum, uploaded.sha256sum);
+        assert_eq!(versions.versions[0].version, info.version);
+        assert!(versions.versions[0].publications.is_empty());
+
+        let res = server
+            .http
+            .get("/api/v0/packages/versions")
+            .add_query_param("name", &info.package)
+            .add_query_param("architecture", "arm64")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .await;
+        assert!(res.json::<PackageVersionsResponse>().versions.is_empty());
+    }
+}
@@ -1,4 +1,5 @@
 use axum::{Json, extract::State};
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 use sha2::{Digest as _, Sha256};
 use tracing::instrument;
@@ -17,14 +18,20 @@ pub struct Repository {
 #[derive(Serialize, Deserialize, Debug)]
 pub struct CreateRepositoryRequest {
     pub name: String,
+    /// Publish the repository flat, with its Release file and a single
+    /// Packages index at its root instead of under `dists/`. Flat
+    /// repositories have only one distribution.
+    #[serde(default)]
+    pub flat: bool,
 }
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub struct CreateRepositoryResponse {
     pub id: i64,
     pub name: String,
     pub s3_bucket: String,
     pub s3_prefix: String,
+    pub flat: bool,
 }
 
 #[axum::debug_handler]
@@ -68,16 +75,18 @@ pub async fn handler(
             tenant_id,
             s3_bucket,
             s3_prefix,
+            flat,
             created_at,
             updated_at
         )
-        VALUES ($1, $2, $3, $4, NOW(), NOW())
-        RETURNING id, name
+        VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+        RETURNING id, name, flat
         "#,
         req.name,
         tenant_id.0,
         s3_bucket,
         s3_prefix,
+        req.flat,
     )
     .fetch_one(&mut *tx)
     .await
@@ -92,6 +101,7 @@ pub async fn handler(
         name: inserted.name,
         s3_bucket,
         s3_prefix,
+        flat: inserted.flat,
     }))
 }
 
@@ -3,6 +3,7 @@ use axum::{
     extract::{Path, State},
     http::StatusCode,
 };
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 use tracing::instrument;
 
@@ -14,7 +15,7 @@ use crate::{
 #[derive(Serialize, Deserialize, Debug)]
 pub struct DeleteRepositoryRequest {}
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub struct DeleteRepositoryResponse {}
 
 #[axum::debug_handler]
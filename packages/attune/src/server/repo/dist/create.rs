@@ -3,12 +3,16 @@ use axum::{
     extract::{Path, State},
 };
 use bon::Builder;
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 use tracing::instrument;
 
 use crate::{
     api::{ErrorResponse, TenantID},
-    server::{ServerState, repo::decode_repo_name},
+    server::{
+        ServerState,
+        repo::{decode_repo_name, index::ensure_flat_distribution},
+    },
 };
 
 /// Request to create a new distribution (release) within a package repository.
@@ -75,7 +79,7 @@ pub struct CreateDistributionRequest {
 /// The distribution is immediately available for adding packages, though it
 /// won't appear in the repository until packages are added and the repository
 /// index is generated.
-#[derive(Serialize, Deserialize, Debug, Builder)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug, Builder)]
 pub struct CreateDistributionResponse {
     /// Unique database identifier for this distribution.
     /// Use this ID for subsequent operations like editing or deleting the
@@ -102,7 +106,7 @@ pub async fn handler(
     let mut tx = state.db.begin().await.unwrap();
     let repo = sqlx::query!(
         r#"
-        SELECT id
+        SELECT id, flat
         FROM debian_repository
         WHERE tenant_id = $1 AND name = $2
         "#,
@@ -139,6 +143,9 @@ pub async fn handler(
             .message("distribution already exists")
             .build());
     }
+    if repo.flat {
+        ensure_flat_distribution(&mut tx, &tenant_id, &repository_name, &req.name).await?;
+    }
 
     // Insert new distribution
     let inserted = sqlx::query!(
@@ -1,25 +1,44 @@
 use axum::{
     Json,
-    extract::{Path, State},
+    extract::{Path, Query, State},
+    http::StatusCode,
 };
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
+use sqlx::{Postgres, Transaction};
 use tracing::instrument;
 
 use crate::{
     api::{ErrorResponse, TenantID},
+    apt::{
+        ContentsIndexMeta, Dep11FileMeta, PackagesDiff, PackagesDiffIndex, PackagesIndexMeta,
+        TranslationIndexMeta, index_component,
+    },
     server::{
         ServerState,
-        repo::{decode_repo_name, dist::decode_dist_name},
+        repo::{decode_repo_name, dist::decode_dist_name, lock::ensure_unlocked},
     },
 };
 
+/// Options for deleting a distribution.
+#[derive(Serialize, Deserialize, Debug, Default)]
+pub struct DeleteDistributionParams {
+    /// Delete the distribution even if packages are still published in it.
+    ///
+    /// Without this, deleting a distribution that still has packages fails
+    /// with `DISTRIBUTION_NOT_EMPTY`, so that packages aren't unpublished by
+    /// accident.
+    #[serde(default)]
+    pub force: bool,
+}
+
 /// Response after successfully deleting a distribution from a repository.
 ///
 /// Deletion is permanent and will cascade to remove all associated components,
 /// package indexes, and package associations. Any packages that were only
 /// available through this distribution will become inaccessible until added
 /// to another distribution.
-#[derive(Serialize, Deserialize, Debug, Default)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug, Default)]
 pub struct DeleteDistributionResponse {}
 
 #[axum::debug_handler]
@@ -28,6 +47,7 @@ pub async fn handler(
     State(state): State<ServerState>,
     tenant_id: TenantID,
     Path((repository_name, distribution_name)): Path<(String, String)>,
+    Query(params): Query<DeleteDistributionParams>,
 ) -> Result<Json<DeleteDistributionResponse>, ErrorResponse> {
     let repository_name = decode_repo_name(&repository_name)?;
     let distribution_name = decode_dist_name(&distribution_name)?;
@@ -47,33 +67,50 @@ pub async fn handler(
     .map_err(ErrorResponse::from)?
     .ok_or_else(|| {
         ErrorResponse::builder()
-            .status(axum::http::StatusCode::NOT_FOUND)
+            .status(StatusCode::NOT_FOUND)
             .error("REPO_NOT_FOUND")
             .message("repository not found")
             .build()
     })?;
+    ensure_unlocked(&mut *tx, &tenant_id, &repository_name).await?;
 
-    // Find all components and their indexes for this distribution.
-    // We need the index content hashes in order to delete by-hash objects.
-    let components = sqlx::query!(
-        r#"
-        SELECT
-            c.name,
-            i.architecture::text as "architecture!: String",
-            i.md5sum,
-            i.sha1sum,
-            i.sha256sum
-        FROM debian_repository_release r
-        JOIN debian_repository_component c ON c.release_id = r.id
-        JOIN debian_repository_index_packages i ON i.component_id = c.id
-        WHERE r.repository_id = $1 AND r.distribution = $2
-        "#,
+    if !params.force {
+        let published = sqlx::query!(
+            r#"
+            SELECT COUNT(*) AS "count!"
+            FROM debian_repository_release r
+            JOIN debian_repository_component c ON c.release_id = r.id
+            JOIN debian_repository_component_package cp ON cp.component_id = c.id
+            WHERE r.repository_id = $1 AND r.distribution = $2
+            "#,
+            repo.id,
+            distribution_name,
+        )
+        .fetch_one(&mut *tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+        if published.count > 0 {
+            return Err(ErrorResponse::new(
+                StatusCode::CONFLICT,
+                "DISTRIBUTION_NOT_EMPTY".to_string(),
+                format!(
+                    "distribution still has {} published packages; remove them first, or force the deletion",
+                    published.count
+                ),
+            ));
+        }
+    }
+
+    // Find the files that the distribution publishes before deleting it.
+    let published = published_keys(
+        &mut tx,
+        &tenant_id,
         repo.id,
-        distribution_name,
+        &repository_name,
+        &repo.s3_prefix,
+        &distribution_name,
     )
-    .fetch_all(&mut *tx)
-    .await
-    .map_err(ErrorResponse::from)?;
+    .await?;
 
     // Cascade will handle related records when deleting the distribution.
     let result = sqlx::query!(
@@ -92,13 +129,15 @@ pub async fn handler(
     // existed.
     if result.rows_affected() == 0 {
         return Err(ErrorResponse::builder()
-            .status(axum::http::StatusCode::NOT_FOUND)
+            .status(StatusCode::NOT_FOUND)
             .error("DISTRIBUTION_NOT_FOUND")
             .message("distribution not found")
             .build());
     }
 
     // Find and delete orphaned packages; the returning clause is for S3 cleanup.
+    // Packages that a snapshot still refers to are kept, so that the snapshot
+    // can be restored.
     let orphaned = sqlx::query!(
         r#"
         DELETE FROM debian_repository_package p
@@ -107,6 +146,10 @@ pub async fn handler(
             SELECT 1 FROM debian_repository_component_package cp
             WHERE cp.package_id = p.id
         )
+        AND NOT EXISTS (
+            SELECT 1 FROM debian_repository_snapshot_package sp
+            WHERE sp.package_id = p.id
+        )
         RETURNING p.id, p.s3_bucket, p.sha256sum
         "#,
         tenant_id.0,
@@ -119,37 +162,16 @@ pub async fn handler(
     // Now all we need to do is clean up S3 objects.
     tx.commit().await.map_err(ErrorResponse::from)?;
 
-    // Clean up S3 objects for this distribution based on known paths.
-    let prefix = format!("{}/dists/{}", repo.s3_prefix, distribution_name);
-    let keys = {
-        // Deletes distribution metadata files.
-        let mut keys = vec![
-            format!("{prefix}/Release"),
-            format!("{prefix}/Release.gpg"),
-            format!("{prefix}/InRelease"),
-        ];
-
-        // Deletes component metadata files.
-        keys.extend(components.iter().flat_map(|record| {
-            // TODO(#94): When compressed indexes are implemented, add their deletion here.
-            let prefix = format!("{}/{}/binary-{}", prefix, record.name, record.architecture);
-            [
-                format!("{prefix}/Packages"),
-                format!("{prefix}/by-hash/SHA256/{}", record.sha256sum),
-                format!("{prefix}/by-hash/SHA1/{}", record.sha1sum),
-                format!("{prefix}/by-hash/MD5Sum/{}", record.md5sum),
-            ]
-        }));
-
-        // Deletes orphaned package files.
-        keys.extend(
+    // Clean up S3 objects for this distribution based on known paths, and
+    // orphaned package files.
+    let keys = published
+        .into_iter()
+        .chain(
             orphaned
                 .iter()
                 .map(|pkg| format!("packages/{}", pkg.sha256sum)),
-        );
-
-        keys
-    };
+        )
+        .collect::<Vec<_>>();
 
     let deletions = keys.chunks(1000).map(|chunk| {
         let objects = chunk
@@ -182,3 +204,323 @@ pub async fn handler(
 
     Ok(Json(DeleteDistributionResponse::default()))
 }
+
+/// The keys of the objects that a distribution publishes, other than its
+/// packages.
+async fn published_keys(
+    tx: &mut Transaction<'_, Postgres>,
+    tenant_id: &TenantID,
+    repository_id: i64,
+    repository_name: &str,
+    s3_prefix: &str,
+    distribution_name: &str,
+) -> Result<Vec<String>, ErrorResponse> {
+    // Find all components and their indexes for this distribution.
+    // We need the index content hashes in order to delete by-hash objects.
+    let components = sqlx::query!(
+        r#"
+        SELECT
+            c.name,
+            i.architecture::text as "architecture!: String",
+            i.md5sum,
+            i.sha1sum,
+            i.sha256sum,
+            i.debian_installer
+        FROM debian_repository_release r
+        JOIN debian_repository_component c ON c.release_id = r.id
+        JOIN debian_repository_index_packages i ON i.component_id = c.id
+        WHERE r.repository_id = $1 AND r.distribution = $2
+        "#,
+        repository_id,
+        distribution_name,
+    )
+    .fetch_all(&mut **tx)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    // Deletes distribution metadata files.
+    let prefix = format!("{s3_prefix}/dists/{distribution_name}");
+    let mut keys = vec![
+        format!("{prefix}/Release"),
+        format!("{prefix}/Release.gpg"),
+        format!("{prefix}/InRelease"),
+    ];
+
+    // Deletes component metadata files.
+    keys.extend(components.iter().flat_map(|record| {
+        // TODO(#94): When compressed indexes are implemented, add their deletion here.
+        let component = index_component(&record.name, record.debian_installer);
+        let prefix = format!("{}/{}/binary-{}", prefix, component, record.architecture);
+        [
+            format!("{prefix}/Packages"),
+            format!("{prefix}/by-hash/SHA256/{}", record.sha256sum),
+            format!("{prefix}/by-hash/SHA1/{}", record.sha1sum),
+            format!("{prefix}/by-hash/MD5Sum/{}", record.md5sum),
+        ]
+    }));
+
+    // Deletes `Packages.diff/` histories: each index's patches, and its
+    // `Packages.diff/Index` along with its by-hash copies.
+    let history =
+        PackagesDiff::query_from_release(tx, tenant_id, repository_name, distribution_name)
+            .await?;
+    let metas =
+        PackagesIndexMeta::query_from_release(tx, tenant_id, repository_name, distribution_name)
+            .await?;
+    for meta in metas {
+        let Some(history) = history.get(&(meta.component.clone(), meta.architecture.clone()))
+        else {
+            continue;
+        };
+        let diff_prefix = format!(
+            "{prefix}/{}/binary-{}/Packages.diff",
+            meta.component, meta.architecture
+        );
+        let diff_index = PackagesDiffIndex::render(&meta, history);
+        keys.extend([
+            format!("{diff_prefix}/Index"),
+            format!("{diff_prefix}/by-hash/SHA256/{}", diff_index.sha256sum),
+            format!("{diff_prefix}/by-hash/MD5Sum/{}", diff_index.md5sum),
+        ]);
+        keys.extend(
+            history
+                .iter()
+                .map(|diff| format!("{diff_prefix}/{}.gz", diff.name)),
+        );
+    }
+
+    // Deletes Contents indexes and their by-hash copies, which are in their
+    // component's `by-hash/` directory.
+    let contents_indexes =
+        ContentsIndexMeta::query_from_release(tx, tenant_id, repository_name, distribution_name)
+            .await?;
+    keys.extend(contents_indexes.iter().flat_map(|index| {
+        let component_prefix = format!("{prefix}/{}", index.component);
+        [
+            format!("{prefix}/{}", index.path()),
+            format!("{component_prefix}/by-hash/SHA256/{}", index.sha256sum),
+            format!("{component_prefix}/by-hash/MD5Sum/{}", index.md5sum),
+        ]
+    }));
+
+    // Deletes Translation indexes and their by-hash copies.
+    let translation_indexes =
+        TranslationIndexMeta::query_from_release(tx, tenant_id, repository_name, distribution_name)
+            .await?;
+    keys.extend(translation_indexes.iter().flat_map(|index| {
+        let i18n_prefix = format!("{prefix}/{}/i18n", index.component);
+        [
+            format!("{prefix}/{}", index.path()),
+            format!("{i18n_prefix}/by-hash/SHA256/{}", index.sha256sum),
+            format!("{i18n_prefix}/by-hash/MD5Sum/{}", index.md5sum),
+        ]
+    }));
+
+    // Deletes published DEP-11 files and their by-hash copies. Only the
+    // version of each file that was last published was uploaded, which may
+    // not be its current version.
+    let dep11_files =
+        Dep11FileMeta::query_from_release(tx, tenant_id, repository_name, distribution_name)
+            .await?;
+    for file in dep11_files {
+        let dep11_prefix = format!("{prefix}/{}/dep11", file.component);
+        keys.push(format!("{prefix}/{}", file.path()));
+        if let (Some(md5sum), Some(sha256sum)) = (file.published_md5sum, file.published_sha256sum)
+        {
+            keys.push(format!("{dep11_prefix}/by-hash/SHA256/{sha256sum}"));
+            keys.push(format!("{dep11_prefix}/by-hash/MD5Sum/{md5sum}"));
+        }
+    }
+
+    Ok(keys)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{
+        server::repo::{
+            dist::{create::CreateDistributionRequest, edit::EditDistributionRequest},
+            edit::EditRepositoryRequest,
+            lock::create::LockRepositoryRequest,
+        },
+        testing::{AttuneTestServer, AttuneTestServerConfig},
+    };
+
+    /// Every file that a distribution publishes is deleted with it.
+    #[sqlx::test(
+        migrator = "crate::testing::MIGRATOR",
+        fixtures(path = "../index/fixtures", scripts("setup_multi_arch"))
+    )]
+    #[test_log::test]
+    async fn delete_published_files(pool: sqlx::PgPool) {
+        let mut tx = pool.begin().await.unwrap();
+        sqlx::query(
+            r#"
+            INSERT INTO debian_repository_index_packages_diff (index_id, name, history_size, history_sha256sum, patch_size, patch_sha256sum, download_size, download_sha256sum, contents, created_at)
+            SELECT id, '2026-10-16-0000.00.000000', 0, 'historysha256', 10, 'patchsha256', 30, 'downloadsha256', ''::bytea, NOW()
+            FROM debian_repository_index_packages
+            WHERE architecture = 'amd64'
+            "#,
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+        sqlx::query(
+            r#"
+            INSERT INTO debian_repository_index_contents (component_id, architecture, size, contents, md5sum, sha256sum, created_at, updated_at)
+            VALUES (1000, 'arm64'::debian_repository_architecture, 20, ''::bytea, 'contentsmd5', 'contentssha256', NOW(), NOW())
+            "#,
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+        sqlx::query(
+            r#"
+            INSERT INTO debian_repository_index_translation (component_id, language, size, contents, md5sum, sha256sum, created_at, updated_at)
+            VALUES (1000, 'de', 20, ''::bytea, 'translationmd5', 'translationsha256', NOW(), NOW())
+            "#,
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+        sqlx::query(
+            r#"
+            INSERT INTO debian_repository_dep11_file (release_id, component, name, size, contents, md5sum, sha256sum, published_md5sum, published_sha256sum, created_at, updated_at)
+            VALUES (1000, 'main', 'Components-amd64.yml.gz', 20, ''::bytea, 'newdep11md5', 'newdep11sha256', 'dep11md5', 'dep11sha256', NOW(), NOW())
+            "#,
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+
+        let keys = published_keys(
+            &mut tx,
+            &TenantID(1),
+            1000,
+            "test-multi-arch",
+            "1/test-multi-arch",
+            "stable",
+        )
+        .await
+        .unwrap();
+        let prefix = "1/test-multi-arch/dists/stable";
+        for key in [
+            format!("{prefix}/InRelease"),
+            format!("{prefix}/main/binary-amd64/Packages"),
+            format!("{prefix}/main/binary-arm64/by-hash/SHA256/oldarm64sha256"),
+            format!("{prefix}/main/binary-amd64/Packages.diff/Index"),
+            format!("{prefix}/main/binary-amd64/Packages.diff/2026-10-16-0000.00.000000.gz"),
+            format!("{prefix}/main/Contents-arm64.gz"),
+            format!("{prefix}/main/by-hash/SHA256/contentssha256"),
+            format!("{prefix}/main/by-hash/MD5Sum/contentsmd5"),
+            format!("{prefix}/main/i18n/Translation-de"),
+            format!("{prefix}/main/i18n/by-hash/SHA256/translationsha256"),
+            format!("{prefix}/main/i18n/by-hash/MD5Sum/translationmd5"),
+            format!("{prefix}/main/dep11/Components-amd64.yml.gz"),
+            format!("{prefix}/main/dep11/by-hash/SHA256/dep11sha256"),
+            format!("{prefix}/main/dep11/by-hash/MD5Sum/dep11md5"),
+        ] {
+            assert!(keys.contains(&key), "{key:?} is not deleted: {keys:#?}");
+        }
+        let diff_by_hash = format!("{prefix}/main/binary-amd64/Packages.diff/by-hash/");
+        let diff_by_hash = keys.iter().filter(|key| key.starts_with(&diff_by_hash));
+        assert_eq!(diff_by_hash.count(), 2);
+        assert!(
+            !keys.iter().any(|key| key.contains("binary-arm64/Packages.diff")),
+            "arm64 has no history to delete"
+        );
+
+        tx.rollback().await.unwrap();
+    }
+
+    /// Locked repositories keep their distributions, even when deletion is
+    /// forced, and reject edits to them or to the repository itself.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR")]
+    #[test_log::test]
+    async fn locked_repository_rejects_deletion(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        const REPO_NAME: &str = "locked_repository_rejects_deletion";
+        let (tenant_id, api_token) = server.create_test_tenant(REPO_NAME).await;
+        server.create_repository(tenant_id, REPO_NAME).await;
+        let auth = format!("Bearer {api_token}");
+        let repo_url = format!("/api/v0/repositories/{REPO_NAME}");
+        let dist_url = format!("{repo_url}/distributions/stable");
+
+        server
+            .http
+            .post(&format!("{repo_url}/distributions"))
+            .add_header("authorization", &auth)
+            .json(
+                &CreateDistributionRequest::builder()
+                    .name("stable")
+                    .suite("stable")
+                    .codename("stable")
+                    .build(),
+            )
+            .await
+            .assert_status_ok();
+        server
+            .http
+            .put(&format!("{repo_url}/lock"))
+            .add_header("authorization", &auth)
+            .json(&LockRepositoryRequest {
+                reason: Some(String::from("incident")),
+            })
+            .await
+            .assert_status_ok();
+
+        let res = server
+            .http
+            .delete(&dist_url)
+            .add_query_param("force", true)
+            .add_header("authorization", &auth)
+            .expect_failure()
+            .await;
+        res.assert_status(StatusCode::LOCKED);
+        assert_eq!(res.json::<ErrorResponse>().error, "REPOSITORY_LOCKED");
+
+        let res = server
+            .http
+            .put(&dist_url)
+            .add_header("authorization", &auth)
+            .json(&EditDistributionRequest::builder().description("edited").build())
+            .expect_failure()
+            .await;
+        res.assert_status(StatusCode::LOCKED);
+        assert_eq!(res.json::<ErrorResponse>().error, "REPOSITORY_LOCKED");
+
+        let res = server
+            .http
+            .put(&repo_url)
+            .add_header("authorization", &auth)
+            .json(&EditRepositoryRequest {
+                new_name: Some(String::from("renamed")),
+                ..Default::default()
+            })
+            .expect_failure()
+            .await;
+        res.assert_status(StatusCode::LOCKED);
+        assert_eq!(res.json::<ErrorResponse>().error, "REPOSITORY_LOCKED");
+
+        // Once unlocked, the distribution can be deleted again.
+        server
+            .http
+            .delete(&format!("{repo_url}/lock"))
+            .add_header("authorization", &auth)
+            .await
+            .assert_status_ok();
+        server
+            .http
+            .delete(&dist_url)
+            .add_query_param("force", true)
+            .add_header("authorization", &auth)
+            .await
+            .assert_status_ok();
+    }
+}
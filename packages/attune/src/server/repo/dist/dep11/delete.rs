@@ -0,0 +1,118 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::Dep11FileMeta,
+    server::{
+        ServerState,
+        repo::{
+            decode_repo_name,
+            dist::{
+                decode_dist_name,
+                dep11::{Dep11FileInfo, Dep11FileResponse, Dep11FileStatus, query_release},
+            },
+        },
+    },
+};
+
+/// Delete a DEP-11 file. Files that were never published are deleted
+/// immediately. Published files stay published until the distribution's
+/// Release file is next signed, so they're only marked as removed.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path((repository_name, distribution_name, component, name)): Path<(
+        String,
+        String,
+        String,
+        String,
+    )>,
+) -> Result<Json<Dep11FileResponse>, ErrorResponse> {
+    let repository_name = decode_repo_name(&repository_name)?;
+    let distribution_name = decode_dist_name(&distribution_name)?;
+
+    let mut tx = state.db.begin().await.map_err(ErrorResponse::from)?;
+    let release = query_release(&mut tx, &tenant_id, &repository_name, &distribution_name).await?;
+    let deleted = sqlx::query_as!(
+        Dep11FileMeta,
+        r#"
+        DELETE FROM debian_repository_dep11_file
+        WHERE
+            release_id = $1
+            AND component = $2
+            AND name = $3
+            AND published_sha256sum IS NULL
+        RETURNING
+            id,
+            component,
+            name,
+            size,
+            md5sum,
+            sha256sum,
+            published_md5sum,
+            published_sha256sum,
+            removed
+        "#,
+        release.id,
+        &component,
+        &name,
+    )
+    .fetch_optional(&mut *tx)
+    .await
+    .map_err(ErrorResponse::from)?;
+    let meta = match deleted {
+        Some(meta) => meta,
+        None => sqlx::query_as!(
+            Dep11FileMeta,
+            r#"
+            UPDATE debian_repository_dep11_file
+            SET
+                removed = true,
+                updated_at = NOW()
+            WHERE
+                release_id = $1
+                AND component = $2
+                AND name = $3
+                AND NOT removed
+            RETURNING
+                id,
+                component,
+                name,
+                size,
+                md5sum,
+                sha256sum,
+                published_md5sum,
+                published_sha256sum,
+                removed
+            "#,
+            release.id,
+            &component,
+            &name,
+        )
+        .fetch_optional(&mut *tx)
+        .await
+        .map_err(ErrorResponse::from)?
+        .ok_or_else(|| {
+            ErrorResponse::new(
+                StatusCode::NOT_FOUND,
+                "DEP11_FILE_NOT_FOUND".to_string(),
+                format!("component {component:?} has no DEP-11 file {name:?}"),
+            )
+        })?,
+    };
+    tx.commit().await.map_err(ErrorResponse::from)?;
+
+    Ok(Json(Dep11FileResponse {
+        result: Dep11FileInfo {
+            status: Dep11FileStatus::Removed,
+            ..Dep11FileInfo::from(&meta)
+        },
+    }))
+}
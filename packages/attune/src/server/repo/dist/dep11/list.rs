@@ -0,0 +1,44 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::Dep11FileMeta,
+    server::{
+        ServerState,
+        repo::{
+            decode_repo_name,
+            dist::{
+                decode_dist_name,
+                dep11::{Dep11FileInfo, ListDep11FilesResponse, query_release},
+            },
+        },
+    },
+};
+
+/// List a distribution's DEP-11 files, including deleted files that are still
+/// published.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path((repository_name, distribution_name)): Path<(String, String)>,
+) -> Result<Json<ListDep11FilesResponse>, ErrorResponse> {
+    let repository_name = decode_repo_name(&repository_name)?;
+    let distribution_name = decode_dist_name(&distribution_name)?;
+
+    let mut tx = state.db.begin().await.map_err(ErrorResponse::from)?;
+    query_release(&mut tx, &tenant_id, &repository_name, &distribution_name).await?;
+    let files =
+        Dep11FileMeta::query_from_release(&mut tx, &tenant_id, &repository_name, &distribution_name)
+            .await?;
+    tx.commit().await.map_err(ErrorResponse::from)?;
+
+    Ok(Json(ListDep11FilesResponse {
+        files: files.iter().map(Dep11FileInfo::from).collect(),
+    }))
+}
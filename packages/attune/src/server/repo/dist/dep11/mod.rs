@@ -0,0 +1,133 @@
+//! DEP-11 (AppStream) metadata, which software centers like GNOME Software and
+//! KDE Discover use to show rich entries for packages.
+//!
+//! DEP-11 files are generated outside of Attune (usually by
+//! `appstream-generator`) and uploaded per distribution and component. Like
+//! other indexes, they're only published when the distribution's Release file
+//! is signed, so an uploaded or deleted file takes effect the next time a
+//! package is added to or removed from the distribution.
+
+use axum::http::StatusCode;
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use sqlx::{Postgres, Transaction};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::Dep11FileMeta,
+};
+
+pub mod delete;
+pub mod list;
+pub mod upload;
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug, Clone, Copy, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum Dep11FileStatus {
+    /// The published Release file lists the file's current version.
+    Published,
+    /// The file's current version is published the next time the Release file
+    /// is signed.
+    Pending,
+    /// The file was deleted, and stops being published the next time the
+    /// Release file is signed.
+    Removed,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct Dep11FileInfo {
+    pub component: String,
+    /// The file name, like `Components-amd64.yml.gz` or `icons-64x64.tar.gz`.
+    pub name: String,
+    pub size: i64,
+    pub sha256sum: String,
+    pub status: Dep11FileStatus,
+}
+
+impl From<&Dep11FileMeta> for Dep11FileInfo {
+    fn from(meta: &Dep11FileMeta) -> Self {
+        let status = if meta.removed {
+            Dep11FileStatus::Removed
+        } else if meta.is_published() {
+            Dep11FileStatus::Published
+        } else {
+            Dep11FileStatus::Pending
+        };
+        Self {
+            component: meta.component.clone(),
+            name: meta.name.clone(),
+            size: meta.size,
+            sha256sum: meta.sha256sum.clone(),
+            status,
+        }
+    }
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct ListDep11FilesResponse {
+    pub files: Vec<Dep11FileInfo>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct Dep11FileResponse {
+    pub result: Dep11FileInfo,
+}
+
+struct Dep11Release {
+    id: i64,
+    flat: bool,
+}
+
+/// Load the distribution that DEP-11 files are uploaded to, or return an error
+/// if it doesn't exist.
+#[instrument(skip(tx))]
+async fn query_release(
+    tx: &mut Transaction<'_, Postgres>,
+    tenant_id: &TenantID,
+    repository: &str,
+    distribution: &str,
+) -> Result<Dep11Release, ErrorResponse> {
+    let repo = sqlx::query!(
+        r#"
+        SELECT id, flat
+        FROM debian_repository
+        WHERE tenant_id = $1 AND name = $2
+        "#,
+        tenant_id.0,
+        repository,
+    )
+    .fetch_optional(&mut **tx)
+    .await
+    .map_err(ErrorResponse::from)?
+    .ok_or_else(|| {
+        ErrorResponse::builder()
+            .status(StatusCode::NOT_FOUND)
+            .error("REPO_NOT_FOUND")
+            .message("repository not found")
+            .build()
+    })?;
+    let release_id = sqlx::query_scalar!(
+        r#"
+        SELECT id
+        FROM debian_repository_release
+        WHERE repository_id = $1 AND distribution = $2
+        "#,
+        repo.id,
+        distribution,
+    )
+    .fetch_optional(&mut **tx)
+    .await
+    .map_err(ErrorResponse::from)?
+    .ok_or_else(|| {
+        ErrorResponse::builder()
+            .status(StatusCode::NOT_FOUND)
+            .error("DIST_NOT_FOUND")
+            .message("distribution not found")
+            .build()
+    })?;
+    Ok(Dep11Release {
+        id: release_id,
+        flat: repo.flat,
+    })
+}
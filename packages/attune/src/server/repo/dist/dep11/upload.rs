@@ -0,0 +1,131 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use bytes::Bytes;
+use lazy_regex::lazy_regex;
+use md5::Md5;
+use sha2::{Digest as _, Sha256};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::{Dep11FileMeta, validate_dep11_file_name},
+    server::{
+        ServerState,
+        repo::{
+            decode_repo_name,
+            dist::{
+                decode_dist_name,
+                dep11::{Dep11FileInfo, Dep11FileResponse, query_release},
+            },
+        },
+    },
+};
+
+/// Upload a DEP-11 file to a component of a distribution, replacing any
+/// existing file with the same name. The request body is the file's contents,
+/// which must already be compressed.
+#[axum::debug_handler]
+#[instrument(skip(state, body))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path((repository_name, distribution_name, component, name)): Path<(
+        String,
+        String,
+        String,
+        String,
+    )>,
+    body: Bytes,
+) -> Result<Json<Dep11FileResponse>, ErrorResponse> {
+    let repository_name = decode_repo_name(&repository_name)?;
+    let distribution_name = decode_dist_name(&distribution_name)?;
+
+    if !lazy_regex!(r"^[a-zA-Z0-9_-]+$").is_match(&component) {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            String::from("INVALID_COMPONENT_NAME"),
+            String::from(
+                "component name must contain only letters, numbers, underscores, and hyphens",
+            ),
+        ));
+    }
+    validate_dep11_file_name(&name).map_err(|message| {
+        ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_DEP11_FILE_NAME".to_string(),
+            message,
+        )
+    })?;
+    // Every DEP-11 file that clients fetch is gzipped.
+    if !body.starts_with(&[0x1f, 0x8b]) {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_DEP11_FILE".to_string(),
+            format!("{name:?} is not gzip-compressed"),
+        ));
+    }
+
+    let mut tx = state.db.begin().await.map_err(ErrorResponse::from)?;
+    let release = query_release(&mut tx, &tenant_id, &repository_name, &distribution_name).await?;
+    if release.flat {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "DEP11_FLAT_REPOSITORY".to_string(),
+            "flat repositories don't have components, so they can't publish DEP-11 metadata"
+                .to_string(),
+        ));
+    }
+
+    let meta = sqlx::query_as!(
+        Dep11FileMeta,
+        r#"
+        INSERT INTO debian_repository_dep11_file (
+            release_id,
+            component,
+            name,
+            size,
+            contents,
+            md5sum,
+            sha256sum,
+            created_at,
+            updated_at
+        )
+        VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
+        ON CONFLICT (release_id, component, name) DO UPDATE SET
+            size = EXCLUDED.size,
+            contents = EXCLUDED.contents,
+            md5sum = EXCLUDED.md5sum,
+            sha256sum = EXCLUDED.sha256sum,
+            removed = false,
+            updated_at = NOW()
+        RETURNING
+            id,
+            component,
+            name,
+            size,
+            md5sum,
+            sha256sum,
+            published_md5sum,
+            published_sha256sum,
+            removed
+        "#,
+        release.id,
+        &component,
+        &name,
+        body.len() as i64,
+        body.as_ref(),
+        hex::encode(Md5::digest(&body)),
+        hex::encode(Sha256::digest(&body)),
+    )
+    .fetch_one(&mut *tx)
+    .await
+    .map_err(ErrorResponse::from)?;
+    tx.commit().await.map_err(ErrorResponse::from)?;
+
+    Ok(Json(Dep11FileResponse {
+        result: Dep11FileInfo::from(&meta),
+    }))
+}
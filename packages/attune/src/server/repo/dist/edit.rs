@@ -3,6 +3,7 @@ use axum::{
     extract::{Path, State},
 };
 use bon::Builder;
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 use tracing::instrument;
 
@@ -10,7 +11,7 @@ use crate::{
     api::{ErrorResponse, TenantID},
     server::{
         ServerState,
-        repo::{decode_repo_name, dist::decode_dist_name},
+        repo::{decode_repo_name, dist::decode_dist_name, lock::ensure_unlocked},
     },
 };
 
@@ -78,7 +79,7 @@ impl EditDistributionRequest {
 /// Returns the distribution ID and name for confirmation. The updated metadata
 /// is immediately available through the API, though changes may not be
 /// reflected in the repository indexes until the next index generation cycle.
-#[derive(Serialize, Deserialize, Debug, Builder)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug, Builder)]
 pub struct EditDistributionResponse {
     /// Unique database identifier for this distribution.
     pub id: i64,
@@ -119,6 +120,7 @@ pub async fn handler(
             .message("repository not found")
             .build()
     })?;
+    ensure_unlocked(&mut *tx, &tenant_id, &repository_name).await?;
 
     let dist = sqlx::query!(
         r#"
@@ -3,6 +3,7 @@ use axum::{
     extract::{Path, State},
 };
 use bon::Builder;
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 use tracing::instrument;
 
@@ -16,7 +17,7 @@ use crate::{
 ///
 /// Contains both required identifiers (name, suite, codename) and optional
 /// metadata that provides additional context for package managers and users.
-#[derive(Serialize, Deserialize, Debug, Builder)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug, Builder)]
 pub struct Distribution {
     /// Unique database identifier for this distribution.
     pub id: i64,
@@ -70,7 +71,7 @@ pub struct Distribution {
 /// Returns distributions sorted alphabetically by name. Each distribution
 /// includes its complete metadata for display or further processing.
 /// Empty repositories will return an empty array.
-#[derive(Serialize, Deserialize, Debug, Builder)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug, Builder)]
 pub struct ListDistributionsResponse {
     /// All distributions in the repository, sorted by distribution name.
     pub distributions: Vec<Distribution>,
@@ -5,6 +5,7 @@ use crate::api::ErrorResponse;
 
 pub mod create;
 pub mod delete;
+pub mod dep11;
 pub mod edit;
 pub mod list;
 
@@ -1,27 +1,92 @@
+use std::collections::BTreeMap;
+
 use axum::{
     Json,
     extract::{Path, State},
     http::StatusCode,
 };
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
+use sqlx::types::Json as SqlJson;
 use tracing::instrument;
 
 use crate::{
     api::{ErrorResponse, TenantID},
-    server::{ServerState, repo::decode_repo_name},
+    apt::validate_release_field,
+    server::{
+        ServerState,
+        repo::{decode_repo_name, lock::ensure_unlocked},
+    },
 };
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub struct Repository {
     pub name: String,
+    /// Custom fields added to the Release file of every distribution.
+    pub release_fields: BTreeMap<String, String>,
+    /// How many days each Release file is valid for, if they expire.
+    pub valid_until_days: Option<i32>,
+    /// Whether Packages indexes publish a `Packages.diff/` history.
+    pub pdiffs: bool,
+    /// Whether components publish `Contents-<arch>.gz` indexes.
+    pub contents_indexes: bool,
+    /// Whether components publish `i18n/Translation-<lang>` indexes.
+    pub translations: bool,
+    /// Whether adding a lower version of a package than the latest one in its
+    /// Packages index is refused.
+    pub prevent_downgrades: bool,
+    /// How many of the newest versions of each package `attune apt repo prune`
+    /// keeps per architecture, if it's limited.
+    pub retain_versions: Option<i32>,
+    /// How many days `attune apt repo prune` keeps older versions of packages
+    /// for after they're added, if it's limited.
+    pub retain_days: Option<i32>,
 }
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, Debug, Default)]
 pub struct EditRepositoryRequest {
     pub new_name: Option<String>,
+    /// Custom Release fields to set. Setting a field replaces any existing
+    /// field with the same name, ignoring case.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub set_release_fields: BTreeMap<String, String>,
+    /// Names of custom Release fields to remove, ignoring case.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub unset_release_fields: Vec<String>,
+    /// How many days each Release file is valid for after it's generated. Set
+    /// to 0 to stop Release files from expiring.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub valid_until_days: Option<i32>,
+    /// Whether Packages indexes publish a `Packages.diff/` history. Turning
+    /// this off discards the existing history.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub pdiffs: Option<bool>,
+    /// Whether components publish `Contents-<arch>.gz` indexes, which list the
+    /// files installed by each package.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub contents_indexes: Option<bool>,
+    /// Whether components publish `i18n/Translation-<lang>` indexes of their
+    /// packages' descriptions.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub translations: Option<bool>,
+    /// Whether adding a lower version of a package than the latest one in its
+    /// Packages index is refused, unless the change allows the downgrade.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub prevent_downgrades: Option<bool>,
+    /// How many of the newest versions of each package to keep per
+    /// architecture when pruning. Set to 0 to keep every version.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub retain_versions: Option<i32>,
+    /// How many days to keep older versions of packages for after they're
+    /// added when pruning. Set to 0 to keep them regardless of age.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub retain_days: Option<i32>,
 }
 
-#[derive(Serialize, Deserialize, Debug)]
+/// The longest that Release files can be valid for, in days.
+pub const MAX_VALID_UNTIL_DAYS: i32 = 3650;
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub struct EditRepositoryResponse {
     pub result: Repository,
 }
@@ -37,28 +102,234 @@ pub async fn handler(
     // The repository name in the path is percent-encoded.
     let name = decode_repo_name(&name)?;
 
-    let updated = sqlx::query!(
+    let mut tx = state.db.begin().await.map_err(ErrorResponse::from)?;
+    let repo = sqlx::query!(
         r#"
-        UPDATE debian_repository
-        SET name = $3
+        SELECT
+            id,
+            release_fields AS "release_fields!: SqlJson<BTreeMap<String, String>>",
+            valid_until_days,
+            flat,
+            pdiffs,
+            contents_indexes,
+            translations,
+            prevent_downgrades,
+            retain_versions,
+            retain_days
+        FROM debian_repository
         WHERE tenant_id = $1 AND name = $2
-        RETURNING id, name
+        FOR UPDATE
         "#,
         tenant_id.0,
         &name,
-        req.new_name.unwrap_or(name.to_string()),
     )
-    .fetch_optional(&state.db)
+    .fetch_optional(&mut *tx)
     .await
-    .map_err(ErrorResponse::from)?;
-    match updated {
-        Some(updated) => Ok(Json(EditRepositoryResponse {
-            result: Repository { name: updated.name },
-        })),
-        None => Err(ErrorResponse::new(
+    .map_err(ErrorResponse::from)?
+    .ok_or_else(|| {
+        ErrorResponse::new(
             StatusCode::NOT_FOUND,
             "REPO_NOT_FOUND".to_string(),
             "repository not found".to_string(),
+        )
+    })?;
+    ensure_unlocked(&mut *tx, &tenant_id, &name).await?;
+
+    let mut release_fields = repo.release_fields.0;
+    for key in &req.unset_release_fields {
+        release_fields.retain(|field, _| !field.eq_ignore_ascii_case(key));
+    }
+    for (key, value) in req.set_release_fields {
+        validate_release_field(&key, &value).map_err(|message| {
+            ErrorResponse::new(
+                StatusCode::BAD_REQUEST,
+                "INVALID_RELEASE_FIELD".to_string(),
+                message,
+            )
+        })?;
+        release_fields.retain(|field, _| !field.eq_ignore_ascii_case(&key));
+        release_fields.insert(key, value);
+    }
+
+    let valid_until_days = match req.valid_until_days {
+        None => repo.valid_until_days,
+        Some(0) => None,
+        Some(days @ 1..=MAX_VALID_UNTIL_DAYS) => Some(days),
+        Some(days) => {
+            return Err(ErrorResponse::new(
+                StatusCode::BAD_REQUEST,
+                "INVALID_VALID_UNTIL_DAYS".to_string(),
+                format!(
+                    "invalid Valid-Until period of {days} days: must be between 1 and {MAX_VALID_UNTIL_DAYS}, or 0 to disable"
+                ),
+            ));
+        }
+    };
+
+    let pdiffs = req.pdiffs.unwrap_or(repo.pdiffs);
+    if pdiffs && repo.flat {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "PDIFFS_FLAT_REPOSITORY".to_string(),
+            "flat repositories can't publish Packages.diff histories".to_string(),
+        ));
+    }
+    if repo.pdiffs && !pdiffs {
+        // Discard the history, so that it doesn't resume from a stale patch if
+        // it's turned back on.
+        sqlx::query!(
+            r#"
+            DELETE FROM debian_repository_index_packages_diff
+            USING
+                debian_repository_index_packages,
+                debian_repository_component,
+                debian_repository_release
+            WHERE
+                debian_repository_index_packages_diff.index_id = debian_repository_index_packages.id
+                AND debian_repository_index_packages.component_id = debian_repository_component.id
+                AND debian_repository_component.release_id = debian_repository_release.id
+                AND debian_repository_release.repository_id = $1
+            "#,
+            repo.id,
+        )
+        .execute(&mut *tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+    }
+
+    let contents_indexes = req.contents_indexes.unwrap_or(repo.contents_indexes);
+    if contents_indexes && repo.flat {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "CONTENTS_INDEXES_FLAT_REPOSITORY".to_string(),
+            "flat repositories can't publish Contents indexes".to_string(),
+        ));
+    }
+    if repo.contents_indexes && !contents_indexes {
+        // Discard the indexes, so that stale ones aren't published if they're
+        // turned back on.
+        sqlx::query!(
+            r#"
+            DELETE FROM debian_repository_index_contents
+            USING
+                debian_repository_component,
+                debian_repository_release
+            WHERE
+                debian_repository_index_contents.component_id = debian_repository_component.id
+                AND debian_repository_component.release_id = debian_repository_release.id
+                AND debian_repository_release.repository_id = $1
+            "#,
+            repo.id,
+        )
+        .execute(&mut *tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+    }
+
+    let translations = req.translations.unwrap_or(repo.translations);
+    if translations && repo.flat {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "TRANSLATIONS_FLAT_REPOSITORY".to_string(),
+            "flat repositories can't publish Translation indexes".to_string(),
+        ));
+    }
+    if repo.translations && !translations {
+        // Discard the indexes, so that stale ones aren't published if they're
+        // turned back on. Uploaded package translations are kept.
+        sqlx::query!(
+            r#"
+            DELETE FROM debian_repository_index_translation
+            USING
+                debian_repository_component,
+                debian_repository_release
+            WHERE
+                debian_repository_index_translation.component_id = debian_repository_component.id
+                AND debian_repository_component.release_id = debian_repository_release.id
+                AND debian_repository_release.repository_id = $1
+            "#,
+            repo.id,
+        )
+        .execute(&mut *tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+    }
+
+    let prevent_downgrades = req.prevent_downgrades.unwrap_or(repo.prevent_downgrades);
+
+    let retain_versions = retention(req.retain_versions, repo.retain_versions, "versions")?;
+    let retain_days = retention(req.retain_days, repo.retain_days, "days")?;
+
+    let updated = sqlx::query!(
+        r#"
+        UPDATE debian_repository
+        SET
+            name = $2,
+            release_fields = $3,
+            valid_until_days = $4,
+            pdiffs = $5,
+            contents_indexes = $6,
+            translations = $7,
+            prevent_downgrades = $8,
+            retain_versions = $9,
+            retain_days = $10
+        WHERE id = $1
+        RETURNING
+            name,
+            release_fields AS "release_fields!: SqlJson<BTreeMap<String, String>>",
+            valid_until_days,
+            pdiffs,
+            contents_indexes,
+            translations,
+            prevent_downgrades,
+            retain_versions,
+            retain_days
+        "#,
+        repo.id,
+        req.new_name.unwrap_or(name.to_string()),
+        SqlJson(release_fields) as _,
+        valid_until_days,
+        pdiffs,
+        contents_indexes,
+        translations,
+        prevent_downgrades,
+        retain_versions,
+        retain_days,
+    )
+    .fetch_one(&mut *tx)
+    .await
+    .map_err(ErrorResponse::from)?;
+    tx.commit().await.map_err(ErrorResponse::from)?;
+
+    Ok(Json(EditRepositoryResponse {
+        result: Repository {
+            name: updated.name,
+            release_fields: updated.release_fields.0,
+            valid_until_days: updated.valid_until_days,
+            pdiffs: updated.pdiffs,
+            contents_indexes: updated.contents_indexes,
+            translations: updated.translations,
+            prevent_downgrades: updated.prevent_downgrades,
+            retain_versions: updated.retain_versions,
+            retain_days: updated.retain_days,
+        },
+    }))
+}
+
+/// Apply a requested change to a retention rule, where 0 removes the rule.
+fn retention(
+    requested: Option<i32>,
+    current: Option<i32>,
+    unit: &str,
+) -> Result<Option<i32>, ErrorResponse> {
+    match requested {
+        None => Ok(current),
+        Some(0) => Ok(None),
+        Some(count @ 1..) => Ok(Some(count)),
+        Some(count) => Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_RETENTION".to_string(),
+            format!("invalid retention of {count} {unit}: must be positive, or 0 to disable"),
         )),
     }
 }
@@ -0,0 +1,94 @@
+use axum::{
+    Json,
+    extract::{Query, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use time::{Duration, OffsetDateTime};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::parse_valid_until,
+    server::ServerState,
+};
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct ExpiringReleasesParams {
+    /// List Release files that expire within this many days. Release files
+    /// that have already expired are always listed.
+    pub within_days: i64,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct ExpiringReleasesResponse {
+    /// Matching Release files, soonest to expire first.
+    pub releases: Vec<ExpiringRelease>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct ExpiringRelease {
+    pub repository: String,
+    pub distribution: String,
+    /// The Release file's `Valid-Until` date.
+    #[serde(with = "time::serde::rfc3339")]
+    #[schemars(with = "String")]
+    pub valid_until: OffsetDateTime,
+}
+
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Query(params): Query<ExpiringReleasesParams>,
+) -> Result<Json<ExpiringReleasesResponse>, ErrorResponse> {
+    if params.within_days < 0 {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_WITHIN_DAYS",
+            "`within_days` must not be negative",
+        ));
+    }
+    let cutoff = OffsetDateTime::now_utc() + Duration::days(params.within_days);
+
+    // The published Release file is the source of truth for when it expires,
+    // since the repository's setting may have changed since it was generated.
+    let mut releases = sqlx::query!(
+        r#"
+        SELECT
+            debian_repository.name AS repository,
+            release.distribution,
+            release.contents
+        FROM debian_repository_release AS release
+        JOIN debian_repository ON debian_repository.id = release.repository_id
+        WHERE
+            debian_repository.tenant_id = $1
+            AND release.contents LIKE '%Valid-Until: %'
+        "#,
+        tenant_id.0,
+    )
+    .fetch_all(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?
+    .into_iter()
+    .filter_map(|row| {
+        let valid_until = parse_valid_until(&row.contents)?;
+        (valid_until <= cutoff).then_some(ExpiringRelease {
+            repository: row.repository,
+            distribution: row.distribution,
+            valid_until,
+        })
+    })
+    .collect::<Vec<_>>();
+    releases.sort_by(|a, b| {
+        (a.valid_until, &a.repository, &a.distribution).cmp(&(
+            b.valid_until,
+            &b.repository,
+            &b.distribution,
+        ))
+    });
+
+    Ok(Json(ExpiringReleasesResponse { releases }))
+}
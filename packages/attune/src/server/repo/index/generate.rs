@@ -1,7 +1,6 @@
 use axum::{
     Json,
     extract::{Path, State},
-    http::StatusCode,
 };
 use serde::{Deserialize, Serialize};
 use time::OffsetDateTime;
@@ -13,7 +12,10 @@ use crate::{
         ServerState,
         repo::{
             decode_repo_name,
-            index::{PackageChange, generate_release_file_with_change},
+            index::{
+                PackageChange, generate_release_file_with_change, request_changes,
+                sign::stage_changes,
+            },
         },
     },
 };
@@ -26,6 +28,10 @@ pub struct Repository {
 #[derive(Serialize, Deserialize, Debug)]
 pub struct GenerateIndexRequest {
     pub change: PackageChange,
+    /// Changes to apply after `change`, in order. The generated Release file
+    /// publishes all of them, so they're applied together once it's signed.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub then: Vec<PackageChange>,
 }
 
 #[derive(Serialize, Deserialize, Debug)]
@@ -45,13 +51,7 @@ pub async fn handler(
 ) -> Result<Json<GenerateIndexResponse>, ErrorResponse> {
     // The repository name in the path is percent-encoded.
     let repo_name = decode_repo_name(&repo_name)?;
-    if repo_name != req.change.repository {
-        return Err(ErrorResponse::new(
-            StatusCode::BAD_REQUEST,
-            "REPOSITORY_MISMATCH".to_string(),
-            "repository name in path does not match repository name in request".to_string(),
-        ));
-    }
+    let changes = request_changes(&repo_name, &req.change, &req.then)?;
 
     let mut tx = state.db.begin().await.unwrap();
     sqlx::query!("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
@@ -59,11 +59,19 @@ pub async fn handler(
         .await
         .map_err(ErrorResponse::from)?;
 
+    // Stage the changes before the last one, so that the last one is generated
+    // from their result. They're not signed yet, so they're staged without
+    // signatures and rolled back.
     let release_ts = OffsetDateTime::now_utc();
-    let result =
-        generate_release_file_with_change(&mut tx, &tenant_id, &req.change, release_ts).await?;
+    let (&last, earlier) = changes.split_last().expect("requests have at least one change");
+    let staged = stage_changes(&mut tx, &tenant_id, earlier, release_ts, "", "").await?;
+    let result = generate_release_file_with_change(&mut tx, &tenant_id, last, release_ts).await?;
 
-    tx.commit().await.map_err(ErrorResponse::from)?;
+    if staged.is_empty() {
+        tx.commit().await.map_err(ErrorResponse::from)?;
+    } else {
+        tx.rollback().await.map_err(ErrorResponse::from)?;
+    }
 
     Ok(Json(GenerateIndexResponse {
         release: result.release_file.contents,
@@ -1,5 +1,8 @@
-use std::iter::once;
+use std::{collections::BTreeSet, iter::once};
 
+use axum::http::StatusCode;
+use debian_packaging::package_version::PackageVersion;
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 use sqlx::{Postgres, Transaction};
 use time::OffsetDateTime;
@@ -7,13 +10,20 @@ use tracing::instrument;
 
 use crate::{
     api::{ErrorResponse, TenantID},
-    apt::{Package, PackagesIndex, PackagesIndexMeta, PublishedPackage, ReleaseFile, ReleaseMeta},
+    apt::{
+        ContentsIndex, ContentsIndexMeta, ContentsPackage, Dep11File, Dep11FileMeta,
+        FlatPackagesIndex, PDIFF_HISTORY_LENGTH, Package, PackagesDiff, PackagesDiffIndex,
+        PackagesIndex, PackagesIndexMeta, PublishedPackage, ReleaseFile, ReleaseMeta,
+        ReleaseSettings, TranslationIndex, TranslationIndexMeta, TranslationPackage,
+        split_index_component,
+    },
+    server::repo::lock::ensure_unlocked,
 };
 
 pub mod generate;
 pub mod sign;
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub struct PackageChange {
     pub repository: String,
     pub distribution: String,
@@ -22,10 +32,15 @@ pub struct PackageChange {
     pub action: PackageChangeAction,
 }
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub enum PackageChangeAction {
     Add {
         package_sha256sum: String,
+        /// Add the package even if its version is lower than the latest
+        /// version of it in the Packages index, in repositories that prevent
+        /// downgrades.
+        #[serde(default)]
+        allow_downgrade: bool,
     },
     Remove {
         name: String,
@@ -34,14 +49,120 @@ pub enum PackageChangeAction {
     },
 }
 
+/// The changes of an index request, in the order that they're applied. They
+/// must all be in the request's repository and in the same distribution, so
+/// that a single Release file publishes all of them.
+fn request_changes<'a>(
+    repository: &str,
+    change: &'a PackageChange,
+    then: &'a [PackageChange],
+) -> Result<Vec<&'a PackageChange>, ErrorResponse> {
+    let changes = once(change).chain(then).collect::<Vec<_>>();
+    for other in &changes {
+        if other.repository != repository {
+            return Err(ErrorResponse::new(
+                StatusCode::BAD_REQUEST,
+                "REPOSITORY_MISMATCH".to_string(),
+                "repository name in path does not match repository name in request".to_string(),
+            ));
+        }
+        if other.distribution != change.distribution {
+            return Err(ErrorResponse::new(
+                StatusCode::BAD_REQUEST,
+                "DISTRIBUTION_MISMATCH".to_string(),
+                "all changes in a request must be in the same distribution".to_string(),
+            ));
+        }
+    }
+    Ok(changes)
+}
+
 #[derive(Debug)]
 struct PackageChangeResult {
     release_file: ReleaseFile,
     changed_packages_index: PackagesIndex,
+    /// The repository's combined Packages index, if it is flat.
+    flat_packages_index: Option<FlatPackagesIndex>,
+    /// Changes to the changed Packages index's `Packages.diff/` history.
+    packages_diffs: PackagesDiffChange,
+    /// Changes to the distribution's Contents indexes.
+    contents_indexes: ContentsIndexChange,
+    /// Changes to the distribution's Translation indexes.
+    translation_indexes: TranslationIndexChange,
+    /// Changes to the distribution's uploaded DEP-11 files.
+    dep11_files: Dep11Change,
     changed_package: PublishedPackage,
     orphaned_pool_filename: bool,
 }
 
+#[derive(Debug, Default)]
+struct PackagesDiffChange {
+    /// The patch from the previous version of the index, if one was generated.
+    added: Option<PackagesDiff>,
+    /// The names of patches that are no longer in the history.
+    expired: Vec<String>,
+    /// The index's `Packages.diff/Index` before and after the change, if it
+    /// has any history.
+    previous_index: Option<PackagesDiffIndex>,
+    index: Option<PackagesDiffIndex>,
+}
+
+#[derive(Debug, Default)]
+struct ContentsIndexChange {
+    /// The Contents indexes that were generated. This includes the changed
+    /// index's (unless it was emptied), and any that didn't exist yet because
+    /// Contents indexes were turned on after their packages were added.
+    updated: Vec<ContentsIndex>,
+    /// The changed index's Contents index before the change, if it had one.
+    previous: Option<ContentsIndexMeta>,
+}
+
+impl ContentsIndexChange {
+    /// The changed index's previous Contents index, if it was removed because
+    /// the index was emptied.
+    fn removed(&self) -> Option<&ContentsIndexMeta> {
+        self.previous.as_ref().filter(|previous| {
+            !self.updated.iter().any(|index| {
+                index.meta.component == previous.component
+                    && index.meta.architecture == previous.architecture
+            })
+        })
+    }
+}
+
+#[derive(Debug, Default)]
+struct TranslationIndexChange {
+    /// The Translation indexes that were generated. This includes the changed
+    /// component's, and those of any component that didn't have any yet.
+    updated: Vec<TranslationIndex>,
+    /// The changed component's Translation indexes before the change.
+    previous: Vec<TranslationIndexMeta>,
+}
+
+impl TranslationIndexChange {
+    /// The changed component's previous Translation indexes that were removed,
+    /// because the component was emptied or no longer has packages translated
+    /// to their language.
+    fn removed(&self) -> impl Iterator<Item = &TranslationIndexMeta> {
+        self.previous.iter().filter(|previous| {
+            !self.updated.iter().any(|index| {
+                index.meta.component == previous.component
+                    && index.meta.language == previous.language
+            })
+        })
+    }
+}
+
+#[derive(Debug, Default)]
+struct Dep11Change {
+    /// The distribution's DEP-11 files, including removed ones that the
+    /// previous Release file listed.
+    files: Vec<Dep11FileMeta>,
+    /// The files whose current version is published by this change, with
+    /// their contents.
+    unpublished: Vec<Dep11File>,
+}
+
 /// Given a single package change, generate the new release file and the changed
 /// Packages index based off of the current state of the repository.
 #[instrument(skip(tx))]
@@ -51,16 +172,8 @@ async fn generate_release_file_with_change(
     change: &PackageChange,
     release_ts: OffsetDateTime,
 ) -> Result<PackageChangeResult, ErrorResponse> {
-    // Load the repository. If it does not exist, return an error.
-    sqlx::query!(
-        "SELECT id FROM debian_repository WHERE tenant_id = $1 AND name = $2",
-        tenant_id.0,
-        change.repository
-    )
-    .fetch_optional(&mut **tx)
-    .await
-    .map_err(ErrorResponse::from)?
-    .ok_or(ErrorResponse::not_found("repository"))?;
+    // Load the repository. If it does not exist or is locked, return an error.
+    ensure_unlocked(&mut **tx, tenant_id, &change.repository).await?;
 
     // Load the Release metadata. If the Release has never been created
     // before, use default values.
@@ -82,7 +195,9 @@ async fn generate_release_file_with_change(
 
     // Load the package to be added. If it does not exist, return an error.
     let changed_package = match &change.action {
-        PackageChangeAction::Add { package_sha256sum } => {
+        PackageChangeAction::Add {
+            package_sha256sum, ..
+        } => {
             let package = Package::query_from_sha256sum(&mut *tx, tenant_id, package_sha256sum)
                 .await?
                 .ok_or(ErrorResponse::not_found("package"))?;
@@ -106,7 +221,8 @@ async fn generate_release_file_with_change(
         .ok_or(ErrorResponse::not_found("package"))?,
     };
 
-    // Load the Packages index that will be changed.
+    // Load the Packages index that will be changed. udebs are listed in the
+    // component's `debian-installer` sub-tree instead of its regular indexes.
     //
     // Note that `packages_index_packages` might be empty if this is the first
     // package to be added to this (distribution, component, architecture)
@@ -119,13 +235,32 @@ async fn generate_release_file_with_change(
         &change.distribution,
         &change.component,
         &changed_package.package.architecture,
+        changed_package.package.udeb,
     )
     .await?;
+
+    // Refuse to roll the package back, unless the change allows it.
+    if let PackageChangeAction::Add {
+        allow_downgrade: false, ..
+    } = &change.action
+    {
+        ensure_not_downgrade(
+            tx,
+            tenant_id,
+            &change.repository,
+            &changed_package.package,
+            &packages_index_packages,
+        )
+        .await?;
+    }
+
     let mut changed_packages_index = PackagesIndex::from_packages(
-        &change.component,
+        &changed_package.package.index_component(&change.component),
         &changed_package.package.architecture,
         packages_index_packages,
     );
+    // Keep the index's previous contents to generate its patch.
+    let previous_contents = changed_packages_index.contents.clone();
 
     // Modify the changed Packages index.
     match &change.action {
@@ -146,12 +281,290 @@ async fn generate_release_file_with_change(
     )
     .await?;
 
+    // Keep the changed index's previous checksums to render its previous
+    // `Packages.diff/Index`, whose by-hash files are deleted after the change.
+    let previous_packages_index = packages_indexes
+        .iter()
+        .find(|pi| {
+            pi.component == changed_packages_index.meta.component
+                && pi.architecture == changed_packages_index.meta.architecture
+        })
+        .cloned();
+
     // Update the set of Packages indexes in the Release file.
     let packages_indexes =
         update_release_package_indexes(packages_indexes, &changed_packages_index);
 
+    // Flat repositories publish a single Packages index that combines every
+    // component and architecture.
+    let settings = ReleaseSettings::query(&mut **tx, tenant_id, &change.repository).await?;
+    let flat_packages_index = if settings.flat {
+        ensure_flat_distribution(tx, tenant_id, &change.repository, &change.distribution).await?;
+        if changed_package.package.udeb {
+            return Err(ErrorResponse::new(
+                StatusCode::BAD_REQUEST,
+                "FLAT_REPOSITORY_UDEB",
+                "udebs can't be published in flat repositories, which have no components",
+            ));
+        }
+        let mut indexes = FlatPackagesIndex::query_indexes_from_release(
+            tx,
+            tenant_id,
+            &change.repository,
+            &change.distribution,
+        )
+        .await?;
+        indexes.insert(
+            (
+                changed_packages_index.meta.component.clone(),
+                changed_packages_index.meta.architecture.clone(),
+            ),
+            changed_packages_index.contents.clone(),
+        );
+        Some(FlatPackagesIndex::from_indexes(&indexes))
+    } else {
+        None
+    };
+
+    // Update the changed Packages index's patch history, and list the
+    // `Packages.diff/Index` of every index that has one.
+    let mut packages_diffs = PackagesDiffChange::default();
+    let mut packages_diff_indexes = Vec::new();
+    if settings.pdiffs {
+        let mut history = PackagesDiff::query_from_release(
+            tx,
+            tenant_id,
+            &change.repository,
+            &change.distribution,
+        )
+        .await?;
+        let changed_history = history
+            .entry((
+                changed_packages_index.meta.component.clone(),
+                changed_packages_index.meta.architecture.clone(),
+            ))
+            .or_default();
+        if !changed_history.is_empty() {
+            packages_diffs.previous_index = previous_packages_index
+                .map(|previous| PackagesDiffIndex::render(&previous, changed_history));
+        }
+        if previous_contents.is_empty() || changed_packages_index.contents.is_empty() {
+            // Patches can't create or delete an index, so an index that was
+            // just created or emptied starts a new history.
+            packages_diffs.expired = changed_history.drain(..).map(|diff| diff.name).collect();
+        } else if previous_contents != changed_packages_index.contents {
+            let diff = PackagesDiff::between(
+                &previous_contents,
+                &changed_packages_index.contents,
+                release_ts,
+            );
+            changed_history.push(diff.clone());
+            packages_diffs.added = Some(diff);
+            let expired = changed_history.len().saturating_sub(PDIFF_HISTORY_LENGTH);
+            packages_diffs.expired = changed_history
+                .drain(..expired)
+                .map(|diff| diff.name)
+                .collect();
+        }
+        for packages_index in &packages_indexes {
+            let Some(history) = history.get(&(
+                packages_index.component.clone(),
+                packages_index.architecture.clone(),
+            )) else {
+                continue;
+            };
+            if history.is_empty() {
+                continue;
+            }
+            let diff_index = PackagesDiffIndex::render(packages_index, history);
+            if packages_index.component == changed_packages_index.meta.component
+                && packages_index.architecture == changed_packages_index.meta.architecture
+            {
+                packages_diffs.index = Some(diff_index.clone());
+            }
+            packages_diff_indexes.push(diff_index.release_entry());
+        }
+    }
+
+    // Regenerate the changed index's Contents index, and list the Contents
+    // index of every published component and architecture.
+    let mut contents_indexes = ContentsIndexChange::default();
+    let mut contents_index_entries = Vec::new();
+    if settings.contents_indexes {
+        let existing = ContentsIndexMeta::query_from_release(
+            tx,
+            tenant_id,
+            &change.repository,
+            &change.distribution,
+        )
+        .await?;
+        let is_changed = |component: &str, architecture: &str| {
+            component == changed_packages_index.meta.component
+                && architecture == changed_packages_index.meta.architecture
+        };
+        contents_indexes.previous = existing
+            .iter()
+            .find(|meta| is_changed(&meta.component, &meta.architecture))
+            .cloned();
+        for packages_index in &packages_indexes {
+            // udebs aren't listed in Contents indexes.
+            if split_index_component(&packages_index.component).1 {
+                continue;
+            }
+            let changed = is_changed(&packages_index.component, &packages_index.architecture);
+            if !changed {
+                let meta = existing.iter().find(|meta| {
+                    meta.component == packages_index.component
+                        && meta.architecture == packages_index.architecture
+                });
+                if let Some(meta) = meta {
+                    contents_index_entries.push(meta.release_entry());
+                    continue;
+                }
+            }
+            let mut packages = ContentsPackage::query_from_packages_index(
+                tx,
+                tenant_id,
+                &change.repository,
+                &change.distribution,
+                &packages_index.component,
+                &packages_index.architecture,
+            )
+            .await?;
+            if changed {
+                packages.retain(|package| package.sha256sum != changed_package.package.sha256sum);
+                if let PackageChangeAction::Add {
+                    package_sha256sum, ..
+                } = &change.action
+                {
+                    packages.extend(
+                        ContentsPackage::query_from_sha256sum(tx, tenant_id, package_sha256sum)
+                            .await?,
+                    );
+                }
+            }
+            let index = ContentsIndex::from_packages(
+                &packages_index.component,
+                &packages_index.architecture,
+                &packages,
+            );
+            contents_index_entries.push(index.meta.release_entry());
+            contents_indexes.updated.push(index);
+        }
+    }
+
+    // Regenerate the changed component's Translation indexes, and list the
+    // Translation indexes of every published component.
+    let mut translation_indexes = TranslationIndexChange::default();
+    let mut translation_index_entries = Vec::new();
+    if settings.translations {
+        let existing = TranslationIndexMeta::query_from_release(
+            tx,
+            tenant_id,
+            &change.repository,
+            &change.distribution,
+        )
+        .await?;
+        // udebs aren't listed in Translation indexes, so changing one doesn't
+        // change its component's.
+        let changed_component = match changed_package.package.udeb {
+            true => None,
+            false => Some(change.component.as_str()),
+        };
+        translation_indexes.previous = existing
+            .iter()
+            .filter(|meta| Some(meta.component.as_str()) == changed_component)
+            .cloned()
+            .collect();
+        let components = packages_indexes
+            .iter()
+            .map(|packages_index| split_index_component(&packages_index.component).0)
+            .collect::<BTreeSet<_>>();
+        for component in components {
+            let changed = Some(component) == changed_component;
+            if !changed {
+                let metas = existing
+                    .iter()
+                    .filter(|meta| meta.component == component)
+                    .collect::<Vec<_>>();
+                if !metas.is_empty() {
+                    translation_index_entries
+                        .extend(metas.into_iter().map(TranslationIndexMeta::release_entry));
+                    continue;
+                }
+            }
+            let mut packages = TranslationPackage::query_from_component(
+                tx,
+                tenant_id,
+                &change.repository,
+                &change.distribution,
+                component,
+            )
+            .await?;
+            if changed {
+                packages.retain(|package| package.sha256sum != changed_package.package.sha256sum);
+                if let PackageChangeAction::Add {
+                    package_sha256sum, ..
+                } = &change.action
+                {
+                    packages.extend(
+                        TranslationPackage::query_from_sha256sum(tx, tenant_id, package_sha256sum)
+                            .await?,
+                    );
+                }
+            }
+            for index in TranslationIndex::from_packages(component, &packages) {
+                translation_index_entries.push(index.meta.release_entry());
+                translation_indexes.updated.push(index);
+            }
+        }
+    }
+
+    // List the distribution's uploaded DEP-11 files, and load the ones that
+    // this change publishes. Flat repositories don't have components to
+    // publish them in.
+    let mut dep11_files = Dep11Change::default();
+    if !settings.flat {
+        dep11_files.files = Dep11FileMeta::query_from_release(
+            tx,
+            tenant_id,
+            &change.repository,
+            &change.distribution,
+        )
+        .await?;
+        if dep11_files.files.iter().any(|file| !file.removed && !file.is_published()) {
+            dep11_files.unpublished = Dep11File::query_from_release(
+                tx,
+                tenant_id,
+                &change.repository,
+                &change.distribution,
+                false,
+            )
+            .await?;
+        }
+    }
+    let dep11_entries = dep11_files
+        .files
+        .iter()
+        .filter(|file| !file.removed)
+        .map(Dep11FileMeta::release_entry)
+        .collect::<Vec<_>>();
+
     // Construct the new Release file.
-    let release_file = ReleaseFile::from_indexes(release, release_ts, &packages_indexes);
+    let release_file = ReleaseFile::from_indexes(
+        release,
+        &settings,
+        release_ts,
+        &packages_indexes,
+        flat_packages_index.as_ref(),
+        &[
+            packages_diff_indexes,
+            contents_index_entries,
+            translation_index_entries,
+            dep11_entries,
+        ]
+        .concat(),
+    );
 
     // Determine whether there exist other component-packages with the same
     // filename. In the case of removals, this is used to clean up orphaned pool
@@ -186,11 +599,56 @@ async fn generate_release_file_with_change(
     Ok(PackageChangeResult {
         release_file,
         changed_packages_index,
+        flat_packages_index,
+        packages_diffs,
+        contents_indexes,
+        translation_indexes,
+        dep11_files,
         changed_package,
         orphaned_pool_filename: remaining_component_packages.count == 0,
     })
 }
 
+/// Flat repositories are published at their root, so they can only have one
+/// distribution. Return an error if the repository already has a different
+/// one.
+pub(crate) async fn ensure_flat_distribution(
+    tx: &mut Transaction<'_, Postgres>,
+    tenant_id: &TenantID,
+    repository: &str,
+    distribution: &str,
+) -> Result<(), ErrorResponse> {
+    let existing = sqlx::query_scalar!(
+        r#"
+        SELECT debian_repository_release.distribution
+        FROM
+            debian_repository
+            JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+        WHERE
+            debian_repository.tenant_id = $1
+            AND debian_repository.name = $2
+            AND debian_repository_release.distribution <> $3
+        LIMIT 1
+        "#,
+        tenant_id.0,
+        repository,
+        distribution,
+    )
+    .fetch_optional(&mut **tx)
+    .await
+    .map_err(ErrorResponse::from)?;
+    match existing {
+        Some(existing) => Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "FLAT_REPOSITORY_DISTRIBUTION",
+            format!(
+                "flat repositories have only one distribution, and this repository's is {existing:?}"
+            ),
+        )),
+        None => Ok(()),
+    }
+}
+
 // Update the set of `Packages` indexes in the Release file. This function is
 // refactored out for purity so we can unit test it.
 fn update_release_package_indexes(
@@ -228,8 +686,60 @@ fn update_release_package_indexes(
     }
 }
 
+/// Refuse to add a lower version of a package than the latest version of it in
+/// the Packages index, if the repository prevents downgrades.
+async fn ensure_not_downgrade(
+    tx: &mut Transaction<'_, Postgres>,
+    tenant_id: &TenantID,
+    repository: &str,
+    added: &Package,
+    index_packages: &[PublishedPackage],
+) -> Result<(), ErrorResponse> {
+    // Versions that can't be parsed can't be compared, so they're never
+    // considered downgrades.
+    let Ok(version) = PackageVersion::parse(&added.version) else {
+        return Ok(());
+    };
+    let latest = index_packages
+        .iter()
+        .filter(|published| published.package.name == added.name)
+        .filter_map(|published| PackageVersion::parse(&published.package.version).ok())
+        .max();
+    let latest = match latest {
+        Some(latest) if latest > version => latest,
+        _ => return Ok(()),
+    };
+
+    let prevent_downgrades = sqlx::query_scalar!(
+        r#"
+        SELECT prevent_downgrades
+        FROM debian_repository
+        WHERE tenant_id = $1 AND name = $2
+        "#,
+        tenant_id.0,
+        repository,
+    )
+    .fetch_optional(&mut **tx)
+    .await
+    .map_err(ErrorResponse::from)?
+    .ok_or(ErrorResponse::not_found("repository"))?;
+    if !prevent_downgrades {
+        return Ok(());
+    }
+    Err(ErrorResponse::new(
+        StatusCode::BAD_REQUEST,
+        "VERSION_DOWNGRADE",
+        format!(
+            "{} {} ({}) is lower than the latest version in the index, {latest}, and repository {repository:?} prevents downgrades",
+            added.name, added.version, added.architecture
+        ),
+    ))
+}
+
 #[cfg(test)]
 mod tests {
+    use std::io::Read as _;
+
     use super::*;
 
     /// Packages with different architectures should be separated into their own
@@ -248,6 +758,7 @@ mod tests {
             component: String::from("main"),
             action: PackageChangeAction::Add {
                 package_sha256sum: String::from("amd64sha256sum"),
+                allow_downgrade: false,
             },
         };
         let amd64_result =
@@ -279,6 +790,7 @@ mod tests {
             component: String::from("main"),
             action: PackageChangeAction::Add {
                 package_sha256sum: String::from("arm64sha256sum"),
+                allow_downgrade: false,
             },
         };
         let arm64_result =
@@ -369,6 +881,7 @@ mod tests {
             component: String::from("main"),
             action: PackageChangeAction::Add {
                 package_sha256sum: String::from("amd64sha256sum"),
+                allow_downgrade: false,
             },
         };
         let result = generate_release_file_with_change(&mut tx, &tenant_id, &change, release_ts)
@@ -398,4 +911,416 @@ mod tests {
 
         tx.rollback().await.unwrap();
     }
+
+    /// Repositories with pdiffs enabled should publish a patch for each
+    /// changed Packages index.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR", fixtures("setup_multi_arch"))]
+    async fn pdiffs_generate_patch(pool: sqlx::PgPool) {
+        let mut tx = pool.begin().await.unwrap();
+        let tenant_id = crate::api::TenantID(1);
+        sqlx::query("UPDATE debian_repository SET pdiffs = true WHERE name = 'test-multi-arch'")
+            .execute(&mut *tx)
+            .await
+            .unwrap();
+        sqlx::query(
+            r#"
+            INSERT INTO debian_repository_package (id, tenant_id, package, version, architecture, maintainer, description, paragraph, size, s3_bucket, md5sum, sha1sum, sha256sum, created_at, updated_at)
+            VALUES (1003, 1, 'other-package', '1.0.0', 'amd64', 'test@example.com', 'Other package', '{"Package": "other-package", "Version": "1.0.0", "Architecture": "amd64"}'::jsonb, 1024, 'attune-test-0', 'othermd5sum', 'othersha1sum', 'othersha256sum', NOW(), NOW())
+            "#,
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+
+        let change = PackageChange {
+            repository: String::from("test-multi-arch"),
+            distribution: String::from("stable"),
+            component: String::from("main"),
+            action: PackageChangeAction::Add {
+                package_sha256sum: String::from("othersha256sum"),
+                allow_downgrade: false,
+            },
+        };
+        let result = generate_release_file_with_change(
+            &mut tx,
+            &tenant_id,
+            &change,
+            OffsetDateTime::now_utc(),
+        )
+        .await
+        .expect("Failed to generate release file");
+        let diff = result
+            .packages_diffs
+            .added
+            .expect("changed index should have a patch");
+        assert!(result.packages_diffs.expired.is_empty());
+        let index = result
+            .packages_diffs
+            .index
+            .expect("changed index should have a Packages.diff/Index");
+        assert!(index.contents.contains(&diff.name));
+        assert!(
+            result
+                .release_file
+                .contents
+                .contains("main/binary-amd64/Packages.diff/Index"),
+            "Release file should reference the amd64 Packages.diff/Index"
+        );
+        assert!(
+            !result
+                .release_file
+                .contents
+                .contains("main/binary-arm64/Packages.diff/Index"),
+            "Release file should not reference an unchanged index's history"
+        );
+
+        tx.rollback().await.unwrap();
+    }
+
+    /// Contents indexes should list the files of the changed package, and be
+    /// generated for indexes that don't have one yet.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR", fixtures("setup_multi_arch"))]
+    async fn contents_indexes_list_files(pool: sqlx::PgPool) {
+        let mut tx = pool.begin().await.unwrap();
+        let tenant_id = crate::api::TenantID(1);
+        sqlx::query(
+            "UPDATE debian_repository SET contents_indexes = true WHERE name = 'test-multi-arch'",
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+        sqlx::query(
+            "UPDATE debian_repository_package SET files = ARRAY['usr/bin/test-package'] WHERE id = 1001",
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+        sqlx::query(
+            r#"
+            INSERT INTO debian_repository_package (id, tenant_id, package, version, architecture, section, maintainer, description, paragraph, size, s3_bucket, md5sum, sha1sum, sha256sum, files, created_at, updated_at)
+            VALUES (1003, 1, 'other-package', '1.0.0', 'amd64', 'utils', 'test@example.com', 'Other package', '{"Package": "other-package", "Version": "1.0.0", "Architecture": "amd64"}'::jsonb, 1024, 'attune-test-0', 'othermd5sum', 'othersha1sum', 'othersha256sum', ARRAY['usr/bin/other-package'], NOW(), NOW())
+            "#,
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+
+        let change = PackageChange {
+            repository: String::from("test-multi-arch"),
+            distribution: String::from("stable"),
+            component: String::from("main"),
+            action: PackageChangeAction::Add {
+                package_sha256sum: String::from("othersha256sum"),
+                allow_downgrade: false,
+            },
+        };
+        let result = generate_release_file_with_change(
+            &mut tx,
+            &tenant_id,
+            &change,
+            OffsetDateTime::now_utc(),
+        )
+        .await
+        .expect("Failed to generate release file");
+        assert!(result.contents_indexes.previous.is_none());
+        let amd64 = result
+            .contents_indexes
+            .updated
+            .iter()
+            .find(|index| index.meta.architecture == "amd64")
+            .expect("amd64 should have a Contents index");
+        let mut contents = String::new();
+        flate2::read::GzDecoder::new(amd64.contents.as_slice())
+            .read_to_string(&mut contents)
+            .unwrap();
+        assert_eq!(
+            contents,
+            "usr/bin/other-package utils/other-package\nusr/bin/test-package test-package\n"
+        );
+        assert!(
+            result
+                .contents_indexes
+                .updated
+                .iter()
+                .any(|index| index.meta.architecture == "arm64"),
+            "arm64 should get a Contents index even though it didn't change"
+        );
+        assert!(
+            result
+                .release_file
+                .contents
+                .contains("main/Contents-amd64.gz"),
+            "Release file should reference the amd64 Contents index"
+        );
+
+        tx.rollback().await.unwrap();
+    }
+
+    /// Translation indexes should list the descriptions of every package in the
+    /// changed component, including uploaded translations.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR", fixtures("setup_multi_arch"))]
+    async fn translation_indexes_list_descriptions(pool: sqlx::PgPool) {
+        let mut tx = pool.begin().await.unwrap();
+        let tenant_id = crate::api::TenantID(1);
+        sqlx::query(
+            "UPDATE debian_repository SET translations = true WHERE name = 'test-multi-arch'",
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+        sqlx::query(
+            r#"
+            INSERT INTO debian_repository_package_translation (package_id, language, description, created_at, updated_at)
+            VALUES (1002, 'de', 'Testpaket für arm64', NOW(), NOW())
+            "#,
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+
+        let change = PackageChange {
+            repository: String::from("test-multi-arch"),
+            distribution: String::from("stable"),
+            component: String::from("main"),
+            action: PackageChangeAction::Add {
+                package_sha256sum: String::from("amd64sha256sum"),
+                allow_downgrade: false,
+            },
+        };
+        let result = generate_release_file_with_change(
+            &mut tx,
+            &tenant_id,
+            &change,
+            OffsetDateTime::now_utc(),
+        )
+        .await
+        .expect("Failed to generate release file");
+        let languages = result
+            .translation_indexes
+            .updated
+            .iter()
+            .map(|index| index.meta.language.as_str())
+            .collect::<Vec<_>>();
+        assert_eq!(languages, ["de", "en"]);
+        let english = &result.translation_indexes.updated[1].contents;
+        assert!(english.contains("Description-en: Test package for amd64\n"));
+        assert!(english.contains("Description-en: Test package for arm64\n"));
+        assert!(
+            result
+                .release_file
+                .contents
+                .contains("main/i18n/Translation-de"),
+            "Release file should reference the German Translation index"
+        );
+
+        tx.rollback().await.unwrap();
+    }
+
+    /// DEP-11 files should be listed in the Release file until they're removed,
+    /// and unpublished files should be loaded so that they can be uploaded.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR", fixtures("setup_multi_arch"))]
+    async fn dep11_files_are_listed(pool: sqlx::PgPool) {
+        let mut tx = pool.begin().await.unwrap();
+        let tenant_id = crate::api::TenantID(1);
+        sqlx::query(
+            r#"
+            INSERT INTO debian_repository_dep11_file (release_id, component, name, size, contents, md5sum, sha256sum, published_md5sum, published_sha256sum, removed, updated_at)
+            SELECT debian_repository_release.id, 'main', file.name, 2, '\x1f8b', file.name || 'md5sum', file.name || 'sha256sum', file.published_md5sum, file.published_sha256sum, file.removed, NOW()
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id,
+                (VALUES
+                    ('Components-amd64.yml.gz', NULL, NULL, false),
+                    ('icons-64x64.tar.gz', 'oldmd5sum', 'oldsha256sum', true)
+                ) AS file (name, published_md5sum, published_sha256sum, removed)
+            WHERE debian_repository.name = 'test-multi-arch' AND debian_repository_release.distribution = 'stable'
+            "#,
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+
+        let change = PackageChange {
+            repository: String::from("test-multi-arch"),
+            distribution: String::from("stable"),
+            component: String::from("main"),
+            action: PackageChangeAction::Add {
+                package_sha256sum: String::from("amd64sha256sum"),
+                allow_downgrade: false,
+            },
+        };
+        let result = generate_release_file_with_change(
+            &mut tx,
+            &tenant_id,
+            &change,
+            OffsetDateTime::now_utc(),
+        )
+        .await
+        .expect("Failed to generate release file");
+        let unpublished = result
+            .dep11_files
+            .unpublished
+            .iter()
+            .map(|file| file.meta.name.as_str())
+            .collect::<Vec<_>>();
+        assert_eq!(unpublished, ["Components-amd64.yml.gz"]);
+        assert!(
+            result
+                .release_file
+                .contents
+                .contains("main/dep11/Components-amd64.yml.gz"),
+            "Release file should reference the uploaded Components file"
+        );
+        assert!(
+            !result
+                .release_file
+                .contents
+                .contains("main/dep11/icons-64x64.tar.gz"),
+            "Release file should not reference the removed icons"
+        );
+
+        tx.rollback().await.unwrap();
+    }
+
+    /// Locked repositories should reject changes with an error that includes
+    /// the lock's reason.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR", fixtures("setup_multi_arch"))]
+    async fn locked_repository_rejects_changes(pool: sqlx::PgPool) {
+        let mut tx = pool.begin().await.unwrap();
+        let tenant_id = crate::api::TenantID(1);
+        sqlx::query(
+            "UPDATE debian_repository SET locked_at = NOW(), lock_reason = 'incident' WHERE name = 'test-multi-arch'",
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+
+        let change = PackageChange {
+            repository: String::from("test-multi-arch"),
+            distribution: String::from("stable"),
+            component: String::from("main"),
+            action: PackageChangeAction::Add {
+                package_sha256sum: String::from("amd64sha256sum"),
+                allow_downgrade: false,
+            },
+        };
+        let error = generate_release_file_with_change(
+            &mut tx,
+            &tenant_id,
+            &change,
+            OffsetDateTime::now_utc(),
+        )
+        .await
+        .expect_err("locked repository should reject changes");
+        assert_eq!(error.error, "REPOSITORY_LOCKED");
+        assert!(error.message.contains("incident"), "{}", error.message);
+
+        tx.rollback().await.unwrap();
+    }
+
+    /// Repositories that prevent downgrades should reject lower versions of a
+    /// package than the latest in its index, unless the change allows it.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR", fixtures("setup_multi_arch"))]
+    async fn prevent_downgrades(pool: sqlx::PgPool) {
+        let mut tx = pool.begin().await.unwrap();
+        let tenant_id = crate::api::TenantID(1);
+        sqlx::query(
+            r#"
+            INSERT INTO debian_repository_package (id, tenant_id, package, version, architecture, maintainer, description, paragraph, size, s3_bucket, md5sum, sha1sum, sha256sum, created_at, updated_at)
+            VALUES (
+                1003,
+                1,
+                'test-package',
+                '1:0.9.0',
+                'amd64'::debian_repository_architecture,
+                'test@example.com',
+                'Older test package for amd64',
+                '{"Package": "test-package", "Version": "1:0.9.0", "Architecture": "amd64", "Maintainer": "test@example.com", "Description": "Older test package for amd64"}'::jsonb,
+                1024,
+                'attune-test-0',
+                'epochmd5sum',
+                'epochsha1sum',
+                'epochsha256sum',
+                NOW(),
+                NOW()
+            ), (
+                1004,
+                1,
+                'test-package',
+                '0.9.0',
+                'amd64'::debian_repository_architecture,
+                'test@example.com',
+                'Old test package for amd64',
+                '{"Package": "test-package", "Version": "0.9.0", "Architecture": "amd64", "Maintainer": "test@example.com", "Description": "Old test package for amd64"}'::jsonb,
+                1024,
+                'attune-test-0',
+                'oldmd5sum',
+                'oldsha1sum',
+                'oldsha256sum',
+                NOW(),
+                NOW()
+            )
+            "#,
+        )
+        .execute(&mut *tx)
+        .await
+        .unwrap();
+        let change = |package_sha256sum: &str, allow_downgrade| PackageChange {
+            repository: String::from("test-multi-arch"),
+            distribution: String::from("stable"),
+            component: String::from("main"),
+            action: PackageChangeAction::Add {
+                package_sha256sum: package_sha256sum.to_string(),
+                allow_downgrade,
+            },
+        };
+        let release_ts = OffsetDateTime::now_utc();
+
+        // Downgrades are allowed unless the repository prevents them.
+        generate_release_file_with_change(
+            &mut tx,
+            &tenant_id,
+            &change("oldsha256sum", false),
+            release_ts,
+        )
+        .await
+        .expect("downgrades should be allowed by default");
+
+        sqlx::query("UPDATE debian_repository SET prevent_downgrades = true WHERE id = 1000")
+            .execute(&mut *tx)
+            .await
+            .unwrap();
+        let error = generate_release_file_with_change(
+            &mut tx,
+            &tenant_id,
+            &change("oldsha256sum", false),
+            release_ts,
+        )
+        .await
+        .expect_err("downgrade should be rejected");
+        assert_eq!(error.error, "VERSION_DOWNGRADE");
+        assert!(error.message.contains("1.0.0"), "{}", error.message);
+
+        generate_release_file_with_change(
+            &mut tx,
+            &tenant_id,
+            &change("oldsha256sum", true),
+            release_ts,
+        )
+        .await
+        .expect("downgrade should be allowed explicitly");
+
+        // Versions are compared like Debian versions, so a higher epoch wins
+        // over a higher upstream version.
+        generate_release_file_with_change(
+            &mut tx,
+            &tenant_id,
+            &change("epochsha256sum", false),
+            release_ts,
+        )
+        .await
+        .expect("upgrade should be allowed");
+
+        tx.rollback().await.unwrap();
+    }
 }
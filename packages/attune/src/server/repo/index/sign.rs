@@ -16,15 +16,17 @@ use time::OffsetDateTime;
 use tracing::{debug, instrument};
 
 use crate::{
-    api::{ErrorResponse, TenantID},
+    api::{Actor, ErrorResponse, TenantID},
     server::{
         ServerState,
+        audit::{self, AuditAction, AuditRecord},
         repo::{
             decode_repo_name,
             index::{
                 PackageChange, PackageChangeAction, PackageChangeResult,
-                generate_release_file_with_change,
+                generate_release_file_with_change, request_changes,
             },
+            release_prefix,
         },
     },
 };
@@ -32,6 +34,10 @@ use crate::{
 #[derive(Serialize, Deserialize, Debug)]
 pub struct SignIndexRequest {
     pub change: PackageChange,
+    /// Changes to apply after `change`, in order, in the same transaction. The
+    /// signed Release file publishes all of them.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub then: Vec<PackageChange>,
     pub release_ts: OffsetDateTime,
     pub clearsigned: String,
     pub detachsigned: String,
@@ -45,30 +51,27 @@ pub struct SignIndexResponse {}
 #[instrument(skip(state, req))]
 pub async fn handler(
     State(state): State<ServerState>,
-    tenant_id: TenantID,
+    actor: Actor,
     Path(repo_name): Path<String>,
     Json(req): Json<SignIndexRequest>,
 ) -> Result<Json<SignIndexResponse>, ErrorResponse> {
     debug!(?req, "signing index");
+    let tenant_id = actor.tenant_id;
 
     // The repository name in the path is percent-encoded.
     let repo_name = decode_repo_name(&repo_name)?;
-    if repo_name != req.change.repository {
-        return Err(ErrorResponse::new(
-            StatusCode::BAD_REQUEST,
-            "REPOSITORY_MISMATCH".to_string(),
-            "repository name in path does not match repository name in request".to_string(),
-        ));
-    }
-
-    if !lazy_regex!(r"^[a-zA-Z0-9_-]+$").is_match(&req.change.component) {
-        return Err(ErrorResponse::new(
-            StatusCode::BAD_REQUEST,
-            String::from("INVALID_COMPONENT_NAME"),
-            String::from(
-                "component name must contain only letters, numbers, underscores, and hyphens",
-            ),
-        ));
+    let changes = request_changes(&repo_name, &req.change, &req.then)?;
+
+    for change in &changes {
+        if !lazy_regex!(r"^[a-zA-Z0-9_-]+$").is_match(&change.component) {
+            return Err(ErrorResponse::new(
+                StatusCode::BAD_REQUEST,
+                String::from("INVALID_COMPONENT_NAME"),
+                String::from(
+                    "component name must contain only letters, numbers, underscores, and hyphens",
+                ),
+            ));
+        }
     }
 
     // Start a Serializable database transaction.
@@ -82,7 +85,7 @@ pub async fn handler(
     let repo = sqlx::query_as!(
         Repository,
         r#"
-        SELECT s3_bucket, s3_prefix
+        SELECT s3_bucket, s3_prefix, flat
         FROM debian_repository
         WHERE tenant_id = $1 AND name = $2
         "#,
@@ -94,8 +97,25 @@ pub async fn handler(
     .map_err(ErrorResponse::from)?
     .ok_or(ErrorResponse::not_found("repository"))?;
 
-    // Apply the change to the database.
-    let (result, previous_by_hash_indexes) = apply_change_to_db(&mut tx, &tenant_id, &req).await?;
+    // Apply the changes to the database.
+    let applied = apply_change_to_db(&mut tx, &tenant_id, &req, &changes).await?;
+    for applied in &applied {
+        audit::record(
+            &mut *tx,
+            &actor,
+            &AuditRecord {
+                action: match applied.change.action {
+                    PackageChangeAction::Add { .. } => AuditAction::PackageAdd,
+                    PackageChangeAction::Remove { .. } => AuditAction::PackageRemove,
+                },
+                repository: &applied.change.repository,
+                distribution: &applied.change.distribution,
+                component: Some(&applied.change.component),
+                package: Some(&applied.result.changed_package.package),
+            },
+        )
+        .await?;
+    }
 
     // Commit the transaction. At this point, the transaction may abort because
     // of a concurrent index change. This should trigger the client to retry.
@@ -123,16 +143,26 @@ pub async fn handler(
     // unlikely, but there is no good mitigation here besides a cron job. Note
     // that any _subsequent_ upload will still upload the correct indexes,
     // because the _database_ state is transactionally consistent.
-    apply_change_to_s3(&state.s3, &repo, &req, &result, previous_by_hash_indexes).await;
+    apply_change_to_s3(&state.s3, &repo, &req, &applied).await;
 
     Ok(Json(SignIndexResponse {}))
 }
 
-async fn apply_change_to_db(
+/// A change that was saved to the database, with what's needed to apply it to
+/// S3.
+#[derive(Debug)]
+pub(super) struct AppliedChange<'a> {
+    change: &'a PackageChange,
+    result: PackageChangeResult,
+    previous_by_hash_indexes: Option<PreviousByHashIndexes>,
+}
+
+async fn apply_change_to_db<'a>(
     tx: &mut sqlx::Transaction<'_, sqlx::Postgres>,
     tenant_id: &TenantID,
     req: &SignIndexRequest,
-) -> Result<(PackageChangeResult, Option<PreviousByHashIndexes>), ErrorResponse> {
+    changes: &[&'a PackageChange],
+) -> Result<Vec<AppliedChange<'a>>, ErrorResponse> {
     // Verify the request cleartext signature.
     let (public_key, _headers) = SignedPublicKey::from_string(&req.public_key_cert)
         .expect("could not parse public key certificate");
@@ -155,11 +185,17 @@ async fn apply_change_to_db(
         ));
     }
 
+    // Apply the changes before the last one, so that the last one is replayed
+    // onto their result.
+    let (&last, earlier) = changes.split_last().expect("requests have at least one change");
+    let mut applied =
+        stage_changes(tx, tenant_id, earlier, req.release_ts, &req.clearsigned, &req.detachsigned)
+            .await?;
+
     // Replay the diff onto the current state of the index. Since index
     // generation is deterministic, this should yield the same index that was
     // signed locally.
-    let result =
-        generate_release_file_with_change(tx, tenant_id, &req.change, req.release_ts).await?;
+    let result = generate_release_file_with_change(tx, tenant_id, last, req.release_ts).await?;
     debug!(?result, "replayed index");
 
     // Compare the replayed index with the signed index.
@@ -179,19 +215,75 @@ async fn apply_change_to_db(
     }
 
     // Save the new state to the database.
-    let previous_by_hash_indexes = match req.change.action {
-        PackageChangeAction::Add { .. } => add_package_to_db(tx, tenant_id, req, &result).await?,
+    let previous_by_hash_indexes =
+        save_change_to_db(tx, tenant_id, last, &result, &req.clearsigned, &req.detachsigned)
+            .await?;
+
+    // Removals don't save the Release file, so if an earlier change saved
+    // its own, replace it with the signed one.
+    if !applied.is_empty() && matches!(last.action, PackageChangeAction::Remove { .. }) {
+        save_release_to_db(tx, tenant_id, last, &result, &req.clearsigned, &req.detachsigned)
+            .await?;
+    }
+
+    applied.push(AppliedChange {
+        change: last,
+        result,
+        previous_by_hash_indexes,
+    });
+    Ok(applied)
+}
+
+/// Save changes to the database in order, replaying each one onto the result
+/// of those before it. Only the Release file of the last change publishes all
+/// of them.
+pub(super) async fn stage_changes<'a>(
+    tx: &mut sqlx::Transaction<'_, sqlx::Postgres>,
+    tenant_id: &TenantID,
+    changes: &[&'a PackageChange],
+    release_ts: OffsetDateTime,
+    clearsigned: &str,
+    detachsigned: &str,
+) -> Result<Vec<AppliedChange<'a>>, ErrorResponse> {
+    let mut applied = Vec::new();
+    for &change in changes {
+        let result = generate_release_file_with_change(tx, tenant_id, change, release_ts).await?;
+        let previous_by_hash_indexes =
+            save_change_to_db(tx, tenant_id, change, &result, clearsigned, detachsigned).await?;
+        applied.push(AppliedChange {
+            change,
+            result,
+            previous_by_hash_indexes,
+        });
+    }
+    Ok(applied)
+}
+
+async fn save_change_to_db(
+    tx: &mut sqlx::Transaction<'_, sqlx::Postgres>,
+    tenant_id: &TenantID,
+    change: &PackageChange,
+    result: &PackageChangeResult,
+    clearsigned: &str,
+    detachsigned: &str,
+) -> Result<Option<PreviousByHashIndexes>, ErrorResponse> {
+    let previous_by_hash_indexes = match &change.action {
+        PackageChangeAction::Add { .. } => {
+            add_package_to_db(tx, tenant_id, change, result, clearsigned, detachsigned).await?
+        }
         PackageChangeAction::Remove {
-            ref name,
-            ref version,
-            ref architecture,
+            name,
+            version,
+            architecture,
         } => Some(
-            remove_package_from_db(tx, tenant_id, req, &result, name, version, architecture)
+            remove_package_from_db(tx, tenant_id, change, result, name, version, architecture)
                 .await?,
         ),
     };
-
-    Ok((result, previous_by_hash_indexes))
+    save_contents_indexes_to_db(tx, tenant_id, change, result).await?;
+    save_translation_indexes_to_db(tx, tenant_id, change, result).await?;
+    save_dep11_files_to_db(tx, result).await?;
+    Ok(previous_by_hash_indexes)
 }
 
 #[derive(Debug)]
@@ -204,147 +296,16 @@ struct PreviousByHashIndexes {
 async fn add_package_to_db(
     tx: &mut sqlx::Transaction<'_, sqlx::Postgres>,
     tenant_id: &TenantID,
-    req: &SignIndexRequest,
+    change: &PackageChange,
     update: &PackageChangeResult,
+    clearsigned: &str,
+    detachsigned: &str,
 ) -> Result<Option<PreviousByHashIndexes>, ErrorResponse> {
     // First, we update-or-create the Release. Remember, it's possible that no
     // package has ever been added to this distribution, so the Release may not
     // exist.
-    let release_id = match sqlx::query!(r#"
-        SELECT
-            debian_repository_release.id,
-            debian_repository_release.description,
-            debian_repository_release.origin,
-            debian_repository_release.label,
-            debian_repository_release.version,
-            debian_repository_release.suite,
-            debian_repository_release.codename,
-            debian_repository_release.contents,
-            debian_repository_release.clearsigned,
-            debian_repository_release.detached
-        FROM
-            debian_repository
-            JOIN debian_repository_release ON debian_repository.id = debian_repository_release.repository_id
-        WHERE
-            debian_repository.tenant_id = $1
-            AND debian_repository.name = $2
-            AND debian_repository_release.distribution = $3
-        LIMIT 1
-        "#,
-        tenant_id.0,
-        req.change.repository,
-        req.change.distribution,
-    )
-    .fetch_optional(&mut **tx)
-    .await
-    .map_err(ErrorResponse::from)? {
-        Some(release) => {
-            // If the release already exists, check whether any fields need to
-            // be updated. If so, update them.
-            if release.description != update.release_file.meta.description ||
-                release.origin != update.release_file.meta.origin ||
-                release.label != update.release_file.meta.label ||
-                release.version != update.release_file.meta.version ||
-                release.suite != update.release_file.meta.suite ||
-                release.codename != update.release_file.meta.codename ||
-                release.contents != update.release_file.contents ||
-                release.clearsigned.is_none() ||
-                release.clearsigned.is_some_and(|clearsigned| clearsigned != req.clearsigned) ||
-                release.detached.is_none() ||
-                release.detached.is_some_and(|detached| detached != req.detachsigned) {
-                sqlx::query!(
-                    r#"
-                    UPDATE
-                        debian_repository_release
-                    SET
-                        description = $2,
-                        origin = $3,
-                        label = $4,
-                        version = $5,
-                        suite = $6,
-                        codename = $7,
-                        contents = $8,
-                        clearsigned = $9,
-                        detached = $10,
-                        updated_at = NOW()
-                    WHERE
-                        id = $1
-                    "#,
-                    release.id,
-                    update.release_file.meta.description,
-                    update.release_file.meta.origin,
-                    update.release_file.meta.label,
-                    update.release_file.meta.version,
-                    update.release_file.meta.suite,
-                    update.release_file.meta.codename,
-                    update.release_file.contents,
-                    req.clearsigned,
-                    req.detachsigned,
-                )
-                .execute(&mut **tx)
-                .await
-                .map_err(ErrorResponse::from)?;
-            }
-            release.id
-        },
-        None => {
-            // If the release doesn't exist, create it with default values.
-            let release = sqlx::query!(
-                r#"
-                INSERT INTO debian_repository_release (
-                    repository_id,
-                    distribution,
-                    description,
-                    origin,
-                    label,
-                    version,
-                    suite,
-                    codename,
-                    contents,
-                    clearsigned,
-                    detached,
-                    created_at,
-                    updated_at
-                )
-                SELECT
-                    debian_repository.id,
-                    $3,
-                    $4,
-                    $5,
-                    $6,
-                    $7,
-                    $8,
-                    $9,
-                    $10,
-                    $11,
-                    $12,
-                    NOW(),
-                    NOW()
-                FROM debian_repository
-                WHERE
-                    debian_repository.tenant_id = $1
-                    AND debian_repository.name = $2
-                RETURNING id
-                "#,
-                tenant_id.0,
-                req.change.repository,
-                req.change.distribution,
-                update.release_file.meta.description,
-                update.release_file.meta.origin,
-                update.release_file.meta.label,
-                update.release_file.meta.version,
-                update.release_file.meta.suite,
-                update.release_file.meta.codename,
-                update.release_file.contents,
-                req.clearsigned,
-                req.detachsigned,
-            )
-            .fetch_one(&mut **tx)
-            .await
-            .map_err(ErrorResponse::from)?;
-            release.id
-        }
-    };
+    let release_id =
+        save_release_to_db(tx, tenant_id, change, update, clearsigned, detachsigned).await?;
 
     // Then, we find-or-create the Component.
     let component_id = match sqlx::query!(
@@ -355,7 +316,7 @@ async fn add_package_to_db(
         LIMIT 1
         "#,
         release_id,
-        req.change.component,
+        change.component,
     )
     .fetch_optional(&mut **tx)
     .await
@@ -380,7 +341,7 @@ async fn add_package_to_db(
                 RETURNING id
                 "#,
                 release_id,
-                req.change.component,
+                change.component,
             )
             .fetch_one(&mut **tx)
             .await
@@ -397,11 +358,13 @@ async fn add_package_to_db(
         WHERE
             component_id = $1
             AND architecture = $2::debian_repository_architecture
+            AND debian_installer = $3
             AND compression IS NULL
         LIMIT 1
         "#,
         component_id,
         update.changed_packages_index.meta.architecture as _,
+        update.changed_package.package.udeb,
     )
     .fetch_optional(&mut **tx)
     .await
@@ -456,6 +419,7 @@ async fn add_package_to_db(
                     md5sum,
                     sha1sum,
                     sha256sum,
+                    debian_installer,
                     created_at,
                     updated_at
                 )
@@ -468,6 +432,7 @@ async fn add_package_to_db(
                     $5,
                     $6,
                     $7,
+                    $8,
                     NOW(),
                     NOW()
                 )
@@ -480,6 +445,7 @@ async fn add_package_to_db(
                 update.changed_packages_index.meta.md5sum,
                 update.changed_packages_index.meta.sha1sum,
                 update.changed_packages_index.meta.sha256sum,
+                update.changed_package.package.udeb,
             )
             .execute(&mut **tx)
             .await
@@ -488,6 +454,9 @@ async fn add_package_to_db(
         }
     };
 
+    // Then, we update the Packages index's patch history.
+    save_packages_diffs_to_db(tx, component_id, update).await?;
+
     // Lastly, we create the component-package.
     //
     // This record should not previously exist, but we use ON CONFLICT DO
@@ -531,10 +500,157 @@ async fn add_package_to_db(
     Ok(previous_by_hash_indexes)
 }
 
+/// Update or create the Release of a change's distribution, and return its ID.
+async fn save_release_to_db(
+    tx: &mut sqlx::Transaction<'_, sqlx::Postgres>,
+    tenant_id: &TenantID,
+    change: &PackageChange,
+    update: &PackageChangeResult,
+    clearsigned: &str,
+    detachsigned: &str,
+) -> Result<i64, ErrorResponse> {
+    let release_id = match sqlx::query!(r#"
+        SELECT
+            debian_repository_release.id,
+            debian_repository_release.description,
+            debian_repository_release.origin,
+            debian_repository_release.label,
+            debian_repository_release.version,
+            debian_repository_release.suite,
+            debian_repository_release.codename,
+            debian_repository_release.contents,
+            debian_repository_release.clearsigned,
+            debian_repository_release.detached
+        FROM
+            debian_repository
+            JOIN debian_repository_release ON debian_repository.id = debian_repository_release.repository_id
+        WHERE
+            debian_repository.tenant_id = $1
+            AND debian_repository.name = $2
+            AND debian_repository_release.distribution = $3
+        LIMIT 1
+        "#,
+        tenant_id.0,
+        change.repository,
+        change.distribution,
+    )
+    .fetch_optional(&mut **tx)
+    .await
+    .map_err(ErrorResponse::from)? {
+        Some(release) => {
+            // If the release already exists, check whether any fields need to
+            // be updated. If so, update them.
+            if release.description != update.release_file.meta.description ||
+                release.origin != update.release_file.meta.origin ||
+                release.label != update.release_file.meta.label ||
+                release.version != update.release_file.meta.version ||
+                release.suite != update.release_file.meta.suite ||
+                release.codename != update.release_file.meta.codename ||
+                release.contents != update.release_file.contents ||
+                release.clearsigned.is_none() ||
+                release.clearsigned.is_some_and(|existing| existing != clearsigned) ||
+                release.detached.is_none() ||
+                release.detached.is_some_and(|existing| existing != detachsigned) {
+                sqlx::query!(
+                    r#"
+                    UPDATE
+                        debian_repository_release
+                    SET
+                        description = $2,
+                        origin = $3,
+                        label = $4,
+                        version = $5,
+                        suite = $6,
+                        codename = $7,
+                        contents = $8,
+                        clearsigned = $9,
+                        detached = $10,
+                        updated_at = NOW()
+                    WHERE
+                        id = $1
+                    "#,
+                    release.id,
+                    update.release_file.meta.description,
+                    update.release_file.meta.origin,
+                    update.release_file.meta.label,
+                    update.release_file.meta.version,
+                    update.release_file.meta.suite,
+                    update.release_file.meta.codename,
+                    update.release_file.contents,
+                    clearsigned,
+                    detachsigned,
+                )
+                .execute(&mut **tx)
+                .await
+                .map_err(ErrorResponse::from)?;
+            }
+            release.id
+        },
+        None => {
+            // If the release doesn't exist, create it with default values.
+            let release = sqlx::query!(
+                r#"
+                INSERT INTO debian_repository_release (
+                    repository_id,
+                    distribution,
+                    description,
+                    origin,
+                    label,
+                    version,
+                    suite,
+                    codename,
+                    contents,
+                    clearsigned,
+                    detached,
+                    created_at,
+                    updated_at
+                )
+                SELECT
+                    debian_repository.id,
+                    $3,
+                    $4,
+                    $5,
+                    $6,
+                    $7,
+                    $8,
+                    $9,
+                    $10,
+                    $11,
+                    $12,
+                    NOW(),
+                    NOW()
+                FROM debian_repository
+                WHERE
+                    debian_repository.tenant_id = $1
+                    AND debian_repository.name = $2
+                RETURNING id
+                "#,
+                tenant_id.0,
+                change.repository,
+                change.distribution,
+                update.release_file.meta.description,
+                update.release_file.meta.origin,
+                update.release_file.meta.label,
+                update.release_file.meta.version,
+                update.release_file.meta.suite,
+                update.release_file.meta.codename,
+                update.release_file.contents,
+                clearsigned,
+                detachsigned,
+            )
+            .fetch_one(&mut **tx)
+            .await
+            .map_err(ErrorResponse::from)?;
+            release.id
+        }
+    };
+    Ok(release_id)
+}
+
 async fn remove_package_from_db(
     tx: &mut sqlx::Transaction<'_, sqlx::Postgres>,
     tenant_id: &TenantID,
-    req: &SignIndexRequest,
+    change: &PackageChange,
     update: &PackageChangeResult,
     package: &str,
     version: &str,
@@ -563,9 +679,9 @@ async fn remove_package_from_db(
         LIMIT 1
         "#,
         tenant_id.0,
-        req.change.repository,
-        req.change.distribution,
-        req.change.component,
+        change.repository,
+        change.distribution,
+        change.component,
         package,
         version,
         architecture as _,
@@ -602,11 +718,13 @@ async fn remove_package_from_db(
         WHERE
             component_id = $1
             AND architecture = $2::debian_repository_architecture
+            AND debian_installer = $3
             AND compression IS NULL
         LIMIT 1
         "#,
         component_package.component_id,
         architecture as _,
+        update.changed_package.package.udeb,
     )
     .fetch_one(&mut **tx)
     .await
@@ -625,9 +743,11 @@ async fn remove_package_from_db(
             WHERE
                 component_id = $1
                 AND architecture = $2::debian_repository_architecture
+                AND debian_installer = $3
         "#,
             component_package.component_id,
             architecture as _,
+            update.changed_package.package.udeb,
         )
         .execute(&mut **tx)
         .await
@@ -646,6 +766,7 @@ async fn remove_package_from_db(
             WHERE
                 component_id = $6
                 AND architecture = $7::debian_repository_architecture
+                AND debian_installer = $8
                 AND compression IS NULL
             "#,
             update.changed_packages_index.contents.as_bytes(),
@@ -655,12 +776,17 @@ async fn remove_package_from_db(
             update.changed_packages_index.meta.sha256sum,
             component_package.component_id,
             architecture as _,
+            update.changed_package.package.udeb,
         )
         .execute(&mut **tx)
         .await
         .map_err(ErrorResponse::from)?;
     }
 
+    // Update the Packages index's patch history. If the index was deleted, its
+    // history was deleted with it.
+    save_packages_diffs_to_db(tx, component_package.component_id, update).await?;
+
     // Delete the Component if it's orphaned.
     let remaining_component_packages = sqlx::query!(
         r#"
@@ -694,21 +820,435 @@ async fn remove_package_from_db(
     Ok(previous_by_hash_indexes)
 }
 
+/// Save the changes to a Packages index's `Packages.diff/` history. This must
+/// happen after the index itself is saved.
+async fn save_packages_diffs_to_db(
+    tx: &mut sqlx::Transaction<'_, sqlx::Postgres>,
+    component_id: i64,
+    update: &PackageChangeResult,
+) -> Result<(), ErrorResponse> {
+    if let Some(diff) = &update.packages_diffs.added {
+        sqlx::query!(
+            r#"
+            INSERT INTO debian_repository_index_packages_diff (
+                index_id,
+                name,
+                history_size,
+                history_sha256sum,
+                patch_size,
+                patch_sha256sum,
+                download_size,
+                download_sha256sum,
+                contents,
+                created_at
+            )
+            SELECT
+                id,
+                $3,
+                $4,
+                $5,
+                $6,
+                $7,
+                $8,
+                $9,
+                $10,
+                NOW()
+            FROM debian_repository_index_packages
+            WHERE
+                component_id = $1
+                AND architecture = $2::debian_repository_architecture
+                AND debian_installer = $11
+                AND compression IS NULL
+            "#,
+            component_id,
+            update.changed_packages_index.meta.architecture as _,
+            diff.name,
+            diff.history_size,
+            diff.history_sha256sum,
+            diff.patch_size,
+            diff.patch_sha256sum,
+            diff.download_size,
+            diff.download_sha256sum,
+            diff.contents,
+            update.changed_package.package.udeb,
+        )
+        .execute(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+    }
+
+    if !update.packages_diffs.expired.is_empty() {
+        sqlx::query!(
+            r#"
+            DELETE FROM debian_repository_index_packages_diff
+            USING debian_repository_index_packages
+            WHERE
+                debian_repository_index_packages_diff.index_id = debian_repository_index_packages.id
+                AND debian_repository_index_packages.component_id = $1
+                AND debian_repository_index_packages.architecture = $2::debian_repository_architecture
+                AND debian_repository_index_packages.debian_installer = $4
+                AND debian_repository_index_packages_diff.name = ANY($3)
+            "#,
+            component_id,
+            update.changed_packages_index.meta.architecture as _,
+            &update.packages_diffs.expired,
+            update.changed_package.package.udeb,
+        )
+        .execute(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+    }
+
+    Ok(())
+}
+
+/// Save the distribution's regenerated Contents indexes, and delete the changed
+/// index's Contents index if it was emptied. This must happen after the
+/// components are saved.
+async fn save_contents_indexes_to_db(
+    tx: &mut sqlx::Transaction<'_, sqlx::Postgres>,
+    tenant_id: &TenantID,
+    change: &PackageChange,
+    update: &PackageChangeResult,
+) -> Result<(), ErrorResponse> {
+    for index in &update.contents_indexes.updated {
+        sqlx::query!(
+            r#"
+            INSERT INTO debian_repository_index_contents (
+                component_id,
+                architecture,
+                size,
+                contents,
+                md5sum,
+                sha256sum,
+                created_at,
+                updated_at
+            )
+            SELECT
+                debian_repository_component.id,
+                $5::debian_repository_architecture,
+                $6,
+                $7,
+                $8,
+                $9,
+                NOW(),
+                NOW()
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_component ON debian_repository_component.release_id = debian_repository_release.id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+                AND debian_repository_component.name = $4
+            ON CONFLICT (component_id, architecture) DO UPDATE SET
+                size = EXCLUDED.size,
+                contents = EXCLUDED.contents,
+                md5sum = EXCLUDED.md5sum,
+                sha256sum = EXCLUDED.sha256sum,
+                updated_at = NOW()
+            "#,
+            tenant_id.0,
+            change.repository,
+            change.distribution,
+            index.meta.component,
+            index.meta.architecture as _,
+            index.meta.size,
+            index.contents,
+            index.meta.md5sum,
+            index.meta.sha256sum,
+        )
+        .execute(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+    }
+
+    if let Some(previous) = update.contents_indexes.removed() {
+        sqlx::query!(
+            r#"
+            DELETE FROM debian_repository_index_contents
+            USING
+                debian_repository,
+                debian_repository_release,
+                debian_repository_component
+            WHERE
+                debian_repository_index_contents.component_id = debian_repository_component.id
+                AND debian_repository_component.release_id = debian_repository_release.id
+                AND debian_repository_release.repository_id = debian_repository.id
+                AND debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+                AND debian_repository_component.name = $4
+                AND debian_repository_index_contents.architecture = $5::debian_repository_architecture
+            "#,
+            tenant_id.0,
+            change.repository,
+            change.distribution,
+            previous.component,
+            previous.architecture as _,
+        )
+        .execute(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+    }
+
+    Ok(())
+}
+
+/// Save the distribution's regenerated Translation indexes, and delete the
+/// changed component's Translation indexes that were removed. This must happen
+/// after the components are saved.
+async fn save_translation_indexes_to_db(
+    tx: &mut sqlx::Transaction<'_, sqlx::Postgres>,
+    tenant_id: &TenantID,
+    change: &PackageChange,
+    update: &PackageChangeResult,
+) -> Result<(), ErrorResponse> {
+    for index in &update.translation_indexes.updated {
+        sqlx::query!(
+            r#"
+            INSERT INTO debian_repository_index_translation (
+                component_id,
+                language,
+                size,
+                contents,
+                md5sum,
+                sha256sum,
+                created_at,
+                updated_at
+            )
+            SELECT
+                debian_repository_component.id,
+                $5,
+                $6,
+                $7,
+                $8,
+                $9,
+                NOW(),
+                NOW()
+            FROM
+                debian_repository
+                JOIN debian_repository_release ON debian_repository_release.repository_id = debian_repository.id
+                JOIN debian_repository_component ON debian_repository_component.release_id = debian_repository_release.id
+            WHERE
+                debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+                AND debian_repository_component.name = $4
+            ON CONFLICT (component_id, language) DO UPDATE SET
+                size = EXCLUDED.size,
+                contents = EXCLUDED.contents,
+                md5sum = EXCLUDED.md5sum,
+                sha256sum = EXCLUDED.sha256sum,
+                updated_at = NOW()
+            "#,
+            tenant_id.0,
+            change.repository,
+            change.distribution,
+            index.meta.component,
+            index.meta.language,
+            index.meta.size,
+            index.contents.as_bytes(),
+            index.meta.md5sum,
+            index.meta.sha256sum,
+        )
+        .execute(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+    }
+
+    let removed = update
+        .translation_indexes
+        .removed()
+        .map(|previous| previous.language.clone())
+        .collect::<Vec<_>>();
+    if !removed.is_empty() {
+        sqlx::query!(
+            r#"
+            DELETE FROM debian_repository_index_translation
+            USING
+                debian_repository,
+                debian_repository_release,
+                debian_repository_component
+            WHERE
+                debian_repository_index_translation.component_id = debian_repository_component.id
+                AND debian_repository_component.release_id = debian_repository_release.id
+                AND debian_repository_release.repository_id = debian_repository.id
+                AND debian_repository.tenant_id = $1
+                AND debian_repository.name = $2
+                AND debian_repository_release.distribution = $3
+                AND debian_repository_component.name = $4
+                AND debian_repository_index_translation.language = ANY($5)
+            "#,
+            tenant_id.0,
+            change.repository,
+            change.distribution,
+            change.component,
+            &removed,
+        )
+        .execute(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+    }
+
+    Ok(())
+}
+
+/// Record that the distribution's unpublished DEP-11 files are published, and
+/// delete the removed files that the new Release file no longer lists.
+async fn save_dep11_files_to_db(
+    tx: &mut sqlx::Transaction<'_, sqlx::Postgres>,
+    update: &PackageChangeResult,
+) -> Result<(), ErrorResponse> {
+    for file in &update.dep11_files.unpublished {
+        sqlx::query!(
+            r#"
+            UPDATE debian_repository_dep11_file
+            SET
+                published_md5sum = $2,
+                published_sha256sum = $3,
+                updated_at = NOW()
+            WHERE id = $1
+            "#,
+            file.meta.id,
+            file.meta.md5sum,
+            file.meta.sha256sum,
+        )
+        .execute(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+    }
+
+    let removed = update
+        .dep11_files
+        .files
+        .iter()
+        .filter(|file| file.removed)
+        .map(|file| file.id)
+        .collect::<Vec<_>>();
+    if !removed.is_empty() {
+        sqlx::query!(
+            r#"
+            DELETE FROM debian_repository_dep11_file
+            WHERE id = ANY($1)
+            "#,
+            &removed,
+        )
+        .execute(&mut **tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+    }
+
+    Ok(())
+}
+
 struct Repository {
     s3_bucket: String,
     s3_prefix: String,
+    flat: bool,
+}
+
+async fn apply_change_to_s3(
+    s3: &aws_sdk_s3::Client,
+    repo: &Repository,
+    req: &SignIndexRequest,
+    applied: &[AppliedChange<'_>],
+) {
+    // Upload the packages and indexes of every change first.
+    for applied in applied {
+        upload_change_to_s3(s3, repo, applied).await;
+    }
+
+    // Upload the updated Release files. This must happen after package uploads
+    // and index uploads so that all files are in place for Acquire-By-Hash.
+    // The last change's Release file publishes all of the changes.
+    let release = &applied
+        .last()
+        .expect("requests have at least one change")
+        .result
+        .release_file;
+    let release_prefix = release_prefix(&repo.s3_prefix, repo.flat, &req.change.distribution);
+    let uploads = [
+        (
+            format!("{release_prefix}/InRelease"),
+            req.clearsigned.as_bytes().to_vec(),
+        ),
+        (
+            format!("{release_prefix}/Release"),
+            release.contents.as_bytes().to_vec(),
+        ),
+        (
+            format!("{release_prefix}/Release.gpg"),
+            req.detachsigned.as_bytes().to_vec(),
+        ),
+    ]
+    .into_iter()
+    .map(|(key, content)| {
+        debug!(?key, content = %String::from_utf8_lossy(&content), "uploading release file");
+        s3.put_object()
+            .bucket(&repo.s3_bucket)
+            .key(key)
+            .content_md5(base64::engine::general_purpose::STANDARD.encode(Md5::digest(&content)))
+            .checksum_algorithm(ChecksumAlgorithm::Sha256)
+            .checksum_sha256(
+                base64::engine::general_purpose::STANDARD.encode(Sha256::digest(&content)),
+            )
+            .body(content.into())
+            .send()
+    });
+    for upload in futures_util::future::join_all(uploads).await {
+        upload.unwrap();
+    }
+
+    // Now we can do deletions: the release files are uploaded and are no longer
+    // pointing at the by-hash Packages indexes that we're about to delete.
+    let deletions = applied
+        .iter()
+        .flat_map(|applied| stale_keys(repo, applied, &release.contents))
+        .collect::<Vec<_>>();
+    debug!(?deletions, "deletions");
+
+    // S3 only allows up to 1000 objects per delete request, but we're dealing
+    // with low ones of keys.
+    let keys = deletions
+        .into_iter()
+        .map(|key| {
+            aws_sdk_s3::types::ObjectIdentifier::builder()
+                .key(key)
+                .build()
+                .unwrap()
+        })
+        .collect::<Vec<_>>();
+    if !keys.is_empty() {
+        let delete = aws_sdk_s3::types::Delete::builder()
+            .set_objects(Some(keys))
+            .build()
+            .unwrap();
+        let deletion = s3
+            .delete_objects()
+            .bucket(&repo.s3_bucket)
+            .delete(delete)
+            .send()
+            .await;
+        if let Err(err) = deletion {
+            tracing::error!("Failed to delete objects: {err:?}");
+        }
+    }
 }
 
-async fn apply_change_to_s3(
+/// Upload the package and index files of a change. This must happen before
+/// the Release file that lists them is uploaded.
+async fn upload_change_to_s3(
     s3: &aws_sdk_s3::Client,
     repo: &Repository,
-    req: &SignIndexRequest,
-    result: &PackageChangeResult,
-    previous_by_hash_indexes: Option<PreviousByHashIndexes>,
+    applied: &AppliedChange<'_>,
 ) {
+    let AppliedChange { change, result, .. } = applied;
+
     // Copy the package from its canonical storage location into the repository
     // pool.
-    match req.change.action {
+    match change.action {
         PackageChangeAction::Add { .. } => {
             let source_key = format!(
                 "{}/packages/{}",
@@ -739,6 +1279,29 @@ async fn apply_change_to_s3(
         }
     }
 
+    // Flat repositories have a single Packages index at their root, which
+    // can't be fetched by hash.
+    if let Some(flat_packages_index) = &result.flat_packages_index {
+        let key = format!("{}/Packages", repo.s3_prefix);
+        let contents = &flat_packages_index.contents;
+        debug!(?key, content = %contents, "uploading flat index file");
+        s3.put_object()
+            .bucket(&repo.s3_bucket)
+            .key(key)
+            .content_md5(
+                base64::engine::general_purpose::STANDARD.encode(Md5::digest(contents.as_bytes())),
+            )
+            .checksum_algorithm(ChecksumAlgorithm::Sha256)
+            .checksum_sha256(
+                base64::engine::general_purpose::STANDARD
+                    .encode(hex::decode(&flat_packages_index.sha256sum).unwrap()),
+            )
+            .body(contents.as_bytes().to_vec().into())
+            .send()
+            .await
+            .unwrap();
+    }
+
     // Upload the updated package index files to standard path and all by-hash
     // paths concurrently.
     //
@@ -751,16 +1314,16 @@ async fn apply_change_to_s3(
     let by_hash_prefix = format!(
         "{}/dists/{}/{}/binary-{}/by-hash",
         repo.s3_prefix,
-        req.change.distribution,
+        change.distribution,
         result.changed_packages_index.meta.component,
         result.changed_packages_index.meta.architecture
     );
-    if !result.changed_packages_index.contents.is_empty() {
+    if !repo.flat && !result.changed_packages_index.contents.is_empty() {
         let uploads = [
             format!(
                 "{}/dists/{}/{}/binary-{}/Packages",
                 repo.s3_prefix,
-                req.change.distribution,
+                change.distribution,
                 result.changed_packages_index.meta.component,
                 result.changed_packages_index.meta.architecture
             ),
@@ -807,34 +1370,30 @@ async fn apply_change_to_s3(
         }
     }
 
-    // Upload the updated Release files. This must happen after package uploads
-    // and index uploads so that all files are in place for Acquire-By-Hash.
-    let uploads = [
-        (
-            format!(
-                "{}/dists/{}/InRelease",
-                repo.s3_prefix, req.change.distribution
-            ),
-            req.clearsigned.as_bytes().to_vec(),
-        ),
-        (
-            format!(
-                "{}/dists/{}/Release",
-                repo.s3_prefix, req.change.distribution
-            ),
-            result.release_file.contents.as_bytes().to_vec(),
-        ),
-        (
-            format!(
-                "{}/dists/{}/Release.gpg",
-                repo.s3_prefix, req.change.distribution
-            ),
-            req.detachsigned.as_bytes().to_vec(),
-        ),
-    ]
-    .into_iter()
-    .map(|(key, content)| {
-        debug!(?key, content = %String::from_utf8_lossy(&content), "uploading release file");
+    // Upload the Packages index's new patch and `Packages.diff/Index`. Clients
+    // fetch the Index by hash, and then fetch the patches that it lists.
+    let diff_prefix = format!(
+        "{}/dists/{}/{}/binary-{}/Packages.diff",
+        repo.s3_prefix,
+        change.distribution,
+        result.changed_packages_index.meta.component,
+        result.changed_packages_index.meta.architecture
+    );
+    let mut diff_uploads = Vec::new();
+    if let Some(diff) = &result.packages_diffs.added {
+        diff_uploads.push((format!("{diff_prefix}/{}.gz", diff.name), diff.contents.clone()));
+    }
+    if let Some(index) = &result.packages_diffs.index {
+        for key in [
+            format!("{diff_prefix}/Index"),
+            format!("{diff_prefix}/by-hash/SHA256/{}", index.sha256sum),
+            format!("{diff_prefix}/by-hash/MD5Sum/{}", index.md5sum),
+        ] {
+            diff_uploads.push((key, index.contents.as_bytes().to_vec()));
+        }
+    }
+    let uploads = diff_uploads.into_iter().map(|(key, content)| {
+        debug!(?key, "uploading Packages.diff file");
         s3.put_object()
             .bucket(&repo.s3_bucket)
             .key(key)
@@ -850,9 +1409,145 @@ async fn apply_change_to_s3(
         upload.unwrap();
     }
 
-    // Now we can do deletions: the release files are uploaded and are no longer
-    // pointing at the by-hash Packages indexes that we're about to delete.
-    let deletions = match previous_by_hash_indexes {
+    // Upload the regenerated Contents indexes.
+    let uploads = result
+        .contents_indexes
+        .updated
+        .iter()
+        .flat_map(|index| {
+            let component_prefix = format!(
+                "{}/dists/{}/{}",
+                repo.s3_prefix, change.distribution, index.meta.component
+            );
+            [
+                format!("{component_prefix}/Contents-{}.gz", index.meta.architecture),
+                format!("{component_prefix}/by-hash/SHA256/{}", index.meta.sha256sum),
+                format!("{component_prefix}/by-hash/MD5Sum/{}", index.meta.md5sum),
+            ]
+            .map(|key| (key, index))
+        })
+        .map(|(key, index)| {
+            debug!(?key, "uploading Contents index");
+            s3.put_object()
+                .bucket(&repo.s3_bucket)
+                .key(key)
+                .content_md5(
+                    base64::engine::general_purpose::STANDARD.encode(Md5::digest(&index.contents)),
+                )
+                .checksum_algorithm(ChecksumAlgorithm::Sha256)
+                .checksum_sha256(
+                    base64::engine::general_purpose::STANDARD
+                        .encode(hex::decode(&index.meta.sha256sum).unwrap()),
+                )
+                .body(index.contents.clone().into())
+                .send()
+        });
+    for upload in futures_util::future::join_all(uploads).await {
+        upload.unwrap();
+    }
+
+    // Upload the regenerated Translation indexes.
+    let uploads = result
+        .translation_indexes
+        .updated
+        .iter()
+        .flat_map(|index| {
+            let i18n_prefix = format!(
+                "{}/dists/{}/{}/i18n",
+                repo.s3_prefix, change.distribution, index.meta.component
+            );
+            [
+                format!("{i18n_prefix}/Translation-{}", index.meta.language),
+                format!("{i18n_prefix}/by-hash/SHA256/{}", index.meta.sha256sum),
+                format!("{i18n_prefix}/by-hash/MD5Sum/{}", index.meta.md5sum),
+            ]
+            .map(|key| (key, index))
+        })
+        .map(|(key, index)| {
+            debug!(?key, "uploading Translation index");
+            s3.put_object()
+                .bucket(&repo.s3_bucket)
+                .key(key)
+                .content_md5(
+                    base64::engine::general_purpose::STANDARD
+                        .encode(Md5::digest(index.contents.as_bytes())),
+                )
+                .checksum_algorithm(ChecksumAlgorithm::Sha256)
+                .checksum_sha256(
+                    base64::engine::general_purpose::STANDARD
+                        .encode(hex::decode(&index.meta.sha256sum).unwrap()),
+                )
+                .body(index.contents.as_bytes().to_vec().into())
+                .send()
+        });
+    for upload in futures_util::future::join_all(uploads).await {
+        upload.unwrap();
+    }
+
+    // Upload the newly published DEP-11 files.
+    let uploads = result
+        .dep11_files
+        .unpublished
+        .iter()
+        .flat_map(|file| {
+            let dep11_prefix = format!(
+                "{}/dists/{}/{}/dep11",
+                repo.s3_prefix, change.distribution, file.meta.component
+            );
+            [
+                format!("{dep11_prefix}/{}", file.meta.name),
+                format!("{dep11_prefix}/by-hash/SHA256/{}", file.meta.sha256sum),
+                format!("{dep11_prefix}/by-hash/MD5Sum/{}", file.meta.md5sum),
+            ]
+            .map(|key| (key, file))
+        })
+        .map(|(key, file)| {
+            debug!(?key, "uploading DEP-11 file");
+            s3.put_object()
+                .bucket(&repo.s3_bucket)
+                .key(key)
+                .content_md5(
+                    base64::engine::general_purpose::STANDARD.encode(Md5::digest(&file.contents)),
+                )
+                .checksum_algorithm(ChecksumAlgorithm::Sha256)
+                .checksum_sha256(
+                    base64::engine::general_purpose::STANDARD
+                        .encode(hex::decode(&file.meta.sha256sum).unwrap()),
+                )
+                .body(file.contents.clone().into())
+                .send()
+        });
+    for upload in futures_util::future::join_all(uploads).await {
+        upload.unwrap();
+    }
+}
+
+/// The keys of the files that a change replaced, which can be deleted once
+/// the Release file no longer lists them.
+fn stale_keys(repo: &Repository, applied: &AppliedChange<'_>, release: &str) -> Vec<String> {
+    let AppliedChange {
+        change,
+        result,
+        previous_by_hash_indexes,
+    } = applied;
+    let by_hash_prefix = format!(
+        "{}/dists/{}/{}/binary-{}/by-hash",
+        repo.s3_prefix,
+        change.distribution,
+        result.changed_packages_index.meta.component,
+        result.changed_packages_index.meta.architecture
+    );
+    let diff_prefix = format!(
+        "{}/dists/{}/{}/binary-{}/Packages.diff",
+        repo.s3_prefix,
+        change.distribution,
+        result.changed_packages_index.meta.component,
+        result.changed_packages_index.meta.architecture
+    );
+
+    // The previous by-hash files of the changed Packages index are no longer
+    // referenced.
+    let mut deletions = match previous_by_hash_indexes {
         None => Vec::new(),
         Some(PreviousByHashIndexes {
             md5sum,
@@ -873,38 +1568,90 @@ async fn apply_change_to_s3(
         // in which case adding the package to the index is a no-op. In that
         // case, we don't want to delete the "old" (but actually still
         // up-to-date) index.
-        .filter(|(old_hash, new_hash, _)| &old_hash != new_hash)
+        .filter(|(old_hash, new_hash, _)| old_hash != new_hash)
         .map(|(old_hash, _, hash_type)| format!("{by_hash_prefix}/{hash_type}/{old_hash}"))
         .collect::<Vec<_>>(),
     };
-    debug!(?deletions, "deletions");
 
-    // S3 only allows up to 1000 objects per delete request, but we're dealing
-    // with low ones of keys.
-    let keys = deletions
-        .into_iter()
-        .map(|key| {
-            aws_sdk_s3::types::ObjectIdentifier::builder()
-                .key(key)
-                .build()
-                .unwrap()
-        })
-        .collect::<Vec<_>>();
-    if !keys.is_empty() {
-        let delete = aws_sdk_s3::types::Delete::builder()
-            .set_objects(Some(keys))
-            .build()
-            .unwrap();
-        let deletion = s3
-            .delete_objects()
-            .bucket(&repo.s3_bucket)
-            .delete(delete)
-            .send()
-            .await;
-        if let Err(err) = deletion {
-            tracing::error!("Failed to delete objects: {err:?}");
+    // Expired patches and the previous `Packages.diff/Index` are also no
+    // longer referenced.
+    deletions.extend(
+        result
+            .packages_diffs
+            .expired
+            .iter()
+            .map(|name| format!("{diff_prefix}/{name}.gz")),
+    );
+    if let Some(previous) = &result.packages_diffs.previous_index {
+        let current = result.packages_diffs.index.as_ref();
+        if current.is_none() {
+            deletions.push(format!("{diff_prefix}/Index"));
+        }
+        if current.is_none_or(|current| current.sha256sum != previous.sha256sum) {
+            deletions.push(format!("{diff_prefix}/by-hash/SHA256/{}", previous.sha256sum));
+            deletions.push(format!("{diff_prefix}/by-hash/MD5Sum/{}", previous.md5sum));
+        }
+    }
+
+    // So is the changed index's previous Contents index, unless it's unchanged.
+    // Architectures with identical Contents indexes share by-hash files, so
+    // they're only deleted once the Release file no longer lists their hash.
+    if let Some(previous) = &result.contents_indexes.previous {
+        let component_prefix = format!(
+            "{}/dists/{}/{}",
+            repo.s3_prefix, change.distribution, previous.component
+        );
+        if result.contents_indexes.removed().is_some() {
+            deletions.push(format!("{component_prefix}/Contents-{}.gz", previous.architecture));
+        }
+        if !release.contains(&previous.sha256sum) {
+            deletions.push(format!("{component_prefix}/by-hash/SHA256/{}", previous.sha256sum));
+            deletions.push(format!("{component_prefix}/by-hash/MD5Sum/{}", previous.md5sum));
+        }
+    }
+
+    // So are the changed component's previous Translation indexes, unless
+    // they're unchanged.
+    for previous in &result.translation_indexes.previous {
+        let current = result.translation_indexes.updated.iter().find(|index| {
+            index.meta.component == previous.component && index.meta.language == previous.language
+        });
+        let i18n_prefix = format!(
+            "{}/dists/{}/{}/i18n",
+            repo.s3_prefix, change.distribution, previous.component
+        );
+        if current.is_none() {
+            deletions.push(format!("{i18n_prefix}/Translation-{}", previous.language));
+        }
+        if current.is_none_or(|current| current.meta.sha256sum != previous.sha256sum) {
+            deletions.push(format!("{i18n_prefix}/by-hash/SHA256/{}", previous.sha256sum));
+            deletions.push(format!("{i18n_prefix}/by-hash/MD5Sum/{}", previous.md5sum));
+        }
+    }
+
+    // So are the previously published versions of DEP-11 files that were
+    // replaced or removed. Identical files share by-hash files, so they're
+    // only deleted once the Release file no longer lists their hash.
+    for file in &result.dep11_files.files {
+        let dep11_prefix = format!(
+            "{}/dists/{}/{}/dep11",
+            repo.s3_prefix, change.distribution, file.component
+        );
+        if file.removed {
+            deletions.push(format!("{dep11_prefix}/{}", file.name));
+        }
+        let stale = file
+            .published_md5sum
+            .as_ref()
+            .zip(file.published_sha256sum.as_ref())
+            .filter(|(_, sha256sum)| !release.contains(sha256sum.as_str()));
+        if let Some((md5sum, sha256sum)) = stale {
+            deletions.push(format!("{dep11_prefix}/by-hash/SHA256/{sha256sum}"));
+            deletions.push(format!("{dep11_prefix}/by-hash/MD5Sum/{md5sum}"));
         }
     }
+
+    deletions
 }
 
 #[cfg(test)]
@@ -918,7 +1665,7 @@ mod tests {
     use super::*;
     use crate::{
         server::{
-            pkg::upload::PackageUploadResponse,
+            pkg::{info::PackageInfoResponse, upload::PackageUploadResponse},
             repo::{
                 index::generate::{GenerateIndexRequest, GenerateIndexResponse},
                 sync::check::CheckConsistencyResponse,
@@ -1004,8 +1751,10 @@ mod tests {
 
                 action: PackageChangeAction::Add {
                     package_sha256sum: package_sha256sum.clone(),
+                    allow_downgrade: false,
                 },
             },
+            then: Vec::new(),
         };
 
         let res = server
@@ -1041,15 +1790,22 @@ mod tests {
                 repository: String::from(REPO_NAME),
                 distribution: String::from("stable"),
                 component: String::from("main"),
-                action: PackageChangeAction::Add { package_sha256sum },
+                action: PackageChangeAction::Add {
+                    package_sha256sum,
+                    allow_downgrade: false,
+                },
             },
+            then: Vec::new(),
             clearsigned,
             detachsigned,
             public_key_cert,
             release_ts,
         };
         let mut tx = server.db.begin().await.unwrap();
-        let (result, _) = apply_change_to_db(&mut tx, &tenant_id, &req).await.unwrap();
+        let mut applied = apply_change_to_db(&mut tx, &tenant_id, &req, &[&req.change])
+            .await
+            .unwrap();
+        let result = applied.remove(0).result;
         tx.commit().await.unwrap();
 
         // Partially upload the index changes. In this case, we upload the
@@ -1281,8 +2037,10 @@ mod tests {
 
                 action: PackageChangeAction::Add {
                     package_sha256sum: package_a_sha256sum.clone(),
+                    allow_downgrade: false,
                 },
             },
+            then: Vec::new(),
         };
         let res = server
             .http
@@ -1306,19 +2064,20 @@ mod tests {
                 component: String::from("main"),
                 action: PackageChangeAction::Add {
                     package_sha256sum: package_a_sha256sum,
+                    allow_downgrade: false,
                 },
             },
+            then: Vec::new(),
             clearsigned,
             detachsigned,
             public_key_cert,
             release_ts,
         };
         let mut tx = server.db.begin().await.unwrap();
-        let (result_a, previous_by_hash_indexes_a) =
-            apply_change_to_db(&mut tx, &tenant_id, &req_a)
-                .await
-                .unwrap();
-        debug!(?result_a, "applied change to database");
+        let applied_a = apply_change_to_db(&mut tx, &tenant_id, &req_a, &[&req_a.change])
+            .await
+            .unwrap();
+        debug!(?applied_a, "applied change to database");
         tx.commit().await.unwrap();
 
         // Add package 2 to the database.
@@ -1330,8 +2089,10 @@ mod tests {
 
                 action: PackageChangeAction::Add {
                     package_sha256sum: package_b_sha256sum.clone(),
+                    allow_downgrade: false,
                 },
             },
+            then: Vec::new(),
         };
         let res = server
             .http
@@ -1355,19 +2116,20 @@ mod tests {
                 component: String::from("main"),
                 action: PackageChangeAction::Add {
                     package_sha256sum: package_b_sha256sum,
+                    allow_downgrade: false,
                 },
             },
+            then: Vec::new(),
             clearsigned,
             detachsigned,
             public_key_cert,
             release_ts,
         };
         let mut tx = server.db.begin().await.unwrap();
-        let (result_b, previous_by_hash_indexes_b) =
-            apply_change_to_db(&mut tx, &tenant_id, &req_b)
-                .await
-                .unwrap();
-        debug!(?result_b, "applied change to database");
+        let applied_b = apply_change_to_db(&mut tx, &tenant_id, &req_b, &[&req_b.change])
+            .await
+            .unwrap();
+        debug!(?applied_b, "applied change to database");
         tx.commit().await.unwrap();
 
         // Upload package 2 to the repository.
@@ -1376,10 +2138,10 @@ mod tests {
             &Repository {
                 s3_bucket: server.s3_bucket_name.clone(),
                 s3_prefix: s3_prefix.clone(),
+                flat: false,
             },
             &req_b,
-            &result_b,
-            previous_by_hash_indexes_b,
+            &applied_b,
         )
         .await;
 
@@ -1389,10 +2151,10 @@ mod tests {
             &Repository {
                 s3_bucket: server.s3_bucket_name.clone(),
                 s3_prefix: s3_prefix.clone(),
+                flat: false,
             },
             &req_a,
-            &result_a,
-            previous_by_hash_indexes_a,
+            &applied_a,
         )
         .await;
 
@@ -1514,8 +2276,10 @@ mod tests {
                     component: String::from(invalid_component),
                     action: PackageChangeAction::Add {
                         package_sha256sum: String::from("dummy-sha256sum"),
+                        allow_downgrade: false,
                     },
                 },
+                then: Vec::new(),
                 release_ts: OffsetDateTime::now_utc(),
                 clearsigned: String::from("dummy-clearsigned"),
                 detachsigned: String::from("dummy-detachsigned"),
@@ -1561,8 +2325,10 @@ mod tests {
                     component: String::from(valid_component),
                     action: PackageChangeAction::Add {
                         package_sha256sum: String::from("dummy-sha256sum"),
+                        allow_downgrade: false,
                     },
                 },
+                then: Vec::new(),
                 release_ts: OffsetDateTime::now_utc(),
                 clearsigned: String::from("dummy-clearsigned"),
                 detachsigned: String::from("dummy-detachsigned"),
@@ -1584,4 +2350,190 @@ mod tests {
             );
         }
     }
+
+    /// Generate an index for a request's changes, sign it, and submit the
+    /// signatures. Returns the signed Release file.
+    async fn sign_changes(
+        server: &AttuneTestServer,
+        api_token: &str,
+        changes: impl Fn() -> (PackageChange, Vec<PackageChange>),
+    ) -> String {
+        let (change, then) = changes();
+        let url = format!("/api/v0/repositories/{}/index", change.repository);
+        let res = server
+            .http
+            .get(&url)
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .json(&GenerateIndexRequest { change, then })
+            .await;
+        res.assert_status_ok();
+        let res = res.json::<GenerateIndexResponse>();
+
+        let (clearsigned, detachsigned, public_key_cert) = sign_index(&res.release).await;
+        let (change, then) = changes();
+        server
+            .http
+            .post(&url)
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .json(&SignIndexRequest {
+                change,
+                then,
+                release_ts: res.release_ts,
+                clearsigned,
+                detachsigned,
+                public_key_cert,
+            })
+            .await
+            .assert_status_ok();
+        res.release
+    }
+
+    /// A request can remove a package from one component and add it to
+    /// another, and a single Release file publishes both changes.
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR")]
+    #[test_log::test]
+    async fn move_package_in_single_release(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        const REPO_NAME: &str = "move_package_in_single_release";
+        let (tenant_id, api_token) = server.create_test_tenant(REPO_NAME).await;
+        let s3_prefix = server.create_repository(tenant_id, REPO_NAME).await;
+
+        let package_file = fixtures::TEST_PACKAGE_AMD64;
+        let upload = MultipartForm::new().add_part("file", Part::bytes(package_file.to_vec()));
+        let package_sha256sum = server
+            .http
+            .post("/api/v0/packages")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .multipart(upload)
+            .await
+            .json::<PackageUploadResponse>()
+            .sha256sum;
+        let info = || {
+            server
+                .http
+                .get(&format!("/api/v0/packages/{package_sha256sum}"))
+                .add_header("authorization", format!("Bearer {api_token}"))
+        };
+        let change = |component: &str, action: PackageChangeAction| PackageChange {
+            repository: String::from(REPO_NAME),
+            distribution: String::from("stable"),
+            component: String::from(component),
+            action,
+        };
+        let add = || PackageChangeAction::Add {
+            package_sha256sum: package_sha256sum.clone(),
+            allow_downgrade: false,
+        };
+
+        // Publish the package in `contrib`.
+        sign_changes(&server, &api_token, || (change("contrib", add()), Vec::new())).await;
+        let before = info().await.json::<PackageInfoResponse>();
+        let [old] = before.publications.as_slice() else {
+            panic!("package should be published once: {:?}", before.publications);
+        };
+        assert_eq!(old.component, "contrib");
+
+        // Move it to `main`.
+        let remove = || PackageChangeAction::Remove {
+            name: before.package.clone(),
+            version: before.version.clone(),
+            architecture: before.architecture.clone(),
+        };
+        let release = sign_changes(&server, &api_token, || {
+            (change("contrib", remove()), vec![change("main", add())])
+        })
+        .await;
+        assert!(release.contains("main/binary-amd64/Packages"), "{release}");
+        assert!(!release.contains("contrib/"), "{release}");
+
+        let after = info().await.json::<PackageInfoResponse>();
+        let [new] = after.publications.as_slice() else {
+            panic!("package should be published once: {:?}", after.publications);
+        };
+        assert_eq!(new.component, "main");
+
+        // The published Release file is the signed one, and the package was
+        // copied to its new component's pool.
+        let published = server
+            .s3
+            .get_object()
+            .bucket(&server.s3_bucket_name)
+            .key(format!("{s3_prefix}/dists/stable/Release"))
+            .send()
+            .await
+            .unwrap()
+            .body
+            .collect()
+            .await
+            .unwrap()
+            .into_bytes();
+        assert_eq!(String::from_utf8_lossy(&published), release);
+        server
+            .s3
+            .head_object()
+            .bucket(&server.s3_bucket_name)
+            .key(format!("{s3_prefix}/{}", new.filename))
+            .send()
+            .await
+            .expect("new pool file should exist");
+
+        // The database agrees with what was published.
+        let status = server
+            .http
+            .get(&format!(
+                "/api/v0/repositories/{REPO_NAME}/distributions/stable/sync"
+            ))
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .await
+            .json::<CheckConsistencyResponse>()
+            .status;
+        assert!(!status.release, "Release file is inconsistent");
+        assert!(!status.release_clearsigned, "InRelease file is inconsistent");
+        assert!(!status.release_detachsigned, "Release.gpg file is inconsistent");
+        assert!(status.packages.is_empty(), "Packages are inconsistent");
+        assert!(
+            status.packages_indexes.is_empty(),
+            "Packages indexes are inconsistent"
+        );
+    }
+
+    #[sqlx::test(migrator = "crate::testing::MIGRATOR")]
+    async fn reject_mixed_distributions(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        const REPO_NAME: &str = "reject_mixed_distributions";
+        let (tenant_id, api_token) = server.create_test_tenant(REPO_NAME).await;
+        server.create_repository(tenant_id, REPO_NAME).await;
+
+        let change = |distribution: &str| PackageChange {
+            repository: String::from(REPO_NAME),
+            distribution: String::from(distribution),
+            component: String::from("main"),
+            action: PackageChangeAction::Add {
+                package_sha256sum: String::from("dummy-sha256sum"),
+                allow_downgrade: false,
+            },
+        };
+        let res = server
+            .http
+            .get(&format!("/api/v0/repositories/{REPO_NAME}/index"))
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .json(&GenerateIndexRequest {
+                change: change("stable"),
+                then: vec![change("unstable")],
+            })
+            .expect_failure()
+            .await;
+        res.assert_status(StatusCode::BAD_REQUEST);
+        assert_eq!(res.json::<ErrorResponse>().error, "DISTRIBUTION_MISMATCH");
+    }
 }
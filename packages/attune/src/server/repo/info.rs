@@ -1,19 +1,91 @@
+use std::collections::BTreeMap;
+
 use axum::{
     Json,
     extract::{Path, State},
     http::StatusCode,
 };
+use pgp::composed::{Deserializable as _, StandaloneSignature};
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
+use sqlx::types::Json as SqlJson;
+use time::OffsetDateTime;
 use tracing::instrument;
 
 use crate::{
     api::{ErrorResponse, TenantID},
-    server::{ServerState, repo::decode_repo_name},
+    apt::parse_valid_until,
+    server::{
+        ServerState,
+        repo::{decode_repo_name, lock::RepositoryLock},
+    },
 };
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub struct RepositoryInfoResponse {
+    pub id: i64,
     pub name: String,
+    /// The public URL that the repository is served from, if one is
+    /// configured.
+    pub uri: Option<String>,
+    pub s3_bucket: String,
+    pub s3_prefix: String,
+    /// Whether the repository is flat, with its only distribution published
+    /// at its root instead of under `dists/`.
+    pub flat: bool,
+    #[serde(with = "time::serde::rfc3339")]
+    #[schemars(with = "String")]
+    pub created_at: OffsetDateTime,
+    /// The repository's lock, if it is locked.
+    pub lock: Option<RepositoryLock>,
+    /// Custom fields added to the Release file of every distribution.
+    pub release_fields: BTreeMap<String, String>,
+    /// How many days each Release file is valid for, if they expire.
+    pub valid_until_days: Option<i32>,
+    /// Whether Packages indexes publish a `Packages.diff/` history.
+    pub pdiffs: bool,
+    /// Whether components publish `Contents-<arch>.gz` indexes.
+    pub contents_indexes: bool,
+    /// Whether components publish `i18n/Translation-<lang>` indexes.
+    pub translations: bool,
+    /// Whether adding a lower version of a package than the latest one in its
+    /// Packages index is refused.
+    pub prevent_downgrades: bool,
+    /// How many of the newest versions of each package `attune apt repo prune`
+    /// keeps per architecture, if it's limited.
+    pub retain_versions: Option<i32>,
+    /// How many days `attune apt repo prune` keeps older versions of packages
+    /// for after they're added, if it's limited.
+    pub retain_days: Option<i32>,
+    /// The repository's distributions, sorted by name.
+    pub distributions: Vec<DistributionInfo>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct DistributionInfo {
+    pub distribution: String,
+    pub suite: String,
+    pub codename: String,
+    /// The distribution's components, sorted by name.
+    pub components: Vec<String>,
+    /// The architectures that the distribution has Packages indexes for,
+    /// sorted by name.
+    pub architectures: Vec<String>,
+    /// The number of packages published across all components.
+    pub package_count: i64,
+    /// When the distribution's index was last published or its metadata was
+    /// last edited.
+    #[serde(with = "time::serde::rfc3339")]
+    #[schemars(with = "String")]
+    pub updated_at: OffsetDateTime,
+    /// The fingerprint of the key that signed the published index, or `None`
+    /// if nothing has been published yet.
+    pub signing_key_fingerprint: Option<String>,
+    /// When the published Release file expires, if it has a `Valid-Until`
+    /// field.
+    #[serde(with = "time::serde::rfc3339::option")]
+    #[schemars(with = "Option<String>")]
+    pub valid_until: Option<OffsetDateTime>,
 }
 
 #[axum::debug_handler]
@@ -28,7 +100,24 @@ pub async fn handler(
 
     let repo = sqlx::query!(
         r#"
-        SELECT name
+        SELECT
+            id,
+            name,
+            uri,
+            s3_bucket,
+            s3_prefix,
+            flat,
+            created_at,
+            locked_at,
+            lock_reason,
+            release_fields AS "release_fields!: SqlJson<BTreeMap<String, String>>",
+            valid_until_days,
+            pdiffs,
+            contents_indexes,
+            translations,
+            prevent_downgrades,
+            retain_versions,
+            retain_days
         FROM debian_repository
         WHERE tenant_id = $1 AND name = $2
         LIMIT 1
@@ -39,12 +128,98 @@ pub async fn handler(
     .fetch_optional(&state.db)
     .await
     .map_err(ErrorResponse::from)?;
-    match repo {
-        Some(repo) => Ok(Json(RepositoryInfoResponse { name: repo.name })),
-        None => Err(ErrorResponse::new(
+    let Some(repo) = repo else {
+        return Err(ErrorResponse::new(
             StatusCode::NOT_FOUND,
             "REPO_NOT_FOUND".to_string(),
             "repository not found".to_string(),
-        )),
-    }
+        ));
+    };
+
+    let distributions = sqlx::query!(
+        r#"
+        SELECT
+            release.distribution,
+            release.suite,
+            release.codename,
+            release.updated_at,
+            release.contents,
+            release.detached,
+            ARRAY(
+                SELECT component.name
+                FROM debian_repository_component AS component
+                WHERE component.release_id = release.id
+                ORDER BY component.name
+            ) AS "components!",
+            (
+                SELECT COUNT(*)
+                FROM debian_repository_component_package AS component_package
+                JOIN debian_repository_component AS component
+                    ON component.id = component_package.component_id
+                WHERE component.release_id = release.id
+            ) AS "package_count!",
+            ARRAY(
+                SELECT DISTINCT packages_index.architecture::TEXT
+                FROM debian_repository_index_packages AS packages_index
+                JOIN debian_repository_component AS component
+                    ON component.id = packages_index.component_id
+                WHERE component.release_id = release.id
+                ORDER BY 1
+            ) AS "architectures!"
+        FROM debian_repository_release AS release
+        WHERE release.repository_id = $1
+        ORDER BY release.distribution
+        "#,
+        repo.id,
+    )
+    .fetch_all(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?
+    .into_iter()
+    .map(|row| DistributionInfo {
+        signing_key_fingerprint: row.detached.as_deref().and_then(signing_key_fingerprint),
+        valid_until: parse_valid_until(&row.contents),
+        distribution: row.distribution,
+        suite: row.suite,
+        codename: row.codename,
+        components: row.components,
+        architectures: row.architectures,
+        package_count: row.package_count,
+        updated_at: row.updated_at,
+    })
+    .collect();
+
+    Ok(Json(RepositoryInfoResponse {
+        id: repo.id,
+        name: repo.name,
+        uri: repo.uri,
+        s3_bucket: repo.s3_bucket,
+        s3_prefix: repo.s3_prefix,
+        flat: repo.flat,
+        created_at: repo.created_at,
+        lock: repo.locked_at.map(|locked_at| RepositoryLock {
+            locked_at,
+            reason: repo.lock_reason,
+        }),
+        release_fields: repo.release_fields.0,
+        valid_until_days: repo.valid_until_days,
+        pdiffs: repo.pdiffs,
+        contents_indexes: repo.contents_indexes,
+        translations: repo.translations,
+        prevent_downgrades: repo.prevent_downgrades,
+        retain_versions: repo.retain_versions,
+        retain_days: repo.retain_days,
+        distributions,
+    }))
+}
+
+/// The fingerprint of the key that made a detached signature, as uppercase
+/// hex.
+fn signing_key_fingerprint(detached: &str) -> Option<String> {
+    let (signature, _headers) = StandaloneSignature::from_string(detached).ok()?;
+    signature
+        .signature
+        .issuer_fingerprint()
+        .first()
+        .map(|fingerprint| hex::encode_upper(fingerprint.as_bytes()))
 }
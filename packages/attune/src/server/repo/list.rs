@@ -1,4 +1,5 @@
 use axum::{Json, extract::State};
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 use tracing::instrument;
 
@@ -7,7 +8,7 @@ use crate::{
     server::ServerState,
 };
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub struct Repository {
     pub id: i64,
     pub name: String,
@@ -20,7 +21,7 @@ pub struct ListRepositoryRequest {
     pub name: Option<String>,
 }
 
-#[derive(Serialize, Deserialize, Debug)]
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
 pub struct ListRepositoryResponse {
     pub repositories: Vec<Repository>,
 }
@@ -0,0 +1,67 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{
+        ServerState,
+        repo::{decode_repo_name, lock::RepositoryLock},
+    },
+};
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct LockRepositoryRequest {
+    pub reason: Option<String>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct LockRepositoryResponse {
+    pub lock: RepositoryLock,
+}
+
+/// Lock a repository. Locking an already-locked repository updates the reason,
+/// but keeps the time it was first locked.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path(repository_name): Path<String>,
+    Json(req): Json<LockRepositoryRequest>,
+) -> Result<Json<LockRepositoryResponse>, ErrorResponse> {
+    let repository_name = decode_repo_name(&repository_name)?;
+
+    let locked = sqlx::query!(
+        r#"
+        UPDATE debian_repository
+        SET locked_at = COALESCE(locked_at, NOW()), lock_reason = $3
+        WHERE tenant_id = $1 AND name = $2
+        RETURNING locked_at AS "locked_at!", lock_reason
+        "#,
+        tenant_id.0,
+        repository_name,
+        req.reason,
+    )
+    .fetch_optional(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+    match locked {
+        Some(locked) => Ok(Json(LockRepositoryResponse {
+            lock: RepositoryLock {
+                locked_at: locked.locked_at,
+                reason: locked.lock_reason,
+            },
+        })),
+        None => Err(ErrorResponse::new(
+            StatusCode::NOT_FOUND,
+            "REPO_NOT_FOUND".to_string(),
+            "repository not found".to_string(),
+        )),
+    }
+}
@@ -0,0 +1,60 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{ServerState, repo::decode_repo_name},
+};
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct UnlockRepositoryResponse {
+    /// Whether the repository was locked before this request.
+    pub was_locked: bool,
+}
+
+/// Unlock a repository. Unlocking a repository that isn't locked succeeds.
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path(repository_name): Path<String>,
+) -> Result<Json<UnlockRepositoryResponse>, ErrorResponse> {
+    let repository_name = decode_repo_name(&repository_name)?;
+
+    // The subquery sees the row as it was before the update.
+    let unlocked = sqlx::query!(
+        r#"
+        UPDATE debian_repository r
+        SET locked_at = NULL, lock_reason = NULL
+        FROM (
+            SELECT id, locked_at IS NOT NULL AS was_locked
+            FROM debian_repository
+            WHERE tenant_id = $1 AND name = $2
+        ) previous
+        WHERE r.id = previous.id
+        RETURNING previous.was_locked AS "was_locked!"
+        "#,
+        tenant_id.0,
+        repository_name,
+    )
+    .fetch_optional(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+    match unlocked {
+        Some(unlocked) => Ok(Json(UnlockRepositoryResponse {
+            was_locked: unlocked.was_locked,
+        })),
+        None => Err(ErrorResponse::new(
+            StatusCode::NOT_FOUND,
+            "REPO_NOT_FOUND".to_string(),
+            "repository not found".to_string(),
+        )),
+    }
+}
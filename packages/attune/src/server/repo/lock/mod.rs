@@ -0,0 +1,67 @@
+//! Locking freezes a repository's published packages, for example while a
+//! release manager investigates an incident.
+//!
+//! Locked repositories reject index changes (adding and removing packages),
+//! resyncs, edits to the repository or its distributions, and deleting its
+//! distributions until they are unlocked.
+
+use axum::http::StatusCode;
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use sqlx::{Executor, Postgres};
+use time::OffsetDateTime;
+use tracing::instrument;
+
+use crate::api::{ErrorResponse, TenantID};
+
+pub mod create;
+pub mod delete;
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct RepositoryLock {
+    #[serde(with = "time::serde::rfc3339")]
+    #[schemars(with = "String")]
+    pub locked_at: OffsetDateTime,
+    /// Why the repository was locked, if a reason was given.
+    pub reason: Option<String>,
+}
+
+/// Return an error if the repository doesn't exist or is locked.
+#[instrument(skip(executor))]
+pub async fn ensure_unlocked<'c, E>(
+    executor: E,
+    tenant_id: &TenantID,
+    repository_name: &str,
+) -> Result<(), ErrorResponse>
+where
+    E: Executor<'c, Database = Postgres>,
+{
+    let repo = sqlx::query!(
+        r#"
+        SELECT locked_at, lock_reason
+        FROM debian_repository
+        WHERE tenant_id = $1 AND name = $2
+        "#,
+        tenant_id.0,
+        repository_name,
+    )
+    .fetch_optional(executor)
+    .await
+    .map_err(ErrorResponse::from)?
+    .ok_or(ErrorResponse::not_found("repository"))?;
+    match repo.locked_at {
+        None => Ok(()),
+        Some(_) => Err(ErrorResponse::new(
+            StatusCode::LOCKED,
+            "REPOSITORY_LOCKED".to_string(),
+            match repo.lock_reason {
+                Some(reason) => format!(
+                    "repository {repository_name:?} is locked ({reason}); unlock it before making changes"
+                ),
+                None => format!(
+                    "repository {repository_name:?} is locked; unlock it before making changes"
+                ),
+            },
+        )),
+    }
+}
@@ -7,9 +7,13 @@ pub mod create;
 pub mod delete;
 pub mod dist;
 pub mod edit;
+pub mod expiring;
 pub mod index;
 pub mod info;
 pub mod list;
+pub mod lock;
+pub mod snapshot;
+pub mod stats;
 pub mod sync;
 
 fn decode_repo_name(name: &str) -> Result<String, ErrorResponse> {
@@ -23,3 +27,13 @@ fn decode_repo_name(name: &str) -> Result<String, ErrorResponse> {
         )),
     }
 }
+
+/// The S3 key prefix that a distribution's Release files are published under.
+/// Flat repositories publish their only distribution at their root.
+fn release_prefix(s3_prefix: &str, flat: bool, distribution: &str) -> String {
+    if flat {
+        s3_prefix.to_string()
+    } else {
+        format!("{s3_prefix}/dists/{distribution}")
+    }
+}
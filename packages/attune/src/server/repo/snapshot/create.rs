@@ -0,0 +1,124 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{
+        ServerState,
+        repo::{decode_repo_name, snapshot::Snapshot},
+    },
+};
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct CreateSnapshotRequest {
+    pub name: String,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct CreateSnapshotResponse {
+    pub snapshot: Snapshot,
+}
+
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path(repository_name): Path<String>,
+    Json(req): Json<CreateSnapshotRequest>,
+) -> Result<Json<CreateSnapshotResponse>, ErrorResponse> {
+    let repository_name = decode_repo_name(&repository_name)?;
+    if req.name.is_empty() {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_SNAPSHOT_NAME".to_string(),
+            "snapshot name must not be empty".to_string(),
+        ));
+    }
+
+    // The snapshot must see a consistent set of packages, even if packages
+    // are being published concurrently.
+    let mut tx = state.db.begin().await.unwrap();
+    sqlx::query!("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
+        .execute(&mut *tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+
+    let repo = sqlx::query!(
+        r#"
+        SELECT id
+        FROM debian_repository
+        WHERE tenant_id = $1 AND name = $2
+        "#,
+        tenant_id.0,
+        repository_name,
+    )
+    .fetch_optional(&mut *tx)
+    .await
+    .map_err(ErrorResponse::from)?
+    .ok_or_else(|| {
+        ErrorResponse::builder()
+            .status(StatusCode::NOT_FOUND)
+            .error("REPO_NOT_FOUND")
+            .message("repository not found")
+            .build()
+    })?;
+
+    let snapshot = sqlx::query!(
+        r#"
+        INSERT INTO debian_repository_snapshot (repository_id, name)
+        VALUES ($1, $2)
+        ON CONFLICT (repository_id, name) DO NOTHING
+        RETURNING id, name, created_at
+        "#,
+        repo.id,
+        req.name,
+    )
+    .fetch_optional(&mut *tx)
+    .await
+    .map_err(ErrorResponse::from)?
+    .ok_or_else(|| {
+        ErrorResponse::new(
+            StatusCode::CONFLICT,
+            "SNAPSHOT_ALREADY_EXISTS".to_string(),
+            "snapshot already exists".to_string(),
+        )
+    })?;
+
+    let captured = sqlx::query!(
+        r#"
+        INSERT INTO debian_repository_snapshot_package (
+            snapshot_id,
+            package_id,
+            distribution,
+            component
+        )
+        SELECT $1, cp.package_id, r.distribution, c.name
+        FROM debian_repository_release r
+        JOIN debian_repository_component c ON c.release_id = r.id
+        JOIN debian_repository_component_package cp ON cp.component_id = c.id
+        WHERE r.repository_id = $2
+        "#,
+        snapshot.id,
+        repo.id,
+    )
+    .execute(&mut *tx)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    tx.commit().await.map_err(ErrorResponse::from)?;
+
+    Ok(Json(CreateSnapshotResponse {
+        snapshot: Snapshot {
+            name: snapshot.name,
+            created_at: snapshot.created_at,
+            package_count: captured.rows_affected() as i64,
+        },
+    }))
+}
@@ -0,0 +1,101 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{
+        ServerState,
+        repo::{
+            decode_repo_name,
+            snapshot::{Snapshot, decode_snapshot_name},
+        },
+    },
+};
+
+/// A package in a snapshot, and where it was published.
+#[derive(Serialize, Deserialize, JsonSchema, Debug, Clone, PartialEq, Eq, PartialOrd, Ord)]
+pub struct SnapshotPackage {
+    pub distribution: String,
+    pub component: String,
+    pub name: String,
+    pub version: String,
+    pub architecture: String,
+    pub sha256sum: String,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct SnapshotInfoResponse {
+    pub snapshot: Snapshot,
+    /// Every package in the snapshot, sorted by distribution, component, name,
+    /// version, and architecture.
+    pub packages: Vec<SnapshotPackage>,
+}
+
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path((repository_name, snapshot_name)): Path<(String, String)>,
+) -> Result<Json<SnapshotInfoResponse>, ErrorResponse> {
+    let repository_name = decode_repo_name(&repository_name)?;
+    let snapshot_name = decode_snapshot_name(&snapshot_name)?;
+
+    let snapshot = sqlx::query!(
+        r#"
+        SELECT s.id, s.name, s.created_at
+        FROM debian_repository_snapshot s
+        JOIN debian_repository r ON r.id = s.repository_id
+        WHERE r.tenant_id = $1 AND r.name = $2 AND s.name = $3
+        "#,
+        tenant_id.0,
+        repository_name,
+        snapshot_name,
+    )
+    .fetch_optional(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?
+    .ok_or_else(|| {
+        ErrorResponse::builder()
+            .status(StatusCode::NOT_FOUND)
+            .error("SNAPSHOT_NOT_FOUND")
+            .message("snapshot not found")
+            .build()
+    })?;
+
+    let packages = sqlx::query_as!(
+        SnapshotPackage,
+        r#"
+        SELECT
+            sp.distribution,
+            sp.component,
+            p.package AS name,
+            p.version,
+            p.architecture::text AS "architecture!",
+            p.sha256sum
+        FROM debian_repository_snapshot_package sp
+        JOIN debian_repository_package p ON p.id = sp.package_id
+        WHERE sp.snapshot_id = $1
+        ORDER BY sp.distribution, sp.component, p.package, p.version, p.architecture
+        "#,
+        snapshot.id,
+    )
+    .fetch_all(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    Ok(Json(SnapshotInfoResponse {
+        snapshot: Snapshot {
+            name: snapshot.name,
+            created_at: snapshot.created_at,
+            package_count: packages.len() as i64,
+        },
+        packages,
+    }))
+}
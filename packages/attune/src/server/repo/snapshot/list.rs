@@ -0,0 +1,75 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{
+        ServerState,
+        repo::{decode_repo_name, snapshot::Snapshot},
+    },
+};
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct ListSnapshotsResponse {
+    /// The repository's snapshots, oldest first.
+    pub snapshots: Vec<Snapshot>,
+}
+
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path(repository_name): Path<String>,
+) -> Result<Json<ListSnapshotsResponse>, ErrorResponse> {
+    let repository_name = decode_repo_name(&repository_name)?;
+
+    let repo = sqlx::query!(
+        r#"
+        SELECT id
+        FROM debian_repository
+        WHERE tenant_id = $1 AND name = $2
+        "#,
+        tenant_id.0,
+        repository_name,
+    )
+    .fetch_optional(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?
+    .ok_or_else(|| {
+        ErrorResponse::builder()
+            .status(StatusCode::NOT_FOUND)
+            .error("REPO_NOT_FOUND")
+            .message("repository not found")
+            .build()
+    })?;
+
+    let snapshots = sqlx::query_as!(
+        Snapshot,
+        r#"
+        SELECT
+            s.name,
+            s.created_at,
+            (
+                SELECT COUNT(*)
+                FROM debian_repository_snapshot_package sp
+                WHERE sp.snapshot_id = s.id
+            ) AS "package_count!"
+        FROM debian_repository_snapshot s
+        WHERE s.repository_id = $1
+        ORDER BY s.created_at, s.id
+        "#,
+        repo.id,
+    )
+    .fetch_all(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    Ok(Json(ListSnapshotsResponse { snapshots }))
+}
@@ -0,0 +1,41 @@
+//! Snapshots are named, immutable records of which packages were published in
+//! which distributions and components of a repository at a point in time.
+//!
+//! Snapshots only record package placements, not signed indexes, so that a
+//! snapshot can be republished (into a new repository, or back into its own)
+//! by the CLI, which signs the resulting indexes with the user's key.
+
+use axum::http::StatusCode;
+use percent_encoding::percent_decode_str;
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use time::OffsetDateTime;
+
+use crate::api::ErrorResponse;
+
+pub mod create;
+pub mod info;
+pub mod list;
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct Snapshot {
+    pub name: String,
+    #[serde(with = "time::serde::rfc3339")]
+    #[schemars(with = "String")]
+    pub created_at: OffsetDateTime,
+    /// The number of package placements in the snapshot. A package published
+    /// in several distributions or components is counted once for each.
+    pub package_count: i64,
+}
+
+fn decode_snapshot_name(name: &str) -> Result<String, ErrorResponse> {
+    // The snapshot name in the path is percent-encoded.
+    match percent_decode_str(name).decode_utf8() {
+        Ok(name) => Ok(name.to_string()),
+        Err(err) => Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_SNAPSHOT_NAME".to_string(),
+            format!("Invalid snapshot name: could not percent decode: {err}"),
+        )),
+    }
+}
@@ -0,0 +1,153 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{ServerState, repo::decode_repo_name},
+};
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct RepositoryStatsResponse {
+    /// The number of distinct packages published in the repository. A package
+    /// published in several distributions or components is counted once.
+    pub package_count: i64,
+    /// The total size, in bytes, of the distinct package files in the pool.
+    pub pool_size: i64,
+    /// The number of `Packages` indexes, counting each compression
+    /// separately.
+    pub index_count: i64,
+    /// The total size, in bytes, of the `Packages` indexes.
+    pub index_size: i64,
+    /// Package counts and sizes, sorted by distribution, component, and
+    /// architecture.
+    pub components: Vec<ComponentStats>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct ComponentStats {
+    pub distribution: String,
+    pub component: String,
+    pub architecture: String,
+    pub package_count: i64,
+    /// The total size, in bytes, of the package files.
+    pub size: i64,
+}
+
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path(repository_name): Path<String>,
+) -> Result<Json<RepositoryStatsResponse>, ErrorResponse> {
+    let repository_name = decode_repo_name(&repository_name)?;
+
+    let repo = sqlx::query!(
+        r#"
+        SELECT id
+        FROM debian_repository
+        WHERE tenant_id = $1 AND name = $2
+        "#,
+        tenant_id.0,
+        repository_name,
+    )
+    .fetch_optional(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?
+    .ok_or_else(|| {
+        ErrorResponse::builder()
+            .status(StatusCode::NOT_FOUND)
+            .error("REPO_NOT_FOUND")
+            .message("repository not found")
+            .build()
+    })?;
+
+    // All of the aggregates are read in one transaction, so that they agree
+    // with each other even while packages are being published.
+    let mut tx = state.db.begin().await.map_err(ErrorResponse::from)?;
+    sqlx::query!("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
+        .execute(&mut *tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+
+    let components = sqlx::query_as!(
+        ComponentStats,
+        r#"
+        SELECT
+            release.distribution,
+            component.name AS component,
+            package.architecture::TEXT AS "architecture!",
+            COUNT(*) AS "package_count!",
+            SUM(package.size)::BIGINT AS "size!"
+        FROM debian_repository_release AS release
+        JOIN debian_repository_component AS component
+            ON component.release_id = release.id
+        JOIN debian_repository_component_package AS component_package
+            ON component_package.component_id = component.id
+        JOIN debian_repository_package AS package
+            ON package.id = component_package.package_id
+        WHERE release.repository_id = $1
+        GROUP BY release.distribution, component.name, package.architecture
+        ORDER BY release.distribution, component.name, package.architecture
+        "#,
+        repo.id,
+    )
+    .fetch_all(&mut *tx)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    let pool = sqlx::query!(
+        r#"
+        SELECT
+            COUNT(*) AS "package_count!",
+            COALESCE(SUM(package.size), 0)::BIGINT AS "pool_size!"
+        FROM debian_repository_package AS package
+        WHERE package.id IN (
+            SELECT component_package.package_id
+            FROM debian_repository_component_package AS component_package
+            JOIN debian_repository_component AS component
+                ON component.id = component_package.component_id
+            JOIN debian_repository_release AS release
+                ON release.id = component.release_id
+            WHERE release.repository_id = $1
+        )
+        "#,
+        repo.id,
+    )
+    .fetch_one(&mut *tx)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    let indexes = sqlx::query!(
+        r#"
+        SELECT
+            COUNT(*) AS "index_count!",
+            COALESCE(SUM(packages_index.size), 0)::BIGINT AS "index_size!"
+        FROM debian_repository_index_packages AS packages_index
+        JOIN debian_repository_component AS component
+            ON component.id = packages_index.component_id
+        JOIN debian_repository_release AS release
+            ON release.id = component.release_id
+        WHERE release.repository_id = $1
+        "#,
+        repo.id,
+    )
+    .fetch_one(&mut *tx)
+    .await
+    .map_err(ErrorResponse::from)?;
+    tx.commit().await.map_err(ErrorResponse::from)?;
+
+    Ok(Json(RepositoryStatsResponse {
+        package_count: pool.package_count,
+        pool_size: pool.pool_size,
+        index_count: indexes.index_count,
+        index_size: indexes.index_size,
+        components,
+    }))
+}
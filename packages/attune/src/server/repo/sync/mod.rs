@@ -6,12 +6,20 @@ use base64::Engine;
 use derivative::Derivative;
 use hex;
 use http::StatusCode;
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 use sha2::{Digest as _, Sha256};
 use sqlx::{Postgres, Transaction};
 use tracing::{Level, debug, instrument};
 
-use crate::api::{ErrorResponse, TenantID};
+use crate::{
+    api::{ErrorResponse, TenantID},
+    apt::{
+        ContentsIndex, Dep11File, FlatPackagesIndex, PackagesDiff, PackagesDiffIndex,
+        PackagesIndexMeta, TranslationIndex, index_component,
+    },
+    server::repo::release_prefix,
+};
 
 #[derive(Derivative)]
 #[derivative(Debug, Clone)]
@@ -24,7 +32,8 @@ pub enum Expected {
         ///
         /// For packages, this is the CopyObject key (which includes the bucket
         /// name) to the canonical package object.
-        contents: String,
+        #[derivative(Debug(format_with = "display_contents"))]
+        contents: Vec<u8>,
         /// The SHA256 sum of the object, used to determine whether the object
         /// has changed.
         #[derivative(Debug(format_with = "display_hex"))]
@@ -39,6 +48,10 @@ fn display_hex(hex: &Vec<u8>, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Resu
     write!(f, "{:?}", hex::encode(hex))
 }
 
+fn display_contents(contents: &Vec<u8>, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+    write!(f, "{:?}", String::from_utf8_lossy(contents))
+}
+
 impl Expected {
     pub fn key(&self) -> &str {
         match self {
@@ -89,7 +102,7 @@ pub async fn query_repository_state(
 ) -> Result<RepositoryState, ErrorResponse> {
     let repo = sqlx::query!(
         r#"
-        SELECT id, name, s3_bucket, s3_prefix
+        SELECT id, name, s3_bucket, s3_prefix, flat
         FROM debian_repository
         WHERE tenant_id = $1 AND name = $2
     "#,
@@ -122,30 +135,31 @@ pub async fn query_repository_state(
         "RELEASE_NOT_FOUND".to_string(),
         "release not found".to_string(),
     ))?;
+    let release_prefix = release_prefix(&repo.s3_prefix, repo.flat, &release_name);
     let release_contents = Expected::Exists {
-        key: format!("{}/dists/{}/Release", &repo.s3_prefix, &release_name),
+        key: format!("{release_prefix}/Release"),
         sha256sum: Sha256::digest(&release.contents).to_vec(),
-        contents: release.contents,
+        contents: release.contents.into_bytes(),
     };
     let release_clearsigned = release
         .clearsigned
         .map(|clearsigned| Expected::Exists {
-            key: format!("{}/dists/{}/InRelease", &repo.s3_prefix, &release_name),
+            key: format!("{release_prefix}/InRelease"),
             sha256sum: Sha256::digest(&clearsigned).to_vec(),
-            contents: clearsigned,
+            contents: clearsigned.into_bytes(),
         })
         .unwrap_or(Expected::DoesNotExist {
-            key: format!("{}/dists/{}/InRelease", &repo.s3_prefix, &release_name),
+            key: format!("{release_prefix}/InRelease"),
         });
     let release_detachsigned = release
         .detached
         .map(|detached| Expected::Exists {
-            key: format!("{}/dists/{}/Release.gpg", &repo.s3_prefix, &release_name),
+            key: format!("{release_prefix}/Release.gpg"),
             sha256sum: Sha256::digest(&detached).to_vec(),
-            contents: detached,
+            contents: detached.into_bytes(),
         })
         .unwrap_or(Expected::DoesNotExist {
-            key: format!("{}/dists/{}/Release.gpg", &repo.s3_prefix, &release_name),
+            key: format!("{release_prefix}/Release.gpg"),
         });
 
     // Check package indexes for consistency.
@@ -156,7 +170,8 @@ pub async fn query_repository_state(
             debian_repository_index_packages.md5sum,
             debian_repository_index_packages.sha1sum,
             debian_repository_index_packages.sha256sum,
-            debian_repository_index_packages.contents
+            debian_repository_index_packages.contents,
+            debian_repository_index_packages.debian_installer
         FROM
             debian_repository_index_packages
             JOIN debian_repository_component ON debian_repository_index_packages.component_id = debian_repository_component.id
@@ -167,39 +182,192 @@ pub async fn query_repository_state(
     )
     .fetch_all(&mut **tx)
     .await
-    .map_err(ErrorResponse::from)?;
-    let packages_indexes = packages_indexes
-        .into_iter()
-        .flat_map(|packages_index| {
-            let by_hash_prefix = format!(
-                "{}/dists/{}/{}/binary-{}/by-hash",
-                repo.s3_prefix,
-                &release_name,
-                &packages_index.component,
-                &packages_index.architecture
-            );
-            let sha256sum = hex::decode(&packages_index.sha256sum)
-                .expect("could not decode Packages index SHA256 sum");
-            let contents = String::from_utf8(packages_index.contents).unwrap();
-            [
-                format!(
-                    "{}/dists/{}/{}/binary-{}/Packages",
-                    &repo.s3_prefix,
+    .map_err(ErrorResponse::from)?
+    .into_iter()
+    .map(|packages_index| {
+        (
+            index_component(&packages_index.component, packages_index.debian_installer),
+            packages_index,
+        )
+    })
+    .collect::<Vec<_>>();
+    let mut packages_indexes = if repo.flat {
+        // Flat repositories have a single Packages index at their root.
+        let flat_packages_index = FlatPackagesIndex::from_indexes(
+            &packages_indexes
+                .into_iter()
+                .map(|(component, packages_index)| {
+                    (
+                        (component, packages_index.architecture),
+                        String::from_utf8(packages_index.contents).unwrap(),
+                    )
+                })
+                .collect(),
+        );
+        vec![Expected::Exists {
+            key: format!("{}/Packages", repo.s3_prefix),
+            sha256sum: hex::decode(&flat_packages_index.sha256sum)
+                .expect("could not decode Packages index SHA256 sum"),
+            contents: flat_packages_index.contents.into_bytes(),
+        }]
+    } else {
+        packages_indexes
+            .into_iter()
+            .flat_map(|(component, packages_index)| {
+                let by_hash_prefix = format!(
+                    "{}/dists/{}/{}/binary-{}/by-hash",
+                    repo.s3_prefix,
                     &release_name,
-                    &packages_index.component,
+                    &component,
                     &packages_index.architecture
-                ),
-                format!("{}/SHA256/{}", by_hash_prefix, packages_index.sha256sum),
-                format!("{}/SHA1/{}", by_hash_prefix, packages_index.sha1sum),
-                format!("{}/MD5Sum/{}", by_hash_prefix, packages_index.md5sum),
-            ]
-            .map(|key| Expected::Exists {
-                key,
-                sha256sum: sha256sum.clone(),
-                contents: contents.clone(),
+                );
+                let sha256sum = hex::decode(&packages_index.sha256sum)
+                    .expect("could not decode Packages index SHA256 sum");
+                let contents = packages_index.contents;
+                [
+                    format!(
+                        "{}/dists/{}/{}/binary-{}/Packages",
+                        &repo.s3_prefix,
+                        &release_name,
+                        &component,
+                        &packages_index.architecture
+                    ),
+                    format!("{}/SHA256/{}", by_hash_prefix, packages_index.sha256sum),
+                    format!("{}/SHA1/{}", by_hash_prefix, packages_index.sha1sum),
+                    format!("{}/MD5Sum/{}", by_hash_prefix, packages_index.md5sum),
+                ]
+                .map(|key| Expected::Exists {
+                    key,
+                    sha256sum: sha256sum.clone(),
+                    contents: contents.clone(),
+                })
             })
-        })
-        .collect::<Vec<_>>();
+            .collect::<Vec<_>>()
+    };
+
+    // Check `Packages.diff/` histories for consistency. Flat repositories
+    // don't have them.
+    if !repo.flat {
+        let history =
+            PackagesDiff::query_from_release(tx, tenant_id, &repo.name, &release_name).await?;
+        let metas =
+            PackagesIndexMeta::query_from_release(tx, tenant_id, &repo.name, &release_name)
+                .await?;
+        for meta in metas {
+            let Some(history) = history.get(&(meta.component.clone(), meta.architecture.clone()))
+            else {
+                continue;
+            };
+            let diff_prefix = format!(
+                "{}/dists/{}/{}/binary-{}/Packages.diff",
+                repo.s3_prefix, &release_name, &meta.component, &meta.architecture
+            );
+            let diff_index = PackagesDiffIndex::render(&meta, history);
+            let sha256sum = hex::decode(&diff_index.sha256sum)
+                .expect("could not decode Packages.diff/Index SHA256 sum");
+            packages_indexes.extend(
+                [
+                    format!("{diff_prefix}/Index"),
+                    format!("{diff_prefix}/by-hash/SHA256/{}", diff_index.sha256sum),
+                    format!("{diff_prefix}/by-hash/MD5Sum/{}", diff_index.md5sum),
+                ]
+                .map(|key| Expected::Exists {
+                    key,
+                    sha256sum: sha256sum.clone(),
+                    contents: diff_index.contents.as_bytes().to_vec(),
+                }),
+            );
+            packages_indexes.extend(history.iter().map(|diff| Expected::Exists {
+                key: format!("{diff_prefix}/{}.gz", diff.name),
+                sha256sum: hex::decode(&diff.download_sha256sum)
+                    .expect("could not decode patch SHA256 sum"),
+                contents: diff.contents.clone(),
+            }));
+        }
+    }
+
+    // Check Contents indexes for consistency. Flat repositories don't have
+    // them.
+    if !repo.flat {
+        let contents_indexes =
+            ContentsIndex::query_from_release(tx, tenant_id, &repo.name, &release_name).await?;
+        for index in contents_indexes {
+            let component_prefix = format!(
+                "{}/dists/{}/{}",
+                repo.s3_prefix, &release_name, &index.meta.component
+            );
+            let sha256sum = hex::decode(&index.meta.sha256sum)
+                .expect("could not decode Contents index SHA256 sum");
+            packages_indexes.extend(
+                [
+                    format!("{component_prefix}/Contents-{}.gz", index.meta.architecture),
+                    format!("{component_prefix}/by-hash/SHA256/{}", index.meta.sha256sum),
+                    format!("{component_prefix}/by-hash/MD5Sum/{}", index.meta.md5sum),
+                ]
+                .map(|key| Expected::Exists {
+                    key,
+                    sha256sum: sha256sum.clone(),
+                    contents: index.contents.clone(),
+                }),
+            );
+        }
+    }
+
+    // Check Translation indexes for consistency. Flat repositories don't have
+    // them.
+    if !repo.flat {
+        let translation_indexes =
+            TranslationIndex::query_from_release(tx, tenant_id, &repo.name, &release_name)
+                .await?;
+        for index in translation_indexes {
+            let i18n_prefix = format!(
+                "{}/dists/{}/{}/i18n",
+                repo.s3_prefix, &release_name, &index.meta.component
+            );
+            let sha256sum = hex::decode(&index.meta.sha256sum)
+                .expect("could not decode Translation index SHA256 sum");
+            packages_indexes.extend(
+                [
+                    format!("{i18n_prefix}/Translation-{}", index.meta.language),
+                    format!("{i18n_prefix}/by-hash/SHA256/{}", index.meta.sha256sum),
+                    format!("{i18n_prefix}/by-hash/MD5Sum/{}", index.meta.md5sum),
+                ]
+                .map(|key| Expected::Exists {
+                    key,
+                    sha256sum: sha256sum.clone(),
+                    contents: index.contents.as_bytes().to_vec(),
+                }),
+            );
+        }
+    }
+
+    // Check published DEP-11 files for consistency. Files that were replaced
+    // or removed since the Release file was signed can't be restored, because
+    // only their current version is stored. Flat repositories don't have them.
+    if !repo.flat {
+        let dep11_files =
+            Dep11File::query_from_release(tx, tenant_id, &repo.name, &release_name, true).await?;
+        for file in dep11_files {
+            let dep11_prefix = format!(
+                "{}/dists/{}/{}/dep11",
+                repo.s3_prefix, &release_name, &file.meta.component
+            );
+            let sha256sum = hex::decode(&file.meta.sha256sum)
+                .expect("could not decode DEP-11 file SHA256 sum");
+            packages_indexes.extend(
+                [
+                    format!("{dep11_prefix}/{}", file.meta.name),
+                    format!("{dep11_prefix}/by-hash/SHA256/{}", file.meta.sha256sum),
+                    format!("{dep11_prefix}/by-hash/MD5Sum/{}", file.meta.md5sum),
+                ]
+                .map(|key| Expected::Exists {
+                    key,
+                    sha256sum: sha256sum.clone(),
+                    contents: file.contents.clone(),
+                }),
+            );
+        }
+    }
 
     // Check packages for consistency.
     let packages = sqlx::query!(
@@ -224,7 +392,8 @@ pub async fn query_repository_state(
         .into_iter()
         .map(|package| Expected::Exists {
             key: format!("{}/{}", repo.s3_prefix, package.filename),
-            contents: format!("{}/packages/{}", package.s3_bucket, package.sha256sum),
+            contents: format!("{}/packages/{}", package.s3_bucket, package.sha256sum)
+                .into_bytes(),
             sha256sum: hex::decode(&package.sha256sum)
                 .expect("could not decode package SHA256 sum"),
         })
@@ -334,7 +503,7 @@ pub async fn check_s3_consistency(
 
 /// This Summary object is safe to serialize and send to clients, because it is
 /// reasonably sized and doesn't leak implementation details (like S3 prefixes).
-#[derive(Debug, Serialize, Deserialize)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct InconsistentSummary {
     pub release: bool,
     pub release_clearsigned: bool,
@@ -355,8 +524,11 @@ impl From<&InconsistentObjects> for InconsistentSummary {
                 .map(|pi| {
                     // Remove the S3 prefix to avoid leaking information.
                     let path = pi.key();
-                    let (_, suffix) = path.split_once("/dists/").unwrap();
-                    format!("dists/{suffix}")
+                    match path.split_once("/dists/") {
+                        Some((_, suffix)) => format!("dists/{suffix}"),
+                        // Flat repositories' index is at their root.
+                        None => path.rsplit('/').next().unwrap_or(path).to_string(),
+                    }
                 })
                 .collect(),
             packages: inconsistent_objects
@@ -5,15 +5,18 @@ use axum::{
 };
 use base64::Engine;
 use md5::{Digest as _, Md5};
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 use tracing::{Level, debug, instrument};
 
 use crate::{
-    api::{ErrorResponse, TenantID},
+    api::{Actor, ErrorResponse},
     server::{
         ServerState,
+        audit::{self, AuditAction, AuditRecord},
         repo::{
             decode_repo_name,
+            lock::ensure_unlocked,
             sync::{
                 Expected, InconsistentObjects, InconsistentSummary, check_s3_consistency,
                 query_repository_state,
@@ -22,7 +25,7 @@ use crate::{
     },
 };
 
-#[derive(Debug, Serialize, Deserialize)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct ResyncRepositoryResponse {
     #[serde(flatten)]
     pub status: InconsistentSummary,
@@ -32,9 +35,10 @@ pub struct ResyncRepositoryResponse {
 #[instrument(skip(state))]
 pub async fn handler(
     State(state): State<ServerState>,
-    tenant_id: TenantID,
+    actor: Actor,
     Path((repo_name, release_name)): Path<(String, String)>,
 ) -> Result<Json<ResyncRepositoryResponse>, ErrorResponse> {
+    let tenant_id = actor.tenant_id;
     // The repository name in the path is percent-encoded.
     let repo_name = decode_repo_name(&repo_name)?;
     let release_name = decode_repo_name(&release_name)?;
@@ -45,6 +49,19 @@ pub async fn handler(
         .execute(&mut *tx)
         .await
         .map_err(ErrorResponse::from)?;
+    ensure_unlocked(&mut *tx, &tenant_id, &repo_name).await?;
+    audit::record(
+        &mut *tx,
+        &actor,
+        &AuditRecord {
+            action: AuditAction::DistributionResync,
+            repository: &repo_name,
+            distribution: &release_name,
+            component: None,
+            package: None,
+        },
+    )
+    .await?;
     let repo = query_repository_state(&mut tx, &tenant_id, repo_name, release_name).await?;
     tx.commit().await.map_err(ErrorResponse::from)?;
     debug!(?repo, "loaded repository state");
@@ -72,13 +89,10 @@ async fn resync_index(
             s3.put_object()
                 .bucket(s3_bucket)
                 .key(key)
-                .content_md5(
-                    base64::engine::general_purpose::STANDARD
-                        .encode(Md5::digest(contents.as_bytes())),
-                )
+                .content_md5(base64::engine::general_purpose::STANDARD.encode(Md5::digest(&contents)))
                 .checksum_algorithm(ChecksumAlgorithm::Sha256)
                 .checksum_sha256(base64::engine::general_purpose::STANDARD.encode(sha256sum))
-                .body(contents.as_bytes().to_vec().into())
+                .body(contents.into())
                 .send()
                 .await
                 .unwrap();
@@ -108,7 +122,9 @@ async fn resync_package(
             s3.copy_object()
                 .bucket(s3_bucket)
                 .key(key)
-                .copy_source(contents)
+                .copy_source(
+                    String::from_utf8(contents).expect("package copy source is not UTF-8"),
+                )
                 .send()
                 .await
                 .unwrap();
@@ -0,0 +1,384 @@
+use axum::Json;
+use schemars::{Schema, schema_for};
+use serde_json::{Map, Value, json};
+
+use crate::{
+    api::ErrorResponse,
+    server::{
+        audit::list::AuditListResponse,
+        compatibility::API_VERSION_HEADER_V0_2_0,
+        pkg::{
+            info::PackageInfoResponse, list::PackageListResponse, owns::PackageOwnersResponse,
+            search::PackageSearchResponse, translation::PackageTranslationResponse,
+            upstream::VerifyUpstreamSignatureResponse, versions::PackageVersionsResponse,
+        },
+        repo::{
+            create::CreateRepositoryResponse,
+            delete::DeleteRepositoryResponse,
+            dist::{
+                create::CreateDistributionResponse,
+                delete::DeleteDistributionResponse,
+                dep11::{Dep11FileResponse, ListDep11FilesResponse},
+                edit::EditDistributionResponse,
+                list::ListDistributionsResponse,
+            },
+            edit::EditRepositoryResponse,
+            expiring::ExpiringReleasesResponse,
+            index::PackageChange,
+            info::RepositoryInfoResponse,
+            list::ListRepositoryResponse,
+            lock::{create::LockRepositoryResponse, delete::UnlockRepositoryResponse},
+            snapshot::{
+                create::CreateSnapshotResponse, info::SnapshotInfoResponse,
+                list::ListSnapshotsResponse,
+            },
+            stats::RepositoryStatsResponse,
+            sync::resync::ResyncRepositoryResponse,
+        },
+        token::{
+            create::CreateTokenResponse, list::ListTokensResponse, revoke::RevokeTokenResponse,
+            rotate::RotateTokenResponse,
+        },
+    },
+};
+
+/// The version of the schema document.
+///
+/// Structured output is made of API types, so the schemas change exactly when
+/// the API version does.
+pub const SCHEMA_VERSION: &str = API_VERSION_HEADER_V0_2_0;
+
+/// A named schema for a structured API response, along with the endpoint that
+/// produces it (if any).
+pub struct NamedSchema {
+    pub name: &'static str,
+    pub endpoint: Option<(&'static str, &'static str)>,
+    pub schema: Schema,
+}
+
+/// Every structured response that the API (and therefore the CLI) produces,
+/// keyed by a stable name.
+///
+/// When adding a new structured response, add its schema here so that
+/// downstream integrations can validate against it and so that `attune api
+/// check` can detect drift. Attune doesn't send webhooks, so there are no
+/// webhook payloads here; their schemas belong here once it does.
+pub fn schemas() -> Vec<NamedSchema> {
+    vec![
+        NamedSchema {
+            name: "error",
+            endpoint: None,
+            schema: schema_for!(ErrorResponse),
+        },
+        NamedSchema {
+            name: "repo.create",
+            endpoint: Some(("post", "/api/v0/repositories")),
+            schema: schema_for!(CreateRepositoryResponse),
+        },
+        NamedSchema {
+            name: "repo.list",
+            endpoint: Some(("get", "/api/v0/repositories")),
+            schema: schema_for!(ListRepositoryResponse),
+        },
+        NamedSchema {
+            name: "repo.show",
+            endpoint: Some(("get", "/api/v0/repositories/{repository_name}")),
+            schema: schema_for!(RepositoryInfoResponse),
+        },
+        NamedSchema {
+            name: "repo.edit",
+            endpoint: Some(("put", "/api/v0/repositories/{repository_name}")),
+            schema: schema_for!(EditRepositoryResponse),
+        },
+        NamedSchema {
+            name: "repo.delete",
+            endpoint: Some(("delete", "/api/v0/repositories/{repository_name}")),
+            schema: schema_for!(DeleteRepositoryResponse),
+        },
+        NamedSchema {
+            name: "repo.stats",
+            endpoint: Some(("get", "/api/v0/repositories/{repository_name}/stats")),
+            schema: schema_for!(RepositoryStatsResponse),
+        },
+        NamedSchema {
+            name: "repo.expiring",
+            endpoint: Some(("get", "/api/v0/releases/expiring")),
+            schema: schema_for!(ExpiringReleasesResponse),
+        },
+        NamedSchema {
+            name: "repo.lock",
+            endpoint: Some(("put", "/api/v0/repositories/{repository_name}/lock")),
+            schema: schema_for!(LockRepositoryResponse),
+        },
+        NamedSchema {
+            name: "repo.unlock",
+            endpoint: Some(("delete", "/api/v0/repositories/{repository_name}/lock")),
+            schema: schema_for!(UnlockRepositoryResponse),
+        },
+        NamedSchema {
+            name: "dist.create",
+            endpoint: Some((
+                "post",
+                "/api/v0/repositories/{repository_name}/distributions",
+            )),
+            schema: schema_for!(CreateDistributionResponse),
+        },
+        NamedSchema {
+            name: "dist.list",
+            endpoint: Some((
+                "get",
+                "/api/v0/repositories/{repository_name}/distributions",
+            )),
+            schema: schema_for!(ListDistributionsResponse),
+        },
+        NamedSchema {
+            name: "dist.edit",
+            endpoint: Some((
+                "put",
+                "/api/v0/repositories/{repository_name}/distributions/{distribution_name}",
+            )),
+            schema: schema_for!(EditDistributionResponse),
+        },
+        NamedSchema {
+            name: "dist.delete",
+            endpoint: Some((
+                "delete",
+                "/api/v0/repositories/{repository_name}/distributions/{distribution_name}",
+            )),
+            schema: schema_for!(DeleteDistributionResponse),
+        },
+        NamedSchema {
+            name: "dist.resync",
+            endpoint: Some((
+                "post",
+                "/api/v0/repositories/{repository_name}/distributions/{distribution_name}/sync",
+            )),
+            schema: schema_for!(ResyncRepositoryResponse),
+        },
+        NamedSchema {
+            name: "dist.dep11.list",
+            endpoint: Some((
+                "get",
+                "/api/v0/repositories/{repository_name}/distributions/{distribution_name}/dep11",
+            )),
+            schema: schema_for!(ListDep11FilesResponse),
+        },
+        NamedSchema {
+            name: "dist.dep11.upload",
+            endpoint: Some((
+                "put",
+                "/api/v0/repositories/{repository_name}/distributions/{distribution_name}/dep11/{component}/{file_name}",
+            )),
+            schema: schema_for!(Dep11FileResponse),
+        },
+        NamedSchema {
+            name: "dist.dep11.delete",
+            endpoint: Some((
+                "delete",
+                "/api/v0/repositories/{repository_name}/distributions/{distribution_name}/dep11/{component}/{file_name}",
+            )),
+            schema: schema_for!(Dep11FileResponse),
+        },
+        NamedSchema {
+            name: "snapshot.create",
+            endpoint: Some(("post", "/api/v0/repositories/{repository_name}/snapshots")),
+            schema: schema_for!(CreateSnapshotResponse),
+        },
+        NamedSchema {
+            name: "snapshot.list",
+            endpoint: Some(("get", "/api/v0/repositories/{repository_name}/snapshots")),
+            schema: schema_for!(ListSnapshotsResponse),
+        },
+        NamedSchema {
+            name: "snapshot.show",
+            endpoint: Some((
+                "get",
+                "/api/v0/repositories/{repository_name}/snapshots/{snapshot_name}",
+            )),
+            schema: schema_for!(SnapshotInfoResponse),
+        },
+        NamedSchema {
+            name: "pkg.list",
+            endpoint: Some(("get", "/api/v0/packages")),
+            schema: schema_for!(PackageListResponse),
+        },
+        NamedSchema {
+            name: "pkg.show",
+            endpoint: Some(("get", "/api/v0/packages/{package_sha256sum}")),
+            schema: schema_for!(PackageInfoResponse),
+        },
+        NamedSchema {
+            name: "pkg.owns",
+            endpoint: Some(("get", "/api/v0/packages/owns")),
+            schema: schema_for!(PackageOwnersResponse),
+        },
+        NamedSchema {
+            name: "pkg.search",
+            endpoint: Some(("get", "/api/v0/packages/search")),
+            schema: schema_for!(PackageSearchResponse),
+        },
+        NamedSchema {
+            name: "pkg.versions",
+            endpoint: Some(("get", "/api/v0/packages/versions")),
+            schema: schema_for!(PackageVersionsResponse),
+        },
+        NamedSchema {
+            name: "pkg.upstream.verify",
+            endpoint: Some((
+                "post",
+                "/api/v0/packages/{package_sha256sum}/upstream-signature",
+            )),
+            schema: schema_for!(VerifyUpstreamSignatureResponse),
+        },
+        NamedSchema {
+            name: "pkg.translation.set",
+            endpoint: Some((
+                "put",
+                "/api/v0/packages/{package_sha256sum}/translations/{language}",
+            )),
+            schema: schema_for!(PackageTranslationResponse),
+        },
+        NamedSchema {
+            name: "pkg.translation.delete",
+            endpoint: Some((
+                "delete",
+                "/api/v0/packages/{package_sha256sum}/translations/{language}",
+            )),
+            schema: schema_for!(PackageTranslationResponse),
+        },
+        // Printed by `attune apt pkg add` and `attune apt pkg remove`, which
+        // make several API requests.
+        NamedSchema {
+            name: "pkg.change",
+            endpoint: None,
+            schema: schema_for!(PackageChange),
+        },
+        NamedSchema {
+            name: "audit.list",
+            endpoint: Some(("get", "/api/v0/audit")),
+            schema: schema_for!(AuditListResponse),
+        },
+        NamedSchema {
+            name: "token.create",
+            endpoint: Some(("post", "/api/v0/tokens")),
+            schema: schema_for!(CreateTokenResponse),
+        },
+        NamedSchema {
+            name: "token.list",
+            endpoint: Some(("get", "/api/v0/tokens")),
+            schema: schema_for!(ListTokensResponse),
+        },
+        NamedSchema {
+            name: "token.revoke",
+            endpoint: Some(("delete", "/api/v0/tokens/{token_id}")),
+            schema: schema_for!(RevokeTokenResponse),
+        },
+        NamedSchema {
+            name: "token.rotate",
+            endpoint: Some(("post", "/api/v0/tokens/{token_id}/rotate")),
+            schema: schema_for!(RotateTokenResponse),
+        },
+    ]
+}
+
+/// Render the schemas as an OpenAPI document.
+///
+/// OpenAPI 3.1 schema objects are JSON Schemas, so each named schema is
+/// included verbatim as a component.
+pub fn openapi() -> Value {
+    let schemas = schemas();
+
+    let mut paths = Map::new();
+    for named in &schemas {
+        let Some((method, path)) = named.endpoint else {
+            continue;
+        };
+        let operations = paths
+            .entry(path)
+            .or_insert_with(|| Value::Object(Map::new()))
+            .as_object_mut()
+            .unwrap();
+        operations.insert(
+            method.to_string(),
+            json!({
+                "operationId": named.name,
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "content": {
+                            "application/json": {
+                                "schema": { "$ref": format!("#/components/schemas/{}", named.name) }
+                            }
+                        }
+                    },
+                    "default": {
+                        "description": "Error",
+                        "content": {
+                            "application/json": {
+                                "schema": { "$ref": "#/components/schemas/error" }
+                            }
+                        }
+                    }
+                }
+            }),
+        );
+    }
+
+    let components = schemas
+        .into_iter()
+        .map(|named| {
+            (
+                named.name.to_string(),
+                serde_json::to_value(named.schema).unwrap(),
+            )
+        })
+        .collect::<Map<String, Value>>();
+
+    json!({
+        "openapi": "3.1.0",
+        "info": {
+            "title": "Attune API",
+            "version": SCHEMA_VERSION,
+        },
+        "paths": paths,
+        "components": {
+            "schemas": components,
+        },
+    })
+}
+
+#[axum::debug_handler]
+pub async fn handler() -> Json<Value> {
+    Json(openapi())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Every operation in the OpenAPI document must reference a schema that
+    /// exists in its components.
+    #[test]
+    fn openapi_refs_resolve() {
+        let doc = openapi();
+        let components = doc["components"]["schemas"].as_object().unwrap();
+        let refs = doc["paths"]
+            .as_object()
+            .unwrap()
+            .values()
+            .flat_map(|ops| ops.as_object().unwrap().values())
+            .flat_map(|op| op["responses"].as_object().unwrap().values())
+            .map(|res| {
+                res["content"]["application/json"]["schema"]["$ref"]
+                    .as_str()
+                    .unwrap()
+                    .to_string()
+            })
+            .collect::<Vec<_>>();
+        assert!(!refs.is_empty());
+        for r in refs {
+            let name = r.strip_prefix("#/components/schemas/").unwrap();
+            assert!(components.contains_key(name), "dangling ref {r:?}");
+        }
+    }
+}
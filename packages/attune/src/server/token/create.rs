@@ -0,0 +1,59 @@
+use axum::{Json, extract::State, http::StatusCode};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{ServerState, token::generate_token},
+};
+
+#[derive(Serialize, Deserialize, Debug)]
+pub struct CreateTokenRequest {
+    pub name: String,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema)]
+pub struct CreateTokenResponse {
+    pub id: i64,
+    pub name: String,
+    /// The plaintext token. This is not stored, and can't be retrieved again.
+    pub token: String,
+}
+
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Json(req): Json<CreateTokenRequest>,
+) -> Result<Json<CreateTokenResponse>, ErrorResponse> {
+    if req.name.trim().is_empty() {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "INVALID_TOKEN_NAME".to_string(),
+            "token name must not be empty".to_string(),
+        ));
+    }
+
+    let (token, hash) = generate_token();
+    let inserted = sqlx::query!(
+        r#"
+        INSERT INTO attune_tenant_api_token (tenant_id, name, token, created_at, updated_at)
+        VALUES ($1, $2, $3, NOW(), NOW())
+        RETURNING id
+        "#,
+        tenant_id.0,
+        req.name,
+        hash,
+    )
+    .fetch_one(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    Ok(Json(CreateTokenResponse {
+        id: inserted.id,
+        name: req.name,
+        token,
+    }))
+}
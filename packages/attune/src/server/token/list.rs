@@ -0,0 +1,52 @@
+use axum::{Json, extract::State};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use time::OffsetDateTime;
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::ServerState,
+};
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct ApiToken {
+    pub id: i64,
+    pub name: String,
+    #[serde(with = "time::serde::rfc3339")]
+    #[schemars(with = "String")]
+    pub created_at: OffsetDateTime,
+    /// Approximate time that the token was last used, or `None` if it has
+    /// never been used.
+    #[serde(with = "time::serde::rfc3339::option")]
+    #[schemars(with = "Option<String>")]
+    pub last_used_at: Option<OffsetDateTime>,
+}
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct ListTokensResponse {
+    pub tokens: Vec<ApiToken>,
+}
+
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+) -> Result<Json<ListTokensResponse>, ErrorResponse> {
+    let tokens = sqlx::query_as!(
+        ApiToken,
+        r#"
+        SELECT id, name, created_at, last_used_at
+        FROM attune_tenant_api_token
+        WHERE tenant_id = $1
+        ORDER BY id
+        "#,
+        tenant_id.0,
+    )
+    .fetch_all(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+
+    Ok(Json(ListTokensResponse { tokens }))
+}
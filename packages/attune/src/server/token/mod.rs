@@ -0,0 +1,122 @@
+//! API token management.
+//!
+//! Tokens are scoped to the tenant of the token used to manage them. Only the
+//! SHA-256 hash of a token is stored, so the plaintext token is only ever
+//! returned once, when it is created or rotated.
+
+use sha2::{Digest as _, Sha256};
+
+pub mod create;
+pub mod list;
+pub mod revoke;
+pub mod rotate;
+
+/// Prefix of generated API tokens, to make them recognizable (e.g. by secret
+/// scanners).
+const TOKEN_PREFIX: &str = "attune_";
+
+/// Generate a new random API token, returning the plaintext token and the hash
+/// to store.
+fn generate_token() -> (String, Vec<u8>) {
+    let token = format!("{TOKEN_PREFIX}{}", hex::encode(rand::random::<[u8; 32]>()));
+    let hash = Sha256::digest(&token).as_slice().to_vec();
+    (token, hash)
+}
+
+#[cfg(test)]
+mod tests {
+    use axum::http::StatusCode;
+
+    use crate::{
+        api::ErrorResponse,
+        server::token::{
+            create::{CreateTokenRequest, CreateTokenResponse},
+            list::ListTokensResponse,
+            rotate::RotateTokenResponse,
+        },
+        testing::{AttuneTestServer, AttuneTestServerConfig, MIGRATOR},
+    };
+
+    #[test_log::test(sqlx::test(migrator = "MIGRATOR"))]
+    async fn token_lifecycle(pool: sqlx::PgPool) {
+        let server = AttuneTestServer::new(AttuneTestServerConfig {
+            db: pool,
+            s3_bucket_name: None,
+            http_api_token: None,
+        })
+        .await;
+        let (_tenant_id, api_token) = server.create_test_tenant("token_lifecycle").await;
+
+        // Create a new token, and check that it authenticates as the same
+        // tenant.
+        let created = server
+            .http
+            .post("/api/v0/tokens")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .json(&CreateTokenRequest {
+                name: String::from("ci"),
+            })
+            .await
+            .json::<CreateTokenResponse>();
+        let listed = server
+            .http
+            .get("/api/v0/tokens")
+            .add_header("authorization", format!("Bearer {}", created.token))
+            .await
+            .json::<ListTokensResponse>();
+        assert_eq!(listed.tokens.len(), 2);
+        let ci = listed
+            .tokens
+            .iter()
+            .find(|token| token.id == created.id)
+            .expect("created token is listed");
+        assert_eq!(ci.name, "ci");
+        assert!(ci.last_used_at.is_some(), "token use should be recorded");
+
+        // Rotating the token invalidates the old plaintext.
+        let rotated = server
+            .http
+            .post(&format!("/api/v0/tokens/{}/rotate", created.id))
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .await
+            .json::<RotateTokenResponse>();
+        assert_ne!(rotated.token, created.token);
+        server
+            .http
+            .get("/api/v0/tokens")
+            .add_header("authorization", format!("Bearer {}", created.token))
+            .expect_failure()
+            .await
+            .assert_status(StatusCode::UNAUTHORIZED);
+
+        // Revoke the original token using the rotated one.
+        let original = listed
+            .tokens
+            .iter()
+            .find(|token| token.id != created.id)
+            .unwrap();
+        server
+            .http
+            .delete(&format!("/api/v0/tokens/{}", original.id))
+            .add_header("authorization", format!("Bearer {}", rotated.token))
+            .await
+            .assert_status_ok();
+        server
+            .http
+            .get("/api/v0/tokens")
+            .add_header("authorization", format!("Bearer {api_token}"))
+            .expect_failure()
+            .await
+            .assert_status(StatusCode::UNAUTHORIZED);
+
+        // The last remaining token can't be revoked.
+        let res = server
+            .http
+            .delete(&format!("/api/v0/tokens/{}", rotated.id))
+            .add_header("authorization", format!("Bearer {}", rotated.token))
+            .expect_failure()
+            .await;
+        res.assert_status(StatusCode::BAD_REQUEST);
+        assert_eq!(res.json::<ErrorResponse>().error, "LAST_TOKEN");
+    }
+}
@@ -0,0 +1,73 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::ServerState,
+};
+
+#[derive(Serialize, Deserialize, JsonSchema, Debug)]
+pub struct RevokeTokenResponse {}
+
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path(token_id): Path<i64>,
+) -> Result<Json<RevokeTokenResponse>, ErrorResponse> {
+    let mut tx = state.db.begin().await.unwrap();
+    sqlx::query!("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
+        .execute(&mut *tx)
+        .await
+        .map_err(ErrorResponse::from)?;
+
+    let deleted = sqlx::query!(
+        r#"
+        DELETE FROM attune_tenant_api_token
+        WHERE tenant_id = $1 AND id = $2
+        "#,
+        tenant_id.0,
+        token_id,
+    )
+    .execute(&mut *tx)
+    .await
+    .map_err(ErrorResponse::from)?;
+    if deleted.rows_affected() == 0 {
+        return Err(ErrorResponse::new(
+            StatusCode::NOT_FOUND,
+            "TOKEN_NOT_FOUND".to_string(),
+            "token not found".to_string(),
+        ));
+    }
+
+    // Revoking the last token would leave the tenant with no way to
+    // authenticate, so we don't allow it.
+    let remaining = sqlx::query!(
+        r#"
+        SELECT COUNT(*) AS "count!: i64"
+        FROM attune_tenant_api_token
+        WHERE tenant_id = $1
+        "#,
+        tenant_id.0,
+    )
+    .fetch_one(&mut *tx)
+    .await
+    .map_err(ErrorResponse::from)?;
+    if remaining.count == 0 {
+        return Err(ErrorResponse::new(
+            StatusCode::BAD_REQUEST,
+            "LAST_TOKEN".to_string(),
+            "cannot revoke the only remaining token; create a new token first".to_string(),
+        ));
+    }
+
+    tx.commit().await.map_err(ErrorResponse::from)?;
+    Ok(Json(RevokeTokenResponse {}))
+}
@@ -0,0 +1,57 @@
+use axum::{
+    Json,
+    extract::{Path, State},
+    http::StatusCode,
+};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use tracing::instrument;
+
+use crate::{
+    api::{ErrorResponse, TenantID},
+    server::{ServerState, token::generate_token},
+};
+
+#[derive(Serialize, Deserialize, JsonSchema)]
+pub struct RotateTokenResponse {
+    pub id: i64,
+    pub name: String,
+    /// The new plaintext token. The old token stops working immediately.
+    pub token: String,
+}
+
+#[axum::debug_handler]
+#[instrument(skip(state))]
+pub async fn handler(
+    State(state): State<ServerState>,
+    tenant_id: TenantID,
+    Path(token_id): Path<i64>,
+) -> Result<Json<RotateTokenResponse>, ErrorResponse> {
+    let (token, hash) = generate_token();
+    let rotated = sqlx::query!(
+        r#"
+        UPDATE attune_tenant_api_token
+        SET token = $3, last_used_at = NULL, updated_at = NOW()
+        WHERE tenant_id = $1 AND id = $2
+        RETURNING name
+        "#,
+        tenant_id.0,
+        token_id,
+        hash,
+    )
+    .fetch_optional(&state.db)
+    .await
+    .map_err(ErrorResponse::from)?;
+    match rotated {
+        Some(rotated) => Ok(Json(RotateTokenResponse {
+            id: token_id,
+            name: rotated.name,
+            token,
+        })),
+        None => Err(ErrorResponse::new(
+            StatusCode::NOT_FOUND,
+            "TOKEN_NOT_FOUND".to_string(),
+            "token not found".to_string(),
+        )),
+    }
+}
@@ -6,3 +6,127 @@ pub const TEST_PACKAGE_AMD64: &[u8] =
     include_bytes!("../../../../scripts/fixtures/attune-test-package_2.0.0_linux_amd64.deb");
 pub const TEST_PACKAGE_ARM64: &[u8] =
     include_bytes!("../../../../scripts/fixtures/attune-test-package_2.0.0_linux_arm64.deb");
+
+/// Build a small Debian package, `attune-test-compression`, whose control and
+/// data archives are named with `extension`, like `control.tar.zst` for
+/// `"zst"`.
+///
+/// The archives are gzipped for `"gz"` and stored in zstd frames for `"zst"`.
+/// For any other extension they're left uncompressed, which is enough to test
+/// how packages with unsupported compressions are refused.
+pub fn build_test_package(extension: &str) -> Vec<u8> {
+    let control = tar(&[(
+        "./control",
+        concat!(
+            "Package: attune-test-compression\n",
+            "Version: 1.0.0\n",
+            "Architecture: amd64\n",
+            "Maintainer: Attune <attune@example.com>\n",
+            "Description: A test package\n",
+        )
+        .as_bytes(),
+    )]);
+    let data = tar(&[(
+        "./usr/share/doc/attune-test-compression/copyright",
+        "Public domain.\n".as_bytes(),
+    )]);
+    let compress = |archive: Vec<u8>| match extension {
+        "gz" => crate::apt::gzip(&archive),
+        "zst" => zstd_raw(&archive),
+        _ => archive,
+    };
+    let suffix = match extension {
+        "" => String::new(),
+        extension => format!(".{extension}"),
+    };
+    let control_name = format!("control.tar{suffix}");
+    let data_name = format!("data.tar{suffix}");
+    ar(&[
+        ("debian-binary", b"2.0\n".to_vec()),
+        (control_name.as_str(), compress(control)),
+        (data_name.as_str(), compress(data)),
+    ])
+}
+
+/// Add a `debsig` origin signature to a package, as its `_gpgorigin` member.
+pub fn with_origin_signature(package: &[u8], signature: &[u8]) -> Vec<u8> {
+    let member = ar(&[("_gpgorigin", signature.to_vec())]);
+    // Members are appended after the archive's magic string.
+    [package, &member[b"!<arch>\n".len()..]].concat()
+}
+
+/// Build a tar archive of regular files.
+fn tar(files: &[(&str, &[u8])]) -> Vec<u8> {
+    let mut archive = Vec::new();
+    for (path, contents) in files {
+        let mut header = [0u8; 512];
+        let mut field = |offset: usize, value: &[u8]| {
+            header[offset..offset + value.len()].copy_from_slice(value);
+        };
+        field(0, path.as_bytes());
+        field(100, b"0000644\0");
+        field(108, b"0000000\0");
+        field(116, b"0000000\0");
+        field(124, format!("{:011o}\0", contents.len()).as_bytes());
+        field(136, b"00000000000\0");
+        field(156, b"0");
+        field(257, b"ustar\000");
+        // The checksum is computed with its own field set to spaces.
+        header[148..156].fill(b' ');
+        let checksum = header.iter().map(|&byte| u32::from(byte)).sum::<u32>();
+        header[148..156].copy_from_slice(format!("{checksum:06o}\0 ").as_bytes());
+        archive.extend_from_slice(&header);
+        archive.extend_from_slice(contents);
+        archive.resize(archive.len().next_multiple_of(512), 0);
+    }
+    // Archives end with two empty blocks.
+    archive.resize(archive.len() + 1024, 0);
+    archive
+}
+
+/// Build an `ar` archive in the format that Debian packages use.
+fn ar(members: &[(&str, Vec<u8>)]) -> Vec<u8> {
+    let mut archive = b"!<arch>\n".to_vec();
+    for (name, contents) in members {
+        let header = format!(
+            "{name:<16}{:<12}{:<6}{:<6}{:<8}{:<10}`\n",
+            0,
+            0,
+            0,
+            100644,
+            contents.len()
+        );
+        archive.extend_from_slice(header.as_bytes());
+        archive.extend_from_slice(contents);
+        // Members are padded to an even number of bytes.
+        if contents.len() % 2 == 1 {
+            archive.push(b'\n');
+        }
+    }
+    archive
+}
+
+/// Store `data` in a zstd frame of uncompressed blocks, which any zstd
+/// decoder reads, without depending on a zstd encoder.
+///
+/// See https://datatracker.ietf.org/doc/html/rfc8878#section-3.1.1.
+fn zstd_raw(data: &[u8]) -> Vec<u8> {
+    const MAX_BLOCK_SIZE: usize = 128 * 1024;
+    // The magic number, then a frame header with no content size, checksum,
+    // or dictionary, and a window descriptor for a 128 KiB window.
+    let mut frame = vec![0x28, 0xb5, 0x2f, 0xfd, 0x00, 7 << 3];
+    let mut blocks = data.chunks(MAX_BLOCK_SIZE).peekable();
+    if blocks.peek().is_none() {
+        // The last block flag, in an empty raw block.
+        frame.extend_from_slice(&[1, 0, 0]);
+    }
+    while let Some(block) = blocks.next() {
+        // Block headers are 3 little-endian bytes: the last block flag, the
+        // block type (0 for raw), and the block size.
+        let last = u32::from(blocks.peek().is_none());
+        let header = last | ((block.len() as u32) << 3);
+        frame.extend_from_slice(&header.to_le_bytes()[..3]);
+        frame.extend_from_slice(block);
+    }
+    frame
+}
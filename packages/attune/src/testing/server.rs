@@ -4,7 +4,7 @@ use reqwest::Url;
 use sha2::{Digest as _, Sha256};
 use uuid::{ContextV7, Timestamp};
 
-use crate::api::TenantID;
+use crate::{api::TenantID, server::pkg::fetch::FetchConfig};
 
 /// A test server for Attune, and all its parts for manual validation/testing.
 pub struct AttuneTestServer {
@@ -41,6 +41,12 @@ pub struct AttuneTestServerConfig {
 impl AttuneTestServer {
     /// Create a new test server.
     pub async fn new(config: AttuneTestServerConfig) -> Self {
+        Self::with_fetch_config(config, FetchConfig::default()).await
+    }
+
+    /// Create a new test server that fetches packages by URL as configured by
+    /// `fetch`.
+    pub async fn with_fetch_config(config: AttuneTestServerConfig, fetch: FetchConfig) -> Self {
         let awsconfig = aws_config::defaults(BehaviorVersion::latest()).load().await;
         let s3config = aws_sdk_s3::config::Builder::from(&awsconfig).build();
         let s3 = aws_sdk_s3::Client::from_conf(s3config);
@@ -57,6 +63,7 @@ impl AttuneTestServer {
                 db: config.db.clone(),
                 s3: s3.clone(),
                 s3_bucket_name: s3_bucket_name.clone(),
+                fetch,
             },
             // TODO: Migrate all tests to use `create_test_tenant`, and then set
             // this to `None` to remove the footgun.